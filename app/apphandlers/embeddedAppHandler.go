@@ -90,7 +90,12 @@ func (embedded *EmbeddedApp) StartRudderCore(ctx context.Context, options *app.O
 
 		if config.GetBool("EnableMultitenancy", false) {
 			tenantRouterDB = &jobsdb.MultiTenantHandleT{HandleT: &routerDB}
-			multitenantStats = multitenant.NewStats(tenantRouterDB)
+			tenantStats := multitenant.NewStats(tenantRouterDB)
+			multitenantStats = tenantStats
+			g.Go(misc.WithBugsnag(func() error {
+				tenantStats.StatLoop(ctx)
+				return nil
+			}))
 		}
 	}
 
@@ -110,7 +110,12 @@ func (processor *ProcessorApp) StartRudderCore(ctx context.Context, options *app
 
 		if config.GetBool("EnableMultitenancy", false) {
 			tenantRouterDB = &jobsdb.MultiTenantHandleT{HandleT: &routerDB}
-			multitenantStats = multitenant.NewStats(tenantRouterDB)
+			tenantStats := multitenant.NewStats(tenantRouterDB)
+			multitenantStats = tenantStats
+			g.Go(misc.WithBugsnag(func() error {
+				tenantStats.StatLoop(ctx)
+				return nil
+			}))
 		}
 	}
 
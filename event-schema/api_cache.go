@@ -0,0 +1,60 @@
+package event_schema
+
+import (
+	"sync"
+	"time"
+)
+
+// apiCacheEntryT is a value cached by apiCacheT along with its expiry
+type apiCacheEntryT struct {
+	value    interface{}
+	expireAt time.Time
+}
+
+// apiCacheT caches results of the queries served by the event-schema APIs,
+// so that frequent polling of these APIs doesn't hit postgres every time.
+type apiCacheT struct {
+	lock    sync.RWMutex
+	entries map[string]*apiCacheEntryT
+}
+
+// get returns the value cached against key, if it exists and hasn't expired yet
+func (cache *apiCacheT) get(key string) (interface{}, bool) {
+	cache.lock.RLock()
+	defer cache.lock.RUnlock()
+
+	entry, ok := cache.entries[key]
+	if !ok || time.Now().After(entry.expireAt) {
+		return nil, false
+	}
+	return entry.value, true
+}
+
+// set caches value against key for ttl. A non-positive ttl disables caching.
+func (cache *apiCacheT) set(key string, value interface{}, ttl time.Duration) {
+	if ttl <= 0 {
+		return
+	}
+
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+
+	if cache.entries == nil {
+		cache.entries = make(map[string]*apiCacheEntryT)
+	}
+	now := time.Now()
+	for k, entry := range cache.entries {
+		if now.After(entry.expireAt) {
+			delete(cache.entries, k)
+		}
+	}
+	cache.entries[key] = &apiCacheEntryT{value: value, expireAt: now.Add(ttl)}
+}
+
+// invalidate removes all the cached values
+func (cache *apiCacheT) invalidate() {
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+
+	cache.entries = nil
+}
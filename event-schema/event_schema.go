@@ -26,7 +26,9 @@ Table: schema_versions
 package event_schema
 
 import (
+	"crypto/sha256"
 	"database/sql"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"reflect"
@@ -109,6 +111,9 @@ type EventSchemaManagerT struct {
 	eventModelLock       sync.RWMutex
 	schemaVersionLock    sync.RWMutex
 	disableInMemoryCache bool
+	apiCache             apiCacheT
+	//querySemaphore bounds the number of API requests querying the db concurrently
+	querySemaphore chan struct{}
 }
 
 type OffloadedModelT struct {
@@ -130,6 +135,8 @@ var (
 	flushInterval                   time.Duration
 	adminUser                       string
 	adminPassword                   string
+	adminCredentials                map[string]string
+	writeKeyAllowlist               map[string]map[string]bool
 	reservoirSampleSize             int
 	eventSchemaChannel              chan *GatewayEventBatchT
 	updatedEventModels              map[string]*EventModelT
@@ -148,6 +155,8 @@ var (
 	offloadLoopInterval             time.Duration
 	offloadThreshold                time.Duration
 	areEventSchemasPopulated        bool
+	apiCacheTTL                     time.Duration
+	maxConcurrentQueries            int
 )
 
 const EVENT_MODELS_TABLE = "event_models"
@@ -172,6 +181,9 @@ type EventPayloadT struct {
 func loadConfig() {
 	adminUser = config.GetEnv("RUDDER_ADMIN_USER", "rudder")
 	adminPassword = config.GetEnv("RUDDER_ADMIN_PASSWORD", "rudderstack")
+	adminCredentials = parseAdminCredentials(config.GetEnv("RUDDER_ADMIN_CREDENTIALS", ""))
+	adminCredentials[adminUser] = adminPassword
+	writeKeyAllowlist = parseWriteKeyAllowlist(config.GetStringSlice("EventSchemas.writeKeyAllowlist", nil))
 	noOfWorkers = config.GetInt("EventSchemas.noOfWorkers", 128)
 	config.RegisterDurationConfigVariable(time.Duration(240), &flushInterval, true, time.Second, []string{"EventSchemas.syncInterval", "EventSchemas.syncIntervalInS"}...)
 
@@ -181,15 +193,59 @@ func loadConfig() {
 	config.RegisterBoolConfigVariable(false, &shouldCaptureNilAsUnknowns, true, "EventSchemas.captureUnknowns")
 	config.RegisterDurationConfigVariable(time.Duration(60), &offloadLoopInterval, true, time.Second, []string{"EventSchemas.offloadLoopInterval"}...)
 	config.RegisterDurationConfigVariable(time.Duration(1800), &offloadThreshold, true, time.Second, []string{"EventSchemas.offloadThreshold"}...)
+	config.RegisterDurationConfigVariable(time.Duration(30), &apiCacheTTL, true, time.Second, []string{"EventSchemas.cacheTTL"}...)
+	config.RegisterIntConfigVariable(10, &maxConcurrentQueries, false, 1, "EventSchemas.maxConcurrentQueries")
 
 	if adminPassword == "rudderstack" {
 		fmt.Println("[EventSchemas] You are using default password. Please change it by setting env variable RUDDER_ADMIN_PASSWORD")
 	}
 }
 
+// parseAdminCredentials parses additional admin credentials given as comma separated user:password pairs
+func parseAdminCredentials(credentials string) map[string]string {
+	parsed := make(map[string]string)
+	for _, credential := range strings.Split(credentials, ",") {
+		credential = strings.TrimSpace(credential)
+		if credential == "" {
+			continue
+		}
+		user, password := credential, ""
+		if idx := strings.Index(credential, ":"); idx >= 0 {
+			user, password = credential[:idx], credential[idx+1:]
+		}
+		if user == "" || password == "" {
+			pkgLogger.Warn("Ignoring invalid admin credential in RUDDER_ADMIN_CREDENTIALS. Expected format is user:password")
+			continue
+		}
+		parsed[user] = password
+	}
+	return parsed
+}
+
+// parseWriteKeyAllowlist parses the write keys the admin users are allowed to query, given as user:writeKey entries.
+// Admin users without any entry aren't restricted
+func parseWriteKeyAllowlist(entries []string) map[string]map[string]bool {
+	allowlist := make(map[string]map[string]bool)
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		idx := strings.Index(entry, ":")
+		if idx <= 0 || idx == len(entry)-1 {
+			pkgLogger.Warnf("Ignoring invalid write key allowlist entry %q. Expected format is user:writeKey", entry)
+			continue
+		}
+		user, writeKey := entry[:idx], entry[idx+1:]
+		if allowlist[user] == nil {
+			allowlist[user] = make(map[string]bool)
+		}
+		allowlist[user][writeKey] = true
+	}
+	return allowlist
+}
+
 func Init2() {
-	loadConfig()
+	//the logger is needed while loading the config, to warn about the invalid entries
 	pkgLogger = logger.NewLogger().Child("event-schema")
+	loadConfig()
 }
 
 //RecordEventSchema : Records event schema for every event in the batch
@@ -733,6 +789,11 @@ func (manager *EventSchemaManagerT) flushEventSchemas() {
 
 		flushDBHandle.Close()
 
+		// Archived event models and schema versions shouldn't be served from the api cache anymore
+		if len(toDeleteEventModelIDs) > 0 || len(toDeleteSchemaVersionIDs) > 0 {
+			manager.apiCache.invalidate()
+		}
+
 		updatedEventModels = make(map[string]*EventModelT)
 		updatedSchemaVersions = make(map[string]*SchemaVersionT)
 		toDeleteEventModelIDs = []string{}
@@ -1026,6 +1087,12 @@ func getSchema(flattenedEvent map[string]interface{}) map[string]string {
 }
 
 func getSchemaHash(schema map[string]string) string {
+	schemaHash := misc.GetMD5Hash(canonicalSchema(schema))
+	return schemaHash
+}
+
+//canonicalSchema returns the key:type pairs of the schema sorted by key, so that it doesn't depend on the order of the keys
+func canonicalSchema(schema map[string]string) string {
 	keys := make([]string, 0, len(schema))
 	for k := range schema {
 		keys = append(keys, k)
@@ -1038,9 +1105,19 @@ func getSchemaHash(schema map[string]string) string {
 		sb.WriteString(schema[k])
 		sb.WriteString(",")
 	}
+	return sb.String()
+}
 
-	schemaHash := misc.GetMD5Hash(sb.String())
-	return schemaHash
+//CanonicalHash returns the SHA-256 hex digest of the sorted key:type pairs of the schema version,
+//which is the same for versions having the same keys and types regardless of their order.
+//It returns an empty string if the schema isn't a valid key to type json map
+func (sv *SchemaVersionT) CanonicalHash() string {
+	var schema map[string]string
+	if err := json.Unmarshal(sv.Schema, &schema); err != nil {
+		return ""
+	}
+	hash := sha256.Sum256([]byte(canonicalSchema(schema)))
+	return hex.EncodeToString(hash[:])
 }
 
 func computeFrequencies(flattenedEvent map[string]interface{}, schemaHash string) {
@@ -1056,6 +1133,7 @@ func (manager *EventSchemaManagerT) Setup() {
 	pkgLogger.Info("[EventSchemas] Setting up eventSchemas...")
 	// Clean this up
 	manager.dbHandle = createDBConnection()
+	manager.querySemaphore = make(chan struct{}, maxConcurrentQueries)
 
 	// Following data structures store events and schemas since last flush
 	updatedEventModels = make(map[string]*EventModelT)
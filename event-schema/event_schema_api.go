@@ -5,29 +5,162 @@
 package event_schema
 
 import (
+	"crypto/subtle"
+	"database/sql"
+	"encoding/csv"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"hash/fnv"
 	"net/http"
+	"sort"
 
 	uuid "github.com/gofrs/uuid"
 	"github.com/gorilla/mux"
+	"github.com/lib/pq"
 	"github.com/rudderlabs/rudder-server/gateway/response"
 	"github.com/rudderlabs/rudder-server/utils/misc"
 	"strconv"
 	"strings"
 )
 
+// errNotFound is wrapped by the fetch helpers when no row exists for the requested uuid
+var errNotFound = errors.New("not found")
+
+// errForbidden is wrapped by authorizeWriteKey when the admin user isn't allowed to query the write key
+var errForbidden = errors.New("forbidden")
+
 func handleBasicAuth(r *http.Request) error {
 	username, password, ok := r.BasicAuth()
 	if !ok {
 		return fmt.Errorf("Basic auth credentials missing")
 	}
-	if username != adminUser || password != adminPassword {
+	if !isValidAdminCredential(username, password) {
 		return fmt.Errorf("Invalid admin credentials")
 	}
+	pkgLogger.Infof("Admin user %s authenticated for %s %s", username, r.Method, r.URL.Path)
+	return nil
+}
+
+// authorizeWriteKey checks that the authenticated admin user is allowed to query writeKey as per EventSchemas.writeKeyAllowlist.
+// A restricted user has to give the write key, since an unfiltered query would return the event models of all the write keys
+func authorizeWriteKey(r *http.Request, writeKey string) error {
+	username, _, _ := r.BasicAuth()
+	allowedWriteKeys, restricted := writeKeyAllowlist[username]
+	if !restricted {
+		return nil
+	}
+	if writeKey == "" {
+		return fmt.Errorf("WriteKey param is required for admin user %s: %w", username, errForbidden)
+	}
+	if !allowedWriteKeys[writeKey] {
+		return fmt.Errorf("Admin user %s is not allowed to query write key %s: %w", username, writeKey, errForbidden)
+	}
 	return nil
 }
 
+// isRestrictedAdmin tells if the authenticated admin user can only query the write keys of the allowlist
+func isRestrictedAdmin(r *http.Request) bool {
+	username, _, _ := r.BasicAuth()
+	_, restricted := writeKeyAllowlist[username]
+	return restricted
+}
+
+// authorizeEventModel checks with authorizeWriteKey that the authenticated admin user is allowed to query the write key of the event model.
+// The write key is looked up only for the restricted admin users, wrapping errNotFound if the event model doesn't exist
+func (manager *EventSchemaManagerT) authorizeEventModel(r *http.Request, eventModelID string) error {
+	if !isRestrictedAdmin(r) {
+		return nil
+	}
+	var writeKey string
+	err := manager.dbHandle.QueryRow(fmt.Sprintf(`SELECT write_key FROM %s WHERE uuid = $1`, EVENT_MODELS_TABLE), eventModelID).Scan(&writeKey)
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("No eventModels found for given eventModelID : %s: %w", eventModelID, errNotFound)
+	}
+	if err != nil {
+		return err
+	}
+	return authorizeWriteKey(r, writeKey)
+}
+
+// authorizeSchemaVersion is authorizeEventModel for the event model of the schema version
+func (manager *EventSchemaManagerT) authorizeSchemaVersion(r *http.Request, versionID string) error {
+	if !isRestrictedAdmin(r) {
+		return nil
+	}
+	var writeKey string
+	err := manager.dbHandle.QueryRow(fmt.Sprintf(`SELECT event_models.write_key FROM %[1]s INNER JOIN %[2]s ON %[2]s.uuid = %[1]s.event_model_id WHERE %[1]s.uuid = $1`,
+		SCHEMA_VERSIONS_TABLE, EVENT_MODELS_TABLE), versionID).Scan(&writeKey)
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("No schemaVersion found for given versionID : %s: %w", versionID, errNotFound)
+	}
+	if err != nil {
+		return err
+	}
+	return authorizeWriteKey(r, writeKey)
+}
+
+// isValidAdminCredential checks the given credential against all the configured admin credentials
+// in constant time, so that the response time doesn't leak valid usernames or passwords
+func isValidAdminCredential(username, password string) bool {
+	valid := 0
+	for adminUsername, adminPassword := range adminCredentials {
+		userMatch := subtle.ConstantTimeCompare([]byte(username), []byte(adminUsername))
+		passwordMatch := subtle.ConstantTimeCompare([]byte(password), []byte(adminPassword))
+		valid |= userMatch & passwordMatch
+	}
+	return valid == 1
+}
+
+// handleFetchError responds with 404 if the requested resource doesn't exist and with 403 if the admin user isn't allowed to query it.
+// Any other error is logged with a logID and responded with 500.
+func handleFetchError(w http.ResponseWriter, err error) {
+	if errors.Is(err, errNotFound) {
+		http.Error(w, response.MakeResponse(err.Error()), 404)
+		return
+	}
+	if errors.Is(err, errForbidden) {
+		http.Error(w, response.MakeResponse(err.Error()), http.StatusForbidden)
+		return
+	}
+	logID := uuid.Must(uuid.NewV4()).String()
+	pkgLogger.Errorf("logID : %s, err: %s", logID, err.Error())
+	http.Error(w, response.MakeResponse(fmt.Sprintf("Internal Error: An error has been logged with logID : %s", logID)), 500)
+}
+
+// writeWithETag writes the JSON response along with an ETag header, a cheap fnv hash of it,
+// so that pollers can send it back in If-None-Match and get a 304 without the body if nothing changed.
+func writeWithETag(w http.ResponseWriter, r *http.Request, responseJSON []byte) {
+	hash := fnv.New64a()
+	hash.Write(responseJSON)
+	etag := fmt.Sprintf(`"%x"`, hash.Sum64())
+	w.Header().Set("ETag", etag)
+
+	for _, match := range strings.Split(r.Header.Get("If-None-Match"), ",") {
+		match = strings.TrimPrefix(strings.TrimSpace(match), "W/")
+		if match == etag || match == "*" {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+	}
+	w.Write(responseJSON)
+}
+
+//acquireQuerySlot takes one of the maxConcurrentQueries slots shared by the API handlers, so that a burst of
+//schema requests can't exhaust the db connections used by ingestion. If none is free, it responds with 429 and returns false.
+func (manager *EventSchemaManagerT) acquireQuerySlot(w http.ResponseWriter) (release func(), ok bool) {
+	if manager.querySemaphore == nil {
+		return func() {}, true
+	}
+	select {
+	case manager.querySemaphore <- struct{}{}:
+		return func() { <-manager.querySemaphore }, true
+	default:
+		http.Error(w, response.MakeResponse("Too many concurrent event schema requests. Please retry later"), http.StatusTooManyRequests)
+		return nil, false
+	}
+}
+
 func (manager *EventSchemaManagerT) GetEventModels(w http.ResponseWriter, r *http.Request) {
 	err := handleBasicAuth(r)
 	if err != nil {
@@ -35,18 +168,28 @@ func (manager *EventSchemaManagerT) GetEventModels(w http.ResponseWriter, r *htt
 		return
 	}
 
+	release, ok := manager.acquireQuerySlot(w)
+	if !ok {
+		return
+	}
+	defer release()
+
 	if r.Method != http.MethodGet {
 		http.Error(w, response.MakeResponse("Only HTTP GET method is supported"), 400)
 		return
 	}
 
-	writeKeys, ok := r.URL.Query()["WriteKey"]
-	writeKey := ""
-	if ok && writeKeys[0] != "" {
-		writeKey = writeKeys[0]
+	filters := eventModelFilters(r)
+	if err := authorizeWriteKey(r, filters["write_key"]); err != nil {
+		http.Error(w, response.MakeResponse(err.Error()), http.StatusForbidden)
+		return
 	}
 
-	eventTypes := manager.fetchEventModelsByWriteKey(writeKey)
+	eventTypes, err := manager.getEventModels(filters, bypassAPICache(r))
+	if err != nil {
+		handleFetchError(w, err)
+		return
+	}
 
 	eventTypesJSON, err := json.Marshal(eventTypes)
 	if err != nil {
@@ -54,7 +197,114 @@ func (manager *EventSchemaManagerT) GetEventModels(w http.ResponseWriter, r *htt
 		return
 	}
 
-	w.Write(eventTypesJSON)
+	writeWithETag(w, r, eventTypesJSON)
+}
+
+// GetEventModel returns the event model with the uuid given in the EventID path var
+func (manager *EventSchemaManagerT) GetEventModel(w http.ResponseWriter, r *http.Request) {
+	err := handleBasicAuth(r)
+	if err != nil {
+		http.Error(w, response.MakeResponse(err.Error()), 400)
+		return
+	}
+
+	release, ok := manager.acquireQuerySlot(w)
+	if !ok {
+		return
+	}
+	defer release()
+
+	if r.Method != http.MethodGet {
+		http.Error(w, response.MakeResponse("Only HTTP GET method is supported"), 400)
+		return
+	}
+
+	vars := mux.Vars(r)
+	eventID, ok := vars["EventID"]
+	if !ok || eventID == "" {
+		http.Error(w, response.MakeResponse("Mandatory field: EventID missing"), 400)
+		return
+	}
+
+	eventModel, err := manager.fetchEventModelByID(eventID)
+	if err == nil {
+		err = authorizeWriteKey(r, eventModel.WriteKey)
+	}
+	if err != nil {
+		handleFetchError(w, err)
+		return
+	}
+
+	eventModelJSON, err := json.Marshal(eventModel)
+	if err != nil {
+		http.Error(w, response.MakeResponse("Internal Error: Failed to Marshal event model"), 500)
+		return
+	}
+
+	w.Write(eventModelJSON)
+}
+
+// eventModelsByIDsRequestT is the JSON body accepted by GetEventModelsByIDs
+type eventModelsByIDsRequestT struct {
+	EventIDs []string
+}
+
+// GetEventModelsByIDs returns a map of eventID to event model for the uuids given either as
+// repeated EventID query params or as the EventIDs of a JSON body, fetched in a single query.
+// The ids without an event model are absent from the map.
+func (manager *EventSchemaManagerT) GetEventModelsByIDs(w http.ResponseWriter, r *http.Request) {
+	err := handleBasicAuth(r)
+	if err != nil {
+		http.Error(w, response.MakeResponse(err.Error()), 400)
+		return
+	}
+
+	release, ok := manager.acquireQuerySlot(w)
+	if !ok {
+		return
+	}
+	defer release()
+
+	var eventIDs []string
+	switch r.Method {
+	case http.MethodGet:
+		eventIDs = r.URL.Query()["EventID"]
+	case http.MethodPost:
+		var req eventModelsByIDsRequestT
+		err := json.NewDecoder(r.Body).Decode(&req)
+		if err != nil {
+			http.Error(w, response.MakeResponse("Invalid request body: "+err.Error()), 400)
+			return
+		}
+		eventIDs = req.EventIDs
+	default:
+		http.Error(w, response.MakeResponse("Only HTTP GET and POST methods are supported"), 400)
+		return
+	}
+	if len(eventIDs) == 0 {
+		http.Error(w, response.MakeResponse("Mandatory field: EventID missing"), 400)
+		return
+	}
+
+	eventModels, err := manager.fetchEventModelsByIDs(eventIDs)
+	if err != nil {
+		handleFetchError(w, err)
+		return
+	}
+	for _, eventModel := range eventModels {
+		if err := authorizeWriteKey(r, eventModel.WriteKey); err != nil {
+			handleFetchError(w, err)
+			return
+		}
+	}
+
+	eventModelsJSON, err := json.Marshal(eventModels)
+	if err != nil {
+		http.Error(w, response.MakeResponse("Internal Error: Failed to Marshal event models"), 500)
+		return
+	}
+
+	w.Write(eventModelsJSON)
 }
 
 func (manager *EventSchemaManagerT) GetJsonSchemas(w http.ResponseWriter, r *http.Request) {
@@ -64,18 +314,28 @@ func (manager *EventSchemaManagerT) GetJsonSchemas(w http.ResponseWriter, r *htt
 		return
 	}
 
+	release, ok := manager.acquireQuerySlot(w)
+	if !ok {
+		return
+	}
+	defer release()
+
 	if r.Method != http.MethodGet {
 		http.Error(w, response.MakeResponse("Only HTTP GET method is supported"), 400)
 		return
 	}
 
-	writeKeys, ok := r.URL.Query()["WriteKey"]
-	writeKey := ""
-	if ok && writeKeys[0] != "" {
-		writeKey = writeKeys[0]
+	filters := eventModelFilters(r)
+	if err := authorizeWriteKey(r, filters["write_key"]); err != nil {
+		http.Error(w, response.MakeResponse(err.Error()), http.StatusForbidden)
+		return
 	}
 
-	eventModels := manager.fetchEventModelsByWriteKey(writeKey)
+	eventModels, err := manager.getEventModels(filters, bypassAPICache(r))
+	if err != nil {
+		handleFetchError(w, err)
+		return
+	}
 	if len(eventModels) == 0 {
 		http.Error(w, response.MakeResponse("No event models exists to create a tracking plan."), 404)
 		return
@@ -287,6 +547,12 @@ func (manager *EventSchemaManagerT) GetEventVersions(w http.ResponseWriter, r *h
 		return
 	}
 
+	release, ok := manager.acquireQuerySlot(w)
+	if !ok {
+		return
+	}
+	defer release()
+
 	if r.Method != http.MethodGet {
 		http.Error(w, response.MakeResponse("Only HTTP GET method is supported"), 400)
 		return
@@ -298,8 +564,16 @@ func (manager *EventSchemaManagerT) GetEventVersions(w http.ResponseWriter, r *h
 		return
 	}
 	eventID := eventIDs[0]
+	if err := manager.authorizeEventModel(r, eventID); err != nil {
+		handleFetchError(w, err)
+		return
+	}
 
-	schemaVersions := manager.fetchSchemaVersionsByEventID(eventID)
+	schemaVersions, err := manager.getSchemaVersionsByEventID(eventID, bypassAPICache(r))
+	if err != nil {
+		handleFetchError(w, err)
+		return
+	}
 	schemaVersionsJSON, err := json.Marshal(schemaVersions)
 	if err != nil {
 		http.Error(w, response.MakeResponse("Internal Error: Failed to Marshal event types"), 500)
@@ -309,6 +583,70 @@ func (manager *EventSchemaManagerT) GetEventVersions(w http.ResponseWriter, r *h
 	w.Write(schemaVersionsJSON)
 }
 
+// ExportSchemaVersions streams all the schema versions of an event model as newline delimited json.
+// Rows are written as they are read from the db, so that the versions aren't buffered in memory.
+func (manager *EventSchemaManagerT) ExportSchemaVersions(w http.ResponseWriter, r *http.Request) {
+	err := handleBasicAuth(r)
+	if err != nil {
+		http.Error(w, response.MakeResponse(err.Error()), 400)
+		return
+	}
+
+	release, ok := manager.acquireQuerySlot(w)
+	if !ok {
+		return
+	}
+	defer release()
+
+	if r.Method != http.MethodGet {
+		http.Error(w, response.MakeResponse("Only HTTP GET method is supported"), 400)
+		return
+	}
+
+	eventIDs, ok := r.URL.Query()["EventID"]
+	if !ok || eventIDs[0] == "" {
+		http.Error(w, response.MakeResponse("Mandatory field: EventID missing"), 400)
+		return
+	}
+	eventID := eventIDs[0]
+	if err := manager.authorizeEventModel(r, eventID); err != nil {
+		handleFetchError(w, err)
+		return
+	}
+
+	schemaVersionsSelectSQL := fmt.Sprintf(`SELECT id, uuid, event_model_id, schema, first_seen, last_seen, total_count FROM %s WHERE event_model_id = $1`, SCHEMA_VERSIONS_TABLE)
+	rows, err := manager.dbHandle.QueryContext(r.Context(), schemaVersionsSelectSQL, eventID)
+	if err != nil {
+		handleFetchError(w, err)
+		return
+	}
+	defer rows.Close()
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	flusher, _ := w.(http.Flusher)
+	encoder := json.NewEncoder(w)
+	for rows.Next() {
+		var schemaVersion SchemaVersionT
+		err := rows.Scan(&schemaVersion.ID, &schemaVersion.UUID, &schemaVersion.EventModelID,
+			&schemaVersion.Schema, &schemaVersion.FirstSeen, &schemaVersion.LastSeen, &schemaVersion.TotalCount)
+		if err != nil {
+			pkgLogger.Errorf("Failed to scan schema version while exporting versions of event model: %s, err: %v", eventID, err)
+			return
+		}
+		// json.Encoder terminates each value with a newline
+		if err := encoder.Encode(schemaVersion); err != nil {
+			pkgLogger.Errorf("Failed to write schema version while exporting versions of event model: %s, err: %v", eventID, err)
+			return
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+	if err := rows.Err(); err != nil {
+		pkgLogger.Errorf("Failed to read schema versions while exporting versions of event model: %s, err: %v", eventID, err)
+	}
+}
+
 //TODO: Complete this
 func (manager *EventSchemaManagerT) GetKeyCounts(w http.ResponseWriter, r *http.Request) {
 	err := handleBasicAuth(r)
@@ -317,6 +655,12 @@ func (manager *EventSchemaManagerT) GetKeyCounts(w http.ResponseWriter, r *http.
 		return
 	}
 
+	release, ok := manager.acquireQuerySlot(w)
+	if !ok {
+		return
+	}
+	defer release()
+
 	if r.Method != http.MethodGet {
 		http.Error(w, response.MakeResponse("Only HTTP GET method is supported"), 400)
 		return
@@ -329,14 +673,39 @@ func (manager *EventSchemaManagerT) GetKeyCounts(w http.ResponseWriter, r *http.
 		return
 	}
 
-	keyCounts, err := manager.getKeyCounts(eventID)
+	if err := manager.authorizeEventModel(r, eventID); err != nil {
+		handleFetchError(w, err)
+		return
+	}
+
+	keyCounts, keyPresenceRatios, err := manager.getKeyCounts(eventID, bypassAPICache(r))
 	if err != nil {
 		logID := uuid.Must(uuid.NewV4()).String()
 		pkgLogger.Errorf("logID : %s, err: %s", logID, err.Error())
 		http.Error(w, response.MakeResponse(fmt.Sprintf("Internal Error: An error has been logged with logID : %s", logID)), 500)
 		return
 	}
-	keyCountsJSON, err := json.Marshal(keyCounts)
+
+	withPresence := r.URL.Query().Get("withPresence") == "true"
+	if !withPresence {
+		keyPresenceRatios = nil
+	}
+
+	if r.URL.Query().Get("format") == "csv" {
+		writeKeyCountsCSV(w, keyCounts, keyPresenceRatios)
+		return
+	}
+
+	var keyCountsJSON []byte
+	if withPresence {
+		keyStats := make(map[string]KeyStatT, len(keyCounts))
+		for k, count := range keyCounts {
+			keyStats[k] = KeyStatT{Count: count, PresenceRatio: keyPresenceRatios[k]}
+		}
+		keyCountsJSON, err = json.Marshal(keyStats)
+	} else {
+		keyCountsJSON, err = json.Marshal(keyCounts)
+	}
 	if err != nil {
 		logID := uuid.Must(uuid.NewV4()).String()
 		pkgLogger.Errorf("logID : %s, err: %s", logID, err.Error())
@@ -347,11 +716,58 @@ func (manager *EventSchemaManagerT) GetKeyCounts(w http.ResponseWriter, r *http.
 	w.Write(keyCountsJSON)
 }
 
-func (manager *EventSchemaManagerT) getKeyCounts(eventID string) (keyCounts map[string]int64, err error) {
+// KeyStatT is the summed count of a key along with the ratio of the schema versions containing it,
+// as returned by GetKeyCounts with withPresence=true
+type KeyStatT struct {
+	Count         int64
+	PresenceRatio float64
+}
+
+// writeKeyCountsCSV writes keyCounts as key,count rows sorted by count in descending order,
+// with a presence_ratio column if keyPresenceRatios isn't nil
+func writeKeyCountsCSV(w http.ResponseWriter, keyCounts map[string]int64, keyPresenceRatios map[string]float64) {
+	keys := make([]string, 0, len(keyCounts))
+	for k := range keyCounts {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keyCounts[keys[i]] == keyCounts[keys[j]] {
+			return keys[i] < keys[j]
+		}
+		return keyCounts[keys[i]] > keyCounts[keys[j]]
+	})
+
+	w.Header().Set("Content-Type", "text/csv")
+	csvWriter := csv.NewWriter(w)
+	if keyPresenceRatios != nil {
+		csvWriter.Write([]string{"key", "count", "presence_ratio"})
+	} else {
+		csvWriter.Write([]string{"key", "count"})
+	}
+	for _, k := range keys {
+		if keyPresenceRatios != nil {
+			csvWriter.Write([]string{k, strconv.FormatInt(keyCounts[k], 10), strconv.FormatFloat(keyPresenceRatios[k], 'f', -1, 64)})
+			continue
+		}
+		csvWriter.Write([]string{k, strconv.FormatInt(keyCounts[k], 10)})
+	}
+	csvWriter.Flush()
+	if err := csvWriter.Error(); err != nil {
+		pkgLogger.Errorf("Failed to write key counts as csv: %v", err)
+	}
+}
+
+// getKeyCounts returns the summed TotalCount of the schema versions containing each key,
+// along with the ratio of the schema versions containing it, which tells the core keys from the rare optional ones
+func (manager *EventSchemaManagerT) getKeyCounts(eventID string, bypassCache bool) (keyCounts map[string]int64, keyPresenceRatios map[string]float64, err error) {
 
-	schemaVersions := manager.fetchSchemaVersionsByEventID(eventID)
+	schemaVersions, err := manager.getSchemaVersionsByEventID(eventID, bypassCache)
+	if err != nil {
+		return
+	}
 
 	keyCounts = make(map[string]int64)
+	keyVersions := make(map[string]int)
 	for _, sv := range schemaVersions {
 		var schema map[string]string
 		err = json.Unmarshal(sv.Schema, &schema)
@@ -364,8 +780,14 @@ func (manager *EventSchemaManagerT) getKeyCounts(eventID string) (keyCounts map[
 				keyCounts[k] = 0
 			}
 			keyCounts[k] = keyCounts[k] + sv.TotalCount
+			keyVersions[k]++
 		}
 	}
+
+	keyPresenceRatios = make(map[string]float64, len(keyVersions))
+	for k, versions := range keyVersions {
+		keyPresenceRatios[k] = float64(versions) / float64(len(schemaVersions))
+	}
 	return
 }
 
@@ -376,6 +798,12 @@ func (manager *EventSchemaManagerT) GetEventModelMetadata(w http.ResponseWriter,
 		return
 	}
 
+	release, ok := manager.acquireQuerySlot(w)
+	if !ok {
+		return
+	}
+	defer release()
+
 	if r.Method != http.MethodGet {
 		http.Error(w, response.MakeResponse("Only HTTP GET method is supported"), 400)
 		return
@@ -388,9 +816,14 @@ func (manager *EventSchemaManagerT) GetEventModelMetadata(w http.ResponseWriter,
 		return
 	}
 
+	if err := manager.authorizeEventModel(r, eventID); err != nil {
+		handleFetchError(w, err)
+		return
+	}
+
 	metadata, err := manager.fetchMetadataByEventModelID(eventID)
 	if err != nil {
-		http.Error(w, response.MakeResponse(err.Error()), 400)
+		handleFetchError(w, err)
 		return
 	}
 
@@ -411,6 +844,12 @@ func (manager *EventSchemaManagerT) GetSchemaVersionMetadata(w http.ResponseWrit
 		return
 	}
 
+	release, ok := manager.acquireQuerySlot(w)
+	if !ok {
+		return
+	}
+	defer release()
+
 	if r.Method != http.MethodGet {
 		http.Error(w, response.MakeResponse("Only HTTP GET method is supported"), 400)
 		return
@@ -423,9 +862,14 @@ func (manager *EventSchemaManagerT) GetSchemaVersionMetadata(w http.ResponseWrit
 		return
 	}
 
+	if err := manager.authorizeSchemaVersion(r, versionID); err != nil {
+		handleFetchError(w, err)
+		return
+	}
+
 	metadata, err := manager.fetchMetadataByEventVersionID(versionID)
 	if err != nil {
-		http.Error(w, response.MakeResponse(err.Error()), 400)
+		handleFetchError(w, err)
 		return
 	}
 
@@ -435,7 +879,53 @@ func (manager *EventSchemaManagerT) GetSchemaVersionMetadata(w http.ResponseWrit
 		return
 	}
 
-	w.Write(metadataJSON)
+	writeWithETag(w, r, metadataJSON)
+}
+
+//GetSchemaVersionHash responds with the canonical hash of the schema version, see SchemaVersionT.CanonicalHash
+func (manager *EventSchemaManagerT) GetSchemaVersionHash(w http.ResponseWriter, r *http.Request) {
+	err := handleBasicAuth(r)
+	if err != nil {
+		http.Error(w, response.MakeResponse(err.Error()), 400)
+		return
+	}
+
+	release, ok := manager.acquireQuerySlot(w)
+	if !ok {
+		return
+	}
+	defer release()
+
+	if r.Method != http.MethodGet {
+		http.Error(w, response.MakeResponse("Only HTTP GET method is supported"), 400)
+		return
+	}
+
+	vars := mux.Vars(r)
+	versionID, ok := vars["VersionID"]
+	if !ok {
+		http.Error(w, response.MakeResponse("Mandatory field: VersionID missing"), 400)
+		return
+	}
+
+	if err := manager.authorizeSchemaVersion(r, versionID); err != nil {
+		handleFetchError(w, err)
+		return
+	}
+
+	schema, err := manager.fetchSchemaVersionByID(versionID)
+	if err != nil {
+		handleFetchError(w, err)
+		return
+	}
+
+	hashJSON, err := json.Marshal(map[string]string{"VersionID": schema.UUID, "CanonicalHash": schema.CanonicalHash()})
+	if err != nil {
+		http.Error(w, response.MakeResponse("Internal Error: Failed to Marshal hash"), 500)
+		return
+	}
+
+	writeWithETag(w, r, hashJSON)
 }
 
 func (manager *EventSchemaManagerT) GetSchemaVersionMissingKeys(w http.ResponseWriter, r *http.Request) {
@@ -445,6 +935,12 @@ func (manager *EventSchemaManagerT) GetSchemaVersionMissingKeys(w http.ResponseW
 		return
 	}
 
+	release, ok := manager.acquireQuerySlot(w)
+	if !ok {
+		return
+	}
+	defer release()
+
 	if r.Method != http.MethodGet {
 		http.Error(w, response.MakeResponse("Only HTTP GET method is supported"), 400)
 		return
@@ -457,9 +953,14 @@ func (manager *EventSchemaManagerT) GetSchemaVersionMissingKeys(w http.ResponseW
 		return
 	}
 
+	if err := manager.authorizeSchemaVersion(r, versionID); err != nil {
+		handleFetchError(w, err)
+		return
+	}
+
 	schema, err := manager.fetchSchemaVersionByID(versionID)
 	if err != nil {
-		http.Error(w, response.MakeResponse(err.Error()), 500)
+		handleFetchError(w, err)
 		return
 	}
 
@@ -505,16 +1006,243 @@ func (manager *EventSchemaManagerT) GetSchemaVersionMissingKeys(w http.ResponseW
 	w.Write(missingKeyJSON)
 }
 
-func (manager *EventSchemaManagerT) fetchEventModelsByWriteKey(writeKey string) []*EventModelT {
-	var eventModelsSelectSQL string
-	if writeKey == "" {
-		eventModelsSelectSQL = fmt.Sprintf(`SELECT id, uuid, write_key, event_type, event_model_identifier, created_at, schema, total_count, last_seen FROM %s`, EVENT_MODELS_TABLE)
+// recomputedCountT is the total_count of an event model before and after RecomputeCounts
+type recomputedCountT struct {
+	EventID string
+	Before  int64
+	After   int64
+}
+
+// RecomputeCounts repairs the total_count of the event model given in the EventID query param, or of all of them without it,
+// which can drift from the counts of its schema versions if ingestion crashed mid flush.
+// It responds with the counts before and after, for the event models having schema versions.
+func (manager *EventSchemaManagerT) RecomputeCounts(w http.ResponseWriter, r *http.Request) {
+	err := handleBasicAuth(r)
+	if err != nil {
+		http.Error(w, response.MakeResponse(err.Error()), 400)
+		return
+	}
+
+	release, ok := manager.acquireQuerySlot(w)
+	if !ok {
+		return
+	}
+	defer release()
+
+	if r.Method != http.MethodPost {
+		http.Error(w, response.MakeResponse("Only HTTP POST method is supported"), 400)
+		return
+	}
+
+	eventID := r.URL.Query().Get("EventID")
+	//the restricted admin users can only recompute the counts of the event models of their write keys, one at a time
+	if eventID == "" {
+		err = authorizeWriteKey(r, "")
 	} else {
-		eventModelsSelectSQL = fmt.Sprintf(`SELECT id, uuid, write_key, event_type, event_model_identifier, created_at, schema, total_count, last_seen FROM %s WHERE write_key = '%s'`, EVENT_MODELS_TABLE, writeKey)
+		err = manager.authorizeEventModel(r, eventID)
+	}
+	if err != nil {
+		handleFetchError(w, err)
+		return
 	}
 
-	rows, err := manager.dbHandle.Query(eventModelsSelectSQL)
-	assertError(err)
+	counts, err := manager.recomputeCounts(eventID)
+	if err != nil {
+		handleFetchError(w, err)
+		return
+	}
+
+	countsJSON, err := json.Marshal(counts)
+	if err != nil {
+		http.Error(w, response.MakeResponse("Internal Error: Failed to Marshal counts"), 500)
+		return
+	}
+
+	w.Write(countsJSON)
+}
+
+// recomputeCounts sets the total_count of the event model eventID, or of all of them if empty, to the sum of the total_count
+// of its schema versions in a transaction, along with the TotalCount of its metadata from which it is reloaded.
+// The event models are locked meanwhile and the cached ones are updated too, so that a flush can't write back the drifted counts.
+func (manager *EventSchemaManagerT) recomputeCounts(eventID string) ([]*recomputedCountT, error) {
+	manager.eventModelLock.Lock()
+	defer manager.eventModelLock.Unlock()
+
+	var eventModelFilter, schemaVersionFilter string
+	var args []interface{}
+	if eventID != "" {
+		eventModelFilter = ` WHERE uuid = $1`
+		schemaVersionFilter = ` WHERE event_model_id = $1`
+		args = append(args, eventID)
+	}
+
+	txn, err := manager.dbHandle.Begin()
+	if err != nil {
+		return nil, err
+	}
+	//Rollback is a no-op once committed
+	defer txn.Rollback()
+
+	countsSelectSQL := fmt.Sprintf(`SELECT uuid, total_count FROM %s%s FOR UPDATE`, EVENT_MODELS_TABLE, eventModelFilter)
+	rows, err := txn.Query(countsSelectSQL, args...)
+	if err != nil {
+		return nil, err
+	}
+	before := make(map[string]int64)
+	for rows.Next() {
+		var eventModelID string
+		var totalCount int64
+		if err := rows.Scan(&eventModelID, &totalCount); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		before[eventModelID] = totalCount
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if eventID != "" && len(before) == 0 {
+		return nil, fmt.Errorf("event model %s: %w", eventID, errNotFound)
+	}
+
+	countsUpdateSQL := fmt.Sprintf(`UPDATE %[1]s SET total_count = sv.total_count, metadata = jsonb_set(%[1]s.metadata, '{TotalCount}', to_jsonb(sv.total_count))
+		FROM (SELECT event_model_id, SUM(total_count) AS total_count FROM %[2]s%[3]s GROUP BY event_model_id) sv
+		WHERE %[1]s.uuid = sv.event_model_id RETURNING %[1]s.uuid, %[1]s.total_count`, EVENT_MODELS_TABLE, SCHEMA_VERSIONS_TABLE, schemaVersionFilter)
+	rows, err = txn.Query(countsUpdateSQL, args...)
+	if err != nil {
+		return nil, err
+	}
+	counts := make([]*recomputedCountT, 0)
+	for rows.Next() {
+		count := &recomputedCountT{}
+		if err := rows.Scan(&count.EventID, &count.After); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		count.Before = before[count.EventID]
+		counts = append(counts, count)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if err := txn.Commit(); err != nil {
+		return nil, err
+	}
+
+	after := make(map[string]int64, len(counts))
+	for _, count := range counts {
+		after[count.EventID] = count.After
+	}
+	for _, eventTypes := range manager.eventModelMap {
+		for _, eventIdentifiers := range eventTypes {
+			for _, eventModel := range eventIdentifiers {
+				if totalCount, ok := after[eventModel.UUID]; ok {
+					eventModel.TotalCount = totalCount
+					if eventModel.reservoirSample != nil {
+						eventModel.reservoirSample.setTotalCount(totalCount)
+					}
+				}
+			}
+		}
+	}
+	manager.apiCache.invalidate()
+
+	sort.Slice(counts, func(i, j int) bool { return counts[i].EventID < counts[j].EventID })
+	pkgLogger.Infof("[EventSchemas] Recomputed the total count of %d event models", len(counts))
+	return counts, nil
+}
+
+// bypassAPICache returns true if the request asks for fresh results with noCache=true
+func bypassAPICache(r *http.Request) bool {
+	return r.URL.Query().Get("noCache") == "true"
+}
+
+// eventModelFilterColumns maps the query params by which the event models can be filtered to their columns,
+// in the order their predicates are added
+var eventModelFilterColumns = []struct {
+	param  string
+	column string
+}{
+	{param: "WriteKey", column: "write_key"},
+	{param: "EventType", column: "event_type"},
+	{param: "EventIdentifier", column: "event_model_identifier"},
+}
+
+// eventModelFilters returns the column filters given as non empty WriteKey, EventType and EventIdentifier query params
+func eventModelFilters(r *http.Request) map[string]string {
+	filters := make(map[string]string)
+	for _, filter := range eventModelFilterColumns {
+		if value := r.URL.Query().Get(filter.param); value != "" {
+			filters[filter.column] = value
+		}
+	}
+	return filters
+}
+
+// getEventModels returns the event models matching filters from the api cache,
+// fetching them from the db if they aren't cached or bypassCache is true. Failed fetches aren't cached.
+func (manager *EventSchemaManagerT) getEventModels(filters map[string]string, bypassCache bool) ([]*EventModelT, error) {
+	cacheKey := "event_models:"
+	for _, filter := range eventModelFilterColumns {
+		if value, ok := filters[filter.column]; ok {
+			cacheKey += fmt.Sprintf("%s=%q;", filter.column, value)
+		}
+	}
+	if !bypassCache {
+		if eventModels, ok := manager.apiCache.get(cacheKey); ok {
+			return eventModels.([]*EventModelT), nil
+		}
+	}
+
+	eventModels, err := manager.fetchEventModels(filters)
+	if err != nil {
+		return nil, err
+	}
+	manager.apiCache.set(cacheKey, eventModels, apiCacheTTL)
+	return eventModels, nil
+}
+
+// getSchemaVersionsByEventID returns the schema versions of eventID from the api cache,
+// fetching them from the db if they aren't cached or bypassCache is true. Failed fetches aren't cached.
+func (manager *EventSchemaManagerT) getSchemaVersionsByEventID(eventID string, bypassCache bool) ([]*SchemaVersionT, error) {
+	cacheKey := "schema_versions:" + eventID
+	if !bypassCache {
+		if schemaVersions, ok := manager.apiCache.get(cacheKey); ok {
+			return schemaVersions.([]*SchemaVersionT), nil
+		}
+	}
+
+	schemaVersions, err := manager.fetchSchemaVersionsByEventID(eventID)
+	if err != nil {
+		return nil, err
+	}
+	manager.apiCache.set(cacheKey, schemaVersions, apiCacheTTL)
+	return schemaVersions, nil
+}
+
+// fetchEventModels returns the event models matching all of filters, a map of column to value.
+// Only the write_key, event_type and event_model_identifier columns are filtered on, and without any filter all the event models are returned.
+func (manager *EventSchemaManagerT) fetchEventModels(filters map[string]string) ([]*EventModelT, error) {
+	eventModelsSelectSQL := fmt.Sprintf(`SELECT id, uuid, write_key, event_type, event_model_identifier, created_at, schema, total_count, last_seen FROM %s`, EVENT_MODELS_TABLE)
+	var predicates []string
+	var args []interface{}
+	for _, filter := range eventModelFilterColumns {
+		if value, ok := filters[filter.column]; ok {
+			args = append(args, value)
+			predicates = append(predicates, fmt.Sprintf(`%s = $%d`, filter.column, len(args)))
+		}
+	}
+	if len(predicates) > 0 {
+		eventModelsSelectSQL += ` WHERE ` + strings.Join(predicates, ` AND `)
+	}
+
+	rows, err := manager.dbHandle.Query(eventModelsSelectSQL, args...)
+	if err != nil {
+		return nil, err
+	}
 	defer rows.Close()
 
 	eventModels := make([]*EventModelT, 0)
@@ -523,19 +1251,23 @@ func (manager *EventSchemaManagerT) fetchEventModelsByWriteKey(writeKey string)
 		var eventModel EventModelT
 		err := rows.Scan(&eventModel.ID, &eventModel.UUID, &eventModel.WriteKey, &eventModel.EventType,
 			&eventModel.EventIdentifier, &eventModel.CreatedAt, &eventModel.Schema, &eventModel.TotalCount, &eventModel.LastSeen)
-		assertError(err)
+		if err != nil {
+			return nil, err
+		}
 
 		eventModels = append(eventModels, &eventModel)
 	}
 
-	return eventModels
+	return eventModels, rows.Err()
 }
 
-func (manager *EventSchemaManagerT) fetchSchemaVersionsByEventID(eventID string) []*SchemaVersionT {
+func (manager *EventSchemaManagerT) fetchSchemaVersionsByEventID(eventID string) ([]*SchemaVersionT, error) {
 	schemaVersionsSelectSQL := fmt.Sprintf(`SELECT id, uuid, event_model_id, schema, first_seen, last_seen, total_count FROM %s WHERE event_model_id = '%s'`, SCHEMA_VERSIONS_TABLE, eventID)
 
 	rows, err := manager.dbHandle.Query(schemaVersionsSelectSQL)
-	assertError(err)
+	if err != nil {
+		return nil, err
+	}
 	defer rows.Close()
 
 	schemaVersions := make([]*SchemaVersionT, 0)
@@ -544,19 +1276,23 @@ func (manager *EventSchemaManagerT) fetchSchemaVersionsByEventID(eventID string)
 		var schemaVersion SchemaVersionT
 		err := rows.Scan(&schemaVersion.ID, &schemaVersion.UUID, &schemaVersion.EventModelID,
 			&schemaVersion.Schema, &schemaVersion.FirstSeen, &schemaVersion.LastSeen, &schemaVersion.TotalCount)
-		assertError(err)
+		if err != nil {
+			return nil, err
+		}
 
 		schemaVersions = append(schemaVersions, &schemaVersion)
 	}
 
-	return schemaVersions
+	return schemaVersions, rows.Err()
 }
 
 func (manager *EventSchemaManagerT) fetchEventModelByID(id string) (*EventModelT, error) {
 	eventModelsSelectSQL := fmt.Sprintf(`SELECT id, uuid, write_key, event_type, event_model_identifier, created_at, schema, total_count, last_seen FROM %s WHERE uuid = '%s'`, EVENT_MODELS_TABLE, id)
 
 	rows, err := manager.dbHandle.Query(eventModelsSelectSQL)
-	assertError(err)
+	if err != nil {
+		return nil, err
+	}
 	defer rows.Close()
 
 	eventModels := make([]*EventModelT, 0)
@@ -565,28 +1301,58 @@ func (manager *EventSchemaManagerT) fetchEventModelByID(id string) (*EventModelT
 		var eventModel EventModelT
 		err := rows.Scan(&eventModel.ID, &eventModel.UUID, &eventModel.WriteKey, &eventModel.EventType,
 			&eventModel.EventIdentifier, &eventModel.CreatedAt, &eventModel.Schema, &eventModel.TotalCount, &eventModel.LastSeen)
-		assertError(err)
+		if err != nil {
+			return nil, err
+		}
 
 		eventModels = append(eventModels, &eventModel)
 	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
 
 	if len(eventModels) == 0 {
-		err = fmt.Errorf("No eventModels found for given eventModelID : %s", id)
+		err = fmt.Errorf("No eventModels found for given eventModelID : %s: %w", id, errNotFound)
 		return nil, err
 	}
 
 	if len(eventModels) > 1 {
-		panic(fmt.Sprintf("More than one entry found for eventModelId : %s. Make sure a unique key constraint is present on uuid column", id))
+		err = fmt.Errorf("More than one entry found for eventModelId : %s. Make sure a unique key constraint is present on uuid column", id)
+		return nil, err
 	}
 
 	return eventModels[0], nil
 }
 
+func (manager *EventSchemaManagerT) fetchEventModelsByIDs(ids []string) (map[string]*EventModelT, error) {
+	eventModelsSelectSQL := fmt.Sprintf(`SELECT id, uuid, write_key, event_type, event_model_identifier, created_at, schema, total_count, last_seen FROM %s WHERE uuid = ANY($1)`, EVENT_MODELS_TABLE)
+
+	rows, err := manager.dbHandle.Query(eventModelsSelectSQL, pq.Array(ids))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	eventModels := make(map[string]*EventModelT)
+	for rows.Next() {
+		var eventModel EventModelT
+		err := rows.Scan(&eventModel.ID, &eventModel.UUID, &eventModel.WriteKey, &eventModel.EventType,
+			&eventModel.EventIdentifier, &eventModel.CreatedAt, &eventModel.Schema, &eventModel.TotalCount, &eventModel.LastSeen)
+		if err != nil {
+			return nil, err
+		}
+		eventModels[eventModel.UUID] = &eventModel
+	}
+	return eventModels, rows.Err()
+}
+
 func (manager *EventSchemaManagerT) fetchSchemaVersionByID(id string) (*SchemaVersionT, error) {
 	schemaVersionsSelectSQL := fmt.Sprintf(`SELECT id, uuid, event_model_id, schema, first_seen, last_seen, total_count FROM %s WHERE uuid = '%s'`, SCHEMA_VERSIONS_TABLE, id)
 
 	rows, err := manager.dbHandle.Query(schemaVersionsSelectSQL)
-	assertError(err)
+	if err != nil {
+		return nil, err
+	}
 	defer rows.Close()
 
 	schemaVersions := make([]*SchemaVersionT, 0)
@@ -594,18 +1360,24 @@ func (manager *EventSchemaManagerT) fetchSchemaVersionByID(id string) (*SchemaVe
 	for rows.Next() {
 		var schemaVersion SchemaVersionT
 		err := rows.Scan(&schemaVersion.ID, &schemaVersion.UUID, &schemaVersion.EventModelID, &schemaVersion.Schema, &schemaVersion.FirstSeen, &schemaVersion.LastSeen, &schemaVersion.TotalCount)
-		assertError(err)
+		if err != nil {
+			return nil, err
+		}
 
 		schemaVersions = append(schemaVersions, &schemaVersion)
 	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
 
 	if len(schemaVersions) == 0 {
-		err = fmt.Errorf("No SchemaVersion found for given VersionID : %s", id)
+		err = fmt.Errorf("No SchemaVersion found for given VersionID : %s: %w", id, errNotFound)
 		return nil, err
 	}
 
 	if len(schemaVersions) > 1 {
-		panic(fmt.Sprintf("More than one entry found for eventVersionID : %s. Make sure a unique key constraint is present on uuid column", id))
+		err = fmt.Errorf("More than one entry found for eventVersionID : %s. Make sure a unique key constraint is present on uuid column", id)
+		return nil, err
 	}
 
 	return schemaVersions[0], nil
@@ -615,7 +1387,9 @@ func (manager *EventSchemaManagerT) fetchMetadataByEventVersionID(eventVersionID
 	metadataSelectSQL := fmt.Sprintf(`SELECT metadata FROM %s WHERE uuid = '%s'`, SCHEMA_VERSIONS_TABLE, eventVersionID)
 
 	rows, err := manager.dbHandle.Query(metadataSelectSQL)
-	assertError(err)
+	if err != nil {
+		return nil, err
+	}
 	defer rows.Close()
 
 	metadatas := make([]*MetaDataT, 0)
@@ -623,21 +1397,28 @@ func (manager *EventSchemaManagerT) fetchMetadataByEventVersionID(eventVersionID
 	for rows.Next() {
 		var metadataRaw []byte
 		err := rows.Scan(&metadataRaw)
-		assertError(err)
+		if err != nil {
+			return nil, err
+		}
 
 		var metadata MetaDataT
 		err = json.Unmarshal(metadataRaw, &metadata)
-		assertError(err)
+		if err != nil {
+			return nil, err
+		}
 		metadatas = append(metadatas, &metadata)
 	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
 
 	if len(metadatas) > 1 {
 		err = fmt.Errorf("More than one entry found for eventVersionID : %s. Make sure a unique key constraint is present on uuid column", eventVersionID)
-		assertError(err)
+		return nil, err
 	}
 
 	if len(metadatas) == 0 {
-		err = fmt.Errorf("No Metadata found for given VersionID : %s", eventVersionID)
+		err = fmt.Errorf("No Metadata found for given VersionID : %s: %w", eventVersionID, errNotFound)
 		return nil, err
 	}
 
@@ -649,7 +1430,9 @@ func (manager *EventSchemaManagerT) fetchMetadataByEventModelID(eventModelID str
 	metadataSelectSQL := fmt.Sprintf(`SELECT metadata FROM %s WHERE uuid = '%s'`, EVENT_MODELS_TABLE, eventModelID)
 
 	rows, err := manager.dbHandle.Query(metadataSelectSQL)
-	assertError(err)
+	if err != nil {
+		return nil, err
+	}
 	defer rows.Close()
 
 	metadatas := make([]*MetaDataT, 0)
@@ -657,21 +1440,28 @@ func (manager *EventSchemaManagerT) fetchMetadataByEventModelID(eventModelID str
 	for rows.Next() {
 		var metadataRaw []byte
 		err := rows.Scan(&metadataRaw)
-		assertError(err)
+		if err != nil {
+			return nil, err
+		}
 
 		var metadata MetaDataT
 		err = json.Unmarshal(metadataRaw, &metadata)
-		assertError(err)
+		if err != nil {
+			return nil, err
+		}
 		metadatas = append(metadatas, &metadata)
 	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
 
 	if len(metadatas) > 1 {
-		err = fmt.Errorf("More than one entry found for eventVersionID : %s. Make sure a unique key constraint is present on uuid column", eventModelID)
-		assertError(err)
+		err = fmt.Errorf("More than one entry found for eventModelID : %s. Make sure a unique key constraint is present on uuid column", eventModelID)
+		return nil, err
 	}
 
 	if len(metadatas) == 0 {
-		err = fmt.Errorf("No Metadata found for given VersionID : %s", eventModelID)
+		err = fmt.Errorf("No Metadata found for given EventID : %s: %w", eventModelID, errNotFound)
 		return nil, err
 	}
 
@@ -0,0 +1,792 @@
+package event_schema
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"time"
+
+	sqlmock "github.com/DATA-DOG/go-sqlmock"
+	"github.com/gorilla/mux"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+	"github.com/rudderlabs/rudder-server/config"
+	"github.com/rudderlabs/rudder-server/utils/logger"
+)
+
+func initEventSchemas() {
+	config.Load()
+	logger.Init()
+	Init2()
+}
+
+func newSchemaRequest(target string, vars map[string]string) *http.Request {
+	req := httptest.NewRequest(http.MethodGet, target, nil)
+	req.SetBasicAuth(adminUser, adminPassword)
+	return mux.SetURLVars(req, vars)
+}
+
+//mockEventSchemaManager is an EventSchemaManagerT over a sqlmock db, renewed before every spec
+type mockEventSchemaManager struct {
+	manager *EventSchemaManagerT
+	dbMock  sqlmock.Sqlmock
+}
+
+//newMockEventSchemaManager sets up a mockEventSchemaManager before every spec of the container, to be customized by the container's own BeforeEach,
+//and checks after every spec that all the expected queries were run
+func newMockEventSchemaManager() *mockEventSchemaManager {
+	m := &mockEventSchemaManager{}
+
+	BeforeEach(func() {
+		db, dbMock, err := sqlmock.New()
+		Expect(err).To(BeNil())
+		m.manager = &EventSchemaManagerT{dbHandle: db}
+		m.dbMock = dbMock
+	})
+
+	AfterEach(func() {
+		Expect(m.dbMock.ExpectationsWereMet()).To(BeNil())
+		m.manager.dbHandle.Close()
+	})
+
+	return m
+}
+
+var _ = Describe("EventSchemas API", func() {
+	initEventSchemas()
+
+	m := newMockEventSchemaManager()
+
+	Context("GetEventModelMetadata", func() {
+		It("responds with 404 if the event model doesn't exist", func() {
+			m.dbMock.ExpectQuery("SELECT metadata FROM event_models").
+				WillReturnRows(sqlmock.NewRows([]string{"metadata"}))
+
+			rr := httptest.NewRecorder()
+			m.manager.GetEventModelMetadata(rr, newSchemaRequest("/schemas/event-model/missing-id/metadata", map[string]string{"EventID": "missing-id"}))
+			Expect(rr.Code).To(Equal(http.StatusNotFound))
+		})
+
+		It("responds with 500 if more than one event model exists for the uuid", func() {
+			m.dbMock.ExpectQuery("SELECT metadata FROM event_models").
+				WillReturnRows(sqlmock.NewRows([]string{"metadata"}).AddRow([]byte(`{}`)).AddRow([]byte(`{}`)))
+
+			rr := httptest.NewRecorder()
+			m.manager.GetEventModelMetadata(rr, newSchemaRequest("/schemas/event-model/duplicate-id/metadata", map[string]string{"EventID": "duplicate-id"}))
+			Expect(rr.Code).To(Equal(http.StatusInternalServerError))
+			Expect(rr.Body.String()).To(ContainSubstring("logID"))
+		})
+
+		It("responds with 400 if the EventID is missing", func() {
+			rr := httptest.NewRecorder()
+			m.manager.GetEventModelMetadata(rr, newSchemaRequest("/schemas/event-model//metadata", map[string]string{}))
+			Expect(rr.Code).To(Equal(http.StatusBadRequest))
+		})
+	})
+
+	Context("GetEventModel", func() {
+		It("responds with the event model for the uuid", func() {
+			m.dbMock.ExpectQuery("SELECT (.+) FROM event_models WHERE uuid").
+				WillReturnRows(sqlmock.NewRows([]string{"id", "uuid", "write_key", "event_type", "event_model_identifier", "created_at", "schema", "total_count", "last_seen"}).
+					AddRow(1, "event-1", "write-key", "track", "logged_in", time.Now(), []byte(`{"a":"string"}`), 5, time.Now()))
+
+			rr := httptest.NewRecorder()
+			m.manager.GetEventModel(rr, newSchemaRequest("/schemas/event-model/event-1", map[string]string{"EventID": "event-1"}))
+			Expect(rr.Code).To(Equal(http.StatusOK))
+			Expect(rr.Body.String()).To(ContainSubstring(`"EventID":"event-1"`))
+			Expect(rr.Body.String()).To(ContainSubstring(`"EventIdentifier":"logged_in"`))
+		})
+
+		It("responds with 404 if the event model doesn't exist", func() {
+			m.dbMock.ExpectQuery("SELECT (.+) FROM event_models WHERE uuid").
+				WillReturnRows(sqlmock.NewRows([]string{"id", "uuid", "write_key", "event_type", "event_model_identifier", "created_at", "schema", "total_count", "last_seen"}))
+
+			rr := httptest.NewRecorder()
+			m.manager.GetEventModel(rr, newSchemaRequest("/schemas/event-model/missing-id", map[string]string{"EventID": "missing-id"}))
+			Expect(rr.Code).To(Equal(http.StatusNotFound))
+		})
+
+		It("responds with 500 rather than panicking if the query fails", func() {
+			m.dbMock.ExpectQuery("SELECT (.+) FROM event_models WHERE uuid").
+				WillReturnError(errors.New("connection reset by peer"))
+
+			rr := httptest.NewRecorder()
+			Expect(func() {
+				m.manager.GetEventModel(rr, newSchemaRequest("/schemas/event-model/event-1", map[string]string{"EventID": "event-1"}))
+			}).NotTo(Panic())
+			Expect(rr.Code).To(Equal(http.StatusInternalServerError))
+			Expect(rr.Body.String()).To(ContainSubstring("logID"))
+		})
+	})
+
+	Context("GetEventModels", func() {
+		eventModelColumns := []string{"id", "uuid", "write_key", "event_type", "event_model_identifier", "created_at", "schema", "total_count", "last_seen"}
+
+		DescribeTable("filters by the params given, passing them as query args rather than in the sql",
+			func(params, whereSQL string, args ...driver.Value) {
+				m.dbMock.ExpectQuery(`SELECT (.+) FROM event_models` + regexp.QuoteMeta(whereSQL) + `$`).
+					WithArgs(args...).
+					WillReturnRows(sqlmock.NewRows(eventModelColumns))
+
+				rr := httptest.NewRecorder()
+				m.manager.GetEventModels(rr, newSchemaRequest("/schemas/event-models"+params, nil))
+				Expect(rr.Code).To(Equal(http.StatusOK))
+				Expect(rr.Body.String()).To(Equal("[]"))
+			},
+			Entry("all of WriteKey, EventType and EventIdentifier", "?EventIdentifier=logged_in&WriteKey=write-key&EventType=track", " WHERE write_key = $1 AND event_type = $2 AND event_model_identifier = $3", "write-key", "track", "logged_in"),
+			Entry("only the non empty ones", "?EventType=identify&WriteKey=", " WHERE event_type = $1", "identify"),
+			Entry("none without any params", "", ""),
+			Entry("quotes in a param", "?WriteKey=key'%20OR%20'1'%3D'1", " WHERE write_key = $1", "key' OR '1'='1"),
+		)
+
+		It("responds with 500 rather than panicking if the query fails", func() {
+			m.dbMock.ExpectQuery(`SELECT (.+) FROM event_models WHERE write_key = \$1$`).
+				WithArgs("write-key").
+				WillReturnError(errors.New("connection reset by peer"))
+
+			rr := httptest.NewRecorder()
+			Expect(func() {
+				m.manager.GetEventModels(rr, newSchemaRequest("/schemas/event-models?WriteKey=write-key", nil))
+			}).NotTo(Panic())
+			Expect(rr.Code).To(Equal(http.StatusInternalServerError))
+		})
+
+		Context("with a write key allowlist", func() {
+			BeforeEach(func() {
+				writeKeyAllowlist = parseWriteKeyAllowlist([]string{adminUser + ":write-key", adminUser + ":other-write-key"})
+			})
+
+			AfterEach(func() {
+				writeKeyAllowlist = map[string]map[string]bool{}
+			})
+
+			It("responds with the event models of an allowed write key", func() {
+				m.dbMock.ExpectQuery(`SELECT (.+) FROM event_models WHERE write_key = \$1$`).
+					WithArgs("other-write-key").
+					WillReturnRows(sqlmock.NewRows(eventModelColumns))
+
+				rr := httptest.NewRecorder()
+				m.manager.GetEventModels(rr, newSchemaRequest("/schemas/event-models?WriteKey=other-write-key", nil))
+				Expect(rr.Code).To(Equal(http.StatusOK))
+			})
+
+			It("responds with 403 for a write key out of the allowlist", func() {
+				rr := httptest.NewRecorder()
+				m.manager.GetEventModels(rr, newSchemaRequest("/schemas/event-models?WriteKey=forbidden-write-key", nil))
+				Expect(rr.Code).To(Equal(http.StatusForbidden))
+			})
+
+			It("responds with 403 without a write key", func() {
+				rr := httptest.NewRecorder()
+				m.manager.GetEventModels(rr, newSchemaRequest("/schemas/event-models?EventType=track", nil))
+				Expect(rr.Code).To(Equal(http.StatusForbidden))
+			})
+
+			It("doesn't restrict the admin users out of the allowlist", func() {
+				writeKeyAllowlist = parseWriteKeyAllowlist([]string{"ops:write-key"})
+				m.dbMock.ExpectQuery(`SELECT (.+) FROM event_models$`).
+					WithArgs().
+					WillReturnRows(sqlmock.NewRows(eventModelColumns))
+
+				rr := httptest.NewRecorder()
+				m.manager.GetEventModels(rr, newSchemaRequest("/schemas/event-models", nil))
+				Expect(rr.Code).To(Equal(http.StatusOK))
+			})
+		})
+	})
+
+	Context("GetEventModelsByIDs", func() {
+		eventModelColumns := []string{"id", "uuid", "write_key", "event_type", "event_model_identifier", "created_at", "schema", "total_count", "last_seen"}
+
+		It("responds with the event models found for the posted ids", func() {
+			m.dbMock.ExpectQuery("SELECT (.+) FROM event_models WHERE uuid = ANY").
+				WithArgs("{\"event-1\",\"event-2\",\"missing-id\"}").
+				WillReturnRows(sqlmock.NewRows(eventModelColumns).
+					AddRow(1, "event-1", "write-key", "track", "logged_in", time.Now(), []byte(`{"a":"string"}`), 5, time.Now()).
+					AddRow(2, "event-2", "write-key", "track", "logged_out", time.Now(), []byte(`{"b":"string"}`), 3, time.Now()))
+
+			req := httptest.NewRequest(http.MethodPost, "/schemas/event-models/by-ids", strings.NewReader(`{"EventIDs":["event-1","event-2","missing-id"]}`))
+			req.SetBasicAuth(adminUser, adminPassword)
+			rr := httptest.NewRecorder()
+			m.manager.GetEventModelsByIDs(rr, req)
+			Expect(rr.Code).To(Equal(http.StatusOK))
+
+			var eventModels map[string]*EventModelT
+			Expect(json.Unmarshal(rr.Body.Bytes(), &eventModels)).To(Succeed())
+			Expect(eventModels).To(HaveLen(2))
+			Expect(eventModels["event-1"].EventIdentifier).To(Equal("logged_in"))
+			Expect(eventModels["event-2"].EventIdentifier).To(Equal("logged_out"))
+			Expect(eventModels).NotTo(HaveKey("missing-id"))
+		})
+
+		It("accepts the ids as repeated query params", func() {
+			m.dbMock.ExpectQuery("SELECT (.+) FROM event_models WHERE uuid = ANY").
+				WithArgs("{\"event-1\",\"event-2\"}").
+				WillReturnRows(sqlmock.NewRows(eventModelColumns))
+
+			rr := httptest.NewRecorder()
+			m.manager.GetEventModelsByIDs(rr, newSchemaRequest("/schemas/event-models/by-ids?EventID=event-1&EventID=event-2", map[string]string{}))
+			Expect(rr.Code).To(Equal(http.StatusOK))
+			Expect(rr.Body.String()).To(Equal(`{}`))
+		})
+
+		It("responds with 400 without any ids", func() {
+			rr := httptest.NewRecorder()
+			m.manager.GetEventModelsByIDs(rr, newSchemaRequest("/schemas/event-models/by-ids", map[string]string{}))
+			Expect(rr.Code).To(Equal(http.StatusBadRequest))
+		})
+	})
+
+	Context("GetSchemaVersionMetadata", func() {
+		It("responds with 304 if the metadata matches the ETag sent in If-None-Match", func() {
+			for i := 0; i < 2; i++ {
+				m.dbMock.ExpectQuery("SELECT metadata FROM schema_versions").
+					WillReturnRows(sqlmock.NewRows([]string{"metadata"}).AddRow([]byte(`{"SampledEvents":[]}`)))
+			}
+
+			rr := httptest.NewRecorder()
+			m.manager.GetSchemaVersionMetadata(rr, newSchemaRequest("/schemas/event-version/version-1/metadata", map[string]string{"VersionID": "version-1"}))
+			Expect(rr.Code).To(Equal(http.StatusOK))
+			etag := rr.Header().Get("ETag")
+			Expect(etag).NotTo(BeEmpty())
+
+			req := newSchemaRequest("/schemas/event-version/version-1/metadata", map[string]string{"VersionID": "version-1"})
+			req.Header.Set("If-None-Match", etag)
+			rr = httptest.NewRecorder()
+			m.manager.GetSchemaVersionMetadata(rr, req)
+			Expect(rr.Code).To(Equal(http.StatusNotModified))
+			Expect(rr.Body.Len()).To(Equal(0))
+			Expect(rr.Header().Get("ETag")).To(Equal(etag))
+		})
+
+		It("responds with the metadata if the ETag doesn't match", func() {
+			m.dbMock.ExpectQuery("SELECT metadata FROM schema_versions").
+				WillReturnRows(sqlmock.NewRows([]string{"metadata"}).AddRow([]byte(`{"SampledEvents":[]}`)))
+
+			req := newSchemaRequest("/schemas/event-version/version-1/metadata", map[string]string{"VersionID": "version-1"})
+			req.Header.Set("If-None-Match", `"stale"`)
+			rr := httptest.NewRecorder()
+			m.manager.GetSchemaVersionMetadata(rr, req)
+			Expect(rr.Code).To(Equal(http.StatusOK))
+			Expect(rr.Body.Len()).NotTo(Equal(0))
+		})
+
+		It("responds with 404 if the schema version doesn't exist", func() {
+			m.dbMock.ExpectQuery("SELECT metadata FROM schema_versions").
+				WillReturnRows(sqlmock.NewRows([]string{"metadata"}))
+
+			rr := httptest.NewRecorder()
+			m.manager.GetSchemaVersionMetadata(rr, newSchemaRequest("/schemas/event-version/missing-id/metadata", map[string]string{"VersionID": "missing-id"}))
+			Expect(rr.Code).To(Equal(http.StatusNotFound))
+		})
+	})
+
+	Context("GetSchemaVersionHash", func() {
+		schemaVersionColumns := []string{"id", "uuid", "event_model_id", "schema", "first_seen", "last_seen", "total_count"}
+
+		It("responds with the canonical hash of the schema version", func() {
+			schema := []byte(`{"b": "float64", "a": "string"}`)
+			m.dbMock.ExpectQuery("SELECT id, uuid, event_model_id, schema, first_seen, last_seen, total_count FROM schema_versions").
+				WillReturnRows(sqlmock.NewRows(schemaVersionColumns).AddRow(1, "version-1", "model-1", schema, time.Now(), time.Now(), 10))
+
+			rr := httptest.NewRecorder()
+			m.manager.GetSchemaVersionHash(rr, newSchemaRequest("/schemas/event-version/version-1/hash", map[string]string{"VersionID": "version-1"}))
+			Expect(rr.Code).To(Equal(http.StatusOK))
+			var body map[string]string
+			Expect(json.Unmarshal(rr.Body.Bytes(), &body)).To(Succeed())
+			Expect(body).To(Equal(map[string]string{
+				"VersionID":     "version-1",
+				"CanonicalHash": (&SchemaVersionT{Schema: schema}).CanonicalHash(),
+			}))
+		})
+
+		It("responds with 404 if the schema version doesn't exist", func() {
+			m.dbMock.ExpectQuery("SELECT id, uuid, event_model_id, schema, first_seen, last_seen, total_count FROM schema_versions").
+				WillReturnRows(sqlmock.NewRows(schemaVersionColumns))
+
+			rr := httptest.NewRecorder()
+			m.manager.GetSchemaVersionHash(rr, newSchemaRequest("/schemas/event-version/missing-id/hash", map[string]string{"VersionID": "missing-id"}))
+			Expect(rr.Code).To(Equal(http.StatusNotFound))
+		})
+	})
+
+	Context("with a write key allowlist", func() {
+		eventModelColumns := []string{"id", "uuid", "write_key", "event_type", "event_model_identifier", "created_at", "schema", "total_count", "last_seen"}
+
+		BeforeEach(func() {
+			writeKeyAllowlist = parseWriteKeyAllowlist([]string{adminUser + ":write-key"})
+		})
+
+		AfterEach(func() {
+			writeKeyAllowlist = map[string]map[string]bool{}
+		})
+
+		It("responds with 403 for the json schemas of a write key out of the allowlist", func() {
+			rr := httptest.NewRecorder()
+			m.manager.GetJsonSchemas(rr, newSchemaRequest("/schemas/json-schemas?WriteKey=forbidden-write-key", nil))
+			Expect(rr.Code).To(Equal(http.StatusForbidden))
+
+			rr = httptest.NewRecorder()
+			m.manager.GetJsonSchemas(rr, newSchemaRequest("/schemas/json-schemas", nil))
+			Expect(rr.Code).To(Equal(http.StatusForbidden))
+		})
+
+		It("responds with 403 for an event model of a write key out of the allowlist", func() {
+			m.dbMock.ExpectQuery("SELECT (.+) FROM event_models WHERE uuid").
+				WillReturnRows(sqlmock.NewRows(eventModelColumns).
+					AddRow(1, "event-1", "forbidden-write-key", "track", "logged_in", time.Now(), []byte(`{"a":"string"}`), 5, time.Now()))
+
+			rr := httptest.NewRecorder()
+			m.manager.GetEventModel(rr, newSchemaRequest("/schemas/event-model/event-1", map[string]string{"EventID": "event-1"}))
+			Expect(rr.Code).To(Equal(http.StatusForbidden))
+			Expect(rr.Body.String()).NotTo(ContainSubstring("logged_in"))
+		})
+
+		It("responds with 403 if any of the event models requested by id is of a write key out of the allowlist", func() {
+			m.dbMock.ExpectQuery("SELECT (.+) FROM event_models WHERE uuid = ANY").
+				WillReturnRows(sqlmock.NewRows(eventModelColumns).
+					AddRow(1, "event-1", "write-key", "track", "logged_in", time.Now(), []byte(`{"a":"string"}`), 5, time.Now()).
+					AddRow(2, "event-2", "forbidden-write-key", "track", "logged_out", time.Now(), []byte(`{"b":"string"}`), 3, time.Now()))
+
+			rr := httptest.NewRecorder()
+			m.manager.GetEventModelsByIDs(rr, newSchemaRequest("/schemas/event-models/by-ids?EventID=event-1&EventID=event-2", nil))
+			Expect(rr.Code).To(Equal(http.StatusForbidden))
+		})
+
+		It("looks up the write key of the event model before responding with its data", func() {
+			m.dbMock.ExpectQuery(`SELECT write_key FROM event_models WHERE uuid = \$1`).
+				WithArgs("event-1").
+				WillReturnRows(sqlmock.NewRows([]string{"write_key"}).AddRow("forbidden-write-key"))
+
+			rr := httptest.NewRecorder()
+			m.manager.GetEventVersions(rr, newSchemaRequest("/schemas/event-versions?EventID=event-1", nil))
+			Expect(rr.Code).To(Equal(http.StatusForbidden))
+
+			m.dbMock.ExpectQuery(`SELECT write_key FROM event_models WHERE uuid = \$1`).
+				WithArgs("event-1").
+				WillReturnRows(sqlmock.NewRows([]string{"write_key"}).AddRow("write-key"))
+			m.dbMock.ExpectQuery("SELECT metadata FROM event_models").
+				WillReturnRows(sqlmock.NewRows([]string{"metadata"}).AddRow([]byte(`{}`)))
+
+			rr = httptest.NewRecorder()
+			m.manager.GetEventModelMetadata(rr, newSchemaRequest("/schemas/event-model/event-1/metadata", map[string]string{"EventID": "event-1"}))
+			Expect(rr.Code).To(Equal(http.StatusOK))
+		})
+
+		It("looks up the write key of the event model of the schema version", func() {
+			m.dbMock.ExpectQuery(`SELECT event_models.write_key FROM schema_versions INNER JOIN event_models ON event_models.uuid = schema_versions.event_model_id WHERE schema_versions.uuid = \$1`).
+				WithArgs("version-1").
+				WillReturnRows(sqlmock.NewRows([]string{"write_key"}).AddRow("forbidden-write-key"))
+
+			rr := httptest.NewRecorder()
+			m.manager.GetSchemaVersionHash(rr, newSchemaRequest("/schemas/event-version/version-1/hash", map[string]string{"VersionID": "version-1"}))
+			Expect(rr.Code).To(Equal(http.StatusForbidden))
+
+			m.dbMock.ExpectQuery(`SELECT event_models.write_key FROM schema_versions`).
+				WithArgs("missing-id").
+				WillReturnRows(sqlmock.NewRows([]string{"write_key"}))
+
+			rr = httptest.NewRecorder()
+			m.manager.GetSchemaVersionMetadata(rr, newSchemaRequest("/schemas/event-version/missing-id/metadata", map[string]string{"VersionID": "missing-id"}))
+			Expect(rr.Code).To(Equal(http.StatusNotFound))
+		})
+
+		It("responds with 403 for recomputing the counts of all the event models", func() {
+			req := httptest.NewRequest(http.MethodPost, "/schemas/recompute-counts", nil)
+			req.SetBasicAuth(adminUser, adminPassword)
+			rr := httptest.NewRecorder()
+			m.manager.RecomputeCounts(rr, req)
+			Expect(rr.Code).To(Equal(http.StatusForbidden))
+		})
+	})
+})
+
+var _ = Describe("SchemaVersionT CanonicalHash", func() {
+	It("doesn't depend on the order of the keys", func() {
+		first := &SchemaVersionT{Schema: []byte(`{"a": "string", "b": "float64", "c.d": "bool"}`)}
+		second := &SchemaVersionT{Schema: []byte(`{"c.d": "bool", "b": "float64", "a": "string"}`)}
+		Expect(first.CanonicalHash()).To(HaveLen(64))
+		Expect(first.CanonicalHash()).To(Equal(second.CanonicalHash()))
+	})
+
+	It("changes with the keys or their types", func() {
+		hash := (&SchemaVersionT{Schema: []byte(`{"a": "string", "b": "float64"}`)}).CanonicalHash()
+		Expect((&SchemaVersionT{Schema: []byte(`{"a": "string", "b": "string"}`)}).CanonicalHash()).NotTo(Equal(hash))
+		Expect((&SchemaVersionT{Schema: []byte(`{"a": "string", "c": "float64"}`)}).CanonicalHash()).NotTo(Equal(hash))
+		Expect((&SchemaVersionT{Schema: []byte(`{"a": "string"}`)}).CanonicalHash()).NotTo(Equal(hash))
+	})
+
+	It("is empty for an invalid schema", func() {
+		Expect((&SchemaVersionT{Schema: []byte(`not json`)}).CanonicalHash()).To(BeEmpty())
+	})
+})
+
+var _ = Describe("EventSchemas export API", func() {
+	initEventSchemas()
+
+	m := newMockEventSchemaManager()
+
+	It("streams the schema versions as newline delimited json", func() {
+		m.dbMock.ExpectQuery("SELECT (.+) FROM schema_versions WHERE event_model_id = \\$1").WithArgs("event-1").
+			WillReturnRows(sqlmock.NewRows([]string{"id", "uuid", "event_model_id", "schema", "first_seen", "last_seen", "total_count"}).
+				AddRow(1, "version-1", "event-1", []byte(`{"a":"string"}`), time.Now(), time.Now(), 5).
+				AddRow(2, "version-2", "event-1", []byte(`{"b":"string"}`), time.Now(), time.Now(), 3))
+
+		rr := httptest.NewRecorder()
+		m.manager.ExportSchemaVersions(rr, newSchemaRequest("/schemas/event-versions/export?EventID=event-1", nil))
+		Expect(rr.Code).To(Equal(http.StatusOK))
+		Expect(rr.Header().Get("Content-Type")).To(Equal("application/x-ndjson"))
+
+		lines := strings.Split(strings.TrimSuffix(rr.Body.String(), "\n"), "\n")
+		Expect(lines).To(HaveLen(2))
+		Expect(lines[0]).To(ContainSubstring(`"VersionID":"version-1"`))
+		Expect(lines[1]).To(ContainSubstring(`"VersionID":"version-2"`))
+	})
+
+	It("responds with 400 if the EventID is missing", func() {
+		rr := httptest.NewRecorder()
+		m.manager.ExportSchemaVersions(rr, newSchemaRequest("/schemas/event-versions/export", nil))
+		Expect(rr.Code).To(Equal(http.StatusBadRequest))
+	})
+})
+
+var _ = Describe("EventSchemas recompute counts API", func() {
+	initEventSchemas()
+
+	m := newMockEventSchemaManager()
+
+	newRecomputeRequest := func(target string) *http.Request {
+		req := httptest.NewRequest(http.MethodPost, target, nil)
+		req.SetBasicAuth(adminUser, adminPassword)
+		return req
+	}
+
+	It("sets the total count of the event model to the sum of its schema versions' and responds with the counts before and after", func() {
+		reservoirSample := NewReservoirSampler(reservoirSampleSize, 0, 7)
+		m.manager.eventModelMap = EventModelMapT{"write-key": {"track": {"logged_in": {UUID: "event-1", TotalCount: 7, reservoirSample: reservoirSample}}}}
+
+		m.dbMock.ExpectBegin()
+		m.dbMock.ExpectQuery("SELECT uuid, total_count FROM event_models WHERE uuid = \\$1 FOR UPDATE").WithArgs("event-1").
+			WillReturnRows(sqlmock.NewRows([]string{"uuid", "total_count"}).AddRow("event-1", 7))
+		m.dbMock.ExpectQuery("UPDATE event_models SET total_count = (.+) FROM schema_versions WHERE event_model_id = \\$1").WithArgs("event-1").
+			WillReturnRows(sqlmock.NewRows([]string{"uuid", "total_count"}).AddRow("event-1", 12))
+		m.dbMock.ExpectCommit()
+
+		rr := httptest.NewRecorder()
+		m.manager.RecomputeCounts(rr, newRecomputeRequest("/schemas/event-models/recompute-counts?EventID=event-1"))
+		Expect(rr.Code).To(Equal(http.StatusOK))
+
+		var counts []*recomputedCountT
+		Expect(json.Unmarshal(rr.Body.Bytes(), &counts)).To(Succeed())
+		Expect(counts).To(Equal([]*recomputedCountT{{EventID: "event-1", Before: 7, After: 12}}))
+		Expect(reservoirSample.getTotalCount()).To(Equal(int64(12)))
+	})
+
+	It("recomputes all the event models without an EventID", func() {
+		m.dbMock.ExpectBegin()
+		m.dbMock.ExpectQuery("SELECT uuid, total_count FROM event_models FOR UPDATE").
+			WillReturnRows(sqlmock.NewRows([]string{"uuid", "total_count"}).AddRow("event-1", 7).AddRow("event-2", 3))
+		m.dbMock.ExpectQuery("UPDATE event_models SET total_count = (.+) FROM schema_versions GROUP BY event_model_id").
+			WillReturnRows(sqlmock.NewRows([]string{"uuid", "total_count"}).AddRow("event-2", 3).AddRow("event-1", 12))
+		m.dbMock.ExpectCommit()
+
+		rr := httptest.NewRecorder()
+		m.manager.RecomputeCounts(rr, newRecomputeRequest("/schemas/event-models/recompute-counts"))
+		Expect(rr.Code).To(Equal(http.StatusOK))
+
+		var counts []*recomputedCountT
+		Expect(json.Unmarshal(rr.Body.Bytes(), &counts)).To(Succeed())
+		Expect(counts).To(Equal([]*recomputedCountT{{EventID: "event-1", Before: 7, After: 12}, {EventID: "event-2", Before: 3, After: 3}}))
+	})
+
+	It("responds with 404 and rolls back if the event model doesn't exist", func() {
+		m.dbMock.ExpectBegin()
+		m.dbMock.ExpectQuery("SELECT uuid, total_count FROM event_models WHERE uuid = \\$1 FOR UPDATE").WithArgs("missing-id").
+			WillReturnRows(sqlmock.NewRows([]string{"uuid", "total_count"}))
+		m.dbMock.ExpectRollback()
+
+		rr := httptest.NewRecorder()
+		m.manager.RecomputeCounts(rr, newRecomputeRequest("/schemas/event-models/recompute-counts?EventID=missing-id"))
+		Expect(rr.Code).To(Equal(http.StatusNotFound))
+	})
+
+	It("responds with 500 and rolls back if the update fails", func() {
+		m.dbMock.ExpectBegin()
+		m.dbMock.ExpectQuery("SELECT uuid, total_count FROM event_models WHERE uuid = \\$1 FOR UPDATE").WithArgs("event-1").
+			WillReturnRows(sqlmock.NewRows([]string{"uuid", "total_count"}).AddRow("event-1", 7))
+		m.dbMock.ExpectQuery("UPDATE event_models").WithArgs("event-1").WillReturnError(errors.New("deadlock detected"))
+		m.dbMock.ExpectRollback()
+
+		rr := httptest.NewRecorder()
+		m.manager.RecomputeCounts(rr, newRecomputeRequest("/schemas/event-models/recompute-counts?EventID=event-1"))
+		Expect(rr.Code).To(Equal(http.StatusInternalServerError))
+		Expect(rr.Body.String()).To(ContainSubstring("logID"))
+	})
+
+	It("responds with 400 for a GET request", func() {
+		rr := httptest.NewRecorder()
+		m.manager.RecomputeCounts(rr, newSchemaRequest("/schemas/event-models/recompute-counts", nil))
+		Expect(rr.Code).To(Equal(http.StatusBadRequest))
+	})
+})
+
+var _ = Describe("EventSchemas key counts API", func() {
+	initEventSchemas()
+
+	m := newMockEventSchemaManager()
+
+	BeforeEach(func() {
+		m.dbMock.ExpectQuery("SELECT (.+) FROM schema_versions WHERE event_model_id").
+			WillReturnRows(sqlmock.NewRows([]string{"id", "uuid", "event_model_id", "schema", "first_seen", "last_seen", "total_count"}).
+				AddRow(1, "version-1", "event-1", []byte(`{"a":"string","b":"float64"}`), time.Now(), time.Now(), 5).
+				AddRow(2, "version-2", "event-1", []byte(`{"a":"string"}`), time.Now(), time.Now(), 3))
+	})
+
+	DescribeTable("responds with the key counts",
+		func(params string, csv bool, expectedBody string) {
+			rr := httptest.NewRecorder()
+			m.manager.GetKeyCounts(rr, newSchemaRequest("/schemas/event-model/event-1/key-counts"+params, map[string]string{"EventID": "event-1"}))
+			Expect(rr.Code).To(Equal(http.StatusOK))
+			if !csv {
+				Expect(rr.Body.String()).To(MatchJSON(expectedBody))
+				return
+			}
+			Expect(rr.Header().Get("Content-Type")).To(Equal("text/csv"))
+			Expect(rr.Body.String()).To(Equal(expectedBody))
+		},
+		Entry("as json by default", "", false, `{"a":8,"b":5}`),
+		Entry("as csv rows sorted by count when format=csv", "?format=csv", true, "key,count\na,8\nb,5\n"),
+		Entry("with the presence ratios of the keys when withPresence=true", "?withPresence=true", false, `{"a":{"Count":8,"PresenceRatio":1},"b":{"Count":5,"PresenceRatio":0.5}}`),
+		Entry("with a presence_ratio column in the csv when withPresence=true", "?format=csv&withPresence=true", true, "key,count,presence_ratio\na,8,1\nb,5,0.5\n"),
+	)
+})
+
+var _ = Describe("EventSchemas API cache", func() {
+	initEventSchemas()
+
+	m := newMockEventSchemaManager()
+
+	eventModelRows := func() *sqlmock.Rows {
+		return sqlmock.NewRows([]string{"id", "uuid", "write_key", "event_type", "event_model_identifier", "created_at", "schema", "total_count", "last_seen"}).
+			AddRow(1, "event-1", "write-key", "track", "logged_in", time.Now(), []byte(`{"a":"string"}`), 5, time.Now())
+	}
+
+	It("doesn't query the db again within the cache ttl", func() {
+		m.dbMock.ExpectQuery("SELECT (.+) FROM event_models WHERE write_key").WillReturnRows(eventModelRows())
+
+		for i := 0; i < 2; i++ {
+			rr := httptest.NewRecorder()
+			m.manager.GetEventModels(rr, newSchemaRequest("/schemas/event-models?WriteKey=write-key", nil))
+			Expect(rr.Code).To(Equal(http.StatusOK))
+			Expect(rr.Body.String()).To(ContainSubstring(`"EventID":"event-1"`))
+		}
+	})
+
+	It("queries the db when noCache=true is passed", func() {
+		m.dbMock.ExpectQuery("SELECT (.+) FROM event_models WHERE write_key").WillReturnRows(eventModelRows())
+		m.dbMock.ExpectQuery("SELECT (.+) FROM event_models WHERE write_key").WillReturnRows(eventModelRows())
+
+		rr := httptest.NewRecorder()
+		m.manager.GetEventModels(rr, newSchemaRequest("/schemas/event-models?WriteKey=write-key", nil))
+		Expect(rr.Code).To(Equal(http.StatusOK))
+
+		rr = httptest.NewRecorder()
+		m.manager.GetEventModels(rr, newSchemaRequest("/schemas/event-models?WriteKey=write-key&noCache=true", nil))
+		Expect(rr.Code).To(Equal(http.StatusOK))
+	})
+
+	It("queries the db again once the cache is invalidated", func() {
+		m.dbMock.ExpectQuery("SELECT (.+) FROM schema_versions WHERE event_model_id").
+			WillReturnRows(sqlmock.NewRows([]string{"id", "uuid", "event_model_id", "schema", "first_seen", "last_seen", "total_count"}))
+		m.dbMock.ExpectQuery("SELECT (.+) FROM schema_versions WHERE event_model_id").
+			WillReturnRows(sqlmock.NewRows([]string{"id", "uuid", "event_model_id", "schema", "first_seen", "last_seen", "total_count"}))
+
+		m.manager.getSchemaVersionsByEventID("event-1", false)
+		m.manager.getSchemaVersionsByEventID("event-1", false)
+		m.manager.apiCache.invalidate()
+		m.manager.getSchemaVersionsByEventID("event-1", false)
+	})
+
+	It("doesn't cache failed fetches", func() {
+		m.dbMock.ExpectQuery("SELECT (.+) FROM event_models WHERE write_key").WillReturnError(errors.New("connection reset by peer"))
+		m.dbMock.ExpectQuery("SELECT (.+) FROM event_models WHERE write_key").WillReturnRows(eventModelRows())
+
+		rr := httptest.NewRecorder()
+		m.manager.GetEventModels(rr, newSchemaRequest("/schemas/event-models?WriteKey=write-key", nil))
+		Expect(rr.Code).To(Equal(http.StatusInternalServerError))
+
+		rr = httptest.NewRecorder()
+		m.manager.GetEventModels(rr, newSchemaRequest("/schemas/event-models?WriteKey=write-key", nil))
+		Expect(rr.Code).To(Equal(http.StatusOK))
+	})
+})
+
+var _ = Describe("EventSchemas basic auth", func() {
+	initEventSchemas()
+
+	It("parses comma separated admin credentials", func() {
+		Expect(parseAdminCredentials("")).To(BeEmpty())
+		Expect(parseAdminCredentials("ops:secret, audit:pass:word,invalid,:nouser")).To(Equal(map[string]string{
+			"ops":   "secret",
+			"audit": "pass:word",
+		}))
+	})
+
+	It("parses user:writeKey allowlist entries", func() {
+		Expect(parseWriteKeyAllowlist(nil)).To(BeEmpty())
+		Expect(parseWriteKeyAllowlist([]string{"ops:key-1", " ops:key-2", "audit:key-1", "invalid", ":nouser", "nokey:"})).To(Equal(map[string]map[string]bool{
+			"ops":   {"key-1": true, "key-2": true},
+			"audit": {"key-1": true},
+		}))
+	})
+
+	It("accepts any of the configured admin credentials", func() {
+		adminCredentials = map[string]string{adminUser: adminPassword, "ops": "secret"}
+		defer func() { adminCredentials = map[string]string{adminUser: adminPassword} }()
+
+		req := httptest.NewRequest(http.MethodGet, "/schemas/event-models", nil)
+		req.SetBasicAuth("ops", "secret")
+		Expect(handleBasicAuth(req)).To(BeNil())
+
+		req.SetBasicAuth(adminUser, adminPassword)
+		Expect(handleBasicAuth(req)).To(BeNil())
+
+		req.SetBasicAuth("ops", adminPassword)
+		Expect(handleBasicAuth(req)).NotTo(BeNil())
+
+		req = httptest.NewRequest(http.MethodGet, "/schemas/event-models", nil)
+		Expect(handleBasicAuth(req)).NotTo(BeNil())
+	})
+})
+
+var _ = Describe("EventSchemas API concurrency limit", func() {
+	initEventSchemas()
+
+	m := newMockEventSchemaManager()
+
+	BeforeEach(func() {
+		m.manager.querySemaphore = make(chan struct{}, 1)
+	})
+
+	It("responds with 429 without querying the db if all the query slots are taken", func() {
+		m.manager.querySemaphore <- struct{}{}
+
+		rr := httptest.NewRecorder()
+		m.manager.GetEventModels(rr, newSchemaRequest("/schemas/event-models?WriteKey=write-key&noCache=true", nil))
+		Expect(rr.Code).To(Equal(http.StatusTooManyRequests))
+	})
+
+	It("rejects the unauthenticated requests before taking a query slot", func() {
+		m.manager.querySemaphore <- struct{}{}
+
+		rr := httptest.NewRecorder()
+		m.manager.GetEventModels(rr, httptest.NewRequest(http.MethodGet, "/schemas/event-models?WriteKey=write-key&noCache=true", nil))
+		Expect(rr.Code).To(Equal(http.StatusBadRequest))
+	})
+
+	It("releases the query slot once the request is served", func() {
+		for i := 0; i < 2; i++ {
+			m.dbMock.ExpectQuery("SELECT (.+) FROM event_models WHERE write_key").
+				WillReturnRows(sqlmock.NewRows([]string{"id", "uuid", "write_key", "event_type", "event_model_identifier", "created_at", "schema", "total_count", "last_seen"}))
+
+			rr := httptest.NewRecorder()
+			m.manager.GetEventModels(rr, newSchemaRequest("/schemas/event-models?WriteKey=write-key&noCache=true", nil))
+			Expect(rr.Code).To(Equal(http.StatusOK))
+		}
+		Expect(m.manager.querySemaphore).To(BeEmpty())
+	})
+})
+
+var _ = Describe("ImportEventModels", func() {
+	initEventSchemas()
+
+	m := newMockEventSchemaManager()
+
+	modelsInsertSQL := regexp.QuoteMeta("INSERT INTO event_models (uuid, write_key, event_type, event_model_identifier, created_at, schema, metadata, private_data, last_seen, total_count, archived) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11) ON CONFLICT (uuid) DO NOTHING")
+	versionsInsertSQL := regexp.QuoteMeta("INSERT INTO schema_versions (uuid, event_model_id, schema_hash, schema, metadata, private_data, first_seen, last_seen, total_count, archived) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10) ON CONFLICT DO NOTHING")
+	existingModelsSQL := regexp.QuoteMeta("SELECT uuid FROM event_models WHERE uuid = ANY($1)")
+
+	It("imports the models along with the versions of imported and existing models in a transaction", func() {
+		lastSeen := time.Now()
+		models := []*EventModelT{{UUID: "model-1", WriteKey: "write-key", EventType: "track", EventIdentifier: "signed_up", Schema: []byte(`{"a":"string"}`), LastSeen: lastSeen, TotalCount: 5}}
+		versions := []*SchemaVersionT{
+			{UUID: "version-1", EventModelID: "model-1", Schema: []byte(`{"a":"string"}`), LastSeen: lastSeen, TotalCount: 5},
+			{UUID: "version-2", EventModelID: "model-2", SchemaHash: "hash-2", Schema: []byte(`{"b":"string"}`), LastSeen: lastSeen, TotalCount: 3},
+		}
+
+		m.dbMock.ExpectBegin()
+		m.dbMock.ExpectQuery(existingModelsSQL).WithArgs("{\"model-2\"}").
+			WillReturnRows(sqlmock.NewRows([]string{"uuid"}).AddRow("model-2"))
+		m.dbMock.ExpectExec(modelsInsertSQL).
+			WithArgs("model-1", "write-key", "track", "signed_up", sqlmock.AnyArg(), `{"a":"string"}`, "{}", "{}", lastSeen, int64(5), false).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		m.dbMock.ExpectExec(versionsInsertSQL).
+			WithArgs("version-1", "model-1", getSchemaHash(map[string]string{"a": "string"}), `{"a":"string"}`, "{}", "{}", sqlmock.AnyArg(), lastSeen, int64(5), false).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		m.dbMock.ExpectExec(versionsInsertSQL).
+			WithArgs("version-2", "model-2", "hash-2", `{"b":"string"}`, "{}", "{}", sqlmock.AnyArg(), lastSeen, int64(3), false).
+			WillReturnResult(sqlmock.NewResult(0, 0))
+		m.dbMock.ExpectCommit()
+
+		Expect(m.manager.ImportEventModels(models, versions)).To(Succeed())
+	})
+
+	It("registers the imported models and versions to be reloaded once their events are seen", func() {
+		previousModels, previousVersions := offloadedEventModels, offloadedSchemaVersions
+		offloadedEventModels = make(map[string]map[string]*OffloadedModelT)
+		offloadedSchemaVersions = make(map[string]map[string]*OffloadedSchemaVersionT)
+		defer func() {
+			offloadedEventModels, offloadedSchemaVersions = previousModels, previousVersions
+		}()
+
+		m.dbMock.ExpectBegin()
+		m.dbMock.ExpectExec(modelsInsertSQL).WillReturnResult(sqlmock.NewResult(0, 1))
+		m.dbMock.ExpectExec(modelsInsertSQL).WillReturnResult(sqlmock.NewResult(0, 0))
+		m.dbMock.ExpectExec(versionsInsertSQL).WillReturnResult(sqlmock.NewResult(0, 1))
+		m.dbMock.ExpectCommit()
+
+		Expect(m.manager.ImportEventModels(
+			[]*EventModelT{
+				{UUID: "model-1", WriteKey: "write-key", EventType: "track", EventIdentifier: "signed_up"},
+				{UUID: "model-2", WriteKey: "write-key", EventType: "identify"},
+			},
+			[]*SchemaVersionT{{UUID: "version-1", EventModelID: "model-1", SchemaHash: "hash-1", Schema: []byte(`{"a":"string"}`)}},
+		)).To(Succeed())
+
+		Expect(offloadedEventModels["write-key"]).To(HaveLen(1))
+		Expect(offloadedEventModels["write-key"][eventTypeIdentifier("track", "signed_up")].UUID).To(Equal("model-1"))
+		Expect(offloadedSchemaVersions["model-1"]["hash-1"].UUID).To(Equal("version-1"))
+	})
+
+	It("imports nothing if a version references an unknown model", func() {
+		m.dbMock.ExpectBegin()
+		m.dbMock.ExpectQuery(existingModelsSQL).WithArgs("{\"model-2\"}").
+			WillReturnRows(sqlmock.NewRows([]string{"uuid"}))
+		m.dbMock.ExpectRollback()
+
+		err := m.manager.ImportEventModels(
+			[]*EventModelT{{UUID: "model-1", WriteKey: "write-key", EventType: "identify"}},
+			[]*SchemaVersionT{{UUID: "version-2", EventModelID: "model-2", SchemaHash: "hash-2"}},
+		)
+		Expect(err).To(MatchError(ContainSubstring("unknown event model: model-2")))
+	})
+
+	It("rolls back if an insert fails", func() {
+		m.dbMock.ExpectBegin()
+		m.dbMock.ExpectExec(modelsInsertSQL).WillReturnError(errors.New("insert failed"))
+		m.dbMock.ExpectRollback()
+
+		err := m.manager.ImportEventModels([]*EventModelT{{UUID: "model-1", WriteKey: "write-key", EventType: "identify"}}, nil)
+		Expect(err).To(MatchError(ContainSubstring("insert failed")))
+	})
+
+	It("fails without touching the db if a version without a schema hash has an invalid schema", func() {
+		err := m.manager.ImportEventModels(nil, []*SchemaVersionT{{UUID: "version-1", EventModelID: "model-1", Schema: []byte(`not json`)}})
+		Expect(err).To(MatchError(ContainSubstring("invalid schema")))
+	})
+})
@@ -0,0 +1,170 @@
+package event_schema
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+
+	"github.com/rudderlabs/rudder-server/utils/timeutil"
+)
+
+// ImportEventModels inserts event models and their schema versions, e.g. exported from another environment to seed this one,
+// in a single transaction. The models whose uuid already exists, and the versions whose uuid or event model and schema hash do,
+// are kept as they are, so importing is idempotent.
+// Every version must belong to one of the models imported or to an existing one, otherwise nothing is imported.
+// The versions missing their schema hash, which isn't exported, get the one of their schema.
+func (manager *EventSchemaManagerT) ImportEventModels(models []*EventModelT, versions []*SchemaVersionT) error {
+	importedModelIDs := make(map[string]bool, len(models))
+	for _, model := range models {
+		if model.UUID == "" {
+			return fmt.Errorf("event model of writeKey: %s, eventType: %s, eventIdentifier: %s is missing its uuid", model.WriteKey, model.EventType, model.EventIdentifier)
+		}
+		importedModelIDs[model.UUID] = true
+	}
+
+	schemaHashes := make([]string, len(versions))
+	var referencedModelIDs []string
+	for i, version := range versions {
+		if version.UUID == "" {
+			return fmt.Errorf("schema version of event model: %s is missing its uuid", version.EventModelID)
+		}
+		schemaHashes[i] = version.SchemaHash
+		if schemaHashes[i] == "" {
+			var schema map[string]string
+			if err := json.Unmarshal(version.Schema, &schema); err != nil {
+				return fmt.Errorf("schema version: %s has an invalid schema: %w", version.UUID, err)
+			}
+			schemaHashes[i] = getSchemaHash(schema)
+		}
+		if !importedModelIDs[version.EventModelID] {
+			referencedModelIDs = append(referencedModelIDs, version.EventModelID)
+		}
+	}
+
+	txn, err := manager.dbHandle.Begin()
+	if err != nil {
+		return err
+	}
+	defer txn.Rollback()
+
+	if len(referencedModelIDs) > 0 {
+		existingModelIDs := make(map[string]bool)
+		rows, err := txn.Query(fmt.Sprintf(`SELECT uuid FROM %s WHERE uuid = ANY($1)`, EVENT_MODELS_TABLE), pq.Array(referencedModelIDs))
+		if err != nil {
+			return err
+		}
+		for rows.Next() {
+			var modelID string
+			if err := rows.Scan(&modelID); err != nil {
+				rows.Close()
+				return err
+			}
+			existingModelIDs[modelID] = true
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		for _, modelID := range referencedModelIDs {
+			if !existingModelIDs[modelID] {
+				return fmt.Errorf("schema versions reference the unknown event model: %s", modelID)
+			}
+		}
+	}
+
+	insertedModels := make([]*EventModelT, 0, len(models))
+	modelsInsertSQL := fmt.Sprintf(`INSERT INTO %s (uuid, write_key, event_type, event_model_identifier, created_at, schema, metadata, private_data, last_seen, total_count, archived) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11) ON CONFLICT (uuid) DO NOTHING`, EVENT_MODELS_TABLE)
+	for _, model := range models {
+		result, err := txn.Exec(modelsInsertSQL, model.UUID, model.WriteKey, model.EventType, model.EventIdentifier, timeOrNow(model.CreatedAt),
+			jsonOrEmptyObject(model.Schema), jsonOrEmptyObject(model.Metadata), jsonOrEmptyObject(model.PrivateData), timeOrNow(model.LastSeen), model.TotalCount, model.Archived)
+		if err != nil {
+			return fmt.Errorf("importing event model: %s: %w", model.UUID, err)
+		}
+		if inserted, _ := result.RowsAffected(); inserted > 0 {
+			insertedModels = append(insertedModels, model)
+		}
+	}
+
+	insertedVersions := make([]*OffloadedSchemaVersionT, 0, len(versions))
+	versionsInsertSQL := fmt.Sprintf(`INSERT INTO %s (uuid, event_model_id, schema_hash, schema, metadata, private_data, first_seen, last_seen, total_count, archived) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10) ON CONFLICT DO NOTHING`, SCHEMA_VERSIONS_TABLE)
+	for i, version := range versions {
+		result, err := txn.Exec(versionsInsertSQL, version.UUID, version.EventModelID, schemaHashes[i], jsonOrEmptyObject(version.Schema),
+			jsonOrEmptyObject(version.Metadata), jsonOrEmptyObject(version.PrivateData), timeOrNow(version.FirstSeen), timeOrNow(version.LastSeen), version.TotalCount, version.Archived)
+		if err != nil {
+			return fmt.Errorf("importing schema version: %s: %w", version.UUID, err)
+		}
+		if inserted, _ := result.RowsAffected(); inserted > 0 {
+			insertedVersions = append(insertedVersions, &OffloadedSchemaVersionT{UUID: version.UUID, EventModelID: version.EventModelID, LastSeen: timeOrNow(version.LastSeen), SchemaHash: schemaHashes[i]})
+		}
+	}
+
+	if err := txn.Commit(); err != nil {
+		return err
+	}
+	manager.registerImported(insertedModels, insertedVersions)
+	manager.apiCache.invalidate()
+	return nil
+}
+
+// registerImported adds the imported models and versions which aren't known yet to the offloaded ones,
+// so that they are reloaded from the db once their events are seen, instead of new ones being created for them.
+// It is a noop if the in-memory cache isn't set up.
+func (manager *EventSchemaManagerT) registerImported(models []*EventModelT, versions []*OffloadedSchemaVersionT) {
+	manager.eventModelLock.Lock()
+	defer manager.eventModelLock.Unlock()
+	manager.schemaVersionLock.Lock()
+	defer manager.schemaVersionLock.Unlock()
+
+	if offloadedEventModels == nil || offloadedSchemaVersions == nil {
+		return
+	}
+
+	for _, model := range models {
+		key := eventTypeIdentifier(model.EventType, model.EventIdentifier)
+		if _, ok := manager.eventModelMap[WriteKey(model.WriteKey)][EventType(model.EventType)][EventIdentifier(model.EventIdentifier)]; ok {
+			continue
+		}
+		if _, ok := offloadedEventModels[model.WriteKey][key]; ok {
+			continue
+		}
+		if _, ok := archivedEventModels[model.WriteKey][key]; ok {
+			continue
+		}
+		if _, ok := offloadedEventModels[model.WriteKey]; !ok {
+			offloadedEventModels[model.WriteKey] = make(map[string]*OffloadedModelT)
+		}
+		offloadedEventModels[model.WriteKey][key] = &OffloadedModelT{UUID: model.UUID, LastSeen: timeOrNow(model.LastSeen), WriteKey: model.WriteKey, EventType: model.EventType, EventIdentifier: model.EventIdentifier}
+	}
+
+	for _, version := range versions {
+		if _, ok := manager.schemaVersionMap[version.EventModelID][version.SchemaHash]; ok {
+			continue
+		}
+		if _, ok := offloadedSchemaVersions[version.EventModelID][version.SchemaHash]; ok {
+			continue
+		}
+		if _, ok := archivedSchemaVersions[version.EventModelID][version.SchemaHash]; ok {
+			continue
+		}
+		if _, ok := offloadedSchemaVersions[version.EventModelID]; !ok {
+			offloadedSchemaVersions[version.EventModelID] = make(map[string]*OffloadedSchemaVersionT)
+		}
+		offloadedSchemaVersions[version.EventModelID][version.SchemaHash] = version
+	}
+}
+
+func jsonOrEmptyObject(data json.RawMessage) string {
+	if len(data) == 0 {
+		return "{}"
+	}
+	return string(data)
+}
+
+func timeOrNow(t time.Time) time.Time {
+	if t.IsZero() {
+		return timeutil.Now()
+	}
+	return t
+}
@@ -63,3 +63,10 @@ func (rs *ReservoirSample) getTotalCount() int64 {
 
 	return rs.totalCount
 }
+
+func (rs *ReservoirSample) setTotalCount(totalCount int64) {
+	rs.lock.Lock()
+	defer rs.lock.Unlock()
+
+	rs.totalCount = totalCount
+}
@@ -1390,12 +1390,17 @@ func (gateway *HandleT) StartWebHandler(ctx context.Context) error {
 
 	if enableEventSchemasFeature {
 		srvMux.HandleFunc("/schemas/event-models", gateway.eventSchemaWebHandler(gateway.eventSchemaHandler.GetEventModels)).Methods("GET")
+		srvMux.HandleFunc("/schemas/event-model/{EventID}", gateway.eventSchemaWebHandler(gateway.eventSchemaHandler.GetEventModel)).Methods("GET")
+		srvMux.HandleFunc("/schemas/event-models/by-ids", gateway.eventSchemaWebHandler(gateway.eventSchemaHandler.GetEventModelsByIDs)).Methods("GET", "POST")
 		srvMux.HandleFunc("/schemas/event-versions", gateway.eventSchemaWebHandler(gateway.eventSchemaHandler.GetEventVersions)).Methods("GET")
+		srvMux.HandleFunc("/schemas/event-versions/export", gateway.eventSchemaWebHandler(gateway.eventSchemaHandler.ExportSchemaVersions)).Methods("GET")
 		srvMux.HandleFunc("/schemas/event-model/{EventID}/key-counts", gateway.eventSchemaWebHandler(gateway.eventSchemaHandler.GetKeyCounts)).Methods("GET")
 		srvMux.HandleFunc("/schemas/event-model/{EventID}/metadata", gateway.eventSchemaWebHandler(gateway.eventSchemaHandler.GetEventModelMetadata)).Methods("GET")
 		srvMux.HandleFunc("/schemas/event-version/{VersionID}/metadata", gateway.eventSchemaWebHandler(gateway.eventSchemaHandler.GetSchemaVersionMetadata)).Methods("GET")
 		srvMux.HandleFunc("/schemas/event-version/{VersionID}/missing-keys", gateway.eventSchemaWebHandler(gateway.eventSchemaHandler.GetSchemaVersionMissingKeys)).Methods("GET")
+		srvMux.HandleFunc("/schemas/event-version/{VersionID}/hash", gateway.eventSchemaWebHandler(gateway.eventSchemaHandler.GetSchemaVersionHash)).Methods("GET")
 		srvMux.HandleFunc("/schemas/event-models/json-schemas", gateway.eventSchemaWebHandler(gateway.eventSchemaHandler.GetJsonSchemas)).Methods("GET")
+		srvMux.HandleFunc("/schemas/event-models/recompute-counts", gateway.eventSchemaWebHandler(gateway.eventSchemaHandler.RecomputeCounts)).Methods("POST")
 	}
 
 	//todo: remove in next release
@@ -0,0 +1,61 @@
+package jobsdb
+
+import (
+	"errors"
+	"fmt"
+)
+
+//Errors returned by the public jobsdb methods.
+//Underlying db errors are wrapped along with these, so that callers can check for either using errors.Is / errors.As
+var (
+	ErrStoreBeginFailed   = errors.New("failed to begin store transaction")
+	ErrStorePrepareFailed = errors.New("failed to prepare store statement")
+	ErrStoreExecFailed    = errors.New("failed to execute store statement")
+	ErrStoreCommitFailed  = errors.New("failed to commit store transaction")
+	//ErrWriterQueueFull is returned by Store when the writer queue is full, if JobsDB.writerQueueNonBlocking is true
+	ErrWriterQueueFull = errors.New("writer queue is full")
+
+	ErrUpdateJobStatusPrepareFailed = errors.New("failed to prepare job status statement")
+	ErrUpdateJobStatusExecFailed    = errors.New("failed to execute job status statement")
+	//ErrUpsertJobStatusDisabled is returned by UpsertJobStatus, unless JobsDB.upsertJobStatus is true
+	ErrUpsertJobStatusDisabled = errors.New("job status upserts are disabled")
+
+	ErrInvalidJSON = errors.New("Invalid JSON")
+	//ErrContainsNullBytes is returned for the jobs whose payload contains null bytes, if JobsDB.nullByteStrategy is reject
+	ErrContainsNullBytes = errors.New("contains_null_bytes")
+	//ErrInvalidJob is returned for the jobs failing validation, if JobsDB.validateBeforeStore is true
+	ErrInvalidJob = errors.New("invalid job")
+
+	ErrDataSetNotFound = errors.New("dataset not found")
+	ErrJobIDOutOfRange = errors.New("job_id out of dataset range")
+	ErrJobNotFound     = errors.New("job not found")
+
+	ErrInvalidParameterFilter = errors.New("invalid parameter filter name")
+	//ErrInvalidQuery is returned by QueryBuilder.Build for query params which are missing the limit or are inconsistent
+	ErrInvalidQuery = errors.New("invalid query")
+
+	//ErrClosed is returned for the requests made after TearDown
+	ErrClosed = errors.New("jobsdb is closed")
+)
+
+//opError wraps an underlying error with the sentinel error of the failed operation
+type opError struct {
+	op  error
+	err error
+}
+
+func wrapOpError(op, err error) error {
+	return &opError{op: op, err: err}
+}
+
+func (e *opError) Error() string {
+	return fmt.Sprintf("%s: %s", e.op, e.err)
+}
+
+func (e *opError) Unwrap() error {
+	return e.err
+}
+
+func (e *opError) Is(target error) bool {
+	return target == e.op
+}
@@ -158,21 +158,23 @@ func TestJobsDB(t *testing.T) {
 		CustomVal:    customVal,
 	}
 
-	unprocessedListEmpty := jobDB.GetUnprocessed(jobsdb.GetQueryParamsT{
+	unprocessedListEmpty, err := jobDB.GetUnprocessed(jobsdb.GetQueryParamsT{
 		CustomValFilters: []string{customVal},
 		JobCount:         1,
 		ParameterFilters: []jobsdb.ParameterFilterT{},
 	})
+	require.NoError(t, err)
 
 	require.Equal(t, 0, len(unprocessedListEmpty))
-	err := jobDB.Store([]*jobsdb.JobT{&sampleTestJob})
+	err = jobDB.Store([]*jobsdb.JobT{&sampleTestJob})
 	require.NoError(t, err)
 
-	unprocessedList := jobDB.GetUnprocessed(jobsdb.GetQueryParamsT{
+	unprocessedList, err := jobDB.GetUnprocessed(jobsdb.GetQueryParamsT{
 		CustomValFilters: []string{customVal},
 		JobCount:         1,
 		ParameterFilters: []jobsdb.ParameterFilterT{},
 	})
+	require.NoError(t, err)
 	require.Equal(t, 1, len(unprocessedList))
 
 	status := jobsdb.JobStatusT{
@@ -190,11 +192,12 @@ func TestJobsDB(t *testing.T) {
 	err = jobDB.UpdateJobStatus([]*jobsdb.JobStatusT{&status}, []string{customVal}, []jobsdb.ParameterFilterT{})
 	require.NoError(t, err)
 
-	unprocessedList = jobDB.GetUnprocessed(jobsdb.GetQueryParamsT{
+	unprocessedList, err = jobDB.GetUnprocessed(jobsdb.GetQueryParamsT{
 		CustomValFilters: []string{customVal},
 		JobCount:         1,
 		ParameterFilters: []jobsdb.ParameterFilterT{},
 	})
+	require.NoError(t, err)
 
 	require.Equal(t, 0, len(unprocessedList))
 
@@ -213,20 +216,22 @@ func TestJobsDB(t *testing.T) {
 		}
 
 		t.Log("GetUnprocessed with job count limit")
-		JobLimitList := jobDB.GetUnprocessed(jobsdb.GetQueryParamsT{
+		JobLimitList, err := jobDB.GetUnprocessed(jobsdb.GetQueryParamsT{
 			CustomValFilters: []string{customVal},
 			JobCount:         100,
 			ParameterFilters: []jobsdb.ParameterFilterT{},
 		})
+		require.NoError(t, err)
 		require.Equal(t, jobCount, len(JobLimitList))
 
 		t.Log("GetUnprocessed with event count limit")
-		eventLimitList := jobDB.GetUnprocessed(jobsdb.GetQueryParamsT{
+		eventLimitList, err := jobDB.GetUnprocessed(jobsdb.GetQueryParamsT{
 			CustomValFilters: []string{customVal},
 			JobCount:         100,
 			EventCount:       eventsPerJob * 20,
 			ParameterFilters: []jobsdb.ParameterFilterT{},
 		})
+		require.NoError(t, err)
 		require.Equal(t, 20, len(eventLimitList))
 		t.Log("GetUnprocessed jobs should have the expected event count")
 		for _, j := range eventLimitList {
@@ -234,12 +239,13 @@ func TestJobsDB(t *testing.T) {
 		}
 
 		t.Log("Repeat read")
-		eventLimitListRepeat := jobDB.GetUnprocessed(jobsdb.GetQueryParamsT{
+		eventLimitListRepeat, err := jobDB.GetUnprocessed(jobsdb.GetQueryParamsT{
 			CustomValFilters: []string{customVal},
 			JobCount:         100,
 			EventCount:       eventsPerJob * 20,
 			ParameterFilters: []jobsdb.ParameterFilterT{},
 		})
+		require.NoError(t, err)
 		require.Equal(t, 20, len(eventLimitListRepeat))
 		require.Equal(t, eventLimitList, eventLimitListRepeat)
 
@@ -263,24 +269,66 @@ func TestJobsDB(t *testing.T) {
 		require.NoError(t, err)
 
 		t.Log("GetUnprocessed with job count limit")
-		retryJobLimitList := jobDB.GetToRetry(jobsdb.GetQueryParamsT{
+		retryJobLimitList, err := jobDB.GetToRetry(jobsdb.GetQueryParamsT{
 			CustomValFilters: []string{customVal},
 			JobCount:         100,
 		})
+		require.NoError(t, err)
 		require.Equal(t, jobCount, len(retryJobLimitList))
 
 		t.Log("GetToRetry with event count limit")
-		retryEventLimitList := jobDB.GetToRetry(jobsdb.GetQueryParamsT{
+		retryEventLimitList, err := jobDB.GetToRetry(jobsdb.GetQueryParamsT{
 			CustomValFilters: []string{customVal},
 			JobCount:         100,
 			EventCount:       eventsPerJob * 20,
 		})
+		require.NoError(t, err)
 		require.Equal(t, 20, len(retryEventLimitList))
 		t.Log("GetToRetry jobs should have the expected event count")
 		for _, j := range eventLimitList {
 			require.Equal(t, eventsPerJob, j.EventCount)
 		}
 
+		t.Log("GetWaiting should not return failed jobs")
+		waitingJobList := jobDB.GetWaiting(jobsdb.GetQueryParamsT{
+			CustomValFilters: []string{customVal},
+			JobCount:         100,
+		})
+		require.Equal(t, 0, len(waitingJobList))
+
+		for i := range statuses {
+			statuses[i] = &jobsdb.JobStatusT{
+				JobID:         JobLimitList[i].JobID,
+				JobState:      jobsdb.Waiting.State,
+				AttemptNum:    1,
+				ExecTime:      n,
+				RetryTime:     n,
+				ErrorResponse: []byte(`{"success":"OK"}`),
+				Parameters:    []byte(`{}`),
+				WorkspaceId:   "testWorkspace",
+			}
+		}
+		t.Log("Mark all jobs as waiting")
+		err = jobDB.UpdateJobStatus(statuses, []string{customVal}, []jobsdb.ParameterFilterT{})
+		require.NoError(t, err)
+
+		t.Log("GetWaiting with job count limit")
+		waitingJobList = jobDB.GetWaiting(jobsdb.GetQueryParamsT{
+			CustomValFilters: []string{customVal},
+			JobCount:         100,
+		})
+		require.Equal(t, jobCount, len(waitingJobList))
+		for _, j := range waitingJobList {
+			require.Equal(t, jobsdb.Waiting.State, j.LastJobStatus.JobState)
+		}
+
+		t.Log("GetToRetry should not return waiting jobs")
+		retryJobLimitList, err = jobDB.GetToRetry(jobsdb.GetQueryParamsT{
+			CustomValFilters: []string{customVal},
+			JobCount:         100,
+		})
+		require.NoError(t, err)
+		require.Equal(t, 0, len(retryJobLimitList))
 	})
 
 	t.Run("DSoverflow", func(t *testing.T) {
@@ -316,22 +364,24 @@ func TestJobsDB(t *testing.T) {
 		t.Log("Using event count that will cause spill-over, not exact for ds1, but remainder suitable for ds2")
 		trickyEventCount := (eventsPerJob_ds1 * (jobCountPerDS - 1)) + eventsPerJob_ds2
 
-		eventLimitList := jobDB.GetUnprocessed(jobsdb.GetQueryParamsT{
+		eventLimitList, err := jobDB.GetUnprocessed(jobsdb.GetQueryParamsT{
 			CustomValFilters: []string{customVal},
 			JobCount:         100,
 			EventCount:       trickyEventCount,
 			ParameterFilters: []jobsdb.ParameterFilterT{},
 		})
+		require.NoError(t, err)
 		requireSequential(t, eventLimitList)
 		require.Equal(t, jobCountPerDS, len(eventLimitList))
 
 		t.Log("Prepare GetToRetry")
 		{
-			allJobs := jobDB.GetUnprocessed(jobsdb.GetQueryParamsT{
+			allJobs, err := jobDB.GetUnprocessed(jobsdb.GetQueryParamsT{
 				CustomValFilters: []string{customVal},
 				JobCount:         1000,
 				ParameterFilters: []jobsdb.ParameterFilterT{},
 			})
+			require.NoError(t, err)
 
 			statuses := make([]*jobsdb.JobStatusT, len(allJobs))
 			n := time.Now().Add(time.Hour * -1)
@@ -354,17 +404,186 @@ func TestJobsDB(t *testing.T) {
 
 		t.Log("Test spill over with GetToRetry")
 		{
-			eventLimitList := jobDB.GetToRetry(jobsdb.GetQueryParamsT{
+			eventLimitList, err := jobDB.GetToRetry(jobsdb.GetQueryParamsT{
 				CustomValFilters: []string{customVal},
 				JobCount:         100,
 				EventCount:       trickyEventCount,
 				ParameterFilters: []jobsdb.ParameterFilterT{},
 			})
+			require.NoError(t, err)
 			requireSequential(t, eventLimitList)
 			require.Equal(t, jobCountPerDS, len(eventLimitList))
 		}
 
 	})
+
+	t.Run("MarkWaiting", func(t *testing.T) {
+		customVal := "MOCKDS"
+
+		jobDB := jobsdb.HandleT{}
+		jobDB.Setup(jobsdb.ReadWrite, false, "rt", dbRetention, migrationMode, true, queryFilters)
+		defer jobDB.TearDown()
+
+		require.NoError(t, jobDB.Store(genJobs(customVal, 2, 1)))
+		jobs, err := jobDB.GetUnprocessed(jobsdb.GetQueryParamsT{
+			CustomValFilters: []string{customVal},
+			JobCount:         10,
+		})
+		require.NoError(t, err)
+		require.Equal(t, 2, len(jobs))
+
+		t.Log("Mark one job as waiting for a second and the other one for an hour")
+		require.NoError(t, jobDB.MarkWaiting([]int64{jobs[0].JobID}, time.Now().Add(time.Second), "Retry-After"))
+		require.NoError(t, jobDB.MarkWaiting([]int64{jobs[1].JobID}, time.Now().Add(time.Hour), "Retry-After"))
+
+		t.Log("Waiting jobs should not be returned before their retry time")
+		waitingJobList := jobDB.GetWaiting(jobsdb.GetQueryParamsT{
+			CustomValFilters: []string{customVal},
+			JobCount:         10,
+		})
+		require.Equal(t, 0, len(waitingJobList))
+
+		t.Log("GetToRetry should not return waiting jobs")
+		retryJobList, err := jobDB.GetToRetry(jobsdb.GetQueryParamsT{
+			CustomValFilters: []string{customVal},
+			JobCount:         10,
+		})
+		require.NoError(t, err)
+		require.Equal(t, 0, len(retryJobList))
+
+		t.Log("Waiting job should be returned once its retry time has passed")
+		require.Eventually(t, func() bool {
+			waitingJobList = jobDB.GetWaiting(jobsdb.GetQueryParamsT{
+				CustomValFilters: []string{customVal},
+				JobCount:         10,
+			})
+			return len(waitingJobList) == 1
+		}, 5*time.Second, 100*time.Millisecond)
+		require.Equal(t, jobs[0].JobID, waitingJobList[0].JobID)
+		require.Equal(t, jobsdb.Waiting.State, waitingJobList[0].LastJobStatus.JobState)
+		require.JSONEq(t, `{"reason":"Retry-After"}`, string(waitingJobList[0].LastJobStatus.ErrorResponse))
+	})
+
+	t.Run("CopyJobs", func(t *testing.T) {
+		customVal := "MOCKDS"
+		replayCustomVal := "REPLAYDS"
+
+		jobDB := jobsdb.HandleT{}
+		jobDB.Setup(jobsdb.ReadWrite, false, "rt", dbRetention, migrationMode, true, queryFilters)
+		defer jobDB.TearDown()
+
+		require.NoError(t, jobDB.Store(genJobs(customVal, 2, 1)))
+		jobs, err := jobDB.GetUnprocessed(jobsdb.GetQueryParamsT{
+			CustomValFilters: []string{customVal},
+			JobCount:         10,
+		})
+		require.NoError(t, err)
+		require.Equal(t, 2, len(jobs))
+
+		t.Log("Abort the jobs")
+		statuses := make([]*jobsdb.JobStatusT, len(jobs))
+		for i, job := range jobs {
+			statuses[i] = &jobsdb.JobStatusT{
+				JobID:         job.JobID,
+				JobState:      jobsdb.Aborted.State,
+				AttemptNum:    1,
+				ExecTime:      time.Now(),
+				RetryTime:     time.Now(),
+				ErrorResponse: []byte(`{}`),
+				Parameters:    []byte(`{}`),
+				WorkspaceId:   "testWorkspace",
+			}
+		}
+		require.NoError(t, jobDB.UpdateJobStatus(statuses, []string{customVal}, []jobsdb.ParameterFilterT{}))
+
+		t.Log("Copy the aborted jobs with a new custom val")
+		newJobIDs, err := jobDB.CopyJobs([]int64{jobs[1].JobID, jobs[0].JobID}, replayCustomVal)
+		require.NoError(t, err)
+		require.Equal(t, 2, len(newJobIDs))
+		require.Greater(t, newJobIDs[0], jobs[1].JobID)
+		require.Equal(t, newJobIDs[0]+1, newJobIDs[1])
+
+		t.Log("Copied jobs should be unprocessed, without any status")
+		copiedJobs, err := jobDB.GetUnprocessed(jobsdb.GetQueryParamsT{
+			CustomValFilters: []string{replayCustomVal},
+			JobCount:         10,
+		})
+		require.NoError(t, err)
+		require.Equal(t, 2, len(copiedJobs))
+		for i, copiedJob := range copiedJobs {
+			original := jobs[len(jobs)-1-i]
+			require.Equal(t, newJobIDs[i], copiedJob.JobID)
+			require.NotEqual(t, original.UUID, copiedJob.UUID)
+			require.Equal(t, original.UserID, copiedJob.UserID)
+			require.JSONEq(t, string(original.Parameters), string(copiedJob.Parameters))
+			require.JSONEq(t, string(original.EventPayload), string(copiedJob.EventPayload))
+			require.Equal(t, "", copiedJob.LastJobStatus.JobState)
+		}
+
+		t.Log("Copying unknown jobs should fail")
+		_, err = jobDB.CopyJobs([]int64{-1}, "")
+		require.ErrorIs(t, err, jobsdb.ErrJobNotFound)
+	})
+
+	t.Run("UpsertJobStatus", func(t *testing.T) {
+		customVal := "UPSERTDS"
+		upsertJobStatusEnv := config.TransformKey("JobsDB.upsertJobStatus")
+		os.Setenv(upsertJobStatusEnv, "true")
+		defer os.Unsetenv(upsertJobStatusEnv)
+
+		jobDB := jobsdb.HandleT{}
+		jobDB.Setup(jobsdb.ReadWrite, false, "rt", dbRetention, migrationMode, true, queryFilters)
+		defer jobDB.TearDown()
+
+		require.NoError(t, jobDB.Store(genJobs(customVal, 1, 1)))
+		jobs, err := jobDB.GetUnprocessed(jobsdb.GetQueryParamsT{
+			CustomValFilters: []string{customVal},
+			JobCount:         10,
+		})
+		require.NoError(t, err)
+		require.Equal(t, 1, len(jobs))
+
+		latestStatus := func() (state string, attempt int) {
+			row := db.QueryRow(`SELECT job_state, attempt FROM rt_job_latest_status WHERE job_id = $1`, jobs[0].JobID)
+			require.NoError(t, row.Scan(&state, &attempt))
+			return state, attempt
+		}
+		status := &jobsdb.JobStatusT{
+			JobID:         jobs[0].JobID,
+			JobState:      jobsdb.Failed.State,
+			AttemptNum:    1,
+			ExecTime:      time.Now(),
+			RetryTime:     time.Now(),
+			ErrorResponse: []byte(`{}`),
+			Parameters:    []byte(`{}`),
+			WorkspaceId:   "testWorkspace",
+		}
+
+		t.Log("Upserting the status of a new job inserts it")
+		require.NoError(t, jobDB.UpsertJobStatus([]*jobsdb.JobStatusT{status}))
+		state, attempt := latestStatus()
+		require.Equal(t, jobsdb.Failed.State, state)
+		require.Equal(t, 1, attempt)
+
+		t.Log("Upserting the status of an existing job updates it in place")
+		status.JobState = jobsdb.Succeeded.State
+		status.AttemptNum = 2
+		require.NoError(t, jobDB.UpsertJobStatus([]*jobsdb.JobStatusT{status}))
+		state, attempt = latestStatus()
+		require.Equal(t, jobsdb.Succeeded.State, state)
+		require.Equal(t, 2, attempt)
+		var count int
+		require.NoError(t, db.QueryRow(`SELECT COUNT(*) FROM rt_job_latest_status WHERE job_id = $1`, jobs[0].JobID).Scan(&count))
+		require.Equal(t, 1, count)
+
+		t.Log("The upserted statuses aren't appended to the dataset status tables")
+		unprocessed, err := jobDB.GetUnprocessed(jobsdb.GetQueryParamsT{
+			CustomValFilters: []string{customVal},
+			JobCount:         10,
+		})
+		require.NoError(t, err)
+		require.Equal(t, 1, len(unprocessed))
+	})
 }
 
 func requireSequential(t *testing.T, jobs []*jobsdb.JobT) {
@@ -418,10 +637,11 @@ func BenchmarkJobsdb(b *testing.B) {
 		timeout := time.After(time.Second * time.Duration(len(expectedJobs)))
 		g.Go(func() error {
 			for {
-				unprocessedList := jobDB.GetUnprocessed(jobsdb.GetQueryParamsT{
+				unprocessedList, err := jobDB.GetUnprocessed(jobsdb.GetQueryParamsT{
 					CustomValFilters: []string{customVal},
 					JobCount:         1,
 				})
+				require.NoError(b, err)
 
 				status := make([]*jobsdb.JobStatusT, len(unprocessedList))
 				for i, j := range unprocessedList {
@@ -438,7 +658,7 @@ func BenchmarkJobsdb(b *testing.B) {
 					}
 				}
 
-				err := jobDB.UpdateJobStatus(status, []string{customVal}, []jobsdb.ParameterFilterT{})
+				err = jobDB.UpdateJobStatus(status, []string{customVal}, []jobsdb.ParameterFilterT{})
 				require.NoError(b, err)
 
 				for _, j := range unprocessedList {
@@ -22,10 +22,12 @@ package jobsdb
 import (
 	"bytes"
 	"context"
+	"crypto/sha256"
 	"database/sql"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"math"
 	"os"
 	"path/filepath"
 	"sort"
@@ -34,6 +36,7 @@ import (
 	"github.com/rudderlabs/rudder-server/admin"
 	"github.com/rudderlabs/rudder-server/utils/logger"
 	"github.com/tidwall/gjson"
+	"github.com/tidwall/sjson"
 	"golang.org/x/sync/errgroup"
 
 	"strconv"
@@ -61,6 +64,7 @@ type BackupSettingsT struct {
 }
 
 // GetQueryParamsT is a struct to hold jobsdb query params.
+// Prefer building it with NewQuery, which validates it and doesn't allow leaving JobCount unset.
 //
 // JobCount puts an upper limit on the number of returned jobs,
 //		if is not specified zero jobs will be returned.
@@ -68,6 +72,31 @@ type BackupSettingsT struct {
 // EventCount can further limit the number of returned jobs,
 //		based on the total number of event these jobs contain.
 // 	    NOTE: EventCount is not an exact limit. If the last job is split in half, it will be returned.
+//
+// ParametersContains limits the returned jobs to the ones whose parameters contain the given json document,
+//		using the jsonb containment operator (parameters @> $n::jsonb). It must be a valid json object.
+//		NOTE: Queries using it skip the empty result cache. For large datasets, a GIN index on the parameters
+//		column is recommended, e.g. CREATE INDEX ON <jobs_table> USING GIN (parameters jsonb_path_ops)
+//
+// MinAttemptNum and MaxAttemptNum limit the returned processed jobs to the ones whose latest attempt is within the given bounds (inclusive).
+//		Zero values mean unbounded. They are ignored for unprocessed jobs and, like ParametersContains, skip the empty result cache.
+//
+// MaxAttempts excludes the processed jobs which have exhausted their retries, i.e. whose latest attempt is MaxAttempts or more.
+//		Zero means no limit. It skips the empty result cache too. See GetExhausted for getting the excluded jobs.
+//
+// SplitByCustomVal makes GetToRetry split JobCount across the CustomValFilters in the ratio of their due failed jobs,
+//		querying each custom val with its own limit, so that no single custom val can take up the whole batch.
+//		EventCount, if set, is split in the same ratio. It has no effect with less than two CustomValFilters.
+//
+// WorkspaceFilters limits the returned jobs to the ones of the given workspaces, using the workspace_id column
+//		instead of extracting it from the parameters. Queries using it skip the empty result cache too.
+//
+// WorkspaceID is an optional hint of the workspace the query is made for. It doesn't filter the jobs,
+//		it is only used as the workspace tag of the query timers. If empty, the handle's default workspace is used (JobsDB.defaultWorkspaceID).
+//
+// SkipPayload leaves the EventPayload of the returned jobs empty, e.g. for jobs which are filtered by their parameters
+//		before their payloads are needed, see FetchPayloads. For the datasets whose payloads are stored in a separate table
+//		(JobsDB.splitPayloadTable) it spares joining it, otherwise it spares reading the payload column.
 type GetQueryParamsT struct {
 	CustomValFilters              []string
 	ParameterFilters              []ParameterFilterT
@@ -77,6 +106,21 @@ type GetQueryParamsT struct {
 	IgnoreCustomValFiltersInQuery bool
 	UseTimeFilter                 bool
 	Before                        time.Time
+	ParametersContains            json.RawMessage
+	MinAttemptNum                 int
+	MaxAttemptNum                 int
+	MaxAttempts                   int
+	WorkspaceFilters              []string
+	WorkspaceID                   string
+	SplitByCustomVal              bool
+	SkipPayload                   bool
+	fromReplica                   bool //set by getToRetry if the query is served by the replica, see SetReplica
+}
+
+//usesEmptyResultCache tells if the query can be served by the empty result cache,
+//which is only keyed by the state, custom val and parameter filters
+func (params GetQueryParamsT) usesEmptyResultCache() bool {
+	return len(params.ParametersContains) == 0 && params.MinAttemptNum == 0 && params.MaxAttemptNum == 0 && params.MaxAttempts == 0 && len(params.WorkspaceFilters) == 0
 }
 
 //StatTagsT is a struct to hold tags for stats
@@ -84,6 +128,7 @@ type StatTagsT struct {
 	CustomValFilters []string
 	ParameterFilters []ParameterFilterT
 	StateFilters     []string
+	WorkspaceID      string
 }
 
 var getTimeNowFunc = func() time.Time {
@@ -106,11 +151,16 @@ type JobsDB interface {
 	AcquireUpdateJobStatusLocks()
 	ReleaseUpdateJobStatusLocks()
 	GetPileUpCounts(statMap map[string]map[string]int)
+	GetRetryPileUpCounts(customValFilters []string) (map[string]map[string]int64, error)
+	GetJobTimeRange(customVal string) (oldest, newest time.Time, err error)
+	GetOldestPendingPerCustomer(destType string) (map[string]time.Time, error)
 
-	GetToRetry(params GetQueryParamsT) []*JobT
+	GetToRetry(params GetQueryParamsT) ([]*JobT, error)
+	GetExhausted(params GetQueryParamsT, maxAttempts int) []*JobT
 	GetWaiting(params GetQueryParamsT) []*JobT
-	GetProcessed(params GetQueryParamsT) []*JobT
-	GetUnprocessed(params GetQueryParamsT) []*JobT
+	MarkWaiting(jobIDs []int64, until time.Time, reason string) error
+	GetProcessed(params GetQueryParamsT) ([]*JobT, error)
+	GetUnprocessed(params GetQueryParamsT) ([]*JobT, error)
 	GetExecuting(params GetQueryParamsT) []*JobT
 	GetImportingList(params GetQueryParamsT) []*JobT
 
@@ -123,6 +173,9 @@ type JobsDB interface {
 	JournalMarkStart(opType string, opPayload json.RawMessage) int64
 }
 
+//HandleT must implement JobsDB, so that callers can depend on the interface and be tested with its mock
+var _ JobsDB = &HandleT{}
+
 /*
 AssertInterface contains public assert methods
 */
@@ -251,6 +304,7 @@ type JobStatusT struct {
 	ErrorResponse json.RawMessage `json:"ErrorResponse"`
 	Parameters    json.RawMessage `json:"Parameters"`
 	WorkspaceId   string          `json:"WorkspaceId"`
+	AbortReason   string          `json:"AbortReason"` //only stored for aborted jobs, see abortReason
 }
 
 /*
@@ -276,6 +330,50 @@ func (job *JobT) String() string {
 	return fmt.Sprintf("JobID=%v, UserID=%v, CreatedAt=%v, ExpireAt=%v, CustomVal=%v, Parameters=%v, EventPayload=%v EventCount=%d", job.JobID, job.UserID, job.CreatedAt, job.ExpireAt, job.CustomVal, string(job.Parameters), string(job.EventPayload), job.EventCount)
 }
 
+//JobHeaderT is a job without its event payload, along with the state and attempt of its latest status, as returned by PeekJobs
+type JobHeaderT struct {
+	JobID       int64           `json:"JobID"`
+	UUID        uuid.UUID       `json:"UUID"`
+	UserID      string          `json:"UserID"`
+	CustomVal   string          `json:"CustomVal"`
+	EventCount  int             `json:"EventCount"`
+	Parameters  json.RawMessage `json:"Parameters"`
+	WorkspaceId string          `json:"WorkspaceId"`
+	CreatedAt   time.Time       `json:"CreatedAt"`
+	ExpireAt    time.Time       `json:"ExpireAt"`
+	//JobState is NotProcessed.State for the jobs without any status
+	JobState   string    `json:"JobState"`
+	AttemptNum int       `json:"AttemptNum"`
+	RetryTime  time.Time `json:"RetryTime"`
+}
+
+//maxCustomValLength is the size of the custom_val column
+const maxCustomValLength = 64
+
+/*
+Validate checks that the job can be stored, i.e. that its required fields are set
+and that its parameters and event payload are valid json.
+The returned errors wrap ErrInvalidJob.
+*/
+func (job *JobT) Validate() error {
+	if job.CustomVal == "" {
+		return fmt.Errorf("%w: empty custom_val", ErrInvalidJob)
+	}
+	if len(job.CustomVal) > maxCustomValLength {
+		return fmt.Errorf("%w: custom_val longer than %d characters", ErrInvalidJob, maxCustomValLength)
+	}
+	if job.EventPayload == nil {
+		return fmt.Errorf("%w: nil event_payload", ErrInvalidJob)
+	}
+	if !json.Valid(job.EventPayload) {
+		return fmt.Errorf("%w: event_payload is not valid json", ErrInvalidJob)
+	}
+	if !json.Valid(job.Parameters) {
+		return fmt.Errorf("%w: parameters are not valid json", ErrInvalidJob)
+	}
+	return nil
+}
+
 //The struct fields need to be exposed to JSON package
 type dataSetT struct {
 	JobTable       string `json:"job"`
@@ -283,6 +381,18 @@ type dataSetT struct {
 	Index          string `json:"index"`
 }
 
+//DataSetInfo identifies a dataset by its tables and index, e.g. for OnDataSetMigration
+type DataSetInfo struct {
+	JobTable       string
+	JobStatusTable string
+	Index          string
+}
+
+//info returns the DataSetInfo of the dataset
+func (ds dataSetT) info() DataSetInfo {
+	return DataSetInfo{JobTable: ds.JobTable, JobStatusTable: ds.JobStatusTable, Index: ds.Index}
+}
+
 type dataSetRangeT struct {
 	minJobID  int64
 	maxJobID  int64
@@ -340,18 +450,39 @@ type HandleT struct {
 	isStatDropDSPeriodInitialized bool
 	migrationState                MigrationState
 	inProgressMigrationTargetDS   *dataSetT
+	migrationProgress             []MigrationProgress
+	migrationProgressLock         sync.RWMutex
 	logger                        logger.LoggerI
 	ownerType                     OwnerType
 	writeChannel                  chan writeJob
 	readChannel                   chan readJob
+	queuesLock                    sync.RWMutex //guards sending to, and closing of, writeChannel and readChannel
+	queuesClosed                  bool
 	enableWriterQueue             bool
+	writerQueueCapacity           int  //number of write requests buffered in writeChannel, while all the writers are busy
+	writerQueueNonBlocking        bool //if true, Store returns ErrWriterQueueFull instead of waiting when writeChannel is full
+	writerQueueDepthStat          stats.RudderStats
 	enableReaderQueue             bool
 	maxReaders                    int
 	maxWriters                    int
 	MaxDSSize                     *int
 	queryFilterKeys               QueryFiltersT
+	defaultWorkspaceID            string //used as the workspace tag of the query timers, when the workspace can't be derived from the query
+	upsertJobStatus               bool   //enables UpsertJobStatus
+	autoRetryTime                 bool   //populates the retry_time of the failed statuses left zero by the caller, see ComputeRetryTime
+	retryTimeBase                 time.Duration
+	retryTimeMax                  time.Duration
+	queryTimeout                  time.Duration //deadline of the read queries, zero meaning no deadline
+	replicaDBHandle               *sql.DB       //serves the reads of GetToRetry if set, see SetReplica
+	maxReplicaLag                 time.Duration //replica lag beyond which GetToRetry reads from the primary, zero meaning no check
+	splitPayloadTable             bool          //creates the new datasets with their payloads in a separate table, see payloadTable
+	payloadDSsLock                sync.RWMutex
+	payloadDSs                    map[string]bool //indexes of the datasets whose payloads are in a separate table
+	redaction                     RedactionConfig //fields of the payloads redacted before storing the jobs
 	backgroundCancel              context.CancelFunc
 	backgroundGroup               *errgroup.Group
+	workersCancel                 context.CancelFunc //stops the writer and reader workers from serving the requests still queued, see TearDown
+	workersLock                   sync.RWMutex       //held by the writer and reader workers while serving a request, so that TearDown can wait for them
 
 	// skipSetupDBSetup is useful for testing as we mock the database client
 	// TODO: Remove this flag once we have test setup that uses real database
@@ -360,6 +491,12 @@ type HandleT struct {
 	// TriggerAddNewDS is useful for triggering addNewDS to run from tests.
 	// TODO: Ideally we should refactor the code to not use this override.
 	TriggerAddNewDS func() <-chan time.Time
+
+	// OnDataSetMigration, if set, is called after the jobs of a dataset are migrated, e.g. for custom metrics or audit logs.
+	// For internal migrations, it is called once for every migrated dataset, while holding the migration lock,
+	// so it should return quickly and must not call back into jobsdb.
+	// For jobs imported during a cluster migration, from is empty since the jobs come from another node.
+	OnDataSetMigration func(from, to DataSetInfo, jobCount int)
 }
 
 type QueryFiltersT struct {
@@ -550,8 +687,52 @@ var (
 	backupRowsBatchSize                          int64
 	pkgLogger                                    logger.LoggerI
 	useNewCacheBurst                             bool
+	storeStrategy                                string
+	analyzeAfterMigration                        bool
+	nullByteStrategy                             string
+	vacuumAfterMigration                         bool
+	refreshDSRangesFromDB                        bool
+	tearDownTimeout                              time.Duration
+	replicaLagQuery                              string
+	validateBeforeStore                          bool
+	migrationBatchSize                           int
+	failureByCodeStatInterval                    time.Duration
+	dataSetSizesStatInterval                     time.Duration
+	estimateDataSetSizes                         bool
+)
+
+//defaultReplicaLagQuery returns the seconds since the last transaction replayed by the replica, zero if it isn't one
+const defaultReplicaLagQuery = `SELECT COALESCE(EXTRACT(EPOCH FROM now() - pg_last_xact_replay_timestamp()), 0)`
+
+//Different strategies for storing jobs in a dataset
+const (
+	//storeStrategyCopy uses postgres COPY, which is the fastest way of storing jobs
+	storeStrategyCopy = "copy"
+	//storeStrategyMultiInsert uses a parameterized INSERT with multiple rows,
+	//useful in environments where COPY is problematic (e.g. PgBouncer in transaction mode)
+	storeStrategyMultiInsert = "multiInsert"
 )
 
+//Different strategies for handling null bytes in the payload of the jobs being stored
+const (
+	//nullByteStrategyStrip removes the null bytes from the payload
+	nullByteStrategyStrip = "strip"
+	//nullByteStrategyReject fails the job with ErrContainsNullBytes
+	nullByteStrategyReject = "reject"
+	//nullByteStrategyReplace replaces the null bytes with nullBytePlaceholder
+	nullByteStrategyReplace = "replace"
+)
+
+var (
+	//nullByteEscape is how a null byte is encoded in a json string
+	nullByteEscape = []byte(`\u0000`)
+	//nullBytePlaceholder is the unicode replacement character
+	nullBytePlaceholder = []byte(`\ufffd`)
+)
+
+//maxStoreParamsPerStmt is the maximum number of parameters postgres accepts in a single statement
+const maxStoreParamsPerStmt = 65535
+
 //Different scenarios for addNewDS
 const (
 	appendToDsList     = "appendToDsList"
@@ -594,6 +775,21 @@ func loadConfig() {
 	config.RegisterDurationConfigVariable(time.Duration(60), &cacheExpiration, true, time.Minute, []string{"JobsDB.cacheExpiration"}...)
 	useJoinForUnprocessed = config.GetBool("JobsDB.useJoinForUnprocessed", true)
 	config.RegisterBoolConfigVariable(true, &useNewCacheBurst, true, "JobsDB.useNewCacheBurst")
+	config.RegisterStringConfigVariable(storeStrategyCopy, &storeStrategy, true, "JobsDB.storeStrategy")
+	config.RegisterBoolConfigVariable(false, &analyzeAfterMigration, true, "JobsDB.analyzeAfterMigration")
+	config.RegisterStringConfigVariable(nullByteStrategyStrip, &nullByteStrategy, true, "JobsDB.nullByteStrategy")
+	config.RegisterBoolConfigVariable(false, &vacuumAfterMigration, true, "JobsDB.vacuumAfterMigration")
+	config.RegisterBoolConfigVariable(false, &refreshDSRangesFromDB, true, "JobsDB.refreshDSRangesFromDB")
+	config.RegisterDurationConfigVariable(time.Duration(30), &tearDownTimeout, true, time.Second, "JobsDB.tearDownTimeout")
+	config.RegisterStringConfigVariable(defaultReplicaLagQuery, &replicaLagQuery, true, "JobsDB.replicaLagQuery")
+	config.RegisterBoolConfigVariable(false, &validateBeforeStore, true, "JobsDB.validateBeforeStore")
+	config.RegisterIntConfigVariable(10000, &migrationBatchSize, true, 1, "JobsDB.migrationBatchSize")
+	//failureByCodeStatInterval: How often the failed jobs are counted by error code, see failureByCodeStatLoop. Zero disables it
+	config.RegisterDurationConfigVariable(time.Duration(0), &failureByCodeStatInterval, false, time.Second, "JobsDB.failureByCodeStatInterval")
+	//dataSetSizesStatInterval: How often the sizes of the datasets are emitted, see dataSetSizesStatLoop. Zero disables it
+	config.RegisterDurationConfigVariable(time.Duration(0), &dataSetSizesStatInterval, false, time.Second, "JobsDB.dataSetSizesStatInterval")
+	//estimateDataSetSizes: Whether GetDataSetSizes estimates the row counts from the planner statistics instead of counting them
+	config.RegisterBoolConfigVariable(false, &estimateDataSetSizes, true, "JobsDB.estimateDataSetSizes")
 }
 
 func Init2() {
@@ -651,15 +847,7 @@ func (jd *HandleT) Setup(ownerType OwnerType, clearAll bool, tablePrefix string,
 
 	jd.backgroundCancel = cancel
 	jd.backgroundGroup = g
-
-	g.Go(func() error {
-		jd.initDBWriters(ctx)
-		return nil
-	})
-	g.Go(func() error {
-		jd.initDBReaders(ctx)
-		return nil
-	})
+	jd.startWorkers(g)
 
 	if !jd.skipSetupDBSetup {
 		jd.setUpForOwnerType(ctx, ownerType, clearAll)
@@ -697,18 +885,60 @@ func (jd *HandleT) workersAndAuxSetup(ownerType OwnerType, tablePrefix string, r
 	jd.statNewDSPeriod = stats.NewTaggedStat("jobsdb.new_ds_period", stats.TimerType, stats.Tags{"customVal": jd.tablePrefix})
 	jd.statDropDSPeriod = stats.NewTaggedStat("jobsdb.drop_ds_period", stats.TimerType, stats.Tags{"customVal": jd.tablePrefix})
 	jd.invalidCacheKeyStat = stats.NewTaggedStat("jobsdb.invalid_cache_key", stats.CountType, stats.Tags{"customVal": jd.tablePrefix})
+	jd.writerQueueDepthStat = stats.NewTaggedStat("jobsdb.writer_queue_depth", stats.GaugeType, stats.Tags{"customVal": jd.tablePrefix})
 
 	enableWriterQueueKeys := []string{"JobsDB." + jd.tablePrefix + "." + "enableWriterQueue", "JobsDB." + "enableWriterQueue"}
 	config.RegisterBoolConfigVariable(true, &jd.enableWriterQueue, true, enableWriterQueueKeys...)
 	enableReaderQueueKeys := []string{"JobsDB." + jd.tablePrefix + "." + "enableReaderQueue", "JobsDB." + "enableReaderQueue"}
 	config.RegisterBoolConfigVariable(true, &jd.enableReaderQueue, true, enableReaderQueueKeys...)
-	jd.writeChannel = make(chan writeJob)
+	writerQueueCapacityKeys := []string{"JobsDB." + jd.tablePrefix + "." + "writerQueueCapacity", "JobsDB." + "writerQueueCapacity"}
+	config.RegisterIntConfigVariable(100, &jd.writerQueueCapacity, false, 1, writerQueueCapacityKeys...)
+	writerQueueNonBlockingKeys := []string{"JobsDB." + jd.tablePrefix + "." + "writerQueueNonBlocking", "JobsDB." + "writerQueueNonBlocking"}
+	config.RegisterBoolConfigVariable(false, &jd.writerQueueNonBlocking, true, writerQueueNonBlockingKeys...)
+	jd.sanitizeWriterQueueConfig()
+	jd.writeChannel = make(chan writeJob, jd.writerQueueCapacity)
+	queryTimeoutKeys := []string{"JobsDB." + jd.tablePrefix + "." + "queryTimeout", "JobsDB." + "queryTimeout"}
+	config.RegisterDurationConfigVariable(0, &jd.queryTimeout, true, time.Second, queryTimeoutKeys...)
 	jd.readChannel = make(chan readJob)
 
 	maxWritersKeys := []string{"JobsDB." + jd.tablePrefix + "." + "maxWriters", "JobsDB." + "maxWriters"}
 	config.RegisterIntConfigVariable(1, &jd.maxWriters, false, 1, maxWritersKeys...)
 	maxReadersKeys := []string{"JobsDB." + jd.tablePrefix + "." + "maxReaders", "JobsDB." + "maxReaders"}
 	config.RegisterIntConfigVariable(3, &jd.maxReaders, false, 1, maxReadersKeys...)
+	defaultWorkspaceIDKeys := []string{"JobsDB." + jd.tablePrefix + "." + "defaultWorkspaceID", "JobsDB." + "defaultWorkspaceID"}
+	config.RegisterStringConfigVariable("", &jd.defaultWorkspaceID, false, defaultWorkspaceIDKeys...)
+	upsertJobStatusKeys := []string{"JobsDB." + jd.tablePrefix + "." + "upsertJobStatus", "JobsDB." + "upsertJobStatus"}
+	config.RegisterBoolConfigVariable(false, &jd.upsertJobStatus, true, upsertJobStatusKeys...)
+	autoRetryTimeKeys := []string{"JobsDB." + jd.tablePrefix + "." + "autoRetryTime", "JobsDB." + "autoRetryTime"}
+	config.RegisterBoolConfigVariable(false, &jd.autoRetryTime, true, autoRetryTimeKeys...)
+	retryTimeBaseKeys := []string{"JobsDB." + jd.tablePrefix + "." + "retryTimeBase", "JobsDB." + "retryTimeBase"}
+	config.RegisterDurationConfigVariable(time.Duration(10), &jd.retryTimeBase, true, time.Second, retryTimeBaseKeys...)
+	retryTimeMaxKeys := []string{"JobsDB." + jd.tablePrefix + "." + "retryTimeMax", "JobsDB." + "retryTimeMax"}
+	config.RegisterDurationConfigVariable(time.Duration(300), &jd.retryTimeMax, true, time.Second, retryTimeMaxKeys...)
+	splitPayloadTableKeys := []string{"JobsDB." + jd.tablePrefix + "." + "splitPayloadTable", "JobsDB." + "splitPayloadTable"}
+	config.RegisterBoolConfigVariable(false, &jd.splitPayloadTable, false, splitPayloadTableKeys...)
+	redactPathsKeys := []string{"JobsDB." + jd.tablePrefix + "." + "redactPaths", "JobsDB." + "redactPaths"}
+	config.RegisterStringSliceConfigVariable(nil, &jd.redaction.Paths, false, redactPathsKeys...)
+	redactActionKeys := []string{"JobsDB." + jd.tablePrefix + "." + "redactAction", "JobsDB." + "redactAction"}
+	config.RegisterStringConfigVariable(RedactionDrop, &jd.redaction.Action, false, redactActionKeys...)
+	jd.sanitizeRedactionConfig()
+}
+
+//sanitizeWriterQueueConfig falls back to a blocking writer queue if it has no buffer,
+//since a non blocking Store would then fail whenever no writer is idle
+func (jd *HandleT) sanitizeWriterQueueConfig() {
+	if jd.writerQueueCapacity <= 0 && jd.writerQueueNonBlocking {
+		jd.logger.Errorf("writerQueueNonBlocking requires a positive writerQueueCapacity, got %d, falling back to a blocking writer queue", jd.writerQueueCapacity)
+		jd.writerQueueNonBlocking = false
+	}
+}
+
+//sanitizeRedactionConfig falls back to RedactionDrop on an unknown redactAction
+func (jd *HandleT) sanitizeRedactionConfig() {
+	if jd.redaction.Action != RedactionDrop && jd.redaction.Action != RedactionHash {
+		jd.logger.Errorf("unknown redactAction: %q, must be %q or %q, falling back to %q", jd.redaction.Action, RedactionDrop, RedactionHash, RedactionDrop)
+		jd.redaction.Action = RedactionDrop
+	}
 }
 
 func (jd *HandleT) setUpForOwnerType(ctx context.Context, ownerType OwnerType, clearAll bool) {
@@ -743,6 +973,24 @@ func (jd *HandleT) startMigrateDSLoop(ctx context.Context) {
 	}))
 }
 
+func (jd *HandleT) startFailureByCodeStatLoop(ctx context.Context) {
+	if failureByCodeStatInterval > 0 {
+		jd.backgroundGroup.Go(misc.WithBugsnag(func() error {
+			jd.failureByCodeStatLoop(ctx)
+			return nil
+		}))
+	}
+}
+
+func (jd *HandleT) startDataSetSizesStatLoop(ctx context.Context) {
+	if dataSetSizesStatInterval > 0 {
+		jd.backgroundGroup.Go(misc.WithBugsnag(func() error {
+			jd.dataSetSizesStatLoop(ctx)
+			return nil
+		}))
+	}
+}
+
 func (jd *HandleT) readerSetup(ctx context.Context) {
 	jd.recoverFromJournal(Read)
 
@@ -765,6 +1013,8 @@ func (jd *HandleT) readerSetup(ctx context.Context) {
 
 	jd.startBackupDSLoop(ctx)
 	jd.startMigrateDSLoop(ctx)
+	jd.startFailureByCodeStatLoop(ctx)
+	jd.startDataSetSizesStatLoop(ctx)
 
 	g.Go(misc.WithBugsnag(func() error {
 		runArchiver(ctx, jd.tablePrefix, jd.dbHandle)
@@ -802,6 +1052,8 @@ func (jd *HandleT) readerWriterSetup(ctx context.Context) {
 
 	jd.startBackupDSLoop(ctx)
 	jd.startMigrateDSLoop(ctx)
+	jd.startFailureByCodeStatLoop(ctx)
+	jd.startDataSetSizesStatLoop(ctx)
 
 	jd.backgroundGroup.Go(misc.WithBugsnag(func() error {
 		runArchiver(ctx, jd.tablePrefix, jd.dbHandle)
@@ -821,6 +1073,21 @@ type writeJob struct {
 	deleteParams         GetQueryParamsT
 }
 
+//startWorkers starts the writer and reader workers in g. They keep serving the requests after the background context is cancelled,
+//until TearDown closes the queues, or rejects the requests still queued with workersCancel.
+func (jd *HandleT) startWorkers(g *errgroup.Group) {
+	ctx, cancel := context.WithCancel(context.Background())
+	jd.workersCancel = cancel
+	g.Go(func() error {
+		jd.initDBWriters(ctx)
+		return nil
+	})
+	g.Go(func() error {
+		jd.initDBReaders(ctx)
+		return nil
+	})
+}
+
 func (jd *HandleT) initDBWriters(ctx context.Context) {
 	g, ctx := errgroup.WithContext(ctx)
 	for i := 0; i < jd.maxWriters; i++ {
@@ -834,6 +1101,13 @@ func (jd *HandleT) initDBWriters(ctx context.Context) {
 
 func (jd *HandleT) dbWriter(ctx context.Context) {
 	for writeReq := range jd.writeChannel {
+		jd.writerQueueDepthStat.Gauge(len(jd.writeChannel))
+		jd.workersLock.RLock()
+		if ctx.Err() != nil {
+			jd.workersLock.RUnlock()
+			rejectWrite(writeReq)
+			continue
+		}
 		switch writeReq.reqType {
 		case writeReqTypeStore:
 			err := jd.store(writeReq.jobsList)
@@ -848,12 +1122,27 @@ func (jd *HandleT) dbWriter(ctx context.Context) {
 			jd.deleteJobStatus(writeReq.deleteParams)
 			writeReq.errorResponse <- nil
 		}
+		jd.workersLock.RUnlock()
+	}
+}
+
+//rejectWrite fails the write request with ErrClosed, without running it
+func rejectWrite(writeReq writeJob) {
+	if writeReq.reqType == writeReqTypeStoreWithRetry {
+		errMap := make(map[uuid.UUID]string, len(writeReq.jobsList))
+		for _, job := range writeReq.jobsList {
+			errMap[job.UUID] = ErrClosed.Error()
+		}
+		writeReq.errorMapResponse <- errMap
+		return
 	}
+	writeReq.errorResponse <- ErrClosed
 }
 
 type readJob struct {
 	getQueryParams GetQueryParamsT
 	jobsListChan   chan []*JobT
+	errChan        chan error //receives the error of the Failed.State and NotProcessed.State reads, before jobsListChan receives the jobs
 	reqType        string
 }
 
@@ -870,12 +1159,22 @@ func (jd *HandleT) initDBReaders(ctx context.Context) {
 
 func (jd *HandleT) dbReader(ctx context.Context) {
 	for readReq := range jd.readChannel {
+		jd.workersLock.RLock()
+		if ctx.Err() != nil {
+			jd.workersLock.RUnlock()
+			rejectRead(readReq)
+			continue
+		}
 		if readReq.reqType == Failed.State {
-			readReq.jobsListChan <- jd.getToRetry(readReq.getQueryParams)
+			jobsList, err := jd.getToRetry(readReq.getQueryParams)
+			readReq.errChan <- err
+			readReq.jobsListChan <- jobsList
 		} else if readReq.reqType == Waiting.State {
 			readReq.jobsListChan <- jd.getWaiting(readReq.getQueryParams)
 		} else if readReq.reqType == NotProcessed.State {
-			readReq.jobsListChan <- jd.getUnprocessed(readReq.getQueryParams)
+			jobsList, err := jd.getUnprocessed(readReq.getQueryParams)
+			readReq.errChan <- err
+			readReq.jobsListChan <- jobsList
 		} else if readReq.reqType == Executing.State {
 			readReq.jobsListChan <- jd.getExecuting(readReq.getQueryParams)
 		} else if readReq.reqType == Importing.State {
@@ -883,17 +1182,109 @@ func (jd *HandleT) dbReader(ctx context.Context) {
 		} else {
 			panic(fmt.Errorf("[[ %s ]] unknown read request type: %s", jd.tablePrefix, readReq.reqType))
 		}
+		jd.workersLock.RUnlock()
+	}
+}
+
+//rejectRead fails the read request with ErrClosed, without running it. The reads without an errChan get no jobs
+func rejectRead(readReq readJob) {
+	if readReq.errChan != nil {
+		readReq.errChan <- ErrClosed
+	}
+	readReq.jobsListChan <- nil
+}
+
+//enqueueWrite sends the request to the writer workers, unless the queues have been closed by TearDown.
+//It waits while writeChannel is full, unless nonBlocking is true, in which case it returns ErrWriterQueueFull
+func (jd *HandleT) enqueueWrite(writeReq writeJob, nonBlocking bool) error {
+	jd.queuesLock.RLock()
+	defer jd.queuesLock.RUnlock()
+	if jd.queuesClosed {
+		return ErrClosed
+	}
+	if nonBlocking {
+		select {
+		case jd.writeChannel <- writeReq:
+		default:
+			return ErrWriterQueueFull
+		}
+	} else {
+		jd.writeChannel <- writeReq
+	}
+	jd.writerQueueDepthStat.Gauge(len(jd.writeChannel))
+	return nil
+}
+
+//queryContext returns the context of a read query, with a deadline of JobsDB.queryTimeout if it is set
+func (jd *HandleT) queryContext() (context.Context, context.CancelFunc) {
+	if jd.queryTimeout <= 0 {
+		return context.Background(), func() {}
+	}
+	return context.WithTimeout(context.Background(), jd.queryTimeout)
+}
+
+//queryTimeoutError returns an error wrapping context.DeadlineExceeded if err is caused by the deadline of ctx,
+//which the driver reports as a cancelled statement rather than the context error
+func (jd *HandleT) queryTimeoutError(ctx context.Context, ds dataSetT, err error) error {
+	if err == nil || ctx.Err() != context.DeadlineExceeded {
+		return nil
+	}
+	return fmt.Errorf("[[ %s ]] query of %s timed out after %v: %w", jd.tablePrefix, ds.JobTable, jd.queryTimeout, context.DeadlineExceeded)
+}
+
+//NextQueryLimit returns the number of jobs a read loop should ask for next, given the limit of its last read and the error of it.
+//It is halved after a read timed out, see JobsDB.queryTimeout, so that the loop backs off to fewer jobs,
+//and doubled back up to maxLimit after a read that didn't.
+func NextQueryLimit(limit, maxLimit int, err error) int {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return misc.MaxInt(limit/2, 1)
+	}
+	return misc.MinInt(limit*2, maxLimit)
+}
+
+//enqueueRead sends the request to the reader workers, unless the queues have been closed by TearDown
+func (jd *HandleT) enqueueRead(readReq readJob) error {
+	jd.queuesLock.RLock()
+	defer jd.queuesLock.RUnlock()
+	if jd.queuesClosed {
+		return ErrClosed
 	}
+	jd.readChannel <- readReq
+	return nil
 }
 
 /*
-TearDown releases all the resources
+TearDown releases all the resources.
+It stops the background loops and stops accepting new requests, which fail with ErrClosed from then on.
+The requests already enqueued are still served by the writer and reader workers, so that no store is lost,
+waiting for them up to tearDownTimeout. After that, the requests still queued fail with ErrClosed,
+and the db handle is closed once the workers are done with the ones they are serving.
 */
 func (jd *HandleT) TearDown() {
 	jd.backgroundCancel()
-	close(jd.readChannel)
-	close(jd.writeChannel)
-	jd.backgroundGroup.Wait()
+
+	drained := make(chan struct{})
+	go func() {
+		//Waits for the requests being sent to be received by the workers
+		jd.queuesLock.Lock()
+		jd.queuesClosed = true
+		close(jd.readChannel)
+		close(jd.writeChannel)
+		jd.queuesLock.Unlock()
+		jd.backgroundGroup.Wait()
+		close(drained)
+	}()
+
+	select {
+	case <-drained:
+	case <-time.After(tearDownTimeout):
+		jd.logger.Errorf("[[ %s : TearDown ]]: Timed out after %v waiting for the writer and reader queues to drain, rejecting the queued requests and closing the db handle", jd.tablePrefix, tearDownTimeout)
+		//No worker runs a query on the closed db handle, which would panic, and the queues drain, so that they get closed
+		jd.workersCancel()
+		jd.workersLock.Lock()
+		defer jd.workersLock.Unlock()
+	}
+	jd.workersCancel()
 	jd.dbHandle.Close()
 }
 
@@ -933,10 +1324,33 @@ func (jd *HandleT) getDSList(refreshFromDB bool) []dataSetT {
 	//At this point we MUST have write-locked dsListLock
 	//since we are modiying the list
 
-	//Reset the global list
-	jd.datasetList = nil
+	return jd.setDSList(getAllTableNames(jd, jd.dbHandle))
+}
+
+/*
+refreshDSList is getDSList(true) for the read path, reading the table names with a deadline of JobsDB.queryTimeout.
+If the query takes longer, an error wrapping context.DeadlineExceeded is returned and the in-memory list is kept.
+Caller must have the dsListLock writelocked
+*/
+func (jd *HandleT) refreshDSList() error {
+	ctx, cancel := jd.queryContext()
+	defer cancel()
+
+	tableNames, err := queryAllTableNames(ctx, jd.dbHandle)
+	if err != nil && ctx.Err() == context.DeadlineExceeded {
+		return fmt.Errorf("[[ %s ]] query of the table names timed out after %v: %w", jd.tablePrefix, jd.queryTimeout, context.DeadlineExceeded)
+	}
+	jd.assertError(err)
+	jd.setDSList(tableNames)
+	return nil
+}
 
-	jd.datasetList = getDSList(jd, jd.dbHandle, jd.tablePrefix)
+//setDSList sets the in-memory list of datasets to the ones of the table names
+func (jd *HandleT) setDSList(tableNames []string) []dataSetT {
+	jd.datasetList = getDSListFromTableNames(jd, tableNames, jd.tablePrefix)
+	jd.payloadDSsLock.Lock()
+	jd.payloadDSs = getPayloadDSIndexes(tableNames, jd.tablePrefix)
+	jd.payloadDSsLock.Unlock()
 
 	//if the owner of this jobsdb is a writer, then shrinking datasetList to have only last two datasets
 	//this shrinked datasetList is used to compute DSRangeList
@@ -1005,6 +1419,86 @@ func (jd *HandleT) getDSRangeList(refreshFromDB bool) []dataSetRangeT {
 	return jd.datasetRangeList
 }
 
+/*
+RefreshDataSetRanges rebuilds the in-memory dataset ranges from the job_id and created_at bounds
+of the datasets in the current in-memory dataset list.
+getDSRangeList only recomputes the job_id bounds and asserts on inconsistencies, whereas this returns an error
+and keeps the previous ranges, so that it can be used to correct ranges which drifted after external writes or crashes.
+The bounds are queried without write-locking dsListLock, so that the readers aren't blocked by the scans,
+and the ranges are only swapped in if the dataset list hasn't changed in the meantime.
+*/
+func (jd *HandleT) RefreshDataSetRanges() error {
+	//The order of lock is very important. The migrateDSLoop
+	//takes lock in this order so reversing this will cause
+	//deadlocks. Read-locking dsMigrationLock keeps the datasets from being dropped while they are queried.
+	jd.dsMigrationLock.RLock()
+	defer jd.dsMigrationLock.RUnlock()
+
+	jd.dsListLock.RLock()
+	dsList := jd.getDSList(false)
+	jd.dsListLock.RUnlock()
+
+	dsRangeList, err := jd.computeDSRangeList(dsList)
+	if err != nil {
+		return err
+	}
+
+	jd.dsListLock.Lock()
+	defer jd.dsListLock.Unlock()
+	if !sameDataSets(dsList, jd.getDSList(false)) {
+		return fmt.Errorf("datasets changed while computing their ranges")
+	}
+	jd.datasetRangeList = dsRangeList
+	return nil
+}
+
+//computeDSRangeList queries the ranges of dsList. It expects dsMigrationLock to be read-locked, for the migration target ds.
+func (jd *HandleT) computeDSRangeList(dsList []dataSetT) ([]dataSetRangeT, error) {
+	var dsRangeList []dataSetRangeT
+	var prevMax int64
+	for idx, ds := range dsList {
+		//Same as in getDSRangeList, we skip the last ds (which is being actively written to) and the migration target ds
+		if idx == len(dsList)-1 || (jd.inProgressMigrationTargetDS != nil && jd.inProgressMigrationTargetDS.Index == ds.Index) {
+			continue
+		}
+		var minID, maxID sql.NullInt64
+		var minCreatedAt, maxCreatedAt sql.NullTime
+		sqlStatement := fmt.Sprintf(`SELECT MIN(job_id), MAX(job_id), MIN(created_at), MAX(created_at) FROM "%s"`, ds.JobTable)
+		err := jd.dbHandle.QueryRow(sqlStatement).Scan(&minID, &maxID, &minCreatedAt, &maxCreatedAt)
+		if err != nil {
+			return nil, fmt.Errorf("computing range of %s: %w", ds.JobTable, err)
+		}
+		if !minID.Valid || !maxID.Valid {
+			continue
+		}
+		if len(dsRangeList) > 0 && prevMax >= minID.Int64 {
+			return nil, fmt.Errorf("min job_id %d of %s is not greater than max job_id %d of the previous ds", minID.Int64, ds.JobTable, prevMax)
+		}
+		dsRangeList = append(dsRangeList, dataSetRangeT{
+			minJobID:  minID.Int64,
+			maxJobID:  maxID.Int64,
+			startTime: minCreatedAt.Time.UnixNano() / int64(time.Millisecond),
+			endTime:   maxCreatedAt.Time.UnixNano() / int64(time.Millisecond),
+			ds:        ds,
+		})
+		prevMax = maxID.Int64
+	}
+	return dsRangeList, nil
+}
+
+//sameDataSets returns whether the two lists have the same datasets in the same order
+func sameDataSets(a, b []dataSetT) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
 /*
 Functions for checking when DB is full or DB needs to be migrated.
 We migrate the DB ONCE most of the jobs have been processed (suceeded/aborted)
@@ -1147,6 +1641,58 @@ func (jd *HandleT) createTableNames(dsIdx string) (string, string) {
 	return jobTable, jobStatusTable
 }
 
+//payloadTableName returns the name of the table holding the payloads of the dataset's jobs if JobsDB.splitPayloadTable was on when it was created.
+//It follows the name of its jobs table, e.g. pre_drop_rt_job_payloads_1 for pre_drop_rt_jobs_1
+func payloadTableName(ds dataSetT) string {
+	return strings.TrimSuffix(ds.JobTable, "_jobs_"+ds.Index) + "_job_payloads_" + ds.Index
+}
+
+/*
+payloadTable returns the payload table of the dataset, if its payloads are stored separately from its jobs.
+Such a dataset has no event_payload column in its jobs table, so that filtering its jobs by their parameters
+scans a narrow table, and its payloads are joined by job_id only when they are needed, see payloadSelect.
+*/
+func (jd *HandleT) payloadTable(ds dataSetT) (string, bool) {
+	jd.payloadDSsLock.RLock()
+	defer jd.payloadDSsLock.RUnlock()
+
+	if !jd.payloadDSs[ds.Index] {
+		return "", false
+	}
+	return payloadTableName(ds), true
+}
+
+//markPayloadDS records that the payloads of the dataset are stored in its payload table, before the dataset list is refreshed
+func (jd *HandleT) markPayloadDS(ds dataSetT) {
+	jd.payloadDSsLock.Lock()
+	defer jd.payloadDSsLock.Unlock()
+
+	if jd.payloadDSs == nil {
+		jd.payloadDSs = make(map[string]bool)
+	}
+	jd.payloadDSs[ds.Index] = true
+}
+
+//payloadSelect returns the column selecting the payloads of the jobs, aliased as jobs, in a query on the dataset along with the join it needs.
+//If skipPayload is true, the payloads are selected as empty, since a null can't be scanned into an EventPayload, and the payload table isn't joined
+func (jd *HandleT) payloadSelect(ds dataSetT, skipPayload bool) (column, join string) {
+	if skipPayload {
+		return "''::bytea AS event_payload", ""
+	}
+	payloadTable, _ := jd.payloadTable(ds)
+	return payloadColumnAndJoin("jobs", payloadTable)
+}
+
+//payloadColumnAndJoin returns the column selecting the payloads of the jobs of the jobs table referred to as jobsTable in a query,
+//along with the join of payloadTable it needs. payloadTable is empty if the payloads are in the jobs table.
+//It is shared by the readers outside HandleT's read paths, e.g. the backups, the export and ReadonlyHandleT.
+func payloadColumnAndJoin(jobsTable, payloadTable string) (column, join string) {
+	if payloadTable == "" {
+		return jobsTable + ".event_payload", ""
+	}
+	return "payloads.event_payload", fmt.Sprintf(` LEFT JOIN "%s" AS payloads ON payloads.job_id = %s.job_id`, payloadTable, jobsTable)
+}
+
 func (jd *HandleT) addNewDS(newDSType string, insertBeforeDS dataSetT) dataSetT {
 	jd.logger.Infof("Creating new DS of type %s before ds %s for %s jobsdb", newDSType, insertBeforeDS.Index, jd.tablePrefix)
 	var newDSIdx string
@@ -1185,20 +1731,28 @@ func (jd *HandleT) addNewDS(newDSType string, insertBeforeDS dataSetT) dataSetT
 }
 
 func (jd *HandleT) computeNewIdxForAppend() string {
-	dList := jd.getDSList(true)
-	newDSIdx := ""
-	if len(dList) == 0 {
-		newDSIdx = "1"
-	} else {
-		levels, levelVals, err := mapDSToLevel(dList[len(dList)-1])
-		jd.assertError(err)
-		//Last one can only be Level0
-		jd.assert(levels == 1, fmt.Sprintf("levels:%d != 1", levels))
-		newDSIdx = fmt.Sprintf("%d", levelVals[0]+1)
-	}
+	newDSIdx, err := computeAppendIdx(jd.getDSList(true))
+	jd.assertError(err)
 	return newDSIdx
 }
 
+//computeAppendIdx returns the index of a new dataset to be appended after all the datasets of dList,
+//i.e. the next top-level index after the maximum one, ignoring the sub-indices of the migration/import datasets.
+//It compares the indices numerically, so the dataset after 9 is 10, and returns 1 if dList is empty.
+func computeAppendIdx(dList []dataSetT) (string, error) {
+	maxIdx := 0
+	for _, ds := range dList {
+		_, levelVals, err := mapDSToLevel(ds)
+		if err != nil {
+			return "", fmt.Errorf("Error while parsing the index of ds %s: %w", ds.Index, err)
+		}
+		if levelVals[0] > maxIdx {
+			maxIdx = levelVals[0]
+		}
+	}
+	return strconv.Itoa(maxIdx + 1), nil
+}
+
 func (jd *HandleT) computeNewIdxForInterNodeMigration(insertBeforeDS dataSetT) string { //ClusterMigration
 	jd.logger.Debugf("computeNewIdxForInterNodeMigration, insertBeforeDS : %v", insertBeforeDS)
 	dList := jd.getDSList(true)
@@ -1375,6 +1929,12 @@ func (jd *HandleT) createDS(appendLast bool, newDSIdx string) dataSetT {
 	jd.assertError(err)
 	opID := jd.JournalMarkStart(addDSOperation, opPayload)
 
+	//With JobsDB.splitPayloadTable the payloads go in a payload table instead, see payloadTable
+	payloadColumn := "event_payload JSONB NOT NULL,"
+	if jd.splitPayloadTable {
+		payloadColumn = ""
+	}
+
 	//Create the jobs and job_status tables
 	sqlStatement := fmt.Sprintf(`CREATE TABLE "%s" (
                                       job_id BIGSERIAL PRIMARY KEY,
@@ -1383,14 +1943,24 @@ func (jd *HandleT) createDS(appendLast bool, newDSIdx string) dataSetT {
 									  user_id TEXT NOT NULL,
 									  parameters JSONB NOT NULL,
                                       custom_val VARCHAR(64) NOT NULL,
-                                      event_payload JSONB NOT NULL,
+                                      %s
 									  event_count INTEGER NOT NULL DEFAULT 1,
                                       created_at TIMESTAMP NOT NULL DEFAULT NOW(),
-                                      expire_at TIMESTAMP NOT NULL DEFAULT NOW());`, newDS.JobTable)
+                                      expire_at TIMESTAMP NOT NULL DEFAULT NOW());`, newDS.JobTable, payloadColumn)
 
 	_, err = jd.dbHandle.Exec(sqlStatement)
 	jd.assertError(err)
 
+	if jd.splitPayloadTable {
+		//The payloads are deleted along with their jobs, so that deleting jobs works the same in both layouts
+		sqlStatement = fmt.Sprintf(`CREATE TABLE "%s" (
+                                      job_id BIGINT PRIMARY KEY REFERENCES "%s"(job_id) ON DELETE CASCADE,
+                                      event_payload JSONB NOT NULL);`, payloadTableName(newDS), newDS.JobTable)
+		_, err = jd.dbHandle.Exec(sqlStatement)
+		jd.assertError(err)
+		jd.markPayloadDS(newDS)
+	}
+
 	//TODO : Evaluate a way to handle indexes only for particular tables
 	if jd.tablePrefix == "rt" {
 		sqlStatement = fmt.Sprintf(`CREATE INDEX IF NOT EXISTS customval_workspace_%s ON "%s" (custom_val,workspace_id)`, newDSIdx, newDS.JobTable)
@@ -1408,6 +1978,7 @@ func (jd *HandleT) createDS(appendLast bool, newDSIdx string) dataSetT {
                                      error_code VARCHAR(32),
                                      error_response JSONB DEFAULT '{}'::JSONB,
 									 parameters JSONB DEFAULT '{}'::JSONB,
+									 abort_reason TEXT NOT NULL DEFAULT '',
 									 PRIMARY KEY (job_id, job_state, id));`, newDS.JobStatusTable, newDS.JobTable)
 	_, err = jd.dbHandle.Exec(sqlStatement)
 	jd.assertError(err)
@@ -1510,6 +2081,11 @@ func (jd *HandleT) dropDS(ds dataSetT, allowMissing bool) {
 	sqlStatement = fmt.Sprintf(`LOCK TABLE "%s" IN ACCESS EXCLUSIVE MODE;`, ds.JobTable)
 	txn = jd.prepareAndExecStmtInTxnAllowMissing(txn, sqlStatement, allowMissing)
 
+	//The payload table refers to the jobs table, so it is dropped first.
+	//Only the datasets created with JobsDB.splitPayloadTable have one
+	sqlStatement = fmt.Sprintf(`DROP TABLE IF EXISTS "%s"`, payloadTableName(ds))
+	jd.prepareAndExecStmtInTxn(txn, sqlStatement)
+
 	if allowMissing {
 		sqlStatement = fmt.Sprintf(`DROP TABLE IF EXISTS "%s"`, ds.JobStatusTable)
 		jd.prepareAndExecStmtInTxn(txn, sqlStatement)
@@ -1570,6 +2146,11 @@ func (jd *HandleT) renameDS(ds dataSetT, allowMissing bool) {
 	}
 	_, err = jd.dbHandle.Exec(sqlStatement)
 	jd.assertError(err)
+
+	//Only the datasets created with JobsDB.splitPayloadTable have a payload table
+	sqlStatement = fmt.Sprintf(`ALTER TABLE IF EXISTS "%s" RENAME TO "pre_drop_%s"`, payloadTableName(ds), payloadTableName(ds))
+	_, err = jd.dbHandle.Exec(sqlStatement)
+	jd.assertError(err)
 }
 
 func (jd *HandleT) getBackupDSList() []dataSetT {
@@ -1664,17 +2245,24 @@ func (jd *HandleT) migrateJobs(srcDS dataSetT, destDS dataSetT) (noJobsMigrated
 	defer jd.dsListLock.RUnlock()
 
 	//Unprocessed jobs
-	unprocessedList := jd.getUnprocessedJobsDS(srcDS, false, 0, GetQueryParamsT{})
+	unprocessedList, err := jd.getUnprocessedJobsDS(srcDS, false, 0, GetQueryParamsT{})
+	jd.assertError(err)
 
 	//Jobs which haven't finished processing
-	retryList := jd.getProcessedJobsDS(srcDS, true,
+	retryList, err := jd.getProcessedJobsDS(srcDS, true,
 		0, GetQueryParamsT{StateFilters: getValidNonTerminalStates()})
+	jd.assertError(err)
 	jobsToMigrate := append(unprocessedList, retryList...)
 	noJobsMigrated = len(jobsToMigrate)
-	//Copy the jobs over. Second parameter (true) makes sure job_id is copied over
+	jd.startMigrationProgress(srcDS, destDS, noJobsMigrated)
+	//Copy the jobs over in batches, updating the progress after every batch. Second parameter (true) makes sure job_id is copied over
 	//instead of getting auto-assigned
-	err = jd.storeJobsDS(destDS, true, jobsToMigrate) //TODO: switch to transaction
-	jd.assertError(err)
+	for start := 0; start < len(jobsToMigrate); start += migrationBatchSize {
+		batch := jobsToMigrate[start:misc.MinInt(start+migrationBatchSize, len(jobsToMigrate))]
+		err = jd.storeJobsDS(destDS, true, batch) //TODO: switch to transaction
+		jd.assertError(err)
+		jd.advanceMigrationProgress(len(batch))
+	}
 
 	//Now copy over the latest status of the unfinished jobs
 	var statusList []*JobStatusT
@@ -1698,6 +2286,58 @@ func (jd *HandleT) migrateJobs(srcDS dataSetT, destDS dataSetT) (noJobsMigrated
 	return
 }
 
+//MigrationProgress is the progress of copying the unfinished jobs of a dataset during an internal migration
+type MigrationProgress struct {
+	SourceDS   dataSetT
+	DestDS     dataSetT
+	RowsCopied int
+	TotalRows  int
+	StartTime  time.Time
+}
+
+/*
+GetMigrationProgress returns the progress of every dataset of the ongoing internal migration, or of the last one if none is ongoing.
+The progress is updated after every JobsDB.migrationBatchSize jobs copied, so a migration whose RowsCopied doesn't advance is stuck.
+*/
+func (jd *HandleT) GetMigrationProgress() []MigrationProgress {
+	jd.migrationProgressLock.RLock()
+	defer jd.migrationProgressLock.RUnlock()
+	progress := make([]MigrationProgress, len(jd.migrationProgress))
+	copy(progress, jd.migrationProgress)
+	return progress
+}
+
+//startMigrationProgress starts tracking the progress of copying totalRows jobs from srcDS to destDS,
+//dropping the progress of the previous migration if destDS is a new destination
+func (jd *HandleT) startMigrationProgress(srcDS, destDS dataSetT, totalRows int) {
+	jd.migrationProgressLock.Lock()
+	if len(jd.migrationProgress) > 0 && jd.migrationProgress[0].DestDS != destDS {
+		jd.migrationProgress = nil
+	}
+	jd.migrationProgress = append(jd.migrationProgress, MigrationProgress{SourceDS: srcDS, DestDS: destDS, TotalRows: totalRows, StartTime: time.Now()})
+	jd.migrationProgressLock.Unlock()
+	jd.migrationProgressStat(0, totalRows)
+}
+
+//advanceMigrationProgress adds rowsCopied to the progress of the dataset being copied
+func (jd *HandleT) advanceMigrationProgress(rowsCopied int) {
+	jd.migrationProgressLock.Lock()
+	progress := &jd.migrationProgress[len(jd.migrationProgress)-1]
+	progress.RowsCopied += rowsCopied
+	copied, total := progress.RowsCopied, progress.TotalRows
+	jd.migrationProgressLock.Unlock()
+	jd.migrationProgressStat(copied, total)
+}
+
+//migrationProgressStat gauges the fraction of the jobs copied from the dataset being migrated
+func (jd *HandleT) migrationProgressStat(copied, total int) {
+	fraction := 1.0
+	if total > 0 {
+		fraction = float64(copied) / float64(total)
+	}
+	stats.NewTaggedStat("jobsdb_migration_progress", stats.GaugeType, stats.Tags{"tablePrefix": jd.tablePrefix}).Gauge(fraction)
+}
+
 func (jd *HandleT) postMigrateHandleDS(migrateFrom []dataSetT) error {
 
 	//Rename datasets before dropping them, so that they can be uploaded to s3
@@ -1718,34 +2358,230 @@ func (jd *HandleT) postMigrateHandleDS(migrateFrom []dataSetT) error {
 	return nil
 }
 
+//DroppedDataSetsT lists the datasets dropped by DropProcessedDataSets, by their jobs table, along with the number of jobs they held
+type DroppedDataSetsT struct {
+	JobTables []string
+	JobCount  int
+}
+
 /*
-Next set of functions are for reading/writing jobs and job_status for
-a given dataset. The names should be self explainatory
+DropProcessedDataSets drops the datasets in which every job has reached a terminal state
+and whose newest job was created more than minRetention ago, returning the datasets dropped.
+The newest dataset is never dropped, and neither is the one before it if the owner is a reader, same as with migrations.
+If backups are enabled, the datasets are renamed instead, so that they are uploaded before being dropped.
+If dryRun is true, nothing is dropped and the datasets which would be dropped are returned instead.
 */
-func (jd *HandleT) storeJobsDS(ds dataSetT, copyID bool, jobList []*JobT) error { //When fixing callers make sure error is handled with assertError
-	queryStat := jd.storeTimerStat("store_jobs")
-	queryStat.Start()
-	defer queryStat.End()
+func (jd *HandleT) DropProcessedDataSets(minRetention time.Duration, dryRun bool) (DroppedDataSetsT, error) {
+	//A dry run only queries the datasets, so it doesn't need to block the readers
+	if dryRun {
+		jd.dsMigrationLock.RLock()
+		defer jd.dsMigrationLock.RUnlock()
+		jd.dsListLock.RLock()
+		defer jd.dsListLock.RUnlock()
+	} else {
+		jd.dsMigrationLock.Lock()
+		defer jd.dsMigrationLock.Unlock()
+		jd.dsListLock.Lock()
+		defer jd.dsListLock.Unlock()
+	}
 
-	txn, err := jd.dbHandle.Begin()
-	if err != nil {
-		return err
+	var result DroppedDataSetsT
+	dsList := jd.getDSList(false)
+	keepCount := 1
+	if jd.ownerType == Read {
+		keepCount = 2
+	}
+	if len(dsList) <= keepCount {
+		return result, nil
 	}
 
-	// Always clear cache even in case of an error,
-	// since we are not sure about the state of the db
-	defer func() {
-		customValParamMap := make(map[string]map[string]map[string]struct{})
-		var workspaces []string //for bursting old cache
-		for _, job := range jobList {
-			if !misc.ContainsString(workspaces, job.WorkspaceId) {
-				workspaces = append(workspaces, job.WorkspaceId)
-			}
-			jd.populateCustomValParamMap(customValParamMap, job.CustomVal, job.Parameters, job.WorkspaceId)
+	dropped := make(map[dataSetT]bool)
+	for _, ds := range dsList[:len(dsList)-keepCount] {
+		jobCount, isProcessed, err := jd.isDSProcessed(ds, minRetention)
+		if err != nil {
+			return DroppedDataSetsT{}, err
+		}
+		if !isProcessed {
+			continue
+		}
+		result.JobTables = append(result.JobTables, ds.JobTable)
+		result.JobCount += jobCount
+		if dryRun {
+			jd.logger.Infof("[[ %s : DropProcessedDataSets ]]: Dry run, would drop %v with %d jobs", jd.tablePrefix, ds, jobCount)
+			continue
 		}
 
-		if useNewCacheBurst {
-			jd.clearCache(ds, customValParamMap)
+		jd.logger.Infof("[[ %s : DropProcessedDataSets ]]: Dropping %v with %d jobs", jd.tablePrefix, ds, jobCount)
+		if jd.BackupSettings.BackupEnabled && isBackupConfigured() {
+			jd.renameDS(ds, false)
+		} else {
+			jd.dropDS(ds, false)
+		}
+		dropped[ds] = true
+	}
+	if len(dropped) == 0 {
+		return result, nil
+	}
+
+	var datasetList []dataSetT
+	for _, ds := range jd.datasetList {
+		if !dropped[ds] {
+			datasetList = append(datasetList, ds)
+		}
+	}
+	var datasetRangeList []dataSetRangeT
+	for _, dsRange := range jd.datasetRangeList {
+		if !dropped[dsRange.ds] {
+			datasetRangeList = append(datasetRangeList, dsRange)
+		}
+	}
+	jd.datasetList = datasetList
+	jd.datasetRangeList = datasetRangeList
+
+	tags := stats.Tags{"customVal": jd.tablePrefix}
+	stats.NewTaggedStat("jobsdb_dropped_processed_ds_count", stats.CountType, tags).Count(len(dropped))
+	stats.NewTaggedStat("jobsdb_dropped_processed_jobs_count", stats.CountType, tags).Count(result.JobCount)
+	return result, nil
+}
+
+//isDSProcessed returns the number of jobs in the dataset and whether all of them are in a terminal state,
+//with the newest of them created more than minRetention ago. Empty datasets are left to the migrations.
+func (jd *HandleT) isDSProcessed(ds dataSetT, minRetention time.Duration) (int, bool, error) {
+	var jobCount, terminalJobCount int
+	var newestCreatedAt sql.NullTime
+	sqlStatement := fmt.Sprintf(`SELECT COUNT(*), MAX(created_at) FROM "%s"`, ds.JobTable)
+	if err := jd.dbHandle.QueryRow(sqlStatement).Scan(&jobCount, &newestCreatedAt); err != nil {
+		return 0, false, err
+	}
+	if jobCount == 0 || !newestCreatedAt.Valid || time.Since(newestCreatedAt.Time) < minRetention {
+		return jobCount, false, nil
+	}
+
+	sqlStatement = fmt.Sprintf(`SELECT COUNT(DISTINCT(job_id)) FROM "%s" WHERE job_state IN ('%s')`,
+		ds.JobStatusTable, strings.Join(getValidTerminalStates(), "', '"))
+	if err := jd.dbHandle.QueryRow(sqlStatement).Scan(&terminalJobCount); err != nil {
+		return 0, false, err
+	}
+	return jobCount, terminalJobCount == jobCount, nil
+}
+
+//DataSetSize is the number of rows and the on-disk size of the tables of a dataset, see GetDataSetSizes
+type DataSetSize struct {
+	Index       string
+	JobCount    int64
+	StatusCount int64
+	//JobTableSize is the size in bytes of the jobs table along with its indexes and toast, and its payload table if any
+	JobTableSize int64
+	//StatusTableSize is the size in bytes of the job status table along with its indexes and toast
+	StatusTableSize int64
+}
+
+/*
+GetDataSetSizes returns the row counts and on-disk sizes of the datasets, oldest first, e.g. for deciding when to compact or drop them.
+If JobsDB.estimateDataSetSizes is true, the row counts are estimated from the planner statistics instead of being counted,
+which is much faster for large tables, but only as accurate as their last analyze.
+*/
+func (jd *HandleT) GetDataSetSizes() ([]DataSetSize, error) {
+	jd.dsMigrationLock.RLock()
+	jd.dsListLock.RLock()
+	defer jd.dsMigrationLock.RUnlock()
+	defer jd.dsListLock.RUnlock()
+
+	rowCount := func(table string) string {
+		if estimateDataSetSizes {
+			//reltuples is -1 for the tables which haven't been analyzed yet
+			return fmt.Sprintf(`(SELECT GREATEST(reltuples, 0)::bigint FROM pg_class WHERE oid = '"%s"'::regclass)`, table)
+		}
+		return fmt.Sprintf(`(SELECT COUNT(*) FROM "%s")`, table)
+	}
+
+	var sizes []DataSetSize
+	for _, ds := range jd.getDSList(false) {
+		jobTableSize := fmt.Sprintf(`pg_total_relation_size('"%s"')`, ds.JobTable)
+		if payloadTable, ok := jd.payloadTable(ds); ok {
+			jobTableSize += fmt.Sprintf(` + pg_total_relation_size('"%s"')`, payloadTable)
+		}
+		sqlStatement := fmt.Sprintf(`SELECT %s, %s, %s, pg_total_relation_size('"%s"')`,
+			rowCount(ds.JobTable), rowCount(ds.JobStatusTable), jobTableSize, ds.JobStatusTable)
+		size := DataSetSize{Index: ds.Index}
+		if err := jd.dbHandle.QueryRow(sqlStatement).Scan(&size.JobCount, &size.StatusCount, &size.JobTableSize, &size.StatusTableSize); err != nil {
+			return nil, err
+		}
+		sizes = append(sizes, size)
+	}
+	return sizes, nil
+}
+
+//dataSetSizesStatLoop periodically emits the sizes of GetDataSetSizes as gauges tagged by the dataset index.
+//The gauges of the datasets which are gone are reset to zero.
+func (jd *HandleT) dataSetSizesStatLoop(ctx context.Context) {
+	var lastSizes []DataSetSize
+	for {
+		select {
+		case <-time.After(dataSetSizesStatInterval):
+		case <-ctx.Done():
+			return
+		}
+
+		sizes, err := jd.GetDataSetSizes()
+		if err != nil {
+			jd.logger.Errorf("[[ %s : dataSetSizesStatLoop ]]: Failed to get the dataset sizes: %v", jd.tablePrefix, err)
+			continue
+		}
+		jd.emitDataSetSizeStats(sizes, lastSizes)
+		lastSizes = sizes
+	}
+}
+
+//emitDataSetSizeStats emits the sizes, along with zeroes for the datasets only in lastSizes
+func (jd *HandleT) emitDataSetSizeStats(sizes, lastSizes []DataSetSize) {
+	emit := func(size DataSetSize) {
+		tags := stats.Tags{"tablePrefix": jd.tablePrefix, "index": size.Index}
+		stats.NewTaggedStat("jobsdb_dataset_job_count", stats.GaugeType, tags).Gauge(size.JobCount)
+		stats.NewTaggedStat("jobsdb_dataset_status_count", stats.GaugeType, tags).Gauge(size.StatusCount)
+		stats.NewTaggedStat("jobsdb_dataset_job_table_size", stats.GaugeType, tags).Gauge(size.JobTableSize)
+		stats.NewTaggedStat("jobsdb_dataset_status_table_size", stats.GaugeType, tags).Gauge(size.StatusTableSize)
+	}
+	indexes := make(map[string]bool, len(sizes))
+	for _, size := range sizes {
+		indexes[size.Index] = true
+		emit(size)
+	}
+	for _, size := range lastSizes {
+		if !indexes[size.Index] {
+			emit(DataSetSize{Index: size.Index})
+		}
+	}
+}
+
+/*
+Next set of functions are for reading/writing jobs and job_status for
+a given dataset. The names should be self explainatory
+*/
+func (jd *HandleT) storeJobsDS(ds dataSetT, copyID bool, jobList []*JobT) error { //When fixing callers make sure error is handled with assertError
+	queryStat := jd.storeTimerStat("store_jobs", jobList)
+	queryStat.Start()
+	defer queryStat.End()
+
+	txn, err := jd.dbHandle.Begin()
+	if err != nil {
+		return wrapOpError(ErrStoreBeginFailed, err)
+	}
+
+	// Always clear cache even in case of an error,
+	// since we are not sure about the state of the db
+	defer func() {
+		customValParamMap := make(map[string]map[string]map[string]struct{})
+		var workspaces []string //for bursting old cache
+		for _, job := range jobList {
+			if !misc.ContainsString(workspaces, job.WorkspaceId) {
+				workspaces = append(workspaces, job.WorkspaceId)
+			}
+			jd.populateCustomValParamMap(customValParamMap, job.CustomVal, job.Parameters, job.WorkspaceId)
+		}
+
+		if useNewCacheBurst {
+			jd.clearCache(ds, customValParamMap)
 		} else {
 			//NOTE: Along with clearing cache for a particular workspace key, we also have to clear for allWorkspaces key
 			jd.markClearEmptyResult(ds, allWorkspaces, []string{}, []string{}, nil, hasJobs, nil)
@@ -1765,14 +2601,14 @@ func (jd *HandleT) storeJobsDS(ds dataSetT, copyID bool, jobList []*JobT) error
 
 	err = txn.Commit()
 	if err != nil {
-		return err
+		return wrapOpError(ErrStoreCommitFailed, err)
 	}
 
 	return nil
 }
 
 func (jd *HandleT) storeJobsDSWithRetryEach(ds dataSetT, copyID bool, jobList []*JobT) (errorMessagesMap map[uuid.UUID]string) {
-	queryStat := jd.storeTimerStat("store_jobs_retry_each")
+	queryStat := jd.storeTimerStat("store_jobs_retry_each", jobList)
 	queryStat.Start()
 	defer queryStat.End()
 
@@ -1891,126 +2727,618 @@ func (jd *HandleT) GetPileUpCounts(statMap map[string]map[string]int) {
 	}
 }
 
-func (jd *HandleT) storeJobsDSInTxn(txHandler transactionHandler, ds dataSetT, copyID bool, jobList []*JobT) error {
-	var stmt *sql.Stmt
-	var err error
+/*
+GetJobTimeRange returns the created_at of the oldest and the newest jobs across all datasets.
+If customVal is not empty, only jobs with that custom_val are considered.
+Zero times are returned if there are no such jobs.
+*/
+func (jd *HandleT) GetJobTimeRange(customVal string) (oldest, newest time.Time, err error) {
+	jd.dsMigrationLock.RLock()
+	jd.dsListLock.RLock()
+	defer jd.dsMigrationLock.RUnlock()
+	defer jd.dsListLock.RUnlock()
 
-	if copyID {
-		stmt, err = txHandler.Prepare(pq.CopyIn(ds.JobTable, "job_id", "uuid", "user_id", "custom_val", "parameters",
-			"event_payload", "event_count", "created_at", "expire_at", "workspace_id"))
-	} else {
-		stmt, err = txHandler.Prepare(pq.CopyIn(ds.JobTable, "uuid", "user_id", "custom_val", "parameters", "event_payload", "event_count", "workspace_id"))
+	//Datasets are created in the order of the jobs' creation, so only the boundary datasets need to be queried.
+	//We move inwards only if a boundary dataset doesn't have any (matching) jobs.
+	dsList := jd.getDSList(false)
+	for _, ds := range dsList {
+		var found bool
+		oldest, found, err = jd.getJobTimeBoundaryDS(ds, "MIN", customVal)
+		if err != nil || found {
+			break
+		}
+	}
+	if err != nil {
+		return time.Time{}, time.Time{}, err
 	}
 
+	for i := len(dsList) - 1; i >= 0; i-- {
+		var found bool
+		newest, found, err = jd.getJobTimeBoundaryDS(dsList[i], "MAX", customVal)
+		if err != nil || found {
+			break
+		}
+	}
 	if err != nil {
-		return err
+		return time.Time{}, time.Time{}, err
 	}
+	return oldest, newest, nil
+}
 
-	defer stmt.Close()
+//getJobTimeBoundaryDS returns the result of the aggregate (MIN/MAX) of created_at of the jobs in ds
+func (jd *HandleT) getJobTimeBoundaryDS(ds dataSetT, aggregate string, customVal string) (time.Time, bool, error) {
+	var createdAt sql.NullTime
+	var err error
+	sqlStatement := fmt.Sprintf(`SELECT %s(created_at) FROM "%s"`, aggregate, ds.JobTable)
+	if customVal != "" {
+		sqlStatement += ` WHERE custom_val = $1`
+		err = jd.dbHandle.QueryRow(sqlStatement, customVal).Scan(&createdAt)
+	} else {
+		err = jd.dbHandle.QueryRow(sqlStatement).Scan(&createdAt)
+	}
+	if err != nil {
+		return time.Time{}, false, err
+	}
+	return createdAt.Time, createdAt.Valid, nil
+}
 
-	for _, job := range jobList {
-		eventCount := 1
-		if job.EventCount > 1 {
-			eventCount = job.EventCount
+/*
+GetRetryPileUpCounts returns the number of jobs whose latest state is failed or waiting, by workspace and custom_val.
+Unlike GetPileUpCounts, unprocessed jobs aren't counted, so that in-memory pile up counters can be reconciled against it.
+*/
+func (jd *HandleT) GetRetryPileUpCounts(customValFilters []string) (map[string]map[string]int64, error) {
+	jd.dsMigrationLock.RLock()
+	jd.dsListLock.RLock()
+	defer jd.dsMigrationLock.RUnlock()
+	defer jd.dsListLock.RUnlock()
+
+	var customValQuery string
+	if len(customValFilters) > 0 {
+		customValQuery = " AND " + constructQuery(jd, "jobs.custom_val", customValFilters, "OR")
+	}
+
+	counts := make(map[string]map[string]int64)
+	for _, ds := range jd.getDSList(false) {
+		sqlStatement := fmt.Sprintf(`SELECT COUNT(*), jobs.custom_val, jobs.workspace_id FROM "%[1]s" AS jobs,
+			(SELECT job_id, job_state FROM "%[2]s" WHERE id IN (SELECT MAX(id) FROM "%[2]s" GROUP BY job_id)) AS job_latest_state
+			WHERE jobs.job_id = job_latest_state.job_id AND job_latest_state.job_state IN ('%[3]s', '%[4]s')%[5]s
+			GROUP BY jobs.custom_val, jobs.workspace_id`,
+			ds.JobTable, ds.JobStatusTable, Failed.State, Waiting.State, customValQuery)
+		rows, err := jd.dbHandle.Query(sqlStatement)
+		if err != nil {
+			return nil, err
+		}
+		for rows.Next() {
+			var count int64
+			var customVal, workspace string
+			if err := rows.Scan(&count, &customVal, &workspace); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			if _, ok := counts[workspace]; !ok {
+				counts[workspace] = make(map[string]int64)
+			}
+			counts[workspace][customVal] += count
+		}
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			return nil, err
 		}
+	}
+	return counts, nil
+}
 
-		if copyID {
-			_, err = stmt.Exec(job.JobID, job.UUID, job.UserID, job.CustomVal, string(job.Parameters),
-				string(job.EventPayload), eventCount, job.CreatedAt, job.ExpireAt, job.WorkspaceId)
-		} else {
-			_, err = stmt.Exec(job.UUID, job.UserID, job.CustomVal, string(job.Parameters), string(job.EventPayload), eventCount, job.WorkspaceId)
+/*
+GetOldestPendingPerCustomer returns the created_at of the oldest pending job of every customer (workspace),
+i.e. of the oldest job which is either unprocessed or whose latest state is failed, e.g. for computing their head-of-line latency.
+If destType is not empty, only the jobs with that custom_val are considered.
+The customers without pending jobs are left out.
+*/
+func (jd *HandleT) GetOldestPendingPerCustomer(destType string) (map[string]time.Time, error) {
+	jd.dsMigrationLock.RLock()
+	jd.dsListLock.RLock()
+	defer jd.dsMigrationLock.RUnlock()
+	defer jd.dsListLock.RUnlock()
+
+	args := []interface{}{Failed.State}
+	var customValQuery string
+	if destType != "" {
+		customValQuery = " AND jobs.custom_val = $2"
+		args = append(args, destType)
+	}
+
+	oldest := make(map[string]time.Time)
+	for _, ds := range jd.getDSList(false) {
+		sqlStatement := fmt.Sprintf(`SELECT jobs.workspace_id, MIN(jobs.created_at) FROM "%[1]s" AS jobs LEFT JOIN
+			(SELECT job_id, job_state FROM "%[2]s" WHERE id IN (SELECT MAX(id) FROM "%[2]s" GROUP BY job_id)) AS job_latest_state
+			ON jobs.job_id = job_latest_state.job_id
+			WHERE (job_latest_state.job_id IS NULL OR job_latest_state.job_state = $1)%[3]s
+			GROUP BY jobs.workspace_id`,
+			ds.JobTable, ds.JobStatusTable, customValQuery)
+		rows, err := jd.dbHandle.Query(sqlStatement, args...)
+		if err != nil {
+			return nil, err
+		}
+		for rows.Next() {
+			var workspace string
+			var createdAt time.Time
+			if err := rows.Scan(&workspace, &createdAt); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			//the datasets mostly hold jobs in the order of their creation, but migrated jobs keep their created_at
+			if current, ok := oldest[workspace]; !ok || createdAt.Before(current) {
+				oldest[workspace] = createdAt
+			}
 		}
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return oldest, nil
+}
+
+func (jd *HandleT) storeJobsDSInTxn(txHandler transactionHandler, ds dataSetT, copyID bool, jobList []*JobT) error {
+	payloadTable, splitPayload := jd.payloadTable(ds)
+	if splitPayload && !copyID {
+		//The payloads refer to their jobs by id, so the ids are allocated beforehand instead of being generated while inserting
+		var err error
+		jobList, err = allocateJobIDsInTxn(txHandler, ds, jobList)
 		if err != nil {
 			return err
 		}
 	}
-	_, err = stmt.Exec()
 
-	return err
+	insertRows := copyRowsInTxn
+	if storeStrategy == storeStrategyMultiInsert {
+		insertRows = multiInsertRowsInTxn
+	}
+	//the jobs copied with their ids are being migrated, their payloads have been redacted when they were first stored
+	err := insertRows(txHandler, ds.JobTable, storeJobColumns(copyID, splitPayload), len(jobList), func(i int) ([]interface{}, error) {
+		return jd.storeJobArgs(jobList[i], copyID, splitPayload, !copyID)
+	})
+	if err != nil || !splitPayload {
+		return err
+	}
+	return insertRows(txHandler, payloadTable, storePayloadColumns, len(jobList), func(i int) ([]interface{}, error) {
+		return jd.storePayloadArgs(jobList[i], !copyID)
+	})
 }
 
-func (jd *HandleT) storeJobDS(ds dataSetT, job *JobT) (err error) {
-	sqlStatement := fmt.Sprintf(`INSERT INTO "%s" (uuid, user_id, custom_val, parameters, event_payload)
-	                                   VALUES ($1, $2, $3, $4, (regexp_replace($5::text, '\\u0000', '', 'g'))::json) RETURNING job_id`, ds.JobTable)
-	stmt, err := jd.dbHandle.Prepare(sqlStatement)
-	jd.assertError(err)
-	defer stmt.Close()
-	_, err = stmt.Exec(job.UUID, job.UserID, job.CustomVal, string(job.Parameters), string(job.EventPayload))
-	if err == nil {
-		//Empty customValFilters means we want to clear for all
-		jd.markClearEmptyResult(ds, allWorkspaces, []string{}, []string{}, nil, hasJobs, nil)
-		jd.markClearEmptyResult(ds, job.WorkspaceId, []string{}, []string{}, nil, hasJobs, nil)
-		// fmt.Println("Bursting CACHE")
-		return
+//copyRowsInTxn stores rowCount rows in the table using postgres COPY, getting the values of the i-th row from rowArgs
+func copyRowsInTxn(txHandler transactionHandler, table string, columns []string, rowCount int, rowArgs func(i int) ([]interface{}, error)) error {
+	stmt, err := txHandler.Prepare(pq.CopyIn(table, columns...))
+	if err != nil {
+		return wrapOpError(ErrStorePrepareFailed, err)
 	}
-	pqErr, ok := err.(*pq.Error)
-	if ok {
-		errCode := string(pqErr.Code)
-		if errCode == dbErrorMap["Invalid JSON"] || errCode == dbErrorMap["Invalid Unicode"] ||
-			errCode == dbErrorMap["Invalid Escape Sequence"] || errCode == dbErrorMap["Invalid Escape Character"] {
-			return errors.New("Invalid JSON")
+
+	defer stmt.Close()
+
+	for i := 0; i < rowCount; i++ {
+		args, err := rowArgs(i)
+		if err != nil {
+			return err
+		}
+		_, err = stmt.Exec(args...)
+		if err != nil {
+			return wrapOpError(ErrStoreExecFailed, err)
 		}
 	}
-	return
+	_, err = stmt.Exec()
+	if err != nil {
+		return wrapOpError(ErrStoreExecFailed, err)
+	}
+
+	return nil
 }
 
-type cacheValue string
+//multiInsertRowsInTxn stores rows using INSERT statements with multiple parameterized rows, like copyRowsInTxn.
+//Rows are split across statements so that postgres' parameter limit is never exceeded.
+func multiInsertRowsInTxn(txHandler transactionHandler, table string, columns []string, rowCount int, rowArgs func(i int) ([]interface{}, error)) error {
+	maxRowsPerStmt := maxStoreParamsPerStmt / len(columns)
 
-const (
-	hasJobs         cacheValue = "Has Jobs"
-	noJobs          cacheValue = "No Jobs"
-	dropDSFromCache cacheValue = "Drop DS From Cache"
-	/*
-	* willTryToSet value is used to prevent wrongly setting empty result when
-	* a db update (new jobs or job status updates) happens during get(Un)Processed db query is in progress.
-	*
-	* getUnprocessedJobs() {  # OR getProcessedJobsDS
-	* 0. Sets cache value to willTryToSet
-	* 1. out = queryDB()
-	* 2. check and set cache to (len(out) == 0) only if cache value is willTryToSet
-	* }
-	 */
-	willTryToSet cacheValue = "Query in progress"
-)
+	for start := 0; start < rowCount; start += maxRowsPerStmt {
+		end := start + maxRowsPerStmt
+		if end > rowCount {
+			end = rowCount
+		}
 
-type cacheEntry struct {
-	Value cacheValue `json:"value"`
-	T     time.Time  `json:"set_at"`
-}
+		valuePlaceholders := make([]string, 0, end-start)
+		args := make([]interface{}, 0, (end-start)*len(columns))
+		for i := start; i < end; i++ {
+			placeholders := make([]string, len(columns))
+			for j := range columns {
+				placeholders[j] = fmt.Sprintf("$%d", len(args)+j+1)
+			}
+			valuePlaceholders = append(valuePlaceholders, "("+strings.Join(placeholders, ", ")+")")
+			rowValues, err := rowArgs(i)
+			if err != nil {
+				return err
+			}
+			args = append(args, rowValues...)
+		}
 
-func (jd *HandleT) dropDSFromCache(ds dataSetT) {
-	jd.dsCacheLock.Lock()
-	defer jd.dsCacheLock.Unlock()
+		sqlStatement := fmt.Sprintf(`INSERT INTO "%s" (%s) VALUES %s`, table, strings.Join(columns, ", "), strings.Join(valuePlaceholders, ", "))
+		if _, err := txHandler.Exec(sqlStatement, args...); err != nil {
+			return wrapOpError(ErrStoreExecFailed, err)
+		}
+	}
 
-	delete(jd.dsEmptyResultCache, ds)
+	return nil
 }
 
-/*
-* If a query returns empty result for a specific dataset, we cache that so that
-* future queries don't have to hit the DB.
-* markClearEmptyResult() when mark=True marks dataset,customVal,state as empty.
-* markClearEmptyResult() when mark=False clears a previous empty mark
- */
-
-func (jd *HandleT) markClearEmptyResult(ds dataSetT, workspace string, stateFilters []string, customValFilters []string, parameterFilters []ParameterFilterT, value cacheValue, checkAndSet *cacheValue) {
-	// Safe check. Every status must have a valid workspace id for the cache to work efficiently.
-	if workspace == "" {
-		jd.logger.Errorf("[%s] Empty workspace key provided while looking into jobsdb cachemap", jd.tablePrefix)
-		jd.invalidCacheKeyStat.Increment()
+//allocateJobIDsInTxn takes the next job ids from the sequence of the dataset's jobs table, returning copies of the jobs with them set
+func allocateJobIDsInTxn(txHandler transactionHandler, ds dataSetT, jobList []*JobT) ([]*JobT, error) {
+	stmt, err := txHandler.Prepare(fmt.Sprintf(`SELECT nextval(pg_get_serial_sequence('"%s"', 'job_id')) FROM generate_series(1, $1)`, ds.JobTable))
+	if err != nil {
+		return nil, wrapOpError(ErrStorePrepareFailed, err)
 	}
+	defer stmt.Close()
 
-	jd.dsCacheLock.Lock()
-	defer jd.dsCacheLock.Unlock()
+	rows, err := stmt.Query(len(jobList))
+	if err != nil {
+		return nil, wrapOpError(ErrStoreExecFailed, err)
+	}
+	defer rows.Close()
 
-	//This means we want to mark/clear all customVals and stateFilters
-	//When clearing, we remove the entire dataset entry. Not a big issue
-	//We process ALL only during internal migration and caching empty
-	//results is not important
-	if len(stateFilters) == 0 || len(customValFilters) == 0 {
-		if value == hasJobs || value == dropDSFromCache {
-			delete(jd.dsEmptyResultCache, ds)
+	jobsWithIDs := make([]*JobT, 0, len(jobList))
+	for rows.Next() && len(jobsWithIDs) < len(jobList) {
+		job := *jobList[len(jobsWithIDs)]
+		if err := rows.Scan(&job.JobID); err != nil {
+			return nil, wrapOpError(ErrStoreExecFailed, err)
 		}
-		return
+		jobsWithIDs = append(jobsWithIDs, &job)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, wrapOpError(ErrStoreExecFailed, err)
+	}
+	if len(jobsWithIDs) != len(jobList) {
+		return nil, wrapOpError(ErrStoreExecFailed, fmt.Errorf("allocated %d job ids for %d jobs", len(jobsWithIDs), len(jobList)))
+	}
+	return jobsWithIDs, nil
+}
+
+/*
+storeJobColumns returns the job table columns which are written while storing jobs.
+With copyID the jobs keep their ids and times, e.g. while migrating them.
+With splitPayload the payloads are written in the payload table instead, see storePayloadColumns,
+and the ids are always written since they are allocated beforehand.
+*/
+func storeJobColumns(copyID, splitPayload bool) []string {
+	var columns []string
+	if copyID || splitPayload {
+		columns = append(columns, "job_id")
+	}
+	columns = append(columns, "uuid", "user_id", "custom_val", "parameters")
+	if !splitPayload {
+		columns = append(columns, "event_payload")
+	}
+	columns = append(columns, "event_count")
+	if copyID {
+		columns = append(columns, "created_at", "expire_at")
+	}
+	return append(columns, "workspace_id")
+}
+
+//storeJobArgs returns the values of a job in the order of storeJobColumns.
+//The payload is redacted only if redact is true, i.e. while ingesting the job: the payloads of the jobs already stored,
+//e.g. being migrated or copied, have been redacted then.
+func (jd *HandleT) storeJobArgs(job *JobT, copyID, splitPayload, redact bool) ([]interface{}, error) {
+	eventCount := 1
+	if job.EventCount > 1 {
+		eventCount = job.EventCount
+	}
+
+	var args []interface{}
+	if copyID || splitPayload {
+		args = append(args, job.JobID)
+	}
+	args = append(args, job.UUID, job.UserID, job.CustomVal, string(job.Parameters))
+	if !splitPayload {
+		eventPayload, err := jd.storedPayload(job.EventPayload, redact)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, string(eventPayload))
+	}
+	args = append(args, eventCount)
+	if copyID {
+		args = append(args, job.CreatedAt, job.ExpireAt)
+	}
+	return append(args, job.WorkspaceId), nil
+}
+
+//storePayloadColumns are the payload table columns which are written while storing jobs, see payloadTable
+var storePayloadColumns = []string{"job_id", "event_payload"}
+
+//storePayloadArgs returns the values of a job's payload in the order of storePayloadColumns, redacted only if redact is true like storeJobArgs
+func (jd *HandleT) storePayloadArgs(job *JobT, redact bool) ([]interface{}, error) {
+	eventPayload, err := jd.storedPayload(job.EventPayload, redact)
+	if err != nil {
+		return nil, err
+	}
+	return []interface{}{job.JobID, string(eventPayload)}, nil
+}
+
+//storedPayload returns the payload as it is to be stored, redacted if redact is true and with its null bytes handled.
+//Only the payloads being ingested are to be redacted: redacting again those being migrated or copied would e.g. hash the hashes.
+//Malformed payloads fail with ErrInvalidJSON if there are redactions, so that StoreWithRetryEach falls back to storing the jobs one at a time, see storeJobDS
+func (jd *HandleT) storedPayload(payload []byte, redact bool) ([]byte, error) {
+	if redact {
+		var err error
+		if payload, err = jd.redaction.redact(payload); err != nil {
+			return nil, err
+		}
+	}
+	return sanitizeNullBytes(payload)
+}
+
+//sanitizeNullBytes handles the null bytes in the payload according to nullByteStrategy,
+//since postgres doesn't accept them in jsonb columns
+func sanitizeNullBytes(payload []byte) ([]byte, error) {
+	escapes := nullByteEscapeIndexes(payload)
+	if len(escapes) == 0 {
+		return payload, nil
+	}
+	switch nullByteStrategy {
+	case nullByteStrategyReject:
+		return nil, ErrContainsNullBytes
+	case nullByteStrategyReplace:
+		return replaceNullByteEscapes(payload, escapes, nullBytePlaceholder), nil
+	default:
+		return replaceNullByteEscapes(payload, escapes, nil), nil
+	}
+}
+
+//nullByteEscapeIndexes returns the indexes of the null byte escapes in the json payload.
+//A \u0000 preceded by an odd number of backslashes isn't one, e.g. in "\\u0000" it is an escaped backslash followed by the text u0000.
+func nullByteEscapeIndexes(payload []byte) []int {
+	var indexes []int
+	for offset := 0; ; {
+		i := bytes.Index(payload[offset:], nullByteEscape)
+		if i < 0 {
+			return indexes
+		}
+		i += offset
+		backslashes := 0
+		for j := i - 1; j >= 0 && payload[j] == '\\'; j-- {
+			backslashes++
+		}
+		if backslashes%2 == 0 {
+			indexes = append(indexes, i)
+		}
+		offset = i + len(nullByteEscape)
+	}
+}
+
+//replaceNullByteEscapes replaces the null byte escapes at the indexes of the payload with replacement
+func replaceNullByteEscapes(payload []byte, indexes []int, replacement []byte) []byte {
+	replaced := make([]byte, 0, len(payload))
+	from := 0
+	for _, i := range indexes {
+		replaced = append(replaced, payload[from:i]...)
+		replaced = append(replaced, replacement...)
+		from = i + len(nullByteEscape)
+	}
+	return append(replaced, payload[from:]...)
+}
+
+//rejectsNullBytes tells if the job would fail with ErrContainsNullBytes while being stored, with JobsDB.nullByteStrategy reject
+func (jd *HandleT) rejectsNullBytes(job *JobT) bool {
+	if nullByteStrategy != nullByteStrategyReject || len(nullByteEscapeIndexes(job.EventPayload)) == 0 {
+		return false
+	}
+	//the null bytes may be in the fields being redacted
+	redacted, err := jd.redaction.redact(job.EventPayload)
+	return err != nil || len(nullByteEscapeIndexes(redacted)) > 0
+}
+
+//Actions applied to the redacted fields of the payloads, see RedactionConfig
+const (
+	//RedactionDrop removes the fields
+	RedactionDrop = "drop"
+	//RedactionHash replaces the fields with the hex encoded sha256 of their values, so that they can still be correlated
+	RedactionHash = "hash"
+)
+
+//RedactionConfig lists the fields of the event payloads to be redacted before they are stored, e.g. for PII compliance.
+//Nothing is redacted if there are no paths.
+type RedactionConfig struct {
+	//Paths of the fields in gjson syntax, e.g. context.traits.email
+	Paths []string
+	//Action is RedactionDrop or RedactionHash
+	Action string
+}
+
+//redact applies the redactions to the payload, returning ErrInvalidJSON for malformed payloads
+func (c RedactionConfig) redact(payload []byte) ([]byte, error) {
+	if len(c.Paths) == 0 {
+		return payload, nil
+	}
+	if !gjson.ValidBytes(payload) {
+		return nil, ErrInvalidJSON
+	}
+	var err error
+	for _, path := range c.Paths {
+		value := gjson.GetBytes(payload, path)
+		if !value.Exists() {
+			continue
+		}
+		if c.Action == RedactionHash {
+			hashed := value.Raw
+			if value.Type == gjson.String {
+				hashed = value.Str
+			}
+			payload, err = sjson.SetBytes(payload, path, fmt.Sprintf("%x", sha256.Sum256([]byte(hashed))))
+		} else {
+			payload, err = sjson.DeleteBytes(payload, path)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return payload, nil
+}
+
+//abortReasonKeys are the keys of the error response looked up for the abort reason, in order of preference
+var abortReasonKeys = []string{"reason", "error", "message"}
+
+//abortReason returns the abort reason to be stored with a status, which is empty unless the job is aborted.
+//If the caller hasn't set one, it is taken from the error response.
+func abortReason(status *JobStatusT) string {
+	if status.JobState != Aborted.State {
+		return ""
+	}
+	if status.AbortReason != "" {
+		return status.AbortReason
+	}
+	for _, key := range abortReasonKeys {
+		if reason := gjson.GetBytes(status.ErrorResponse, key); reason.Exists() {
+			return reason.String()
+		}
+	}
+	return ""
+}
+
+/*
+ComputeRetryTime returns the time at which a job failing its attempt-th attempt should be retried,
+backing off exponentially from base for the first attempt, doubling with every attempt, up to max.
+*/
+func ComputeRetryTime(attempt int, base, max time.Duration) time.Time {
+	return time.Now().Add(retryBackoff(attempt, base, max))
+}
+
+//retryBackoff returns the backoff of ComputeRetryTime
+func retryBackoff(attempt int, base, max time.Duration) time.Duration {
+	backoff := base
+	//doubling one attempt at a time, so that a large attempt can't overflow the duration
+	for i := 1; i < attempt && backoff > 0 && backoff < max; i++ {
+		backoff *= 2
+	}
+	if backoff > max {
+		return max
+	}
+	return backoff
+}
+
+//populateRetryTime sets the retry_time of the failed statuses left zero by the caller from their attempt, if JobsDB.autoRetryTime is true
+func (jd *HandleT) populateRetryTime(statusList []*JobStatusT) {
+	if !jd.autoRetryTime {
+		return
+	}
+	for _, status := range statusList {
+		if status.JobState == Failed.State && status.RetryTime.IsZero() {
+			status.RetryTime = ComputeRetryTime(status.AttemptNum, jd.retryTimeBase, jd.retryTimeMax)
+		}
+	}
+}
+
+func (jd *HandleT) storeJobDS(ds dataSetT, job *JobT) (err error) {
+	eventPayload, err := jd.redaction.redact(job.EventPayload)
+	if err != nil {
+		//malformed payloads are left untouched, for postgres to reject them
+		eventPayload = job.EventPayload
+	}
+	eventPayload, err = sanitizeNullBytes(eventPayload)
+	if err != nil {
+		return err
+	}
+	sqlStatement := fmt.Sprintf(`INSERT INTO "%s" (uuid, user_id, custom_val, parameters, event_payload)
+	                                   VALUES ($1, $2, $3, $4, $5::jsonb) RETURNING job_id`, ds.JobTable)
+	if payloadTable, ok := jd.payloadTable(ds); ok {
+		sqlStatement = fmt.Sprintf(`WITH job AS (INSERT INTO "%s" (uuid, user_id, custom_val, parameters) VALUES ($1, $2, $3, $4) RETURNING job_id)
+		                                   INSERT INTO "%s" (job_id, event_payload) SELECT job_id, $5::jsonb FROM job RETURNING job_id`, ds.JobTable, payloadTable)
+	}
+	stmt, err := jd.dbHandle.Prepare(sqlStatement)
+	jd.assertError(err)
+	defer stmt.Close()
+	_, err = stmt.Exec(job.UUID, job.UserID, job.CustomVal, string(job.Parameters), string(eventPayload))
+	if err == nil {
+		//Empty customValFilters means we want to clear for all
+		jd.markClearEmptyResult(ds, allWorkspaces, []string{}, []string{}, nil, hasJobs, nil)
+		jd.markClearEmptyResult(ds, job.WorkspaceId, []string{}, []string{}, nil, hasJobs, nil)
+		// fmt.Println("Bursting CACHE")
+		return
+	}
+	pqErr, ok := err.(*pq.Error)
+	if ok {
+		errCode := string(pqErr.Code)
+		if errCode == dbErrorMap["Invalid JSON"] || errCode == dbErrorMap["Invalid Unicode"] ||
+			errCode == dbErrorMap["Invalid Escape Sequence"] || errCode == dbErrorMap["Invalid Escape Character"] {
+			return ErrInvalidJSON
+		}
+	}
+	return
+}
+
+type cacheValue string
+
+const (
+	hasJobs         cacheValue = "Has Jobs"
+	noJobs          cacheValue = "No Jobs"
+	dropDSFromCache cacheValue = "Drop DS From Cache"
+	/*
+	* willTryToSet value is used to prevent wrongly setting empty result when
+	* a db update (new jobs or job status updates) happens during get(Un)Processed db query is in progress.
+	*
+	* getUnprocessedJobs() {  # OR getProcessedJobsDS
+	* 0. Sets cache value to willTryToSet
+	* 1. out = queryDB()
+	* 2. check and set cache to (len(out) == 0) only if cache value is willTryToSet
+	* }
+	 */
+	willTryToSet cacheValue = "Query in progress"
+)
+
+type cacheEntry struct {
+	Value cacheValue `json:"value"`
+	T     time.Time  `json:"set_at"`
+	//Until, if set, is the time the entry expires at before cacheExpiration, see markClearEmptyResultUntil
+	Until time.Time `json:"until,omitempty"`
+}
+
+func (jd *HandleT) dropDSFromCache(ds dataSetT) {
+	jd.dsCacheLock.Lock()
+	defer jd.dsCacheLock.Unlock()
+
+	delete(jd.dsEmptyResultCache, ds)
+}
+
+/*
+* If a query returns empty result for a specific dataset, we cache that so that
+* future queries don't have to hit the DB.
+* markClearEmptyResult() when mark=True marks dataset,customVal,state as empty.
+* markClearEmptyResult() when mark=False clears a previous empty mark
+ */
+
+func (jd *HandleT) markClearEmptyResult(ds dataSetT, workspace string, stateFilters []string, customValFilters []string, parameterFilters []ParameterFilterT, value cacheValue, checkAndSet *cacheValue) {
+	jd.markClearEmptyResultUntil(ds, workspace, stateFilters, customValFilters, parameterFilters, value, checkAndSet, time.Time{})
+}
+
+//markClearEmptyResultUntil is markClearEmptyResult with the entries expiring at until, if earlier than cacheExpiration
+//and not zero, e.g. when jobs become due without any new status being written
+func (jd *HandleT) markClearEmptyResultUntil(ds dataSetT, workspace string, stateFilters []string, customValFilters []string, parameterFilters []ParameterFilterT, value cacheValue, checkAndSet *cacheValue, until time.Time) {
+	// Safe check. Every status must have a valid workspace id for the cache to work efficiently.
+	if workspace == "" {
+		jd.logger.Errorf("[%s] Empty workspace key provided while looking into jobsdb cachemap", jd.tablePrefix)
+		jd.invalidCacheKeyStat.Increment()
+	}
+
+	jd.dsCacheLock.Lock()
+	defer jd.dsCacheLock.Unlock()
+
+	//This means we want to mark/clear all customVals and stateFilters
+	//When clearing, we remove the entire dataset entry. Not a big issue
+	//We process ALL only during internal migration and caching empty
+	//results is not important
+	if len(stateFilters) == 0 || len(customValFilters) == 0 {
+		if value == hasJobs || value == dropDSFromCache {
+			delete(jd.dsEmptyResultCache, ds)
+		}
+		return
 	}
 
 	_, ok := jd.dsEmptyResultCache[ds]
@@ -2045,6 +3373,7 @@ func (jd *HandleT) markClearEmptyResult(ds dataSetT, workspace string, stateFilt
 				jd.dsEmptyResultCache[ds][workspace][cVal][pVal][st] = cacheEntry{
 					Value: value,
 					T:     time.Now(),
+					Until: until,
 				}
 			}
 		}
@@ -2056,7 +3385,7 @@ func (jd *HandleT) markClearEmptyResult(ds dataSetT, workspace string, stateFilt
 //  All of the condition above apply:
 // 	* There is a cache entry for this dataset, customVal, parameterFilter, stateFilter
 //  * The entry is noJobs
-//  * The entry is not expired (entry time + cache expiration > now)
+//  * The entry is not expired (entry time + cache expiration > now, and its until time if any > now)
 func (jd *HandleT) isEmptyResult(ds dataSetT, workspace string, stateFilters []string, customValFilters []string, parameterFilters []ParameterFilterT) bool {
 	queryStat := stats.NewTaggedStat("isEmptyCheck", stats.TimerType, stats.Tags{"customVal": jd.tablePrefix})
 	queryStat.Start()
@@ -2100,7 +3429,7 @@ func (jd *HandleT) isEmptyResult(ds dataSetT, workspace string, stateFilters []s
 
 		for _, st := range stateFilters {
 			mark, ok := jd.dsEmptyResultCache[ds][workspace][cVal][pVal][st]
-			if !ok || mark.Value != noJobs || time.Now().After(mark.T.Add(cacheExpiration)) {
+			if !ok || mark.Value != noJobs || time.Now().After(mark.T.Add(cacheExpiration)) || (!mark.Until.IsZero() && !time.Now().Before(mark.Until)) {
 				return false
 			}
 		}
@@ -2110,30 +3439,72 @@ func (jd *HandleT) isEmptyResult(ds dataSetT, workspace string, stateFilters []s
 	return true
 }
 
+//processedJobsConditions returns the conditions on the jobs and their latest state of the params which take arguments,
+//along with args extended by their arguments
+func processedJobsConditions(params GetQueryParamsT, args []interface{}) (string, []interface{}) {
+	var conditions string
+	if len(params.ParametersContains) > 0 {
+		conditions += fmt.Sprintf(" AND jobs.parameters @> $%d::jsonb", len(args)+1)
+		args = append(args, string(params.ParametersContains))
+	}
+	if len(params.WorkspaceFilters) > 0 {
+		conditions += fmt.Sprintf(" AND jobs.workspace_id = ANY($%d)", len(args)+1)
+		args = append(args, pq.Array(params.WorkspaceFilters))
+	}
+	switch {
+	case params.MinAttemptNum > 0 && params.MaxAttemptNum > 0:
+		conditions += fmt.Sprintf(" AND job_latest_state.attempt BETWEEN $%d AND $%d", len(args)+1, len(args)+2)
+		args = append(args, params.MinAttemptNum, params.MaxAttemptNum)
+	case params.MinAttemptNum > 0:
+		conditions += fmt.Sprintf(" AND job_latest_state.attempt >= $%d", len(args)+1)
+		args = append(args, params.MinAttemptNum)
+	case params.MaxAttemptNum > 0:
+		conditions += fmt.Sprintf(" AND job_latest_state.attempt <= $%d", len(args)+1)
+		args = append(args, params.MaxAttemptNum)
+	}
+	if params.MaxAttempts > 0 {
+		conditions += fmt.Sprintf(" AND job_latest_state.attempt < $%d", len(args)+1)
+		args = append(args, params.MaxAttempts)
+	}
+	return conditions, args
+}
+
 /*
 limitCount == 0 means return all
 stateFilters and customValFilters do a OR query on values passed in array
 parameterFilters do a AND query on values included in the map
+An error wrapping context.DeadlineExceeded is returned if the query takes longer than JobsDB.queryTimeout, any other error causes a panic
 */
-func (jd *HandleT) getProcessedJobsDS(ds dataSetT, getAll bool, limitCount int, params GetQueryParamsT) []*JobT {
+func (jd *HandleT) getProcessedJobsDS(ds dataSetT, getAll bool, limitCount int, params GetQueryParamsT) ([]*JobT, error) {
 	stateFilters := params.StateFilters
 	customValFilters := params.CustomValFilters
 	parameterFilters := params.ParameterFilters
 
 	checkValidJobState(jd, stateFilters)
+	checkValidParametersContains(jd, params.ParametersContains)
+	jd.assert(params.MinAttemptNum >= 0 && params.MaxAttemptNum >= 0, fmt.Sprintf("attempt bounds can't be negative, min: %d, max: %d", params.MinAttemptNum, params.MaxAttemptNum))
+	jd.assert(params.MaxAttemptNum == 0 || params.MinAttemptNum <= params.MaxAttemptNum, fmt.Sprintf("MinAttemptNum %d is greater than MaxAttemptNum %d", params.MinAttemptNum, params.MaxAttemptNum))
+	jd.assert(params.MaxAttempts >= 0, fmt.Sprintf("MaxAttempts can't be negative: %d", params.MaxAttempts))
 
-	if jd.isEmptyResult(ds, allWorkspaces, stateFilters, customValFilters, parameterFilters) {
+	useEmptyCache := params.usesEmptyResultCache()
+	if useEmptyCache && jd.isEmptyResult(ds, allWorkspaces, stateFilters, customValFilters, parameterFilters) {
 		jd.logger.Debugf("[getProcessedJobsDS] Empty cache hit for ds: %v, stateFilters: %v, customValFilters: %v, parameterFilters: %v", ds, stateFilters, customValFilters, parameterFilters)
-		return []*JobT{}
+		return []*JobT{}, nil
 	}
 
-	tags := StatTagsT{CustomValFilters: params.CustomValFilters, StateFilters: params.StateFilters, ParameterFilters: params.ParameterFilters}
+	tags := StatTagsT{CustomValFilters: params.CustomValFilters, StateFilters: params.StateFilters, ParameterFilters: params.ParameterFilters, WorkspaceID: jd.statWorkspaceID(params.WorkspaceID)}
 	queryStat := jd.getTimerStat("processed_ds_time", tags)
 	queryStat.Start()
 	defer queryStat.End()
 
-	// We don't reset this in case of error for now, as any error in this function causes panic
-	jd.markClearEmptyResult(ds, allWorkspaces, stateFilters, customValFilters, parameterFilters, willTryToSet, nil)
+	//A replica can lag behind the statuses which clear the empty result cache, so the results it serves aren't cached
+	setEmptyCache := useEmptyCache && !params.fromReplica
+
+	// We don't reset this in case of error for now, as any error other than a timeout causes panic,
+	// and the entry isn't set as empty without a result
+	if setEmptyCache {
+		jd.markClearEmptyResult(ds, allWorkspaces, stateFilters, customValFilters, parameterFilters, willTryToSet, nil)
+	}
 
 	var stateQuery, customValQuery, limitQuery, sourceQuery string
 
@@ -2157,6 +3528,10 @@ func (jd *HandleT) getProcessedJobsDS(ds dataSetT, getAll bool, limitCount int,
 		sourceQuery = ""
 	}
 
+	if !params.usesEmptyResultCache() {
+		jd.assert(!getAll, "getAll is true")
+	}
+
 	if limitCount > 0 {
 		jd.assert(!getAll, "getAll is true")
 		limitQuery = fmt.Sprintf(" LIMIT %d ", limitCount)
@@ -2164,37 +3539,51 @@ func (jd *HandleT) getProcessedJobsDS(ds dataSetT, getAll bool, limitCount int,
 		limitQuery = ""
 	}
 
+	//The migrations read whole datasets, so they aren't bound by queryTimeout
+	ctx, cancel := context.Background(), context.CancelFunc(func() {})
+	if !getAll {
+		ctx, cancel = jd.queryContext()
+	}
+	defer cancel()
+
 	var rows *sql.Rows
+	//the jobs are due if their retry_time is before queryTime
+	queryTime := getTimeNowFunc()
 	if getAll {
+		payloadColumn, payloadJoin := jd.payloadSelect(ds, false)
 		sqlStatement := fmt.Sprintf(`SELECT
-                                  jobs.job_id, jobs.uuid, jobs.user_id, jobs.parameters,  jobs.custom_val, jobs.event_payload, jobs.event_count,
+                                  jobs.job_id, jobs.uuid, jobs.user_id, jobs.parameters,  jobs.custom_val, %[4]s, jobs.event_count,
                                   jobs.created_at, jobs.expire_at, jobs.workspace_id,
 								  sum(jobs.event_count) over (order by jobs.job_id asc) as running_event_counts,
                                   job_latest_state.job_state, job_latest_state.attempt,
                                   job_latest_state.exec_time, job_latest_state.retry_time,
                                   job_latest_state.error_code, job_latest_state.error_response, job_latest_state.parameters
                                  FROM
-                                  "%[1]s" AS jobs,
+                                  "%[1]s" AS jobs%[5]s,
                                   (SELECT job_id, job_state, attempt, exec_time, retry_time,
                                     error_code, error_response,parameters FROM "%[2]s" WHERE id IN
                                     (SELECT MAX(id) from "%[2]s" GROUP BY job_id) %[3]s)
                                   AS job_latest_state
                                    WHERE jobs.job_id=job_latest_state.job_id`,
-			ds.JobTable, ds.JobStatusTable, stateQuery)
+			ds.JobTable, ds.JobStatusTable, stateQuery, payloadColumn, payloadJoin)
 		var err error
 		rows, err = jd.dbHandle.Query(sqlStatement)
 		jd.assertError(err)
 		defer rows.Close()
 	} else {
+		conditions, args := processedJobsConditions(params, []interface{}{queryTime})
+		sourceQuery += conditions
+
+		payloadColumn, payloadJoin := jd.payloadSelect(ds, params.SkipPayload)
 		sqlStatement := fmt.Sprintf(`SELECT
-                                               jobs.job_id, jobs.uuid, jobs.user_id, jobs.parameters, jobs.custom_val, jobs.event_payload, jobs.event_count,
+                                               jobs.job_id, jobs.uuid, jobs.user_id, jobs.parameters, jobs.custom_val, %[7]s, jobs.event_count,
                                                jobs.created_at, jobs.expire_at, jobs.workspace_id,
 											   sum(jobs.event_count) over (order by jobs.job_id asc) as running_event_counts,
                                                job_latest_state.job_state, job_latest_state.attempt,
                                                job_latest_state.exec_time, job_latest_state.retry_time,
                                                job_latest_state.error_code, job_latest_state.error_response, job_latest_state.parameters
                                             FROM
-                                               "%[1]s" AS jobs,
+                                               "%[1]s" AS jobs%[8]s,
                                                (SELECT job_id, job_state, attempt, exec_time, retry_time,
                                                  error_code, error_response, parameters FROM "%[2]s" WHERE id IN
                                                    (SELECT MAX(id) from "%[2]s" GROUP BY job_id) %[3]s)
@@ -2202,9 +3591,8 @@ func (jd *HandleT) getProcessedJobsDS(ds dataSetT, getAll bool, limitCount int,
                                             WHERE jobs.job_id=job_latest_state.job_id
                                              %[4]s %[5]s
                                              AND job_latest_state.retry_time < $1 ORDER BY jobs.job_id %[6]s`,
-			ds.JobTable, ds.JobStatusTable, stateQuery, customValQuery, sourceQuery, limitQuery)
+			ds.JobTable, ds.JobStatusTable, stateQuery, customValQuery, sourceQuery, limitQuery, payloadColumn, payloadJoin)
 
-		args := []interface{}{getTimeNowFunc()}
 		if params.EventCount > 0 {
 			sqlStatement = fmt.Sprintf(`SELECT * FROM (`+sqlStatement+`) t WHERE running_event_counts - t.event_count + 1 <= $%d;`, len(args)+1)
 			// EXPLAIN `running_event_counts - t.event_count + 1`: If the event count limit "splits" a job we want this jobs to be returned.
@@ -2212,10 +3600,16 @@ func (jd *HandleT) getProcessedJobsDS(ds dataSetT, getAll bool, limitCount int,
 			args = append(args, params.EventCount)
 		}
 
-		stmt, err := jd.dbHandle.Prepare(sqlStatement)
-		jd.assertError(err)
-		defer stmt.Close()
-		rows, err = stmt.Query(args...)
+		//Not prepared, so that no statement is left open if the query times out
+		var err error
+		dbHandle := jd.dbHandle
+		if params.fromReplica {
+			dbHandle = jd.replicaDBHandle
+		}
+		rows, err = dbHandle.QueryContext(ctx, sqlStatement, args...)
+		if err := jd.queryTimeoutError(ctx, ds, err); err != nil {
+			return nil, err
+		}
 		jd.assertError(err)
 		defer rows.Close()
 	}
@@ -2231,30 +3625,65 @@ func (jd *HandleT) getProcessedJobsDS(ds dataSetT, getAll bool, limitCount int,
 		jd.assertError(err)
 		jobList = append(jobList, &job)
 	}
+	if err := jd.queryTimeoutError(ctx, ds, rows.Err()); err != nil {
+		return nil, err
+	}
 
 	result := hasJobs
+	var until time.Time
 	if len(jobList) == 0 {
 		jd.logger.Debugf("[getProcessedJobsDS] Setting empty cache for ds: %v, stateFilters: %v, customValFilters: %v, parameterFilters: %v", ds, stateFilters, customValFilters, parameterFilters)
 		result = noJobs
+		//Waiting jobs can be scheduled for a future retry_time (see MarkWaiting), which becomes due without any new status being written.
+		//So the empty result is cached only until the earliest of them is due.
+		if setEmptyCache && misc.ContainsString(stateFilters, Waiting.State) {
+			var err error
+			if until, err = jd.getNextWaitingRetryTime(ds, queryTime); err != nil {
+				jd.logger.Errorf("[getProcessedJobsDS] Not caching the empty result for ds: %v, failed to get the next retry time of its waiting jobs: %v", ds, err)
+				result = hasJobs
+			}
+		}
+	}
+	if setEmptyCache {
+		_willTryToSet := willTryToSet
+		jd.markClearEmptyResultUntil(ds, allWorkspaces, stateFilters, customValFilters, parameterFilters, result, &_willTryToSet, until)
 	}
-	_willTryToSet := willTryToSet
-	jd.markClearEmptyResult(ds, allWorkspaces, stateFilters, customValFilters, parameterFilters, result, &_willTryToSet)
 
-	return jobList
+	return jobList, nil
+}
+
+//getNextWaitingRetryTime returns the earliest retry_time from after on of the jobs of the dataset waiting as their latest status,
+//or the zero time if there are none
+func (jd *HandleT) getNextWaitingRetryTime(ds dataSetT, after time.Time) (time.Time, error) {
+	ctx, cancel := jd.queryContext()
+	defer cancel()
+
+	var nextRetryTime sql.NullTime
+	sqlStatement := fmt.Sprintf(`SELECT MIN(retry_time) FROM "%[1]s" WHERE id IN (SELECT MAX(id) FROM "%[1]s" GROUP BY job_id) AND job_state = $1 AND retry_time >= $2`, ds.JobStatusTable)
+	err := jd.dbHandle.QueryRowContext(ctx, sqlStatement, Waiting.State, after).Scan(&nextRetryTime)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return nextRetryTime.Time, nil
 }
 
 /*
 count == 0 means return all
 stateFilters and customValFilters do a OR query on values passed in array
 parameterFilters do a AND query on values included in the map
+An error wrapping context.DeadlineExceeded is returned if the query takes longer than JobsDB.queryTimeout, any other error causes a panic
 */
-func (jd *HandleT) getUnprocessedJobsDS(ds dataSetT, order bool, count int, params GetQueryParamsT) []*JobT {
+func (jd *HandleT) getUnprocessedJobsDS(ds dataSetT, order bool, count int, params GetQueryParamsT) ([]*JobT, error) {
 	customValFilters := params.CustomValFilters
 	parameterFilters := params.ParameterFilters
 
-	if jd.isEmptyResult(ds, allWorkspaces, []string{NotProcessed.State}, customValFilters, parameterFilters) {
+	checkValidParametersContains(jd, params.ParametersContains)
+
+	//The empty result cache isn't keyed by ParametersContains or WorkspaceFilters, so it can neither be used nor set for such queries
+	useEmptyCache := len(params.ParametersContains) == 0 && len(params.WorkspaceFilters) == 0
+	if useEmptyCache && jd.isEmptyResult(ds, allWorkspaces, []string{NotProcessed.State}, customValFilters, parameterFilters) {
 		jd.logger.Debugf("[getUnprocessedJobsDS] Empty cache hit for ds: %v, stateFilters: NP, customValFilters: %v, parameterFilters: %v", ds, customValFilters, parameterFilters)
-		return []*JobT{}
+		return []*JobT{}, nil
 	}
 
 	tags := StatTagsT{CustomValFilters: params.CustomValFilters, ParameterFilters: params.ParameterFilters}
@@ -2262,8 +3691,11 @@ func (jd *HandleT) getUnprocessedJobsDS(ds dataSetT, order bool, count int, para
 	queryStat.Start()
 	defer queryStat.End()
 
-	// We don't reset this in case of error for now, as any error in this function causes panic
-	jd.markClearEmptyResult(ds, allWorkspaces, []string{NotProcessed.State}, customValFilters, parameterFilters, willTryToSet, nil)
+	// We don't reset this in case of error for now, as any error other than a timeout causes panic,
+	// and the entry isn't set as empty without a result
+	if useEmptyCache {
+		jd.markClearEmptyResult(ds, allWorkspaces, []string{NotProcessed.State}, customValFilters, parameterFilters, willTryToSet, nil)
+	}
 
 	var rows *sql.Rows
 	var err error
@@ -2271,22 +3703,23 @@ func (jd *HandleT) getUnprocessedJobsDS(ds dataSetT, order bool, count int, para
 
 	var sqlStatement string
 
+	payloadColumn, payloadJoin := jd.payloadSelect(ds, params.SkipPayload)
 	if useJoinForUnprocessed {
 		// event_count default 1, number of items in payload
 		sqlStatement = fmt.Sprintf(
-			`SELECT jobs.job_id, jobs.uuid, jobs.user_id, jobs.parameters, jobs.custom_val, jobs.event_payload, jobs.event_count, jobs.created_at, jobs.expire_at, jobs.workspace_id,`+
+			`SELECT jobs.job_id, jobs.uuid, jobs.user_id, jobs.parameters, jobs.custom_val, %[3]s, jobs.event_count, jobs.created_at, jobs.expire_at, jobs.workspace_id,`+
 				`	sum(jobs.event_count) over (order by jobs.job_id asc) as running_event_counts `+
 				`FROM "%[1]s" AS jobs `+
-				`LEFT JOIN "%[2]s" AS job_status ON jobs.job_id=job_status.job_id `+
+				`LEFT JOIN "%[2]s" AS job_status ON jobs.job_id=job_status.job_id%[4]s `+
 				`WHERE job_status.job_id is NULL `,
-			ds.JobTable, ds.JobStatusTable)
+			ds.JobTable, ds.JobStatusTable, payloadColumn, payloadJoin)
 	} else {
 		sqlStatement = fmt.Sprintf(
-			`SELECT jobs.job_id, jobs.uuid, jobs.user_id, jobs.parameters, jobs.custom_val, jobs.event_payload, jobs.event_count, jobs.created_at, jobs.expire_at, jobs.workspace_id,`+
+			`SELECT jobs.job_id, jobs.uuid, jobs.user_id, jobs.parameters, jobs.custom_val, %[3]s, jobs.event_count, jobs.created_at, jobs.expire_at, jobs.workspace_id,`+
 				`	sum(jobs.event_count) over (order by jobs.job_id asc) as running_event_counts `+
-				` FROM AS jobs `+
+				` FROM AS jobs%[4]s `+
 				`WHERE jobs.job_id NOT IN (SELECT DISTINCT(job_status.job_id) FROM "%[2]s" AS job_status)`,
-			ds.JobTable, ds.JobStatusTable)
+			ds.JobTable, ds.JobStatusTable, payloadColumn, payloadJoin)
 	}
 
 	if len(customValFilters) > 0 && !params.IgnoreCustomValFiltersInQuery {
@@ -2297,6 +3730,16 @@ func (jd *HandleT) getUnprocessedJobsDS(ds dataSetT, order bool, count int, para
 		sqlStatement += " AND " + constructParameterJSONQuery("jobs", parameterFilters)
 	}
 
+	if len(params.ParametersContains) > 0 {
+		sqlStatement += fmt.Sprintf(" AND jobs.parameters @> $%d::jsonb", len(args)+1)
+		args = append(args, string(params.ParametersContains))
+	}
+
+	if len(params.WorkspaceFilters) > 0 {
+		sqlStatement += fmt.Sprintf(" AND jobs.workspace_id = ANY($%d)", len(args)+1)
+		args = append(args, pq.Array(params.WorkspaceFilters))
+	}
+
 	if params.UseTimeFilter {
 		sqlStatement += fmt.Sprintf(" AND created_at < $%d", len(args)+1)
 		args = append(args, params.Before)
@@ -2315,16 +3758,19 @@ func (jd *HandleT) getUnprocessedJobsDS(ds dataSetT, order bool, count int, para
 		args = append(args, params.EventCount)
 	}
 
-	if params.UseTimeFilter {
-		stmt, err := jd.dbHandle.Prepare(sqlStatement)
-		jd.assertError(err)
-		defer stmt.Close()
-		rows, err = stmt.Query(args...)
-		jd.assertError(err)
-	} else {
-		rows, err = jd.dbHandle.Query(sqlStatement, args...)
-		jd.assertError(err)
+	//The migrations read whole datasets, so they aren't bound by queryTimeout
+	ctx, cancel := context.Background(), context.CancelFunc(func() {})
+	if count > 0 {
+		ctx, cancel = jd.queryContext()
 	}
+	defer cancel()
+
+	//Not prepared, so that no statement is left open if the query times out
+	rows, err = jd.dbHandle.QueryContext(ctx, sqlStatement, args...)
+	if err := jd.queryTimeoutError(ctx, ds, err); err != nil {
+		return nil, err
+	}
+	jd.assertError(err)
 	defer rows.Close()
 
 	var jobList []*JobT
@@ -2336,6 +3782,9 @@ func (jd *HandleT) getUnprocessedJobsDS(ds dataSetT, order bool, count int, para
 		jd.assertError(err)
 		jobList = append(jobList, &job)
 	}
+	if err := jd.queryTimeoutError(ctx, ds, rows.Err()); err != nil {
+		return nil, err
+	}
 
 	result := hasJobs
 	dsList := jd.getDSList(false)
@@ -2344,10 +3793,12 @@ func (jd *HandleT) getUnprocessedJobsDS(ds dataSetT, order bool, count int, para
 		jd.logger.Debugf("[getUnprocessedJobsDS] Setting empty cache for ds: %v, stateFilters: NP, customValFilters: %v, parameterFilters: %v", ds, customValFilters, parameterFilters)
 		result = noJobs
 	}
-	_willTryToSet := willTryToSet
-	jd.markClearEmptyResult(ds, allWorkspaces, []string{NotProcessed.State}, customValFilters, parameterFilters, result, &_willTryToSet)
+	if useEmptyCache {
+		_willTryToSet := willTryToSet
+		jd.markClearEmptyResult(ds, allWorkspaces, []string{NotProcessed.State}, customValFilters, parameterFilters, result, &_willTryToSet)
+	}
 
-	return jobList
+	return jobList, nil
 }
 
 func (jd *HandleT) updateJobStatusDS(ds dataSetT, statusList []*JobStatusT, customValFilters []string, parameterFilters []ParameterFilterT) (err error) {
@@ -2392,8 +3843,9 @@ func (jd *HandleT) updateJobStatusDSInTxn(txHandler transactionHandler, ds dataS
 	defer queryStat.End()
 
 	stmt, err := txHandler.Prepare(pq.CopyIn(ds.JobStatusTable, "job_id", "job_state", "attempt", "exec_time",
-		"retry_time", "error_code", "error_response", "parameters"))
+		"retry_time", "error_code", "error_response", "parameters", "abort_reason"))
 	if err != nil {
+		err = wrapOpError(ErrUpdateJobStatusPrepareFailed, err)
 		return
 	}
 
@@ -2408,8 +3860,9 @@ func (jd *HandleT) updateJobStatusDSInTxn(txHandler transactionHandler, ds dataS
 			status.ErrorResponse = []byte(`{}`)
 		}
 		_, err = stmt.Exec(status.JobID, status.JobState, status.AttemptNum, status.ExecTime,
-			status.RetryTime, status.ErrorCode, string(status.ErrorResponse), string(status.Parameters))
+			status.RetryTime, status.ErrorCode, string(status.ErrorResponse), string(status.Parameters), abortReason(status))
 		if err != nil {
+			err = wrapOpError(ErrUpdateJobStatusExecFailed, err)
 			return
 		}
 	}
@@ -2425,6 +3878,7 @@ func (jd *HandleT) updateJobStatusDSInTxn(txHandler transactionHandler, ds dataS
 
 	_, err = stmt.Exec()
 	if err != nil {
+		err = wrapOpError(ErrUpdateJobStatusExecFailed, err)
 		return
 	}
 
@@ -2492,32 +3946,138 @@ func (jd *HandleT) refreshDSListLoop(ctx context.Context) {
 
 		jd.logger.Debugf("[[ %s : refreshDSListLoop ]]: Start", jd.tablePrefix)
 
+		if refreshDSRangesFromDB {
+			jd.dsListLock.Lock()
+			err := jd.refreshDSList()
+			jd.dsListLock.Unlock()
+			if err != nil {
+				jd.logger.Errorf("[[ %s : refreshDSListLoop ]]: Failed to refresh ds list: %v", jd.tablePrefix, err)
+				continue
+			}
+			if err := jd.RefreshDataSetRanges(); err != nil {
+				jd.logger.Errorf("[[ %s : refreshDSListLoop ]]: Failed to refresh ds ranges, falling back to job_id ranges: %v", jd.tablePrefix, err)
+				jd.dsListLock.Lock()
+				jd.getDSRangeList(true)
+				jd.dsListLock.Unlock()
+			}
+			continue
+		}
+
 		jd.dsListLock.Lock()
-		jd.getDSList(true)
+		if err := jd.refreshDSList(); err != nil {
+			jd.dsListLock.Unlock()
+			jd.logger.Errorf("[[ %s : refreshDSListLoop ]]: Failed to refresh ds list: %v", jd.tablePrefix, err)
+			continue
+		}
 		jd.getDSRangeList(true)
-
 		jd.dsListLock.Unlock()
 	}
 }
 
-func (jd *HandleT) migrateDSLoop(ctx context.Context) {
+//failureByCodeStatLoop periodically emits the number of jobs whose latest state is failed, by custom_val and error code,
+//as the jobsdb_failure_by_code gauge. The gauges of the codes which no longer have failed jobs are reset to zero.
+func (jd *HandleT) failureByCodeStatLoop(ctx context.Context) {
+	var lastCounts map[string]map[string]int64
 	for {
 		select {
-		case <-time.After(migrateDSLoopSleepDuration):
+		case <-time.After(failureByCodeStatInterval):
 		case <-ctx.Done():
 			return
 		}
-		jd.logger.Debugf("[[ %s : migrateDSLoop ]]: Start", jd.tablePrefix)
 
-		//This block disables internal migration/consolidation while cluster-level migration is in progress
-		if db.IsValidMigrationMode(jd.migrationState.migrationMode) {
-			jd.logger.Debugf("[[ %s : migrateDSLoop ]]: migration mode = %s, so skipping internal migrations", jd.tablePrefix, jd.migrationState.migrationMode)
+		counts, err := jd.getFailureCountsByCode()
+		if err != nil {
+			jd.logger.Errorf("[[ %s : failureByCodeStatLoop ]]: Failed to count the failed jobs by error code: %v", jd.tablePrefix, err)
 			continue
 		}
+		jd.emitFailureByCodeStats(counts, lastCounts)
+		lastCounts = counts
+	}
+}
 
-		jd.dsListLock.RLock()
-		dsList := jd.getDSList(false)
-		jd.dsListLock.RUnlock()
+//getFailureCountsByCode returns the number of jobs whose latest state is failed across the datasets, by custom_val and error code
+func (jd *HandleT) getFailureCountsByCode() (map[string]map[string]int64, error) {
+	jd.dsMigrationLock.RLock()
+	jd.dsListLock.RLock()
+	defer jd.dsMigrationLock.RUnlock()
+	defer jd.dsListLock.RUnlock()
+
+	counts := make(map[string]map[string]int64)
+	for _, ds := range jd.getDSList(false) {
+		sqlStatement := fmt.Sprintf(`SELECT jobs.custom_val, COALESCE(job_latest_state.error_code, ''), COUNT(*) FROM "%[1]s" AS jobs,
+			(SELECT job_id, job_state, error_code FROM "%[2]s" WHERE id IN (SELECT MAX(id) FROM "%[2]s" GROUP BY job_id)) AS job_latest_state
+			WHERE jobs.job_id = job_latest_state.job_id AND job_latest_state.job_state = $1
+			GROUP BY jobs.custom_val, job_latest_state.error_code`,
+			ds.JobTable, ds.JobStatusTable)
+		rows, err := jd.dbHandle.Query(sqlStatement, Failed.State)
+		if err != nil {
+			return nil, err
+		}
+		for rows.Next() {
+			var customVal, errorCode string
+			var count int64
+			if err := rows.Scan(&customVal, &errorCode, &count); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			if _, ok := counts[customVal]; !ok {
+				counts[customVal] = make(map[string]int64)
+			}
+			counts[customVal][errorCode] += count
+		}
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return counts, nil
+}
+
+//emitFailureByCodeStats emits the counts of getFailureCountsByCode, along with zeroes for the ones only in lastCounts
+func (jd *HandleT) emitFailureByCodeStats(counts, lastCounts map[string]map[string]int64) {
+	emit := func(customVal, errorCode string, count int64) {
+		if errorCode == "" {
+			errorCode = "unknown"
+		}
+		stats.NewTaggedStat("jobsdb_failure_by_code", stats.GaugeType, stats.Tags{
+			"tablePrefix": jd.tablePrefix,
+			"custom_val":  customVal,
+			"code":        errorCode,
+		}).Gauge(count)
+	}
+	for customVal, codeCounts := range counts {
+		for errorCode, count := range codeCounts {
+			emit(customVal, errorCode, count)
+		}
+	}
+	for customVal, codeCounts := range lastCounts {
+		for errorCode := range codeCounts {
+			if _, ok := counts[customVal][errorCode]; !ok {
+				emit(customVal, errorCode, 0)
+			}
+		}
+	}
+}
+
+func (jd *HandleT) migrateDSLoop(ctx context.Context) {
+	for {
+		select {
+		case <-time.After(migrateDSLoopSleepDuration):
+		case <-ctx.Done():
+			return
+		}
+		jd.logger.Debugf("[[ %s : migrateDSLoop ]]: Start", jd.tablePrefix)
+
+		//This block disables internal migration/consolidation while cluster-level migration is in progress
+		if db.IsValidMigrationMode(jd.migrationState.migrationMode) {
+			jd.logger.Debugf("[[ %s : migrateDSLoop ]]: migration mode = %s, so skipping internal migrations", jd.tablePrefix, jd.migrationState.migrationMode)
+			continue
+		}
+
+		jd.dsListLock.RLock()
+		dsList := jd.getDSList(false)
+		jd.dsListLock.RUnlock()
 
 		var migrateFrom []dataSetT
 		var insertBeforeDS dataSetT
@@ -2587,6 +4147,7 @@ func (jd *HandleT) migrateDSLoop(ctx context.Context) {
 					jd.logger.Infof("[[ %s : migrateDSLoop ]]: Migrate: %v to: %v", jd.tablePrefix, ds, migrateTo)
 					noJobsMigrated, _ := jd.migrateJobs(ds, migrateTo)
 					totalJobsMigrated += noJobsMigrated
+					jd.dataSetMigrated(internalMigration, ds, migrateTo, noJobsMigrated)
 				}
 				jd.logger.Infof("[[ %s : migrateDSLoop ]]: Total migrated %d jobs", jd.tablePrefix, totalJobsMigrated)
 
@@ -2617,7 +4178,100 @@ func (jd *HandleT) migrateDSLoop(ctx context.Context) {
 		migrationLoopStat.End()
 		jd.dsMigrationLock.Unlock()
 
+		//Done after releasing the migration lock, since a VACUUM can take long
+		if len(migrateFrom) > 0 {
+			jd.postMigrateAnalyze()
+		}
+	}
+}
+
+//Different types of dataset migrations, used as the type tag of the jobsdb_migration stat
+const (
+	internalMigration = "internal"
+	importMigration   = "import"
+)
+
+//dataSetMigrated emits the jobsdb_migration stat for the jobs migrated from one dataset to another and calls OnDataSetMigration, if set
+func (jd *HandleT) dataSetMigrated(migrationType string, from, to dataSetT, jobCount int) {
+	stats.NewTaggedStat("jobsdb_migration", stats.CountType, stats.Tags{
+		"tablePrefix": jd.tablePrefix,
+		"type":        migrationType,
+	}).Count(jobCount)
+	if jd.OnDataSetMigration != nil {
+		jd.OnDataSetMigration(from.info(), to.info(), jobCount)
+	}
+}
+
+/*
+postMigrateAnalyze refreshes the planner statistics of the active dataset, if analyzeAfterMigration is set.
+Dropping the migrated datasets leaves them stale until autovacuum catches up, which degrades the query plans.
+*/
+func (jd *HandleT) postMigrateAnalyze() {
+	if !analyzeAfterMigration {
+		return
+	}
+
+	jd.dsListLock.RLock()
+	dsList := jd.getDSList(false)
+	jd.dsListLock.RUnlock()
+	if len(dsList) == 0 {
+		return
+	}
+
+	analyzeStat := stats.NewTaggedStat("jobsdb_post_migration_analyze", stats.TimerType, stats.Tags{"customVal": jd.tablePrefix})
+	analyzeStat.Start()
+	defer analyzeStat.End()
+	_ = jd.analyzeDS(dsList[len(dsList)-1], vacuumAfterMigration)
+}
+
+/*
+AnalyzeDataSet refreshes the planner statistics of the job and status tables of the dataset, vacuuming them too if vacuum is true.
+It is meant to be called after bulk deletions, which leave the statistics stale until autovacuum catches up.
+VACUUM reclaims the dead tuples too, but can take long on large tables.
+*/
+func (jd *HandleT) AnalyzeDataSet(ds dataSetT, vacuum bool) error {
+	analyzeStat := stats.NewTaggedStat("jobsdb_analyze_time", stats.TimerType, stats.Tags{"customVal": jd.tablePrefix, "vacuum": strconv.FormatBool(vacuum)})
+	analyzeStat.Start()
+	defer analyzeStat.End()
+	return jd.analyzeDS(ds, vacuum)
+}
+
+/*
+AnalyzeAllDataSets runs AnalyzeDataSet on all the datasets, oldest first.
+The rest of the datasets are analyzed even if one of them fails, and the first error is returned.
+*/
+func (jd *HandleT) AnalyzeAllDataSets(vacuum bool) error {
+	jd.dsListLock.RLock()
+	dsList := jd.getDSList(false)
+	jd.dsListLock.RUnlock()
+
+	var firstErr error
+	for _, ds := range dsList {
+		if err := jd.AnalyzeDataSet(ds, vacuum); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+//analyzeDS runs ANALYZE on the tables of the dataset, or VACUUM ANALYZE if vacuum is true, returning the first error.
+//It must not be run inside a transaction, since VACUUM can't be.
+func (jd *HandleT) analyzeDS(ds dataSetT, vacuum bool) error {
+	command := "ANALYZE"
+	if vacuum {
+		command = "VACUUM ANALYZE"
+	}
+	var firstErr error
+	for _, table := range []string{ds.JobTable, ds.JobStatusTable} {
+		sqlStatement := fmt.Sprintf(`%s "%s"`, command, table)
+		if _, err := jd.dbHandle.Exec(sqlStatement); err != nil {
+			jd.logger.Errorf("[[ %s : analyzeDS ]]: %s failed: %v", jd.tablePrefix, sqlStatement, err)
+			if firstErr == nil {
+				firstErr = fmt.Errorf("%s: %w", sqlStatement, err)
+			}
+		}
 	}
+	return firstErr
 }
 
 func (jd *HandleT) backupDSLoop(ctx context.Context) {
@@ -2712,14 +4366,25 @@ func (jd *HandleT) removeTableJSONDumps() {
 // getBackUpQuery individual queries for getting rows in json
 func (jd *HandleT) getBackUpQuery(backupDSRange dataSetRangeT, isJobStatusTable bool, offset int64) string {
 	var stmt string
+	//the payloads of the datasets with a payload table are backed up along with their jobs, as if they were in the jobs table
+	payloadTable, splitPayload := jd.payloadTable(backupDSRange.ds)
 	if jd.BackupSettings.FailedOnly {
 		// check failed and aborted state, order the output based on destination, job_id, exec_time
-		stmt = fmt.Sprintf(`SELECT coalesce(json_agg(failed_jobs), '[]'::json) FROM (select * from "%[1]s" %[2]s INNER JOIN "%[3]s" %[4]s ON  %[2]s.job_id = %[4]s.job_id
+		columns := "*"
+		var payloadJoin string
+		if splitPayload {
+			columns = "job_status.*, job.*, payloads.event_payload"
+			_, payloadJoin = payloadColumnAndJoin("job", payloadTable)
+		}
+		stmt = fmt.Sprintf(`SELECT coalesce(json_agg(failed_jobs), '[]'::json) FROM (select %[9]s from "%[1]s" %[2]s INNER JOIN "%[3]s" %[4]s ON  %[2]s.job_id = %[4]s.job_id%[10]s
 			where %[2]s.job_state in ('%[5]s', '%[6]s') order by  %[4]s.custom_val, %[2]s.job_id, %[2]s.exec_time asc limit %[7]d offset %[8]d) AS failed_jobs`, backupDSRange.ds.JobStatusTable, "job_status", backupDSRange.ds.JobTable, "job",
-			Failed.State, Aborted.State, backupRowsBatchSize, offset)
+			Failed.State, Aborted.State, backupRowsBatchSize, offset, columns, payloadJoin)
 	} else {
 		if isJobStatusTable {
 			stmt = fmt.Sprintf(`SELECT json_agg(dump_table) FROM (select * from "%[1]s" order by job_id asc limit %[2]d offset %[3]d) AS dump_table`, backupDSRange.ds.JobStatusTable, backupRowsBatchSize, offset)
+		} else if splitPayload {
+			payloadColumn, payloadJoin := payloadColumnAndJoin("jobs", payloadTable)
+			stmt = fmt.Sprintf(`SELECT json_agg(dump_table) FROM (select jobs.*, %[4]s from "%[1]s" AS jobs%[5]s order by jobs.job_id asc limit %[2]d offset %[3]d) AS dump_table`, backupDSRange.ds.JobTable, backupRowsBatchSize, offset, payloadColumn, payloadJoin)
 		} else {
 			stmt = fmt.Sprintf(`SELECT json_agg(dump_table) FROM (select * from "%[1]s" order by job_id asc limit %[2]d offset %[3]d) AS dump_table`, backupDSRange.ds.JobTable, backupRowsBatchSize, offset)
 		}
@@ -3203,10 +4868,12 @@ func (jd *HandleT) UpdateJobStatus(statusList []*JobStatusT, customValFilters []
 			parameterFiltersList: parameterFilters,
 			errorResponse:        respCh,
 		}
-		jd.writeChannel <- writeJobRequest
+		err := jd.enqueueWrite(writeJobRequest, false)
 		waitTimeStat.End()
-		err := <-respCh
-		return err
+		if err != nil {
+			return err
+		}
+		return <-respCh
 	} else {
 		return jd.updateJobStatus(statusList, customValFilters, parameterFilters)
 	}
@@ -3256,6 +4923,69 @@ func (jd *HandleT) updateJobStatus(statusList []*JobStatusT, customValFilters []
 	return nil
 }
 
+/*
+UpsertJobStatus stores the statuses as the latest status of their jobs in the <prefix>_job_latest_status table,
+keyed by job_id, instead of appending them to the status tables of the datasets like UpdateJobStatus does.
+This changes the status history semantics: only the latest status of a job is kept, each upsert overwriting the previous one,
+and the upserted statuses aren't seen by the queries on the datasets' status tables, e.g. GetToRetry or GetProcessed.
+It is meant for replays, where appending the statuses would bloat the status tables.
+It fails with ErrUpsertJobStatusDisabled unless JobsDB.upsertJobStatus is true.
+*/
+func (jd *HandleT) UpsertJobStatus(statusList []*JobStatusT) error {
+	if !jd.upsertJobStatus {
+		return ErrUpsertJobStatusDisabled
+	}
+	if len(statusList) == 0 {
+		return nil
+	}
+
+	queryStat := jd.getTimerStat("upsert_job_status_time", StatTagsT{})
+	queryStat.Start()
+	defer queryStat.End()
+
+	txn, err := jd.dbHandle.Begin()
+	if err != nil {
+		return err
+	}
+	err = jd.upsertJobStatusInTxn(txn, statusList)
+	if err != nil {
+		jd.rollbackTx(err, txn)
+		return err
+	}
+	return txn.Commit()
+}
+
+func (jd *HandleT) upsertJobStatusInTxn(txn *sql.Tx, statusList []*JobStatusT) error {
+	sqlStatement := fmt.Sprintf(`INSERT INTO "%s_job_latest_status" (job_id, job_state, attempt, exec_time, retry_time, error_code, error_response, parameters, workspace_id, abort_reason)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+		ON CONFLICT (job_id) DO UPDATE SET job_state = EXCLUDED.job_state, attempt = EXCLUDED.attempt, exec_time = EXCLUDED.exec_time,
+		retry_time = EXCLUDED.retry_time, error_code = EXCLUDED.error_code, error_response = EXCLUDED.error_response,
+		parameters = EXCLUDED.parameters, workspace_id = EXCLUDED.workspace_id, abort_reason = EXCLUDED.abort_reason`, jd.tablePrefix)
+	stmt, err := txn.Prepare(sqlStatement)
+	if err != nil {
+		return wrapOpError(ErrUpdateJobStatusPrepareFailed, err)
+	}
+	defer stmt.Close()
+
+	for _, status := range statusList {
+		if !utf8.ValidString(string(status.ErrorResponse)) {
+			status.ErrorResponse = []byte(`{}`)
+		}
+		_, err = stmt.Exec(status.JobID, status.JobState, status.AttemptNum, status.ExecTime, status.RetryTime,
+			status.ErrorCode, string(status.ErrorResponse), string(status.Parameters), status.WorkspaceId, abortReason(status))
+		if err != nil {
+			return wrapOpError(ErrUpdateJobStatusExecFailed, err)
+		}
+	}
+	return nil
+}
+
+func (jd *HandleT) dropLatestStatusTable() {
+	sqlStatement := fmt.Sprintf(`DROP TABLE IF EXISTS %s_job_latest_status`, jd.tablePrefix)
+	_, err := jd.dbHandle.Exec(sqlStatement)
+	jd.assertError(err)
+}
+
 /*
 updateJobStatusInTxn updates the status of a batch of jobs
 customValFilters[] is passed so we can efficinetly mark empty cache
@@ -3266,6 +4996,8 @@ func (jd *HandleT) updateJobStatusInTxn(txHandler transactionHandler, statusList
 		return
 	}
 
+	jd.populateRetryTime(statusList)
+
 	//First we sort by JobID
 	sort.Slice(statusList, func(i, j int) bool {
 		return statusList[i].JobID < statusList[j].JobID
@@ -3342,15 +5074,31 @@ func (jd *HandleT) updateJobStatusInTxn(txHandler transactionHandler, statusList
 
 /*
 Store call is used to create new Jobs
-If enableWriterQueue is true, this goes through writer worker pool.
+If enableWriterQueue is true, this goes through writer worker pool,
+waiting while the writer queue is full, or failing with ErrWriterQueueFull if JobsDB.writerQueueNonBlocking is true.
+If JobsDB.validateBeforeStore is true, nothing is stored if any of the jobs is invalid.
 */
 func (jd *HandleT) Store(jobList []*JobT) error {
-	totalWriteTime := jd.storeTimerStat("store_total_time")
+	totalWriteTime := jd.storeTimerStat("store_total_time", jobList)
 	totalWriteTime.Start()
 	defer totalWriteTime.End()
 
+	if validateBeforeStore {
+		for _, job := range jobList {
+			if err := job.Validate(); err != nil {
+				return fmt.Errorf("job %s: %w", job.UUID, err)
+			}
+		}
+	}
+	//the jobs are stored all together or none, so the error tells the job which can't be
+	for _, job := range jobList {
+		if jd.rejectsNullBytes(job) {
+			return fmt.Errorf("job %s: %w", job.UUID, ErrContainsNullBytes)
+		}
+	}
+
 	if jd.enableWriterQueue {
-		waitTimeStat := jd.storeTimerStat("store_wait_time")
+		waitTimeStat := jd.storeTimerStat("store_wait_time", jobList)
 		waitTimeStat.Start()
 		respCh := make(chan error)
 		writeJobRequest := writeJob{
@@ -3358,10 +5106,12 @@ func (jd *HandleT) Store(jobList []*JobT) error {
 			jobsList:      jobList,
 			errorResponse: respCh,
 		}
-		jd.writeChannel <- writeJobRequest
+		err := jd.enqueueWrite(writeJobRequest, jd.writerQueueNonBlocking)
 		waitTimeStat.End()
-		err := <-respCh
-		return err
+		if err != nil {
+			return err
+		}
+		return <-respCh
 	} else {
 		return jd.store(jobList)
 	}
@@ -3380,13 +5130,263 @@ func (jd *HandleT) store(jobList []*JobT) error {
 	return err
 }
 
+/*
+StoreInDataSet stores the jobs in the given dataset, keeping their job ids.
+It is meant for migration/backfill tooling, which needs to place jobs in the dataset whose job id range contains them,
+instead of the newest dataset used by Store.
+The dataset must be one of the datasets of this jobsdb and the job ids must lie within its range.
+For the dataset being actively written to, the job ids must be greater than those of the previous datasets.
+*/
+func (jd *HandleT) StoreInDataSet(ds dataSetT, jobList []*JobT) error {
+	jd.dsListLock.RLock()
+	defer jd.dsListLock.RUnlock()
+
+	minJobID, maxJobID, err := jd.getDSJobIDBounds(ds)
+	if err != nil {
+		return err
+	}
+	for _, job := range jobList {
+		if job.JobID < minJobID || job.JobID > maxJobID {
+			return fmt.Errorf("%w: job_id %d not in [%d, %d] of %s", ErrJobIDOutOfRange, job.JobID, minJobID, maxJobID, ds.JobTable)
+		}
+	}
+
+	return jd.storeJobsDS(ds, true, jobList)
+}
+
+//getDSJobIDBounds returns the range of job ids that can be stored in the dataset,
+//so that it doesn't overlap with the ranges of its neighbouring datasets.
+//Caller must have the dsListLock readlocked
+func (jd *HandleT) getDSJobIDBounds(ds dataSetT) (minJobID, maxJobID int64, err error) {
+	dsRanges := make(map[dataSetT]dataSetRangeT)
+	for _, dsRange := range jd.getDSRangeList(false) {
+		dsRanges[dsRange.ds] = dsRange
+	}
+
+	minJobID, maxJobID = 1, math.MaxInt64
+	found := false
+	for _, dataset := range jd.getDSList(false) {
+		dsRange, hasRange := dsRanges[dataset]
+		if dataset == ds {
+			if hasRange {
+				return dsRange.minJobID, dsRange.maxJobID, nil
+			}
+			found = true
+			continue
+		}
+		if !hasRange {
+			continue
+		}
+		if !found {
+			minJobID = dsRange.maxJobID + 1
+		} else {
+			return minJobID, dsRange.minJobID - 1, nil
+		}
+	}
+	if !found {
+		return 0, 0, fmt.Errorf("%w: %s", ErrDataSetNotFound, ds.JobTable)
+	}
+	return minJobID, maxJobID, nil
+}
+
+/*
+CopyJobs stores copies of the given jobs as new unprocessed jobs in the active dataset, e.g. for replaying aborted jobs.
+The copies keep the payload, parameters, user and workspace of the original jobs and get a new uuid and job_id.
+If newCustomVal is not empty, it replaces the custom_val of the copies.
+The new job ids are returned in the order of the given job ids. Nothing is copied if any of the jobs is not found.
+*/
+func (jd *HandleT) CopyJobs(jobIDs []int64, newCustomVal string) ([]int64, error) {
+	if len(jobIDs) == 0 {
+		return []int64{}, nil
+	}
+
+	jd.dsListLock.RLock()
+	defer jd.dsListLock.RUnlock()
+
+	jobsByID, err := jd.getJobsForCopy(jobIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	jobList := make([]*JobT, 0, len(jobsByID))
+	copied := make(map[int64]bool, len(jobsByID))
+	for _, jobID := range jobIDs {
+		if copied[jobID] {
+			continue
+		}
+		job, ok := jobsByID[jobID]
+		if !ok {
+			return nil, fmt.Errorf("%w: %d", ErrJobNotFound, jobID)
+		}
+		copied[jobID] = true
+		job.UUID = uuid.Must(uuid.NewV4())
+		if newCustomVal != "" {
+			job.CustomVal = newCustomVal
+		}
+		jobList = append(jobList, job)
+	}
+
+	dsList := jd.getDSList(false)
+	return jd.storeJobsReturningIDs(dsList[len(dsList)-1], jobList)
+}
+
+//getJobsForCopy returns the jobs with the given ids by their job id, with the fields needed for storing a copy of them.
+//Caller must have the dsListLock readlocked
+func (jd *HandleT) getJobsForCopy(jobIDs []int64) (map[int64]*JobT, error) {
+	jobs := make(map[int64]*JobT, len(jobIDs))
+	for _, ds := range jd.getDSList(false) {
+		payloadColumn, payloadJoin := jd.payloadSelect(ds, false)
+		sqlStatement := fmt.Sprintf(`SELECT jobs.job_id, jobs.user_id, jobs.custom_val, jobs.parameters, %s, jobs.event_count, jobs.workspace_id
+			FROM "%s" AS jobs%s WHERE jobs.job_id = ANY($1)`, payloadColumn, ds.JobTable, payloadJoin)
+		rows, err := jd.dbHandle.Query(sqlStatement, pq.Array(jobIDs))
+		if err != nil {
+			return nil, err
+		}
+		for rows.Next() {
+			var job JobT
+			if err := rows.Scan(&job.JobID, &job.UserID, &job.CustomVal, &job.Parameters, &job.EventPayload, &job.EventCount, &job.WorkspaceId); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			jobs[job.JobID] = &job
+		}
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return jobs, nil
+}
+
+//storeJobsReturningIDs stores the jobs in the dataset in a single transaction, returning their new job ids.
+//Unlike storeJobsDS it inserts the jobs one by one, since COPY doesn't return the generated ids.
+//Caller must have the dsListLock readlocked
+func (jd *HandleT) storeJobsReturningIDs(ds dataSetT, jobList []*JobT) (jobIDs []int64, err error) {
+	columns := storeJobColumns(false, false)
+	placeholders := make([]string, len(columns))
+	for i := range columns {
+		placeholders[i] = fmt.Sprintf("$%d", i+1)
+	}
+	sqlStatement := fmt.Sprintf(`INSERT INTO "%s" (%s) VALUES (%s) RETURNING job_id`, ds.JobTable, strings.Join(columns, ", "), strings.Join(placeholders, ", "))
+	if payloadTable, ok := jd.payloadTable(ds); ok {
+		//the payload is passed in the place of the event_payload column, to be stored with the generated job id
+		sqlStatement = fmt.Sprintf(`WITH job AS (INSERT INTO "%s" (uuid, user_id, custom_val, parameters, event_count, workspace_id) VALUES ($1, $2, $3, $4, $6, $7) RETURNING job_id)
+			INSERT INTO "%s" (job_id, event_payload) SELECT job_id, $5::jsonb FROM job RETURNING job_id`, ds.JobTable, payloadTable)
+	}
+
+	txn, err := jd.dbHandle.Begin()
+	if err != nil {
+		return nil, wrapOpError(ErrStoreBeginFailed, err)
+	}
+	defer func() {
+		if err != nil {
+			_ = txn.Rollback()
+			return
+		}
+		jd.markClearEmptyResult(ds, allWorkspaces, []string{}, []string{}, nil, hasJobs, nil)
+		for _, job := range jobList {
+			jd.markClearEmptyResult(ds, job.WorkspaceId, []string{}, []string{}, nil, hasJobs, nil)
+		}
+	}()
+
+	stmt, err := txn.Prepare(sqlStatement)
+	if err != nil {
+		return nil, wrapOpError(ErrStorePrepareFailed, err)
+	}
+	defer stmt.Close()
+
+	jobIDs = make([]int64, 0, len(jobList))
+	for _, job := range jobList {
+		//the copied jobs have been redacted when they were stored first
+		args, err := jd.storeJobArgs(job, false, false, false)
+		if err != nil {
+			return nil, err
+		}
+		var jobID int64
+		if err = stmt.QueryRow(args...).Scan(&jobID); err != nil {
+			return nil, wrapOpError(ErrStoreExecFailed, err)
+		}
+		jobIDs = append(jobIDs, jobID)
+	}
+
+	if err = txn.Commit(); err != nil {
+		return nil, wrapOpError(ErrStoreCommitFailed, err)
+	}
+	return jobIDs, nil
+}
+
+/*
+StoreWithRetryEach stores the jobs, returning the error messages of the ones that failed to be stored.
+If JobsDB.validateBeforeStore is true, the invalid jobs are rejected without reaching the database and the rest are stored.
+*/
 func (jd *HandleT) StoreWithRetryEach(jobList []*JobT) map[uuid.UUID]string {
-	totalWriteTime := jd.storeTimerStat("store_retry_each_total_time")
+	totalWriteTime := jd.storeTimerStat("store_retry_each_total_time", jobList)
 	totalWriteTime.Start()
 	defer totalWriteTime.End()
 
+	var invalidJobs map[uuid.UUID]string
+	if validateBeforeStore {
+		jobList, invalidJobs = validateJobs(jobList)
+		if len(jobList) == 0 {
+			return invalidJobs
+		}
+	}
+	//the jobs rejected for their null bytes fail on their own, instead of failing the batch to be stored one job at a time
+	jobList, invalidJobs = jd.splitNullByteRejects(jobList, invalidJobs)
+	if len(jobList) == 0 {
+		return invalidJobs
+	}
+	errMap := jd.storeWithRetryEachQueued(jobList)
+	if len(invalidJobs) == 0 {
+		return errMap
+	}
+	for jobUUID, errMessage := range errMap {
+		invalidJobs[jobUUID] = errMessage
+	}
+	return invalidJobs
+}
+
+//validateJobs splits the jobs into the valid ones and the error messages of the invalid ones
+func validateJobs(jobList []*JobT) (validJobs []*JobT, errorMessagesMap map[uuid.UUID]string) {
+	validJobs = make([]*JobT, 0, len(jobList))
+	for _, job := range jobList {
+		if err := job.Validate(); err != nil {
+			if errorMessagesMap == nil {
+				errorMessagesMap = make(map[uuid.UUID]string)
+			}
+			errorMessagesMap[job.UUID] = err.Error()
+			continue
+		}
+		validJobs = append(validJobs, job)
+	}
+	return validJobs, errorMessagesMap
+}
+
+//splitNullByteRejects returns the jobs which aren't rejected for their null bytes, see rejectsNullBytes,
+//adding the error messages of the rest of them to errorMessagesMap
+func (jd *HandleT) splitNullByteRejects(jobList []*JobT, errorMessagesMap map[uuid.UUID]string) ([]*JobT, map[uuid.UUID]string) {
+	if nullByteStrategy != nullByteStrategyReject {
+		return jobList, errorMessagesMap
+	}
+	acceptedJobs := make([]*JobT, 0, len(jobList))
+	for _, job := range jobList {
+		if !jd.rejectsNullBytes(job) {
+			acceptedJobs = append(acceptedJobs, job)
+			continue
+		}
+		if errorMessagesMap == nil {
+			errorMessagesMap = make(map[uuid.UUID]string)
+		}
+		errorMessagesMap[job.UUID] = ErrContainsNullBytes.Error()
+	}
+	return acceptedJobs, errorMessagesMap
+}
+
+//storeWithRetryEachQueued goes through writer worker pool if enableWriterQueue is true, else calls storeWithRetryEach directly
+func (jd *HandleT) storeWithRetryEachQueued(jobList []*JobT) map[uuid.UUID]string {
 	if jd.enableWriterQueue {
-		waitTimeStat := jd.storeTimerStat("store_retry_each_wait_time")
+		waitTimeStat := jd.storeTimerStat("store_retry_each_wait_time", jobList)
 		waitTimeStat.Start()
 		respCh := make(chan map[uuid.UUID]string)
 		writeJobRequest := writeJob{
@@ -3394,8 +5394,15 @@ func (jd *HandleT) StoreWithRetryEach(jobList []*JobT) map[uuid.UUID]string {
 			jobsList:         jobList,
 			errorMapResponse: respCh,
 		}
-		jd.writeChannel <- writeJobRequest
+		err := jd.enqueueWrite(writeJobRequest, jd.writerQueueNonBlocking)
 		waitTimeStat.End()
+		if err != nil {
+			errMap := make(map[uuid.UUID]string, len(jobList))
+			for _, job := range jobList {
+				errMap[job.UUID] = err.Error()
+			}
+			return errMap
+		}
 		errMap := <-respCh
 		return errMap
 	} else {
@@ -3436,10 +5443,12 @@ func (jd *HandleT) printLists(console bool) {
 GetUnprocessed returns the unprocessed events. Unprocessed events are
 those whose state hasn't been marked in the DB.
 If enableReaderQueue is true, this goes through worker pool, else calls getUnprocessed directly.
+If the query of a dataset takes longer than JobsDB.queryTimeout, the jobs of the datasets before it are returned
+along with an error wrapping context.DeadlineExceeded, so that the caller can back off and ask for fewer jobs.
 */
-func (jd *HandleT) GetUnprocessed(params GetQueryParamsT) []*JobT {
+func (jd *HandleT) GetUnprocessed(params GetQueryParamsT) ([]*JobT, error) {
 	if params.JobCount == 0 {
-		return []*JobT{}
+		return []*JobT{}, nil
 	}
 
 	tags := StatTagsT{CustomValFilters: params.CustomValFilters, ParameterFilters: params.ParameterFilters}
@@ -3453,12 +5462,16 @@ func (jd *HandleT) GetUnprocessed(params GetQueryParamsT) []*JobT {
 		readJobRequest := readJob{
 			getQueryParams: params,
 			jobsListChan:   make(chan []*JobT),
+			errChan:        make(chan error, 1),
 			reqType:        NotProcessed.State,
 		}
-		jd.readChannel <- readJobRequest
+		err := jd.enqueueRead(readJobRequest)
 		readChannelWaitTime.End()
-		jobsList := <-readJobRequest.jobsListChan
-		return jobsList
+		if err != nil {
+			return nil, err
+		}
+		jobsList := <-readJobRequest.jobsListChan
+		return jobsList, <-readJobRequest.errChan
 	} else {
 		return jd.getUnprocessed(params)
 	}
@@ -3468,9 +5481,9 @@ func (jd *HandleT) GetUnprocessed(params GetQueryParamsT) []*JobT {
 getUnprocessed returns the unprocessed events. Unprocessed events are
 those whose state hasn't been marked in the DB
 */
-func (jd *HandleT) getUnprocessed(params GetQueryParamsT) []*JobT {
+func (jd *HandleT) getUnprocessed(params GetQueryParamsT) ([]*JobT, error) {
 	if params.JobCount == 0 {
-		return []*JobT{}
+		return []*JobT{}, nil
 	}
 
 	count := params.JobCount
@@ -3492,7 +5505,7 @@ func (jd *HandleT) getUnprocessed(params GetQueryParamsT) []*JobT {
 	outJobs := make([]*JobT, 0)
 	jd.assert(count >= 0, fmt.Sprintf("request job count cannot be negative: %d", count))
 	if count == 0 {
-		return outJobs
+		return outJobs, nil
 	}
 	limitByEventCount := false
 	if params.EventCount > 0 {
@@ -3501,7 +5514,10 @@ func (jd *HandleT) getUnprocessed(params GetQueryParamsT) []*JobT {
 
 	for _, ds := range dsList {
 		jd.assert(count > 0, fmt.Sprintf("cannot receive negative job count: %d", count))
-		jobs := jd.getUnprocessedJobsDS(ds, true, count, params)
+		jobs, err := jd.getUnprocessedJobsDS(ds, true, count, params)
+		if err != nil {
+			return outJobs, err
+		}
 		outJobs = append(outJobs, jobs...)
 		count -= len(jobs)
 		jd.assert(count >= 0, fmt.Sprintf("cannot receive more jobs than requested, diff: %d", count))
@@ -3521,7 +5537,7 @@ func (jd *HandleT) getUnprocessed(params GetQueryParamsT) []*JobT {
 		}
 	}
 	//Release lock
-	return outJobs
+	return outJobs, nil
 }
 
 func (jd *HandleT) GetImportingList(params GetQueryParamsT) []*JobT {
@@ -3544,8 +5560,11 @@ func (jd *HandleT) GetImportingList(params GetQueryParamsT) []*JobT {
 			jobsListChan:   make(chan []*JobT),
 			reqType:        Importing.State,
 		}
-		jd.readChannel <- readJobRequest
+		err := jd.enqueueRead(readJobRequest)
 		readChannelWaitTime.End()
+		if err != nil {
+			return []*JobT{}
+		}
 		jobsList := <-readJobRequest.jobsListChan
 		return jobsList
 	} else {
@@ -3555,10 +5574,10 @@ func (jd *HandleT) GetImportingList(params GetQueryParamsT) []*JobT {
 
 /*
 getImportingList returns events which need are Importing.
-This is a wrapper over GetProcessed call above
+This is a wrapper over getProcessedJobs call above
 */
 func (jd *HandleT) getImportingList(params GetQueryParamsT) []*JobT {
-	return jd.GetProcessed(params)
+	return jd.getProcessedJobs(params)
 }
 
 /*
@@ -3699,16 +5718,34 @@ func (jd *HandleT) deleteJobStatusDSInTxn(txHandler transactionHandler, ds dataS
 GetProcessed returns events of a given state. This does not update any state itself and
 realises on the caller to update it. That means that successive calls to GetProcessed("failed")
 can return the same set of events. It is the responsibility of the caller to call it from
-one thread, update the state (to "waiting") in the same thread and pass on the the processors
+one thread, update the state (to "waiting") in the same thread and pass on the the processors.
+If the query of a dataset takes longer than JobsDB.queryTimeout, the jobs of the datasets before it are returned
+along with an error wrapping context.DeadlineExceeded, so that the caller can back off and ask for fewer jobs.
 */
-func (jd *HandleT) GetProcessed(params GetQueryParamsT) []*JobT {
+func (jd *HandleT) GetProcessed(params GetQueryParamsT) ([]*JobT, error) {
+	return jd.getProcessed(params)
+}
+
+//getProcessedJobs is getProcessed for the reads returning only jobs, e.g. GetWaiting:
+//if the query of a dataset takes longer than JobsDB.queryTimeout, it logs the error and returns the jobs of the datasets before it
+func (jd *HandleT) getProcessedJobs(params GetQueryParamsT) []*JobT {
+	jobs, err := jd.getProcessed(params)
+	if err != nil {
+		jd.logger.Errorf("[[ %s ]] Returning %d processed jobs: %v", jd.tablePrefix, len(jobs), err)
+	}
+	return jobs
+}
+
+//getProcessed returns along with the jobs read so far an error wrapping context.DeadlineExceeded
+//if the query of a dataset takes longer than JobsDB.queryTimeout
+func (jd *HandleT) getProcessed(params GetQueryParamsT) ([]*JobT, error) {
 	if params.JobCount == 0 {
-		return []*JobT{}
+		return []*JobT{}, nil
 	}
 
 	count := params.JobCount
 
-	tags := StatTagsT{CustomValFilters: params.CustomValFilters, StateFilters: params.StateFilters, ParameterFilters: params.ParameterFilters}
+	tags := StatTagsT{CustomValFilters: params.CustomValFilters, StateFilters: params.StateFilters, ParameterFilters: params.ParameterFilters, WorkspaceID: jd.statWorkspaceID(params.WorkspaceID)}
 	queryStat := jd.getTimerStat("processed_jobs_time", tags)
 	queryStat.Start()
 	defer queryStat.End()
@@ -3726,7 +5763,7 @@ func (jd *HandleT) GetProcessed(params GetQueryParamsT) []*JobT {
 
 	jd.assert(count >= 0, fmt.Sprintf("request job count cannot be negative: %d", count))
 	if count == 0 {
-		return outJobs
+		return outJobs, nil
 	}
 
 	limitByEventCount := false
@@ -3737,7 +5774,10 @@ func (jd *HandleT) GetProcessed(params GetQueryParamsT) []*JobT {
 	for _, ds := range dsList {
 		//count==0 means return all which we don't want
 		jd.assert(count > 0, fmt.Sprintf("count:%d is less than or equal to 0", count))
-		jobs := jd.getProcessedJobsDS(ds, false, count, params)
+		jobs, err := jd.getProcessedJobsDS(ds, false, count, params)
+		if err != nil {
+			return outJobs, err
+		}
 		outJobs = append(outJobs, jobs...)
 		count -= len(jobs)
 		jd.assert(count >= 0, fmt.Sprintf("count:%d after subtracting len(jobs):%d is less than 0", count, len(jobs)))
@@ -3758,21 +5798,23 @@ func (jd *HandleT) GetProcessed(params GetQueryParamsT) []*JobT {
 		}
 	}
 
-	return outJobs
+	return outJobs, nil
 }
 
 /*
 GetToRetry returns events which need to be retried.
 If enableReaderQueue is true, this goes through worker pool, else calls getUnprocessed directly.
+If the query of a dataset takes longer than JobsDB.queryTimeout, the jobs of the datasets before it are returned
+along with an error wrapping context.DeadlineExceeded, so that the caller can back off and ask for fewer jobs.
 */
-func (jd *HandleT) GetToRetry(params GetQueryParamsT) []*JobT {
+func (jd *HandleT) GetToRetry(params GetQueryParamsT) ([]*JobT, error) {
 	if params.JobCount == 0 {
-		return []*JobT{}
+		return []*JobT{}, nil
 	}
 
 	params.StateFilters = []string{Failed.State}
 
-	tags := StatTagsT{CustomValFilters: params.CustomValFilters, StateFilters: params.StateFilters, ParameterFilters: params.ParameterFilters}
+	tags := StatTagsT{CustomValFilters: params.CustomValFilters, StateFilters: params.StateFilters, ParameterFilters: params.ParameterFilters, WorkspaceID: jd.statWorkspaceID(params.WorkspaceID)}
 	totalReadTime := jd.getTimerStat("processed_total_time", tags)
 	totalReadTime.Start()
 	defer totalReadTime.End()
@@ -3783,28 +5825,232 @@ func (jd *HandleT) GetToRetry(params GetQueryParamsT) []*JobT {
 		readJobRequest := readJob{
 			getQueryParams: params,
 			jobsListChan:   make(chan []*JobT),
+			errChan:        make(chan error, 1),
 			reqType:        Failed.State,
 		}
-		jd.readChannel <- readJobRequest
+		err := jd.enqueueRead(readJobRequest)
 		readChannelWaitTime.End()
+		if err != nil {
+			return nil, err
+		}
 		jobsList := <-readJobRequest.jobsListChan
-		return jobsList
+		return jobsList, <-readJobRequest.errChan
 	} else {
 		return jd.getToRetry(params)
 	}
 }
 
+/*
+SetReplica serves the reads of GetToRetry from the read replica behind dbHandle, unless it lags behind, see SetMaxReplicaLag.
+The replica is owned by the caller, which closes it after TearDown. It must be set before the reads start.
+*/
+func (jd *HandleT) SetReplica(dbHandle *sql.DB) {
+	jd.replicaDBHandle = dbHandle
+}
+
+/*
+SetMaxReplicaLag sets the lag of the replica, as returned by JobsDB.replicaLagQuery, beyond which GetToRetry reads from the primary,
+since a lagging replica returns jobs whose latest status isn't replicated yet, which are picked up again.
+Zero, the default, reads from the replica without checking its lag. It must be set before the reads start.
+*/
+func (jd *HandleT) SetMaxReplicaLag(d time.Duration) {
+	jd.maxReplicaLag = d
+}
+
+//useReplica tells if the next read of GetToRetry is served by the replica. If it lags more than maxReplicaLag,
+//or its lag can't be queried, the read falls back to the primary and the replica_lag_fallback stat is incremented.
+func (jd *HandleT) useReplica() bool {
+	if jd.replicaDBHandle == nil {
+		return false
+	}
+	if jd.maxReplicaLag <= 0 {
+		return true
+	}
+	lag, err := jd.getReplicaLag()
+	if err == nil && lag <= jd.maxReplicaLag {
+		return true
+	}
+	if err != nil {
+		jd.logger.Errorf("[[ %s ]] Failed to query the replica lag, reading from the primary: %v", jd.tablePrefix, err)
+	} else {
+		jd.logger.Debugf("[[ %s ]] Replica lag %v exceeds %v, reading from the primary", jd.tablePrefix, lag, jd.maxReplicaLag)
+	}
+	stats.NewTaggedStat("replica_lag_fallback", stats.CountType, stats.Tags{"customVal": jd.tablePrefix}).Increment()
+	return false
+}
+
+//getReplicaLag runs replicaLagQuery on the replica
+func (jd *HandleT) getReplicaLag() (time.Duration, error) {
+	ctx, cancel := jd.queryContext()
+	defer cancel()
+	var seconds float64
+	if err := jd.replicaDBHandle.QueryRowContext(ctx, replicaLagQuery).Scan(&seconds); err != nil {
+		return 0, err
+	}
+	return time.Duration(seconds * float64(time.Second)), nil
+}
+
 /*
 getToRetry returns events which need to be retried.
-This is a wrapper over GetProcessed call above
+This is a wrapper over getProcessed call above, served by the replica if there is one, see SetReplica
+*/
+func (jd *HandleT) getToRetry(params GetQueryParamsT) ([]*JobT, error) {
+	params.fromReplica = jd.useReplica()
+	if params.SplitByCustomVal && len(params.CustomValFilters) > 1 && !params.IgnoreCustomValFiltersInQuery {
+		return jd.getToRetrySplitByCustomVal(params)
+	}
+	return jd.getProcessed(params)
+}
+
+//getToRetrySplitByCustomVal gets the jobs to retry of every custom val separately, with limits proportional to their due failed jobs.
+//If the due failed jobs can't be counted, it falls back to a single query for all the custom vals.
+func (jd *HandleT) getToRetrySplitByCustomVal(params GetQueryParamsT) ([]*JobT, error) {
+	dueCounts, err := jd.getDueCountsByCustomVal(params)
+	if err != nil {
+		jd.logger.Errorf("[%s] Failed to count the due failed jobs by custom val, getting them without splitting: %v", jd.tablePrefix, err)
+		return jd.getProcessed(params)
+	}
+
+	limits := splitByRatio(params.JobCount, params.CustomValFilters, dueCounts)
+	outJobs := make([]*JobT, 0)
+	for _, customVal := range params.CustomValFilters {
+		limit := limits[customVal]
+		if limit == 0 {
+			continue
+		}
+		customValParams := params
+		customValParams.CustomValFilters = []string{customVal}
+		customValParams.JobCount = limit
+		if params.EventCount > 0 {
+			customValParams.EventCount = misc.MaxInt(params.EventCount*limit/params.JobCount, 1)
+		}
+		jobs, err := jd.getProcessed(customValParams)
+		outJobs = append(outJobs, jobs...)
+		if err != nil {
+			return outJobs, err
+		}
+	}
+	return outJobs, nil
+}
+
+//getDueCountsByCustomVal returns the number of jobs matching params which are due to be retried, by custom val.
+//Like getProcessed, it goes through the datasets in order, skipping the ones cached as empty, and stops at the one
+//where JobCount jobs are reached, so only the datasets a single query for all the custom vals would read are counted.
+//A count taking longer than JobsDB.queryTimeout returns an error wrapping context.DeadlineExceeded.
+func (jd *HandleT) getDueCountsByCustomVal(params GetQueryParamsT) (map[string]int64, error) {
+	jd.dsMigrationLock.RLock()
+	jd.dsListLock.RLock()
+	defer jd.dsMigrationLock.RUnlock()
+	defer jd.dsListLock.RUnlock()
+
+	var parameterQuery string
+	if len(params.ParameterFilters) > 0 {
+		parameterQuery = " AND " + constructParameterJSONQuery("jobs", params.ParameterFilters)
+	}
+	conditions, args := processedJobsConditions(params, []interface{}{getTimeNowFunc()})
+
+	counts := make(map[string]int64)
+	var total int64
+	for _, ds := range jd.getDSList(false) {
+		if params.usesEmptyResultCache() && jd.isEmptyResult(ds, allWorkspaces, params.StateFilters, params.CustomValFilters, params.ParameterFilters) {
+			continue
+		}
+		sqlStatement := fmt.Sprintf(`SELECT COUNT(*), jobs.custom_val FROM "%[1]s" AS jobs,
+			(SELECT job_id, job_state, attempt, retry_time FROM "%[2]s" WHERE id IN (SELECT MAX(id) FROM "%[2]s" GROUP BY job_id) AND job_state = '%[3]s') AS job_latest_state
+			WHERE jobs.job_id = job_latest_state.job_id AND %[4]s%[5]s%[6]s AND job_latest_state.retry_time < $1
+			GROUP BY jobs.custom_val`,
+			ds.JobTable, ds.JobStatusTable, Failed.State, constructQuery(jd, "jobs.custom_val", params.CustomValFilters, "OR"), parameterQuery, conditions)
+		if err := jd.countByCustomVal(ds, sqlStatement, args, counts, &total); err != nil {
+			return nil, err
+		}
+		if total >= int64(params.JobCount) {
+			break
+		}
+	}
+	return counts, nil
+}
+
+//countByCustomVal adds the counts by custom val returned by sqlStatement to counts and total
+func (jd *HandleT) countByCustomVal(ds dataSetT, sqlStatement string, args []interface{}, counts map[string]int64, total *int64) error {
+	ctx, cancel := jd.queryContext()
+	defer cancel()
+	rows, err := jd.dbHandle.QueryContext(ctx, sqlStatement, args...)
+	if timeoutErr := jd.queryTimeoutError(ctx, ds, err); timeoutErr != nil {
+		return timeoutErr
+	}
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var count int64
+		var customVal string
+		if err := rows.Scan(&count, &customVal); err != nil {
+			return err
+		}
+		counts[customVal] += count
+		*total += count
+	}
+	if timeoutErr := jd.queryTimeoutError(ctx, ds, rows.Err()); timeoutErr != nil {
+		return timeoutErr
+	}
+	return rows.Err()
+}
+
+//splitByRatio splits total across keys in the ratio of their counts, using the largest remainder method so that the
+//limits sum up to total. If the counts sum up to total or less, every key gets its count.
+func splitByRatio(total int, keys []string, counts map[string]int64) map[string]int {
+	limits := make(map[string]int, len(keys))
+	var sum int64
+	for _, key := range keys {
+		sum += counts[key]
+	}
+	if sum <= int64(total) {
+		for _, key := range keys {
+			limits[key] = int(counts[key])
+		}
+		return limits
+	}
+
+	remainders := make([]int64, len(keys))
+	assigned := 0
+	for i, key := range keys {
+		share := int64(total) * counts[key]
+		limits[key] = int(share / sum)
+		remainders[i] = share % sum
+		assigned += limits[key]
+	}
+	//keys with the same remainder keep their order, so that the split is deterministic
+	order := make([]int, len(keys))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool { return remainders[order[i]] > remainders[order[j]] })
+	for i := 0; assigned < total; i++ {
+		limits[keys[order[i]]]++
+		assigned++
+	}
+	return limits
+}
+
+/*
+GetExhausted returns the failed jobs which have exhausted their retries, i.e. whose latest attempt is maxAttempts or more,
+so that they can be drained. These are the jobs excluded from GetToRetry by GetQueryParamsT.MaxAttempts.
 */
-func (jd *HandleT) getToRetry(params GetQueryParamsT) []*JobT {
-	return jd.GetProcessed(params)
+func (jd *HandleT) GetExhausted(params GetQueryParamsT, maxAttempts int) []*JobT {
+	jd.assert(maxAttempts > 0, fmt.Sprintf("maxAttempts must be positive: %d", maxAttempts))
+	params.StateFilters = []string{Failed.State}
+	params.MinAttemptNum = maxAttempts
+	params.MaxAttemptNum = 0
+	params.MaxAttempts = 0
+	return jd.getProcessedJobs(params)
 }
 
 /*
-GetWaiting returns events which are under processing
-If enableReaderQueue is true, this goes through worker pool, else calls getUnprocessed directly.
+GetWaiting returns events whose latest job state is waiting.
+Unlike GetToRetry this only matches the waiting state, which helps telling apart
+jobs that are stuck waiting from the ones that have failed.
+If enableReaderQueue is true, this goes through worker pool, else calls getWaiting directly.
 */
 func (jd *HandleT) GetWaiting(params GetQueryParamsT) []*JobT {
 	if params.JobCount == 0 {
@@ -3826,8 +6072,11 @@ func (jd *HandleT) GetWaiting(params GetQueryParamsT) []*JobT {
 			jobsListChan:   make(chan []*JobT),
 			reqType:        Waiting.State,
 		}
-		jd.readChannel <- readJobRequest
+		err := jd.enqueueRead(readJobRequest)
 		readChannelWaitTime.End()
+		if err != nil {
+			return []*JobT{}
+		}
 		jobsList := <-readJobRequest.jobsListChan
 		return jobsList
 	} else {
@@ -3836,11 +6085,538 @@ func (jd *HandleT) GetWaiting(params GetQueryParamsT) []*JobT {
 }
 
 /*
-GetWaiting returns events which are under processing
-This is a wrapper over GetProcessed call above
+getWaiting returns events whose latest job state is waiting.
+This is a wrapper over getProcessedJobs call above
 */
 func (jd *HandleT) getWaiting(params GetQueryParamsT) []*JobT {
-	return jd.GetProcessed(params)
+	return jd.getProcessedJobs(params)
+}
+
+/*
+MarkWaiting marks the jobs as waiting until the given time, e.g. to honour a destination's Retry-After.
+Since the retry_time of the status is set to until, the jobs aren't returned by GetWaiting before that.
+The attempt number of the latest status of each job is retained and the reason is recorded in the error response.
+*/
+func (jd *HandleT) MarkWaiting(jobIDs []int64, until time.Time, reason string) error {
+	if len(jobIDs) == 0 {
+		return nil
+	}
+
+	errorResponse, err := json.Marshal(map[string]string{"reason": reason})
+	if err != nil {
+		return err
+	}
+
+	jobs, err := jd.getJobsForStatusUpdate(jobIDs)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	statusList := make([]*JobStatusT, 0, len(jobs))
+	for _, job := range jobs {
+		statusList = append(statusList, &JobStatusT{
+			JobID:         job.JobID,
+			JobState:      Waiting.State,
+			AttemptNum:    job.LastJobStatus.AttemptNum,
+			ExecTime:      now,
+			RetryTime:     until,
+			ErrorResponse: errorResponse,
+			Parameters:    []byte(`{}`),
+			WorkspaceId:   job.WorkspaceId,
+		})
+	}
+	return jd.UpdateJobStatus(statusList, nil, nil)
+}
+
+//getJobsForStatusUpdate returns the jobs with the given ids, along with the attempt number of their latest status
+func (jd *HandleT) getJobsForStatusUpdate(jobIDs []int64) ([]*JobT, error) {
+	jd.dsListLock.RLock()
+	defer jd.dsListLock.RUnlock()
+
+	var jobs []*JobT
+	for _, ds := range jd.getDSList(false) {
+		sqlStatement := fmt.Sprintf(`SELECT jobs.job_id, jobs.workspace_id,
+			COALESCE((SELECT attempt FROM "%[2]s" WHERE job_id = jobs.job_id ORDER BY id DESC LIMIT 1), 0)
+			FROM "%[1]s" AS jobs WHERE jobs.job_id = ANY($1)`, ds.JobTable, ds.JobStatusTable)
+		rows, err := jd.dbHandle.Query(sqlStatement, pq.Array(jobIDs))
+		if err != nil {
+			return nil, err
+		}
+		for rows.Next() {
+			var job JobT
+			if err := rows.Scan(&job.JobID, &job.WorkspaceId, &job.LastJobStatus.AttemptNum); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			jobs = append(jobs, &job)
+		}
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return jobs, nil
+}
+
+/*
+GetJobsByIDs returns the jobs with the given ids, along with their latest status, if any.
+Only the datasets which may contain the ids, according to their job id ranges, are queried.
+Jobs which aren't found are absent from the result.
+*/
+func (jd *HandleT) GetJobsByIDs(jobIDs []int64) ([]*JobT, error) {
+	if len(jobIDs) == 0 {
+		return []*JobT{}, nil
+	}
+
+	jd.dsListLock.RLock()
+	defer jd.dsListLock.RUnlock()
+
+	jobIDsByDS := jd.groupJobIDsByDS(jobIDs)
+	jobs := make([]*JobT, 0, len(jobIDs))
+	for _, ds := range jd.getDSList(false) {
+		dsJobIDs, ok := jobIDsByDS[ds]
+		if !ok {
+			continue
+		}
+		dsJobs, err := jd.getJobsByIDsDS(ds, dsJobIDs)
+		if err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, dsJobs...)
+	}
+	return jobs, nil
+}
+
+//groupJobIDsByDS groups the job ids by the dataset whose range contains them.
+//Ids outside of all the ranges are assigned to every dataset without a range, i.e. the active dataset and any migration target.
+//Caller must have the dsListLock readlocked
+func (jd *HandleT) groupJobIDsByDS(jobIDs []int64) map[dataSetT][]int64 {
+	dsRangeList := jd.getDSRangeList(false)
+	ranged := make(map[dataSetT]bool, len(dsRangeList))
+	for _, dsRange := range dsRangeList {
+		ranged[dsRange.ds] = true
+	}
+	var unrangedDSList []dataSetT
+	for _, ds := range jd.getDSList(false) {
+		if !ranged[ds] {
+			unrangedDSList = append(unrangedDSList, ds)
+		}
+	}
+
+	jobIDsByDS := make(map[dataSetT][]int64)
+	for _, jobID := range jobIDs {
+		found := false
+		for _, dsRange := range dsRangeList {
+			if jobID >= dsRange.minJobID && jobID <= dsRange.maxJobID {
+				jobIDsByDS[dsRange.ds] = append(jobIDsByDS[dsRange.ds], jobID)
+				found = true
+				break
+			}
+		}
+		if found {
+			continue
+		}
+		for _, ds := range unrangedDSList {
+			jobIDsByDS[ds] = append(jobIDsByDS[ds], jobID)
+		}
+	}
+	return jobIDsByDS
+}
+
+func (jd *HandleT) getJobsByIDsDS(ds dataSetT, jobIDs []int64) ([]*JobT, error) {
+	payloadColumn, payloadJoin := jd.payloadSelect(ds, false)
+	sqlStatement := fmt.Sprintf(`SELECT jobs.job_id, jobs.uuid, jobs.user_id, jobs.parameters, jobs.custom_val, %[3]s, jobs.event_count,
+		jobs.created_at, jobs.expire_at, jobs.workspace_id,
+		job_latest_state.job_state, job_latest_state.attempt, job_latest_state.exec_time, job_latest_state.retry_time,
+		job_latest_state.error_code, job_latest_state.error_response, job_latest_state.parameters, job_latest_state.abort_reason
+		FROM "%[1]s" AS jobs%[4]s LEFT JOIN
+			(SELECT job_id, job_state, attempt, exec_time, retry_time, error_code, error_response, parameters, abort_reason FROM "%[2]s" WHERE id IN
+				(SELECT MAX(id) FROM "%[2]s" WHERE job_id = ANY($1) GROUP BY job_id))
+			AS job_latest_state ON jobs.job_id = job_latest_state.job_id
+		WHERE jobs.job_id = ANY($1) ORDER BY jobs.job_id`, ds.JobTable, ds.JobStatusTable, payloadColumn, payloadJoin)
+	rows, err := jd.dbHandle.Query(sqlStatement, pq.Array(jobIDs))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var jobs []*JobT
+	for rows.Next() {
+		var job JobT
+		var jobState, errorCode, abortReason sql.NullString
+		var attempt sql.NullInt64
+		var execTime, retryTime sql.NullTime
+		var errorResponse, statusParameters []byte
+		err := rows.Scan(&job.JobID, &job.UUID, &job.UserID, &job.Parameters, &job.CustomVal, &job.EventPayload, &job.EventCount,
+			&job.CreatedAt, &job.ExpireAt, &job.WorkspaceId,
+			&jobState, &attempt, &execTime, &retryTime, &errorCode, &errorResponse, &statusParameters, &abortReason)
+		if err != nil {
+			return nil, err
+		}
+		if jobState.Valid {
+			job.LastJobStatus = JobStatusT{
+				JobID:         job.JobID,
+				JobState:      jobState.String,
+				AttemptNum:    int(attempt.Int64),
+				ExecTime:      execTime.Time,
+				RetryTime:     retryTime.Time,
+				ErrorCode:     errorCode.String,
+				ErrorResponse: errorResponse,
+				Parameters:    statusParameters,
+				WorkspaceId:   job.WorkspaceId,
+				AbortReason:   abortReason.String,
+			}
+		}
+		jobs = append(jobs, &job)
+	}
+	return jobs, rows.Err()
+}
+
+/*
+GetJobStatusHistory returns all the statuses of the job, oldest first, rather than only its latest one,
+so that the whole sequence of states it went through can be audited.
+Only the dataset which may contain the job, according to its job id range, is queried.
+*/
+func (jd *HandleT) GetJobStatusHistory(jobID int64) ([]JobStatusT, error) {
+	jd.dsListLock.RLock()
+	defer jd.dsListLock.RUnlock()
+
+	statuses := make([]JobStatusT, 0)
+	jobIDsByDS := jd.groupJobIDsByDS([]int64{jobID})
+	for _, ds := range jd.getDSList(false) {
+		if _, ok := jobIDsByDS[ds]; !ok {
+			continue
+		}
+		dsStatuses, err := jd.getJobStatusHistoryDS(ds, jobID)
+		if err != nil {
+			return nil, err
+		}
+		statuses = append(statuses, dsStatuses...)
+	}
+	return statuses, nil
+}
+
+/*
+FetchPayloads sets the EventPayload of the jobs, e.g. of the ones got with GetQueryParamsT.SkipPayload once their payloads are needed.
+Only the datasets which may contain the jobs, according to their job id ranges, are queried.
+The payloads of the jobs which aren't found are left empty.
+*/
+func (jd *HandleT) FetchPayloads(jobs []*JobT) error {
+	jd.dsListLock.RLock()
+	defer jd.dsListLock.RUnlock()
+
+	jobsByID := make(map[int64][]*JobT, len(jobs))
+	jobIDs := make([]int64, 0, len(jobs))
+	for _, job := range jobs {
+		if _, ok := jobsByID[job.JobID]; !ok {
+			jobIDs = append(jobIDs, job.JobID)
+		}
+		jobsByID[job.JobID] = append(jobsByID[job.JobID], job)
+	}
+
+	jobIDsByDS := jd.groupJobIDsByDS(jobIDs)
+	for _, ds := range jd.getDSList(false) {
+		dsJobIDs, ok := jobIDsByDS[ds]
+		if !ok {
+			continue
+		}
+		if err := jd.fetchPayloadsDS(ds, dsJobIDs, jobsByID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//fetchPayloadsDS sets the payloads of the jobs found in ds, reading them from its payload table if it has one
+func (jd *HandleT) fetchPayloadsDS(ds dataSetT, jobIDs []int64, jobsByID map[int64][]*JobT) error {
+	table := ds.JobTable
+	if payloadTable, ok := jd.payloadTable(ds); ok {
+		table = payloadTable
+	}
+	sqlStatement := fmt.Sprintf(`SELECT job_id, event_payload FROM "%s" WHERE job_id = ANY($1)`, table)
+	rows, err := jd.dbHandle.Query(sqlStatement, pq.Array(jobIDs))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var jobID int64
+		var eventPayload json.RawMessage
+		if err := rows.Scan(&jobID, &eventPayload); err != nil {
+			return err
+		}
+		for _, job := range jobsByID[jobID] {
+			job.EventPayload = eventPayload
+		}
+	}
+	return rows.Err()
+}
+
+//getJobStatusHistoryDS returns the statuses of the job in ds in the order they were inserted, i.e. by their serial id
+func (jd *HandleT) getJobStatusHistoryDS(ds dataSetT, jobID int64) ([]JobStatusT, error) {
+	sqlStatement := fmt.Sprintf(`SELECT job_id, job_state, attempt, exec_time, retry_time, error_code, error_response, parameters, abort_reason
+		FROM "%s" WHERE job_id = $1 ORDER BY id`, ds.JobStatusTable)
+	rows, err := jd.dbHandle.Query(sqlStatement, jobID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var statuses []JobStatusT
+	for rows.Next() {
+		var status JobStatusT
+		var errorCode sql.NullString
+		var execTime, retryTime sql.NullTime
+		err := rows.Scan(&status.JobID, &status.JobState, &status.AttemptNum, &execTime, &retryTime,
+			&errorCode, &status.ErrorResponse, &status.Parameters, &status.AbortReason)
+		if err != nil {
+			return nil, err
+		}
+		status.ExecTime = execTime.Time
+		status.RetryTime = retryTime.Time
+		status.ErrorCode = errorCode.String
+		statuses = append(statuses, status)
+	}
+	return statuses, rows.Err()
+}
+
+/*
+GetAbortedJobs returns up to limit jobs aborted since the given time, along with their latest status,
+which holds the error code, error response and abort reason of the abort.
+The jobs are returned in the order of their ids, starting after afterJobID, so that the next page can be got
+by passing the id of the last job returned. An empty customVal returns the aborted jobs of all custom vals.
+*/
+func (jd *HandleT) GetAbortedJobs(customVal string, since time.Time, afterJobID int64, limit int) ([]*JobT, error) {
+	jd.assert(limit >= 0, fmt.Sprintf("limit cannot be negative: %d", limit))
+	jobs := make([]*JobT, 0)
+	if limit == 0 {
+		return jobs, nil
+	}
+
+	jd.dsListLock.RLock()
+	defer jd.dsListLock.RUnlock()
+
+	args := []interface{}{Aborted.State, since, afterJobID}
+	var customValQuery string
+	if customVal != "" {
+		customValQuery = " AND jobs.custom_val = $4"
+		args = append(args, customVal)
+	}
+
+	for _, ds := range jd.getDSList(false) {
+		payloadColumn, payloadJoin := jd.payloadSelect(ds, false)
+		sqlStatement := fmt.Sprintf(`SELECT jobs.job_id, jobs.uuid, jobs.user_id, jobs.parameters, jobs.custom_val, %[4]s, jobs.event_count,
+			jobs.created_at, jobs.expire_at, jobs.workspace_id,
+			job_status.job_state, job_status.attempt, job_status.exec_time, job_status.retry_time,
+			job_status.error_code, job_status.error_response, job_status.parameters, job_status.abort_reason
+			FROM "%[1]s" AS jobs%[5]s, "%[2]s" AS job_status
+			WHERE jobs.job_id = job_status.job_id AND job_status.job_state = $1 AND job_status.exec_time >= $2 AND jobs.job_id > $3%[3]s
+			ORDER BY jobs.job_id LIMIT %[6]d`, ds.JobTable, ds.JobStatusTable, customValQuery, payloadColumn, payloadJoin, limit-len(jobs))
+		rows, err := jd.dbHandle.Query(sqlStatement, args...)
+		if err != nil {
+			return nil, err
+		}
+		for rows.Next() {
+			var job JobT
+			err := rows.Scan(&job.JobID, &job.UUID, &job.UserID, &job.Parameters, &job.CustomVal, &job.EventPayload, &job.EventCount,
+				&job.CreatedAt, &job.ExpireAt, &job.WorkspaceId,
+				&job.LastJobStatus.JobState, &job.LastJobStatus.AttemptNum, &job.LastJobStatus.ExecTime, &job.LastJobStatus.RetryTime,
+				&job.LastJobStatus.ErrorCode, &job.LastJobStatus.ErrorResponse, &job.LastJobStatus.Parameters, &job.LastJobStatus.AbortReason)
+			if err != nil {
+				rows.Close()
+				return nil, err
+			}
+			job.LastJobStatus.JobID = job.JobID
+			job.LastJobStatus.WorkspaceId = job.WorkspaceId
+			jobs = append(jobs, &job)
+		}
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			return nil, err
+		}
+		if len(jobs) == limit {
+			break
+		}
+	}
+	return jobs, nil
+}
+
+/*
+DeleteJobsByUserID deletes the jobs of the user, along with their statuses, across all datasets in a single transaction,
+e.g. for serving erasure requests. It returns the number of jobs deleted.
+If dryRun is true, nothing is deleted and the number of jobs which would be deleted is returned instead.
+*/
+func (jd *HandleT) DeleteJobsByUserID(userID string, dryRun bool) (int64, error) {
+	jd.dsMigrationLock.RLock()
+	jd.dsListLock.RLock()
+	defer jd.dsMigrationLock.RUnlock()
+	defer jd.dsListLock.RUnlock()
+
+	dsList := jd.getDSList(false)
+	if dryRun {
+		var total int64
+		for _, ds := range dsList {
+			var count int64
+			sqlStatement := fmt.Sprintf(`SELECT COUNT(*) FROM "%s" WHERE user_id = $1`, ds.JobTable)
+			if err := jd.dbHandle.QueryRow(sqlStatement, userID).Scan(&count); err != nil {
+				return 0, err
+			}
+			total += count
+		}
+		return total, nil
+	}
+
+	txn, err := jd.dbHandle.Begin()
+	if err != nil {
+		return 0, err
+	}
+	var total int64
+	for _, ds := range dsList {
+		deleted, err := jd.deleteJobsByUserIDDS(txn, ds, userID)
+		if err != nil {
+			txn.Rollback()
+			return 0, err
+		}
+		total += deleted
+	}
+	if err := txn.Commit(); err != nil {
+		return 0, err
+	}
+	jd.logger.Infof("[[ %s : DeleteJobsByUserID ]]: Deleted %d jobs across %d datasets", jd.tablePrefix, total, len(dsList))
+	return total, nil
+}
+
+//deleteJobsByUserIDDS deletes the jobs of the user in ds, statuses first, returning the number of jobs deleted
+func (jd *HandleT) deleteJobsByUserIDDS(txn *sql.Tx, ds dataSetT, userID string) (int64, error) {
+	sqlStatement := fmt.Sprintf(`DELETE FROM "%[1]s" WHERE job_id IN (SELECT job_id FROM "%[2]s" WHERE user_id = $1)`, ds.JobStatusTable, ds.JobTable)
+	if _, err := txn.Exec(sqlStatement, userID); err != nil {
+		return 0, err
+	}
+	sqlStatement = fmt.Sprintf(`DELETE FROM "%s" WHERE user_id = $1`, ds.JobTable)
+	res, err := txn.Exec(sqlStatement, userID)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+/*
+PeekJobs returns the headers of up to params.JobCount jobs, i.e. the jobs without their event payloads, for listing them cheaply.
+The jobs are filtered by the StateFilters, where NotProcessed.State matches the jobs without any status, and an empty list matches all states,
+along with the CustomValFilters, ParameterFilters, ParametersContains and WorkspaceFilters. The other params are ignored.
+*/
+func (jd *HandleT) PeekJobs(params GetQueryParamsT) ([]JobHeaderT, error) {
+	if params.JobCount == 0 {
+		return []JobHeaderT{}, nil
+	}
+	var states []string
+	includeNotProcessed := len(params.StateFilters) == 0
+	for _, state := range params.StateFilters {
+		if state == NotProcessed.State {
+			includeNotProcessed = true
+			continue
+		}
+		states = append(states, state)
+	}
+	checkValidJobState(jd, states)
+	checkValidParametersContains(jd, params.ParametersContains)
+
+	var conditions []string
+	var args []interface{}
+	placeholder := func(arg interface{}) string {
+		args = append(args, arg)
+		return fmt.Sprintf("$%d", len(args))
+	}
+	if len(params.StateFilters) > 0 {
+		var stateConditions []string
+		if includeNotProcessed {
+			stateConditions = append(stateConditions, "job_latest_state.job_id IS NULL")
+		}
+		if len(states) > 0 {
+			stateConditions = append(stateConditions, fmt.Sprintf("job_latest_state.job_state = ANY(%s)", placeholder(pq.Array(states))))
+		}
+		conditions = append(conditions, "("+strings.Join(stateConditions, " OR ")+")")
+	}
+	if len(params.CustomValFilters) > 0 && !params.IgnoreCustomValFiltersInQuery {
+		conditions = append(conditions, fmt.Sprintf("jobs.custom_val = ANY(%s)", placeholder(pq.Array(params.CustomValFilters))))
+	}
+	if len(params.ParameterFilters) > 0 {
+		parameterQuery, parameterArgs, err := constructParameterJSONQueryWithArgs("jobs", params.ParameterFilters, len(args))
+		if err != nil {
+			return nil, err
+		}
+		conditions = append(conditions, parameterQuery)
+		args = append(args, parameterArgs...)
+	}
+	if len(params.ParametersContains) > 0 {
+		conditions = append(conditions, fmt.Sprintf("jobs.parameters @> %s::jsonb", placeholder(string(params.ParametersContains))))
+	}
+	if len(params.WorkspaceFilters) > 0 {
+		conditions = append(conditions, fmt.Sprintf("jobs.workspace_id = ANY(%s)", placeholder(pq.Array(params.WorkspaceFilters))))
+	}
+	var whereQuery string
+	if len(conditions) > 0 {
+		whereQuery = " WHERE " + strings.Join(conditions, " AND ")
+	}
+
+	jd.dsListLock.RLock()
+	defer jd.dsListLock.RUnlock()
+
+	count := params.JobCount
+	headers := make([]JobHeaderT, 0)
+	for _, ds := range jd.getDSList(false) {
+		dsHeaders, err := jd.peekJobsDS(ds, whereQuery, args, count)
+		if err != nil {
+			return nil, err
+		}
+		headers = append(headers, dsHeaders...)
+		count -= len(dsHeaders)
+		if count <= 0 {
+			break
+		}
+	}
+	return headers, nil
+}
+
+func (jd *HandleT) peekJobsDS(ds dataSetT, whereQuery string, args []interface{}, limit int) ([]JobHeaderT, error) {
+	sqlStatement := fmt.Sprintf(`SELECT jobs.job_id, jobs.uuid, jobs.user_id, jobs.parameters, jobs.custom_val, jobs.event_count,
+		jobs.created_at, jobs.expire_at, jobs.workspace_id,
+		job_latest_state.job_state, job_latest_state.attempt, job_latest_state.retry_time
+		FROM "%[1]s" AS jobs LEFT JOIN
+			(SELECT job_id, job_state, attempt, retry_time FROM "%[2]s" WHERE id IN (SELECT MAX(id) FROM "%[2]s" GROUP BY job_id))
+			AS job_latest_state ON jobs.job_id = job_latest_state.job_id%[3]s
+		ORDER BY jobs.job_id LIMIT $%[4]d`, ds.JobTable, ds.JobStatusTable, whereQuery, len(args)+1)
+	//the full slice expression makes append copy args, which is shared by the datasets
+	rows, err := jd.dbHandle.Query(sqlStatement, append(args[:len(args):len(args)], limit)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var headers []JobHeaderT
+	for rows.Next() {
+		var header JobHeaderT
+		var jobState sql.NullString
+		var attempt sql.NullInt64
+		var retryTime sql.NullTime
+		err := rows.Scan(&header.JobID, &header.UUID, &header.UserID, &header.Parameters, &header.CustomVal, &header.EventCount,
+			&header.CreatedAt, &header.ExpireAt, &header.WorkspaceId, &jobState, &attempt, &retryTime)
+		if err != nil {
+			return nil, err
+		}
+		header.JobState = NotProcessed.State
+		if jobState.Valid {
+			header.JobState = jobState.String
+			header.AttemptNum = int(attempt.Int64)
+			header.RetryTime = retryTime.Time
+		}
+		headers = append(headers, header)
+	}
+	return headers, rows.Err()
 }
 
 func (jd *HandleT) GetExecuting(params GetQueryParamsT) []*JobT {
@@ -3863,8 +6639,11 @@ func (jd *HandleT) GetExecuting(params GetQueryParamsT) []*JobT {
 			jobsListChan:   make(chan []*JobT),
 			reqType:        Executing.State,
 		}
-		jd.readChannel <- readJobRequest
+		err := jd.enqueueRead(readJobRequest)
 		readChannelWaitTime.End()
+		if err != nil {
+			return []*JobT{}
+		}
 		jobsList := <-readJobRequest.jobsListChan
 		return jobsList
 	} else {
@@ -3876,7 +6655,7 @@ func (jd *HandleT) GetExecuting(params GetQueryParamsT) []*JobT {
 getExecuting returns events which  in executing state
 */
 func (jd *HandleT) getExecuting(params GetQueryParamsT) []*JobT {
-	return jd.GetProcessed(params)
+	return jd.getProcessedJobs(params)
 }
 
 /*
@@ -3904,8 +6683,11 @@ func (jd *HandleT) DeleteExecuting(params GetQueryParamsT) {
 			deleteParams:  params,
 			errorResponse: respCh,
 		}
-		jd.writeChannel <- writeJobRequest
+		err := jd.enqueueWrite(writeJobRequest, false)
 		waitTimeStat.End()
+		if err != nil {
+			return
+		}
 		<-writeJobRequest.errorResponse
 	} else {
 		jd.deleteJobStatus(params)
@@ -3939,7 +6721,8 @@ func (jd *HandleT) GetLastJob() *JobT {
 	maxID := jd.GetMaxIDForDs(dsList[len(dsList)-1])
 
 	var job JobT
-	sqlStatement := fmt.Sprintf(`SELECT %[1]s.job_id, %[1]s.uuid, %[1]s.user_id, %[1]s.parameters, %[1]s.custom_val, %[1]s.event_payload, %[1]s.created_at, %[1]s.expire_at FROM %[1]s WHERE %[1]s.job_id = %[2]d`, dsList[len(dsList)-1].JobTable, maxID)
+	payloadColumn, payloadJoin := jd.payloadSelect(dsList[len(dsList)-1], false)
+	sqlStatement := fmt.Sprintf(`SELECT jobs.job_id, jobs.uuid, jobs.user_id, jobs.parameters, jobs.custom_val, %[3]s, jobs.created_at, jobs.expire_at FROM %[1]s AS jobs%[4]s WHERE jobs.job_id = %[2]d`, dsList[len(dsList)-1].JobTable, maxID, payloadColumn, payloadJoin)
 	err := jd.dbHandle.QueryRow(sqlStatement).Scan(&job.JobID, &job.UUID, &job.UserID, &job.Parameters, &job.CustomVal, &job.EventPayload, &job.CreatedAt, &job.ExpireAt)
 	if err != nil && err != sql.ErrNoRows {
 		jd.assertError(err)
@@ -164,17 +164,19 @@ func (jd *HandleT) getNonMigratedJobsFromDS(ds dataSetT, count int) ([]*JobT, er
 
 	var sqlStatement string
 
+	payloadTable, _ := jd.payloadTable(ds)
+	payloadColumn, payloadJoin := payloadColumnAndJoin(ds.JobTable, payloadTable)
 	sqlStatement = fmt.Sprintf(`
 		SELECT * FROM (
 			SELECT DISTINCT ON (%[1]s.job_id)
 				%[1]s.job_id, %[1]s.uuid, %[1]s.user_id, %[1]s.parameters, %[1]s.custom_val,
-				%[1]s.event_payload, %[1]s.created_at, %[1]s.expire_at,
+				%[3]s, %[1]s.created_at, %[1]s.expire_at,
 				%[2]s.job_state, %[2]s.attempt, %[2]s.exec_time,
 				%[2]s.retry_time, %[2]s.error_code, %[2]s.error_response
-			FROM %[1]s LEFT JOIN %[2]s
+			FROM %[1]s%[4]s LEFT JOIN %[2]s
 				ON %[1]s.job_id = %[2]s.job_id
 			order by %[1]s.job_id asc, %[2]s.id desc
-		) as temp WHERE job_state IS NULL OR (job_state != 'migrating' AND job_state != 'migrated' AND job_state != 'wont_migrate')`, ds.JobTable, ds.JobStatusTable)
+		) as temp WHERE job_state IS NULL OR (job_state != 'migrating' AND job_state != 'migrated' AND job_state != 'wont_migrate')`, ds.JobTable, ds.JobStatusTable, payloadColumn, payloadJoin)
 
 	jd.assert(count > 0, fmt.Sprintf("count should be greater than 0, but count = %d", count))
 	sqlStatement += fmt.Sprintf(" LIMIT %d", count)
@@ -288,7 +290,7 @@ func (jd *HandleT) getNonExportedJobsCountDS(ds dataSetT) int64 {
 		SELECT count(*) FROM (
 			SELECT DISTINCT ON (%[1]s.job_id)
 				%[1]s.job_id, %[1]s.uuid, %[1]s.user_id, %[1]s.parameters, %[1]s.custom_val,
-				%[1]s.event_payload, %[1]s.created_at, %[1]s.expire_at,
+				%[1]s.created_at, %[1]s.expire_at,
 				%[2]s.job_state, %[2]s.attempt, %[2]s.exec_time,
 				%[2]s.retry_time, %[2]s.error_code, %[2]s.error_response
 			FROM %[1]s LEFT JOIN %[2]s
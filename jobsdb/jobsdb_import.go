@@ -119,6 +119,8 @@ func (jd *HandleT) StoreJobsAndCheckpoint(jobList []*JobT, migrationCheckpoint M
 
 	//Clear ds from cache
 	jd.dropDSFromCache(jd.migrationState.dsForImport)
+
+	jd.dataSetMigrated(importMigration, dataSetT{}, jd.migrationState.dsForImport, len(jobList))
 }
 
 func (jd *HandleT) updateSequenceNumber(ds dataSetT, sequenceNumber int64) {
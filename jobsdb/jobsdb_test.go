@@ -1,9 +1,19 @@
 package jobsdb
 
 import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
 	"time"
 
+	sqlmock "github.com/DATA-DOG/go-sqlmock"
 	uuid "github.com/gofrs/uuid"
+	"github.com/lib/pq"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/ginkgo/extensions/table"
 	. "github.com/onsi/gomega"
@@ -11,6 +21,7 @@ import (
 	"github.com/rudderlabs/rudder-server/config"
 	"github.com/rudderlabs/rudder-server/services/stats"
 	"github.com/rudderlabs/rudder-server/utils/logger"
+	"golang.org/x/sync/errgroup"
 )
 
 var _ = Describe("Calculate newDSIdx for internal migrations", func() {
@@ -62,6 +73,35 @@ var _ = Describe("Calculate newDSIdx for internal migrations", func() {
 	)
 })
 
+var _ = Describe("Calculate newDSIdx for appends", func() {
+	initJobsDB()
+
+	var _ = DescribeTable("newDSIdx tests",
+		func(indices []string, expected string) {
+			dList := make([]dataSetT, len(indices))
+			for i, index := range indices {
+				dList[i] = dataSetT{Index: index}
+			}
+			computedIdx, err := computeAppendIdx(dList)
+			Expect(computedIdx).To(Equal(expected))
+			Expect(err).To(BeNil())
+		},
+		Entry("Append Case 1 : empty dList", []string{}, "1"),
+		Entry("Append Case 2 : ", []string{"1", "2", "3"}, "4"),
+		Entry("Append Case 3 : migration datasets", []string{"1_1", "2_1", "3"}, "4"),
+		Entry("Append Case 4 : import datasets", []string{"0_1", "0_2_1", "1"}, "2"),
+		Entry("Append Case 5 : only sub-indices", []string{"1_1", "2_1"}, "3"),
+		Entry("OrderTest Case 1 Test 2 : ", []string{"8", "9"}, "10"),
+		Entry("OrderTest Case 1 Test 3 : ", []string{"9", "10"}, "11"),
+		Entry("OrderTest Case 1 Test 4 : lexicographic order", []string{"10", "9"}, "11"),
+	)
+
+	It("returns an error for an invalid index", func() {
+		_, err := computeAppendIdx([]dataSetT{{Index: "1"}, {Index: "a"}})
+		Expect(err).NotTo(BeNil())
+	})
+})
+
 var _ = Describe("Calculate newDSIdx for cluster migrations", func() {
 	initJobsDB()
 
@@ -246,6 +286,82 @@ var _ = Describe("jobsdb", func() {
 	})
 })
 
+var _ = Describe("GetWaiting", func() {
+	initJobsDB()
+
+	var (
+		now     = time.Now()
+		columns = []string{"job_id", "uuid", "user_id", "parameters", "custom_val", "event_payload", "event_count", "created_at", "expire_at", "workspace_id", "running_event_counts", "job_state", "attempt", "exec_time", "retry_time", "error_code", "error_response", "status_parameters"}
+	)
+
+	m := newMockJobsDB()
+	freezeTimeNow(now)
+
+	It("reads the jobs whose latest state is waiting", func() {
+		rows := sqlmock.NewRows(columns)
+		for i, jobID := range []int{1, 2} {
+			rows.AddRow(jobID, uuid.Must(uuid.NewV4()).String(), "user-1", []byte(`{}`), "MOCKDS", []byte(`{}`), 1, now, now, "workspace", i+1, Waiting.State, 1, now, now, "429", []byte(`{}`), []byte(`{}`))
+		}
+		m.dbMock.ExpectQuery(`SELECT jobs.job_id, jobs.uuid, jobs.user_id, jobs.parameters, jobs.custom_val, jobs.event_payload, jobs.event_count, jobs.created_at, jobs.expire_at, jobs.workspace_id, sum(jobs.event_count) over (order by jobs.job_id asc) as running_event_counts, job_latest_state.job_state, job_latest_state.attempt, job_latest_state.exec_time, job_latest_state.retry_time, job_latest_state.error_code, job_latest_state.error_response, job_latest_state.parameters FROM "tt_jobs_1" AS jobs, (SELECT job_id, job_state, attempt, exec_time, retry_time, error_code, error_response, parameters FROM "tt_job_status_1" WHERE id IN (SELECT MAX(id) from "tt_job_status_1" GROUP BY job_id) AND ((job_state='waiting'))) AS job_latest_state WHERE jobs.job_id=job_latest_state.job_id AND ((jobs.custom_val='MOCKDS')) AND job_latest_state.retry_time < $1 ORDER BY jobs.job_id LIMIT 2`).
+			WithArgs(now).WillReturnRows(rows)
+
+		jobs := m.jd.GetWaiting(GetQueryParamsT{CustomValFilters: []string{"MOCKDS"}, JobCount: 2})
+		Expect(jobs).To(HaveLen(2))
+		for _, job := range jobs {
+			Expect(job.LastJobStatus.JobState).To(Equal(Waiting.State))
+		}
+	})
+})
+
+var _ = Describe("empty result cache of the waiting jobs", func() {
+	initJobsDB()
+
+	var (
+		now                = time.Now()
+		params             = GetQueryParamsT{StateFilters: []string{Waiting.State}, CustomValFilters: []string{"MOCKDS"}}
+		columns            = []string{"job_id", "uuid", "user_id", "parameters", "custom_val", "event_payload", "event_count", "created_at", "expire_at", "workspace_id", "running_event_counts", "job_state", "attempt", "exec_time", "retry_time", "error_code", "error_response", "status_parameters"}
+		waitingJobsQuery   = `SELECT jobs.job_id, jobs.uuid, jobs.user_id, jobs.parameters, jobs.custom_val, jobs.event_payload, jobs.event_count, jobs.created_at, jobs.expire_at, jobs.workspace_id, sum(jobs.event_count) over (order by jobs.job_id asc) as running_event_counts, job_latest_state.job_state, job_latest_state.attempt, job_latest_state.exec_time, job_latest_state.retry_time, job_latest_state.error_code, job_latest_state.error_response, job_latest_state.parameters FROM "tt_jobs_1" AS jobs, (SELECT job_id, job_state, attempt, exec_time, retry_time, error_code, error_response, parameters FROM "tt_job_status_1" WHERE id IN (SELECT MAX(id) from "tt_job_status_1" GROUP BY job_id) AND ((job_state='waiting'))) AS job_latest_state WHERE jobs.job_id=job_latest_state.job_id AND ((jobs.custom_val='MOCKDS')) AND job_latest_state.retry_time < $1 ORDER BY jobs.job_id`
+		nextRetryTimeQuery = `SELECT MIN(retry_time) FROM "tt_job_status_1" WHERE id IN (SELECT MAX(id) FROM "tt_job_status_1" GROUP BY job_id) AND job_state = $1 AND retry_time >= $2`
+	)
+
+	m := newMockJobsDB()
+	freezeTimeNow(now)
+
+	It("caches the empty result if no job is waiting", func() {
+		m.dbMock.ExpectQuery(waitingJobsQuery).WithArgs(now).WillReturnRows(sqlmock.NewRows(columns))
+		m.dbMock.ExpectQuery(nextRetryTimeQuery).WithArgs(Waiting.State, now).WillReturnRows(sqlmock.NewRows([]string{"min"}).AddRow(nil))
+
+		for i := 0; i < 2; i++ {
+			jobs, err := m.jd.getProcessedJobsDS(d1, false, 0, params)
+			Expect(err).To(BeNil())
+			Expect(jobs).To(BeEmpty())
+		}
+	})
+
+	It("caches the empty result until the earliest waiting job is due", func() {
+		dueAt := time.Now().Add(100 * time.Millisecond)
+		m.dbMock.ExpectQuery(waitingJobsQuery).WithArgs(now).WillReturnRows(sqlmock.NewRows(columns))
+		m.dbMock.ExpectQuery(nextRetryTimeQuery).WithArgs(Waiting.State, now).WillReturnRows(sqlmock.NewRows([]string{"min"}).AddRow(dueAt))
+
+		jobs, err := m.jd.getProcessedJobsDS(d1, false, 0, params)
+		Expect(err).To(BeNil())
+		Expect(jobs).To(BeEmpty())
+		Expect(m.jd.isEmptyResult(d1, allWorkspaces, params.StateFilters, params.CustomValFilters, nil)).To(BeTrue())
+
+		time.Sleep(time.Until(dueAt))
+		Expect(m.jd.isEmptyResult(d1, allWorkspaces, params.StateFilters, params.CustomValFilters, nil)).To(BeFalse())
+	})
+
+	It("doesn't cache the empty result if the next retry time can't be queried", func() {
+		m.dbMock.ExpectQuery(waitingJobsQuery).WithArgs(now).WillReturnRows(sqlmock.NewRows(columns))
+		m.dbMock.ExpectQuery(nextRetryTimeQuery).WithArgs(Waiting.State, now).WillReturnError(errors.New("connection reset"))
+
+		_, err := m.jd.getProcessedJobsDS(d1, false, 0, params)
+		Expect(err).To(BeNil())
+		Expect(m.jd.isEmptyResult(d1, allWorkspaces, params.StateFilters, params.CustomValFilters, nil)).To(BeFalse())
+	})
+})
+
 var d1 = dataSetT{JobTable: "tt_jobs_1",
 	JobStatusTable: "tt_job_status_1"}
 
@@ -258,3 +374,2266 @@ var dsListInMemory = []dataSetT{
 	d1,
 	d2,
 }
+
+//mockJobsDB is a HandleT over a sqlmock db matching the queries exactly, renewed before every spec
+type mockJobsDB struct {
+	jd     *HandleT
+	db     *sql.DB
+	dbMock sqlmock.Sqlmock
+	//onQuery, if set, is called with every query run, before it is matched
+	onQuery func(query string)
+}
+
+//newMockJobsDB sets up a mockJobsDB over the datasets in memory before every spec of the container, to be customized by the container's own BeforeEach,
+//and checks after every spec that all the expected queries were run
+func newMockJobsDB() *mockJobsDB {
+	m := &mockJobsDB{}
+
+	BeforeEach(func() {
+		var err error
+		m.onQuery = nil
+		matcher := sqlmock.QueryMatcherFunc(func(expectedSQL, actualSQL string) error {
+			if m.onQuery != nil {
+				m.onQuery(actualSQL)
+			}
+			return sqlmock.QueryMatcherEqual.Match(expectedSQL, actualSQL)
+		})
+		m.db, m.dbMock, err = sqlmock.New(sqlmock.QueryMatcherOption(matcher))
+		Expect(err).To(BeNil())
+		stats.Setup()
+		m.jd = &HandleT{
+			dbHandle:           m.db,
+			datasetList:        dsListInMemory,
+			dsEmptyResultCache: map[dataSetT]map[string]map[string]map[string]map[string]cacheEntry{},
+			tablePrefix:        "tt",
+			logger:             pkgLogger,
+			BackupSettings:     &BackupSettingsT{},
+		}
+	})
+
+	AfterEach(func() {
+		Expect(m.dbMock.ExpectationsWereMet()).To(BeNil())
+		m.db.Close()
+	})
+
+	return m
+}
+
+//freezeTimeNow makes getTimeNowFunc return now during every spec of the container
+func freezeTimeNow(now time.Time) {
+	var initialTimeNowFunc func() time.Time
+
+	BeforeEach(func() {
+		initialTimeNowFunc = getTimeNowFunc
+		getTimeNowFunc = func() time.Time { return now }
+	})
+
+	AfterEach(func() {
+		getTimeNowFunc = initialTimeNowFunc
+	})
+}
+
+var _ = Describe("storeJobsDSInTxn", func() {
+	initJobsDB()
+
+	var (
+		jobs            []*JobT
+		initialStrategy string
+	)
+
+	m := newMockJobsDB()
+
+	BeforeEach(func() {
+		jobs = []*JobT{
+			{UUID: uuid.Must(uuid.NewV4()), UserID: "user-1", CustomVal: "MOCKDS", Parameters: []byte(`{}`), EventPayload: []byte(`{"a":1}`), WorkspaceId: "workspace-1"},
+			{UUID: uuid.Must(uuid.NewV4()), UserID: "user-2", CustomVal: "MOCKDS", Parameters: []byte(`{}`), EventPayload: []byte(`{"a":2}`), EventCount: 3, WorkspaceId: "workspace-2"},
+		}
+		initialStrategy = storeStrategy
+	})
+
+	AfterEach(func() {
+		storeStrategy = initialStrategy
+	})
+
+	It("uses COPY by default", func() {
+		storeStrategy = storeStrategyCopy
+
+		prepared := m.dbMock.ExpectPrepare(`COPY "tt_jobs_1" ("uuid", "user_id", "custom_val", "parameters", "event_payload", "event_count", "workspace_id") FROM STDIN`)
+		prepared.ExpectExec().WithArgs(sqlmock.AnyArg(), "user-1", "MOCKDS", `{}`, `{"a":1}`, 1, "workspace-1").WillReturnResult(sqlmock.NewResult(0, 1))
+		prepared.ExpectExec().WithArgs(sqlmock.AnyArg(), "user-2", "MOCKDS", `{}`, `{"a":2}`, 3, "workspace-2").WillReturnResult(sqlmock.NewResult(0, 1))
+		prepared.ExpectExec().WillReturnResult(sqlmock.NewResult(0, 0))
+
+		Expect(m.jd.storeJobsDSInTxn(m.db, d1, false, jobs)).To(BeNil())
+	})
+
+	It("uses a single multi-row INSERT with multiInsert strategy", func() {
+		storeStrategy = storeStrategyMultiInsert
+
+		m.dbMock.ExpectExec(`INSERT INTO "tt_jobs_1" (uuid, user_id, custom_val, parameters, event_payload, event_count, workspace_id) VALUES ($1, $2, $3, $4, $5, $6, $7), ($8, $9, $10, $11, $12, $13, $14)`).
+			WithArgs(sqlmock.AnyArg(), "user-1", "MOCKDS", `{}`, `{"a":1}`, 1, "workspace-1",
+				sqlmock.AnyArg(), "user-2", "MOCKDS", `{}`, `{"a":2}`, 3, "workspace-2").
+			WillReturnResult(sqlmock.NewResult(0, 2))
+
+		Expect(m.jd.storeJobsDSInTxn(m.db, d1, false, jobs)).To(BeNil())
+	})
+
+	It("applies the null byte strategy to the payloads", func() {
+		storeStrategy = storeStrategyMultiInsert
+		initialNullByteStrategy := nullByteStrategy
+		defer func() { nullByteStrategy = initialNullByteStrategy }()
+		jobs[1].EventPayload = []byte(`{"a":"x\u0000y"}`)
+
+		nullByteStrategy = nullByteStrategyStrip
+		m.dbMock.ExpectExec(`INSERT INTO "tt_jobs_1" (uuid, user_id, custom_val, parameters, event_payload, event_count, workspace_id) VALUES ($1, $2, $3, $4, $5, $6, $7), ($8, $9, $10, $11, $12, $13, $14)`).
+			WithArgs(sqlmock.AnyArg(), "user-1", "MOCKDS", `{}`, `{"a":1}`, 1, "workspace-1",
+				sqlmock.AnyArg(), "user-2", "MOCKDS", `{}`, `{"a":"xy"}`, 3, "workspace-2").
+			WillReturnResult(sqlmock.NewResult(0, 2))
+		Expect(m.jd.storeJobsDSInTxn(m.db, d1, false, jobs)).To(BeNil())
+
+		nullByteStrategy = nullByteStrategyReject
+		Expect(errors.Is(m.jd.storeJobsDSInTxn(m.db, d1, false, jobs), ErrContainsNullBytes)).To(BeTrue())
+	})
+
+	It("redacts the payloads", func() {
+		storeStrategy = storeStrategyMultiInsert
+		m.jd.redaction = RedactionConfig{Paths: []string{"context.traits.email"}, Action: RedactionDrop}
+		jobs[1].EventPayload = []byte(`{"a":2,"context":{"traits":{"email":"a@b.com"}}}`)
+
+		m.dbMock.ExpectExec(`INSERT INTO "tt_jobs_1" (uuid, user_id, custom_val, parameters, event_payload, event_count, workspace_id) VALUES ($1, $2, $3, $4, $5, $6, $7), ($8, $9, $10, $11, $12, $13, $14)`).
+			WithArgs(sqlmock.AnyArg(), "user-1", "MOCKDS", `{}`, `{"a":1}`, 1, "workspace-1",
+				sqlmock.AnyArg(), "user-2", "MOCKDS", `{}`, `{"a":2,"context":{"traits":{}}}`, 3, "workspace-2").
+			WillReturnResult(sqlmock.NewResult(0, 2))
+		Expect(m.jd.storeJobsDSInTxn(m.db, d1, false, jobs)).To(BeNil())
+
+		//malformed payloads fail the batch, to be stored one at a time
+		jobs[1].EventPayload = []byte(`{"a":`)
+		Expect(m.jd.storeJobsDSInTxn(m.db, d1, false, jobs)).To(Equal(ErrInvalidJSON))
+	})
+
+	It("doesn't redact the payloads again while migrating the jobs", func() {
+		storeStrategy = storeStrategyMultiInsert
+		m.jd.redaction = RedactionConfig{Paths: []string{"context.traits.email"}, Action: RedactionHash}
+		now := time.Now()
+		jobs = jobs[1:]
+		jobs[0].JobID, jobs[0].CreatedAt, jobs[0].ExpireAt = 7, now, now
+		jobs[0].EventPayload = []byte(`{"a":2,"context":{"traits":{"email":"hashed"}}}`)
+
+		m.dbMock.ExpectExec(`INSERT INTO "tt_jobs_1" (job_id, uuid, user_id, custom_val, parameters, event_payload, event_count, created_at, expire_at, workspace_id) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)`).
+			WithArgs(int64(7), sqlmock.AnyArg(), "user-2", "MOCKDS", `{}`, `{"a":2,"context":{"traits":{"email":"hashed"}}}`, 3, now, now, "workspace-2").
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		Expect(m.jd.storeJobsDSInTxn(m.db, d1, true, jobs)).To(BeNil())
+	})
+
+	It("wraps the db error with ErrStorePrepareFailed if the statement can't be prepared", func() {
+		storeStrategy = storeStrategyCopy
+		dbErr := errors.New("connection reset")
+
+		m.dbMock.ExpectPrepare(`COPY "tt_jobs_1" ("uuid", "user_id", "custom_val", "parameters", "event_payload", "event_count", "workspace_id") FROM STDIN`).
+			WillReturnError(dbErr)
+
+		err := m.jd.storeJobsDSInTxn(m.db, d1, false, jobs)
+		Expect(errors.Is(err, ErrStorePrepareFailed)).To(BeTrue())
+		Expect(errors.Is(err, ErrStoreExecFailed)).To(BeFalse())
+		Expect(errors.Is(err, dbErr)).To(BeTrue())
+	})
+
+	It("wraps the db error with ErrStoreExecFailed if the insert fails", func() {
+		storeStrategy = storeStrategyMultiInsert
+		dbErr := errors.New("connection reset")
+
+		m.dbMock.ExpectExec(`INSERT INTO "tt_jobs_1" (uuid, user_id, custom_val, parameters, event_payload, event_count, workspace_id) VALUES ($1, $2, $3, $4, $5, $6, $7), ($8, $9, $10, $11, $12, $13, $14)`).
+			WillReturnError(dbErr)
+
+		err := m.jd.storeJobsDSInTxn(m.db, d1, false, jobs)
+		Expect(errors.Is(err, ErrStoreExecFailed)).To(BeTrue())
+		Expect(errors.Is(err, dbErr)).To(BeTrue())
+	})
+})
+
+var _ = Describe("storeJobDS", func() {
+	initJobsDB()
+
+	var (
+		job             *JobT
+		initialStrategy string
+	)
+
+	m := newMockJobsDB()
+
+	BeforeEach(func() {
+		job = &JobT{UUID: uuid.Must(uuid.NewV4()), UserID: "user-1", CustomVal: "MOCKDS", Parameters: []byte(`{}`), EventPayload: []byte(`{"a":"x\u0000y"}`), WorkspaceId: "workspace"}
+		initialStrategy = nullByteStrategy
+	})
+
+	AfterEach(func() {
+		nullByteStrategy = initialStrategy
+	})
+
+	DescribeTable("stored payload of a null byte strategy",
+		func(strategy, payload, storedPayload string) {
+			nullByteStrategy = strategy
+			job.EventPayload = []byte(payload)
+
+			m.dbMock.ExpectPrepare(`INSERT INTO "tt_jobs_1" (uuid, user_id, custom_val, parameters, event_payload) VALUES ($1, $2, $3, $4, $5::jsonb) RETURNING job_id`).
+				ExpectExec().WithArgs(sqlmock.AnyArg(), "user-1", "MOCKDS", `{}`, storedPayload).WillReturnResult(sqlmock.NewResult(0, 1))
+
+			Expect(m.jd.storeJobDS(d1, job)).To(BeNil())
+		},
+		Entry("strip strategy strips null bytes", nullByteStrategyStrip, `{"a":"x\u0000y"}`, `{"a":"xy"}`),
+		Entry("replace strategy replaces null bytes", nullByteStrategyReplace, `{"a":"x\u0000y"}`, `{"a":"x\ufffdy"}`),
+		Entry("escaped backslashes followed by u0000 are left untouched", nullByteStrategyStrip, `{"path":"C:\\u0000","a":"x\\\u0000y"}`, `{"path":"C:\\u0000","a":"x\\y"}`),
+	)
+
+	It("rejects payloads with null bytes with the reject strategy", func() {
+		nullByteStrategy = nullByteStrategyReject
+
+		Expect(m.jd.storeJobDS(d1, job)).To(Equal(ErrContainsNullBytes))
+	})
+
+	It("rejects only the jobs with null bytes with the reject strategy, unless they are redacted", func() {
+		nullByteStrategy = nullByteStrategyReject
+		m.jd.redaction = RedactionConfig{Paths: []string{"context.traits.email"}, Action: RedactionDrop}
+		escapedBackslash := &JobT{UUID: uuid.Must(uuid.NewV4()), EventPayload: []byte(`{"a":"x\\u0000y"}`)}
+		redacted := &JobT{UUID: uuid.Must(uuid.NewV4()), EventPayload: []byte(`{"context":{"traits":{"email":"x\u0000y"}}}`)}
+
+		jobs, errorMessagesMap := m.jd.splitNullByteRejects([]*JobT{job, escapedBackslash, redacted}, nil)
+		Expect(jobs).To(Equal([]*JobT{escapedBackslash, redacted}))
+		Expect(errorMessagesMap).To(Equal(map[uuid.UUID]string{job.UUID: ErrContainsNullBytes.Error()}))
+
+		stats.Setup()
+		err := m.jd.Store([]*JobT{escapedBackslash, job})
+		Expect(errors.Is(err, ErrContainsNullBytes)).To(BeTrue())
+		Expect(err.Error()).To(ContainSubstring(job.UUID.String()))
+	})
+
+	It("leaves malformed payloads untouched while redacting", func() {
+		m.jd.redaction = RedactionConfig{Paths: []string{"context.traits.email"}, Action: RedactionHash}
+		job.EventPayload = []byte(`{"context":`)
+
+		m.dbMock.ExpectPrepare(`INSERT INTO "tt_jobs_1" (uuid, user_id, custom_val, parameters, event_payload) VALUES ($1, $2, $3, $4, $5::jsonb) RETURNING job_id`).
+			ExpectExec().WithArgs(sqlmock.AnyArg(), "user-1", "MOCKDS", `{}`, `{"context":`).WillReturnError(&pq.Error{Code: pq.ErrorCode(dbErrorMap["Invalid JSON"])})
+
+		Expect(m.jd.storeJobDS(d1, job)).To(Equal(ErrInvalidJSON))
+	})
+})
+
+var _ = Describe("split payload table", func() {
+	initJobsDB()
+
+	var (
+		jobs            []*JobT
+		initialStrategy string
+		initialUseJoin  bool
+		now             = time.Now()
+		//unlike d1 and d2 they have indexes, which the payload tables are tracked by
+		ds1        = dataSetT{JobTable: "tt_jobs_1", JobStatusTable: "tt_job_status_1", Index: "1"}
+		ds2        = dataSetT{JobTable: "tt_jobs_2", JobStatusTable: "tt_job_status_2", Index: "2"}
+		jobColumns = []string{"job_id", "uuid", "user_id", "parameters", "custom_val", "event_payload", "event_count", "created_at", "expire_at", "workspace_id", "running_event_counts"}
+	)
+
+	m := newMockJobsDB()
+
+	BeforeEach(func() {
+		//only ds1 has a payload table
+		m.jd.datasetList = []dataSetT{ds1, ds2}
+		m.jd.datasetRangeList = []dataSetRangeT{{minJobID: 1, maxJobID: 10, ds: ds1}}
+		m.jd.payloadDSs = map[string]bool{ds1.Index: true}
+		jobs = []*JobT{
+			{UUID: uuid.Must(uuid.NewV4()), UserID: "user-1", CustomVal: "MOCKDS", Parameters: []byte(`{}`), EventPayload: []byte(`{"a":1}`), WorkspaceId: "workspace-1"},
+			{UUID: uuid.Must(uuid.NewV4()), UserID: "user-2", CustomVal: "MOCKDS", Parameters: []byte(`{}`), EventPayload: []byte(`{"a":2}`), EventCount: 3, WorkspaceId: "workspace-2"},
+		}
+		initialStrategy, initialUseJoin = storeStrategy, useJoinForUnprocessed
+		useJoinForUnprocessed = true
+	})
+
+	AfterEach(func() {
+		storeStrategy, useJoinForUnprocessed = initialStrategy, initialUseJoin
+	})
+
+	expectJobIDsAllocation := func(jobIDs ...int64) {
+		rows := sqlmock.NewRows([]string{"nextval"})
+		for _, jobID := range jobIDs {
+			rows.AddRow(jobID)
+		}
+		m.dbMock.ExpectPrepare(`SELECT nextval(pg_get_serial_sequence('"tt_jobs_1"', 'job_id')) FROM generate_series(1, $1)`).
+			ExpectQuery().WithArgs(len(jobIDs)).WillReturnRows(rows)
+	}
+
+	It("names the payload table after the jobs table", func() {
+		Expect(payloadTableName(ds1)).To(Equal("tt_job_payloads_1"))
+		Expect(payloadTableName(dataSetT{JobTable: "pre_drop_tt_jobs_1_1", JobStatusTable: "pre_drop_tt_job_status_1_1", Index: "1_1"})).To(Equal("pre_drop_tt_job_payloads_1_1"))
+	})
+
+	It("stores the payloads in the payload table, under job ids allocated beforehand", func() {
+		storeStrategy = storeStrategyCopy
+
+		expectJobIDsAllocation(5, 6)
+		preparedJobs := m.dbMock.ExpectPrepare(`COPY "tt_jobs_1" ("job_id", "uuid", "user_id", "custom_val", "parameters", "event_count", "workspace_id") FROM STDIN`)
+		preparedJobs.ExpectExec().WithArgs(int64(5), sqlmock.AnyArg(), "user-1", "MOCKDS", `{}`, 1, "workspace-1").WillReturnResult(sqlmock.NewResult(0, 1))
+		preparedJobs.ExpectExec().WithArgs(int64(6), sqlmock.AnyArg(), "user-2", "MOCKDS", `{}`, 3, "workspace-2").WillReturnResult(sqlmock.NewResult(0, 1))
+		preparedJobs.ExpectExec().WillReturnResult(sqlmock.NewResult(0, 0))
+		preparedPayloads := m.dbMock.ExpectPrepare(`COPY "tt_job_payloads_1" ("job_id", "event_payload") FROM STDIN`)
+		preparedPayloads.ExpectExec().WithArgs(int64(5), `{"a":1}`).WillReturnResult(sqlmock.NewResult(0, 1))
+		preparedPayloads.ExpectExec().WithArgs(int64(6), `{"a":2}`).WillReturnResult(sqlmock.NewResult(0, 1))
+		preparedPayloads.ExpectExec().WillReturnResult(sqlmock.NewResult(0, 0))
+
+		Expect(m.jd.storeJobsDSInTxn(m.db, ds1, false, jobs)).To(BeNil())
+		//the caller's jobs aren't modified
+		Expect(jobs[0].JobID).To(BeZero())
+	})
+
+	It("stores the payloads in the payload table with multiInsert strategy", func() {
+		storeStrategy = storeStrategyMultiInsert
+
+		expectJobIDsAllocation(5, 6)
+		m.dbMock.ExpectExec(`INSERT INTO "tt_jobs_1" (job_id, uuid, user_id, custom_val, parameters, event_count, workspace_id) VALUES ($1, $2, $3, $4, $5, $6, $7), ($8, $9, $10, $11, $12, $13, $14)`).
+			WithArgs(int64(5), sqlmock.AnyArg(), "user-1", "MOCKDS", `{}`, 1, "workspace-1",
+				int64(6), sqlmock.AnyArg(), "user-2", "MOCKDS", `{}`, 3, "workspace-2").
+			WillReturnResult(sqlmock.NewResult(0, 2))
+		m.dbMock.ExpectExec(`INSERT INTO "tt_job_payloads_1" (job_id, event_payload) VALUES ($1, $2), ($3, $4)`).
+			WithArgs(int64(5), `{"a":1}`, int64(6), `{"a":2}`).
+			WillReturnResult(sqlmock.NewResult(0, 2))
+
+		Expect(m.jd.storeJobsDSInTxn(m.db, ds1, false, jobs)).To(BeNil())
+	})
+
+	It("keeps the ids of the jobs copied to a dataset with a payload table", func() {
+		storeStrategy = storeStrategyMultiInsert
+		jobs[0].JobID, jobs[0].CreatedAt, jobs[0].ExpireAt = 7, now, now
+		jobs = jobs[:1]
+
+		m.dbMock.ExpectExec(`INSERT INTO "tt_jobs_1" (job_id, uuid, user_id, custom_val, parameters, event_count, created_at, expire_at, workspace_id) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)`).
+			WithArgs(int64(7), sqlmock.AnyArg(), "user-1", "MOCKDS", `{}`, 1, now, now, "workspace-1").
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		m.dbMock.ExpectExec(`INSERT INTO "tt_job_payloads_1" (job_id, event_payload) VALUES ($1, $2)`).
+			WithArgs(int64(7), `{"a":1}`).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+
+		Expect(m.jd.storeJobsDSInTxn(m.db, ds1, true, jobs)).To(BeNil())
+	})
+
+	It("joins the payload table while reading the jobs", func() {
+		m.dbMock.ExpectQuery(`SELECT jobs.job_id, jobs.uuid, jobs.user_id, jobs.parameters, jobs.custom_val, payloads.event_payload, jobs.event_count, jobs.created_at, jobs.expire_at, jobs.workspace_id, sum(jobs.event_count) over (order by jobs.job_id asc) as running_event_counts FROM "tt_jobs_1" AS jobs LEFT JOIN "tt_job_status_1" AS job_status ON jobs.job_id=job_status.job_id LEFT JOIN "tt_job_payloads_1" AS payloads ON payloads.job_id = jobs.job_id WHERE job_status.job_id is NULL ORDER BY jobs.job_id LIMIT $1`).
+			WithArgs(10).
+			WillReturnRows(sqlmock.NewRows(jobColumns).
+				AddRow(1, uuid.Must(uuid.NewV4()).String(), "user-1", []byte(`{}`), "MOCKDS", []byte(`{"a":1}`), 1, now, now, "workspace", 1))
+
+		jobs, err := m.jd.getUnprocessedJobsDS(ds1, true, 10, GetQueryParamsT{})
+
+		Expect(err).To(BeNil())
+		Expect(jobs).To(HaveLen(1))
+		Expect(string(jobs[0].EventPayload)).To(Equal(`{"a":1}`))
+	})
+
+	It("doesn't join the payload table if the payloads are skipped, fetching them on demand", func() {
+		m.dbMock.ExpectQuery(`SELECT jobs.job_id, jobs.uuid, jobs.user_id, jobs.parameters, jobs.custom_val, ''::bytea AS event_payload, jobs.event_count, jobs.created_at, jobs.expire_at, jobs.workspace_id, sum(jobs.event_count) over (order by jobs.job_id asc) as running_event_counts FROM "tt_jobs_1" AS jobs LEFT JOIN "tt_job_status_1" AS job_status ON jobs.job_id=job_status.job_id WHERE job_status.job_id is NULL ORDER BY jobs.job_id LIMIT $1`).
+			WithArgs(10).
+			WillReturnRows(sqlmock.NewRows(jobColumns).
+				AddRow(1, uuid.Must(uuid.NewV4()).String(), "user-1", []byte(`{}`), "MOCKDS", []byte{}, 1, now, now, "workspace", 1).
+				AddRow(2, uuid.Must(uuid.NewV4()).String(), "user-2", []byte(`{}`), "MOCKDS", []byte{}, 1, now, now, "workspace", 2))
+
+		jobs, err := m.jd.getUnprocessedJobsDS(ds1, true, 10, GetQueryParamsT{SkipPayload: true})
+
+		Expect(err).To(BeNil())
+		Expect(jobs).To(HaveLen(2))
+		Expect(jobs[0].EventPayload).To(BeEmpty())
+
+		//the job beyond the ranges may be in the dataset without a range, which has no payload table
+		jobs = append(jobs, &JobT{JobID: 11})
+		m.dbMock.ExpectQuery(`SELECT job_id, event_payload FROM "tt_job_payloads_1" WHERE job_id = ANY($1)`).
+			WithArgs(`{1,2}`).
+			WillReturnRows(sqlmock.NewRows([]string{"job_id", "event_payload"}).AddRow(1, []byte(`{"a":1}`)).AddRow(2, []byte(`{"a":2}`)))
+		m.dbMock.ExpectQuery(`SELECT job_id, event_payload FROM "tt_jobs_2" WHERE job_id = ANY($1)`).
+			WithArgs(`{11}`).
+			WillReturnRows(sqlmock.NewRows([]string{"job_id", "event_payload"}).AddRow(11, []byte(`{"a":11}`)))
+
+		Expect(m.jd.FetchPayloads(jobs)).To(BeNil())
+		Expect(string(jobs[0].EventPayload)).To(Equal(`{"a":1}`))
+		Expect(string(jobs[1].EventPayload)).To(Equal(`{"a":2}`))
+		Expect(string(jobs[2].EventPayload)).To(Equal(`{"a":11}`))
+	})
+
+	It("backs up the payloads along with the jobs of the datasets with a payload table", func() {
+		Expect(m.jd.getBackUpQuery(dataSetRangeT{ds: ds1}, false, 0)).To(Equal(
+			fmt.Sprintf(`SELECT json_agg(dump_table) FROM (select jobs.*, payloads.event_payload from "tt_jobs_1" AS jobs LEFT JOIN "tt_job_payloads_1" AS payloads ON payloads.job_id = jobs.job_id order by jobs.job_id asc limit %d offset 0) AS dump_table`, backupRowsBatchSize)))
+		Expect(m.jd.getBackUpQuery(dataSetRangeT{ds: ds2}, false, 0)).To(Equal(
+			fmt.Sprintf(`SELECT json_agg(dump_table) FROM (select * from "tt_jobs_2" order by job_id asc limit %d offset 0) AS dump_table`, backupRowsBatchSize)))
+	})
+})
+
+var _ = Describe("GetJobTimeRange", func() {
+	initJobsDB()
+
+	m := newMockJobsDB()
+
+	It("queries only the boundary datasets", func() {
+		oldest := time.Date(2021, 10, 1, 0, 0, 0, 0, time.UTC)
+		newest := time.Date(2021, 10, 2, 0, 0, 0, 0, time.UTC)
+		m.dbMock.ExpectQuery(`SELECT MIN(created_at) FROM "tt_jobs_1"`).
+			WillReturnRows(sqlmock.NewRows([]string{"min"}).AddRow(oldest))
+		m.dbMock.ExpectQuery(`SELECT MAX(created_at) FROM "tt_jobs_2"`).
+			WillReturnRows(sqlmock.NewRows([]string{"max"}).AddRow(newest))
+
+		gotOldest, gotNewest, err := m.jd.GetJobTimeRange("")
+		Expect(err).To(BeNil())
+		Expect(gotOldest).To(Equal(oldest))
+		Expect(gotNewest).To(Equal(newest))
+	})
+
+	It("moves inwards if a boundary dataset has no matching jobs", func() {
+		oldest := time.Date(2021, 10, 1, 0, 0, 0, 0, time.UTC)
+		newest := time.Date(2021, 10, 2, 0, 0, 0, 0, time.UTC)
+		m.dbMock.ExpectQuery(`SELECT MIN(created_at) FROM "tt_jobs_1" WHERE custom_val = $1`).WithArgs("GW").
+			WillReturnRows(sqlmock.NewRows([]string{"min"}).AddRow(nil))
+		m.dbMock.ExpectQuery(`SELECT MIN(created_at) FROM "tt_jobs_2" WHERE custom_val = $1`).WithArgs("GW").
+			WillReturnRows(sqlmock.NewRows([]string{"min"}).AddRow(oldest))
+		m.dbMock.ExpectQuery(`SELECT MAX(created_at) FROM "tt_jobs_2" WHERE custom_val = $1`).WithArgs("GW").
+			WillReturnRows(sqlmock.NewRows([]string{"max"}).AddRow(newest))
+
+		gotOldest, gotNewest, err := m.jd.GetJobTimeRange("GW")
+		Expect(err).To(BeNil())
+		Expect(gotOldest).To(Equal(oldest))
+		Expect(gotNewest).To(Equal(newest))
+	})
+})
+
+var _ = Describe("StoreInDataSet", func() {
+	initJobsDB()
+
+	var initialStrategy string
+
+	m := newMockJobsDB()
+
+	BeforeEach(func() {
+		m.jd.datasetRangeList = []dataSetRangeT{{minJobID: 1, maxJobID: 100, ds: d1}}
+		initialStrategy = storeStrategy
+		storeStrategy = storeStrategyMultiInsert
+	})
+
+	AfterEach(func() {
+		storeStrategy = initialStrategy
+	})
+
+	It("stores the jobs with their job ids if they are within the range of the dataset", func() {
+		job := &JobT{JobID: 42, UUID: uuid.Must(uuid.NewV4()), UserID: "user-1", CustomVal: "MOCKDS", Parameters: []byte(`{}`), EventPayload: []byte(`{"a":1}`), WorkspaceId: "workspace"}
+
+		m.dbMock.ExpectBegin()
+		m.dbMock.ExpectExec(`INSERT INTO "tt_jobs_1" (job_id, uuid, user_id, custom_val, parameters, event_payload, event_count, created_at, expire_at, workspace_id) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)`).
+			WithArgs(int64(42), sqlmock.AnyArg(), "user-1", "MOCKDS", `{}`, `{"a":1}`, 1, sqlmock.AnyArg(), sqlmock.AnyArg(), "workspace").
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		m.dbMock.ExpectCommit()
+
+		Expect(m.jd.StoreInDataSet(d1, []*JobT{job})).To(BeNil())
+	})
+
+	It("rejects jobs outside the range of the dataset without writing any of them", func() {
+		jobs := []*JobT{
+			{JobID: 100, UUID: uuid.Must(uuid.NewV4()), WorkspaceId: "workspace"},
+			{JobID: 101, UUID: uuid.Must(uuid.NewV4()), WorkspaceId: "workspace"},
+		}
+
+		err := m.jd.StoreInDataSet(d1, jobs)
+		Expect(errors.Is(err, ErrJobIDOutOfRange)).To(BeTrue())
+
+		err = m.jd.StoreInDataSet(d2, jobs[:1])
+		Expect(errors.Is(err, ErrJobIDOutOfRange)).To(BeTrue())
+	})
+
+	It("rejects datasets which don't belong to the jobsdb", func() {
+		ds := dataSetT{JobTable: "tt_jobs_3", JobStatusTable: "tt_job_status_3"}
+
+		err := m.jd.StoreInDataSet(ds, []*JobT{{JobID: 200, UUID: uuid.Must(uuid.NewV4())}})
+		Expect(errors.Is(err, ErrDataSetNotFound)).To(BeTrue())
+	})
+})
+
+var _ = Describe("postMigrateAnalyze", func() {
+	initJobsDB()
+
+	var initialAnalyze, initialVacuum bool
+
+	m := newMockJobsDB()
+
+	BeforeEach(func() {
+		initialAnalyze, initialVacuum = analyzeAfterMigration, vacuumAfterMigration
+	})
+
+	AfterEach(func() {
+		analyzeAfterMigration, vacuumAfterMigration = initialAnalyze, initialVacuum
+	})
+
+	DescribeTable("analysis of the active dataset after a migration",
+		func(analyze, vacuum bool, command string) {
+			analyzeAfterMigration, vacuumAfterMigration = analyze, vacuum
+			if command != "" {
+				m.dbMock.ExpectExec(command + ` "tt_jobs_2"`).WillReturnResult(sqlmock.NewResult(0, 0))
+				m.dbMock.ExpectExec(command + ` "tt_job_status_2"`).WillReturnResult(sqlmock.NewResult(0, 0))
+			}
+
+			m.jd.postMigrateAnalyze()
+		},
+		Entry("none if analyzeAfterMigration is off", false, true, ""),
+		Entry("analyzes the tables if analyzeAfterMigration is on", true, false, "ANALYZE"),
+		Entry("vacuums the tables too if vacuumAfterMigration is on", true, true, "VACUUM ANALYZE"),
+	)
+
+	It("analyzes the tables of a dataset on demand, vacuuming them only if asked to", func() {
+		vacuumAfterMigration = true
+
+		m.dbMock.ExpectExec(`ANALYZE "tt_jobs_1"`).WillReturnResult(sqlmock.NewResult(0, 0))
+		m.dbMock.ExpectExec(`ANALYZE "tt_job_status_1"`).WillReturnResult(sqlmock.NewResult(0, 0))
+		Expect(m.jd.AnalyzeDataSet(d1, false)).To(Succeed())
+
+		m.dbMock.ExpectExec(`VACUUM ANALYZE "tt_jobs_1"`).WillReturnResult(sqlmock.NewResult(0, 0))
+		m.dbMock.ExpectExec(`VACUUM ANALYZE "tt_job_status_1"`).WillReturnResult(sqlmock.NewResult(0, 0))
+		Expect(m.jd.AnalyzeDataSet(d1, true)).To(Succeed())
+	})
+
+	It("analyzes all the datasets, returning the first error", func() {
+		m.dbMock.ExpectExec(`VACUUM ANALYZE "tt_jobs_1"`).WillReturnError(errors.New("canceling statement"))
+		m.dbMock.ExpectExec(`VACUUM ANALYZE "tt_job_status_1"`).WillReturnResult(sqlmock.NewResult(0, 0))
+		m.dbMock.ExpectExec(`VACUUM ANALYZE "tt_jobs_2"`).WillReturnResult(sqlmock.NewResult(0, 0))
+		m.dbMock.ExpectExec(`VACUUM ANALYZE "tt_job_status_2"`).WillReturnError(errors.New("other"))
+
+		err := m.jd.AnalyzeAllDataSets(true)
+		Expect(err).To(MatchError(ContainSubstring(`VACUUM ANALYZE "tt_jobs_1": canceling statement`)))
+	})
+})
+
+var _ = Describe("DropProcessedDataSets", func() {
+	initJobsDB()
+
+	var d3 = dataSetT{JobTable: "tt_jobs_3", JobStatusTable: "tt_job_status_3"}
+
+	m := newMockJobsDB()
+
+	BeforeEach(func() {
+		m.jd.datasetList = []dataSetT{d1, d2, d3}
+		m.jd.datasetRangeList = []dataSetRangeT{{minJobID: 1, maxJobID: 10, ds: d1}, {minJobID: 11, maxJobID: 20, ds: d2}}
+		m.jd.statDropDSPeriod = stats.NewTaggedStat("jobsdb.drop_ds_period", stats.TimerType, stats.Tags{"customVal": "tt"})
+	})
+
+	It("drops only the old datasets with all jobs in a terminal state, except the newest", func() {
+		old := time.Now().Add(-2 * time.Hour)
+		m.dbMock.ExpectQuery(`SELECT COUNT(*), MAX(created_at) FROM "tt_jobs_1"`).
+			WillReturnRows(sqlmock.NewRows([]string{"count", "max"}).AddRow(10, old))
+		m.dbMock.ExpectQuery(`SELECT COUNT(DISTINCT(job_id)) FROM "tt_job_status_1" WHERE job_state IN ('succeeded', 'aborted', 'migrated', 'wont_migrate')`).
+			WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(10))
+		m.dbMock.ExpectBegin()
+		m.dbMock.ExpectPrepare(`LOCK TABLE "tt_job_status_1" IN ACCESS EXCLUSIVE MODE;`).ExpectExec().WillReturnResult(sqlmock.NewResult(0, 0))
+		m.dbMock.ExpectPrepare(`LOCK TABLE "tt_jobs_1" IN ACCESS EXCLUSIVE MODE;`).ExpectExec().WillReturnResult(sqlmock.NewResult(0, 0))
+		m.dbMock.ExpectPrepare(`DROP TABLE IF EXISTS "tt_jobs_1_job_payloads_"`).ExpectExec().WillReturnResult(sqlmock.NewResult(0, 0))
+		m.dbMock.ExpectPrepare(`DROP TABLE "tt_job_status_1"`).ExpectExec().WillReturnResult(sqlmock.NewResult(0, 0))
+		m.dbMock.ExpectPrepare(`DROP TABLE "tt_jobs_1"`).ExpectExec().WillReturnResult(sqlmock.NewResult(0, 0))
+		m.dbMock.ExpectCommit()
+
+		m.dbMock.ExpectQuery(`SELECT COUNT(*), MAX(created_at) FROM "tt_jobs_2"`).
+			WillReturnRows(sqlmock.NewRows([]string{"count", "max"}).AddRow(10, old))
+		m.dbMock.ExpectQuery(`SELECT COUNT(DISTINCT(job_id)) FROM "tt_job_status_2" WHERE job_state IN ('succeeded', 'aborted', 'migrated', 'wont_migrate')`).
+			WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(7))
+
+		dropped, err := m.jd.DropProcessedDataSets(time.Hour, false)
+		Expect(err).To(BeNil())
+		Expect(dropped).To(Equal(DroppedDataSetsT{JobTables: []string{"tt_jobs_1"}, JobCount: 10}))
+		Expect(m.jd.datasetList).To(Equal([]dataSetT{d2, d3}))
+		Expect(m.jd.datasetRangeList).To(Equal([]dataSetRangeT{{minJobID: 11, maxJobID: 20, ds: d2}}))
+	})
+
+	It("doesn't drop datasets with jobs newer than the retention", func() {
+		m.dbMock.ExpectQuery(`SELECT COUNT(*), MAX(created_at) FROM "tt_jobs_1"`).
+			WillReturnRows(sqlmock.NewRows([]string{"count", "max"}).AddRow(10, time.Now()))
+		m.dbMock.ExpectQuery(`SELECT COUNT(*), MAX(created_at) FROM "tt_jobs_2"`).
+			WillReturnRows(sqlmock.NewRows([]string{"count", "max"}).AddRow(10, time.Now()))
+
+		dropped, err := m.jd.DropProcessedDataSets(time.Hour, false)
+		Expect(err).To(BeNil())
+		Expect(dropped).To(Equal(DroppedDataSetsT{}))
+		Expect(m.jd.datasetList).To(Equal([]dataSetT{d1, d2, d3}))
+	})
+
+	It("only returns the datasets which would be dropped on a dry run", func() {
+		old := time.Now().Add(-2 * time.Hour)
+		m.dbMock.ExpectQuery(`SELECT COUNT(*), MAX(created_at) FROM "tt_jobs_1"`).
+			WillReturnRows(sqlmock.NewRows([]string{"count", "max"}).AddRow(10, old))
+		m.dbMock.ExpectQuery(`SELECT COUNT(DISTINCT(job_id)) FROM "tt_job_status_1" WHERE job_state IN ('succeeded', 'aborted', 'migrated', 'wont_migrate')`).
+			WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(10))
+		m.dbMock.ExpectQuery(`SELECT COUNT(*), MAX(created_at) FROM "tt_jobs_2"`).
+			WillReturnRows(sqlmock.NewRows([]string{"count", "max"}).AddRow(5, old))
+		m.dbMock.ExpectQuery(`SELECT COUNT(DISTINCT(job_id)) FROM "tt_job_status_2" WHERE job_state IN ('succeeded', 'aborted', 'migrated', 'wont_migrate')`).
+			WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(5))
+
+		dropped, err := m.jd.DropProcessedDataSets(time.Hour, true)
+		Expect(err).To(BeNil())
+		Expect(dropped).To(Equal(DroppedDataSetsT{JobTables: []string{"tt_jobs_1", "tt_jobs_2"}, JobCount: 15}))
+		Expect(m.jd.datasetList).To(Equal([]dataSetT{d1, d2, d3}))
+		Expect(m.jd.datasetRangeList).To(Equal([]dataSetRangeT{{minJobID: 1, maxJobID: 10, ds: d1}, {minJobID: 11, maxJobID: 20, ds: d2}}))
+	})
+})
+
+var _ = Describe("GetDataSetSizes", func() {
+	initJobsDB()
+
+	var (
+		initialEstimate bool
+		ds1             = dataSetT{JobTable: "tt_jobs_1", JobStatusTable: "tt_job_status_1", Index: "1"}
+		ds2             = dataSetT{JobTable: "tt_jobs_2", JobStatusTable: "tt_job_status_2", Index: "2"}
+		sizeColumns     = []string{"job_count", "status_count", "job_table_size", "status_table_size"}
+	)
+
+	m := newMockJobsDB()
+
+	BeforeEach(func() {
+		//only ds1 has a payload table
+		m.jd.datasetList = []dataSetT{ds1, ds2}
+		m.jd.payloadDSs = map[string]bool{ds1.Index: true}
+		initialEstimate = estimateDataSetSizes
+	})
+
+	AfterEach(func() {
+		estimateDataSetSizes = initialEstimate
+	})
+
+	It("counts the rows and sums up the sizes of the tables of every dataset", func() {
+		estimateDataSetSizes = false
+		m.dbMock.ExpectQuery(`SELECT (SELECT COUNT(*) FROM "tt_jobs_1"), (SELECT COUNT(*) FROM "tt_job_status_1"), pg_total_relation_size('"tt_jobs_1"') + pg_total_relation_size('"tt_job_payloads_1"'), pg_total_relation_size('"tt_job_status_1"')`).
+			WillReturnRows(sqlmock.NewRows(sizeColumns).AddRow(10, 25, 8192, 4096))
+		m.dbMock.ExpectQuery(`SELECT (SELECT COUNT(*) FROM "tt_jobs_2"), (SELECT COUNT(*) FROM "tt_job_status_2"), pg_total_relation_size('"tt_jobs_2"'), pg_total_relation_size('"tt_job_status_2"')`).
+			WillReturnRows(sqlmock.NewRows(sizeColumns).AddRow(3, 0, 2048, 1024))
+
+		sizes, err := m.jd.GetDataSetSizes()
+		Expect(err).To(BeNil())
+		Expect(sizes).To(Equal([]DataSetSize{
+			{Index: "1", JobCount: 10, StatusCount: 25, JobTableSize: 8192, StatusTableSize: 4096},
+			{Index: "2", JobCount: 3, StatusCount: 0, JobTableSize: 2048, StatusTableSize: 1024},
+		}))
+	})
+
+	It("estimates the row counts from the planner statistics", func() {
+		estimateDataSetSizes = true
+		m.jd.datasetList = []dataSetT{ds2}
+		m.dbMock.ExpectQuery(`SELECT (SELECT GREATEST(reltuples, 0)::bigint FROM pg_class WHERE oid = '"tt_jobs_2"'::regclass), (SELECT GREATEST(reltuples, 0)::bigint FROM pg_class WHERE oid = '"tt_job_status_2"'::regclass), pg_total_relation_size('"tt_jobs_2"'), pg_total_relation_size('"tt_job_status_2"')`).
+			WillReturnRows(sqlmock.NewRows(sizeColumns).AddRow(3000, 0, 2048, 1024))
+
+		sizes, err := m.jd.GetDataSetSizes()
+		Expect(err).To(BeNil())
+		Expect(sizes).To(Equal([]DataSetSize{{Index: "2", JobCount: 3000, JobTableSize: 2048, StatusTableSize: 1024}}))
+	})
+
+	It("emits the sizes tagged by dataset index, resetting the ones of the datasets gone", func() {
+		stats.Setup()
+		recorder := &gaugeRecordingStats{Stats: stats.DefaultStats}
+		stats.DefaultStats = recorder
+		defer func() { stats.DefaultStats = recorder.Stats }()
+
+		first := []DataSetSize{{Index: "1", JobCount: 10, StatusCount: 25, JobTableSize: 8192, StatusTableSize: 4096}}
+		m.jd.emitDataSetSizeStats(first, nil)
+		m.jd.emitDataSetSizeStats([]DataSetSize{{Index: "2", JobCount: 3, JobTableSize: 2048, StatusTableSize: 1024}}, first)
+		Expect(recorder.gauges).To(Equal(map[string]interface{}{
+			"jobsdb_dataset_job_count,index=1,tablePrefix=tt":         int64(0),
+			"jobsdb_dataset_status_count,index=1,tablePrefix=tt":      int64(0),
+			"jobsdb_dataset_job_table_size,index=1,tablePrefix=tt":    int64(0),
+			"jobsdb_dataset_status_table_size,index=1,tablePrefix=tt": int64(0),
+			"jobsdb_dataset_job_count,index=2,tablePrefix=tt":         int64(3),
+			"jobsdb_dataset_status_count,index=2,tablePrefix=tt":      int64(0),
+			"jobsdb_dataset_job_table_size,index=2,tablePrefix=tt":    int64(2048),
+			"jobsdb_dataset_status_table_size,index=2,tablePrefix=tt": int64(1024),
+		}))
+	})
+})
+
+var _ = Describe("GetRetryPileUpCounts", func() {
+	initJobsDB()
+
+	m := newMockJobsDB()
+
+	pileUpCountsQuery := func(ds dataSetT, customValQuery string) string {
+		return fmt.Sprintf(`SELECT COUNT(*), jobs.custom_val, jobs.workspace_id FROM "%[1]s" AS jobs, (SELECT job_id, job_state FROM "%[2]s" WHERE id IN (SELECT MAX(id) FROM "%[2]s" GROUP BY job_id)) AS job_latest_state WHERE jobs.job_id = job_latest_state.job_id AND job_latest_state.job_state IN ('failed', 'waiting')%[3]s GROUP BY jobs.custom_val, jobs.workspace_id`, ds.JobTable, ds.JobStatusTable, customValQuery)
+	}
+
+	It("sums up the counts of failed and waiting jobs across datasets by workspace and custom_val", func() {
+		m.dbMock.ExpectQuery(pileUpCountsQuery(d1, ` AND ((jobs.custom_val='WEBHOOK') OR (jobs.custom_val='GA'))`)).
+			WillReturnRows(sqlmock.NewRows([]string{"count", "custom_val", "workspace_id"}).
+				AddRow(3, "WEBHOOK", "workspace-1").
+				AddRow(2, "GA", "workspace-2"))
+		m.dbMock.ExpectQuery(pileUpCountsQuery(d2, ` AND ((jobs.custom_val='WEBHOOK') OR (jobs.custom_val='GA'))`)).
+			WillReturnRows(sqlmock.NewRows([]string{"count", "custom_val", "workspace_id"}).
+				AddRow(4, "WEBHOOK", "workspace-1").
+				AddRow(1, "WEBHOOK", "workspace-2"))
+
+		counts, err := m.jd.GetRetryPileUpCounts([]string{"WEBHOOK", "GA"})
+		Expect(err).To(BeNil())
+		Expect(counts).To(Equal(map[string]map[string]int64{
+			"workspace-1": {"WEBHOOK": 7},
+			"workspace-2": {"GA": 2, "WEBHOOK": 1},
+		}))
+	})
+
+	It("returns the error if a query fails", func() {
+		m.dbMock.ExpectQuery(pileUpCountsQuery(d1, "")).WillReturnError(errors.New("connection reset"))
+
+		_, err := m.jd.GetRetryPileUpCounts(nil)
+		Expect(err).To(MatchError("connection reset"))
+	})
+})
+
+var _ = Describe("failure by code stats", func() {
+	initJobsDB()
+
+	m := newMockJobsDB()
+
+	failureCountsQuery := func(ds dataSetT) string {
+		return fmt.Sprintf(`SELECT jobs.custom_val, COALESCE(job_latest_state.error_code, ''), COUNT(*) FROM "%[1]s" AS jobs, (SELECT job_id, job_state, error_code FROM "%[2]s" WHERE id IN (SELECT MAX(id) FROM "%[2]s" GROUP BY job_id)) AS job_latest_state WHERE jobs.job_id = job_latest_state.job_id AND job_latest_state.job_state = $1 GROUP BY jobs.custom_val, job_latest_state.error_code`, ds.JobTable, ds.JobStatusTable)
+	}
+
+	It("sums up the latest failed statuses across datasets by custom_val and error code", func() {
+		m.dbMock.ExpectQuery(failureCountsQuery(d1)).
+			WithArgs(Failed.State).
+			WillReturnRows(sqlmock.NewRows([]string{"custom_val", "error_code", "count"}).
+				AddRow("GA", "429", 3).
+				AddRow("GA", "500", 1))
+		m.dbMock.ExpectQuery(failureCountsQuery(d2)).
+			WithArgs(Failed.State).
+			WillReturnRows(sqlmock.NewRows([]string{"custom_val", "error_code", "count"}).
+				AddRow("GA", "429", 2).
+				AddRow("AM", "", 4))
+
+		counts, err := m.jd.getFailureCountsByCode()
+		Expect(err).To(BeNil())
+		Expect(counts).To(Equal(map[string]map[string]int64{
+			"GA": {"429": 5, "500": 1},
+			"AM": {"": 4},
+		}))
+	})
+
+	It("emits the counts tagged by custom_val and code, resetting the ones gone", func() {
+		stats.Setup()
+		recorder := &gaugeRecordingStats{Stats: stats.DefaultStats}
+		stats.DefaultStats = recorder
+		defer func() { stats.DefaultStats = recorder.Stats }()
+
+		m.jd.emitFailureByCodeStats(map[string]map[string]int64{"GA": {"429": 5, "500": 1}, "AM": {"": 4}}, nil)
+		Expect(recorder.gauges).To(Equal(map[string]interface{}{
+			"jobsdb_failure_by_code,code=429,custom_val=GA,tablePrefix=tt":     int64(5),
+			"jobsdb_failure_by_code,code=500,custom_val=GA,tablePrefix=tt":     int64(1),
+			"jobsdb_failure_by_code,code=unknown,custom_val=AM,tablePrefix=tt": int64(4),
+		}))
+
+		m.jd.emitFailureByCodeStats(map[string]map[string]int64{"GA": {"429": 2}}, map[string]map[string]int64{"GA": {"429": 5, "500": 1}, "AM": {"": 4}})
+		Expect(recorder.gauges).To(Equal(map[string]interface{}{
+			"jobsdb_failure_by_code,code=429,custom_val=GA,tablePrefix=tt":     int64(2),
+			"jobsdb_failure_by_code,code=500,custom_val=GA,tablePrefix=tt":     int64(0),
+			"jobsdb_failure_by_code,code=unknown,custom_val=AM,tablePrefix=tt": int64(0),
+		}))
+	})
+})
+
+var _ = Describe("GetOldestPendingPerCustomer", func() {
+	initJobsDB()
+
+	var now = time.Now()
+
+	m := newMockJobsDB()
+
+	oldestPendingQuery := func(ds dataSetT, customValQuery string) string {
+		return fmt.Sprintf(`SELECT jobs.workspace_id, MIN(jobs.created_at) FROM "%[1]s" AS jobs LEFT JOIN (SELECT job_id, job_state FROM "%[2]s" WHERE id IN (SELECT MAX(id) FROM "%[2]s" GROUP BY job_id)) AS job_latest_state ON jobs.job_id = job_latest_state.job_id WHERE (job_latest_state.job_id IS NULL OR job_latest_state.job_state = $1)%[3]s GROUP BY jobs.workspace_id`, ds.JobTable, ds.JobStatusTable, customValQuery)
+	}
+
+	It("returns the oldest created_at of the unprocessed or failed jobs across datasets by workspace", func() {
+		m.dbMock.ExpectQuery(oldestPendingQuery(d1, " AND jobs.custom_val = $2")).
+			WithArgs(Failed.State, "WEBHOOK").
+			WillReturnRows(sqlmock.NewRows([]string{"workspace_id", "min"}).
+				AddRow("workspace-1", now.Add(-time.Hour)).
+				AddRow("workspace-2", now.Add(-time.Minute)))
+		m.dbMock.ExpectQuery(oldestPendingQuery(d2, " AND jobs.custom_val = $2")).
+			WithArgs(Failed.State, "WEBHOOK").
+			WillReturnRows(sqlmock.NewRows([]string{"workspace_id", "min"}).
+				AddRow("workspace-2", now.Add(-2*time.Minute)).
+				AddRow("workspace-3", now))
+
+		oldest, err := m.jd.GetOldestPendingPerCustomer("WEBHOOK")
+		Expect(err).To(BeNil())
+		Expect(oldest).To(Equal(map[string]time.Time{
+			"workspace-1": now.Add(-time.Hour),
+			"workspace-2": now.Add(-2 * time.Minute),
+			"workspace-3": now,
+		}))
+	})
+
+	It("considers all the custom vals if the destination type is empty", func() {
+		m.dbMock.ExpectQuery(oldestPendingQuery(d1, "")).
+			WithArgs(Failed.State).
+			WillReturnRows(sqlmock.NewRows([]string{"workspace_id", "min"}))
+		m.dbMock.ExpectQuery(oldestPendingQuery(d2, "")).
+			WithArgs(Failed.State).
+			WillReturnError(errors.New("connection reset"))
+
+		_, err := m.jd.GetOldestPendingPerCustomer("")
+		Expect(err).To(MatchError("connection reset"))
+	})
+})
+
+var _ = Describe("ParametersContains", func() {
+	initJobsDB()
+
+	var (
+		initialUseJoin      bool
+		now                 = time.Now()
+		jobColumns          = []string{"job_id", "uuid", "user_id", "parameters", "custom_val", "event_payload", "event_count", "created_at", "expire_at", "workspace_id", "running_event_counts"}
+		processedJobColumns = append(jobColumns, "job_state", "attempt", "exec_time", "retry_time", "error_code", "error_response", "status_parameters")
+	)
+
+	m := newMockJobsDB()
+	freezeTimeNow(now)
+
+	BeforeEach(func() {
+		//dsEmptyResultCache is left nil, so any attempt to use the empty result cache would panic
+		m.jd.dsEmptyResultCache = nil
+		initialUseJoin = useJoinForUnprocessed
+		useJoinForUnprocessed = true
+	})
+
+	AfterEach(func() {
+		useJoinForUnprocessed = initialUseJoin
+	})
+
+	It("adds a jsonb containment predicate to the unprocessed jobs query", func() {
+		m.dbMock.ExpectQuery(`SELECT jobs.job_id, jobs.uuid, jobs.user_id, jobs.parameters, jobs.custom_val, jobs.event_payload, jobs.event_count, jobs.created_at, jobs.expire_at, jobs.workspace_id,	sum(jobs.event_count) over (order by jobs.job_id asc) as running_event_counts FROM "tt_jobs_1" AS jobs LEFT JOIN "tt_job_status_1" AS job_status ON jobs.job_id=job_status.job_id WHERE job_status.job_id is NULL  AND jobs.parameters @> $1::jsonb ORDER BY jobs.job_id LIMIT $2`).
+			WithArgs(`{"source_id":"src-1"}`, 10).
+			WillReturnRows(sqlmock.NewRows(jobColumns).
+				AddRow(1, uuid.Must(uuid.NewV4()).String(), "user-1", []byte(`{"source_id":"src-1","batch_id":1}`), "MOCKDS", []byte(`{}`), 1, now, now, "workspace", 1))
+
+		jobs, err := m.jd.getUnprocessedJobsDS(d1, true, 10, GetQueryParamsT{ParametersContains: []byte(`{"source_id":"src-1"}`)})
+
+		Expect(err).To(BeNil())
+		Expect(jobs).To(HaveLen(1))
+		Expect(jobs[0].JobID).To(Equal(int64(1)))
+		Expect(string(jobs[0].Parameters)).To(Equal(`{"source_id":"src-1","batch_id":1}`))
+	})
+
+	It("adds a jsonb containment predicate to the processed jobs query", func() {
+		m.dbMock.ExpectQuery(`SELECT jobs.job_id, jobs.uuid, jobs.user_id, jobs.parameters, jobs.custom_val, jobs.event_payload, jobs.event_count, jobs.created_at, jobs.expire_at, jobs.workspace_id, sum(jobs.event_count) over (order by jobs.job_id asc) as running_event_counts, job_latest_state.job_state, job_latest_state.attempt, job_latest_state.exec_time, job_latest_state.retry_time, job_latest_state.error_code, job_latest_state.error_response, job_latest_state.parameters FROM "tt_jobs_1" AS jobs, (SELECT job_id, job_state, attempt, exec_time, retry_time, error_code, error_response, parameters FROM "tt_job_status_1" WHERE id IN (SELECT MAX(id) from "tt_job_status_1" GROUP BY job_id) AND ((job_state='failed'))) AS job_latest_state WHERE jobs.job_id=job_latest_state.job_id AND jobs.parameters @> $2::jsonb AND job_latest_state.retry_time < $1 ORDER BY jobs.job_id LIMIT 10`).
+			WithArgs(now, `{"source_id":"src-1"}`).
+			WillReturnRows(sqlmock.NewRows(processedJobColumns))
+
+		jobs, err := m.jd.getProcessedJobsDS(d1, false, 10, GetQueryParamsT{StateFilters: []string{Failed.State}, ParametersContains: []byte(`{"source_id":"src-1"}`)})
+		Expect(err).To(BeNil())
+		Expect(jobs).To(BeEmpty())
+	})
+})
+
+var _ = Describe("attempt filters", func() {
+	initJobsDB()
+
+	var (
+		now     = time.Now()
+		columns = []string{"job_id", "uuid", "user_id", "parameters", "custom_val", "event_payload", "event_count", "created_at", "expire_at", "workspace_id", "running_event_counts", "job_state", "attempt", "exec_time", "retry_time", "error_code", "error_response", "status_parameters"}
+	)
+
+	m := newMockJobsDB()
+	freezeTimeNow(now)
+
+	BeforeEach(func() {
+		//dsEmptyResultCache is left nil, so any attempt to use the empty result cache would panic
+		m.jd.dsEmptyResultCache = nil
+	})
+
+	DescribeTable("adds the attempt predicate to the processed jobs query",
+		func(minAttempt, maxAttempt int, attemptQuery string, attemptArgs ...driver.Value) {
+			m.dbMock.ExpectQuery(`SELECT jobs.job_id, jobs.uuid, jobs.user_id, jobs.parameters, jobs.custom_val, jobs.event_payload, jobs.event_count, jobs.created_at, jobs.expire_at, jobs.workspace_id, sum(jobs.event_count) over (order by jobs.job_id asc) as running_event_counts, job_latest_state.job_state, job_latest_state.attempt, job_latest_state.exec_time, job_latest_state.retry_time, job_latest_state.error_code, job_latest_state.error_response, job_latest_state.parameters FROM "tt_jobs_1" AS jobs, (SELECT job_id, job_state, attempt, exec_time, retry_time, error_code, error_response, parameters FROM "tt_job_status_1" WHERE id IN (SELECT MAX(id) from "tt_job_status_1" GROUP BY job_id) AND ((job_state='failed'))) AS job_latest_state WHERE jobs.job_id=job_latest_state.job_id ` + attemptQuery + ` AND job_latest_state.retry_time < $1 ORDER BY jobs.job_id LIMIT 10`).
+				WithArgs(append([]driver.Value{now}, attemptArgs...)...).
+				WillReturnRows(sqlmock.NewRows(columns).
+					AddRow(1, uuid.Must(uuid.NewV4()).String(), "user-1", []byte(`{}`), "MOCKDS", []byte(`{}`), 1, now, now, "workspace", 1, Failed.State, 5, now, now, "500", []byte(`{}`), []byte(`{}`)))
+
+			jobs, err := m.jd.getProcessedJobsDS(d1, false, 10, GetQueryParamsT{StateFilters: []string{Failed.State}, MinAttemptNum: minAttempt, MaxAttemptNum: maxAttempt})
+			Expect(err).To(BeNil())
+			Expect(jobs).To(HaveLen(1))
+			Expect(jobs[0].LastJobStatus.AttemptNum).To(Equal(5))
+		},
+		Entry("min and max", 5, 10, `AND job_latest_state.attempt BETWEEN $2 AND $3`, 5, 10),
+		Entry("only min", 5, 0, `AND job_latest_state.attempt >= $2`, 5),
+		Entry("only max", 0, 5, `AND job_latest_state.attempt <= $2`, 5),
+		Entry("exact attempt", 5, 5, `AND job_latest_state.attempt BETWEEN $2 AND $3`, 5, 5),
+	)
+
+	It("excludes the jobs which have exhausted their retries with MaxAttempts", func() {
+		m.dbMock.ExpectQuery(`SELECT jobs.job_id, jobs.uuid, jobs.user_id, jobs.parameters, jobs.custom_val, jobs.event_payload, jobs.event_count, jobs.created_at, jobs.expire_at, jobs.workspace_id, sum(jobs.event_count) over (order by jobs.job_id asc) as running_event_counts, job_latest_state.job_state, job_latest_state.attempt, job_latest_state.exec_time, job_latest_state.retry_time, job_latest_state.error_code, job_latest_state.error_response, job_latest_state.parameters FROM "tt_jobs_1" AS jobs, (SELECT job_id, job_state, attempt, exec_time, retry_time, error_code, error_response, parameters FROM "tt_job_status_1" WHERE id IN (SELECT MAX(id) from "tt_job_status_1" GROUP BY job_id) AND ((job_state='failed'))) AS job_latest_state WHERE jobs.job_id=job_latest_state.job_id AND job_latest_state.attempt < $2 AND job_latest_state.retry_time < $1 ORDER BY jobs.job_id LIMIT 10`).
+			WithArgs(now, 5).
+			WillReturnRows(sqlmock.NewRows(columns).
+				AddRow(1, uuid.Must(uuid.NewV4()).String(), "user-1", []byte(`{}`), "MOCKDS", []byte(`{}`), 1, now, now, "workspace", 1, Failed.State, 4, now, now, "500", []byte(`{}`), []byte(`{}`)))
+
+		jobs, err := m.jd.getProcessedJobsDS(d1, false, 10, GetQueryParamsT{StateFilters: []string{Failed.State}, MaxAttempts: 5})
+		Expect(err).To(BeNil())
+		Expect(jobs).To(HaveLen(1))
+		Expect(jobs[0].LastJobStatus.AttemptNum).To(Equal(4))
+	})
+
+	It("gets the failed jobs which have exhausted their retries with GetExhausted", func() {
+		m.jd.datasetList = []dataSetT{d1}
+		m.dbMock.ExpectQuery(`SELECT jobs.job_id, jobs.uuid, jobs.user_id, jobs.parameters, jobs.custom_val, jobs.event_payload, jobs.event_count, jobs.created_at, jobs.expire_at, jobs.workspace_id, sum(jobs.event_count) over (order by jobs.job_id asc) as running_event_counts, job_latest_state.job_state, job_latest_state.attempt, job_latest_state.exec_time, job_latest_state.retry_time, job_latest_state.error_code, job_latest_state.error_response, job_latest_state.parameters FROM "tt_jobs_1" AS jobs, (SELECT job_id, job_state, attempt, exec_time, retry_time, error_code, error_response, parameters FROM "tt_job_status_1" WHERE id IN (SELECT MAX(id) from "tt_job_status_1" GROUP BY job_id) AND ((job_state='failed'))) AS job_latest_state WHERE jobs.job_id=job_latest_state.job_id AND ((jobs.custom_val='MOCKDS')) AND job_latest_state.attempt >= $2 AND job_latest_state.retry_time < $1 ORDER BY jobs.job_id LIMIT 10`).
+			WithArgs(now, 5).
+			WillReturnRows(sqlmock.NewRows(columns).
+				AddRow(1, uuid.Must(uuid.NewV4()).String(), "user-1", []byte(`{}`), "MOCKDS", []byte(`{}`), 1, now, now, "workspace", 1, Failed.State, 7, now, now, "500", []byte(`{}`), []byte(`{}`)))
+
+		jobs := m.jd.GetExhausted(GetQueryParamsT{CustomValFilters: []string{"MOCKDS"}, JobCount: 10, MaxAttempts: 5}, 5)
+		Expect(jobs).To(HaveLen(1))
+		Expect(jobs[0].LastJobStatus.AttemptNum).To(Equal(7))
+	})
+})
+
+var _ = Describe("WorkspaceFilters", func() {
+	initJobsDB()
+
+	var (
+		initialUseJoin      bool
+		now                 = time.Now()
+		jobColumns          = []string{"job_id", "uuid", "user_id", "parameters", "custom_val", "event_payload", "event_count", "created_at", "expire_at", "workspace_id", "running_event_counts"}
+		processedJobColumns = append(jobColumns, "job_state", "attempt", "exec_time", "retry_time", "error_code", "error_response", "status_parameters")
+	)
+
+	m := newMockJobsDB()
+	freezeTimeNow(now)
+
+	BeforeEach(func() {
+		//dsEmptyResultCache is left nil, so any attempt to use the empty result cache would panic
+		m.jd.dsEmptyResultCache = nil
+		initialUseJoin = useJoinForUnprocessed
+		useJoinForUnprocessed = true
+	})
+
+	AfterEach(func() {
+		useJoinForUnprocessed = initialUseJoin
+	})
+
+	It("filters the unprocessed jobs by the workspace_id column", func() {
+		m.dbMock.ExpectQuery(`SELECT jobs.job_id, jobs.uuid, jobs.user_id, jobs.parameters, jobs.custom_val, jobs.event_payload, jobs.event_count, jobs.created_at, jobs.expire_at, jobs.workspace_id,	sum(jobs.event_count) over (order by jobs.job_id asc) as running_event_counts FROM "tt_jobs_1" AS jobs LEFT JOIN "tt_job_status_1" AS job_status ON jobs.job_id=job_status.job_id WHERE job_status.job_id is NULL  AND ((jobs.custom_val='MOCKDS')) AND jobs.workspace_id = ANY($1) ORDER BY jobs.job_id LIMIT $2`).
+			WithArgs(`{"workspace-1","workspace-2"}`, 10).
+			WillReturnRows(sqlmock.NewRows(jobColumns).
+				AddRow(1, uuid.Must(uuid.NewV4()).String(), "user-1", []byte(`{}`), "MOCKDS", []byte(`{}`), 1, now, now, "workspace-2", 1))
+
+		jobs, err := m.jd.getUnprocessedJobsDS(d1, true, 10, GetQueryParamsT{CustomValFilters: []string{"MOCKDS"}, WorkspaceFilters: []string{"workspace-1", "workspace-2"}})
+
+		Expect(err).To(BeNil())
+		Expect(jobs).To(HaveLen(1))
+		Expect(jobs[0].WorkspaceId).To(Equal("workspace-2"))
+	})
+
+	It("filters the processed jobs by the workspace_id column", func() {
+		m.dbMock.ExpectQuery(`SELECT jobs.job_id, jobs.uuid, jobs.user_id, jobs.parameters, jobs.custom_val, jobs.event_payload, jobs.event_count, jobs.created_at, jobs.expire_at, jobs.workspace_id, sum(jobs.event_count) over (order by jobs.job_id asc) as running_event_counts, job_latest_state.job_state, job_latest_state.attempt, job_latest_state.exec_time, job_latest_state.retry_time, job_latest_state.error_code, job_latest_state.error_response, job_latest_state.parameters FROM "tt_jobs_1" AS jobs, (SELECT job_id, job_state, attempt, exec_time, retry_time, error_code, error_response, parameters FROM "tt_job_status_1" WHERE id IN (SELECT MAX(id) from "tt_job_status_1" GROUP BY job_id) AND ((job_state='failed'))) AS job_latest_state WHERE jobs.job_id=job_latest_state.job_id AND ((jobs.custom_val='MOCKDS')) AND jobs.workspace_id = ANY($2) AND job_latest_state.retry_time < $1 ORDER BY jobs.job_id LIMIT 10`).
+			WithArgs(now, `{"workspace-1"}`).
+			WillReturnRows(sqlmock.NewRows(processedJobColumns).
+				AddRow(1, uuid.Must(uuid.NewV4()).String(), "user-1", []byte(`{}`), "MOCKDS", []byte(`{}`), 1, now, now, "workspace-1", 1, Failed.State, 1, now, now, "500", []byte(`{}`), []byte(`{}`)))
+
+		jobs, err := m.jd.getProcessedJobsDS(d1, false, 10, GetQueryParamsT{StateFilters: []string{Failed.State}, CustomValFilters: []string{"MOCKDS"}, WorkspaceFilters: []string{"workspace-1"}})
+		Expect(err).To(BeNil())
+		Expect(jobs).To(HaveLen(1))
+		Expect(jobs[0].WorkspaceId).To(Equal("workspace-1"))
+	})
+})
+
+var _ = Describe("RefreshDataSetRanges", func() {
+	initJobsDB()
+
+	var (
+		d3           = dataSetT{JobTable: "tt_jobs_3", JobStatusTable: "tt_job_status_3"}
+		staleRanges  = []dataSetRangeT{{minJobID: 1, maxJobID: 5, ds: d1}}
+		rangeColumns = []string{"min", "max", "min", "max"}
+		start        = time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+		end          = start.Add(time.Hour)
+	)
+
+	m := newMockJobsDB()
+
+	BeforeEach(func() {
+		m.jd.datasetList = []dataSetT{d1, d2, d3}
+		m.jd.datasetRangeList = staleRanges
+	})
+
+	It("rebuilds the ranges of all but the last dataset", func() {
+		m.dbMock.ExpectQuery(`SELECT MIN(job_id), MAX(job_id), MIN(created_at), MAX(created_at) FROM "tt_jobs_1"`).
+			WillReturnRows(sqlmock.NewRows(rangeColumns).AddRow(1, 10, start, end))
+		m.dbMock.ExpectQuery(`SELECT MIN(job_id), MAX(job_id), MIN(created_at), MAX(created_at) FROM "tt_jobs_2"`).
+			WillReturnRows(sqlmock.NewRows(rangeColumns).AddRow(11, 20, end, end))
+
+		Expect(m.jd.RefreshDataSetRanges()).To(Succeed())
+		Expect(m.jd.getDSRangeList(false)).To(Equal([]dataSetRangeT{
+			{minJobID: 1, maxJobID: 10, startTime: start.UnixNano() / int64(time.Millisecond), endTime: end.UnixNano() / int64(time.Millisecond), ds: d1},
+			{minJobID: 11, maxJobID: 20, startTime: end.UnixNano() / int64(time.Millisecond), endTime: end.UnixNano() / int64(time.Millisecond), ds: d2},
+		}))
+	})
+
+	It("skips empty datasets", func() {
+		m.dbMock.ExpectQuery(`SELECT MIN(job_id), MAX(job_id), MIN(created_at), MAX(created_at) FROM "tt_jobs_1"`).
+			WillReturnRows(sqlmock.NewRows(rangeColumns).AddRow(nil, nil, nil, nil))
+		m.dbMock.ExpectQuery(`SELECT MIN(job_id), MAX(job_id), MIN(created_at), MAX(created_at) FROM "tt_jobs_2"`).
+			WillReturnRows(sqlmock.NewRows(rangeColumns).AddRow(11, 20, start, end))
+
+		Expect(m.jd.RefreshDataSetRanges()).To(Succeed())
+		Expect(m.jd.getDSRangeList(false)).To(HaveLen(1))
+		Expect(m.jd.getDSRangeList(false)[0].ds).To(Equal(d2))
+	})
+
+	It("keeps the previous ranges if the datasets overlap", func() {
+		m.dbMock.ExpectQuery(`SELECT MIN(job_id), MAX(job_id), MIN(created_at), MAX(created_at) FROM "tt_jobs_1"`).
+			WillReturnRows(sqlmock.NewRows(rangeColumns).AddRow(1, 10, start, end))
+		m.dbMock.ExpectQuery(`SELECT MIN(job_id), MAX(job_id), MIN(created_at), MAX(created_at) FROM "tt_jobs_2"`).
+			WillReturnRows(sqlmock.NewRows(rangeColumns).AddRow(10, 20, start, end))
+
+		Expect(m.jd.RefreshDataSetRanges()).NotTo(Succeed())
+		Expect(m.jd.getDSRangeList(false)).To(Equal(staleRanges))
+	})
+
+	It("keeps the previous ranges if a query fails", func() {
+		m.dbMock.ExpectQuery(`SELECT MIN(job_id), MAX(job_id), MIN(created_at), MAX(created_at) FROM "tt_jobs_1"`).
+			WillReturnError(errors.New("connection reset"))
+
+		err := m.jd.RefreshDataSetRanges()
+		Expect(err).To(HaveOccurred())
+		Expect(err.Error()).To(ContainSubstring("connection reset"))
+		Expect(m.jd.getDSRangeList(false)).To(Equal(staleRanges))
+	})
+
+	It("doesn't write-lock the dataset list while querying, keeping the previous ranges if it changes meanwhile", func() {
+		m.dbMock.ExpectQuery(`SELECT MIN(job_id), MAX(job_id), MIN(created_at), MAX(created_at) FROM "tt_jobs_1"`).
+			WillDelayFor(200 * time.Millisecond).WillReturnRows(sqlmock.NewRows(rangeColumns).AddRow(1, 10, start, end))
+		m.dbMock.ExpectQuery(`SELECT MIN(job_id), MAX(job_id), MIN(created_at), MAX(created_at) FROM "tt_jobs_2"`).
+			WillReturnRows(sqlmock.NewRows(rangeColumns).AddRow(11, 20, end, end))
+
+		errChan := make(chan error, 1)
+		go func() { errChan <- m.jd.RefreshDataSetRanges() }()
+		time.Sleep(50 * time.Millisecond)
+		m.jd.dsListLock.Lock()
+		m.jd.datasetList = []dataSetT{d1, d2, d3, {JobTable: "tt_jobs_4", JobStatusTable: "tt_job_status_4", Index: "4"}}
+		m.jd.dsListLock.Unlock()
+
+		Expect(<-errChan).To(MatchError("datasets changed while computing their ranges"))
+		Expect(m.jd.getDSRangeList(false)).To(Equal(staleRanges))
+	})
+})
+
+var _ = DescribeTable("NewParameterFilter",
+	func(name string, expectedErr error) {
+		filter, err := NewParameterFilter(name, "src-1", false)
+		if expectedErr != nil {
+			Expect(errors.Is(err, expectedErr)).To(BeTrue())
+			return
+		}
+		Expect(err).To(BeNil())
+		Expect(filter).To(Equal(ParameterFilterT{Name: name, Value: "src-1"}))
+	},
+	Entry("returns a filter for a known parameter", "source_id", nil),
+	Entry("rejects an unknown parameter", "source_id'->>'x", ErrInvalidParameterFilter),
+)
+
+var _ = Describe("constructParameterJSONQueryWithArgs", func() {
+	DescribeTable("rendered filters",
+		func(filters []ParameterFilterT, argsOffset int, expectedQuery string, expectedArgs []interface{}) {
+			query, args, err := constructParameterJSONQueryWithArgs("jobs", filters, argsOffset)
+			Expect(err).To(BeNil())
+			Expect(query).To(Equal(expectedQuery))
+			Expect(args).To(Equal(expectedArgs))
+		},
+		Entry("mandatory filters as a single bound containment",
+			[]ParameterFilterT{{Name: "source_id", Value: "src-1"}, {Name: "destination_id", Value: "dst-1"}}, 1,
+			`("jobs".parameters @> $2::jsonb)`,
+			[]interface{}{`{"destination_id":"dst-1","source_id":"src-1"}`}),
+		Entry("optional filters as alternatives where they are missing",
+			[]ParameterFilterT{{Name: "source_id", Value: "src-1"}, {Name: "destination_id", Value: "dst-1", Optional: true}}, 0,
+			`("jobs".parameters @> $1::jsonb OR ("jobs".parameters @> $2::jsonb AND "jobs".parameters -> $3 IS NULL))`,
+			[]interface{}{`{"destination_id":"dst-1","source_id":"src-1"}`, `{"source_id":"src-1"}`, "destination_id"}),
+	)
+
+	It("rejects filters on unknown parameters", func() {
+		_, _, err := constructParameterJSONQueryWithArgs("jobs", []ParameterFilterT{{Name: "event_payload", Value: "x"}}, 0)
+		Expect(errors.Is(err, ErrInvalidParameterFilter)).To(BeTrue())
+	})
+})
+
+var _ = Describe("TearDown", func() {
+	initJobsDB()
+
+	m := newMockJobsDB()
+
+	BeforeEach(func() {
+		m.jd.enableWriterQueue = true
+		m.jd.enableReaderQueue = true
+		m.jd.maxWriters = 1
+		m.jd.maxReaders = 1
+		m.jd.writeChannel = make(chan writeJob)
+		m.jd.readChannel = make(chan readJob)
+		m.jd.writerQueueDepthStat = stats.NewTaggedStat("jobsdb.writer_queue_depth", stats.GaugeType, stats.Tags{"customVal": m.jd.tablePrefix})
+		ctx, cancel := context.WithCancel(context.Background())
+		g, _ := errgroup.WithContext(ctx)
+		m.jd.backgroundCancel = cancel
+		m.jd.backgroundGroup = g
+		m.jd.startWorkers(g)
+	})
+
+	It("commits the stores enqueued before closing the db handle", func() {
+		job := &JobT{UUID: uuid.Must(uuid.NewV4()), UserID: "user-1", CustomVal: "MOCKDS", Parameters: []byte(`{}`), EventPayload: []byte(`{"a":1}`), WorkspaceId: "workspace"}
+
+		m.dbMock.ExpectBegin().WillDelayFor(200 * time.Millisecond)
+		prepared := m.dbMock.ExpectPrepare(`COPY "tt_jobs_2" ("uuid", "user_id", "custom_val", "parameters", "event_payload", "event_count", "workspace_id") FROM STDIN`)
+		prepared.ExpectExec().WithArgs(sqlmock.AnyArg(), "user-1", "MOCKDS", `{}`, `{"a":1}`, 1, "workspace").WillReturnResult(sqlmock.NewResult(0, 1))
+		prepared.ExpectExec().WillReturnResult(sqlmock.NewResult(0, 0))
+		m.dbMock.ExpectCommit()
+		m.dbMock.ExpectClose()
+
+		storeErr := make(chan error, 1)
+		go func() {
+			storeErr <- m.jd.Store([]*JobT{job})
+		}()
+		//Waits for the store to be picked up by the writer, which is held up in Begin
+		time.Sleep(50 * time.Millisecond)
+
+		m.jd.TearDown()
+		Eventually(storeErr).Should(Receive(BeNil()))
+	})
+
+	It("rejects the queued requests without querying the closed db handle if the queues don't drain in time", func() {
+		initialTearDownTimeout := tearDownTimeout
+		defer func() { tearDownTimeout = initialTearDownTimeout }()
+		tearDownTimeout = 100 * time.Millisecond
+
+		newJob := func() *JobT {
+			return &JobT{UUID: uuid.Must(uuid.NewV4()), UserID: "user-1", CustomVal: "MOCKDS", Parameters: []byte(`{}`), EventPayload: []byte(`{"a":1}`), WorkspaceId: "workspace"}
+		}
+
+		m.dbMock.ExpectBegin().WillDelayFor(300 * time.Millisecond)
+		prepared := m.dbMock.ExpectPrepare(`COPY "tt_jobs_2" ("uuid", "user_id", "custom_val", "parameters", "event_payload", "event_count", "workspace_id") FROM STDIN`)
+		prepared.ExpectExec().WithArgs(sqlmock.AnyArg(), "user-1", "MOCKDS", `{}`, `{"a":1}`, 1, "workspace").WillReturnResult(sqlmock.NewResult(0, 1))
+		prepared.ExpectExec().WillReturnResult(sqlmock.NewResult(0, 0))
+		m.dbMock.ExpectCommit()
+		m.dbMock.ExpectClose()
+
+		inFlightErr := make(chan error, 1)
+		go func() {
+			inFlightErr <- m.jd.Store([]*JobT{newJob()})
+		}()
+		//Waits for the first store to be picked up by the writer, which is held up in Begin, so that the second one stays queued
+		time.Sleep(50 * time.Millisecond)
+		queuedErr := make(chan error, 1)
+		go func() {
+			queuedErr <- m.jd.Store([]*JobT{newJob()})
+		}()
+		time.Sleep(50 * time.Millisecond)
+
+		m.jd.TearDown()
+		Eventually(inFlightErr).Should(Receive(BeNil()))
+		Eventually(queuedErr).Should(Receive(MatchError(ErrClosed)))
+		//The queues drain, so that TearDown's goroutine closes them and the workers stop
+		stopped := make(chan struct{})
+		go func() {
+			_ = m.jd.backgroundGroup.Wait()
+			close(stopped)
+		}()
+		Eventually(stopped).Should(BeClosed())
+	})
+
+	It("rejects the requests made after TearDown", func() {
+		m.dbMock.ExpectClose()
+		m.jd.TearDown()
+
+		Expect(m.jd.Store([]*JobT{{UUID: uuid.Must(uuid.NewV4())}})).To(MatchError(ErrClosed))
+		jobs, err := m.jd.GetUnprocessed(GetQueryParamsT{JobCount: 10})
+		Expect(err).To(MatchError(ErrClosed))
+		Expect(jobs).To(BeNil())
+		jobs, err = m.jd.GetToRetry(GetQueryParamsT{JobCount: 10})
+		Expect(err).To(MatchError(ErrClosed))
+		Expect(jobs).To(BeNil())
+	})
+})
+
+var _ = Describe("writer queue", func() {
+	initJobsDB()
+
+	var (
+		jd        *HandleT
+		depthStat *lastGaugeStat
+		storeErrs chan error
+	)
+
+	storeInBackground := func() {
+		go func() {
+			storeErrs <- jd.Store([]*JobT{{UUID: uuid.Must(uuid.NewV4())}})
+		}()
+	}
+
+	BeforeEach(func() {
+		stats.Setup()
+		depthStat = &lastGaugeStat{RudderStats: stats.NewTaggedStat("jobsdb.writer_queue_depth", stats.GaugeType, stats.Tags{"customVal": "tt"})}
+		//No writers are started, so that the requests stay in the queue
+		jd = &HandleT{
+			tablePrefix:          "tt",
+			logger:               pkgLogger,
+			enableWriterQueue:    true,
+			writerQueueCapacity:  2,
+			writeChannel:         make(chan writeJob, 2),
+			writerQueueDepthStat: depthStat,
+		}
+		storeErrs = make(chan error, 3)
+	})
+
+	It("fails the stores with ErrWriterQueueFull once the queue is full, if non blocking", func() {
+		jd.writerQueueNonBlocking = true
+		storeInBackground()
+		storeInBackground()
+		Eventually(func() int { return len(jd.writeChannel) }).Should(Equal(2))
+		Eventually(depthStat.last).Should(Equal(2))
+
+		Expect(jd.Store([]*JobT{{UUID: uuid.Must(uuid.NewV4())}})).To(MatchError(ErrWriterQueueFull))
+		errMap := jd.storeWithRetryEachQueued([]*JobT{{UUID: uuid.Must(uuid.NewV4())}})
+		Expect(errMap).To(HaveLen(1))
+		Expect(errMap).To(ContainElement(ErrWriterQueueFull.Error()))
+		Consistently(storeErrs).ShouldNot(Receive())
+	})
+
+	It("holds the stores back until the queue has room, if blocking", func() {
+		storeInBackground()
+		storeInBackground()
+		storeInBackground()
+		Eventually(func() int { return len(jd.writeChannel) }).Should(Equal(2))
+		Consistently(func() int { return len(jd.writeChannel) }).Should(Equal(2))
+
+		//Serving a request makes room for the held back store
+		writeReq := <-jd.writeChannel
+		writeReq.errorResponse <- nil
+		Eventually(storeErrs).Should(Receive(BeNil()))
+		Eventually(func() int { return len(jd.writeChannel) }).Should(Equal(2))
+		Eventually(depthStat.last).Should(Equal(2))
+	})
+
+	It("falls back to a blocking queue if non blocking without a buffer", func() {
+		jd.writerQueueCapacity = 0
+		jd.writerQueueNonBlocking = true
+		jd.sanitizeWriterQueueConfig()
+		Expect(jd.writerQueueNonBlocking).To(BeFalse())
+	})
+
+	It("keeps the non blocking mode with a buffer", func() {
+		jd.writerQueueNonBlocking = true
+		jd.sanitizeWriterQueueConfig()
+		Expect(jd.writerQueueNonBlocking).To(BeTrue())
+	})
+})
+
+//lastGaugeStat records the last value gauged through it
+type lastGaugeStat struct {
+	stats.RudderStats
+	lock  sync.Mutex
+	value interface{}
+}
+
+func (s *lastGaugeStat) Gauge(value interface{}) {
+	s.lock.Lock()
+	s.value = value
+	s.lock.Unlock()
+	s.RudderStats.Gauge(value)
+}
+
+func (s *lastGaugeStat) last() interface{} {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.value
+}
+
+var _ = Describe("GetToRetry across datasets", func() {
+	initJobsDB()
+
+	var (
+		now     = time.Now()
+		columns = []string{"job_id", "uuid", "user_id", "parameters", "custom_val", "event_payload", "event_count", "created_at", "expire_at", "workspace_id", "running_event_counts", "job_state", "attempt", "exec_time", "retry_time", "error_code", "error_response", "status_parameters"}
+	)
+
+	m := newMockJobsDB()
+	freezeTimeNow(now)
+
+	failedJobsQuery := func(ds dataSetT, limit int) string {
+		return fmt.Sprintf(`SELECT jobs.job_id, jobs.uuid, jobs.user_id, jobs.parameters, jobs.custom_val, jobs.event_payload, jobs.event_count, jobs.created_at, jobs.expire_at, jobs.workspace_id, sum(jobs.event_count) over (order by jobs.job_id asc) as running_event_counts, job_latest_state.job_state, job_latest_state.attempt, job_latest_state.exec_time, job_latest_state.retry_time, job_latest_state.error_code, job_latest_state.error_response, job_latest_state.parameters FROM "%[1]s" AS jobs, (SELECT job_id, job_state, attempt, exec_time, retry_time, error_code, error_response, parameters FROM "%[2]s" WHERE id IN (SELECT MAX(id) from "%[2]s" GROUP BY job_id) AND ((job_state='failed'))) AS job_latest_state WHERE jobs.job_id=job_latest_state.job_id AND job_latest_state.retry_time < $1 ORDER BY jobs.job_id LIMIT %[3]d`, ds.JobTable, ds.JobStatusTable, limit)
+	}
+	failedJobRows := func(jobIDs ...int) *sqlmock.Rows {
+		rows := sqlmock.NewRows(columns)
+		for i, jobID := range jobIDs {
+			rows.AddRow(jobID, uuid.Must(uuid.NewV4()).String(), "user-1", []byte(`{}`), "MOCKDS", []byte(`{}`), 1, now, now, "workspace", i+1, Failed.State, 1, now, now, "500", []byte(`{}`), []byte(`{}`))
+		}
+		return rows
+	}
+
+	It("limits the next dataset to the remaining count", func() {
+		m.dbMock.ExpectQuery(failedJobsQuery(d1, 5)).WithArgs(now).WillReturnRows(failedJobRows(1, 2))
+		m.dbMock.ExpectQuery(failedJobsQuery(d2, 3)).WithArgs(now).WillReturnRows(failedJobRows(11, 12, 13))
+
+		jobs, err := m.jd.getToRetry(GetQueryParamsT{StateFilters: []string{Failed.State}, JobCount: 5})
+		Expect(err).To(BeNil())
+		Expect(jobs).To(HaveLen(5))
+		jobIDs := make([]int64, len(jobs))
+		for i := range jobs {
+			jobIDs[i] = jobs[i].JobID
+		}
+		Expect(jobIDs).To(Equal([]int64{1, 2, 11, 12, 13}))
+	})
+
+	It("doesn't query the next dataset once the count is reached", func() {
+		m.dbMock.ExpectQuery(failedJobsQuery(d1, 2)).WithArgs(now).WillReturnRows(failedJobRows(1, 2))
+
+		Expect(m.jd.getToRetry(GetQueryParamsT{StateFilters: []string{Failed.State}, JobCount: 2})).To(HaveLen(2))
+	})
+
+	It("returns fewer jobs if there aren't enough", func() {
+		m.dbMock.ExpectQuery(failedJobsQuery(d1, 5)).WithArgs(now).WillReturnRows(failedJobRows(1))
+		m.dbMock.ExpectQuery(failedJobsQuery(d2, 4)).WithArgs(now).WillReturnRows(failedJobRows(11))
+
+		Expect(m.jd.getToRetry(GetQueryParamsT{StateFilters: []string{Failed.State}, JobCount: 5})).To(HaveLen(2))
+	})
+
+	It("tags the timers with the workspace hint", func() {
+		recorder := &tagsRecordingStats{Stats: stats.DefaultStats}
+		stats.DefaultStats = recorder
+		defer func() { stats.DefaultStats = recorder.Stats }()
+		m.dbMock.ExpectQuery(failedJobsQuery(d1, 2)).WithArgs(now).WillReturnRows(failedJobRows(1, 2))
+
+		Expect(m.jd.GetToRetry(GetQueryParamsT{JobCount: 2, WorkspaceID: "workspace"})).To(HaveLen(2))
+		Expect(recorder.tagsOf("processed_total_time")).To(HaveKeyWithValue("workspace", "workspace"))
+		Expect(recorder.tagsOf("processed_jobs_time")).To(HaveKeyWithValue("workspace", "workspace"))
+	})
+
+	Context("with a replica", func() {
+		var (
+			replica     *sql.DB
+			replicaMock sqlmock.Sqlmock
+			recorder    *tagsRecordingStats
+		)
+
+		BeforeEach(func() {
+			var err error
+			replica, replicaMock, err = sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+			Expect(err).To(BeNil())
+			m.jd.SetReplica(replica)
+			m.jd.SetMaxReplicaLag(time.Second)
+			recorder = &tagsRecordingStats{Stats: stats.DefaultStats}
+			stats.DefaultStats = recorder
+		})
+
+		AfterEach(func() {
+			stats.DefaultStats = recorder.Stats
+			Expect(replicaMock.ExpectationsWereMet()).To(BeNil())
+			replica.Close()
+		})
+
+		It("reads from the replica if its lag is within the max replica lag", func() {
+			replicaMock.ExpectQuery(defaultReplicaLagQuery).WillReturnRows(sqlmock.NewRows([]string{"lag"}).AddRow(0.5))
+			replicaMock.ExpectQuery(failedJobsQuery(d1, 2)).WithArgs(now).WillReturnRows(failedJobRows(1, 2))
+
+			Expect(m.jd.GetToRetry(GetQueryParamsT{JobCount: 2})).To(HaveLen(2))
+			Expect(recorder.tags).NotTo(HaveKey("replica_lag_fallback"))
+		})
+
+		It("falls back to the primary if the replica lags more than the max replica lag", func() {
+			replicaMock.ExpectQuery(defaultReplicaLagQuery).WillReturnRows(sqlmock.NewRows([]string{"lag"}).AddRow(5))
+			m.dbMock.ExpectQuery(failedJobsQuery(d1, 2)).WithArgs(now).WillReturnRows(failedJobRows(1, 2))
+
+			Expect(m.jd.GetToRetry(GetQueryParamsT{JobCount: 2})).To(HaveLen(2))
+			Expect(recorder.tagsOf("replica_lag_fallback")).To(HaveKeyWithValue("customVal", "tt"))
+		})
+
+		It("falls back to the primary if the replica lag can't be queried", func() {
+			replicaMock.ExpectQuery(defaultReplicaLagQuery).WillReturnError(errors.New("connection reset"))
+			m.dbMock.ExpectQuery(failedJobsQuery(d1, 2)).WithArgs(now).WillReturnRows(failedJobRows(1, 2))
+
+			Expect(m.jd.GetToRetry(GetQueryParamsT{JobCount: 2})).To(HaveLen(2))
+			Expect(recorder.tagsOf("replica_lag_fallback")).To(HaveKeyWithValue("customVal", "tt"))
+		})
+	})
+
+	It("returns the jobs read so far along with a deadline exceeded error if a query times out", func() {
+		m.jd.queryTimeout = 50 * time.Millisecond
+		m.dbMock.ExpectQuery(failedJobsQuery(d1, 5)).WithArgs(now).WillReturnRows(failedJobRows(1, 2))
+		m.dbMock.ExpectQuery(failedJobsQuery(d2, 3)).WithArgs(now).WillDelayFor(time.Second).WillReturnRows(failedJobRows(11, 12, 13))
+
+		start := time.Now()
+		jobs, err := m.jd.GetToRetry(GetQueryParamsT{JobCount: 5})
+		Expect(time.Since(start)).To(BeNumerically("<", time.Second))
+		Expect(errors.Is(err, context.DeadlineExceeded)).To(BeTrue())
+		Expect(jobs).To(HaveLen(2))
+	})
+
+	It("returns the unprocessed jobs read so far along with a deadline exceeded error if a query times out", func() {
+		initialUseJoin := useJoinForUnprocessed
+		defer func() { useJoinForUnprocessed = initialUseJoin }()
+		useJoinForUnprocessed = true
+		unprocessedJobsQuery := func(ds dataSetT) string {
+			return fmt.Sprintf(`SELECT jobs.job_id, jobs.uuid, jobs.user_id, jobs.parameters, jobs.custom_val, jobs.event_payload, jobs.event_count, jobs.created_at, jobs.expire_at, jobs.workspace_id, sum(jobs.event_count) over (order by jobs.job_id asc) as running_event_counts FROM "%s" AS jobs LEFT JOIN "%s" AS job_status ON jobs.job_id=job_status.job_id WHERE job_status.job_id is NULL ORDER BY jobs.job_id LIMIT $1`, ds.JobTable, ds.JobStatusTable)
+		}
+		unprocessedJobRows := func(jobIDs ...int) *sqlmock.Rows {
+			rows := sqlmock.NewRows(columns[:11])
+			for i, jobID := range jobIDs {
+				rows.AddRow(jobID, uuid.Must(uuid.NewV4()).String(), "user-1", []byte(`{}`), "MOCKDS", []byte(`{}`), 1, now, now, "workspace", i+1)
+			}
+			return rows
+		}
+
+		m.jd.queryTimeout = 50 * time.Millisecond
+		m.dbMock.ExpectQuery(unprocessedJobsQuery(d1)).WithArgs(5).WillReturnRows(unprocessedJobRows(1, 2))
+		m.dbMock.ExpectQuery(unprocessedJobsQuery(d2)).WithArgs(3).WillDelayFor(time.Second).WillReturnRows(unprocessedJobRows(11, 12, 13))
+
+		start := time.Now()
+		jobs, err := m.jd.GetUnprocessed(GetQueryParamsT{JobCount: 5})
+		Expect(time.Since(start)).To(BeNumerically("<", time.Second))
+		Expect(errors.Is(err, context.DeadlineExceeded)).To(BeTrue())
+		Expect(jobs).To(HaveLen(2))
+	})
+
+	It("returns a deadline exceeded error and keeps the dataset list if reading the table names times out", func() {
+		m.jd.queryTimeout = 50 * time.Millisecond
+		m.dbMock.ExpectQuery(`SELECT tablename FROM pg_catalog.pg_tables WHERE schemaname != 'pg_catalog' AND schemaname != 'information_schema'`).
+			WillDelayFor(time.Second).
+			WillReturnRows(sqlmock.NewRows([]string{"tablename"}).AddRow("tt_jobs_1").AddRow("tt_job_status_1"))
+
+		err := m.jd.refreshDSList()
+		Expect(errors.Is(err, context.DeadlineExceeded)).To(BeTrue())
+		Expect(m.jd.getDSList(false)).To(Equal(dsListInMemory))
+	})
+})
+
+var _ = DescribeTable("NextQueryLimit",
+	func(limit, maxLimit int, err error, expected int) {
+		Expect(NextQueryLimit(limit, maxLimit, err)).To(Equal(expected))
+	},
+	Entry("halves the limit after a query timeout", 100, 1000, fmt.Errorf("query timed out: %w", context.DeadlineExceeded), 50),
+	Entry("doesn't go below a job", 1, 1000, context.DeadlineExceeded, 1),
+	Entry("doubles the limit after a read that didn't time out", 100, 1000, nil, 200),
+	Entry("doesn't go beyond the max limit", 800, 1000, nil, 1000),
+)
+
+var _ = Describe("GetToRetry split by custom val", func() {
+	initJobsDB()
+
+	var (
+		now     = time.Now()
+		columns = []string{"job_id", "uuid", "user_id", "parameters", "custom_val", "event_payload", "event_count", "created_at", "expire_at", "workspace_id", "running_event_counts", "job_state", "attempt", "exec_time", "retry_time", "error_code", "error_response", "status_parameters"}
+	)
+
+	m := newMockJobsDB()
+	freezeTimeNow(now)
+
+	dueCountsQuery := func(ds dataSetT, conditions string) string {
+		return fmt.Sprintf(`SELECT COUNT(*), jobs.custom_val FROM "%[1]s" AS jobs, (SELECT job_id, job_state, attempt, retry_time FROM "%[2]s" WHERE id IN (SELECT MAX(id) FROM "%[2]s" GROUP BY job_id) AND job_state = 'failed') AS job_latest_state WHERE jobs.job_id = job_latest_state.job_id AND %[3]s AND job_latest_state.retry_time < $1 GROUP BY jobs.custom_val`, ds.JobTable, ds.JobStatusTable, conditions)
+	}
+	failedJobsQuery := func(ds dataSetT, customVal string, limit int) string {
+		return fmt.Sprintf(`SELECT jobs.job_id, jobs.uuid, jobs.user_id, jobs.parameters, jobs.custom_val, jobs.event_payload, jobs.event_count, jobs.created_at, jobs.expire_at, jobs.workspace_id, sum(jobs.event_count) over (order by jobs.job_id asc) as running_event_counts, job_latest_state.job_state, job_latest_state.attempt, job_latest_state.exec_time, job_latest_state.retry_time, job_latest_state.error_code, job_latest_state.error_response, job_latest_state.parameters FROM "%[1]s" AS jobs, (SELECT job_id, job_state, attempt, exec_time, retry_time, error_code, error_response, parameters FROM "%[2]s" WHERE id IN (SELECT MAX(id) from "%[2]s" GROUP BY job_id) AND ((job_state='failed'))) AS job_latest_state WHERE jobs.job_id=job_latest_state.job_id AND ((jobs.custom_val='%[3]s')) AND job_latest_state.retry_time < $1 ORDER BY jobs.job_id LIMIT %[4]d`, ds.JobTable, ds.JobStatusTable, customVal, limit)
+	}
+	failedJobRows := func(customVal string, fromJobID, count int) *sqlmock.Rows {
+		rows := sqlmock.NewRows(columns)
+		for i := 0; i < count; i++ {
+			rows.AddRow(fromJobID+i, uuid.Must(uuid.NewV4()).String(), "user-1", []byte(`{}`), customVal, []byte(`{}`), 1, now, now, "workspace", i+1, Failed.State, 1, now, now, "500", []byte(`{}`), []byte(`{}`))
+		}
+		return rows
+	}
+	countRows := func(counts ...interface{}) *sqlmock.Rows {
+		rows := sqlmock.NewRows([]string{"count", "custom_val"})
+		for i := 0; i < len(counts); i += 2 {
+			rows.AddRow(counts[i], counts[i+1])
+		}
+		return rows
+	}
+
+	It("limits every custom val in the ratio of its due failed jobs", func() {
+		customValQuery := `((jobs.custom_val='WEBHOOK') OR (jobs.custom_val='GA'))`
+		m.dbMock.ExpectQuery(dueCountsQuery(d1, customValQuery)).WithArgs(now).WillReturnRows(countRows(4, "WEBHOOK", 1, "GA"))
+		m.dbMock.ExpectQuery(dueCountsQuery(d2, customValQuery)).WithArgs(now).WillReturnRows(countRows(4, "WEBHOOK", 1, "GA"))
+		m.dbMock.ExpectQuery(failedJobsQuery(d1, "WEBHOOK", 8)).WithArgs(now).WillReturnRows(failedJobRows("WEBHOOK", 1, 8))
+		m.dbMock.ExpectQuery(failedJobsQuery(d1, "GA", 2)).WithArgs(now).WillReturnRows(failedJobRows("GA", 100, 2))
+
+		jobs, err := m.jd.getToRetry(GetQueryParamsT{StateFilters: []string{Failed.State}, CustomValFilters: []string{"WEBHOOK", "GA"}, JobCount: 10, SplitByCustomVal: true})
+		Expect(err).To(BeNil())
+		Expect(jobs).To(HaveLen(10))
+		perCustomVal := map[string]int{}
+		for _, job := range jobs {
+			perCustomVal[job.CustomVal]++
+		}
+		Expect(perCustomVal).To(Equal(map[string]int{"WEBHOOK": 8, "GA": 2}))
+	})
+
+	It("only counts the datasets up to the one where the job count is reached", func() {
+		customValQuery := `((jobs.custom_val='WEBHOOK') OR (jobs.custom_val='GA'))`
+		m.dbMock.ExpectQuery(dueCountsQuery(d1, customValQuery)).WithArgs(now).WillReturnRows(countRows(60, "WEBHOOK", 20, "GA"))
+		m.dbMock.ExpectQuery(failedJobsQuery(d1, "WEBHOOK", 8)).WithArgs(now).WillReturnRows(failedJobRows("WEBHOOK", 1, 8))
+		m.dbMock.ExpectQuery(failedJobsQuery(d1, "GA", 2)).WithArgs(now).WillReturnRows(failedJobRows("GA", 100, 2))
+
+		jobs, err := m.jd.getToRetry(GetQueryParamsT{StateFilters: []string{Failed.State}, CustomValFilters: []string{"WEBHOOK", "GA"}, JobCount: 10, SplitByCustomVal: true})
+		Expect(err).To(BeNil())
+		Expect(jobs).To(HaveLen(10))
+	})
+
+	It("counts the due failed jobs with the same filters as the ones read", func() {
+		m.dbMock.ExpectQuery(dueCountsQuery(d1, `((jobs.custom_val='WEBHOOK') OR (jobs.custom_val='GA')) AND (jobs.parameters @> '{"destination_id":"dest-1"}' ) AND job_latest_state.attempt < $2`)).
+			WithArgs(now, 3).WillReturnRows(countRows(30, "WEBHOOK", 10, "GA"))
+		m.dbMock.ExpectQuery(`SELECT jobs.job_id, jobs.uuid, jobs.user_id, jobs.parameters, jobs.custom_val, jobs.event_payload, jobs.event_count, jobs.created_at, jobs.expire_at, jobs.workspace_id, sum(jobs.event_count) over (order by jobs.job_id asc) as running_event_counts, job_latest_state.job_state, job_latest_state.attempt, job_latest_state.exec_time, job_latest_state.retry_time, job_latest_state.error_code, job_latest_state.error_response, job_latest_state.parameters FROM "tt_jobs_1" AS jobs, (SELECT job_id, job_state, attempt, exec_time, retry_time, error_code, error_response, parameters FROM "tt_job_status_1" WHERE id IN (SELECT MAX(id) from "tt_job_status_1" GROUP BY job_id) AND ((job_state='failed'))) AS job_latest_state WHERE jobs.job_id=job_latest_state.job_id AND ((jobs.custom_val='WEBHOOK')) AND (jobs.parameters @> '{"destination_id":"dest-1"}' ) AND job_latest_state.attempt < $2 AND job_latest_state.retry_time < $1 ORDER BY jobs.job_id LIMIT 3`).
+			WithArgs(now, 3).WillReturnRows(failedJobRows("WEBHOOK", 1, 3))
+		m.dbMock.ExpectQuery(`SELECT jobs.job_id, jobs.uuid, jobs.user_id, jobs.parameters, jobs.custom_val, jobs.event_payload, jobs.event_count, jobs.created_at, jobs.expire_at, jobs.workspace_id, sum(jobs.event_count) over (order by jobs.job_id asc) as running_event_counts, job_latest_state.job_state, job_latest_state.attempt, job_latest_state.exec_time, job_latest_state.retry_time, job_latest_state.error_code, job_latest_state.error_response, job_latest_state.parameters FROM "tt_jobs_1" AS jobs, (SELECT job_id, job_state, attempt, exec_time, retry_time, error_code, error_response, parameters FROM "tt_job_status_1" WHERE id IN (SELECT MAX(id) from "tt_job_status_1" GROUP BY job_id) AND ((job_state='failed'))) AS job_latest_state WHERE jobs.job_id=job_latest_state.job_id AND ((jobs.custom_val='GA')) AND (jobs.parameters @> '{"destination_id":"dest-1"}' ) AND job_latest_state.attempt < $2 AND job_latest_state.retry_time < $1 ORDER BY jobs.job_id LIMIT 1`).
+			WithArgs(now, 3).WillReturnRows(failedJobRows("GA", 100, 1))
+
+		jobs, err := m.jd.getToRetry(GetQueryParamsT{
+			StateFilters:     []string{Failed.State},
+			CustomValFilters: []string{"WEBHOOK", "GA"},
+			ParameterFilters: []ParameterFilterT{{Name: "destination_id", Value: "dest-1"}},
+			MaxAttempts:      3,
+			JobCount:         4,
+			SplitByCustomVal: true,
+		})
+		Expect(err).To(BeNil())
+		Expect(jobs).To(HaveLen(4))
+	})
+
+	It("skips the custom vals without due failed jobs", func() {
+		customValQuery := `((jobs.custom_val='WEBHOOK') OR (jobs.custom_val='GA'))`
+		m.dbMock.ExpectQuery(dueCountsQuery(d1, customValQuery)).WithArgs(now).WillReturnRows(countRows(3, "GA"))
+		m.dbMock.ExpectQuery(dueCountsQuery(d2, customValQuery)).WithArgs(now).WillReturnRows(countRows())
+		m.dbMock.ExpectQuery(failedJobsQuery(d1, "GA", 3)).WithArgs(now).WillReturnRows(failedJobRows("GA", 1, 3))
+
+		jobs, err := m.jd.getToRetry(GetQueryParamsT{StateFilters: []string{Failed.State}, CustomValFilters: []string{"WEBHOOK", "GA"}, JobCount: 10, SplitByCustomVal: true})
+		Expect(err).To(BeNil())
+		Expect(jobs).To(HaveLen(3))
+	})
+
+	It("gets the jobs of all the custom vals together if they can't be counted", func() {
+		m.dbMock.ExpectQuery(dueCountsQuery(d1, `((jobs.custom_val='WEBHOOK') OR (jobs.custom_val='GA'))`)).WithArgs(now).WillReturnError(errors.New("connection reset"))
+		m.dbMock.ExpectQuery(`SELECT jobs.job_id, jobs.uuid, jobs.user_id, jobs.parameters, jobs.custom_val, jobs.event_payload, jobs.event_count, jobs.created_at, jobs.expire_at, jobs.workspace_id, sum(jobs.event_count) over (order by jobs.job_id asc) as running_event_counts, job_latest_state.job_state, job_latest_state.attempt, job_latest_state.exec_time, job_latest_state.retry_time, job_latest_state.error_code, job_latest_state.error_response, job_latest_state.parameters FROM "tt_jobs_1" AS jobs, (SELECT job_id, job_state, attempt, exec_time, retry_time, error_code, error_response, parameters FROM "tt_job_status_1" WHERE id IN (SELECT MAX(id) from "tt_job_status_1" GROUP BY job_id) AND ((job_state='failed'))) AS job_latest_state WHERE jobs.job_id=job_latest_state.job_id AND ((jobs.custom_val='WEBHOOK') OR (jobs.custom_val='GA')) AND job_latest_state.retry_time < $1 ORDER BY jobs.job_id LIMIT 10`).
+			WithArgs(now).WillReturnRows(failedJobRows("WEBHOOK", 1, 10))
+
+		jobs, err := m.jd.getToRetry(GetQueryParamsT{StateFilters: []string{Failed.State}, CustomValFilters: []string{"WEBHOOK", "GA"}, JobCount: 10, SplitByCustomVal: true})
+		Expect(err).To(BeNil())
+		Expect(jobs).To(HaveLen(10))
+	})
+
+	DescribeTable("splitByRatio",
+		func(total int, counts map[string]int64, expected map[string]int) {
+			Expect(splitByRatio(total, []string{"a", "b", "c"}, counts)).To(Equal(expected))
+		},
+		Entry("proportional split", 10, map[string]int64{"a": 50, "b": 30, "c": 20}, map[string]int{"a": 5, "b": 3, "c": 2}),
+		Entry("largest remainders get the rest", 10, map[string]int64{"a": 10, "b": 10, "c": 10}, map[string]int{"a": 4, "b": 3, "c": 3}),
+		Entry("largest remainders get the rest, regardless of the order", 10, map[string]int64{"a": 10, "b": 20, "c": 40}, map[string]int{"a": 1, "b": 3, "c": 6}),
+		Entry("counts within the total", 10, map[string]int64{"a": 2, "c": 5}, map[string]int{"a": 2, "b": 0, "c": 5}),
+	)
+})
+
+//tagsRecordingStats records the tags of the tagged stats created through it
+type tagsRecordingStats struct {
+	stats.Stats
+	tags map[string]stats.Tags
+}
+
+func (s *tagsRecordingStats) NewTaggedStat(name, statType string, tags stats.Tags) stats.RudderStats {
+	if s.tags == nil {
+		s.tags = map[string]stats.Tags{}
+	}
+	s.tags[name] = tags
+	return s.Stats.NewTaggedStat(name, statType, tags)
+}
+
+func (s *tagsRecordingStats) tagsOf(name string) stats.Tags {
+	return s.tags[name]
+}
+
+//gaugeRecordingStats records the latest values of the gauges created through it, keyed by their names and sorted tags
+type gaugeRecordingStats struct {
+	stats.Stats
+	gauges map[string]interface{}
+}
+
+func (s *gaugeRecordingStats) NewTaggedStat(name, statType string, tags stats.Tags) stats.RudderStats {
+	if s.gauges == nil {
+		s.gauges = map[string]interface{}{}
+	}
+	key := name
+	tagNames := make([]string, 0, len(tags))
+	for tagName := range tags {
+		tagNames = append(tagNames, tagName)
+	}
+	sort.Strings(tagNames)
+	for _, tagName := range tagNames {
+		key += "," + tagName + "=" + tags[tagName]
+	}
+	return &gaugeRecordingStat{RudderStats: s.Stats.NewTaggedStat(name, statType, tags), gauges: s.gauges, key: key}
+}
+
+type gaugeRecordingStat struct {
+	stats.RudderStats
+	gauges map[string]interface{}
+	key    string
+}
+
+func (s *gaugeRecordingStat) Gauge(value interface{}) {
+	s.gauges[s.key] = value
+	s.RudderStats.Gauge(value)
+}
+
+var _ = Describe("store timer workspace tag", func() {
+	initJobsDB()
+
+	var jd *HandleT
+
+	BeforeEach(func() {
+		stats.Setup()
+		jd = &HandleT{tablePrefix: "tt", logger: pkgLogger}
+	})
+
+	DescribeTable("workspace tag",
+		func(defaultWorkspaceID string, workspaceIDs []string, expected string) {
+			jd.defaultWorkspaceID = defaultWorkspaceID
+			recorder := &tagsRecordingStats{Stats: stats.DefaultStats}
+			stats.DefaultStats = recorder
+			defer func() { stats.DefaultStats = recorder.Stats }()
+
+			jobList := make([]*JobT, len(workspaceIDs))
+			for i, workspaceID := range workspaceIDs {
+				jobList[i] = &JobT{WorkspaceId: workspaceID}
+			}
+			jd.storeTimerStat("store_total_time", jobList)
+
+			tags := recorder.tagsOf("store_total_time")
+			Expect(tags).To(HaveKeyWithValue("tablePrefix", "tt"))
+			if expected == "" {
+				Expect(tags).NotTo(HaveKey("workspace"))
+			} else {
+				Expect(tags).To(HaveKeyWithValue("workspace", expected))
+			}
+		},
+		Entry("jobs of a single workspace", "", []string{"w1", "w1"}, "w1"),
+		Entry("jobs of mixed workspaces", "default", []string{"w1", "w2"}, ""),
+		Entry("jobs without workspace use the default", "default", []string{"", ""}, "default"),
+		Entry("no workspace and no default", "", []string{""}, ""),
+	)
+})
+
+var _ = Describe("JobT Validate", func() {
+	validJob := func() *JobT {
+		return &JobT{UUID: uuid.Must(uuid.NewV4()), UserID: "user-1", CustomVal: "MOCKDS", Parameters: []byte(`{}`), EventPayload: []byte(`{"a":"b"}`)}
+	}
+
+	It("accepts a valid job", func() {
+		Expect(validJob().Validate()).To(BeNil())
+	})
+
+	DescribeTable("invalid jobs",
+		func(modify func(job *JobT), expected string) {
+			job := validJob()
+			modify(job)
+			err := job.Validate()
+			Expect(errors.Is(err, ErrInvalidJob)).To(BeTrue())
+			Expect(err.Error()).To(ContainSubstring(expected))
+		},
+		Entry("empty custom val", func(job *JobT) { job.CustomVal = "" }, "empty custom_val"),
+		Entry("too long custom val", func(job *JobT) { job.CustomVal = fmt.Sprintf("%065d", 0) }, "custom_val longer than 64"),
+		Entry("nil event payload", func(job *JobT) { job.EventPayload = nil }, "nil event_payload"),
+		Entry("malformed event payload", func(job *JobT) { job.EventPayload = []byte(`{"a":`) }, "event_payload is not valid json"),
+		Entry("empty parameters", func(job *JobT) { job.Parameters = nil }, "parameters are not valid json"),
+		Entry("malformed parameters", func(job *JobT) { job.Parameters = []byte(`{"source_id"}`) }, "parameters are not valid json"),
+	)
+})
+
+var _ = Describe("validateBeforeStore", func() {
+	initJobsDB()
+
+	var (
+		initialValidateBeforeStore bool
+		validJob, invalidJob       *JobT
+	)
+
+	m := newMockJobsDB()
+
+	BeforeEach(func() {
+		validJob = &JobT{UUID: uuid.Must(uuid.NewV4()), UserID: "user-1", CustomVal: "MOCKDS", Parameters: []byte(`{}`), EventPayload: []byte(`{}`), WorkspaceId: "workspace"}
+		invalidJob = &JobT{UUID: uuid.Must(uuid.NewV4()), UserID: "user-1", CustomVal: "MOCKDS", Parameters: []byte(`{}`), EventPayload: []byte(`{"a":`), WorkspaceId: "workspace"}
+		initialValidateBeforeStore = validateBeforeStore
+		validateBeforeStore = true
+	})
+
+	AfterEach(func() {
+		validateBeforeStore = initialValidateBeforeStore
+	})
+
+	It("rejects the whole batch in Store without touching the db", func() {
+		err := m.jd.Store([]*JobT{validJob, invalidJob})
+		Expect(errors.Is(err, ErrInvalidJob)).To(BeTrue())
+		Expect(err.Error()).To(ContainSubstring(invalidJob.UUID.String()))
+	})
+
+	It("rejects the invalid jobs in StoreWithRetryEach without touching the db", func() {
+		errMap := m.jd.StoreWithRetryEach([]*JobT{invalidJob})
+		Expect(errMap).To(HaveLen(1))
+		Expect(errMap[invalidJob.UUID]).To(ContainSubstring("event_payload is not valid json"))
+	})
+})
+
+var _ = Describe("abortReason", func() {
+	DescribeTable("abort reason of a status",
+		func(status JobStatusT, expected string) {
+			Expect(abortReason(&status)).To(Equal(expected))
+		},
+		Entry("not aborted", JobStatusT{JobState: Failed.State, AbortReason: "ignored", ErrorResponse: []byte(`{"reason":"ignored"}`)}, ""),
+		Entry("set by the caller", JobStatusT{JobState: Aborted.State, AbortReason: "too many attempts", ErrorResponse: []byte(`{"reason":"other"}`)}, "too many attempts"),
+		Entry("reason in error response", JobStatusT{JobState: Aborted.State, ErrorResponse: []byte(`{"reason":"invalid payload","error":"other"}`)}, "invalid payload"),
+		Entry("error in error response", JobStatusT{JobState: Aborted.State, ErrorResponse: []byte(`{"error":"400 bad request"}`)}, "400 bad request"),
+		Entry("no reason in error response", JobStatusT{JobState: Aborted.State, ErrorResponse: []byte(`{"success":"OK"}`)}, ""),
+	)
+})
+
+var _ = Describe("RedactionConfig", func() {
+	initJobsDB()
+
+	payload := []byte(`{"event":"identify","context":{"traits":{"email":"a@b.com","age":42,"name":"x"}}}`)
+
+	DescribeTable("redacted payload",
+		func(config RedactionConfig, expected string) {
+			redacted, err := config.redact(payload)
+			Expect(err).To(BeNil())
+			Expect(redacted).To(MatchJSON(expected))
+		},
+		Entry("no paths", RedactionConfig{Action: RedactionDrop}, string(payload)),
+		Entry("dropping a nested path", RedactionConfig{Paths: []string{"context.traits.email"}, Action: RedactionDrop},
+			`{"event":"identify","context":{"traits":{"age":42,"name":"x"}}}`),
+		Entry("hashing a nested path", RedactionConfig{Paths: []string{"context.traits.email", "context.traits.age"}, Action: RedactionHash},
+			`{"event":"identify","context":{"traits":{"email":"fb98d44ad7501a959f3f4f4a3f004fe2d9e581ea6207e218c4b02c08a4d75adf","age":"73475cb40a568e8da8a045ced110137e159f890ac4da883b6b17dc651b3a8049","name":"x"}}}`),
+		Entry("missing path", RedactionConfig{Paths: []string{"context.traits.phone"}, Action: RedactionHash}, string(payload)),
+	)
+
+	It("fails for malformed payloads", func() {
+		_, err := RedactionConfig{Paths: []string{"context.traits.email"}, Action: RedactionDrop}.redact([]byte(`{"context":`))
+		Expect(err).To(Equal(ErrInvalidJSON))
+	})
+
+	DescribeTable("sanitized action",
+		func(action, expected string) {
+			jd := &HandleT{logger: pkgLogger, redaction: RedactionConfig{Action: action}}
+			jd.sanitizeRedactionConfig()
+			Expect(jd.redaction.Action).To(Equal(expected))
+		},
+		Entry("drop", RedactionDrop, RedactionDrop),
+		Entry("hash", RedactionHash, RedactionHash),
+		Entry("unknown action falls back to drop", "mask", RedactionDrop),
+	)
+})
+
+var _ = Describe("retry time", func() {
+	DescribeTable("backoff of an attempt",
+		func(attempt int, expected time.Duration) {
+			Expect(retryBackoff(attempt, 10*time.Second, 5*time.Minute)).To(Equal(expected))
+		},
+		Entry("no attempt yet", 0, 10*time.Second),
+		Entry("first attempt", 1, 10*time.Second),
+		Entry("second attempt", 2, 20*time.Second),
+		Entry("third attempt", 3, 40*time.Second),
+		Entry("fifth attempt", 5, 160*time.Second),
+		Entry("capped", 6, 5*time.Minute),
+		Entry("capped without overflowing", 1000000, 5*time.Minute),
+	)
+
+	It("backs off from now", func() {
+		Expect(ComputeRetryTime(3, time.Second, time.Minute)).To(BeTemporally("~", time.Now().Add(4*time.Second), time.Second))
+	})
+
+	It("populates the retry time of the failed statuses left zero, if enabled", func() {
+		retryTime := time.Now().Add(time.Hour)
+		statusList := []*JobStatusT{
+			{JobID: 1, JobState: Failed.State, AttemptNum: 2},
+			{JobID: 2, JobState: Failed.State, AttemptNum: 2, RetryTime: retryTime},
+			{JobID: 3, JobState: Succeeded.State, AttemptNum: 2},
+		}
+		jd := &HandleT{retryTimeBase: time.Minute, retryTimeMax: time.Hour}
+		jd.populateRetryTime(statusList)
+		Expect(statusList[0].RetryTime).To(BeZero())
+
+		jd.autoRetryTime = true
+		jd.populateRetryTime(statusList)
+		Expect(statusList[0].RetryTime).To(BeTemporally("~", time.Now().Add(2*time.Minute), time.Second))
+		Expect(statusList[1].RetryTime).To(Equal(retryTime))
+		Expect(statusList[2].RetryTime).To(BeZero())
+	})
+})
+
+var _ = Describe("DeleteJobsByUserID", func() {
+	initJobsDB()
+
+	m := newMockJobsDB()
+
+	It("deletes the jobs of the user and their statuses across all datasets in a transaction", func() {
+		m.dbMock.ExpectBegin()
+		m.dbMock.ExpectExec(`DELETE FROM "tt_job_status_1" WHERE job_id IN (SELECT job_id FROM "tt_jobs_1" WHERE user_id = $1)`).WithArgs("user-1").
+			WillReturnResult(sqlmock.NewResult(0, 4))
+		m.dbMock.ExpectExec(`DELETE FROM "tt_jobs_1" WHERE user_id = $1`).WithArgs("user-1").
+			WillReturnResult(sqlmock.NewResult(0, 2))
+		m.dbMock.ExpectExec(`DELETE FROM "tt_job_status_2" WHERE job_id IN (SELECT job_id FROM "tt_jobs_2" WHERE user_id = $1)`).WithArgs("user-1").
+			WillReturnResult(sqlmock.NewResult(0, 0))
+		m.dbMock.ExpectExec(`DELETE FROM "tt_jobs_2" WHERE user_id = $1`).WithArgs("user-1").
+			WillReturnResult(sqlmock.NewResult(0, 3))
+		m.dbMock.ExpectCommit()
+
+		deleted, err := m.jd.DeleteJobsByUserID("user-1", false)
+		Expect(err).To(BeNil())
+		Expect(deleted).To(Equal(int64(5)))
+	})
+
+	It("rolls back the deletes of all datasets if one fails", func() {
+		m.dbMock.ExpectBegin()
+		m.dbMock.ExpectExec(`DELETE FROM "tt_job_status_1" WHERE job_id IN (SELECT job_id FROM "tt_jobs_1" WHERE user_id = $1)`).WithArgs("user-1").
+			WillReturnResult(sqlmock.NewResult(0, 4))
+		m.dbMock.ExpectExec(`DELETE FROM "tt_jobs_1" WHERE user_id = $1`).WithArgs("user-1").
+			WillReturnResult(sqlmock.NewResult(0, 2))
+		m.dbMock.ExpectExec(`DELETE FROM "tt_job_status_2" WHERE job_id IN (SELECT job_id FROM "tt_jobs_2" WHERE user_id = $1)`).WithArgs("user-1").
+			WillReturnError(errors.New("deadlock detected"))
+		m.dbMock.ExpectRollback()
+
+		deleted, err := m.jd.DeleteJobsByUserID("user-1", false)
+		Expect(err).To(MatchError("deadlock detected"))
+		Expect(deleted).To(Equal(int64(0)))
+	})
+
+	It("only counts the jobs of the user on a dry run", func() {
+		m.dbMock.ExpectQuery(`SELECT COUNT(*) FROM "tt_jobs_1" WHERE user_id = $1`).WithArgs("user-1").
+			WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(2))
+		m.dbMock.ExpectQuery(`SELECT COUNT(*) FROM "tt_jobs_2" WHERE user_id = $1`).WithArgs("user-1").
+			WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(3))
+
+		count, err := m.jd.DeleteJobsByUserID("user-1", true)
+		Expect(err).To(BeNil())
+		Expect(count).To(Equal(int64(5)))
+	})
+})
+
+var _ = Describe("GetAbortedJobs", func() {
+	initJobsDB()
+
+	var (
+		since   = time.Now().Add(-time.Hour)
+		now     = time.Now()
+		columns = []string{"job_id", "uuid", "user_id", "parameters", "custom_val", "event_payload", "event_count", "created_at", "expire_at", "workspace_id", "job_state", "attempt", "exec_time", "retry_time", "error_code", "error_response", "parameters", "abort_reason"}
+	)
+
+	m := newMockJobsDB()
+
+	abortedJobsQuery := func(ds dataSetT, customValQuery string, limit int) string {
+		return fmt.Sprintf(`SELECT jobs.job_id, jobs.uuid, jobs.user_id, jobs.parameters, jobs.custom_val, jobs.event_payload, jobs.event_count, jobs.created_at, jobs.expire_at, jobs.workspace_id, job_status.job_state, job_status.attempt, job_status.exec_time, job_status.retry_time, job_status.error_code, job_status.error_response, job_status.parameters, job_status.abort_reason FROM "%[1]s" AS jobs, "%[2]s" AS job_status WHERE jobs.job_id = job_status.job_id AND job_status.job_state = $1 AND job_status.exec_time >= $2 AND jobs.job_id > $3%[3]s ORDER BY jobs.job_id LIMIT %[4]d`, ds.JobTable, ds.JobStatusTable, customValQuery, limit)
+	}
+	abortedJobRow := func(rows *sqlmock.Rows, jobID int, reason string) *sqlmock.Rows {
+		return rows.AddRow(jobID, uuid.Must(uuid.NewV4()).String(), "user-1", []byte(`{}`), "MOCKDS", []byte(`{}`), 1, now, now, "workspace", Aborted.State, 3, now, now, "400", []byte(`{"reason":"`+reason+`"}`), []byte(`{}`), reason)
+	}
+
+	It("returns the aborted jobs of all datasets with their abort reasons", func() {
+		m.dbMock.ExpectQuery(abortedJobsQuery(d1, " AND jobs.custom_val = $4", 10)).WithArgs(Aborted.State, since, 0, "MOCKDS").
+			WillReturnRows(abortedJobRow(sqlmock.NewRows(columns), 1, "invalid payload"))
+		m.dbMock.ExpectQuery(abortedJobsQuery(d2, " AND jobs.custom_val = $4", 9)).WithArgs(Aborted.State, since, 0, "MOCKDS").
+			WillReturnRows(abortedJobRow(sqlmock.NewRows(columns), 11, "too many attempts"))
+
+		jobs, err := m.jd.GetAbortedJobs("MOCKDS", since, 0, 10)
+		Expect(err).To(BeNil())
+		Expect(jobs).To(HaveLen(2))
+		Expect(jobs[0].JobID).To(Equal(int64(1)))
+		Expect(jobs[0].LastJobStatus.JobID).To(Equal(int64(1)))
+		Expect(jobs[0].LastJobStatus.ErrorCode).To(Equal("400"))
+		Expect(jobs[0].LastJobStatus.AbortReason).To(Equal("invalid payload"))
+		Expect(jobs[1].JobID).To(Equal(int64(11)))
+		Expect(jobs[1].LastJobStatus.AbortReason).To(Equal("too many attempts"))
+	})
+
+	It("doesn't filter by custom val if it is empty", func() {
+		m.dbMock.ExpectQuery(abortedJobsQuery(d1, "", 10)).WithArgs(Aborted.State, since, 0).WillReturnRows(sqlmock.NewRows(columns))
+		m.dbMock.ExpectQuery(abortedJobsQuery(d2, "", 10)).WithArgs(Aborted.State, since, 0).WillReturnRows(sqlmock.NewRows(columns))
+
+		jobs, err := m.jd.GetAbortedJobs("", since, 0, 10)
+		Expect(err).To(BeNil())
+		Expect(jobs).To(BeEmpty())
+	})
+
+	It("returns the jobs after the given job id, up to the limit", func() {
+		rows := abortedJobRow(sqlmock.NewRows(columns), 6, "invalid payload")
+		m.dbMock.ExpectQuery(abortedJobsQuery(d1, "", 2)).WithArgs(Aborted.State, since, 5).WillReturnRows(abortedJobRow(rows, 7, "invalid payload"))
+
+		jobs, err := m.jd.GetAbortedJobs("", since, 5, 2)
+		Expect(err).To(BeNil())
+		Expect(jobs).To(HaveLen(2))
+		Expect(jobs[0].JobID).To(Equal(int64(6)))
+		Expect(jobs[1].JobID).To(Equal(int64(7)))
+	})
+
+	It("doesn't query with a zero limit", func() {
+		jobs, err := m.jd.GetAbortedJobs("", since, 0, 0)
+		Expect(err).To(BeNil())
+		Expect(jobs).To(BeEmpty())
+	})
+
+	It("returns the query error", func() {
+		m.dbMock.ExpectQuery(abortedJobsQuery(d1, "", 10)).WithArgs(Aborted.State, since, 0).WillReturnError(errors.New("query failed"))
+
+		_, err := m.jd.GetAbortedJobs("", since, 0, 10)
+		Expect(err).To(MatchError("query failed"))
+	})
+})
+
+var _ = Describe("GetJobsByIDs", func() {
+	initJobsDB()
+
+	var (
+		now     = time.Now()
+		columns = []string{"job_id", "uuid", "user_id", "parameters", "custom_val", "event_payload", "event_count", "created_at", "expire_at", "workspace_id", "job_state", "attempt", "exec_time", "retry_time", "error_code", "error_response", "parameters", "abort_reason"}
+	)
+
+	m := newMockJobsDB()
+
+	jobsByIDsQuery := func(ds dataSetT) string {
+		return fmt.Sprintf(`SELECT jobs.job_id, jobs.uuid, jobs.user_id, jobs.parameters, jobs.custom_val, jobs.event_payload, jobs.event_count, jobs.created_at, jobs.expire_at, jobs.workspace_id, job_latest_state.job_state, job_latest_state.attempt, job_latest_state.exec_time, job_latest_state.retry_time, job_latest_state.error_code, job_latest_state.error_response, job_latest_state.parameters, job_latest_state.abort_reason FROM "%[1]s" AS jobs LEFT JOIN (SELECT job_id, job_state, attempt, exec_time, retry_time, error_code, error_response, parameters, abort_reason FROM "%[2]s" WHERE id IN (SELECT MAX(id) FROM "%[2]s" WHERE job_id = ANY($1) GROUP BY job_id)) AS job_latest_state ON jobs.job_id = job_latest_state.job_id WHERE jobs.job_id = ANY($1) ORDER BY jobs.job_id`, ds.JobTable, ds.JobStatusTable)
+	}
+
+	BeforeEach(func() {
+		m.jd.datasetRangeList = []dataSetRangeT{{minJobID: 1, maxJobID: 10, ds: d1}}
+	})
+
+	It("queries only the datasets containing the ids", func() {
+		m.dbMock.ExpectQuery(jobsByIDsQuery(d1)).WithArgs("{2,5}").
+			WillReturnRows(sqlmock.NewRows(columns).
+				AddRow(2, uuid.Must(uuid.NewV4()).String(), "user-1", []byte(`{}`), "MOCKDS", []byte(`{}`), 1, now, now, "workspace", Failed.State, 1, now, now, "500", []byte(`{}`), []byte(`{}`), "").
+				AddRow(5, uuid.Must(uuid.NewV4()).String(), "user-1", []byte(`{}`), "MOCKDS", []byte(`{}`), 1, now, now, "workspace", nil, nil, nil, nil, nil, nil, nil, nil))
+
+		jobs, err := m.jd.GetJobsByIDs([]int64{2, 5})
+		Expect(err).To(BeNil())
+		Expect(jobs).To(HaveLen(2))
+		Expect(jobs[0].JobID).To(Equal(int64(2)))
+		Expect(jobs[0].LastJobStatus.JobState).To(Equal(Failed.State))
+		Expect(jobs[0].LastJobStatus.AttemptNum).To(Equal(1))
+		Expect(jobs[1].JobID).To(Equal(int64(5)))
+		Expect(jobs[1].LastJobStatus).To(Equal(JobStatusT{}))
+	})
+
+	It("looks for the ids beyond the ranges in the datasets without a range", func() {
+		m.dbMock.ExpectQuery(jobsByIDsQuery(d1)).WithArgs("{3}").WillReturnRows(sqlmock.NewRows(columns))
+		m.dbMock.ExpectQuery(jobsByIDsQuery(d2)).WithArgs("{11,12}").
+			WillReturnRows(sqlmock.NewRows(columns).
+				AddRow(11, uuid.Must(uuid.NewV4()).String(), "user-1", []byte(`{}`), "MOCKDS", []byte(`{}`), 1, now, now, "workspace", nil, nil, nil, nil, nil, nil, nil, nil))
+
+		jobs, err := m.jd.GetJobsByIDs([]int64{11, 3, 12})
+		Expect(err).To(BeNil())
+		Expect(jobs).To(HaveLen(1))
+		Expect(jobs[0].JobID).To(Equal(int64(11)))
+	})
+
+	It("doesn't query for no ids", func() {
+		jobs, err := m.jd.GetJobsByIDs(nil)
+		Expect(err).To(BeNil())
+		Expect(jobs).To(BeEmpty())
+	})
+
+	It("returns the query error", func() {
+		m.dbMock.ExpectQuery(jobsByIDsQuery(d1)).WithArgs("{1}").WillReturnError(errors.New("query failed"))
+
+		_, err := m.jd.GetJobsByIDs([]int64{1})
+		Expect(err).To(MatchError("query failed"))
+	})
+})
+
+var _ = Describe("GetJobStatusHistory", func() {
+	initJobsDB()
+
+	var (
+		now     = time.Now()
+		columns = []string{"job_id", "job_state", "attempt", "exec_time", "retry_time", "error_code", "error_response", "parameters", "abort_reason"}
+	)
+
+	m := newMockJobsDB()
+
+	statusHistoryQuery := func(ds dataSetT) string {
+		return fmt.Sprintf(`SELECT job_id, job_state, attempt, exec_time, retry_time, error_code, error_response, parameters, abort_reason FROM "%s" WHERE job_id = $1 ORDER BY id`, ds.JobStatusTable)
+	}
+
+	BeforeEach(func() {
+		m.jd.datasetRangeList = []dataSetRangeT{{minJobID: 1, maxJobID: 10, ds: d1}}
+	})
+
+	It("returns all the statuses of the job in order from the dataset containing it", func() {
+		m.dbMock.ExpectQuery(statusHistoryQuery(d1)).WithArgs(int64(2)).
+			WillReturnRows(sqlmock.NewRows(columns).
+				AddRow(2, Executing.State, 0, now, now, nil, []byte(`{}`), []byte(`{}`), "").
+				AddRow(2, Failed.State, 1, now, now.Add(time.Minute), "500", []byte(`{"error":"timeout"}`), []byte(`{}`), "").
+				AddRow(2, Executing.State, 1, now, now, nil, []byte(`{}`), []byte(`{}`), "").
+				AddRow(2, Aborted.State, 2, now, now, "400", []byte(`{"error":"bad request"}`), []byte(`{}`), "max_attempts"))
+
+		statuses, err := m.jd.GetJobStatusHistory(2)
+		Expect(err).To(BeNil())
+		states := make([]string, 0, len(statuses))
+		for _, status := range statuses {
+			Expect(status.JobID).To(Equal(int64(2)))
+			states = append(states, status.JobState)
+		}
+		Expect(states).To(Equal([]string{Executing.State, Failed.State, Executing.State, Aborted.State}))
+		Expect(statuses[1].ErrorCode).To(Equal("500"))
+		Expect(statuses[1].AttemptNum).To(Equal(1))
+		Expect(statuses[3].AbortReason).To(Equal("max_attempts"))
+	})
+
+	It("looks for a job beyond the ranges in the datasets without a range", func() {
+		m.dbMock.ExpectQuery(statusHistoryQuery(d2)).WithArgs(int64(11)).
+			WillReturnRows(sqlmock.NewRows(columns).AddRow(11, Succeeded.State, 1, now, now, "200", []byte(`{}`), []byte(`{}`), ""))
+
+		statuses, err := m.jd.GetJobStatusHistory(11)
+		Expect(err).To(BeNil())
+		Expect(statuses).To(HaveLen(1))
+		Expect(statuses[0].JobState).To(Equal(Succeeded.State))
+	})
+
+	It("returns no statuses for a job which wasn't processed", func() {
+		m.dbMock.ExpectQuery(statusHistoryQuery(d1)).WithArgs(int64(3)).WillReturnRows(sqlmock.NewRows(columns))
+
+		statuses, err := m.jd.GetJobStatusHistory(3)
+		Expect(err).To(BeNil())
+		Expect(statuses).To(BeEmpty())
+	})
+
+	It("returns the query error", func() {
+		m.dbMock.ExpectQuery(statusHistoryQuery(d1)).WithArgs(int64(1)).WillReturnError(errors.New("query failed"))
+
+		_, err := m.jd.GetJobStatusHistory(1)
+		Expect(err).To(MatchError("query failed"))
+	})
+})
+
+var _ = Describe("PeekJobs", func() {
+	initJobsDB()
+
+	var (
+		queries []string
+		now     = time.Now()
+		columns = []string{"job_id", "uuid", "user_id", "parameters", "custom_val", "event_count", "created_at", "expire_at", "workspace_id", "job_state", "attempt", "retry_time"}
+	)
+
+	m := newMockJobsDB()
+
+	peekQuery := func(ds dataSetT, whereQuery string, limitArg int) string {
+		return fmt.Sprintf(`SELECT jobs.job_id, jobs.uuid, jobs.user_id, jobs.parameters, jobs.custom_val, jobs.event_count, jobs.created_at, jobs.expire_at, jobs.workspace_id, job_latest_state.job_state, job_latest_state.attempt, job_latest_state.retry_time FROM "%[1]s" AS jobs LEFT JOIN (SELECT job_id, job_state, attempt, retry_time FROM "%[2]s" WHERE id IN (SELECT MAX(id) FROM "%[2]s" GROUP BY job_id)) AS job_latest_state ON jobs.job_id = job_latest_state.job_id%[3]s ORDER BY jobs.job_id LIMIT $%[4]d`, ds.JobTable, ds.JobStatusTable, whereQuery, limitArg)
+	}
+
+	BeforeEach(func() {
+		queries = nil
+		//recording the queries, to check that the payloads aren't selected
+		m.onQuery = func(query string) {
+			queries = append(queries, query)
+		}
+	})
+
+	It("returns the headers of the jobs without selecting their payloads", func() {
+		jobUUID := uuid.Must(uuid.NewV4())
+		m.dbMock.ExpectQuery(peekQuery(d1, ` WHERE (job_latest_state.job_id IS NULL OR job_latest_state.job_state = ANY($1)) AND jobs.custom_val = ANY($2) AND jobs.workspace_id = ANY($3)`, 4)).
+			WithArgs(`{"failed"}`, `{"MOCKDS"}`, `{"workspace"}`, 3).
+			WillReturnRows(sqlmock.NewRows(columns).
+				AddRow(1, jobUUID.String(), "user-1", []byte(`{"source_id":"src-1"}`), "MOCKDS", 2, now, now, "workspace", Failed.State, 3, now).
+				AddRow(2, uuid.Must(uuid.NewV4()).String(), "user-2", []byte(`{}`), "MOCKDS", 1, now, now, "workspace", nil, nil, nil))
+		m.dbMock.ExpectQuery(peekQuery(d2, ` WHERE (job_latest_state.job_id IS NULL OR job_latest_state.job_state = ANY($1)) AND jobs.custom_val = ANY($2) AND jobs.workspace_id = ANY($3)`, 4)).
+			WithArgs(`{"failed"}`, `{"MOCKDS"}`, `{"workspace"}`, 1).
+			WillReturnRows(sqlmock.NewRows(columns))
+
+		headers, err := m.jd.PeekJobs(GetQueryParamsT{
+			StateFilters:     []string{NotProcessed.State, Failed.State},
+			CustomValFilters: []string{"MOCKDS"},
+			WorkspaceFilters: []string{"workspace"},
+			JobCount:         3,
+		})
+		Expect(err).To(BeNil())
+		Expect(headers).To(Equal([]JobHeaderT{
+			{JobID: 1, UUID: jobUUID, UserID: "user-1", CustomVal: "MOCKDS", EventCount: 2, Parameters: []byte(`{"source_id":"src-1"}`), WorkspaceId: "workspace", CreatedAt: now, ExpireAt: now, JobState: Failed.State, AttemptNum: 3, RetryTime: now},
+			{JobID: 2, UUID: headers[1].UUID, UserID: "user-2", CustomVal: "MOCKDS", EventCount: 1, Parameters: []byte(`{}`), WorkspaceId: "workspace", CreatedAt: now, ExpireAt: now, JobState: NotProcessed.State},
+		}))
+		Expect(queries).NotTo(BeEmpty())
+		for _, query := range queries {
+			Expect(query).NotTo(ContainSubstring("event_payload"))
+		}
+	})
+
+	It("doesn't query the next dataset once the count is reached", func() {
+		m.dbMock.ExpectQuery(peekQuery(d1, ` WHERE ("jobs".parameters @> $1::jsonb)`, 2)).
+			WithArgs(`{"source_id":"src-1"}`, 1).
+			WillReturnRows(sqlmock.NewRows(columns).
+				AddRow(1, uuid.Must(uuid.NewV4()).String(), "user-1", []byte(`{"source_id":"src-1"}`), "MOCKDS", 1, now, now, "workspace", Succeeded.State, 1, now))
+
+		headers, err := m.jd.PeekJobs(GetQueryParamsT{ParameterFilters: []ParameterFilterT{{Name: "source_id", Value: "src-1"}}, JobCount: 1})
+		Expect(err).To(BeNil())
+		Expect(headers).To(HaveLen(1))
+		Expect(headers[0].JobState).To(Equal(Succeeded.State))
+	})
+
+	It("returns the query error", func() {
+		m.dbMock.ExpectQuery(peekQuery(d1, "", 1)).WithArgs(10).WillReturnError(errors.New("query failed"))
+
+		_, err := m.jd.PeekJobs(GetQueryParamsT{JobCount: 10})
+		Expect(err).To(MatchError("query failed"))
+	})
+})
+
+var _ = Describe("migration progress", func() {
+	initJobsDB()
+
+	var (
+		progressAtCopy     [][]MigrationProgress
+		initialBatchSize   int
+		initialStrategy    string
+		initialUseJoin     bool
+		now                = time.Now()
+		jobColumns         = []string{"job_id", "uuid", "user_id", "parameters", "custom_val", "event_payload", "event_count", "created_at", "expire_at", "workspace_id", "running_event_counts"}
+		processedJobColumn = append(jobColumns, "job_state", "attempt", "exec_time", "retry_time", "error_code", "error_response", "status_parameters")
+		d3                 = dataSetT{JobTable: "tt_jobs_1_1", JobStatusTable: "tt_job_status_1_1", Index: "1_1"}
+	)
+
+	m := newMockJobsDB()
+
+	BeforeEach(func() {
+		progressAtCopy = nil
+		//recording the progress as seen by an observer whenever it changes between the copy statements
+		m.onQuery = func(query string) {
+			if strings.HasPrefix(query, "COPY") {
+				progress := m.jd.GetMigrationProgress()
+				if len(progressAtCopy) == 0 || progress[0].RowsCopied != progressAtCopy[len(progressAtCopy)-1][0].RowsCopied {
+					progressAtCopy = append(progressAtCopy, progress)
+				}
+			}
+		}
+		m.jd.datasetList = []dataSetT{d1, d3, d2}
+		initialBatchSize, initialStrategy, initialUseJoin = migrationBatchSize, storeStrategy, useJoinForUnprocessed
+		migrationBatchSize, storeStrategy, useJoinForUnprocessed = 2, storeStrategyCopy, true
+	})
+
+	AfterEach(func() {
+		migrationBatchSize, storeStrategy, useJoinForUnprocessed = initialBatchSize, initialStrategy, initialUseJoin
+	})
+
+	It("advances the progress after every batch until all the jobs are copied", func() {
+		unprocessedRows := sqlmock.NewRows(jobColumns)
+		for jobID := 1; jobID <= 3; jobID++ {
+			unprocessedRows.AddRow(jobID, uuid.Must(uuid.NewV4()).String(), "user-1", []byte(`{}`), "MOCKDS", []byte(`{}`), 1, now, now, "workspace", jobID)
+		}
+		m.dbMock.ExpectQuery(`SELECT jobs.job_id, jobs.uuid, jobs.user_id, jobs.parameters, jobs.custom_val, jobs.event_payload, jobs.event_count, jobs.created_at, jobs.expire_at, jobs.workspace_id, sum(jobs.event_count) over (order by jobs.job_id asc) as running_event_counts FROM "tt_jobs_1" AS jobs LEFT JOIN "tt_job_status_1" AS job_status ON jobs.job_id=job_status.job_id WHERE job_status.job_id is NULL`).WillReturnRows(unprocessedRows)
+		m.dbMock.ExpectQuery(`SELECT jobs.job_id, jobs.uuid, jobs.user_id, jobs.parameters, jobs.custom_val, jobs.event_payload, jobs.event_count, jobs.created_at, jobs.expire_at, jobs.workspace_id, sum(jobs.event_count) over (order by jobs.job_id asc) as running_event_counts, job_latest_state.job_state, job_latest_state.attempt, job_latest_state.exec_time, job_latest_state.retry_time, job_latest_state.error_code, job_latest_state.error_response, job_latest_state.parameters FROM "tt_jobs_1" AS jobs, (SELECT job_id, job_state, attempt, exec_time, retry_time, error_code, error_response,parameters FROM "tt_job_status_1" WHERE id IN (SELECT MAX(id) from "tt_job_status_1" GROUP BY job_id) AND ((job_state='failed') OR (job_state='executing') OR (job_state='waiting') OR (job_state='waiting_retry') OR (job_state='migrating') OR (job_state='importing'))) AS job_latest_state WHERE jobs.job_id=job_latest_state.job_id`).WillReturnRows(sqlmock.NewRows(processedJobColumn))
+		for _, batchSize := range []int{2, 1} {
+			m.dbMock.ExpectBegin()
+			prepared := m.dbMock.ExpectPrepare(`COPY "tt_jobs_1_1" ("job_id", "uuid", "user_id", "custom_val", "parameters", "event_payload", "event_count", "created_at", "expire_at", "workspace_id") FROM STDIN`)
+			for i := 0; i < batchSize; i++ {
+				prepared.ExpectExec().WillReturnResult(sqlmock.NewResult(0, 1))
+			}
+			prepared.ExpectExec().WillReturnResult(sqlmock.NewResult(0, 0))
+			m.dbMock.ExpectCommit()
+		}
+
+		Expect(m.jd.GetMigrationProgress()).To(BeEmpty())
+		migrated, err := m.jd.migrateJobs(d1, d3)
+		Expect(err).To(BeNil())
+		Expect(migrated).To(Equal(3))
+
+		Expect(progressAtCopy).To(HaveLen(2))
+		for i, rowsCopied := range []int{0, 2} {
+			Expect(progressAtCopy[i]).To(HaveLen(1))
+			Expect(progressAtCopy[i][0].RowsCopied).To(Equal(rowsCopied))
+			Expect(progressAtCopy[i][0].TotalRows).To(Equal(3))
+		}
+		progress := m.jd.GetMigrationProgress()
+		Expect(progress).To(HaveLen(1))
+		Expect(progress[0].SourceDS).To(Equal(d1))
+		Expect(progress[0].DestDS).To(Equal(d3))
+		Expect(progress[0].RowsCopied).To(Equal(3))
+		Expect(progress[0].TotalRows).To(Equal(3))
+		Expect(progress[0].StartTime).NotTo(BeZero())
+	})
+
+	It("keeps the progress of every dataset of a migration, dropping the previous migration's", func() {
+		d4 := dataSetT{JobTable: "tt_jobs_2_1", JobStatusTable: "tt_job_status_2_1", Index: "2_1"}
+		m.jd.startMigrationProgress(d1, d3, 0)
+		m.jd.startMigrationProgress(d2, d3, 5)
+		m.jd.advanceMigrationProgress(5)
+		Expect(m.jd.GetMigrationProgress()).To(HaveLen(2))
+
+		m.jd.startMigrationProgress(d3, d4, 10)
+		progress := m.jd.GetMigrationProgress()
+		Expect(progress).To(HaveLen(1))
+		Expect(progress[0].SourceDS).To(Equal(d3))
+		Expect(progress[0].RowsCopied).To(Equal(0))
+	})
+})
+
+var _ = Describe("UpsertJobStatus", func() {
+	initJobsDB()
+
+	var now = time.Now()
+
+	m := newMockJobsDB()
+
+	upsertQuery := `INSERT INTO "tt_job_latest_status" (job_id, job_state, attempt, exec_time, retry_time, error_code, error_response, parameters, workspace_id, abort_reason) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10) ON CONFLICT (job_id) DO UPDATE SET job_state = EXCLUDED.job_state, attempt = EXCLUDED.attempt, exec_time = EXCLUDED.exec_time, retry_time = EXCLUDED.retry_time, error_code = EXCLUDED.error_code, error_response = EXCLUDED.error_response, parameters = EXCLUDED.parameters, workspace_id = EXCLUDED.workspace_id, abort_reason = EXCLUDED.abort_reason`
+	status := func(jobID int64, state string) *JobStatusT {
+		return &JobStatusT{JobID: jobID, JobState: state, AttemptNum: 1, ExecTime: now, RetryTime: now, ErrorCode: "500", ErrorResponse: []byte(`{}`), Parameters: []byte(`{}`), WorkspaceId: "workspace"}
+	}
+
+	BeforeEach(func() {
+		m.jd.upsertJobStatus = true
+	})
+
+	It("fails if upserts are disabled", func() {
+		m.jd.upsertJobStatus = false
+		err := m.jd.UpsertJobStatus([]*JobStatusT{status(1, Failed.State)})
+		Expect(err).To(Equal(ErrUpsertJobStatusDisabled))
+	})
+
+	It("upserts the statuses of new and existing jobs by job id", func() {
+		m.dbMock.ExpectBegin()
+		prepare := m.dbMock.ExpectPrepare(upsertQuery)
+		prepare.ExpectExec().WithArgs(1, Failed.State, 1, now, now, "500", `{}`, `{}`, "workspace", "").WillReturnResult(sqlmock.NewResult(0, 1))
+		prepare.ExpectExec().WithArgs(1, Aborted.State, 1, now, now, "500", `{}`, `{}`, "workspace", "").WillReturnResult(sqlmock.NewResult(0, 1))
+		m.dbMock.ExpectCommit()
+
+		aborted := status(1, Aborted.State)
+		err := m.jd.UpsertJobStatus([]*JobStatusT{status(1, Failed.State), aborted})
+		Expect(err).To(BeNil())
+	})
+
+	It("rolls back if an upsert fails", func() {
+		m.dbMock.ExpectBegin()
+		m.dbMock.ExpectPrepare(upsertQuery).ExpectExec().WillReturnError(errors.New("exec failed"))
+		m.dbMock.ExpectRollback()
+
+		err := m.jd.UpsertJobStatus([]*JobStatusT{status(1, Failed.State)})
+		Expect(errors.Is(err, ErrUpdateJobStatusExecFailed)).To(BeTrue())
+	})
+})
+
+var _ = Describe("dataSetMigrated", func() {
+	initJobsDB()
+
+	var (
+		jd       *HandleT
+		recorder *tagsRecordingStats
+	)
+
+	BeforeEach(func() {
+		stats.Setup()
+		recorder = &tagsRecordingStats{Stats: stats.DefaultStats}
+		stats.DefaultStats = recorder
+		jd = &HandleT{tablePrefix: "tt", logger: pkgLogger}
+	})
+
+	AfterEach(func() {
+		stats.DefaultStats = recorder.Stats
+	})
+
+	It("emits the migration stat without a hook", func() {
+		jd.dataSetMigrated(internalMigration, d1, d2, 10)
+
+		Expect(recorder.tagsOf("jobsdb_migration")).To(Equal(stats.Tags{"tablePrefix": "tt", "type": internalMigration}))
+	})
+
+	It("calls the hook with the migrated datasets and job count", func() {
+		var from, to DataSetInfo
+		var jobCount int
+		jd.OnDataSetMigration = func(f, t DataSetInfo, count int) {
+			from, to, jobCount = f, t, count
+		}
+
+		jd.dataSetMigrated(importMigration, dataSetT{}, d2, 5)
+
+		Expect(from).To(Equal(DataSetInfo{}))
+		Expect(to).To(Equal(DataSetInfo{JobTable: "tt_jobs_2", JobStatusTable: "tt_job_status_2"}))
+		Expect(jobCount).To(Equal(5))
+		Expect(recorder.tagsOf("jobsdb_migration")).To(HaveKeyWithValue("type", importMigration))
+	})
+})
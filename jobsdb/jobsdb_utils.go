@@ -1,7 +1,10 @@
 package jobsdb
 
 import (
+	"bytes"
+	"context"
 	"database/sql"
+	"encoding/json"
 	"fmt"
 	"sort"
 	"strconv"
@@ -16,10 +19,12 @@ Function to return an ordered list of datasets and datasetRanges
 Most callers use the in-memory list of dataset and datasetRanges
 */
 func getDSList(jd AssertInterface, dbHandle *sql.DB, tablePrefix string) []dataSetT {
-	datasetList := []dataSetT{}
-
 	//Read the table names from PG
-	tableNames := getAllTableNames(jd, dbHandle)
+	return getDSListFromTableNames(jd, getAllTableNames(jd, dbHandle), tablePrefix)
+}
+
+func getDSListFromTableNames(jd AssertInterface, tableNames []string, tablePrefix string) []dataSetT {
+	datasetList := []dataSetT{}
 
 	//Tables are of form jobs_ and job_status_. Iterate
 	//through them and sort them to produce and
@@ -78,6 +83,17 @@ func getDSList(jd AssertInterface, dbHandle *sql.DB, tablePrefix string) []dataS
 	return datasetList
 }
 
+//getPayloadDSIndexes returns the indexes of the datasets which have a payload table, i.e. whose payloads are stored separately from their jobs
+func getPayloadDSIndexes(tableNames []string, tablePrefix string) map[string]bool {
+	payloadDSIndexes := map[string]bool{}
+	for _, t := range tableNames {
+		if strings.HasPrefix(t, tablePrefix+"_job_payloads_") {
+			payloadDSIndexes[t[len(tablePrefix+"_job_payloads_"):]] = true
+		}
+	}
+	return payloadDSIndexes
+}
+
 /*sortDnumList Function to sort table suffixes. We should not have any use case
 for having > 2 len suffixes (e.g. 1_1_1 - see comment below)
 but this sort handles the general case
@@ -139,27 +155,32 @@ var dsComparitor = func(src, dst []string) (bool, error) {
 
 //getAllTableNames Function to get all table names form Postgres
 func getAllTableNames(jd AssertInterface, dbHandle *sql.DB) []string {
-	//Read the table names from PG
-	stmt, err := dbHandle.Prepare(`SELECT tablename
+	tableNames, err := queryAllTableNames(context.Background(), dbHandle)
+	jd.assertError(err)
+	return tableNames
+}
+
+//queryAllTableNames reads the table names from PG within the deadline of ctx
+func queryAllTableNames(ctx context.Context, dbHandle *sql.DB) ([]string, error) {
+	//Not prepared, so that no statement is left open if the query times out
+	rows, err := dbHandle.QueryContext(ctx, `SELECT tablename
                                         FROM pg_catalog.pg_tables
                                         WHERE schemaname != 'pg_catalog' AND
                                         schemaname != 'information_schema'`)
-	jd.assertError(err)
-	defer stmt.Close()
-
-	rows, err := stmt.Query()
-	jd.assertError(err)
+	if err != nil {
+		return nil, err
+	}
 	defer rows.Close()
 
 	tableNames := []string{}
 	for rows.Next() {
 		var tbName string
-		err = rows.Scan(&tbName)
-		jd.assertError(err)
+		if err := rows.Scan(&tbName); err != nil {
+			return nil, err
+		}
 		tableNames = append(tableNames, tbName)
 	}
-
-	return tableNames
+	return tableNames, rows.Err()
 }
 
 //checkValidJobState Function to check validity of states
@@ -175,6 +196,15 @@ func checkValidJobState(jd AssertInterface, stateFilters []string) {
 	}
 }
 
+//checkValidParametersContains asserts that the containment filter, if set, is a valid json object
+func checkValidParametersContains(jd AssertInterface, parametersContains json.RawMessage) {
+	if len(parametersContains) == 0 {
+		return
+	}
+	jd.assert(json.Valid(parametersContains) && bytes.HasPrefix(bytes.TrimSpace(parametersContains), []byte("{")),
+		fmt.Sprintf("parametersContains %s is not a valid json object", string(parametersContains)))
+}
+
 //constructQuery construct and return query
 func constructQuery(jd AssertInterface, paramKey string, paramList []string, queryType string) string {
 	jd.assert(queryType == "OR" || queryType == "AND", fmt.Sprintf("queryType:%s is neither OR nor AND", queryType))
@@ -226,6 +256,65 @@ func constructParameterJSONQuery(table string, parameterFilters []ParameterFilte
 	return fmt.Sprintf(`(%s.parameters @> '{%s}' %s)`, table, strings.Join(allKeyValues, ","), opQuery)
 }
 
+//parameterFilterNames are the job parameters which can be used in parameter filters
+var parameterFilterNames = map[string]struct{}{
+	"source_id":         {},
+	"workspace_id":      {},
+	"destination_id":    {},
+	"source_job_run_id": {},
+}
+
+//NewParameterFilter returns a parameter filter, after validating that its name is one of the known job parameters
+func NewParameterFilter(name, value string, optional bool) (ParameterFilterT, error) {
+	if _, ok := parameterFilterNames[name]; !ok {
+		return ParameterFilterT{}, fmt.Errorf("%w: %q", ErrInvalidParameterFilter, name)
+	}
+	return ParameterFilterT{Name: name, Value: value, Optional: optional}, nil
+}
+
+//constructParameterJSONQueryWithArgs is the same as constructParameterJSONQuery, but binds the filter names and values as args,
+//numbering the placeholders starting from argOffset+1
+//eg. ("jobs".parameters @> $1::jsonb OR ("jobs".parameters @> $2::jsonb AND "jobs".parameters -> $3 IS NULL))
+func constructParameterJSONQueryWithArgs(table string, parameterFilters []ParameterFilterT, argOffset int) (string, []interface{}, error) {
+	allKeyValues := map[string]string{}
+	mandatoryKeyValues := map[string]string{}
+	var opNames []string
+	for _, parameter := range parameterFilters {
+		if _, ok := parameterFilterNames[parameter.Name]; !ok {
+			return "", nil, fmt.Errorf("%w: %q", ErrInvalidParameterFilter, parameter.Name)
+		}
+		allKeyValues[parameter.Name] = parameter.Value
+		if parameter.Optional {
+			opNames = append(opNames, parameter.Name)
+		} else {
+			mandatoryKeyValues[parameter.Name] = parameter.Value
+		}
+	}
+
+	var args []interface{}
+	placeholder := func(arg interface{}) string {
+		args = append(args, arg)
+		return fmt.Sprintf("$%d", argOffset+len(args))
+	}
+	allJSON, err := json.Marshal(allKeyValues)
+	if err != nil {
+		return "", nil, err
+	}
+	query := fmt.Sprintf(`"%s".parameters @> %s::jsonb`, table, placeholder(string(allJSON)))
+	if len(opNames) > 0 {
+		mandatoryJSON, err := json.Marshal(mandatoryKeyValues)
+		if err != nil {
+			return "", nil, err
+		}
+		opConditions := []string{fmt.Sprintf(`"%s".parameters @> %s::jsonb`, table, placeholder(string(mandatoryJSON)))}
+		for _, name := range opNames {
+			opConditions = append(opConditions, fmt.Sprintf(`"%s".parameters -> %s IS NULL`, table, placeholder(name)))
+		}
+		query += fmt.Sprintf(` OR (%s)`, strings.Join(opConditions, " AND "))
+	}
+	return "(" + query + ")", args, nil
+}
+
 //Admin Handlers
 type JobsdbUtilsHandler struct {
 }
@@ -284,13 +373,42 @@ func (jd *HandleT) getTimerStat(stat string, tags StatTagsT) stats.RudderStats {
 		timingTags[paramTag.Name] = paramTag.Value
 	}
 
+	if tags.WorkspaceID != "" {
+		timingTags["workspace"] = tags.WorkspaceID
+	}
+
 	return stats.NewTaggedStat(stat, stats.TimerType, timingTags)
 }
 
-func (jd *HandleT) storeTimerStat(stat string) stats.RudderStats {
+//storeTimerStat tags the store timers with the workspace of the jobs, if they all belong to the same one
+func (jd *HandleT) storeTimerStat(stat string, jobList []*JobT) stats.RudderStats {
 	timingTags := stats.Tags{
 		"tablePrefix": jd.tablePrefix,
 	}
+	if workspaceID := jd.storeStatWorkspaceID(jobList); workspaceID != "" {
+		timingTags["workspace"] = workspaceID
+	}
 	timingStat := stats.NewTaggedStat(stat, stats.TimerType, timingTags)
 	return timingStat
 }
+
+//statWorkspaceID returns the workspace tag for the query timers, falling back to the handle's default workspace when no hint is given
+func (jd *HandleT) statWorkspaceID(hint string) string {
+	if hint != "" {
+		return hint
+	}
+	return jd.defaultWorkspaceID
+}
+
+//storeStatWorkspaceID returns the workspace tag for the store timers.
+//Batches mixing workspaces are not tagged, since there is no single workspace to attribute them to
+func (jd *HandleT) storeStatWorkspaceID(jobList []*JobT) string {
+	var workspaceID string
+	for i, job := range jobList {
+		if i > 0 && job.WorkspaceId != workspaceID {
+			return ""
+		}
+		workspaceID = job.WorkspaceId
+	}
+	return jd.statWorkspaceID(workspaceID)
+}
@@ -0,0 +1,152 @@
+package jobsdb
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+/*
+QueryBuilder builds the GetQueryParamsT of a query, validating them in Build.
+It is the recommended way of making query params, since unlike the raw struct it doesn't allow forgetting the limit,
+e.g.
+
+	params, err := jobsdb.NewQuery().CustomVals("GA").States(jobsdb.Failed.State).Limit(100).Build()
+*/
+type QueryBuilder struct {
+	params GetQueryParamsT
+}
+
+//NewQuery returns an empty QueryBuilder
+func NewQuery() *QueryBuilder {
+	return &QueryBuilder{}
+}
+
+//CustomVals limits the jobs to the given custom vals, see GetQueryParamsT.CustomValFilters
+func (b *QueryBuilder) CustomVals(customVals ...string) *QueryBuilder {
+	b.params.CustomValFilters = append(b.params.CustomValFilters, customVals...)
+	return b
+}
+
+//IgnoreCustomValsInQuery keeps the custom vals for the cache and the stats only, see GetQueryParamsT.IgnoreCustomValFiltersInQuery
+func (b *QueryBuilder) IgnoreCustomValsInQuery() *QueryBuilder {
+	b.params.IgnoreCustomValFiltersInQuery = true
+	return b
+}
+
+//SplitByCustomVal splits the limits across the custom vals, see GetQueryParamsT.SplitByCustomVal
+func (b *QueryBuilder) SplitByCustomVal() *QueryBuilder {
+	b.params.SplitByCustomVal = true
+	return b
+}
+
+//Parameters limits the jobs to the ones matching the parameter filters, see GetQueryParamsT.ParameterFilters
+func (b *QueryBuilder) Parameters(filters ...ParameterFilterT) *QueryBuilder {
+	b.params.ParameterFilters = append(b.params.ParameterFilters, filters...)
+	return b
+}
+
+//ParametersContains limits the jobs to the ones whose parameters contain doc, see GetQueryParamsT.ParametersContains
+func (b *QueryBuilder) ParametersContains(doc json.RawMessage) *QueryBuilder {
+	b.params.ParametersContains = doc
+	return b
+}
+
+//States limits the jobs to the ones in the given states, see GetQueryParamsT.StateFilters
+func (b *QueryBuilder) States(states ...string) *QueryBuilder {
+	b.params.StateFilters = append(b.params.StateFilters, states...)
+	return b
+}
+
+//Limit sets the maximum number of jobs returned. It is mandatory
+func (b *QueryBuilder) Limit(jobCount int) *QueryBuilder {
+	b.params.JobCount = jobCount
+	return b
+}
+
+//EventLimit further limits the jobs returned by the number of their events, see GetQueryParamsT.EventCount
+func (b *QueryBuilder) EventLimit(eventCount int) *QueryBuilder {
+	b.params.EventCount = eventCount
+	return b
+}
+
+//Before limits the jobs to the ones created before t
+func (b *QueryBuilder) Before(t time.Time) *QueryBuilder {
+	b.params.UseTimeFilter = true
+	b.params.Before = t
+	return b
+}
+
+//AttemptRange limits the processed jobs to the ones whose latest attempt is within min and max (inclusive), zero meaning unbounded
+func (b *QueryBuilder) AttemptRange(min, max int) *QueryBuilder {
+	b.params.MinAttemptNum = min
+	b.params.MaxAttemptNum = max
+	return b
+}
+
+//MaxAttempts excludes the processed jobs which have exhausted their retries, see GetQueryParamsT.MaxAttempts
+func (b *QueryBuilder) MaxAttempts(maxAttempts int) *QueryBuilder {
+	b.params.MaxAttempts = maxAttempts
+	return b
+}
+
+//Workspaces limits the jobs to the given workspaces, see GetQueryParamsT.WorkspaceFilters
+func (b *QueryBuilder) Workspaces(workspaceIDs ...string) *QueryBuilder {
+	b.params.WorkspaceFilters = append(b.params.WorkspaceFilters, workspaceIDs...)
+	return b
+}
+
+//WorkspaceID sets the workspace tag of the query timers, see GetQueryParamsT.WorkspaceID
+func (b *QueryBuilder) WorkspaceID(workspaceID string) *QueryBuilder {
+	b.params.WorkspaceID = workspaceID
+	return b
+}
+
+//SkipPayload leaves the payloads of the jobs empty, see GetQueryParamsT.SkipPayload
+func (b *QueryBuilder) SkipPayload() *QueryBuilder {
+	b.params.SkipPayload = true
+	return b
+}
+
+/*
+Build returns the query params, or an ErrInvalidQuery error if
+the limit isn't set, any of the limits or attempt bounds is negative, the attempt range is inverted,
+ParametersContains isn't a json object, or custom vals are both split and ignored in the query.
+*/
+func (b *QueryBuilder) Build() (GetQueryParamsT, error) {
+	params := b.params
+	if params.JobCount <= 0 {
+		return GetQueryParamsT{}, fmt.Errorf("%w: limit must be positive, got %d", ErrInvalidQuery, params.JobCount)
+	}
+	if params.EventCount < 0 {
+		return GetQueryParamsT{}, fmt.Errorf("%w: event limit can't be negative, got %d", ErrInvalidQuery, params.EventCount)
+	}
+	if params.MinAttemptNum < 0 || params.MaxAttemptNum < 0 {
+		return GetQueryParamsT{}, fmt.Errorf("%w: attempt bounds can't be negative, min: %d, max: %d", ErrInvalidQuery, params.MinAttemptNum, params.MaxAttemptNum)
+	}
+	if params.MaxAttemptNum != 0 && params.MinAttemptNum > params.MaxAttemptNum {
+		return GetQueryParamsT{}, fmt.Errorf("%w: min attempt %d is greater than max attempt %d", ErrInvalidQuery, params.MinAttemptNum, params.MaxAttemptNum)
+	}
+	if params.MaxAttempts < 0 {
+		return GetQueryParamsT{}, fmt.Errorf("%w: max attempts can't be negative, got %d", ErrInvalidQuery, params.MaxAttempts)
+	}
+	if len(params.ParametersContains) > 0 &&
+		!(json.Valid(params.ParametersContains) && bytes.HasPrefix(bytes.TrimSpace(params.ParametersContains), []byte("{"))) {
+		return GetQueryParamsT{}, fmt.Errorf("%w: parameters contains %s is not a json object", ErrInvalidQuery, string(params.ParametersContains))
+	}
+	//Splitting needs the custom vals to be queried one by one
+	if params.SplitByCustomVal && params.IgnoreCustomValFiltersInQuery {
+		return GetQueryParamsT{}, fmt.Errorf("%w: custom vals can't be both split and ignored in the query", ErrInvalidQuery)
+	}
+	return params, nil
+}
+
+//MustBuild is like Build, but panics if the query params are invalid
+func (b *QueryBuilder) MustBuild() GetQueryParamsT {
+	params, err := b.Build()
+	if err != nil {
+		panic(err)
+	}
+	return params
+}
@@ -0,0 +1,64 @@
+package jobsdb
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("QueryBuilder", func() {
+	It("builds the query params", func() {
+		before := time.Now()
+		params, err := NewQuery().
+			CustomVals("GA", "AM").
+			States(Failed.State, Waiting.State).
+			Parameters(ParameterFilterT{Name: "destination_id", Value: "dst-1"}).
+			ParametersContains(json.RawMessage(`{"source_id":"src-1"}`)).
+			Limit(100).
+			EventLimit(1000).
+			Before(before).
+			AttemptRange(1, 3).
+			MaxAttempts(5).
+			Workspaces("workspace-1").
+			WorkspaceID("workspace-1").
+			SplitByCustomVal().
+			Build()
+		Expect(err).To(BeNil())
+		Expect(params).To(Equal(GetQueryParamsT{
+			CustomValFilters:   []string{"GA", "AM"},
+			StateFilters:       []string{Failed.State, Waiting.State},
+			ParameterFilters:   []ParameterFilterT{{Name: "destination_id", Value: "dst-1"}},
+			ParametersContains: json.RawMessage(`{"source_id":"src-1"}`),
+			JobCount:           100,
+			EventCount:         1000,
+			UseTimeFilter:      true,
+			Before:             before,
+			MinAttemptNum:      1,
+			MaxAttemptNum:      3,
+			MaxAttempts:        5,
+			WorkspaceFilters:   []string{"workspace-1"},
+			WorkspaceID:        "workspace-1",
+			SplitByCustomVal:   true,
+		}))
+	})
+
+	DescribeTable("rejects invalid query params",
+		func(builder *QueryBuilder) {
+			_, err := builder.Build()
+			Expect(errors.Is(err, ErrInvalidQuery)).To(BeTrue())
+			Expect(func() { builder.MustBuild() }).To(Panic())
+		},
+		Entry("missing limit", NewQuery().CustomVals("GA")),
+		Entry("negative limit", NewQuery().Limit(-1)),
+		Entry("negative event limit", NewQuery().Limit(10).EventLimit(-1)),
+		Entry("negative attempt bound", NewQuery().Limit(10).AttemptRange(-1, 0)),
+		Entry("inverted attempt range", NewQuery().Limit(10).AttemptRange(3, 1)),
+		Entry("negative max attempts", NewQuery().Limit(10).MaxAttempts(-1)),
+		Entry("invalid parameters containment", NewQuery().Limit(10).ParametersContains(json.RawMessage(`["source_id"]`))),
+		Entry("custom vals both split and ignored", NewQuery().Limit(10).CustomVals("GA", "AM").SplitByCustomVal().IgnoreCustomValsInQuery()),
+	)
+})
@@ -130,6 +130,17 @@ func (jd *ReadonlyHandleT) getDSList() []dataSetT {
 	return getDSList(jd, jd.DbHandle, jd.tablePrefix)
 }
 
+//payloadTable returns the payload table of the dataset if its payloads are stored separately from its jobs, see HandleT.payloadTable.
+//It is empty otherwise.
+func (jd *ReadonlyHandleT) payloadTable(ds dataSetT) (string, error) {
+	var exists bool
+	err := jd.DbHandle.QueryRow(`SELECT to_regclass($1) IS NOT NULL`, payloadTableName(ds)).Scan(&exists)
+	if err != nil || !exists {
+		return "", err
+	}
+	return payloadTableName(ds), nil
+}
+
 /*
 Count queries
 */
@@ -219,14 +230,22 @@ func (jd *ReadonlyHandleT) getUnprocessedJobsDSCount(ds dataSetT, customValFilte
 		return 0
 	}
 
-	var selectColumn string
+	var selectColumn, payloadJoin string
 	if jd.tablePrefix == "gw" {
-		selectColumn = "event_payload->'batch' as batch"
+		payloadTable, err := jd.payloadTable(ds)
+		if err != nil {
+			txn.Rollback()
+			jd.logger.Errorf("error looking up the payload table of ds(%v). Err: %w", ds, err)
+			return 0
+		}
+		var payloadColumn string
+		payloadColumn, payloadJoin = payloadColumnAndJoin(ds.JobTable, payloadTable)
+		selectColumn = payloadColumn + "->'batch' as batch"
 	} else {
 		selectColumn = "COUNT(*)"
 	}
-	sqlStatement = fmt.Sprintf(`SELECT %[3]s FROM %[1]s LEFT JOIN %[2]s ON %[1]s.job_id=%[2]s.job_id
-											 WHERE %[2]s.job_id is NULL`, ds.JobTable, ds.JobStatusTable, selectColumn)
+	sqlStatement = fmt.Sprintf(`SELECT %[3]s FROM %[1]s%[4]s LEFT JOIN %[2]s ON %[1]s.job_id=%[2]s.job_id
+											 WHERE %[2]s.job_id is NULL`, ds.JobTable, ds.JobStatusTable, selectColumn, payloadJoin)
 
 	if len(customValFilters) > 0 {
 		sqlStatement += " AND " + constructQuery(jd, fmt.Sprintf("%s.custom_val", ds.JobTable),
@@ -361,21 +380,29 @@ func (jd *ReadonlyHandleT) getProcessedJobsDSCount(ds dataSetT, stateFilters []s
 		return 0
 	}
 
-	var selectColumn string
+	var selectColumn, payloadJoin string
 	if jd.tablePrefix == "gw" {
-		selectColumn = fmt.Sprintf("%[1]s.event_payload->'batch' as batch", ds.JobTable)
+		payloadTable, err := jd.payloadTable(ds)
+		if err != nil {
+			txn.Rollback()
+			jd.logger.Errorf("error looking up the payload table of ds(%v). Err: %w", ds, err)
+			return 0
+		}
+		var payloadColumn string
+		payloadColumn, payloadJoin = payloadColumnAndJoin(ds.JobTable, payloadTable)
+		selectColumn = payloadColumn + "->'batch' as batch"
 	} else {
 		selectColumn = fmt.Sprintf("COUNT(%[1]s.job_id)", ds.JobTable)
 	}
 	sqlStatement = fmt.Sprintf(`SELECT %[6]s FROM
-                                               %[1]s,
+                                               %[1]s%[7]s,
                                                (SELECT job_id, retry_time FROM %[2]s WHERE id IN
                                                    (SELECT MAX(id) from %[2]s GROUP BY job_id) %[3]s)
                                                AS job_latest_state
                                             WHERE %[1]s.job_id=job_latest_state.job_id
                                              %[4]s %[5]s
                                              AND job_latest_state.retry_time < $1`,
-		ds.JobTable, ds.JobStatusTable, stateQuery, customValQuery, sourceQuery, selectColumn)
+		ds.JobTable, ds.JobStatusTable, stateQuery, customValQuery, sourceQuery, selectColumn, payloadJoin)
 
 	if jd.tablePrefix == "gw" {
 		sqlStatement = fmt.Sprintf("select sum(jsonb_array_length(batch)) from (%s) t", sqlStatement)
@@ -589,21 +616,26 @@ func (jd *ReadonlyHandleT) GetJobByID(job_id string, prefix string) (string, err
 		if jobId < int(min.Int32) || jobId > int(max.Int32) {
 			continue
 		}
+		payloadTable, err := jd.payloadTable(dsPair)
+		if err != nil {
+			return "", err
+		}
+		payloadColumn, payloadJoin := payloadColumnAndJoin(dsPair.JobTable, payloadTable)
 		sqlStatement = fmt.Sprintf(`SELECT
-						%[1]s.job_id, %[1]s.uuid, %[1]s.user_id, %[1]s.parameters, %[1]s.custom_val, %[1]s.event_payload,
+						%[1]s.job_id, %[1]s.uuid, %[1]s.user_id, %[1]s.parameters, %[1]s.custom_val, %[4]s,
 						%[1]s.created_at, %[1]s.expire_at,
 						job_latest_state.job_state, job_latest_state.attempt,
 						job_latest_state.exec_time, job_latest_state.retry_time,
 						job_latest_state.error_code, job_latest_state.error_response
 					FROM
-						%[1]s
+						%[1]s%[5]s
 					LEFT JOIN
 						(SELECT job_id, job_state, attempt, exec_time, retry_time,
 						error_code, error_response FROM %[2]s WHERE id IN
 							(SELECT MAX(id) from %[2]s GROUP BY job_id))
 						AS job_latest_state
 					ON %[1]s.job_id=job_latest_state.job_id
-					WHERE %[1]s.job_id = %[3]s;`, dsPair.JobTable, dsPair.JobStatusTable, job_id)
+					WHERE %[1]s.job_id = %[3]s;`, dsPair.JobTable, dsPair.JobStatusTable, job_id, payloadColumn, payloadJoin)
 
 		event := JobT{}
 		row = jd.DbHandle.QueryRow(sqlStatement)
@@ -613,11 +645,11 @@ func (jd *ReadonlyHandleT) GetJobByID(job_id string, prefix string) (string, err
 			&event.LastJobStatus.ErrorResponse)
 		if err != nil {
 			sqlStatement = fmt.Sprintf(`SELECT
-						%[1]s.job_id, %[1]s.uuid, %[1]s.user_id, %[1]s.parameters, %[1]s.custom_val, %[1]s.event_payload,
+						%[1]s.job_id, %[1]s.uuid, %[1]s.user_id, %[1]s.parameters, %[1]s.custom_val, %[3]s,
 						%[1]s.created_at, %[1]s.expire_at
 					FROM
-						%[1]s
-					WHERE %[1]s.job_id = %[2]s;`, dsPair.JobTable, job_id)
+						%[1]s%[4]s
+					WHERE %[1]s.job_id = %[2]s;`, dsPair.JobTable, job_id, payloadColumn, payloadJoin)
 			row = jd.DbHandle.QueryRow(sqlStatement)
 			err1 := row.Scan(&event.JobID, &event.UUID, &event.UserID, &event.Parameters, &event.CustomVal, &event.EventPayload,
 				&event.CreatedAt, &event.ExpireAt)
@@ -56,6 +56,7 @@ func (jd *HandleT) dropDatabaseTables() {
 	jd.dropSchemaMigrationTables()
 	jd.dropAllDS()
 	jd.dropJournal()
+	jd.dropLatestStatusTable()
 	jd.dropAllBackupDS()
 	jd.dropMigrationCheckpointTables()
 }
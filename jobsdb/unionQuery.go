@@ -15,6 +15,11 @@ type MultiTenantHandleT struct {
 	*HandleT
 }
 
+var (
+	_ MultiTenantJobsDB = &MultiTenantHandleT{}
+	_ MultiTenantJobsDB = &MultiTenantLegacy{}
+)
+
 type JobsDBStatusCache struct {
 	once sync.Once
 	a    HandleT
@@ -37,6 +42,7 @@ type MultiTenantJobsDB interface {
 	JournalMarkStart(opType string, opPayload json.RawMessage) int64
 	JournalDeleteEntry(opID int64)
 	GetPileUpCounts(map[string]map[string]int)
+	GetOldestPendingPerCustomer(destType string) (map[string]time.Time, error)
 }
 
 func (mj *MultiTenantHandleT) GetPileUpCounts(statMap map[string]map[string]int) {
@@ -330,10 +336,11 @@ func (mj *MultiTenantHandleT) getInitialSingleWorkspaceQueryString(ds dataSetT,
 		sourceQuery = ""
 	}
 
+	payloadColumn, payloadJoin := mj.payloadSelect(ds, params.SkipPayload)
 	sqlStatement = fmt.Sprintf(`with rt_jobs_view AS (
 		                                    SELECT
                                                 jobs.job_id, jobs.uuid, jobs.user_id, jobs.parameters, jobs.custom_val,
-                                                jobs.event_payload, jobs.event_count, jobs.created_at,
+                                                %[8]s, jobs.event_count, jobs.created_at,
                                                 jobs.expire_at, jobs.workspace_id,
                                                 sum(jobs.event_count) over (
                                                     order by jobs.job_id asc
@@ -355,9 +362,9 @@ func (mj *MultiTenantHandleT) getInitialSingleWorkspaceQueryString(ds dataSetT,
                                                         from %[2]s
                                                         GROUP BY job_id
                                                         )
-                                                ) AS job_latest_state ON jobs.job_id = job_latest_state.job_id
+                                                ) AS job_latest_state ON jobs.job_id = job_latest_state.job_id%[9]s
                                             WHERE
                                                 jobs.workspace_id IN %[7]s %[3]s %[4]s %[5]s %[6]s`,
-		ds.JobTable, ds.JobStatusTable, stateQuery, customValQuery, sourceQuery, limitQuery, workspaceString)
+		ds.JobTable, ds.JobStatusTable, stateQuery, customValQuery, sourceQuery, limitQuery, workspaceString, payloadColumn, payloadJoin)
 	return sqlStatement + ")"
 }
@@ -1,16 +1,36 @@
 package jobsdb
 
+import (
+	"strings"
+	"sync"
+
+	"github.com/rudderlabs/rudder-server/utils/misc"
+)
+
 type MultiTenantLegacy struct {
 	*HandleT
+	queryLimitsLock sync.Mutex
+	queryLimits     map[string]int //the number of jobs read by GetAllJobs per custom val filters, which backs off after a query timeout
 }
 
 func (mj *MultiTenantLegacy) GetAllJobs(workspaceCount map[string]int, params GetQueryParamsT, maxDSQuerySize int) []*JobT {
-	toQuery := workspaceCount["0"]
-	retryList := mj.GetToRetry(GetQueryParamsT{CustomValFilters: params.CustomValFilters, JobCount: toQuery})
-	toQuery -= len(retryList)
-	waitList := mj.GetWaiting(GetQueryParamsT{CustomValFilters: params.CustomValFilters, JobCount: toQuery})
-	toQuery -= len(waitList)
-	unprocessedList := mj.GetUnprocessed(GetQueryParamsT{CustomValFilters: params.CustomValFilters, JobCount: toQuery})
+	maxLimit := workspaceCount["0"]
+	limit := mj.getQueryLimit(params.CustomValFilters, maxLimit)
+	toQuery := limit
+	retryList, err := mj.GetToRetry(GetQueryParamsT{CustomValFilters: params.CustomValFilters, JobCount: toQuery})
+	var waitList, unprocessedList []*JobT
+	if err == nil {
+		toQuery -= len(retryList)
+		waitList = mj.GetWaiting(GetQueryParamsT{CustomValFilters: params.CustomValFilters, JobCount: toQuery})
+		toQuery -= len(waitList)
+		unprocessedList, err = mj.GetUnprocessed(GetQueryParamsT{CustomValFilters: params.CustomValFilters, JobCount: toQuery})
+	}
+	mj.setQueryLimit(params.CustomValFilters, NextQueryLimit(limit, maxLimit, err), maxLimit)
+	//The jobs are read again next time after a failed read, with fewer jobs if it timed out
+	if err != nil {
+		mj.logger.Errorf("[[ %s ]] Failed to read the jobs of %v: %v", mj.tablePrefix, params.CustomValFilters, err)
+		return []*JobT{}
+	}
 
 	var list []*JobT
 	list = append(list, retryList...)
@@ -20,6 +40,31 @@ func (mj *MultiTenantLegacy) GetAllJobs(workspaceCount map[string]int, params Ge
 	return list
 }
 
+//getQueryLimit returns the number of jobs GetAllJobs reads for the custom val filters, at most maxLimit
+func (mj *MultiTenantLegacy) getQueryLimit(customValFilters []string, maxLimit int) int {
+	mj.queryLimitsLock.Lock()
+	defer mj.queryLimitsLock.Unlock()
+	if limit, ok := mj.queryLimits[strings.Join(customValFilters, ",")]; ok {
+		return misc.MinInt(limit, maxLimit)
+	}
+	return maxLimit
+}
+
+//setQueryLimit sets the number of jobs GetAllJobs reads next for the custom val filters, which is forgotten once it is back up to maxLimit
+func (mj *MultiTenantLegacy) setQueryLimit(customValFilters []string, limit int, maxLimit int) {
+	mj.queryLimitsLock.Lock()
+	defer mj.queryLimitsLock.Unlock()
+	key := strings.Join(customValFilters, ",")
+	if limit >= maxLimit {
+		delete(mj.queryLimits, key)
+		return
+	}
+	if mj.queryLimits == nil {
+		mj.queryLimits = make(map[string]int)
+	}
+	mj.queryLimits[key] = limit
+}
+
 func (mj *MultiTenantLegacy) GetPileUpCounts(statMap map[string]map[string]int) {
 }
 
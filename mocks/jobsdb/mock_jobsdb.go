@@ -8,6 +8,7 @@ import (
 	sql "database/sql"
 	json "encoding/json"
 	reflect "reflect"
+	time "time"
 
 	uuid "github.com/gofrs/uuid"
 	gomock "github.com/golang/mock/gomock"
@@ -127,6 +128,20 @@ func (mr *MockJobsDBMockRecorder) GetExecuting(arg0 interface{}) *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetExecuting", reflect.TypeOf((*MockJobsDB)(nil).GetExecuting), arg0)
 }
 
+// GetExhausted mocks base method.
+func (m *MockJobsDB) GetExhausted(arg0 jobsdb.GetQueryParamsT, arg1 int) []*jobsdb.JobT {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetExhausted", arg0, arg1)
+	ret0, _ := ret[0].([]*jobsdb.JobT)
+	return ret0
+}
+
+// GetExhausted indicates an expected call of GetExhausted.
+func (mr *MockJobsDBMockRecorder) GetExhausted(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetExhausted", reflect.TypeOf((*MockJobsDB)(nil).GetExhausted), arg0, arg1)
+}
+
 // GetIdentifier mocks base method.
 func (m *MockJobsDB) GetIdentifier() string {
 	m.ctrl.T.Helper()
@@ -155,6 +170,22 @@ func (mr *MockJobsDBMockRecorder) GetImportingList(arg0 interface{}) *gomock.Cal
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetImportingList", reflect.TypeOf((*MockJobsDB)(nil).GetImportingList), arg0)
 }
 
+// GetJobTimeRange mocks base method.
+func (m *MockJobsDB) GetJobTimeRange(arg0 string) (time.Time, time.Time, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetJobTimeRange", arg0)
+	ret0, _ := ret[0].(time.Time)
+	ret1, _ := ret[1].(time.Time)
+	ret2, _ := ret[2].(error)
+	return ret0, ret1, ret2
+}
+
+// GetJobTimeRange indicates an expected call of GetJobTimeRange.
+func (mr *MockJobsDBMockRecorder) GetJobTimeRange(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetJobTimeRange", reflect.TypeOf((*MockJobsDB)(nil).GetJobTimeRange), arg0)
+}
+
 // GetJournalEntries mocks base method.
 func (m *MockJobsDB) GetJournalEntries(arg0 string) []jobsdb.JournalEntryT {
 	m.ctrl.T.Helper()
@@ -169,6 +200,21 @@ func (mr *MockJobsDBMockRecorder) GetJournalEntries(arg0 interface{}) *gomock.Ca
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetJournalEntries", reflect.TypeOf((*MockJobsDB)(nil).GetJournalEntries), arg0)
 }
 
+// GetOldestPendingPerCustomer mocks base method.
+func (m *MockJobsDB) GetOldestPendingPerCustomer(arg0 string) (map[string]time.Time, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetOldestPendingPerCustomer", arg0)
+	ret0, _ := ret[0].(map[string]time.Time)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetOldestPendingPerCustomer indicates an expected call of GetOldestPendingPerCustomer.
+func (mr *MockJobsDBMockRecorder) GetOldestPendingPerCustomer(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetOldestPendingPerCustomer", reflect.TypeOf((*MockJobsDB)(nil).GetOldestPendingPerCustomer), arg0)
+}
+
 // GetPileUpCounts mocks base method.
 func (m *MockJobsDB) GetPileUpCounts(arg0 map[string]map[string]int) {
 	m.ctrl.T.Helper()
@@ -182,11 +228,12 @@ func (mr *MockJobsDBMockRecorder) GetPileUpCounts(arg0 interface{}) *gomock.Call
 }
 
 // GetProcessed mocks base method.
-func (m *MockJobsDB) GetProcessed(arg0 jobsdb.GetQueryParamsT) []*jobsdb.JobT {
+func (m *MockJobsDB) GetProcessed(arg0 jobsdb.GetQueryParamsT) ([]*jobsdb.JobT, error) {
 	m.ctrl.T.Helper()
 	ret := m.ctrl.Call(m, "GetProcessed", arg0)
 	ret0, _ := ret[0].([]*jobsdb.JobT)
-	return ret0
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
 }
 
 // GetProcessed indicates an expected call of GetProcessed.
@@ -195,12 +242,28 @@ func (mr *MockJobsDBMockRecorder) GetProcessed(arg0 interface{}) *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetProcessed", reflect.TypeOf((*MockJobsDB)(nil).GetProcessed), arg0)
 }
 
+// GetRetryPileUpCounts mocks base method.
+func (m *MockJobsDB) GetRetryPileUpCounts(arg0 []string) (map[string]map[string]int64, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetRetryPileUpCounts", arg0)
+	ret0, _ := ret[0].(map[string]map[string]int64)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetRetryPileUpCounts indicates an expected call of GetRetryPileUpCounts.
+func (mr *MockJobsDBMockRecorder) GetRetryPileUpCounts(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetRetryPileUpCounts", reflect.TypeOf((*MockJobsDB)(nil).GetRetryPileUpCounts), arg0)
+}
+
 // GetToRetry mocks base method.
-func (m *MockJobsDB) GetToRetry(arg0 jobsdb.GetQueryParamsT) []*jobsdb.JobT {
+func (m *MockJobsDB) GetToRetry(arg0 jobsdb.GetQueryParamsT) ([]*jobsdb.JobT, error) {
 	m.ctrl.T.Helper()
 	ret := m.ctrl.Call(m, "GetToRetry", arg0)
 	ret0, _ := ret[0].([]*jobsdb.JobT)
-	return ret0
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
 }
 
 // GetToRetry indicates an expected call of GetToRetry.
@@ -210,11 +273,12 @@ func (mr *MockJobsDBMockRecorder) GetToRetry(arg0 interface{}) *gomock.Call {
 }
 
 // GetUnprocessed mocks base method.
-func (m *MockJobsDB) GetUnprocessed(arg0 jobsdb.GetQueryParamsT) []*jobsdb.JobT {
+func (m *MockJobsDB) GetUnprocessed(arg0 jobsdb.GetQueryParamsT) ([]*jobsdb.JobT, error) {
 	m.ctrl.T.Helper()
 	ret := m.ctrl.Call(m, "GetUnprocessed", arg0)
 	ret0, _ := ret[0].([]*jobsdb.JobT)
-	return ret0
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
 }
 
 // GetUnprocessed indicates an expected call of GetUnprocessed.
@@ -263,6 +327,20 @@ func (mr *MockJobsDBMockRecorder) JournalMarkStart(arg0, arg1 interface{}) *gomo
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "JournalMarkStart", reflect.TypeOf((*MockJobsDB)(nil).JournalMarkStart), arg0, arg1)
 }
 
+// MarkWaiting mocks base method.
+func (m *MockJobsDB) MarkWaiting(arg0 []int64, arg1 time.Time, arg2 string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "MarkWaiting", arg0, arg1, arg2)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// MarkWaiting indicates an expected call of MarkWaiting.
+func (mr *MockJobsDBMockRecorder) MarkWaiting(arg0, arg1, arg2 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "MarkWaiting", reflect.TypeOf((*MockJobsDB)(nil).MarkWaiting), arg0, arg1, arg2)
+}
+
 // ReleaseStoreLock mocks base method.
 func (m *MockJobsDB) ReleaseStoreLock() {
 	m.ctrl.T.Helper()
@@ -8,6 +8,7 @@ import (
 	sql "database/sql"
 	json "encoding/json"
 	reflect "reflect"
+	time "time"
 
 	gomock "github.com/golang/mock/gomock"
 	jobsdb "github.com/rudderlabs/rudder-server/jobsdb"
@@ -114,6 +115,21 @@ func (mr *MockMultiTenantJobsDBMockRecorder) GetJournalEntries(arg0 interface{})
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetJournalEntries", reflect.TypeOf((*MockMultiTenantJobsDB)(nil).GetJournalEntries), arg0)
 }
 
+// GetOldestPendingPerCustomer mocks base method.
+func (m *MockMultiTenantJobsDB) GetOldestPendingPerCustomer(arg0 string) (map[string]time.Time, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetOldestPendingPerCustomer", arg0)
+	ret0, _ := ret[0].(map[string]time.Time)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetOldestPendingPerCustomer indicates an expected call of GetOldestPendingPerCustomer.
+func (mr *MockMultiTenantJobsDBMockRecorder) GetOldestPendingPerCustomer(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetOldestPendingPerCustomer", reflect.TypeOf((*MockMultiTenantJobsDB)(nil).GetOldestPendingPerCustomer), arg0)
+}
+
 // GetPileUpCounts mocks base method.
 func (m *MockMultiTenantJobsDB) GetPileUpCounts(arg0 map[string]map[string]int) {
 	m.ctrl.T.Helper()
@@ -35,6 +35,34 @@ func (m *MockTransformer) EXPECT() *MockTransformerMockRecorder {
 	return m.recorder
 }
 
+// DestinationTransform mocks base method.
+func (m *MockTransformer) DestinationTransform(arg0 context.Context, arg1 []transformer.TransformerEventT, arg2 string, arg3 int) transformer.ResponseT {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DestinationTransform", arg0, arg1, arg2, arg3)
+	ret0, _ := ret[0].(transformer.ResponseT)
+	return ret0
+}
+
+// DestinationTransform indicates an expected call of DestinationTransform.
+func (mr *MockTransformerMockRecorder) DestinationTransform(arg0, arg1, arg2, arg3 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DestinationTransform", reflect.TypeOf((*MockTransformer)(nil).DestinationTransform), arg0, arg1, arg2, arg3)
+}
+
+// Healthy mocks base method.
+func (m *MockTransformer) Healthy() bool {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Healthy")
+	ret0, _ := ret[0].(bool)
+	return ret0
+}
+
+// Healthy indicates an expected call of Healthy.
+func (mr *MockTransformerMockRecorder) Healthy() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Healthy", reflect.TypeOf((*MockTransformer)(nil).Healthy))
+}
+
 // Setup mocks base method.
 func (m *MockTransformer) Setup() {
 	m.ctrl.T.Helper()
@@ -61,6 +89,48 @@ func (mr *MockTransformerMockRecorder) Transform(arg0, arg1, arg2, arg3 interfac
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Transform", reflect.TypeOf((*MockTransformer)(nil).Transform), arg0, arg1, arg2, arg3)
 }
 
+// TransformMulti mocks base method.
+func (m *MockTransformer) TransformMulti(arg0 context.Context, arg1 []transformer.TransformerEventT, arg2 []string, arg3 int) transformer.ResponseT {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "TransformMulti", arg0, arg1, arg2, arg3)
+	ret0, _ := ret[0].(transformer.ResponseT)
+	return ret0
+}
+
+// TransformMulti indicates an expected call of TransformMulti.
+func (mr *MockTransformerMockRecorder) TransformMulti(arg0, arg1, arg2, arg3 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "TransformMulti", reflect.TypeOf((*MockTransformer)(nil).TransformMulti), arg0, arg1, arg2, arg3)
+}
+
+// TransformWithDeadline mocks base method.
+func (m *MockTransformer) TransformWithDeadline(arg0 context.Context, arg1 []transformer.TransformerEventT, arg2 string, arg3 int) transformer.ResponseT {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "TransformWithDeadline", arg0, arg1, arg2, arg3)
+	ret0, _ := ret[0].(transformer.ResponseT)
+	return ret0
+}
+
+// TransformWithDeadline indicates an expected call of TransformWithDeadline.
+func (mr *MockTransformerMockRecorder) TransformWithDeadline(arg0, arg1, arg2, arg3 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "TransformWithDeadline", reflect.TypeOf((*MockTransformer)(nil).TransformWithDeadline), arg0, arg1, arg2, arg3)
+}
+
+// UserTransform mocks base method.
+func (m *MockTransformer) UserTransform(arg0 context.Context, arg1 []transformer.TransformerEventT, arg2 int) transformer.ResponseT {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "UserTransform", arg0, arg1, arg2)
+	ret0, _ := ret[0].(transformer.ResponseT)
+	return ret0
+}
+
+// UserTransform indicates an expected call of UserTransform.
+func (mr *MockTransformerMockRecorder) UserTransform(arg0, arg1, arg2 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UserTransform", reflect.TypeOf((*MockTransformer)(nil).UserTransform), arg0, arg1, arg2)
+}
+
 // Validate mocks base method.
 func (m *MockTransformer) Validate(arg0 []transformer.TransformerEventT, arg1 string, arg2 int) transformer.ResponseT {
 	m.ctrl.T.Helper()
@@ -58,6 +58,35 @@ func (mr *MockMultiTenantIMockRecorder) CalculateSuccessFailureCounts(arg0, arg1
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CalculateSuccessFailureCounts", reflect.TypeOf((*MockMultiTenantI)(nil).CalculateSuccessFailureCounts), arg0, arg1, arg2, arg3)
 }
 
+// GetInMemoryJobCount mocks base method.
+func (m *MockMultiTenantI) GetInMemoryJobCount(arg0, arg1, arg2 string) int {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetInMemoryJobCount", arg0, arg1, arg2)
+	ret0, _ := ret[0].(int)
+	return ret0
+}
+
+// GetInMemoryJobCount indicates an expected call of GetInMemoryJobCount.
+func (mr *MockMultiTenantIMockRecorder) GetInMemoryJobCount(arg0, arg1, arg2 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetInMemoryJobCount", reflect.TypeOf((*MockMultiTenantI)(nil).GetInMemoryJobCount), arg0, arg1, arg2)
+}
+
+// GetPickupJobs mocks base method.
+func (m *MockMultiTenantI) GetPickupJobs(arg0, arg1 string, arg2 int, arg3 time.Duration, arg4 int, arg5 float64) (map[string]int, map[string]float64) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetPickupJobs", arg0, arg1, arg2, arg3, arg4, arg5)
+	ret0, _ := ret[0].(map[string]int)
+	ret1, _ := ret[1].(map[string]float64)
+	return ret0, ret1
+}
+
+// GetPickupJobs indicates an expected call of GetPickupJobs.
+func (mr *MockMultiTenantIMockRecorder) GetPickupJobs(arg0, arg1, arg2, arg3, arg4, arg5 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetPickupJobs", reflect.TypeOf((*MockMultiTenantI)(nil).GetPickupJobs), arg0, arg1, arg2, arg3, arg4, arg5)
+}
+
 // GetRouterPickupJobs mocks base method.
 func (m *MockMultiTenantI) GetRouterPickupJobs(arg0 string, arg1 int, arg2 time.Duration, arg3 int, arg4 float64) (map[string]int, map[string]float64) {
 	m.ctrl.T.Helper()
@@ -73,6 +102,35 @@ func (mr *MockMultiTenantIMockRecorder) GetRouterPickupJobs(arg0, arg1, arg2, ar
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetRouterPickupJobs", reflect.TypeOf((*MockMultiTenantI)(nil).GetRouterPickupJobs), arg0, arg1, arg2, arg3, arg4)
 }
 
+// IsCustomerHealthy mocks base method.
+func (m *MockMultiTenantI) IsCustomerHealthy(arg0, arg1 string) (bool, float64) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "IsCustomerHealthy", arg0, arg1)
+	ret0, _ := ret[0].(bool)
+	ret1, _ := ret[1].(float64)
+	return ret0, ret1
+}
+
+// IsCustomerHealthy indicates an expected call of IsCustomerHealthy.
+func (mr *MockMultiTenantIMockRecorder) IsCustomerHealthy(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "IsCustomerHealthy", reflect.TypeOf((*MockMultiTenantI)(nil).IsCustomerHealthy), arg0, arg1)
+}
+
+// PeekSuccessRateMap mocks base method.
+func (m *MockMultiTenantI) PeekSuccessRateMap(arg0 string) map[string]float64 {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "PeekSuccessRateMap", arg0)
+	ret0, _ := ret[0].(map[string]float64)
+	return ret0
+}
+
+// PeekSuccessRateMap indicates an expected call of PeekSuccessRateMap.
+func (mr *MockMultiTenantIMockRecorder) PeekSuccessRateMap(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "PeekSuccessRateMap", reflect.TypeOf((*MockMultiTenantI)(nil).PeekSuccessRateMap), arg0)
+}
+
 // RemoveFromInMemoryCount mocks base method.
 func (m *MockMultiTenantI) RemoveFromInMemoryCount(arg0, arg1 string, arg2 int, arg3 string) {
 	m.ctrl.T.Helper()
@@ -97,6 +155,30 @@ func (mr *MockMultiTenantIMockRecorder) ReportProcLoopAddStats(arg0, arg1 interf
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ReportProcLoopAddStats", reflect.TypeOf((*MockMultiTenantI)(nil).ReportProcLoopAddStats), arg0, arg1)
 }
 
+// ResetSuccessCounters mocks base method.
+func (m *MockMultiTenantI) ResetSuccessCounters() {
+	m.ctrl.T.Helper()
+	m.ctrl.Call(m, "ResetSuccessCounters")
+}
+
+// ResetSuccessCounters indicates an expected call of ResetSuccessCounters.
+func (mr *MockMultiTenantIMockRecorder) ResetSuccessCounters() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ResetSuccessCounters", reflect.TypeOf((*MockMultiTenantI)(nil).ResetSuccessCounters))
+}
+
+// UpdateEarliestJobMap mocks base method.
+func (m *MockMultiTenantI) UpdateEarliestJobMap(arg0 string, arg1 map[string]time.Time) {
+	m.ctrl.T.Helper()
+	m.ctrl.Call(m, "UpdateEarliestJobMap", arg0, arg1)
+}
+
+// UpdateEarliestJobMap indicates an expected call of UpdateEarliestJobMap.
+func (mr *MockMultiTenantIMockRecorder) UpdateEarliestJobMap(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdateEarliestJobMap", reflect.TypeOf((*MockMultiTenantI)(nil).UpdateEarliestJobMap), arg0, arg1)
+}
+
 // UpdateWorkspaceLatencyMap mocks base method.
 func (m *MockMultiTenantI) UpdateWorkspaceLatencyMap(arg0, arg1 string, arg2 float64) {
 	m.ctrl.T.Helper()
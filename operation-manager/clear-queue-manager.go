@@ -1,7 +1,9 @@
 package operationmanager
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"time"
 
 	"github.com/rudderlabs/rudder-server/config"
@@ -129,17 +131,31 @@ func (handler *ClearOperationHandlerT) Exec(payload []byte) error {
 
 func (handler *ClearOperationHandlerT) clearFromJobsdb(db jobsdb.JobsDB, parameterFilters []jobsdb.ParameterFilterT, throttled, waiting bool) {
 	startTime := time.Now().UTC()
+	//the number of jobs read, which backs off after a query timeout
+	queryLimit := jobQueryBatchSize
 	for {
 		var pendingList, unprocessedList []*jobsdb.JobT
-		toQuery := jobQueryBatchSize
+		limit := queryLimit
+		toQuery := limit
 		getParams := jobsdb.GetQueryParamsT{JobCount: toQuery, ParameterFilters: parameterFilters}
 		getParams.StateFilters = []string{jobsdb.Failed.State, jobsdb.Waiting.State, jobsdb.Importing.State}
-		pendingList = db.GetProcessed(getParams)
-		toQuery -= len(pendingList)
-		getParams = jobsdb.GetQueryParamsT{JobCount: toQuery, ParameterFilters: parameterFilters}
-		getParams.UseTimeFilter = true
-		getParams.Before = startTime
-		unprocessedList = db.GetUnprocessed(getParams)
+		pendingList, err := db.GetProcessed(getParams)
+		if err == nil {
+			toQuery -= len(pendingList)
+			getParams = jobsdb.GetQueryParamsT{JobCount: toQuery, ParameterFilters: parameterFilters}
+			getParams.UseTimeFilter = true
+			getParams.Before = startTime
+			unprocessedList, err = db.GetUnprocessed(getParams)
+		}
+		queryLimit = jobsdb.NextQueryLimit(limit, jobQueryBatchSize, err)
+		if errors.Is(err, context.DeadlineExceeded) {
+			pkgLogger.Errorf("ClearQueueManager: Failed to read the jobs to clear from %s db, reading at most %d jobs next: %v", db.GetIdentifier(), queryLimit, err)
+			continue
+		}
+		if err != nil {
+			pkgLogger.Errorf("ClearQueueManager: Failed to read the jobs to clear from %s db: %v", db.GetIdentifier(), err)
+			break
+		}
 
 		combinedList := append(unprocessedList, pendingList...)
 
@@ -163,7 +179,7 @@ func (handler *ClearOperationHandlerT) clearFromJobsdb(db jobsdb.JobsDB, paramet
 			statusList = append(statusList, &status)
 		}
 		//Mark the jobs statuses
-		err := db.UpdateJobStatus(statusList, []string{}, parameterFilters)
+		err = db.UpdateJobStatus(statusList, []string{}, parameterFilters)
 		if err != nil {
 			pkgLogger.Errorf("ClearQueueManager: Error occurred while marking jobs statuses as aborted. Panicking. ParameterFilters:%#v, Err: %v", parameterFilters, err)
 			panic(err)
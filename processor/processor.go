@@ -95,6 +95,7 @@ type HandleT struct {
 	statDBReadEvents               stats.RudderStats
 	statDBReadPayloadBytes         stats.RudderStats
 	lastJobID                      int64
+	queryLimit                     int //the number of jobs read, which backs off after a query timeout, zero meaning maxEventsToProcess
 	statDBReadOutOfOrder           stats.RudderStats
 	statDBReadOutOfSequence        stats.RudderStats
 	statMarkExecuting              stats.RudderStats
@@ -134,6 +135,9 @@ type DestStatT struct {
 	numOutputSuccessEvents stats.RudderStats
 	numOutputFailedEvents  stats.RudderStats
 	transformTime          stats.RudderStats
+	//numOrphanResponses counts the responses of the transformer which don't match any of the events sent, so no job reflects them.
+	//It is only set for the user and destination transformations
+	numOrphanResponses stats.RudderStats
 }
 
 type ParametersT struct {
@@ -207,12 +211,14 @@ func (proc *HandleT) newUserTransformationStat(sourceID, workspaceID string, des
 	numOutputSuccessEvents := proc.stats.NewTaggedStat("proc_num_ut_output_success_events", stats.CountType, tags)
 	numOutputFailedEvents := proc.stats.NewTaggedStat("proc_num_ut_output_failed_events", stats.CountType, tags)
 	transformTime := proc.stats.NewTaggedStat("proc_user_transform", stats.TimerType, tags)
+	numOrphanResponses := proc.stats.NewTaggedStat("proc_num_ut_orphan_responses", stats.CountType, tags)
 
 	return &DestStatT{
 		numEvents:              numEvents,
 		numOutputSuccessEvents: numOutputSuccessEvents,
 		numOutputFailedEvents:  numOutputFailedEvents,
 		transformTime:          transformTime,
+		numOrphanResponses:     numOrphanResponses,
 	}
 }
 
@@ -225,12 +231,14 @@ func (proc *HandleT) newDestinationTransformationStat(sourceID, workspaceID, tra
 	numOutputSuccessEvents := proc.stats.NewTaggedStat("proc_num_dt_output_success_events", stats.CountType, tags)
 	numOutputFailedEvents := proc.stats.NewTaggedStat("proc_num_dt_output_failed_events", stats.CountType, tags)
 	destTransform := proc.stats.NewTaggedStat("proc_dest_transform", stats.TimerType, tags)
+	numOrphanResponses := proc.stats.NewTaggedStat("proc_num_dt_orphan_responses", stats.CountType, tags)
 
 	return &DestStatT{
 		numEvents:              numEvents,
 		numOutputSuccessEvents: numOutputSuccessEvents,
 		numOutputFailedEvents:  numOutputFailedEvents,
 		transformTime:          destTransform,
+		numOrphanResponses:     numOrphanResponses,
 	}
 }
 
@@ -1624,7 +1632,6 @@ func (proc *HandleT) transformSrcDest(
 	}
 	//REPORTING - END
 
-	url := integrations.GetDestinationURL(destType)
 	var response transformer.ResponseT
 	var eventsToTransform []transformer.TransformerEventT
 	// Send to custom transformer only if the destination has a transformer enabled
@@ -1635,7 +1642,7 @@ func (proc *HandleT) transformSrcDest(
 
 		trace.WithRegion(ctx, "UserTransform", func() {
 			startedAt := time.Now()
-			response = proc.transformer.Transform(ctx, eventList, integrations.GetUserTransformURL(), userTransformBatchSize)
+			response = proc.transformer.UserTransform(ctx, eventList, userTransformBatchSize)
 			d := time.Since(startedAt)
 			userTransformationStat.transformTime.SendTiming(d)
 			proc.addToTransformEventByTimePQ(&TransformRequestT{
@@ -1657,6 +1664,7 @@ func (proc *HandleT) transformSrcDest(
 			procErrorJobsByDestID[destID] = append(procErrorJobsByDestID[destID], failedJobs...)
 			userTransformationStat.numOutputSuccessEvents.Count(len(eventsToTransform))
 			userTransformationStat.numOutputFailedEvents.Count(len(failedJobs))
+			userTransformationStat.numOrphanResponses.Count(len(response.OrphanResponses))
 			proc.logger.Debug("Custom Transform output size", len(eventsToTransform))
 			trace.Logf(ctx, "UserTransform", "User Transform output size: %d", len(eventsToTransform))
 
@@ -1762,7 +1770,7 @@ func (proc *HandleT) transformSrcDest(
 			trace.Logf(ctx, "Dest Transform", "input size %d", len(eventsToTransform))
 			proc.logger.Debug("Dest Transform input size", len(eventsToTransform))
 			s := time.Now()
-			response = proc.transformer.Transform(ctx, eventsToTransform, url, transformBatchSize)
+			response = proc.transformer.DestinationTransform(ctx, eventsToTransform, destType, transformBatchSize)
 
 			destTransformationStat := proc.newDestinationTransformationStat(sourceID, workspaceID, transformAt, destination)
 			destTransformationStat.transformTime.Since(s)
@@ -1778,6 +1786,7 @@ func (proc *HandleT) transformSrcDest(
 			destTransformationStat.numEvents.Count(len(eventsToTransform))
 			destTransformationStat.numOutputSuccessEvents.Count(len(response.Events))
 			destTransformationStat.numOutputFailedEvents.Count(len(failedJobs))
+			destTransformationStat.numOrphanResponses.Count(len(response.OrphanResponses))
 
 			proc.saveFailedJobs(failedJobs)
 
@@ -1988,15 +1997,25 @@ func (proc *HandleT) getJobs() []*jobsdb.JobT {
 
 	proc.logger.Debugf("Processor DB Read size: %d", maxEventsToProcess)
 
-	eventCount := maxEventsToProcess
+	limit := maxEventsToProcess
+	if proc.queryLimit > 0 {
+		limit = misc.MinInt(proc.queryLimit, maxEventsToProcess)
+	}
+	eventCount := limit
 	if !enableEventCount {
 		eventCount = 0
 	}
-	unprocessedList := proc.gatewayDB.GetUnprocessed(jobsdb.GetQueryParamsT{
+	unprocessedList, err := proc.gatewayDB.GetUnprocessed(jobsdb.GetQueryParamsT{
 		CustomValFilters: []string{GWCustomVal},
-		JobCount:         maxEventsToProcess,
+		JobCount:         limit,
 		EventCount:       eventCount,
 	})
+	proc.queryLimit = jobsdb.NextQueryLimit(limit, maxEventsToProcess, err)
+	//The jobs are read again in the next loop after a failed read, with fewer jobs if it timed out
+	if err != nil {
+		proc.logger.Errorf("Processor DB Read failed, reading at most %d jobs next: %v", proc.queryLimit, err)
+		return []*jobsdb.JobT{}
+	}
 	totalEvents := 0
 	totalPayloadBytes := 0
 	for i, job := range unprocessedList {
@@ -221,6 +221,8 @@ func (st *HandleT) setErrJobStatus(jobs []*jobsdb.JobT, output StoreErrorOutputT
 func (st *HandleT) readErrJobsLoop(ctx context.Context) {
 	st.logger.Info("Processor errors stash loop started")
 
+	//the number of jobs read, which backs off after a query timeout
+	queryLimit := errDBReadBatchSize
 	for {
 		select {
 		case <-ctx.Done():
@@ -230,12 +232,21 @@ func (st *HandleT) readErrJobsLoop(ctx context.Context) {
 			st.statErrDBR.Start()
 
 			//NOTE: sending custom val filters array of size 1 to take advantage of cache in jobsdb.
-			toQuery := errDBReadBatchSize
-			retryList := st.errorDB.GetToRetry(jobsdb.GetQueryParamsT{CustomValFilters: []string{""}, JobCount: toQuery, IgnoreCustomValFiltersInQuery: true})
-			toQuery -= len(retryList)
-			unprocessedList := st.errorDB.GetUnprocessed(jobsdb.GetQueryParamsT{CustomValFilters: []string{""}, JobCount: toQuery, IgnoreCustomValFiltersInQuery: true})
+			limit := misc.MinInt(queryLimit, errDBReadBatchSize)
+			toQuery := limit
+			retryList, err := st.errorDB.GetToRetry(jobsdb.GetQueryParamsT{CustomValFilters: []string{""}, JobCount: toQuery, IgnoreCustomValFiltersInQuery: true})
+			var unprocessedList []*jobsdb.JobT
+			if err == nil {
+				toQuery -= len(retryList)
+				unprocessedList, err = st.errorDB.GetUnprocessed(jobsdb.GetQueryParamsT{CustomValFilters: []string{""}, JobCount: toQuery, IgnoreCustomValFiltersInQuery: true})
+			}
 
 			st.statErrDBR.End()
+			queryLimit = jobsdb.NextQueryLimit(limit, errDBReadBatchSize, err)
+			if err != nil {
+				st.logger.Errorf("[Processor: readErrJobsLoop]: Failed to read the proc_err jobs, reading at most %d jobs next: %v", queryLimit, err)
+				continue
+			}
 
 			combinedList := append(retryList, unprocessedList...)
 
@@ -268,7 +279,7 @@ func (st *HandleT) readErrJobsLoop(ctx context.Context) {
 				statusList = append(statusList, &status)
 			}
 
-			err := st.errorDB.UpdateJobStatus(statusList, nil, nil)
+			err = st.errorDB.UpdateJobStatus(statusList, nil, nil)
 			if err != nil {
 				pkgLogger.Errorf("Error occurred while marking proc error jobs statuses as %v. Panicking. Err: %v", jobState, err)
 				panic(err)
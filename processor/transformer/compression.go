@@ -0,0 +1,99 @@
+package transformer
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const gzipEncoding = "gzip"
+
+//compressionSupportT remembers the transformer urls which advertised support for gzip request bodies,
+//in the Accept-Encoding header of their responses. Until a url has, its requests are sent uncompressed.
+type compressionSupportT struct {
+	lock sync.RWMutex
+	gzip map[string]bool
+}
+
+func (c *compressionSupportT) set(url string, supported bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.gzip == nil {
+		c.gzip = make(map[string]bool)
+	}
+	c.gzip[url] = supported
+}
+
+func (c *compressionSupportT) supportsGzip(url string) bool {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+
+	return c.gzip[url]
+}
+
+//acceptsGzip returns true if gzip is one of the encodings listed in the Accept-Encoding header, without a zero q value
+func acceptsGzip(header http.Header) bool {
+	for _, value := range header.Values("Accept-Encoding") {
+		for _, encoding := range strings.Split(value, ",") {
+			params := strings.Split(encoding, ";")
+			if !strings.EqualFold(strings.TrimSpace(params[0]), gzipEncoding) {
+				continue
+			}
+			for _, param := range params[1:] {
+				param = strings.TrimSpace(param)
+				if !strings.HasPrefix(param, "q=") {
+					continue
+				}
+				if q, err := strconv.ParseFloat(param[len("q="):], 64); err == nil && q == 0 {
+					return false
+				}
+			}
+			return true
+		}
+	}
+	return false
+}
+
+func gzipBody(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	gzipWriter := gzip.NewWriter(&buf)
+	if _, err := gzipWriter.Write(data); err != nil {
+		return nil, err
+	}
+	if err := gzipWriter.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+//decodedBody returns a reader of the body decompressing it if its Content-Encoding is gzip, along with a func closing both
+func decodedBody(header http.Header, body io.ReadCloser) (io.Reader, func(), error) {
+	if !strings.EqualFold(header.Get("Content-Encoding"), gzipEncoding) {
+		return body, func() { body.Close() }, nil
+	}
+	gzipReader, err := gzip.NewReader(body)
+	if err != nil {
+		body.Close()
+		return nil, nil, err
+	}
+	return gzipReader, func() {
+		gzipReader.Close()
+		body.Close()
+	}, nil
+}
+
+//ReadRequestBody reads the body of a request sent to a transformer, decompressing it if it was gzipped
+//with Processor.Transformer.enableCompression. It is meant for transformers implemented in go, e.g. fakes in tests.
+func ReadRequestBody(r *http.Request) ([]byte, error) {
+	body, closeBody, err := decodedBody(r.Header, r.Body)
+	if err != nil {
+		return nil, err
+	}
+	defer closeBody()
+	return io.ReadAll(body)
+}
@@ -0,0 +1,102 @@
+package transformer
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+//redactedHeaderValue replaces the values of the sensitive headers in the logs
+const redactedHeaderValue = "[REDACTED]"
+
+//sensitiveHeaderNames are the substrings of the names of the headers whose values are redacted in the logs, in lower case
+var sensitiveHeaderNames = []string{"authorization", "cookie", "token", "secret", "password", "key"}
+
+//requestHeadersT holds the custom headers set on every request sent to the transformer, e.g. for authenticating to it
+type requestHeadersT struct {
+	lock    sync.RWMutex
+	headers map[string]string
+	isSet   bool
+}
+
+func (h *requestHeadersT) set(headers map[string]string) {
+	copied := make(map[string]string, len(headers))
+	for name, value := range headers {
+		copied[name] = value
+	}
+
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.headers = copied
+	h.isSet = true
+}
+
+func (h *requestHeadersT) wasSet() bool {
+	h.lock.RLock()
+	defer h.lock.RUnlock()
+
+	return h.isSet
+}
+
+func (h *requestHeadersT) count() int {
+	h.lock.RLock()
+	defer h.lock.RUnlock()
+
+	return len(h.headers)
+}
+
+func (h *requestHeadersT) apply(header http.Header) {
+	h.lock.RLock()
+	defer h.lock.RUnlock()
+
+	for name, value := range h.headers {
+		header.Set(name, value)
+	}
+}
+
+//String lists the headers sorted by name, with the values of the sensitive ones redacted, so that they can be logged
+func (h *requestHeadersT) String() string {
+	h.lock.RLock()
+	defer h.lock.RUnlock()
+
+	names := make([]string, 0, len(h.headers))
+	for name := range h.headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	entries := make([]string, len(names))
+	for i, name := range names {
+		value := h.headers[name]
+		if isSensitiveHeader(name) {
+			value = redactedHeaderValue
+		}
+		entries[i] = name + ": " + value
+	}
+	return "[" + strings.Join(entries, ", ") + "]"
+}
+
+func isSensitiveHeader(name string) bool {
+	name = strings.ToLower(name)
+	for _, sensitive := range sensitiveHeaderNames {
+		if strings.Contains(name, sensitive) {
+			return true
+		}
+	}
+	return false
+}
+
+//parseRequestHeaders parses the headers configured in Processor.Transformer.requestHeaders as a JSON object of names to values,
+//e.g. {"Authorization": "Bearer ..."}. It returns nil if none are configured.
+func parseRequestHeaders(config string) (map[string]string, error) {
+	if strings.TrimSpace(config) == "" {
+		return nil, nil
+	}
+	var headers map[string]string
+	if err := json.Unmarshal([]byte(config), &headers); err != nil {
+		return nil, fmt.Errorf("parsing transformer request headers: %w", err)
+	}
+	return headers, nil
+}
@@ -0,0 +1,42 @@
+package transformer
+
+import "sort"
+
+//MergeResponses combines the responses of several Transform calls, e.g. of the events split across urls or retried,
+//concatenating their events in the order of the responses passed, so that the result is deterministic.
+//The responses are copied, so appending to the merged response doesn't modify the ones passed.
+//It is Cancelled if any of them is.
+func MergeResponses(responses ...ResponseT) ResponseT {
+	var merged ResponseT
+	for _, response := range responses {
+		merged.Events = append(merged.Events, response.Events...)
+		merged.FailedEvents = append(merged.FailedEvents, response.FailedEvents...)
+		merged.DeferredEvents = append(merged.DeferredEvents, response.DeferredEvents...)
+		merged.OrphanResponses = append(merged.OrphanResponses, response.OrphanResponses...)
+		merged.Cancelled = merged.Cancelled || response.Cancelled
+	}
+	return merged
+}
+
+//Merge returns the events of r followed by those of other, see MergeResponses
+func (r ResponseT) Merge(other ResponseT) ResponseT {
+	return MergeResponses(r, other)
+}
+
+//SortByMessageID sorts the events of each of the lists in place by their messageID, keeping the order of the events sharing one,
+//so that merged responses are in the same order whichever order they were merged in.
+//It restores the order of the input events only if their messageIDs were sorted to begin with.
+func (r ResponseT) SortByMessageID() {
+	sortResponsesByMessageID(r.Events)
+	sortResponsesByMessageID(r.FailedEvents)
+	sortResponsesByMessageID(r.OrphanResponses)
+	sort.SliceStable(r.DeferredEvents, func(i, j int) bool {
+		return r.DeferredEvents[i].Metadata.MessageID < r.DeferredEvents[j].Metadata.MessageID
+	})
+}
+
+func sortResponsesByMessageID(responses []TransformerResponseT) {
+	sort.SliceStable(responses, func(i, j int) bool {
+		return responses[i].Metadata.MessageID < responses[j].Metadata.MessageID
+	})
+}
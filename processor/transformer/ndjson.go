@@ -0,0 +1,53 @@
+package transformer
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+
+	"github.com/rudderlabs/rudder-server/processor/integrations"
+)
+
+//ndjsonContentType is the content type of the responses streamed one JSON object per line, see Processor.Transformer.enableStreaming
+const ndjsonContentType = "application/x-ndjson"
+
+//isNDJSON tells if the response is streamed as NDJSON, going by its Content-Type header
+func isNDJSON(header http.Header) bool {
+	mediaType, _, err := mime.ParseMediaType(header.Get("Content-Type"))
+	return err == nil && mediaType == ndjsonContentType
+}
+
+//errMalformedNDJSON is returned by decodeNDJSON if the body isn't valid NDJSON, unlike the errors reading it
+var errMalformedNDJSON = errors.New("malformed ndjson response")
+
+//decodeNDJSON decodes the responses one object at a time as they are read from body, instead of buffering the whole body.
+//The returned slice is never nil, even if there are no responses. It fails with errMalformedNDJSON if the body is malformed,
+//while the errors reading body, including io.ErrUnexpectedEOF for a truncated one, are returned as is.
+func decodeNDJSON(body io.Reader) ([]TransformerResponseT, error) {
+	responses := make([]TransformerResponseT, 0)
+	decoder := json.NewDecoder(body)
+	for {
+		var raw json.RawMessage
+		err := decoder.Decode(&raw)
+		if err == io.EOF {
+			return responses, nil
+		}
+		var syntaxErr *json.SyntaxError
+		if errors.As(err, &syntaxErr) {
+			return nil, fmt.Errorf("%w: %v", errMalformedNDJSON, err)
+		}
+		if err != nil {
+			return nil, err
+		}
+		//the error stats are collected per response, same as for the array responses
+		integrations.CollectDestErrorStats(raw)
+		var response TransformerResponseT
+		if err := jsonfast.Unmarshal(raw, &response); err != nil {
+			return nil, fmt.Errorf("%w: %v", errMalformedNDJSON, err)
+		}
+		responses = append(responses, response)
+	}
+}
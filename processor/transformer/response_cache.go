@@ -0,0 +1,91 @@
+package transformer
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"strings"
+	"sync"
+)
+
+type responseCacheEntryT struct {
+	key       [sha256.Size]byte
+	responses []TransformerResponseT
+}
+
+//responseCacheT is an LRU cache of the successful responses of the events sent to the transformer,
+//keyed by the hash of the serialized event and the transformer urls
+type responseCacheT struct {
+	lock    sync.Mutex
+	size    int
+	entries map[[sha256.Size]byte]*list.Element
+	order   *list.List //most recently used first
+}
+
+func newResponseCache(size int) *responseCacheT {
+	return &responseCacheT{
+		size:    size,
+		entries: make(map[[sha256.Size]byte]*list.Element, size),
+		order:   list.New(),
+	}
+}
+
+//key returns the cache key of the event sent to the urls
+func (c *responseCacheT) key(urls []string, event *TransformerEventT) [sha256.Size]byte {
+	rawJSON, err := jsonfast.Marshal(event)
+	if err != nil {
+		panic(err)
+	}
+	hash := sha256.New()
+	hash.Write([]byte(strings.Join(urls, ",")))
+	hash.Write([]byte{0})
+	hash.Write(rawJSON)
+	var key [sha256.Size]byte
+	copy(key[:], hash.Sum(nil))
+	return key
+}
+
+//get returns a copy of the cached responses of key
+func (c *responseCacheT) get(key [sha256.Size]byte) ([]TransformerResponseT, bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	element, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	c.order.MoveToFront(element)
+	return copyResponses(element.Value.(*responseCacheEntryT).responses), true
+}
+
+//set caches the responses of key, evicting the least recently used entry if the cache is full
+func (c *responseCacheT) set(key [sha256.Size]byte, responses []TransformerResponseT) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if element, ok := c.entries[key]; ok {
+		element.Value.(*responseCacheEntryT).responses = copyResponses(responses)
+		c.order.MoveToFront(element)
+		return
+	}
+	if c.order.Len() >= c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*responseCacheEntryT).key)
+	}
+	c.entries[key] = c.order.PushFront(&responseCacheEntryT{key: key, responses: copyResponses(responses)})
+}
+
+//copyResponses copies the responses along with their outputs, so that the cached ones can't be modified by the callers
+func copyResponses(responses []TransformerResponseT) []TransformerResponseT {
+	copied := make([]TransformerResponseT, len(responses))
+	for i, response := range responses {
+		if response.Output != nil {
+			response.Output = make(map[string]interface{}, len(responses[i].Output))
+			for k, v := range responses[i].Output {
+				response.Output[k] = v
+			}
+		}
+		copied[i] = response
+	}
+	return copied
+}
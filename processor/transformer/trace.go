@@ -0,0 +1,63 @@
+package transformer
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+)
+
+//TraceT is a request sent to the transformer, captured along with its response for debugging
+type TraceT struct {
+	Time       time.Time
+	URL        string
+	RequestID  string
+	Tags       map[string]string
+	StatusCode int
+	Request    []byte
+	Response   []byte
+	Error      string
+}
+
+//traceBufferT is a ring buffer of the traces of the sampled requests, keeping the latest ones
+type traceBufferT struct {
+	lock   sync.Mutex
+	traces []TraceT
+	next   int
+	full   bool
+}
+
+func newTraceBuffer(size int) *traceBufferT {
+	return &traceBufferT{traces: make([]TraceT, size)}
+}
+
+//sample tells if a request should be traced, with a probability of Processor.Transformer.traceSampleRate.
+//It is false for a nil buffer, i.e. if the transformer hasn't been set up
+func (b *traceBufferT) sample() bool {
+	return b != nil && traceSampleRate > 0 && (traceSampleRate >= 1 || rand.Float64() < traceSampleRate)
+}
+
+//add adds the trace, overwriting the oldest one if the buffer is full.
+//The request and response bodies aren't copied, since they aren't modified after being sent and read
+func (b *traceBufferT) add(trace TraceT) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	b.traces[b.next] = trace
+	b.next = (b.next + 1) % len(b.traces)
+	if b.next == 0 {
+		b.full = true
+	}
+}
+
+//list returns the traces, oldest first
+func (b *traceBufferT) list() []TraceT {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	if !b.full {
+		return append([]TraceT(nil), b.traces[:b.next]...)
+	}
+	traces := make([]TraceT, 0, len(b.traces))
+	traces = append(traces, b.traces[b.next:]...)
+	return append(traces, b.traces[:b.next]...)
+}
@@ -0,0 +1,36 @@
+package transformer
+
+import (
+	"context"
+	"net/http"
+)
+
+//Span is a unit of work reported to a distributed tracing backend
+type Span interface {
+	SetAttributes(attributes map[string]interface{})
+	End()
+}
+
+//Tracer starts a span for every batch sent to the transformer and propagates its trace context to the transformer.
+//It adapts a distributed tracing library like OpenTelemetry, see SetTracer.
+type Tracer interface {
+	//Start starts a span named name, as a child of the span carried by ctx if any, and returns a ctx carrying the new span
+	Start(ctx context.Context, name string) (context.Context, Span)
+	//Inject sets the trace context carried by ctx in the headers of an outgoing request, e.g. the W3C traceparent and tracestate headers
+	Inject(ctx context.Context, header http.Header)
+}
+
+//noopTracer is the tracer used when none is set, so that tracing costs nothing unless configured
+type noopTracer struct{}
+
+type noopSpan struct{}
+
+func (noopTracer) Start(ctx context.Context, _ string) (context.Context, Span) {
+	return ctx, noopSpan{}
+}
+
+func (noopTracer) Inject(context.Context, http.Header) {}
+
+func (noopSpan) SetAttributes(map[string]interface{}) {}
+
+func (noopSpan) End() {}
@@ -5,14 +5,23 @@ package transformer
 import (
 	"bytes"
 	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"os"
 	"runtime/trace"
 	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/cenkalti/backoff/v4"
+	uuid "github.com/gofrs/uuid"
 	jsoniter "github.com/json-iterator/go"
 
 	"github.com/rudderlabs/rudder-server/config"
@@ -24,6 +33,23 @@ import (
 	"github.com/rudderlabs/rudder-server/utils/types"
 )
 
+//NoTransformerResponseError is set as the error of the events for which the transformer didn't return any response,
+//if they are expected to get one, see failMissingResponses
+const NoTransformerResponseError = "no_transformer_response"
+
+//ResponseTooLargeError is set as the error of the events of a batch whose response exceeded maxResponseBytes
+const ResponseTooLargeError = "response_too_large"
+
+//NoTransformerURLError is set as the error of the events which weren't transformed because no transformer url was given, e.g. to TransformMulti
+const NoTransformerURLError = "no_transformer_url"
+
+//CancelledError is set as the error of the events which weren't transformed because the context was cancelled
+const CancelledError = "cancelled"
+
+//RequestIDHeader is the header carrying the id generated for every batch sent to the transformer,
+//which the transformer echoes back, so that the batch can be correlated with the transformer logs
+const RequestIDHeader = "X-Request-ID"
+
 const (
 	UserTransformerStage        = "user_transformer"
 	EventFilterStage            = "event_filter"
@@ -65,6 +91,8 @@ type MetadataT struct {
 	EventType               string   `json:"eventType"`
 	SourceDefinitionID      string   `json:"sourceDefinitionId"`
 	DestinationDefinitionID string   `json:"destinationDefinitionId"`
+	// set by the processor to the request id of the transformer batch the response belongs to
+	TransformerRequestID string `json:"transformerRequestId,omitempty"`
 }
 
 type TransformerEventT struct {
@@ -86,15 +114,42 @@ type HandleT struct {
 	logger logger.LoggerI
 
 	Client *http.Client
+	//tlsConfig is used by the client built in Setup, for transformers behind TLS/mTLS
+	tlsConfig *tls.Config
 
 	guardConcurrency chan struct{}
+
+	urlHealth urlHealthT
+
+	//unavailable is set to 1 if the health check in Setup didn't succeed within healthCheckTimeout
+	unavailable int32
+
+	//responseCache is set in Setup if Processor.Transformer.enableTransformCache is true
+	responseCache *responseCacheT
+
+	//traces keeps the latest requests sampled with Processor.Transformer.traceSampleRate, see Traces
+	traces *traceBufferT
+
+	//tracer starts the spans of the batches, a no-op unless set with SetTracer
+	tracer Tracer
+
+	//compressionSupport remembers the urls accepting gzip request bodies, if Processor.Transformer.enableCompression is true
+	compressionSupport compressionSupportT
+
+	//requestHeaders are set on every request, see SetRequestHeaders
+	requestHeaders requestHeadersT
 }
 
 //Transformer provides methods to transform events
 type Transformer interface {
 	Setup()
 	Transform(ctx context.Context, clientEvents []TransformerEventT, url string, batchSize int) ResponseT
+	UserTransform(ctx context.Context, clientEvents []TransformerEventT, batchSize int) ResponseT
+	DestinationTransform(ctx context.Context, clientEvents []TransformerEventT, destType string, batchSize int) ResponseT
+	TransformWithDeadline(ctx context.Context, clientEvents []TransformerEventT, url string, batchSize int) ResponseT
+	TransformMulti(ctx context.Context, clientEvents []TransformerEventT, urls []string, batchSize int) ResponseT
 	Validate(clientEvents []TransformerEventT, url string, batchSize int) ResponseT
+	Healthy() bool
 }
 
 //NewTransformer creates a new transformer
@@ -102,9 +157,43 @@ func NewTransformer() *HandleT {
 	return &HandleT{}
 }
 
+//SetTLSConfig sets the TLS config of the client built in Setup, e.g. with the client certificates for mTLS.
+//It must be called before Setup and takes precedence over the Processor.Transformer.tls* config.
+//It has no effect if Client is set.
+func (trans *HandleT) SetTLSConfig(tlsConfig *tls.Config) {
+	trans.tlsConfig = tlsConfig
+}
+
+//SetRequestHeaders sets the headers sent along with every request to the transformer, e.g. Authorization, replacing the ones set before.
+//It can be called at any time, e.g. for rotating the tokens. If called before Setup, it takes precedence over Processor.Transformer.requestHeaders.
+//The values of the headers which look sensitive, e.g. Authorization or *-Token, are redacted in the logs.
+func (trans *HandleT) SetRequestHeaders(headers map[string]string) {
+	trans.requestHeaders.set(headers)
+	if trans.logger != nil {
+		trans.logger.Infof("Transformer request headers set: %v", &trans.requestHeaders)
+	}
+}
+
 var (
 	maxConcurrency, maxHTTPConnections, maxHTTPIdleConnections, maxRetry int
+	failMissingResponses                                                 bool
 	retrySleep                                                           time.Duration
+	deadlineMargin                                                       time.Duration
+	unhealthyURLThreshold                                                int
+	unhealthyURLCooldown                                                 time.Duration
+	maxResponseBytes                                                     int64
+	dedupeByMessageID                                                    bool
+	tlsCertFile, tlsKeyFile, tlsCAFile                                   string
+	healthCheckEnabled, healthCheckFatal                                 bool
+	healthCheckPath                                                      string
+	healthCheckTimeout                                                   time.Duration
+	enableTransformCache                                                 bool
+	transformCacheSize                                                   int
+	traceSampleRate                                                      float64
+	traceBufferSize                                                      int
+	enableCompression                                                    bool
+	requestHeadersConfig                                                 string
+	enableStreaming                                                      bool
 	pkgLogger                                                            logger.LoggerI
 )
 
@@ -117,9 +206,57 @@ func loadConfig() {
 	config.RegisterIntConfigVariable(200, &maxConcurrency, false, 1, "Processor.maxConcurrency")
 	config.RegisterIntConfigVariable(100, &maxHTTPConnections, false, 1, "Processor.maxHTTPConnections")
 	config.RegisterIntConfigVariable(50, &maxHTTPIdleConnections, false, 1, "Processor.maxHTTPIdleConnections")
+	config.RegisterBoolConfigVariable(false, &failMissingResponses, true, "Processor.Transformer.failMissingResponses")
 
 	config.RegisterIntConfigVariable(30, &maxRetry, true, 1, "Processor.maxRetry")
 	config.RegisterDurationConfigVariable(time.Duration(100), &retrySleep, true, time.Millisecond, []string{"Processor.retrySleep", "Processor.retrySleepInMS"}...)
+	config.RegisterDurationConfigVariable(time.Duration(50), &deadlineMargin, true, time.Millisecond, []string{"Processor.transformerDeadlineMargin"}...)
+	config.RegisterIntConfigVariable(3, &unhealthyURLThreshold, true, 1, "Processor.transformerUnhealthyURLThreshold")
+	config.RegisterDurationConfigVariable(time.Duration(30), &unhealthyURLCooldown, true, time.Second, []string{"Processor.transformerUnhealthyURLCooldown"}...)
+	config.RegisterInt64ConfigVariable(500*1024*1024, &maxResponseBytes, true, 1, "Processor.Transformer.maxResponseBytes")
+	config.RegisterBoolConfigVariable(false, &dedupeByMessageID, true, "Processor.Transformer.dedupeByMessageID")
+	config.RegisterStringConfigVariable("", &tlsCertFile, false, "Processor.Transformer.tlsCertFile")
+	config.RegisterStringConfigVariable("", &tlsKeyFile, false, "Processor.Transformer.tlsKeyFile")
+	config.RegisterStringConfigVariable("", &tlsCAFile, false, "Processor.Transformer.tlsCAFile")
+	config.RegisterBoolConfigVariable(false, &healthCheckEnabled, false, "Processor.Transformer.healthCheckEnabled")
+	config.RegisterBoolConfigVariable(false, &healthCheckFatal, false, "Processor.Transformer.healthCheckFatal")
+	config.RegisterStringConfigVariable("/health", &healthCheckPath, false, "Processor.Transformer.healthCheckPath")
+	config.RegisterDurationConfigVariable(time.Duration(30), &healthCheckTimeout, false, time.Second, []string{"Processor.Transformer.healthCheckTimeout"}...)
+	config.RegisterBoolConfigVariable(false, &enableTransformCache, false, "Processor.Transformer.enableTransformCache")
+	config.RegisterIntConfigVariable(10000, &transformCacheSize, false, 1, "Processor.Transformer.transformCacheSize")
+	config.RegisterFloat64ConfigVariable(0, &traceSampleRate, true, "Processor.Transformer.traceSampleRate")
+	config.RegisterIntConfigVariable(100, &traceBufferSize, false, 1, "Processor.Transformer.traceBufferSize")
+	config.RegisterBoolConfigVariable(false, &enableCompression, true, "Processor.Transformer.enableCompression")
+	config.RegisterStringConfigVariable("", &requestHeadersConfig, false, "Processor.Transformer.requestHeaders")
+	config.RegisterBoolConfigVariable(false, &enableStreaming, true, "Processor.Transformer.enableStreaming")
+}
+
+//loadTLSConfig builds a TLS config from the PEM files configured, with the client certificate if both
+//the cert and key files are set, and the root CAs if the CA file is set. It returns nil if none of them are set.
+func loadTLSConfig(certFile, keyFile, caFile string) (*tls.Config, error) {
+	if certFile == "" && keyFile == "" && caFile == "" {
+		return nil, nil
+	}
+	tlsConfig := &tls.Config{}
+	if certFile != "" || keyFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("loading transformer client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	if caFile != "" {
+		caCert, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, fmt.Errorf("reading transformer CA file: %w", err)
+		}
+		caCertPool := x509.NewCertPool()
+		if !caCertPool.AppendCertsFromPEM(caCert) {
+			return nil, fmt.Errorf("no certificates found in transformer CA file %s", caFile)
+		}
+		tlsConfig.RootCAs = caCertPool
+	}
+	return tlsConfig, nil
 }
 
 type TransformerResponseT struct {
@@ -137,6 +274,12 @@ type ValidationErrorT struct {
 	Meta    map[string]string `json:"meta"`
 }
 
+//SetTracer sets the tracer starting a span for every batch sent to the transformer and propagating its trace context
+//in the request headers. It must be called before Setup, and without it no spans are started.
+func (trans *HandleT) SetTracer(tracer Tracer) {
+	trans.tracer = tracer
+}
+
 //Setup initializes this class
 func (trans *HandleT) Setup() {
 	trans.logger = pkgLogger
@@ -150,20 +293,115 @@ func (trans *HandleT) Setup() {
 	trans.perfStats.Setup("JS Call")
 
 	if trans.Client == nil {
+		if trans.tlsConfig == nil {
+			tlsConfig, err := loadTLSConfig(tlsCertFile, tlsKeyFile, tlsCAFile)
+			if err != nil {
+				panic(err)
+			}
+			trans.tlsConfig = tlsConfig
+		}
 		trans.Client = &http.Client{
 			Transport: &http.Transport{
 				MaxConnsPerHost:     maxHTTPConnections,
 				MaxIdleConnsPerHost: maxHTTPIdleConnections,
 				IdleConnTimeout:     time.Minute,
+				TLSClientConfig:     trans.tlsConfig,
 			},
 		}
 	}
+
+	//Not all the transforms are pure functions of their events, so caching their responses is opt in
+	if enableTransformCache {
+		trans.responseCache = newResponseCache(transformCacheSize)
+	}
+	trans.traces = newTraceBuffer(traceBufferSize)
+	if trans.tracer == nil {
+		trans.tracer = noopTracer{}
+	}
+	if !trans.requestHeaders.wasSet() {
+		headers, err := parseRequestHeaders(requestHeadersConfig)
+		if err != nil {
+			panic(err)
+		}
+		trans.requestHeaders.set(headers)
+	}
+	if trans.requestHeaders.count() > 0 {
+		trans.logger.Infof("Transformer request headers set: %v", &trans.requestHeaders)
+	}
+
+	if healthCheckEnabled {
+		trans.checkHealth(integrations.GetTransformerURL() + healthCheckPath)
+	}
+}
+
+//Traces returns the latest requests sampled with Processor.Transformer.traceSampleRate, along with their responses, oldest first.
+//Up to Processor.Transformer.traceBufferSize traces are kept.
+func (trans *HandleT) Traces() []TraceT {
+	return trans.traces.list()
+}
+
+//Healthy returns false if the transformer couldn't be reached by the health check in Setup.
+//It is always true if Processor.Transformer.healthCheckEnabled is false.
+func (trans *HandleT) Healthy() bool {
+	return atomic.LoadInt32(&trans.unavailable) == 0
+}
+
+//checkHealth GETs url, retrying with an exponential backoff until it responds with 200 or healthCheckTimeout elapses.
+//If it doesn't, the transformer is marked as unavailable, and Setup panics if Processor.Transformer.healthCheckFatal is true.
+func (trans *HandleT) checkHealth(url string) {
+	start := time.Now()
+	operation := func() error {
+		req, err := http.NewRequest(http.MethodGet, url, nil)
+		if err != nil {
+			return backoff.Permanent(err)
+		}
+		trans.requestHeaders.apply(req.Header)
+		resp, err := trans.Client.Do(req)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		_, _ = io.Copy(io.Discard, resp.Body)
+		if resp.StatusCode != http.StatusOK {
+			return fmt.Errorf("transformer health check returned status code %d", resp.StatusCode)
+		}
+		return nil
+	}
+	b := backoff.NewExponentialBackOff()
+	b.MaxElapsedTime = healthCheckTimeout
+	err := backoff.RetryNotify(operation, b, func(err error, t time.Duration) {
+		trans.logger.Warnf("Transformer health check failed, URL: %v Error: %v. Retrying in %v", url, err, t)
+	})
+
+	status := "healthy"
+	if err != nil {
+		status = "unavailable"
+		atomic.StoreInt32(&trans.unavailable, 1)
+		trans.logger.Errorf("Transformer is unavailable after %v, URL: %v Error: %v", time.Since(start), url, err)
+	} else {
+		atomic.StoreInt32(&trans.unavailable, 0)
+		trans.logger.Infof("Transformer is healthy, URL: %v", url)
+	}
+	stats.NewTaggedStat("processor.transformer_health_check", stats.CountType, stats.Tags{"status": status}).Increment()
+	stats.NewTaggedStat("processor.transformer_health_check_time", stats.TimerType, stats.Tags{"status": status}).SendTiming(time.Since(start))
+	if err != nil && healthCheckFatal {
+		panic(fmt.Errorf("transformer is unavailable, URL: %v Error: %w", url, err))
+	}
 }
 
 //ResponseT represents a Transformer response
 type ResponseT struct {
 	Events       []TransformerResponseT
 	FailedEvents []TransformerResponseT
+	//DeferredEvents are the events which weren't sent to the transformer
+	//since the deadline of the context passed to TransformWithDeadline was near
+	DeferredEvents []TransformerEventT
+	//OrphanResponses are the responses returned by the transformer whose metadata doesn't match any of the events sent
+	OrphanResponses []TransformerResponseT
+	//Cancelled is true if the context was cancelled before all the events were transformed.
+	//The responses of the batches completed until then are returned as usual,
+	//while the rest of the events are returned in FailedEvents with CancelledError, so that they can be retried
+	Cancelled bool
 }
 
 //GetVersion gets the transformer version by asking it on /transfomerBuildVersion. if there is any error it returns empty string
@@ -193,15 +431,66 @@ func GetVersion() (transformerBuildVersion string) {
 	return
 }
 
-//Transform function is used to invoke transformer API
+//Transform function is used to invoke transformer API.
+//If ctx is cancelled, no more batches are sent and the responses of the ones already sent are returned,
+//with ResponseT.Cancelled set and the events of the rest of the batches failed with CancelledError.
 func (trans *HandleT) Transform(ctx context.Context, clientEvents []TransformerEventT,
 	url string, batchSize int) ResponseT {
+	return trans.transform(ctx, clientEvents, []string{url}, batchSize, false, "")
+}
+
+//UserTransform works like Transform, sending the events to the user transformations endpoint of the transformer.
+//Its stats are tagged with the user_transformer stage.
+func (trans *HandleT) UserTransform(ctx context.Context, clientEvents []TransformerEventT, batchSize int) ResponseT {
+	return trans.transform(ctx, clientEvents, []string{integrations.GetUserTransformURL()}, batchSize, false, UserTransformerStage)
+}
+
+//DestinationTransform works like Transform, sending the events to the transformer endpoint of destType.
+//Its stats are tagged with the dest_transformer stage.
+func (trans *HandleT) DestinationTransform(ctx context.Context, clientEvents []TransformerEventT, destType string, batchSize int) ResponseT {
+	return trans.transform(ctx, clientEvents, []string{integrations.GetDestinationURL(destType)}, batchSize, false, DestTransformerStage)
+}
+
+//TransformMulti works like Transform, but distributes the batches across the given transformer urls.
+//If a batch can't be sent to a url because of a connection error, it is retried on the next url.
+//Urls which fail persistently are tried only after the healthy ones.
+func (trans *HandleT) TransformMulti(ctx context.Context, clientEvents []TransformerEventT,
+	urls []string, batchSize int) ResponseT {
+	return trans.transform(ctx, clientEvents, urls, batchSize, false, "")
+}
+
+//TransformWithDeadline works like Transform, but stops dispatching new batches once the deadline of ctx is near.
+//Events of the batches which weren't dispatched are returned in ResponseT.DeferredEvents, so that they can be requeued.
+func (trans *HandleT) TransformWithDeadline(ctx context.Context, clientEvents []TransformerEventT,
+	url string, batchSize int) ResponseT {
+	return trans.transform(ctx, clientEvents, []string{url}, batchSize, true, "")
+}
+
+//isDeadlineNear returns true if ctx is done or its deadline is within deadlineMargin
+func isDeadlineNear(ctx context.Context) bool {
+	if ctx.Err() != nil {
+		return true
+	}
+	deadline, ok := ctx.Deadline()
+	return ok && time.Until(deadline) < deadlineMargin
+}
+
+func (trans *HandleT) transform(ctx context.Context, clientEvents []TransformerEventT,
+	urls []string, batchSize int, deferOnDeadline bool, stage string) ResponseT {
 
 	if len(clientEvents) == 0 {
 		return ResponseT{}
 	}
+	//without this, the batches would be retried on no url until giving up with a panic
+	if len(urls) == 0 {
+		trans.logger.Errorf("No transformer url to send %d events to", len(clientEvents))
+		return ResponseT{FailedEvents: failedResponses(clientEvents, http.StatusBadRequest, NoTransformerURLError)}
+	}
 
-	sTags := statsTags(clientEvents[0])
+	sTags := statsTags(clientEvents[0], stage)
+	//user transformations drop events by not returning any response for them, which the processor accounts for,
+	//while destination transformations respond to every event
+	failMissing := failMissingResponses || stage == DestTransformerStage
 
 	s := time.Now()
 	defer stats.NewTaggedStat(
@@ -210,6 +499,24 @@ func (trans *HandleT) Transform(ctx context.Context, clientEvents []TransformerE
 		sTags,
 	).Since(s)
 
+	var duplicates map[string][]TransformerEventT
+	if dedupeByMessageID {
+		clientEvents, duplicates = dedupeEvents(clientEvents)
+		if len(duplicates) > 0 {
+			duplicateCount := 0
+			for _, events := range duplicates {
+				duplicateCount += len(events)
+			}
+			stats.NewTaggedStat("processor.transformer_deduped", stats.CountType, sTags).Count(duplicateCount)
+		}
+	}
+
+	var cachedResponses []TransformerResponseT
+	var cacheKeys map[string][][sha256.Size]byte
+	if trans.responseCache != nil {
+		clientEvents, cachedResponses, cacheKeys = trans.lookupCachedResponses(urls, clientEvents, sTags)
+	}
+
 	batchCount := len(clientEvents) / batchSize
 	if len(clientEvents)%batchSize != 0 {
 		batchCount += 1
@@ -223,9 +530,11 @@ func (trans *HandleT) Transform(ctx context.Context, clientEvents []TransformerE
 	trace.Logf(ctx, "request", "batch_count: %d", batchCount)
 
 	transformResponse := make([][]TransformerResponseT, batchCount)
+	orphanResponse := make([][]TransformerResponseT, batchCount)
+	var deferredEvents []TransformerEventT
+	var cancelledEvents []TransformerEventT
 
 	wg := sync.WaitGroup{}
-	wg.Add(len(transformResponse))
 	for i := range transformResponse {
 		i := i
 		from := i * batchSize
@@ -234,9 +543,32 @@ func (trans *HandleT) Transform(ctx context.Context, clientEvents []TransformerE
 			to = len(clientEvents)
 		}
 		trans.guardConcurrency <- struct{}{}
+		if deferOnDeadline && isDeadlineNear(ctx) {
+			<-trans.guardConcurrency
+			deferredEvents = clientEvents[from:]
+			trace.Logf(ctx, "request", "deferred_count: %d", len(deferredEvents))
+			break
+		}
+		if ctx.Err() != nil {
+			<-trans.guardConcurrency
+			cancelledEvents = clientEvents[from:]
+			trace.Logf(ctx, "request", "cancelled_count: %d", len(cancelledEvents))
+			break
+		}
+		wg.Add(1)
 		go func() {
 			trace.WithRegion(ctx, "request", func() {
-				transformResponse[i] = trans.request(ctx, url, clientEvents[from:to])
+				requestID := uuid.Must(uuid.NewV4()).String()
+				batchCtx, span := trans.tracer.Start(ctx, "transformer.request")
+				defer span.End()
+				var response []TransformerResponseT
+				if len(urls) == 1 {
+					response = trans.request(batchCtx, urls[0], requestID, clientEvents[from:to], sTags)
+				} else {
+					response = trans.requestMulti(batchCtx, urls, i, requestID, clientEvents[from:to], sTags)
+				}
+				transformResponse[i], orphanResponse[i] = trans.validateResponses(requestID, clientEvents[from:to], response, failMissing)
+				span.SetAttributes(batchSpanAttributes(urls, stage, requestID, to-from, transformResponse[i], orphanResponse[i]))
 			})
 			<-trans.guardConcurrency
 			wg.Done()
@@ -246,6 +578,12 @@ func (trans *HandleT) Transform(ctx context.Context, clientEvents []TransformerE
 
 	var outClientEvents []TransformerResponseT
 	var failedEvents []TransformerResponseT
+	var orphanResponses []TransformerResponseT
+	cancelled := len(cancelledEvents) > 0
+
+	for _, batch := range orphanResponse {
+		orphanResponses = append(orphanResponses, batch...)
+	}
 
 	for _, batch := range transformResponse {
 		if batch == nil {
@@ -256,21 +594,261 @@ func (trans *HandleT) Transform(ctx context.Context, clientEvents []TransformerE
 		//response for each is an array. We flatten it out
 		for _, transformerResponse := range batch {
 			if transformerResponse.StatusCode != 200 {
+				cancelled = cancelled || transformerResponse.Error == CancelledError
 				failedEvents = append(failedEvents, transformerResponse)
 				continue
 			}
 			outClientEvents = append(outClientEvents, transformerResponse)
 		}
 	}
+	failedEvents = append(failedEvents, cancelledResponses(cancelledEvents)...)
+
+	if trans.responseCache != nil {
+		trans.cacheResponses(cacheKeys, outClientEvents, failedEvents)
+		outClientEvents = append(outClientEvents, cachedResponses...)
+	}
+
+	if len(duplicates) > 0 {
+		outClientEvents = fanOutResponses(outClientEvents, duplicates)
+		failedEvents = fanOutResponses(failedEvents, duplicates)
+		deferredEvents = fanOutEvents(deferredEvents, duplicates)
+	}
 
 	trans.receivedStat.Count(len(outClientEvents))
 	trans.failedStat.Count(len(failedEvents))
-	trans.perfStats.Rate(len(clientEvents), time.Since(s))
+	trans.perfStats.Rate(len(clientEvents)-len(deferredEvents)-len(cancelledEvents), time.Since(s))
+	if len(deferredEvents) > 0 {
+		stats.NewTaggedStat("processor.transformer_deferred", stats.CountType, sTags).Count(len(deferredEvents))
+	}
+	if cancelled {
+		stats.NewTaggedStat("processor.transformer_cancelled", stats.CountType, sTags).Increment()
+	}
+	if len(orphanResponses) > 0 {
+		stats.NewTaggedStat("processor.transformer_orphan_responses", stats.CountType, sTags).Count(len(orphanResponses))
+	}
 
 	return ResponseT{
-		Events:       outClientEvents,
-		FailedEvents: failedEvents,
+		Events:          outClientEvents,
+		FailedEvents:    failedEvents,
+		DeferredEvents:  deferredEvents,
+		OrphanResponses: orphanResponses,
+		Cancelled:       cancelled,
+	}
+}
+
+//batchSpanAttributes returns the attributes of the span of a batch, along with the counts of its responses
+func batchSpanAttributes(urls []string, stage, requestID string, batchSize int, responses, orphanResponses []TransformerResponseT) map[string]interface{} {
+	successCount := 0
+	for i := range responses {
+		if responses[i].StatusCode == 200 {
+			successCount++
+		}
+	}
+	attributes := map[string]interface{}{
+		"transformer.request_id":    requestID,
+		"transformer.batch_size":    batchSize,
+		"transformer.success_count": successCount,
+		"transformer.failed_count":  len(responses) - successCount,
+		"transformer.orphan_count":  len(orphanResponses),
+		"transformer.url":           urls[0],
+	}
+	if len(urls) > 1 {
+		attributes["transformer.url"] = strings.Join(urls, ",")
+	}
+	if stage != "" {
+		attributes["transformer.stage"] = stage
+	}
+	return attributes
+}
+
+//cancelledResponses returns failed responses with CancelledError for the events, so that they can be retried
+func cancelledResponses(events []TransformerEventT) []TransformerResponseT {
+	return failedResponses(events, http.StatusInternalServerError, CancelledError)
+}
+
+//failedResponses returns failed responses with the status code and error for the events
+func failedResponses(events []TransformerEventT, statusCode int, errorMessage string) []TransformerResponseT {
+	responses := make([]TransformerResponseT, 0, len(events))
+	for i := range events {
+		responses = append(responses, TransformerResponseT{
+			StatusCode: statusCode,
+			Error:      errorMessage,
+			Metadata:   events[i].Metadata,
+		})
+	}
+	return responses
+}
+
+//dedupeEvents returns the events with only the first of the events sharing a messageID,
+//along with the rest of them grouped by messageID, so that the responses can be fanned out to them later
+func dedupeEvents(clientEvents []TransformerEventT) (uniqueEvents []TransformerEventT, duplicates map[string][]TransformerEventT) {
+	seen := make(map[string]bool, len(clientEvents))
+	uniqueEvents = make([]TransformerEventT, 0, len(clientEvents))
+	for i := range clientEvents {
+		messageID := clientEvents[i].Metadata.MessageID
+		if messageID != "" && seen[messageID] {
+			if duplicates == nil {
+				duplicates = make(map[string][]TransformerEventT)
+			}
+			duplicates[messageID] = append(duplicates[messageID], clientEvents[i])
+			continue
+		}
+		seen[messageID] = true
+		uniqueEvents = append(uniqueEvents, clientEvents[i])
+	}
+	return uniqueEvents, duplicates
+}
+
+//fanOutResponses adds a copy of the responses for each of the duplicate events, carrying the metadata of the duplicate event
+func fanOutResponses(responses []TransformerResponseT, duplicates map[string][]TransformerEventT) []TransformerResponseT {
+	fannedOut := make([]TransformerResponseT, 0, len(responses))
+	for _, response := range responses {
+		fannedOut = append(fannedOut, response)
+		for i := range duplicates[response.Metadata.MessageID] {
+			duplicateResponse := response
+			if response.Output != nil {
+				duplicateResponse.Output = make(map[string]interface{}, len(response.Output))
+				for k, v := range response.Output {
+					duplicateResponse.Output[k] = v
+				}
+			}
+			duplicateResponse.Metadata = duplicates[response.Metadata.MessageID][i].Metadata
+			duplicateResponse.Metadata.MessageIDs = response.Metadata.MessageIDs
+			duplicateResponse.Metadata.TransformerRequestID = response.Metadata.TransformerRequestID
+			fannedOut = append(fannedOut, duplicateResponse)
+		}
+	}
+	return fannedOut
+}
+
+//fanOutEvents adds back the duplicate events of the events
+func fanOutEvents(events []TransformerEventT, duplicates map[string][]TransformerEventT) []TransformerEventT {
+	if len(events) == 0 {
+		return events
+	}
+	fannedOut := make([]TransformerEventT, 0, len(events))
+	for i := range events {
+		fannedOut = append(fannedOut, events[i])
+		fannedOut = append(fannedOut, duplicates[events[i].Metadata.MessageID]...)
+	}
+	return fannedOut
+}
+
+//lookupCachedResponses returns the events without cached responses, along with the cached responses of the rest of the events.
+//The cache keys of the events looked up are returned by their messageID, so that their responses can be cached once transformed.
+//Events without a messageID are always sent to the transformer, since their responses can't be matched with them.
+func (trans *HandleT) lookupCachedResponses(urls []string, clientEvents []TransformerEventT, tags stats.Tags) (misses []TransformerEventT, hits []TransformerResponseT, missKeys map[string][][sha256.Size]byte) {
+	missKeys = make(map[string][][sha256.Size]byte)
+	misses = make([]TransformerEventT, 0, len(clientEvents))
+	hitCount, missCount := 0, 0
+	for i := range clientEvents {
+		messageID := clientEvents[i].Metadata.MessageID
+		if messageID == "" {
+			misses = append(misses, clientEvents[i])
+			continue
+		}
+		key := trans.responseCache.key(urls, &clientEvents[i])
+		if responses, ok := trans.responseCache.get(key); ok {
+			hits = append(hits, responses...)
+			hitCount++
+			continue
+		}
+		missKeys[messageID] = append(missKeys[messageID], key)
+		misses = append(misses, clientEvents[i])
+		missCount++
 	}
+	stats.NewTaggedStat("processor.transformer_cache_hits", stats.CountType, tags).Count(hitCount)
+	stats.NewTaggedStat("processor.transformer_cache_misses", stats.CountType, tags).Count(missCount)
+	return misses, hits, missKeys
+}
+
+//cacheResponses caches the responses of the events looked up in the cache, if all of them succeeded.
+//The responses grouping several events, and the events sharing their messageID with others, aren't cached.
+func (trans *HandleT) cacheResponses(missKeys map[string][][sha256.Size]byte, responses, failedResponses []TransformerResponseT) {
+	uncacheable := make(map[string]bool)
+	for _, response := range failedResponses {
+		uncacheable[response.Metadata.MessageID] = true
+		for _, messageID := range response.Metadata.MessageIDs {
+			uncacheable[messageID] = true
+		}
+	}
+	responsesByMessageID := make(map[string][]TransformerResponseT)
+	for _, response := range responses {
+		if len(response.Metadata.MessageIDs) > 1 {
+			for _, messageID := range response.Metadata.MessageIDs {
+				uncacheable[messageID] = true
+			}
+			continue
+		}
+		messageID := response.Metadata.MessageID
+		if len(response.Metadata.MessageIDs) == 1 {
+			messageID = response.Metadata.MessageIDs[0]
+		}
+		responsesByMessageID[messageID] = append(responsesByMessageID[messageID], response)
+	}
+	for messageID, responses := range responsesByMessageID {
+		if keys := missKeys[messageID]; len(keys) == 1 && !uncacheable[messageID] {
+			trans.responseCache.set(keys[0], responses)
+		}
+	}
+}
+
+//validateResponses matches the responses returned by the transformer with the events sent, using the messageIDs in their metadata.
+//Responses which don't match any of the events sent are returned separately as orphans.
+//If failMissing is true, events for which no response was returned are marked as failed with NoTransformerResponseError,
+//otherwise they are left out as dropped.
+func (trans *HandleT) validateResponses(requestID string, data []TransformerEventT, responses []TransformerResponseT, failMissing bool) (validResponses, orphanResponses []TransformerResponseT) {
+	//the number of responses per messageID, so that a missing response is detected among events sharing a messageID, e.g. without dedup
+	responded := make(map[string]int, len(data))
+	for i := range data {
+		responded[data[i].Metadata.MessageID] = 0
+	}
+
+	for _, response := range responses {
+		messageIDs := response.Metadata.MessageIDs
+		if len(messageIDs) == 0 {
+			messageIDs = []string{response.Metadata.MessageID}
+		}
+
+		isOrphan := false
+		for _, messageID := range messageIDs {
+			if _, ok := responded[messageID]; !ok {
+				isOrphan = true
+				break
+			}
+		}
+		if isOrphan {
+			trans.logger.Errorf("Transformer returned a response for unknown messageIDs: %v, RequestID: %v", messageIDs, requestID)
+			orphanResponses = append(orphanResponses, response)
+			continue
+		}
+
+		for _, messageID := range messageIDs {
+			responded[messageID]++
+		}
+		validResponses = append(validResponses, response)
+	}
+
+	if !failMissing {
+		return validResponses, orphanResponses
+	}
+	//the events sharing a messageID are matched with its responses in order, the ones beyond them are missing a response
+	seen := make(map[string]int, len(data))
+	for i := range data {
+		seen[data[i].Metadata.MessageID]++
+		if seen[data[i].Metadata.MessageID] <= responded[data[i].Metadata.MessageID] {
+			continue
+		}
+		trans.logger.Errorf("Transformer returned no response for messageID: %s, RequestID: %v", data[i].Metadata.MessageID, requestID)
+		metadata := data[i].Metadata
+		metadata.TransformerRequestID = requestID
+		validResponses = append(validResponses, TransformerResponseT{
+			StatusCode: http.StatusInternalServerError,
+			Error:      NoTransformerResponseError,
+			Metadata:   metadata,
+		})
+	}
+	return validResponses, orphanResponses
 }
 
 func (trans *HandleT) Validate(clientEvents []TransformerEventT,
@@ -282,31 +860,28 @@ func (trans *HandleT) requestTime(s stats.Tags, d time.Duration) {
 	stats.NewTaggedStat("processor.transformer_request_time", stats.TimerType, s).SendTiming(d)
 }
 
-func statsTags(event TransformerEventT) stats.Tags {
-	return stats.Tags{
+//statsTags returns the tags of the stats of the events, tagged with stage unless it is empty
+func statsTags(event TransformerEventT, stage string) stats.Tags {
+	tags := stats.Tags{
 		"dest_type": event.Destination.DestinationDefinition.Name,
 		"dest_id":   event.Destination.ID,
 		"src_id":    event.Metadata.SourceID,
 	}
+	if stage != "" {
+		tags["stage"] = stage
+	}
+	return tags
 }
 
-func (trans *HandleT) request(ctx context.Context, url string, data []TransformerEventT) []TransformerResponseT {
+func (trans *HandleT) request(ctx context.Context, url, requestID string, data []TransformerEventT, tags stats.Tags) []TransformerResponseT {
 	//Call remote transformation
-	var (
-		rawJSON []byte
-		err     error
-	)
-
-	trace.WithRegion(ctx, "marshal", func() {
-		rawJSON, err = jsonfast.Marshal(data)
-	})
-	trace.Logf(ctx, "marshal", "request raw body size: %d", len(rawJSON))
-	if err != nil {
-		panic(err)
-	}
+	rawJSON := trans.marshalRequest(ctx, data)
 	retryCount := 0
-	var resp *http.Response
+	var statusCode int
 	var respData []byte
+	var streamed []TransformerResponseT
+	var echoedRequestID string
+	var err error
 	//We should rarely have error communicating with our JS
 	reqFailed := false
 
@@ -314,26 +889,16 @@ func (trans *HandleT) request(ctx context.Context, url string, data []Transforme
 		return nil
 	}
 
-	// assume that the first event is representative
-
 	for {
-		s := time.Now()
-		trace.WithRegion(ctx, "request/post", func() {
-			resp, err = trans.Client.Post(url, "application/json; charset=utf-8", bytes.NewBuffer(rawJSON))
-		})
-		if err == nil {
-			//If no err returned by client.Post, reading body.
-			//If reading body fails, retrying.
-			respData, err = io.ReadAll(resp.Body)
-			resp.Body.Close()
-		}
-
+		statusCode, respData, streamed, echoedRequestID, err = trans.post(ctx, url, rawJSON, requestID, tags)
 		if err != nil {
-			trans.requestTime(statsTags(data[0]), time.Since(s))
 			reqFailed = true
-			trans.logger.Errorf("JS HTTP connection error: URL: %v Error: %+v", url, err)
+			trans.logger.Errorf("JS HTTP connection error: URL: %v RequestID: %v Error: %+v", url, requestID, err)
+			if ctx.Err() != nil {
+				return cancelledResponses(data)
+			}
 			if retryCount > maxRetry {
-				panic(fmt.Errorf("JS HTTP connection error: URL: %v Error: %+v", url, err))
+				panic(fmt.Errorf("JS HTTP connection error: URL: %v RequestID: %v Error: %+v", url, requestID, err))
 			}
 			retryCount++
 			time.Sleep(retrySleep)
@@ -341,35 +906,206 @@ func (trans *HandleT) request(ctx context.Context, url string, data []Transforme
 			continue
 		}
 		if reqFailed {
-			trans.logger.Errorf("Failed request succeeded after %v retries, URL: %v", retryCount, url)
+			trans.logger.Errorf("Failed request succeeded after %v retries, URL: %v RequestID: %v", retryCount, url, requestID)
 		}
+		break
+	}
+
+	return trans.parseResponse(ctx, url, echoedRequestID, data, rawJSON, statusCode, respData, streamed)
+}
+
+//requestMulti sends data to one of the urls, starting from the url at offset.
+//On a connection error, the request is retried on the next url, with the unhealthy urls being tried last.
+func (trans *HandleT) requestMulti(ctx context.Context, urls []string, offset int, requestID string, data []TransformerEventT, tags stats.Tags) []TransformerResponseT {
+	rawJSON := trans.marshalRequest(ctx, data)
+	retryCount := 0
 
-		// perform version compatability check only on success
-		if resp.StatusCode == http.StatusOK {
-			transformerAPIVersion, convErr := strconv.Atoi(resp.Header.Get("apiVersion"))
-			if convErr != nil {
-				transformerAPIVersion = 0
+	if len(data) == 0 {
+		return nil
+	}
+
+	for {
+		for _, url := range trans.urlHealth.order(urls, offset) {
+			statusCode, respData, streamed, echoedRequestID, err := trans.post(ctx, url, rawJSON, requestID, tags)
+			if err != nil {
+				trans.urlHealth.markFailure(url)
+				trans.logger.Errorf("JS HTTP connection error: URL: %v RequestID: %v Error: %+v. Trying the next url", url, requestID, err)
+				continue
 			}
-			if types.SUPPORTED_TRANSFORMER_API_VERSION != transformerAPIVersion {
-				trans.logger.Errorf("Incompatible transformer version: Expected: %d Received: %d, URL: %v", types.SUPPORTED_TRANSFORMER_API_VERSION, transformerAPIVersion, url)
-				panic(fmt.Errorf("Incompatible transformer version: Expected: %d Received: %d, URL: %v", types.SUPPORTED_TRANSFORMER_API_VERSION, transformerAPIVersion, url))
+			trans.urlHealth.markSuccess(url)
+			if retryCount > 0 {
+				trans.logger.Errorf("Failed request succeeded after %v retries, URL: %v RequestID: %v", retryCount, url, requestID)
 			}
+			return trans.parseResponse(ctx, url, echoedRequestID, data, rawJSON, statusCode, respData, streamed)
 		}
 
-		trans.requestTime(statsTags(data[0]), time.Since(s))
-		break
+		if ctx.Err() != nil {
+			return cancelledResponses(data)
+		}
+		if retryCount > maxRetry {
+			panic(fmt.Errorf("JS HTTP connection error on all URLs: %v RequestID: %v", urls, requestID))
+		}
+		retryCount++
+		time.Sleep(retrySleep)
+	}
+}
+
+func (trans *HandleT) marshalRequest(ctx context.Context, data []TransformerEventT) []byte {
+	var (
+		rawJSON []byte
+		err     error
+	)
+
+	trace.WithRegion(ctx, "marshal", func() {
+		rawJSON, err = jsonfast.Marshal(data)
+	})
+	trace.Logf(ctx, "marshal", "request raw body size: %d", len(rawJSON))
+	if err != nil {
+		panic(err)
+	}
+	return rawJSON
+}
+
+//post makes a single request to the transformer and reads the response body.
+//If the response body is larger than maxResponseBytes, it isn't read any further and
+//http.StatusRequestEntityTooLarge is returned along with ResponseTooLargeError as the response.
+//The request carries requestID in RequestIDHeader, and the id echoed by the transformer is returned,
+//falling back to requestID if the transformer didn't echo any.
+//With Processor.Transformer.enableStreaming, a successful response streamed as NDJSON is decoded while being read
+//and returned as streamed instead of respData. A malformed stream is returned as http.StatusBadRequest, same as
+//a malformed array response, and streamed responses aren't captured in the traces.
+func (trans *HandleT) post(ctx context.Context, url string, rawJSON []byte, requestID string, tags stats.Tags) (statusCode int, respData []byte, streamed []TransformerResponseT, echoedRequestID string, err error) {
+	s := time.Now()
+	defer func() { trans.requestTime(tags, time.Since(s)) }()
+	if trans.traces.sample() {
+		defer func() {
+			captured := TraceT{Time: s, URL: url, RequestID: echoedRequestID, Tags: tags, StatusCode: statusCode, Request: rawJSON, Response: respData}
+			if err != nil {
+				captured.Error = err.Error()
+			}
+			trans.traces.add(captured)
+		}()
+	}
+
+	resp, err := trans.send(ctx, url, rawJSON, requestID)
+	if err != nil {
+		return 0, nil, nil, requestID, err
+	}
+	echoedRequestID = resp.Header.Get(RequestIDHeader)
+	if echoedRequestID == "" {
+		echoedRequestID = requestID
+	} else if echoedRequestID != requestID {
+		trans.logger.Debugf("Transformer echoed request id %v for request id %v, URL: %v", echoedRequestID, requestID, url)
+	}
+	//If no err returned by client.Post, reading body.
+	//If reading body fails, retrying.
+	//Closing the body without draining it, if the limit is exceeded, so that the rest of the response isn't read
+	//The limit applies to the decompressed body
+	body, closeBody, err := decodedBody(resp.Header, resp.Body)
+	if err != nil {
+		return 0, nil, nil, echoedRequestID, err
+	}
+	limitedBody := &io.LimitedReader{R: body, N: maxResponseBytes + 1}
+	statusCode = resp.StatusCode
+	if enableStreaming && resp.StatusCode == http.StatusOK && isNDJSON(resp.Header) {
+		streamed, err = decodeNDJSON(limitedBody)
+	} else {
+		respData, err = io.ReadAll(limitedBody)
+	}
+	closeBody()
+	if limitedBody.N <= 0 {
+		trans.logger.Errorf("Transformer response exceeded %d bytes, URL: %v RequestID: %v", maxResponseBytes, url, echoedRequestID)
+		stats.NewTaggedStat("processor.transformer_response_too_large", stats.CountType, tags).Increment()
+		return http.StatusRequestEntityTooLarge, []byte(ResponseTooLargeError), nil, echoedRequestID, nil
+	}
+	if errors.Is(err, errMalformedNDJSON) {
+		trans.logger.Errorf("Data sent to transformer (RequestID: %v) : %v", echoedRequestID, string(rawJSON))
+		trans.logger.Errorf("Transformer streamed a malformed response (RequestID: %v) : %v", echoedRequestID, err)
+		statusCode = http.StatusBadRequest
+		respData = []byte(fmt.Sprintf("Failed to unmarshal transformer response: %v", err))
+	} else if err != nil {
+		return 0, nil, nil, echoedRequestID, err
+	}
+
+	// perform version compatability check only on success
+	if resp.StatusCode == http.StatusOK {
+		transformerAPIVersion, convErr := strconv.Atoi(resp.Header.Get("apiVersion"))
+		if convErr != nil {
+			transformerAPIVersion = 0
+		}
+		if types.SUPPORTED_TRANSFORMER_API_VERSION != transformerAPIVersion {
+			trans.logger.Errorf("Incompatible transformer version: Expected: %d Received: %d, URL: %v RequestID: %v", types.SUPPORTED_TRANSFORMER_API_VERSION, transformerAPIVersion, url, echoedRequestID)
+			panic(fmt.Errorf("Incompatible transformer version: Expected: %d Received: %d, URL: %v RequestID: %v", types.SUPPORTED_TRANSFORMER_API_VERSION, transformerAPIVersion, url, echoedRequestID))
+		}
+	}
+	return statusCode, respData, streamed, echoedRequestID, nil
+}
+
+//send posts rawJSON to the transformer. With Processor.Transformer.enableCompression, the body is gzipped
+//once the url has advertised support for it, and gzipped responses are asked for, which post decompresses rather than the client.
+func (trans *HandleT) send(ctx context.Context, url string, rawJSON []byte, requestID string) (*http.Response, error) {
+	compressed := enableCompression && trans.compressionSupport.supportsGzip(url)
+	reqBody := rawJSON
+	if compressed {
+		var err error
+		if reqBody, err = gzipBody(rawJSON); err != nil {
+			return nil, err
+		}
+	}
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewBuffer(reqBody))
+	if err != nil {
+		return nil, err
 	}
+	//the custom headers are set first, so that they can't override the ones the protocol depends on
+	trans.requestHeaders.apply(req.Header)
+	req.Header.Set("Content-Type", "application/json; charset=utf-8")
+	req.Header.Set(RequestIDHeader, requestID)
+	if compressed {
+		req.Header.Set("Content-Encoding", gzipEncoding)
+	}
+	if enableCompression {
+		req.Header.Set("Accept-Encoding", gzipEncoding)
+	}
+	if enableStreaming {
+		//the transformers not supporting streaming keep responding with arrays
+		req.Header.Set("Accept", ndjsonContentType+", application/json")
+	}
+	trans.tracer.Inject(ctx, req.Header)
 
+	var resp *http.Response
+	trace.WithRegion(ctx, "request/post", func() {
+		resp, err = trans.Client.Do(req)
+	})
+	if err != nil || !enableCompression {
+		return resp, err
+	}
+	//The url may have stopped accepting gzip, e.g. after a rollback, in which case the request is resent uncompressed
+	if compressed && resp.StatusCode == http.StatusUnsupportedMediaType {
+		resp.Body.Close()
+		trans.compressionSupport.set(url, false)
+		trans.logger.Infof("Transformer doesn't accept gzipped requests anymore, resending uncompressed, URL: %v RequestID: %v", url, requestID)
+		return trans.send(ctx, url, rawJSON, requestID)
+	}
+	trans.compressionSupport.set(url, acceptsGzip(resp.Header))
+	return resp, nil
+}
+
+//parseResponse returns the responses of a batch, with their metadata carrying the request id of the batch.
+//The responses already decoded from a stream by post are used as they are, instead of unmarshalling respData.
+func (trans *HandleT) parseResponse(ctx context.Context, url, requestID string, data []TransformerEventT, rawJSON []byte, statusCode int, respData []byte, streamed []TransformerResponseT) []TransformerResponseT {
 	// Remove Assertion?
-	if !(resp.StatusCode == http.StatusOK ||
-		resp.StatusCode == http.StatusBadRequest ||
-		resp.StatusCode == http.StatusNotFound ||
-		resp.StatusCode == http.StatusRequestEntityTooLarge) {
-		trans.logger.Errorf("Transformer returned status code: %v", resp.StatusCode)
+	if !(statusCode == http.StatusOK ||
+		statusCode == http.StatusBadRequest ||
+		statusCode == http.StatusNotFound ||
+		statusCode == http.StatusRequestEntityTooLarge) {
+		trans.logger.Errorf("Transformer returned status code: %v, URL: %v RequestID: %v", statusCode, url, requestID)
 	}
 
+	var err error
 	var transformerResponses []TransformerResponseT
-	if resp.StatusCode == http.StatusOK {
+	if statusCode == http.StatusOK && streamed != nil {
+		transformerResponses = streamed
+	} else if statusCode == http.StatusOK {
 		integrations.CollectIntgTransformErrorStats(respData)
 
 		trace.Logf(ctx, "Unmarshal", "response raw size: %d", len(respData))
@@ -379,20 +1115,23 @@ func (trans *HandleT) request(ctx context.Context, url string, data []Transforme
 		//This is returned by our JS engine so should  be parsable
 		//but still handling it
 		if err != nil {
-			trans.logger.Errorf("Data sent to transformer : %v", string(rawJSON))
-			trans.logger.Errorf("Transformer returned : %v", string(respData))
+			trans.logger.Errorf("Data sent to transformer (RequestID: %v) : %v", requestID, string(rawJSON))
+			trans.logger.Errorf("Transformer returned (RequestID: %v) : %v", requestID, string(respData))
 			respData = []byte(fmt.Sprintf("Failed to unmarshal transformer response: %s", string(respData)))
 			transformerResponses = nil
-			resp.StatusCode = 400
+			statusCode = 400
 		}
 	}
 
-	if resp.StatusCode != http.StatusOK {
+	if statusCode != http.StatusOK {
 		for i := range data {
 			transformEvent := &data[i]
-			resp := TransformerResponseT{StatusCode: resp.StatusCode, Error: string(respData), Metadata: transformEvent.Metadata}
+			resp := TransformerResponseT{StatusCode: statusCode, Error: string(respData), Metadata: transformEvent.Metadata}
 			transformerResponses = append(transformerResponses, resp)
 		}
 	}
+	for i := range transformerResponses {
+		transformerResponses[i].Metadata.TransformerRequestID = requestID
+	}
 	return transformerResponses
 }
@@ -1,14 +1,29 @@
 package transformer_test
 
 import (
+	"bytes"
+	"compress/gzip"
 	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
 	"encoding/json"
 	"fmt"
+	"math/big"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/rudderlabs/rudder-server/config"
+	backendconfig "github.com/rudderlabs/rudder-server/config/backend-config"
+	"github.com/rudderlabs/rudder-server/processor/integrations"
 	"github.com/rudderlabs/rudder-server/processor/transformer"
 	"github.com/rudderlabs/rudder-server/services/stats"
 	"github.com/rudderlabs/rudder-server/utils/logger"
@@ -17,37 +32,66 @@ import (
 
 type fakeTransformer struct {
 	requests [][]transformer.TransformerEventT
+
+	omitMessageID   string
+	orphanMessageID string
+	//omitOnce omits only the first event with omitMessageID
+	omitOnce bool
 }
 
 func (t *fakeTransformer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, err := transformer.ReadRequestBody(r)
+	if err != nil {
+		panic(err)
+	}
 	var reqBody []transformer.TransformerEventT
-	if err := json.NewDecoder(r.Body).Decode(&reqBody); err != nil {
+	if err := json.Unmarshal(body, &reqBody); err != nil {
 		panic(err)
 	}
 
 	t.requests = append(t.requests, reqBody)
-	resps := make([]transformer.TransformerResponseT, len(reqBody))
+	resps := make([]transformer.TransformerResponseT, 0, len(reqBody))
+	omitted := false
 	for i := range reqBody {
+		if t.omitMessageID != "" && reqBody[i].Metadata.MessageID == t.omitMessageID && !(t.omitOnce && omitted) {
+			omitted = true
+			continue
+		}
 		statusCode := int(reqBody[i].Message["forceStatusCode"].(float64))
 		delete(reqBody[i].Message, "forceStatusCode")
 		reqBody[i].Message["echo-key-1"] = reqBody[i].Message["src-key-1"]
 
-		resps[i] = transformer.TransformerResponseT{
+		resp := transformer.TransformerResponseT{
 			Output:     reqBody[i].Message,
 			Metadata:   reqBody[i].Metadata,
 			StatusCode: statusCode,
 			Error:      "",
 		}
 		if statusCode >= 400 {
-			resps[i].Error = "error"
+			resp.Error = "error"
 		}
+		resps = append(resps, resp)
+	}
+	if t.orphanMessageID != "" {
+		resps = append(resps, transformer.TransformerResponseT{
+			Metadata:   transformer.MetadataT{MessageID: t.orphanMessageID},
+			StatusCode: 200,
+		})
 	}
 	w.Header().Set("apiVersion", "2")
+	w.Header().Set(transformer.RequestIDHeader, r.Header.Get(transformer.RequestIDHeader))
 	if err := json.NewEncoder(w).Encode(resps); err != nil {
 		panic(err)
 	}
 }
 
+//clearRequestIDs clears the random request ids from the metadata of the responses, so that they can be compared
+func clearRequestIDs(responses []transformer.TransformerResponseT) {
+	for i := range responses {
+		responses[i].Metadata.TransformerRequestID = ""
+	}
+}
+
 func Test_Transformer(t *testing.T) {
 	config.Load()
 	logger.Init()
@@ -125,6 +169,1133 @@ func Test_Transformer(t *testing.T) {
 		}
 
 		rsp := tr.Transform(context.TODO(), events, srv.URL, batchSize)
+		clearRequestIDs(rsp.Events)
+		clearRequestIDs(rsp.FailedEvents)
 		require.Equal(t, expectedResponse, rsp)
 	}
 }
+
+func Test_TransformerMissingResponses(t *testing.T) {
+	config.Load()
+	logger.Init()
+	stats.Setup()
+	transformer.Init()
+
+	ft := &fakeTransformer{omitMessageID: "messageID-3", orphanMessageID: "messageID-unknown"}
+
+	srv := httptest.NewServer(ft)
+	defer srv.Close()
+	os.Setenv("DEST_TRANSFORM_URL", srv.URL)
+	defer os.Unsetenv("DEST_TRANSFORM_URL")
+	integrations.Init()
+
+	tr := transformer.NewTransformer()
+	tr.Client = srv.Client()
+	tr.Setup()
+
+	events := func() []transformer.TransformerEventT {
+		events := make([]transformer.TransformerEventT, 5)
+		for i := range events {
+			msgID := fmt.Sprintf("messageID-%d", i)
+			events[i] = transformer.TransformerEventT{
+				Metadata: transformer.MetadataT{
+					MessageID: msgID,
+				},
+				Message: map[string]interface{}{
+					"src-key-1":       msgID,
+					"forceStatusCode": 200,
+				},
+			}
+		}
+		return events
+	}
+
+	requireFailed := func(t *testing.T, rsp transformer.ResponseT) {
+		require.Len(t, rsp.Events, 4)
+		require.NotEmpty(t, rsp.FailedEvents[0].Metadata.TransformerRequestID)
+		clearRequestIDs(rsp.FailedEvents)
+		require.Equal(t, []transformer.TransformerResponseT{{
+			Metadata:   transformer.MetadataT{MessageID: "messageID-3"},
+			StatusCode: http.StatusInternalServerError,
+			Error:      transformer.NoTransformerResponseError,
+		}}, rsp.FailedEvents)
+		require.Len(t, rsp.OrphanResponses, 1)
+		require.Equal(t, "messageID-unknown", rsp.OrphanResponses[0].Metadata.MessageID)
+	}
+
+	requireDropped := func(t *testing.T, rsp transformer.ResponseT) {
+		require.Len(t, rsp.Events, 4)
+		require.Empty(t, rsp.FailedEvents)
+		for _, event := range rsp.Events {
+			require.NotEqual(t, "messageID-3", event.Metadata.MessageID)
+		}
+		require.Len(t, rsp.OrphanResponses, 1)
+		require.Equal(t, "messageID-unknown", rsp.OrphanResponses[0].Metadata.MessageID)
+	}
+
+	t.Run("events dropped by user transformations stay dropped", func(t *testing.T) {
+		requireDropped(t, tr.UserTransform(context.TODO(), events(), 10))
+	})
+
+	t.Run("events without a response are dropped by default", func(t *testing.T) {
+		requireDropped(t, tr.Transform(context.TODO(), events(), srv.URL, 10))
+	})
+
+	t.Run("events without a response of destination transformations fail", func(t *testing.T) {
+		requireFailed(t, tr.DestinationTransform(context.TODO(), events(), "WEBHOOK", 10))
+	})
+
+	t.Run("events without a response fail with failMissingResponses", func(t *testing.T) {
+		os.Setenv("RSERVER_PROCESSOR_TRANSFORMER_FAIL_MISSING_RESPONSES", "true")
+		defer os.Unsetenv("RSERVER_PROCESSOR_TRANSFORMER_FAIL_MISSING_RESPONSES")
+		config.Load()
+		transformer.Init()
+
+		requireFailed(t, tr.Transform(context.TODO(), events(), srv.URL, 10))
+	})
+
+	t.Run("an event without a response fails among events sharing its messageID", func(t *testing.T) {
+		ft.omitOnce = true
+		defer func() { ft.omitOnce = false }()
+
+		duplicated := append(events(), events()[3])
+		rsp := tr.DestinationTransform(context.TODO(), duplicated, "WEBHOOK", 10)
+		require.Len(t, rsp.Events, 5)
+		clearRequestIDs(rsp.FailedEvents)
+		require.Equal(t, []transformer.TransformerResponseT{{
+			Metadata:   transformer.MetadataT{MessageID: "messageID-3"},
+			StatusCode: http.StatusInternalServerError,
+			Error:      transformer.NoTransformerResponseError,
+		}}, rsp.FailedEvents)
+	})
+}
+
+func Test_TransformMulti(t *testing.T) {
+	config.Load()
+	logger.Init()
+	stats.Setup()
+	transformer.Init()
+
+	srv := httptest.NewServer(&fakeTransformer{})
+	defer srv.Close()
+
+	downSrv := httptest.NewServer(&fakeTransformer{})
+	downURL := downSrv.URL
+	downSrv.Close()
+
+	tr := transformer.NewTransformer()
+	tr.Client = srv.Client()
+	tr.Setup()
+
+	events := make([]transformer.TransformerEventT, 50)
+	for i := range events {
+		msgID := fmt.Sprintf("messageID-%d", i)
+		events[i] = transformer.TransformerEventT{
+			Metadata: transformer.MetadataT{
+				MessageID: msgID,
+			},
+			Message: map[string]interface{}{
+				"src-key-1":       msgID,
+				"forceStatusCode": 200,
+			},
+		}
+	}
+
+	rsp := tr.TransformMulti(context.TODO(), events, []string{downURL, srv.URL}, 5)
+	require.Len(t, rsp.Events, len(events))
+	require.Empty(t, rsp.FailedEvents)
+	for i := range events {
+		require.Equal(t, events[i].Metadata.MessageID, rsp.Events[i].Metadata.MessageID)
+	}
+
+	t.Run("fails the events without any url", func(t *testing.T) {
+		rsp := tr.TransformMulti(context.TODO(), events[:2], nil, 5)
+		require.Empty(t, rsp.Events)
+		require.Equal(t, []transformer.TransformerResponseT{
+			{StatusCode: http.StatusBadRequest, Error: transformer.NoTransformerURLError, Metadata: events[0].Metadata},
+			{StatusCode: http.StatusBadRequest, Error: transformer.NoTransformerURLError, Metadata: events[1].Metadata},
+		}, rsp.FailedEvents)
+	})
+}
+
+func Test_TransformerResponseTooLarge(t *testing.T) {
+	os.Setenv("RSERVER_PROCESSOR_TRANSFORMER_MAX_RESPONSE_BYTES", "1024")
+	defer os.Unsetenv("RSERVER_PROCESSOR_TRANSFORMER_MAX_RESPONSE_BYTES")
+
+	config.Load()
+	logger.Init()
+	stats.Setup()
+	transformer.Init()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("apiVersion", "2")
+		w.Write([]byte(`[{"output":{"key":"` + strings.Repeat("a", 4096) + `"},"statusCode":200}]`))
+	}))
+	defer srv.Close()
+
+	tr := transformer.NewTransformer()
+	tr.Client = srv.Client()
+	tr.Setup()
+
+	events := []transformer.TransformerEventT{{
+		Metadata: transformer.MetadataT{
+			MessageID: "messageID-1",
+		},
+		Message: map[string]interface{}{
+			"src-key-1": "messageID-1",
+		},
+	}}
+
+	rsp := tr.Transform(context.TODO(), events, srv.URL, 10)
+	require.Empty(t, rsp.Events)
+	clearRequestIDs(rsp.FailedEvents)
+	require.Equal(t, []transformer.TransformerResponseT{{
+		Metadata:   transformer.MetadataT{MessageID: "messageID-1"},
+		StatusCode: http.StatusRequestEntityTooLarge,
+		Error:      transformer.ResponseTooLargeError,
+	}}, rsp.FailedEvents)
+}
+
+type slowTransformer struct {
+	fakeTransformer
+	delay time.Duration
+}
+
+func (t *slowTransformer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	time.Sleep(t.delay)
+	t.fakeTransformer.ServeHTTP(w, r)
+}
+
+func Test_TransformWithDeadline(t *testing.T) {
+	os.Setenv("RSERVER_PROCESSOR_MAX_CONCURRENCY", "1")
+	defer os.Unsetenv("RSERVER_PROCESSOR_MAX_CONCURRENCY")
+
+	config.Load()
+	logger.Init()
+	stats.Setup()
+	transformer.Init()
+
+	srv := httptest.NewServer(&slowTransformer{delay: 50 * time.Millisecond})
+	defer srv.Close()
+
+	tr := transformer.NewTransformer()
+	tr.Client = srv.Client()
+	tr.Setup()
+
+	events := make([]transformer.TransformerEventT, 50)
+	for i := range events {
+		msgID := fmt.Sprintf("messageID-%d", i)
+		events[i] = transformer.TransformerEventT{
+			Metadata: transformer.MetadataT{
+				MessageID: msgID,
+			},
+			Message: map[string]interface{}{
+				"src-key-1":       msgID,
+				"forceStatusCode": 200,
+			},
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 250*time.Millisecond)
+	defer cancel()
+
+	rsp := tr.TransformWithDeadline(ctx, events, srv.URL, 5)
+	require.NotEmpty(t, rsp.Events)
+	require.NotEmpty(t, rsp.DeferredEvents)
+	require.Empty(t, rsp.FailedEvents)
+	require.Equal(t, len(events), len(rsp.Events)+len(rsp.DeferredEvents))
+	require.Equal(t, events[len(rsp.Events):], rsp.DeferredEvents)
+}
+
+func Test_TransformerDedupeByMessageID(t *testing.T) {
+	os.Setenv("RSERVER_PROCESSOR_TRANSFORMER_DEDUPE_BY_MESSAGE_ID", "true")
+	defer os.Unsetenv("RSERVER_PROCESSOR_TRANSFORMER_DEDUPE_BY_MESSAGE_ID")
+
+	config.Load()
+	logger.Init()
+	stats.Setup()
+	transformer.Init()
+
+	ft := &fakeTransformer{}
+
+	srv := httptest.NewServer(ft)
+	defer srv.Close()
+
+	tr := transformer.NewTransformer()
+	tr.Client = srv.Client()
+	tr.Setup()
+
+	events := make([]transformer.TransformerEventT, 2)
+	for i := range events {
+		events[i] = transformer.TransformerEventT{
+			Metadata: transformer.MetadataT{
+				MessageID: "messageID-1",
+				JobID:     int64(i + 1),
+			},
+			Message: map[string]interface{}{
+				"src-key-1":       "messageID-1",
+				"forceStatusCode": 200,
+			},
+		}
+	}
+
+	rsp := tr.Transform(context.TODO(), events, srv.URL, 10)
+	require.Len(t, ft.requests, 1)
+	require.Len(t, ft.requests[0], 1)
+	require.Empty(t, rsp.FailedEvents)
+	require.Len(t, rsp.Events, 2)
+	require.NotEmpty(t, rsp.Events[0].Metadata.TransformerRequestID)
+	require.Equal(t, rsp.Events[0].Metadata.TransformerRequestID, rsp.Events[1].Metadata.TransformerRequestID)
+	clearRequestIDs(rsp.Events)
+	for i := range rsp.Events {
+		require.Equal(t, events[i].Metadata, rsp.Events[i].Metadata)
+		require.Equal(t, "messageID-1", rsp.Events[i].Output["echo-key-1"])
+	}
+}
+
+func Test_TransformerResponseCache(t *testing.T) {
+	os.Setenv("RSERVER_PROCESSOR_TRANSFORMER_ENABLE_TRANSFORM_CACHE", "true")
+	defer os.Unsetenv("RSERVER_PROCESSOR_TRANSFORMER_ENABLE_TRANSFORM_CACHE")
+
+	newEvent := func(messageID string, statusCode int) transformer.TransformerEventT {
+		return transformer.TransformerEventT{
+			Metadata: transformer.MetadataT{MessageID: messageID, JobID: 1},
+			Message: map[string]interface{}{
+				"src-key-1":       messageID,
+				"forceStatusCode": statusCode,
+			},
+		}
+	}
+
+	setup := func(t *testing.T, cacheSize string) (*transformer.HandleT, *fakeTransformer, string) {
+		os.Setenv("RSERVER_PROCESSOR_TRANSFORMER_TRANSFORM_CACHE_SIZE", cacheSize)
+		t.Cleanup(func() { os.Unsetenv("RSERVER_PROCESSOR_TRANSFORMER_TRANSFORM_CACHE_SIZE") })
+		config.Load()
+		logger.Init()
+		stats.Setup()
+		transformer.Init()
+
+		ft := &fakeTransformer{}
+		srv := httptest.NewServer(ft)
+		t.Cleanup(srv.Close)
+
+		tr := transformer.NewTransformer()
+		tr.Client = srv.Client()
+		tr.Setup()
+		return tr, ft, srv.URL
+	}
+
+	t.Run("identical events are transformed once", func(t *testing.T) {
+		tr, ft, url := setup(t, "10")
+
+		first := tr.Transform(context.TODO(), []transformer.TransformerEventT{newEvent("messageID-1", 200)}, url, 10)
+		second := tr.Transform(context.TODO(), []transformer.TransformerEventT{newEvent("messageID-1", 200)}, url, 10)
+		require.Len(t, ft.requests, 1)
+		require.Len(t, second.Events, 1)
+		require.Equal(t, first.Events, second.Events)
+		require.Equal(t, "messageID-1", second.Events[0].Output["echo-key-1"])
+
+		//a different event isn't served from the cache
+		tr.Transform(context.TODO(), []transformer.TransformerEventT{newEvent("messageID-2", 200)}, url, 10)
+		require.Len(t, ft.requests, 2)
+	})
+
+	t.Run("failed events aren't cached", func(t *testing.T) {
+		tr, ft, url := setup(t, "10")
+
+		events := []transformer.TransformerEventT{newEvent("messageID-1", 200), newEvent("messageID-2", 400)}
+		tr.Transform(context.TODO(), events, url, 10)
+		rsp := tr.Transform(context.TODO(), events, url, 10)
+		require.Len(t, ft.requests, 2)
+		require.Len(t, ft.requests[1], 1)
+		require.Equal(t, "messageID-2", ft.requests[1][0].Metadata.MessageID)
+		require.Len(t, rsp.Events, 1)
+		require.Len(t, rsp.FailedEvents, 1)
+	})
+
+	t.Run("least recently used events are evicted", func(t *testing.T) {
+		tr, ft, url := setup(t, "1")
+
+		for _, messageID := range []string{"messageID-1", "messageID-2", "messageID-2", "messageID-1"} {
+			tr.Transform(context.TODO(), []transformer.TransformerEventT{newEvent(messageID, 200)}, url, 10)
+		}
+		require.Len(t, ft.requests, 3)
+	})
+}
+
+func Test_TransformerRequestID(t *testing.T) {
+	config.Load()
+	logger.Init()
+	stats.Setup()
+	transformer.Init()
+
+	var (
+		mu         sync.Mutex
+		requestIDs []string
+	)
+	ft := &fakeTransformer{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		requestIDs = append(requestIDs, r.Header.Get(transformer.RequestIDHeader))
+		ft.ServeHTTP(w, r)
+	}))
+	defer srv.Close()
+
+	tr := transformer.NewTransformer()
+	tr.Client = srv.Client()
+	tr.Setup()
+
+	events := make([]transformer.TransformerEventT, 10)
+	for i := range events {
+		msgID := fmt.Sprintf("messageID-%d", i)
+		events[i] = transformer.TransformerEventT{
+			Metadata: transformer.MetadataT{
+				MessageID: msgID,
+			},
+			Message: map[string]interface{}{
+				"src-key-1":       msgID,
+				"forceStatusCode": 200,
+			},
+		}
+	}
+
+	rsp := tr.Transform(context.TODO(), events, srv.URL, 5)
+	require.Len(t, rsp.Events, len(events))
+	require.Len(t, requestIDs, 2)
+	require.NotEmpty(t, requestIDs[0])
+	require.NotEqual(t, requestIDs[0], requestIDs[1])
+
+	//batches are sent concurrently, so the requests can arrive in any order
+	batchRequestIDs := map[string]bool{}
+	for i := range rsp.Events {
+		requestID := rsp.Events[i].Metadata.TransformerRequestID
+		require.Contains(t, requestIDs, requestID)
+		require.Equal(t, rsp.Events[i/5*5].Metadata.TransformerRequestID, requestID)
+		batchRequestIDs[requestID] = true
+	}
+	require.Len(t, batchRequestIDs, 2)
+}
+
+func Test_TransformerRequestHeaders(t *testing.T) {
+	os.Setenv("RSERVER_PROCESSOR_TRANSFORMER_REQUEST_HEADERS", `{"X-Workspace-Token": "from-config"}`)
+	defer os.Unsetenv("RSERVER_PROCESSOR_TRANSFORMER_REQUEST_HEADERS")
+
+	config.Load()
+	logger.Init()
+	stats.Setup()
+	transformer.Init()
+
+	var (
+		mu      sync.Mutex
+		headers []http.Header
+	)
+	ft := &fakeTransformer{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		headers = append(headers, r.Header.Clone())
+		ft.ServeHTTP(w, r)
+	}))
+	defer srv.Close()
+
+	events := []transformer.TransformerEventT{{
+		Metadata: transformer.MetadataT{MessageID: "messageID-1"},
+		Message:  map[string]interface{}{"src-key-1": "messageID-1", "forceStatusCode": 200},
+	}}
+	transform := func(t *testing.T, tr *transformer.HandleT) http.Header {
+		headers = nil
+		rsp := tr.Transform(context.TODO(), events, srv.URL, 10)
+		require.Len(t, rsp.Events, 1)
+		require.Len(t, headers, 1)
+		return headers[0]
+	}
+
+	t.Run("loaded from the config at setup", func(t *testing.T) {
+		tr := transformer.NewTransformer()
+		tr.Client = srv.Client()
+		tr.Setup()
+
+		header := transform(t, tr)
+		require.Equal(t, "from-config", header.Get("X-Workspace-Token"))
+		require.Equal(t, "application/json; charset=utf-8", header.Get("Content-Type"))
+		require.NotEmpty(t, header.Get(transformer.RequestIDHeader))
+	})
+
+	t.Run("set before setup, taking precedence over the config", func(t *testing.T) {
+		tr := transformer.NewTransformer()
+		tr.Client = srv.Client()
+		tr.SetRequestHeaders(map[string]string{"Authorization": "Bearer secret"})
+		tr.Setup()
+
+		header := transform(t, tr)
+		require.Equal(t, "Bearer secret", header.Get("Authorization"))
+		require.Empty(t, header.Get("X-Workspace-Token"))
+	})
+
+	t.Run("replaced after setup, without overriding the protocol headers", func(t *testing.T) {
+		tr := transformer.NewTransformer()
+		tr.Client = srv.Client()
+		tr.Setup()
+		tr.SetRequestHeaders(map[string]string{"Authorization": "Bearer rotated", "Content-Type": "text/plain"})
+
+		header := transform(t, tr)
+		require.Equal(t, "Bearer rotated", header.Get("Authorization"))
+		require.Empty(t, header.Get("X-Workspace-Token"))
+		require.Equal(t, "application/json; charset=utf-8", header.Get("Content-Type"))
+	})
+}
+
+func Test_TransformerTLSConfig(t *testing.T) {
+	config.Load()
+	logger.Init()
+	stats.Setup()
+	transformer.Init()
+
+	clientCert := newClientCertificate(t, "rudder-processor")
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(clientCert.Leaf)
+
+	var peerCommonNames []string
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for _, cert := range r.TLS.PeerCertificates {
+			peerCommonNames = append(peerCommonNames, cert.Subject.CommonName)
+		}
+		(&fakeTransformer{}).ServeHTTP(w, r)
+	}))
+	srv.TLS = &tls.Config{
+		ClientAuth: tls.RequireAndVerifyClientCert,
+		ClientCAs:  clientCAs,
+	}
+	srv.StartTLS()
+	defer srv.Close()
+
+	rootCAs := x509.NewCertPool()
+	rootCAs.AddCert(srv.Certificate())
+
+	tr := transformer.NewTransformer()
+	tr.SetTLSConfig(&tls.Config{
+		Certificates: []tls.Certificate{clientCert},
+		RootCAs:      rootCAs,
+	})
+	tr.Setup()
+
+	events := []transformer.TransformerEventT{{
+		Metadata: transformer.MetadataT{
+			MessageID: "messageID-1",
+		},
+		Message: map[string]interface{}{
+			"src-key-1":       "messageID-1",
+			"forceStatusCode": 200,
+		},
+	}}
+
+	rsp := tr.Transform(context.TODO(), events, srv.URL, 10)
+	require.Len(t, rsp.Events, 1)
+	require.Empty(t, rsp.FailedEvents)
+	require.Equal(t, []string{"rudder-processor"}, peerCommonNames)
+}
+
+//newClientCertificate returns a self signed client certificate with the given common name
+func newClientCertificate(t *testing.T, commonName string) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: commonName},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	leaf, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}
+}
+
+func Test_TransformCancelled(t *testing.T) {
+	os.Setenv("RSERVER_PROCESSOR_MAX_CONCURRENCY", "1")
+	defer os.Unsetenv("RSERVER_PROCESSOR_MAX_CONCURRENCY")
+
+	config.Load()
+	logger.Init()
+	stats.Setup()
+	transformer.Init()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	ft := &fakeTransformer{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		//cancelling while the first batch is in flight, which is still expected to complete
+		cancel()
+		ft.ServeHTTP(w, r)
+	}))
+	defer srv.Close()
+
+	tr := transformer.NewTransformer()
+	tr.Client = srv.Client()
+	tr.Setup()
+
+	events := make([]transformer.TransformerEventT, 12)
+	for i := range events {
+		msgID := fmt.Sprintf("messageID-%d", i)
+		events[i] = transformer.TransformerEventT{
+			Metadata: transformer.MetadataT{
+				MessageID: msgID,
+			},
+			Message: map[string]interface{}{
+				"src-key-1":       msgID,
+				"forceStatusCode": 200,
+			},
+		}
+	}
+
+	rsp := tr.Transform(ctx, events, srv.URL, 5)
+	require.True(t, rsp.Cancelled)
+	require.Len(t, ft.requests, 1, "no batch should be sent after cancellation")
+
+	require.Len(t, rsp.Events, 5)
+	for i := range rsp.Events {
+		require.Equal(t, events[i].Metadata.MessageID, rsp.Events[i].Metadata.MessageID)
+	}
+
+	require.Len(t, rsp.FailedEvents, 7)
+	for i := range rsp.FailedEvents {
+		require.Equal(t, events[5+i].Metadata, rsp.FailedEvents[i].Metadata)
+		require.Equal(t, transformer.CancelledError, rsp.FailedEvents[i].Error)
+		require.Equal(t, http.StatusInternalServerError, rsp.FailedEvents[i].StatusCode)
+	}
+}
+
+func Test_TransformerHealthCheck(t *testing.T) {
+	os.Setenv("RSERVER_PROCESSOR_TRANSFORMER_HEALTH_CHECK_ENABLED", "true")
+	defer os.Unsetenv("RSERVER_PROCESSOR_TRANSFORMER_HEALTH_CHECK_ENABLED")
+	os.Setenv("RSERVER_PROCESSOR_TRANSFORMER_HEALTH_CHECK_TIMEOUT", "2s")
+	defer os.Unsetenv("RSERVER_PROCESSOR_TRANSFORMER_HEALTH_CHECK_TIMEOUT")
+
+	config.Load()
+	logger.Init()
+	stats.Setup()
+	transformer.Init()
+
+	setup := func(t *testing.T, handler http.HandlerFunc) *transformer.HandleT {
+		srv := httptest.NewServer(handler)
+		t.Cleanup(srv.Close)
+		os.Setenv("DEST_TRANSFORM_URL", srv.URL)
+		t.Cleanup(func() { os.Unsetenv("DEST_TRANSFORM_URL") })
+		integrations.Init()
+
+		tr := transformer.NewTransformer()
+		tr.Client = srv.Client()
+		tr.Setup()
+		return tr
+	}
+
+	t.Run("healthy after retries", func(t *testing.T) {
+		var requests int
+		tr := setup(t, func(w http.ResponseWriter, r *http.Request) {
+			require.Equal(t, "/health", r.URL.Path)
+			requests++
+			if requests < 3 {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			w.WriteHeader(http.StatusOK)
+		})
+		require.Equal(t, 3, requests)
+		require.True(t, tr.Healthy())
+	})
+
+	t.Run("unavailable after the timeout", func(t *testing.T) {
+		start := time.Now()
+		tr := setup(t, func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		})
+		require.False(t, tr.Healthy(), "setup shouldn't fail, but the transformer should be marked as unavailable")
+		require.Less(t, time.Since(start), 10*time.Second)
+	})
+}
+
+func Test_UserAndDestinationTransform(t *testing.T) {
+	config.Load()
+	logger.Init()
+	stats.Setup()
+	transformer.Init()
+
+	var (
+		pathsLock sync.Mutex
+		paths     []string
+	)
+	ft := &fakeTransformer{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		pathsLock.Lock()
+		paths = append(paths, r.URL.Path)
+		pathsLock.Unlock()
+		ft.ServeHTTP(w, r)
+	}))
+	defer srv.Close()
+	os.Setenv("DEST_TRANSFORM_URL", srv.URL)
+	defer os.Unsetenv("DEST_TRANSFORM_URL")
+	integrations.Init()
+
+	tr := transformer.NewTransformer()
+	tr.Client = srv.Client()
+	tr.Setup()
+
+	events := func() []transformer.TransformerEventT {
+		return []transformer.TransformerEventT{{
+			Metadata: transformer.MetadataT{MessageID: "messageID-1"},
+			Message: map[string]interface{}{
+				"src-key-1":       "messageID-1",
+				"forceStatusCode": 200,
+			},
+		}}
+	}
+
+	t.Run("user transformations", func(t *testing.T) {
+		paths = nil
+		rsp := tr.UserTransform(context.TODO(), events(), 10)
+		require.Len(t, rsp.Events, 1)
+		require.Equal(t, []string{"/customTransform"}, paths)
+	})
+
+	t.Run("destination transformations", func(t *testing.T) {
+		paths = nil
+		rsp := tr.DestinationTransform(context.TODO(), events(), "WEBHOOK", 10)
+		require.Len(t, rsp.Events, 1)
+		require.Equal(t, []string{"/v0/webhook"}, paths)
+	})
+}
+
+func Test_TransformerTraces(t *testing.T) {
+	os.Setenv("RSERVER_PROCESSOR_TRANSFORMER_TRACE_SAMPLE_RATE", "1.0")
+	defer os.Unsetenv("RSERVER_PROCESSOR_TRANSFORMER_TRACE_SAMPLE_RATE")
+	os.Setenv("RSERVER_PROCESSOR_TRANSFORMER_TRACE_BUFFER_SIZE", "2")
+	defer os.Unsetenv("RSERVER_PROCESSOR_TRANSFORMER_TRACE_BUFFER_SIZE")
+
+	config.Load()
+	logger.Init()
+	stats.Setup()
+	transformer.Init()
+
+	srv := httptest.NewServer(&fakeTransformer{})
+	defer srv.Close()
+
+	tr := transformer.NewTransformer()
+	tr.Client = srv.Client()
+	tr.Setup()
+	require.Empty(t, tr.Traces())
+
+	transform := func(msgID string, statusCode int) {
+		events := []transformer.TransformerEventT{{
+			Metadata:    transformer.MetadataT{MessageID: msgID, SourceID: "src-1"},
+			Destination: backendconfig.DestinationT{ID: "dst-1"},
+			Message: map[string]interface{}{
+				"src-key-1":       msgID,
+				"forceStatusCode": statusCode,
+			},
+		}}
+		tr.Transform(context.TODO(), events, srv.URL, 10)
+	}
+
+	transform("messageID-1", 200)
+	traces := tr.Traces()
+	require.Len(t, traces, 1)
+	require.Equal(t, srv.URL, traces[0].URL)
+	require.NotEmpty(t, traces[0].RequestID)
+	require.Equal(t, http.StatusOK, traces[0].StatusCode)
+	require.Equal(t, "src-1", traces[0].Tags["src_id"])
+	require.Equal(t, "dst-1", traces[0].Tags["dest_id"])
+	require.Empty(t, traces[0].Error)
+
+	var request []transformer.TransformerEventT
+	require.NoError(t, json.Unmarshal(traces[0].Request, &request))
+	require.Len(t, request, 1)
+	require.Equal(t, "messageID-1", request[0].Metadata.MessageID)
+	var response []transformer.TransformerResponseT
+	require.NoError(t, json.Unmarshal(traces[0].Response, &response))
+	require.Len(t, response, 1)
+	require.Equal(t, "messageID-1", response[0].Metadata.MessageID)
+
+	//Only the latest traces are kept
+	transform("messageID-2", 400)
+	transform("messageID-3", 200)
+	traces = tr.Traces()
+	require.Len(t, traces, 2)
+	require.Equal(t, http.StatusOK, traces[0].StatusCode)
+	require.Contains(t, string(traces[0].Request), "messageID-2")
+	require.Contains(t, string(traces[1].Request), "messageID-3")
+}
+
+type fakeSpan struct {
+	name       string
+	parent     string
+	attributes map[string]interface{}
+	ended      bool
+}
+
+func (s *fakeSpan) SetAttributes(attributes map[string]interface{}) {
+	for k, v := range attributes {
+		s.attributes[k] = v
+	}
+}
+
+func (s *fakeSpan) End() {
+	s.ended = true
+}
+
+type spanKey struct{}
+
+//fakeTracer records the spans started and injects the name of the span carried by the context as the traceparent header
+type fakeTracer struct {
+	mu    sync.Mutex
+	spans []*fakeSpan
+}
+
+func (t *fakeTracer) Start(ctx context.Context, name string) (context.Context, transformer.Span) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	span := &fakeSpan{name: fmt.Sprintf("%s-%d", name, len(t.spans)), attributes: map[string]interface{}{}}
+	span.parent, _ = ctx.Value(spanKey{}).(string)
+	t.spans = append(t.spans, span)
+	return context.WithValue(ctx, spanKey{}, span.name), span
+}
+
+func (t *fakeTracer) Inject(ctx context.Context, header http.Header) {
+	if name, ok := ctx.Value(spanKey{}).(string); ok {
+		header.Set("traceparent", name)
+	}
+}
+
+func Test_TransformerTracer(t *testing.T) {
+	config.Load()
+	logger.Init()
+	stats.Setup()
+	transformer.Init()
+
+	var mu sync.Mutex
+	var traceparents []string
+	ft := &fakeTransformer{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		traceparents = append(traceparents, r.Header.Get("traceparent"))
+		ft.ServeHTTP(w, r)
+	}))
+	defer srv.Close()
+
+	tracer := &fakeTracer{}
+	tr := transformer.NewTransformer()
+	tr.Client = srv.Client()
+	tr.SetTracer(tracer)
+	tr.Setup()
+
+	var events []transformer.TransformerEventT
+	for i := 0; i < 3; i++ {
+		msgID := fmt.Sprintf("messageID-%d", i)
+		statusCode := 200
+		if i == 2 {
+			statusCode = 400
+		}
+		events = append(events, transformer.TransformerEventT{
+			Metadata: transformer.MetadataT{MessageID: msgID},
+			Message: map[string]interface{}{
+				"src-key-1":       msgID,
+				"forceStatusCode": statusCode,
+			},
+		})
+	}
+
+	ctx := context.WithValue(context.TODO(), spanKey{}, "incoming")
+	rsp := tr.Transform(ctx, events, srv.URL, 2)
+	require.Len(t, rsp.Events, 2)
+	require.Len(t, rsp.FailedEvents, 1)
+
+	//A span per batch, child of the incoming one and propagated to the transformer
+	require.Len(t, tracer.spans, 2)
+	var spanNames []string
+	var batchSizes, successCounts, failedCounts int
+	for _, span := range tracer.spans {
+		require.True(t, span.ended)
+		require.Equal(t, "incoming", span.parent)
+		require.Equal(t, srv.URL, span.attributes["transformer.url"])
+		require.NotEmpty(t, span.attributes["transformer.request_id"])
+		spanNames = append(spanNames, span.name)
+		batchSizes += span.attributes["transformer.batch_size"].(int)
+		successCounts += span.attributes["transformer.success_count"].(int)
+		failedCounts += span.attributes["transformer.failed_count"].(int)
+	}
+	require.Equal(t, 3, batchSizes)
+	require.Equal(t, 2, successCounts)
+	require.Equal(t, 1, failedCounts)
+	require.ElementsMatch(t, spanNames, traceparents)
+}
+
+func Test_TransformerCompression(t *testing.T) {
+	os.Setenv("RSERVER_PROCESSOR_TRANSFORMER_ENABLE_COMPRESSION", "true")
+	defer os.Unsetenv("RSERVER_PROCESSOR_TRANSFORMER_ENABLE_COMPRESSION")
+
+	config.Load()
+	logger.Init()
+	stats.Setup()
+	transformer.Init()
+
+	events := make([]transformer.TransformerEventT, 10)
+	for i := range events {
+		msgID := fmt.Sprintf("messageID-%d", i)
+		events[i] = transformer.TransformerEventT{
+			Metadata: transformer.MetadataT{MessageID: msgID},
+			Message: map[string]interface{}{
+				"src-key-1":       msgID,
+				"forceStatusCode": 200,
+			},
+		}
+	}
+
+	//setup starts a transformer gzipping its responses if asked to, which advertises that it accepts gzipped requests if acceptGzip is true.
+	//It responds with 415 to the gzipped requests if rejectGzip is true, as one rolled back to a version without compression.
+	//It returns the Content-Encoding of the requests received.
+	setup := func(t *testing.T, acceptGzip, rejectGzip bool) (*transformer.HandleT, string, func() []string) {
+		var (
+			mu        sync.Mutex
+			encodings []string
+		)
+		ft := &fakeTransformer{}
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			mu.Lock()
+			defer mu.Unlock()
+			encodings = append(encodings, r.Header.Get("Content-Encoding"))
+			if rejectGzip && r.Header.Get("Content-Encoding") == "gzip" {
+				w.WriteHeader(http.StatusUnsupportedMediaType)
+				return
+			}
+
+			rec := httptest.NewRecorder()
+			ft.ServeHTTP(rec, r)
+			for key, values := range rec.Header() {
+				w.Header()[key] = values
+			}
+			if acceptGzip {
+				w.Header().Set("Accept-Encoding", "gzip")
+			}
+			body := rec.Body.Bytes()
+			if strings.Contains(r.Header.Get("Accept-Encoding"), "gzip") {
+				var buf bytes.Buffer
+				gzipWriter := gzip.NewWriter(&buf)
+				_, err := gzipWriter.Write(body)
+				require.NoError(t, err)
+				require.NoError(t, gzipWriter.Close())
+				body = buf.Bytes()
+				w.Header().Set("Content-Encoding", "gzip")
+			}
+			w.WriteHeader(rec.Code)
+			_, _ = w.Write(body)
+		}))
+		t.Cleanup(srv.Close)
+
+		tr := transformer.NewTransformer()
+		tr.Client = srv.Client()
+		tr.Setup()
+		return tr, srv.URL, func() []string {
+			mu.Lock()
+			defer mu.Unlock()
+			return append([]string(nil), encodings...)
+		}
+	}
+
+	requireTransformed := func(t *testing.T, rsp transformer.ResponseT) {
+		require.Empty(t, rsp.FailedEvents)
+		require.Len(t, rsp.Events, len(events))
+		for i := range rsp.Events {
+			require.Equal(t, rsp.Events[i].Output["src-key-1"], rsp.Events[i].Output["echo-key-1"])
+		}
+	}
+
+	t.Run("requests are gzipped once the transformer advertises support", func(t *testing.T) {
+		tr, url, encodings := setup(t, true, false)
+
+		requireTransformed(t, tr.Transform(context.TODO(), events, url, len(events)))
+		requireTransformed(t, tr.Transform(context.TODO(), events, url, len(events)))
+		require.Equal(t, []string{"", "gzip"}, encodings())
+	})
+
+	t.Run("requests stay uncompressed without support", func(t *testing.T) {
+		tr, url, encodings := setup(t, false, false)
+
+		requireTransformed(t, tr.Transform(context.TODO(), events, url, len(events)))
+		requireTransformed(t, tr.Transform(context.TODO(), events, url, len(events)))
+		require.Equal(t, []string{"", ""}, encodings())
+	})
+
+	t.Run("rejected gzipped requests are resent uncompressed", func(t *testing.T) {
+		tr, url, encodings := setup(t, true, true)
+
+		requireTransformed(t, tr.Transform(context.TODO(), events, url, len(events)))
+		requireTransformed(t, tr.Transform(context.TODO(), events, url, len(events)))
+		require.Equal(t, []string{"", "gzip", ""}, encodings())
+	})
+}
+
+func Test_TransformerNDJSON(t *testing.T) {
+	os.Setenv("RSERVER_PROCESSOR_TRANSFORMER_ENABLE_STREAMING", "true")
+	defer os.Unsetenv("RSERVER_PROCESSOR_TRANSFORMER_ENABLE_STREAMING")
+
+	config.Load()
+	logger.Init()
+	stats.Setup()
+	transformer.Init()
+
+	events := make([]transformer.TransformerEventT, 30)
+	for i := range events {
+		msgID := fmt.Sprintf("messageID-%d", i)
+		statusCode := 200
+		if i%4 == 0 {
+			statusCode = 400
+		}
+		events[i] = transformer.TransformerEventT{
+			Metadata: transformer.MetadataT{MessageID: msgID},
+			Message: map[string]interface{}{
+				"src-key-1":       msgID,
+				"forceStatusCode": statusCode,
+			},
+		}
+	}
+	//copyEvents copies the events, since the fake transformer removes forceStatusCode from their messages
+	copyEvents := func() []transformer.TransformerEventT {
+		copied := make([]transformer.TransformerEventT, len(events))
+		for i := range events {
+			copied[i] = events[i]
+			copied[i].Message = make(map[string]interface{}, len(events[i].Message))
+			for key, value := range events[i].Message {
+				copied[i].Message[key] = value
+			}
+		}
+		return copied
+	}
+
+	//setup starts a transformer streaming its responses one per line if asked to, rewriting each line with rewriteLine if set
+	setup := func(t *testing.T, rewriteLine func(line []byte) []byte) (*transformer.HandleT, string) {
+		ft := &fakeTransformer{}
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			require.Contains(t, r.Header.Get("Accept"), "application/x-ndjson")
+
+			rec := httptest.NewRecorder()
+			ft.ServeHTTP(rec, r)
+			for key, values := range rec.Header() {
+				w.Header()[key] = values
+			}
+			var resps []json.RawMessage
+			require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resps))
+			w.Header().Set("Content-Type", "application/x-ndjson")
+			for _, resp := range resps {
+				line := []byte(resp)
+				if rewriteLine != nil {
+					line = rewriteLine(line)
+				}
+				_, _ = w.Write(append(line, '\n'))
+			}
+		}))
+		t.Cleanup(srv.Close)
+
+		tr := transformer.NewTransformer()
+		tr.Client = srv.Client()
+		tr.Setup()
+		return tr, srv.URL
+	}
+
+	arraySrv := httptest.NewServer(&fakeTransformer{})
+	defer arraySrv.Close()
+	arrayTr := transformer.NewTransformer()
+	arrayTr.Client = arraySrv.Client()
+	arrayTr.Setup()
+
+	t.Run("streamed responses are the same as the array ones", func(t *testing.T) {
+		tr, url := setup(t, nil)
+
+		for _, batchSize := range []int{1, 7, len(events)} {
+			expected := arrayTr.Transform(context.TODO(), copyEvents(), arraySrv.URL, batchSize)
+			rsp := tr.Transform(context.TODO(), copyEvents(), url, batchSize)
+			for _, responses := range [][]transformer.TransformerResponseT{expected.Events, expected.FailedEvents, rsp.Events, rsp.FailedEvents} {
+				clearRequestIDs(responses)
+			}
+			require.Len(t, rsp.Events, 22)
+			require.Len(t, rsp.FailedEvents, 8)
+			require.Equal(t, expected, rsp)
+		}
+	})
+
+	t.Run("malformed streams fail the events", func(t *testing.T) {
+		tr, url := setup(t, func(line []byte) []byte {
+			return line[:len(line)-1]
+		})
+
+		rsp := tr.Transform(context.TODO(), copyEvents(), url, len(events))
+		require.Empty(t, rsp.Events)
+		require.Len(t, rsp.FailedEvents, len(events))
+		for _, failed := range rsp.FailedEvents {
+			require.Equal(t, http.StatusBadRequest, failed.StatusCode)
+			require.Contains(t, failed.Error, "Failed to unmarshal transformer response")
+		}
+	})
+}
+
+func Test_MergeResponses(t *testing.T) {
+	response := func(statusCode int, messageID string) transformer.TransformerResponseT {
+		return transformer.TransformerResponseT{Metadata: transformer.MetadataT{MessageID: messageID}, StatusCode: statusCode}
+	}
+	first := transformer.ResponseT{
+		Events:       []transformer.TransformerResponseT{response(200, "messageID-3"), response(200, "messageID-1")},
+		FailedEvents: []transformer.TransformerResponseT{response(400, "messageID-5")},
+	}
+	second := transformer.ResponseT{
+		Events:          []transformer.TransformerResponseT{response(200, "messageID-2")},
+		FailedEvents:    []transformer.TransformerResponseT{response(400, "messageID-4")},
+		DeferredEvents:  []transformer.TransformerEventT{{Metadata: transformer.MetadataT{MessageID: "messageID-6"}}},
+		OrphanResponses: []transformer.TransformerResponseT{response(200, "messageID-unknown")},
+		Cancelled:       true,
+	}
+
+	t.Run("events are concatenated in the order of the responses", func(t *testing.T) {
+		merged := first.Merge(second)
+		require.Equal(t, transformer.ResponseT{
+			Events:          []transformer.TransformerResponseT{response(200, "messageID-3"), response(200, "messageID-1"), response(200, "messageID-2")},
+			FailedEvents:    []transformer.TransformerResponseT{response(400, "messageID-5"), response(400, "messageID-4")},
+			DeferredEvents:  second.DeferredEvents,
+			OrphanResponses: second.OrphanResponses,
+			Cancelled:       true,
+		}, merged)
+		require.Equal(t, merged, transformer.MergeResponses(first, second))
+		require.Equal(t, first, transformer.MergeResponses(first))
+		require.Equal(t, transformer.ResponseT{}, transformer.MergeResponses())
+	})
+
+	t.Run("merged responses don't share the events of the responses merged", func(t *testing.T) {
+		merged := first.Merge(second)
+		merged.SortByMessageID()
+		require.Equal(t, []transformer.TransformerResponseT{response(200, "messageID-3"), response(200, "messageID-1")}, first.Events)
+		require.Equal(t, []transformer.TransformerResponseT{response(200, "messageID-2")}, second.Events)
+	})
+
+	t.Run("sorting by messageID is stable and independent of the merge order", func(t *testing.T) {
+		duplicate := transformer.TransformerResponseT{Metadata: transformer.MetadataT{MessageID: "messageID-1"}, StatusCode: 299}
+		third := transformer.ResponseT{Events: []transformer.TransformerResponseT{duplicate}}
+
+		merged := transformer.MergeResponses(first, second, third)
+		merged.SortByMessageID()
+		require.Equal(t, []transformer.TransformerResponseT{response(200, "messageID-1"), duplicate, response(200, "messageID-2"), response(200, "messageID-3")}, merged.Events)
+		require.Equal(t, []transformer.TransformerResponseT{response(400, "messageID-4"), response(400, "messageID-5")}, merged.FailedEvents)
+
+		reversed := transformer.MergeResponses(second, first)
+		reversed.SortByMessageID()
+		merged = first.Merge(second)
+		merged.SortByMessageID()
+		require.Equal(t, merged, reversed)
+	})
+}
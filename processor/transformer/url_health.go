@@ -0,0 +1,67 @@
+package transformer
+
+import (
+	"sync"
+	"time"
+)
+
+type urlHealthStatusT struct {
+	consecutiveFailures int
+	lastFailure         time.Time
+}
+
+//urlHealthT tracks connection failures per transformer url, so that persistently failing urls can be skipped
+type urlHealthT struct {
+	lock   sync.RWMutex
+	status map[string]*urlHealthStatusT
+}
+
+func (h *urlHealthT) markFailure(url string) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	if h.status == nil {
+		h.status = make(map[string]*urlHealthStatusT)
+	}
+	status, ok := h.status[url]
+	if !ok {
+		status = &urlHealthStatusT{}
+		h.status[url] = status
+	}
+	status.consecutiveFailures++
+	status.lastFailure = time.Now()
+}
+
+func (h *urlHealthT) markSuccess(url string) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	delete(h.status, url)
+}
+
+//isHealthy returns false if the url has failed unhealthyURLThreshold times in a row, within the last unhealthyURLCooldown
+func (h *urlHealthT) isHealthy(url string) bool {
+	h.lock.RLock()
+	defer h.lock.RUnlock()
+
+	status, ok := h.status[url]
+	if !ok {
+		return true
+	}
+	return status.consecutiveFailures < unhealthyURLThreshold || time.Since(status.lastFailure) > unhealthyURLCooldown
+}
+
+//order returns the urls rotated to start at offset, with the unhealthy urls moved to the end
+func (h *urlHealthT) order(urls []string, offset int) []string {
+	healthy := make([]string, 0, len(urls))
+	var unhealthy []string
+	for i := range urls {
+		url := urls[(offset+i)%len(urls)]
+		if h.isHealthy(url) {
+			healthy = append(healthy, url)
+		} else {
+			unhealthy = append(unhealthy, url)
+		}
+	}
+	return append(healthy, unhealthy...)
+}
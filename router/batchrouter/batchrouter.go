@@ -109,6 +109,7 @@ type HandleT struct {
 	backendConfigInitialized       chan bool
 	asyncDestinationStruct         map[string]*asyncdestinationmanager.AsyncDestinationStruct
 	jobQueryBatchSize              int
+	queryLimit                     int //the number of jobs read in the main loop, which backs off after a query timeout
 	pollStatusLoopSleep            time.Duration
 	asyncUploadWorkerPauseChannel  chan *PauseT
 	asyncUploadWorkerResumeChannel chan bool
@@ -1477,15 +1478,25 @@ func (worker *workerT) workerProcess() {
 			parameterFilters := worker.constructParameterFilters(batchDest)
 			var combinedList []*jobsdb.JobT
 			if readPerDestination {
-				toQuery := worker.brt.jobQueryBatchSize
+				limit := misc.MinInt(worker.queryLimit, worker.brt.jobQueryBatchSize)
+				toQuery := limit
 				if !brt.holdFetchingJobs(parameterFilters) {
 					brtQueryStat := stats.NewTaggedStat("batch_router.jobsdb_query_time", stats.TimerType, map[string]string{"function": "workerProcess"})
 					brtQueryStat.Start()
 					brt.logger.Debugf("BRT: %s: DB about to read for parameter Filters: %v ", brt.destType, parameterFilters)
 
-					retryList := brt.jobsDB.GetToRetry(jobsdb.GetQueryParamsT{CustomValFilters: []string{brt.destType}, JobCount: toQuery, ParameterFilters: parameterFilters, IgnoreCustomValFiltersInQuery: true})
-					unprocessedList := brt.jobsDB.GetUnprocessed(jobsdb.GetQueryParamsT{CustomValFilters: []string{brt.destType}, JobCount: toQuery, ParameterFilters: parameterFilters, IgnoreCustomValFiltersInQuery: true})
+					retryList, err := brt.jobsDB.GetToRetry(jobsdb.GetQueryParamsT{CustomValFilters: []string{brt.destType}, JobCount: toQuery, ParameterFilters: parameterFilters, IgnoreCustomValFiltersInQuery: true})
+					var unprocessedList []*jobsdb.JobT
+					if err == nil {
+						unprocessedList, err = brt.jobsDB.GetUnprocessed(jobsdb.GetQueryParamsT{CustomValFilters: []string{brt.destType}, JobCount: toQuery, ParameterFilters: parameterFilters, IgnoreCustomValFiltersInQuery: true})
+					}
 					brtQueryStat.End()
+					worker.queryLimit = jobsdb.NextQueryLimit(limit, worker.brt.jobQueryBatchSize, err)
+					//The destination is skipped this time after a failed read, just like one without jobs
+					if err != nil {
+						brt.logger.Errorf("BRT: %s: Failed to read the jobs for parameter Filters: %v, reading at most %d jobs next: %v", brt.destType, parameterFilters, worker.queryLimit, err)
+						retryList, unprocessedList = nil, nil
+					}
 
 					combinedList = append(retryList, unprocessedList...)
 
@@ -1697,6 +1708,7 @@ func (brt *HandleT) initWorkers() {
 			resumeChannel: make(chan bool),
 			workerID:      i,
 			brt:           brt,
+			queryLimit:    brt.jobQueryBatchSize,
 		}
 		brt.workers[i] = worker
 		g.Go(misc.WithBugsnag(func() error {
@@ -1719,6 +1731,7 @@ type workerT struct {
 	resumeChannel chan bool
 	workerID      int // identifies the worker
 	brt           *HandleT
+	queryLimit    int //the number of jobs read per destination, which backs off after a query timeout
 }
 
 type DestinationT struct {
@@ -1816,12 +1829,21 @@ func (brt *HandleT) readAndProcess() {
 		brt.logger.Debugf("BRT: %s: Reading in mainLoop", brt.destType)
 		brtQueryStat := stats.NewTaggedStat("batch_router.jobsdb_query_time", stats.TimerType, map[string]string{"function": "mainLoop"})
 		brtQueryStat.Start()
-		toQuery := brt.jobQueryBatchSize
+		limit := misc.MinInt(brt.queryLimit, brt.jobQueryBatchSize)
+		toQuery := limit
 		if !brt.holdFetchingJobs([]jobsdb.ParameterFilterT{}) {
-			retryList := brt.jobsDB.GetToRetry(jobsdb.GetQueryParamsT{CustomValFilters: []string{brt.destType}, JobCount: toQuery})
-			toQuery -= len(retryList)
-			unprocessedList := brt.jobsDB.GetUnprocessed(jobsdb.GetQueryParamsT{CustomValFilters: []string{brt.destType}, JobCount: toQuery})
+			retryList, err := brt.jobsDB.GetToRetry(jobsdb.GetQueryParamsT{CustomValFilters: []string{brt.destType}, JobCount: toQuery})
+			var unprocessedList []*jobsdb.JobT
+			if err == nil {
+				toQuery -= len(retryList)
+				unprocessedList, err = brt.jobsDB.GetUnprocessed(jobsdb.GetQueryParamsT{CustomValFilters: []string{brt.destType}, JobCount: toQuery})
+			}
 			brtQueryStat.End()
+			brt.queryLimit = jobsdb.NextQueryLimit(limit, brt.jobQueryBatchSize, err)
+			if err != nil {
+				brt.logger.Errorf("BRT: %s: Failed to read the jobs, reading at most %d jobs next: %v", brt.destType, brt.queryLimit, err)
+				return
+			}
 
 			jobs = append(retryList, unprocessedList...)
 			brt.logger.Debugf("BRT: %s: Length of jobs received: %d", brt.destType, len(jobs))
@@ -2116,6 +2138,7 @@ func (brt *HandleT) Setup(backendConfig backendconfig.BackendConfig, jobsDB jobs
 	brt.noOfWorkers = getBatchRouterConfigInt("noOfWorkers", destType, 8)
 	config.RegisterDurationConfigVariable(time.Duration(10), &brt.pollStatusLoopSleep, true, time.Second, []string{"BatchRouter." + brt.destType + "." + "pollStatusLoopSleep", "BatchRouter.pollStatusLoopSleep"}...)
 	config.RegisterIntConfigVariable(100000, &brt.jobQueryBatchSize, true, 1, []string{"BatchRouter." + brt.destType + "." + "jobQueryBatchSize", "BatchRouter.jobQueryBatchSize"}...)
+	brt.queryLimit = brt.jobQueryBatchSize
 	config.RegisterIntConfigVariable(10000, &brt.maxEventsInABatch, false, 1, []string{"BatchRouter." + brt.destType + "." + "maxEventsInABatch", "BatchRouter.maxEventsInABatch"}...)
 	config.RegisterIntConfigVariable(128, &brt.maxFailedCountForJob, true, 1, []string{"BatchRouter." + brt.destType + "." + "maxFailedCountForJob", "BatchRouter." + "maxFailedCountForJob"}...)
 	config.RegisterDurationConfigVariable(180, &brt.retryTimeWindow, true, time.Minute, []string{"BatchRouter." + brt.destType + "." + "retryTimeWindow", "BatchRouter." + brt.destType + "." + "retryTimeWindowInMins", "BatchRouter." + "retryTimeWindow", "BatchRouter." + "retryTimeWindowInMins"}...)
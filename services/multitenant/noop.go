@@ -14,12 +14,33 @@ func (*noop) CalculateSuccessFailureCounts(workspace string, destType string, is
 func (*noop) AddToInMemoryCount(workspaceID string, destinationType string, count int, tableType string) {
 }
 
+func (*noop) GetInMemoryJobCount(tableType string, workspaceID string, destinationType string) int {
+	return 0
+}
+
+func (*noop) IsCustomerHealthy(customer string, destType string) (bool, float64) {
+	return true, 1
+}
+
+func (*noop) PeekSuccessRateMap(destType string) map[string]float64 {
+	return map[string]float64{}
+}
+
+func (*noop) ResetSuccessCounters() {
+}
+
 func (*noop) GetRouterPickupJobs(destType string, noOfWorkers int, routerTimeOut time.Duration, jobQueryBatchSize int, timeGained float64) (map[string]int, map[string]float64) {
 	return map[string]int{
 		"0": jobQueryBatchSize,
 	}, map[string]float64{}
 }
 
+func (*noop) GetPickupJobs(tableType string, destType string, noOfWorkers int, routerTimeOut time.Duration, jobQueryBatchSize int, timeGained float64) (map[string]int, map[string]float64) {
+	return map[string]int{
+		"0": jobQueryBatchSize,
+	}, map[string]float64{}
+}
+
 func (*noop) RemoveFromInMemoryCount(workspaceID string, destinationType string, count int, tableType string) {
 }
 
@@ -33,3 +54,7 @@ func (*noop) AddWorkspaceToLatencyMap(destType string, workspaceID string) {
 func (*noop) UpdateWorkspaceLatencyMap(destType string, workspaceID string, val float64) {
 
 }
+
+func (*noop) UpdateEarliestJobMap(destType string, earliestJobMap map[string]time.Time) {
+
+}
@@ -3,51 +3,132 @@
 package multitenant
 
 import (
+	"context"
+	"encoding/json"
+	"fmt"
 	"math"
 	"sort"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 
+	"github.com/rudderlabs/rudder-server/config"
 	"github.com/rudderlabs/rudder-server/jobsdb"
+	"github.com/rudderlabs/rudder-server/services/stats"
 	"github.com/rudderlabs/rudder-server/utils/logger"
 	"github.com/rudderlabs/rudder-server/utils/misc"
 )
 
 var (
-	pkgLogger logger.LoggerI
+	pkgLogger          logger.LoggerI
+	drainedDecayWindow time.Duration
+	drainedDecayShape  string
+	coldStartSamples   int
+	statLoopInterval   time.Duration
+	unhealthyThreshold float64
+	useP95Latency      bool
+	pickupPassOrder    string
+	defaultLatency     float64
+	minPickupFloor     int
+	coldStartPickup    int
+	//latencyQuantileWindow is the number of latencies after which the p95 estimators restart from their estimate, so that they follow the recent latencies
+	latencyQuantileWindow int
+	//earliestJobPriority breaks the ties between similarly scored workspaces in favour of the one with the oldest waiting job
+	earliestJobPriority bool
+	//similarScoreTolerance is the relative difference below which two workspace scores are similar, see earliestJobPriority
+	similarScoreTolerance float64
+)
+
+//Orders in which GetRouterPickupJobs runs its fairness passes, see pickupPassOrder
+const (
+	inRateFirst = "inrate_first"
+	//pileUpFirst drains the pile ups before giving the workspaces their input rates
+	pileUpFirst = "pileup_first"
+)
+
+//latencyQuantile is the quantile of the workspace latencies used for sizing the pickups if useP95Latency is true
+const latencyQuantile = 0.95
+
+//Shapes with which a drained workspace's deprioritization decays over drainedDecayWindow
+const (
+	linearDecay      = "linear"
+	exponentialDecay = "exponential"
+	//stepDecay keeps a drained workspace fully deprioritized until drainedDecayWindow has passed
+	stepDecay = "step"
+	//exponentialDecayRate controls how fast the weight recovers with exponentialDecay
+	exponentialDecayRate = 5.0
 )
 
 type MultitenantStatsT struct {
 	routerNonTerminalCounts map[string]map[string]map[string]int
 	routerJobCountMutex     sync.RWMutex
 	routerInputRates        map[string]map[string]map[string]misc.MovingAverage
+	routerInputRateSamples  map[string]map[string]map[string]int
 	lastDrainedTimestamps   map[string]map[string]time.Time
 	failureRate             map[string]map[string]misc.MovingAverage
+	drainedRate             map[string]map[string]misc.MovingAverage
 	routerSuccessRateMutex  sync.RWMutex
 	routerTenantLatencyStat map[string]map[string]misc.MovingAverage
-	routerLatencyMutex      sync.RWMutex
-	processorStageTime      time.Time
+	//routerTenantLatencyP95 tracks an approximate p95 of the latencies, at a constant ~180 bytes per workspace and destination type
+	routerTenantLatencyP95 map[string]map[string]misc.MovingAverage
+	routerLatencyMutex     sync.RWMutex
+	//earliestJobTimestamps are the created_at of the oldest pending job of the workspaces per destination type, guarded by routerLatencyMutex
+	earliestJobTimestamps map[string]map[string]time.Time
+	processorStageTime    time.Time
+	//maxPickupPerWorkspace caps the number of jobs picked up for a workspace in a router loop, regardless of its pile up
+	maxPickupPerWorkspace map[string]int
+	//routerTimeOutPerDestType overrides the router timeout of the pickups of the destination types, e.g. for the slower warehouses
+	routerTimeOutPerDestType map[string]time.Duration
+	//routerDB is read by StatLoop for the earliest jobs of the workspaces, if earliestJobPriority is true
+	routerDB jobsdb.MultiTenantJobsDB
 }
 
 type MultiTenantI interface {
 	CalculateSuccessFailureCounts(workspace string, destType string, isSuccess bool, isDrained bool)
 	GetRouterPickupJobs(destType string, noOfWorkers int, routerTimeOut time.Duration, jobQueryBatchSize int, timeGained float64) (map[string]int, map[string]float64)
+	GetPickupJobs(tableType string, destType string, noOfWorkers int, routerTimeOut time.Duration, jobQueryBatchSize int, timeGained float64) (map[string]int, map[string]float64)
 	AddToInMemoryCount(workspaceID string, destinationType string, count int, tableType string)
 	RemoveFromInMemoryCount(workspaceID string, destinationType string, count int, tableType string)
+	GetInMemoryJobCount(tableType string, workspaceID string, destinationType string) int
+	IsCustomerHealthy(customer string, destType string) (bool, float64)
+	PeekSuccessRateMap(destType string) map[string]float64
+	ResetSuccessCounters()
 	ReportProcLoopAddStats(stats map[string]map[string]int, tableType string)
 	UpdateWorkspaceLatencyMap(destType string, workspaceID string, val float64)
+	UpdateEarliestJobMap(destType string, earliestJobMap map[string]time.Time)
 }
 
 type workspaceScore struct {
 	score           float64
 	secondary_score float64
 	workspaceId     string
+	//latency and drainingScore are the parts of the score of getSortedWorkspaceScoreList, telling the workspaces of similar latency apart
+	latency       float64
+	drainingScore float64
 }
 
 func Init() {
+	loadConfig()
 	pkgLogger = logger.NewLogger().Child("services").Child("multitenant")
 }
 
+func loadConfig() {
+	config.RegisterDurationConfigVariable(time.Duration(100), &drainedDecayWindow, true, time.Second, "Router.multitenant.drainedDecayWindow")
+	config.RegisterStringConfigVariable(linearDecay, &drainedDecayShape, true, "Router.multitenant.drainedDecayShape")
+	config.RegisterIntConfigVariable(0, &coldStartSamples, true, 1, "Router.multitenant.coldStartSamples")
+	config.RegisterDurationConfigVariable(time.Duration(10), &statLoopInterval, true, time.Second, "Router.multitenant.statLoopInterval")
+	config.RegisterFloat64ConfigVariable(0.1, &unhealthyThreshold, true, "Router.multitenant.unhealthyThreshold")
+	config.RegisterBoolConfigVariable(false, &useP95Latency, true, "Router.multitenant.useP95Latency")
+	config.RegisterStringConfigVariable(inRateFirst, &pickupPassOrder, true, "Router.multitenant.pickupPassOrder")
+	config.RegisterFloat64ConfigVariable(1, &defaultLatency, true, "Router.multitenant.defaultLatency")
+	config.RegisterIntConfigVariable(0, &minPickupFloor, true, 1, "Router.multitenant.minPickupFloor")
+	config.RegisterIntConfigVariable(0, &coldStartPickup, true, 1, "Router.multitenant.coldStartPickup")
+	config.RegisterBoolConfigVariable(false, &earliestJobPriority, true, "Router.multitenant.earliestJobPriority")
+	config.RegisterFloat64ConfigVariable(0.05, &similarScoreTolerance, true, "Router.multitenant.similarScoreTolerance")
+	config.RegisterIntConfigVariable(1000, &latencyQuantileWindow, false, 1, "Router.multitenant.latencyQuantileWindow")
+}
+
 func NewStats(routerDB jobsdb.MultiTenantJobsDB) *MultitenantStatsT {
 	multitenantStat := MultitenantStatsT{}
 	multitenantStat.routerNonTerminalCounts = make(map[string]map[string]map[string]int)
@@ -56,10 +137,19 @@ func NewStats(routerDB jobsdb.MultiTenantJobsDB) *MultitenantStatsT {
 	multitenantStat.routerInputRates = make(map[string]map[string]map[string]misc.MovingAverage)
 	multitenantStat.routerInputRates["router"] = make(map[string]map[string]misc.MovingAverage)
 	multitenantStat.routerInputRates["batch_router"] = make(map[string]map[string]misc.MovingAverage)
+	multitenantStat.routerInputRateSamples = make(map[string]map[string]map[string]int)
+	multitenantStat.routerInputRateSamples["router"] = make(map[string]map[string]int)
+	multitenantStat.routerInputRateSamples["batch_router"] = make(map[string]map[string]int)
 	multitenantStat.lastDrainedTimestamps = make(map[string]map[string]time.Time)
 	multitenantStat.failureRate = make(map[string]map[string]misc.MovingAverage)
+	multitenantStat.drainedRate = make(map[string]map[string]misc.MovingAverage)
 	multitenantStat.routerTenantLatencyStat = make(map[string]map[string]misc.MovingAverage)
+	multitenantStat.routerTenantLatencyP95 = make(map[string]map[string]misc.MovingAverage)
+	multitenantStat.earliestJobTimestamps = make(map[string]map[string]time.Time)
+	multitenantStat.routerDB = routerDB
 	multitenantStat.processorStageTime = time.Now()
+	multitenantStat.maxPickupPerWorkspace = parseMaxPickupPerWorkspace(config.GetStringSlice("Router.multitenant.maxPickupPerWorkspace", nil))
+	multitenantStat.routerTimeOutPerDestType = parseRouterTimeOutPerDestType(config.GetStringSlice("Router.multitenant.routerTimeOutPerDestType", nil))
 	pileUpStatMap := make(map[string]map[string]int)
 	routerDB.GetPileUpCounts(pileUpStatMap)
 	for workspace := range pileUpStatMap {
@@ -71,6 +161,197 @@ func NewStats(routerDB jobsdb.MultiTenantJobsDB) *MultitenantStatsT {
 	return &multitenantStat
 }
 
+//parseMaxPickupPerWorkspace parses the pickup caps given as workspaceID:count entries
+func parseMaxPickupPerWorkspace(entries []string) map[string]int {
+	var maxPickupPerWorkspace map[string]int
+	for _, entry := range entries {
+		idx := strings.LastIndex(entry, ":")
+		if idx <= 0 {
+			pkgLogger.Errorf("Ignoring invalid pickup cap %q. Expected format is workspaceID:count", entry)
+			continue
+		}
+		maxPickup, err := strconv.Atoi(strings.TrimSpace(entry[idx+1:]))
+		if err != nil || maxPickup < 0 {
+			pkgLogger.Errorf("Ignoring invalid pickup cap %q. Expected format is workspaceID:count", entry)
+			continue
+		}
+		if maxPickupPerWorkspace == nil {
+			maxPickupPerWorkspace = make(map[string]int)
+		}
+		maxPickupPerWorkspace[strings.TrimSpace(entry[:idx])] = maxPickup
+	}
+	return maxPickupPerWorkspace
+}
+
+//parseRouterTimeOutPerDestType parses the router timeouts given as destType:duration entries, e.g. RS:60s
+func parseRouterTimeOutPerDestType(entries []string) map[string]time.Duration {
+	var routerTimeOutPerDestType map[string]time.Duration
+	for _, entry := range entries {
+		idx := strings.LastIndex(entry, ":")
+		if idx <= 0 {
+			pkgLogger.Errorf("Ignoring invalid router timeout %q. Expected format is destType:duration", entry)
+			continue
+		}
+		routerTimeOut, err := time.ParseDuration(strings.TrimSpace(entry[idx+1:]))
+		if err != nil || routerTimeOut <= 0 {
+			pkgLogger.Errorf("Ignoring invalid router timeout %q. Expected format is destType:duration", entry)
+			continue
+		}
+		if routerTimeOutPerDestType == nil {
+			routerTimeOutPerDestType = make(map[string]time.Duration)
+		}
+		routerTimeOutPerDestType[strings.TrimSpace(entry[:idx])] = routerTimeOut
+	}
+	return routerTimeOutPerDestType
+}
+
+//getRouterTimeOut returns the router timeout of the destination type, falling back to routerTimeOut if it isn't overridden
+func (multitenantStat *MultitenantStatsT) getRouterTimeOut(destType string, routerTimeOut time.Duration) time.Duration {
+	if destTypeRouterTimeOut, ok := multitenantStat.routerTimeOutPerDestType[destType]; ok {
+		return destTypeRouterTimeOut
+	}
+	return routerTimeOut
+}
+
+//statsSnapshotT is the JSON representation of the in memory stats, see SnapshotStats
+type statsSnapshotT struct {
+	RouterNonTerminalCounts map[string]map[string]map[string]int                `json:"routerNonTerminalCounts"`
+	RouterInputRates        map[string]map[string]map[string]misc.MovingAverage `json:"routerInputRates"`
+	RouterInputRateSamples  map[string]map[string]map[string]int                `json:"routerInputRateSamples"`
+	LastDrainedTimestamps   map[string]map[string]time.Time                     `json:"lastDrainedTimestamps"`
+	FailureRate             map[string]map[string]misc.MovingAverage            `json:"failureRate"`
+	DrainedRate             map[string]map[string]misc.MovingAverage            `json:"drainedRate"`
+	RouterTenantLatencyStat map[string]map[string]misc.MovingAverage            `json:"routerTenantLatencyStat"`
+	RouterTenantLatencyP95  map[string]map[string]misc.MovingAverage            `json:"routerTenantLatencyP95"`
+}
+
+//restoredStatsSnapshotT is statsSnapshotT with the moving averages left undecoded,
+//since their implementation depends on the map they are restored to
+type restoredStatsSnapshotT struct {
+	RouterNonTerminalCounts map[string]map[string]map[string]int             `json:"routerNonTerminalCounts"`
+	RouterInputRates        map[string]map[string]map[string]json.RawMessage `json:"routerInputRates"`
+	RouterInputRateSamples  map[string]map[string]map[string]int             `json:"routerInputRateSamples"`
+	LastDrainedTimestamps   map[string]map[string]time.Time                  `json:"lastDrainedTimestamps"`
+	FailureRate             map[string]map[string]json.RawMessage            `json:"failureRate"`
+	DrainedRate             map[string]map[string]json.RawMessage            `json:"drainedRate"`
+	RouterTenantLatencyStat map[string]map[string]json.RawMessage            `json:"routerTenantLatencyStat"`
+	RouterTenantLatencyP95  map[string]map[string]json.RawMessage            `json:"routerTenantLatencyP95"`
+}
+
+//SnapshotStats serializes the in memory job counts and the moving averages, along with their internal state, to JSON.
+//A process can persist it on shutdown and load it with RestoreStats on boot, instead of starting over from cold stats.
+func (multitenantStat *MultitenantStatsT) SnapshotStats() ([]byte, error) {
+	multitenantStat.routerJobCountMutex.RLock()
+	defer multitenantStat.routerJobCountMutex.RUnlock()
+	multitenantStat.routerLatencyMutex.RLock()
+	defer multitenantStat.routerLatencyMutex.RUnlock()
+	multitenantStat.routerSuccessRateMutex.RLock()
+	defer multitenantStat.routerSuccessRateMutex.RUnlock()
+
+	return json.Marshal(statsSnapshotT{
+		RouterNonTerminalCounts: multitenantStat.routerNonTerminalCounts,
+		RouterInputRates:        multitenantStat.routerInputRates,
+		RouterInputRateSamples:  multitenantStat.routerInputRateSamples,
+		LastDrainedTimestamps:   multitenantStat.lastDrainedTimestamps,
+		FailureRate:             multitenantStat.failureRate,
+		DrainedRate:             multitenantStat.drainedRate,
+		RouterTenantLatencyStat: multitenantStat.routerTenantLatencyStat,
+		RouterTenantLatencyP95:  multitenantStat.routerTenantLatencyP95,
+	})
+}
+
+//RestoreStats replaces the in memory job counts and moving averages with the ones serialized by SnapshotStats.
+//Nothing is replaced if the snapshot can't be decoded.
+func (multitenantStat *MultitenantStatsT) RestoreStats(data []byte) error {
+	var snapshot restoredStatsSnapshotT
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		return fmt.Errorf("invalid multitenant stats snapshot: %w", err)
+	}
+
+	routerInputRates := make(map[string]map[string]map[string]misc.MovingAverage, len(snapshot.RouterInputRates))
+	for tableType, rates := range snapshot.RouterInputRates {
+		restored, err := restoreMovingAverages(rates, func() misc.MovingAverage { return misc.NewMovingAverage() })
+		if err != nil {
+			return fmt.Errorf("invalid %s input rates in multitenant stats snapshot: %w", tableType, err)
+		}
+		routerInputRates[tableType] = restored
+	}
+	newVariableEWMA := func() misc.MovingAverage { return misc.NewMovingAverage(misc.AVG_METRIC_AGE) }
+	failureRate, err := restoreMovingAverages(snapshot.FailureRate, newVariableEWMA)
+	if err != nil {
+		return fmt.Errorf("invalid failure rates in multitenant stats snapshot: %w", err)
+	}
+	drainedRate, err := restoreMovingAverages(snapshot.DrainedRate, newVariableEWMA)
+	if err != nil {
+		return fmt.Errorf("invalid drained rates in multitenant stats snapshot: %w", err)
+	}
+	latencyStat, err := restoreMovingAverages(snapshot.RouterTenantLatencyStat, newVariableEWMA)
+	if err != nil {
+		return fmt.Errorf("invalid latencies in multitenant stats snapshot: %w", err)
+	}
+	latencyP95, err := restoreMovingAverages(snapshot.RouterTenantLatencyP95, func() misc.MovingAverage { return misc.NewPSquareQuantile(latencyQuantile, latencyQuantileWindow) })
+	if err != nil {
+		return fmt.Errorf("invalid p95 latencies in multitenant stats snapshot: %w", err)
+	}
+
+	routerNonTerminalCounts := snapshot.RouterNonTerminalCounts
+	if routerNonTerminalCounts == nil {
+		routerNonTerminalCounts = make(map[string]map[string]map[string]int)
+	}
+	routerInputRateSamples := snapshot.RouterInputRateSamples
+	if routerInputRateSamples == nil {
+		routerInputRateSamples = make(map[string]map[string]map[string]int)
+	}
+	lastDrainedTimestamps := snapshot.LastDrainedTimestamps
+	if lastDrainedTimestamps == nil {
+		lastDrainedTimestamps = make(map[string]map[string]time.Time)
+	}
+	for _, tableType := range []string{"router", "batch_router"} {
+		if routerNonTerminalCounts[tableType] == nil {
+			routerNonTerminalCounts[tableType] = make(map[string]map[string]int)
+		}
+		if routerInputRates[tableType] == nil {
+			routerInputRates[tableType] = make(map[string]map[string]misc.MovingAverage)
+		}
+		if routerInputRateSamples[tableType] == nil {
+			routerInputRateSamples[tableType] = make(map[string]map[string]int)
+		}
+	}
+
+	multitenantStat.routerJobCountMutex.Lock()
+	defer multitenantStat.routerJobCountMutex.Unlock()
+	multitenantStat.routerLatencyMutex.Lock()
+	defer multitenantStat.routerLatencyMutex.Unlock()
+	multitenantStat.routerSuccessRateMutex.Lock()
+	defer multitenantStat.routerSuccessRateMutex.Unlock()
+
+	multitenantStat.routerNonTerminalCounts = routerNonTerminalCounts
+	multitenantStat.routerInputRates = routerInputRates
+	multitenantStat.routerInputRateSamples = routerInputRateSamples
+	multitenantStat.lastDrainedTimestamps = lastDrainedTimestamps
+	multitenantStat.failureRate = failureRate
+	multitenantStat.drainedRate = drainedRate
+	multitenantStat.routerTenantLatencyStat = latencyStat
+	multitenantStat.routerTenantLatencyP95 = latencyP95
+	return nil
+}
+
+//restoreMovingAverages decodes the serialized moving averages into the ones constructed by newMovingAverage
+func restoreMovingAverages(serialized map[string]map[string]json.RawMessage, newMovingAverage func() misc.MovingAverage) (map[string]map[string]misc.MovingAverage, error) {
+	restored := make(map[string]map[string]misc.MovingAverage, len(serialized))
+	for outerKey, inner := range serialized {
+		restored[outerKey] = make(map[string]misc.MovingAverage, len(inner))
+		for innerKey, data := range inner {
+			movingAverage := newMovingAverage()
+			if err := json.Unmarshal(data, movingAverage); err != nil {
+				return nil, fmt.Errorf("%s/%s: %w", outerKey, innerKey, err)
+			}
+			restored[outerKey][innerKey] = movingAverage
+		}
+	}
+	return restored, nil
+}
+
 func (multitenantStat *MultitenantStatsT) UpdateWorkspaceLatencyMap(destType string, workspaceID string, val float64) {
 	multitenantStat.routerLatencyMutex.Lock()
 	defer multitenantStat.routerLatencyMutex.Unlock()
@@ -83,6 +364,99 @@ func (multitenantStat *MultitenantStatsT) UpdateWorkspaceLatencyMap(destType str
 		multitenantStat.routerTenantLatencyStat[destType][workspaceID] = misc.NewMovingAverage(misc.AVG_METRIC_AGE)
 	}
 	multitenantStat.routerTenantLatencyStat[destType][workspaceID].Add(val)
+
+	//the p95 latencies are only tracked while they are used, so the workspaces start at defaultLatency after enabling useP95Latency
+	if !useP95Latency {
+		return
+	}
+	_, ok = multitenantStat.routerTenantLatencyP95[destType]
+	if !ok {
+		multitenantStat.routerTenantLatencyP95[destType] = make(map[string]misc.MovingAverage)
+	}
+	_, ok = multitenantStat.routerTenantLatencyP95[destType][workspaceID]
+	if !ok {
+		multitenantStat.routerTenantLatencyP95[destType][workspaceID] = misc.NewPSquareQuantile(latencyQuantile, latencyQuantileWindow)
+	}
+	multitenantStat.routerTenantLatencyP95[destType][workspaceID].Add(val)
+}
+
+//UpdateEarliestJobMap replaces the created_at of the oldest pending job of the workspaces for the destination type,
+//e.g. as returned by jobsdb's GetOldestPendingPerCustomer. They are only used if Router.multitenant.earliestJobPriority is true.
+func (multitenantStat *MultitenantStatsT) UpdateEarliestJobMap(destType string, earliestJobMap map[string]time.Time) {
+	earliestJobs := make(map[string]time.Time, len(earliestJobMap))
+	for workspaceID, earliestJob := range earliestJobMap {
+		earliestJobs[workspaceID] = earliestJob
+	}
+
+	multitenantStat.routerLatencyMutex.Lock()
+	defer multitenantStat.routerLatencyMutex.Unlock()
+	multitenantStat.earliestJobTimestamps[destType] = earliestJobs
+}
+
+//hasSimilarScores tells if the scores a and b differ by at most similarScoreTolerance relative to the larger one
+func hasSimilarScores(a float64, b float64) bool {
+	return math.Abs(a-b) <= similarScoreTolerance*math.Max(math.Abs(a), math.Abs(b))
+}
+
+//hasOlderEarliestJob tells if workspace a has been waiting longer than workspace b for its oldest pending job of the destination type.
+//Workspaces without an earliest job are the last. Caller must hold routerLatencyMutex.
+func (multitenantStat *MultitenantStatsT) hasOlderEarliestJob(destType string, a string, b string) bool {
+	earliestA, okA := multitenantStat.earliestJobTimestamps[destType][a]
+	earliestB, okB := multitenantStat.earliestJobTimestamps[destType][b]
+	if !okA || !okB {
+		return okA
+	}
+	return earliestA.Before(earliestB)
+}
+
+//reconcileLatencyMap returns the latency map along with the workspaces having pending jobs of the destination type but no latency yet,
+//e.g. the newly seen ones, at defaultLatency. Otherwise their jobs would never be picked up.
+//The given map is left untouched. Caller must hold routerJobCountMutex and routerLatencyMutex.
+func (multitenantStat *MultitenantStatsT) reconcileLatencyMap(tableType string, destType string, latencyMap map[string]misc.MovingAverage) map[string]misc.MovingAverage {
+	var missingWorkspaces []string
+	for workspaceKey, destWiseMap := range multitenantStat.routerNonTerminalCounts[tableType] {
+		if destWiseMap[destType] <= 0 {
+			continue
+		}
+		if _, ok := latencyMap[workspaceKey]; !ok {
+			missingWorkspaces = append(missingWorkspaces, workspaceKey)
+		}
+	}
+	if len(missingWorkspaces) == 0 {
+		return latencyMap
+	}
+
+	reconciledLatencyMap := make(map[string]misc.MovingAverage, len(latencyMap)+len(missingWorkspaces))
+	for workspaceKey, latency := range latencyMap {
+		reconciledLatencyMap[workspaceKey] = latency
+	}
+	for _, workspaceKey := range missingWorkspaces {
+		latency := misc.NewMovingAverage()
+		latency.Set(defaultLatency)
+		reconciledLatencyMap[workspaceKey] = latency
+		pkgLogger.Debugf("Workspace : %v , destType : %v has pending jobs but no latency, using %v", workspaceKey, destType, defaultLatency)
+		statStatsMismatch(workspaceKey, destType, "latency")
+	}
+	return reconciledLatencyMap
+}
+
+//statStatsMismatch counts the workspaces with pending jobs missing the given stat in GetRouterPickupJobs
+func statStatsMismatch(workspaceID string, destType string, missing string) {
+	stats.NewTaggedStat("router_pickup_stats_mismatch", stats.CountType, stats.Tags{
+		"workspaceId": workspaceID,
+		"destType":    destType,
+		"missing":     missing,
+	}).Increment()
+}
+
+//getLatencyMap returns the workspace latencies of the destination type to size the pickups against,
+//the p95 latencies if useP95Latency is true, since the mean under-provisions when the latencies are heavy-tailed.
+//Caller must hold routerLatencyMutex.
+func (multitenantStat *MultitenantStatsT) getLatencyMap(destType string) map[string]misc.MovingAverage {
+	if useP95Latency {
+		return multitenantStat.routerTenantLatencyP95[destType]
+	}
+	return multitenantStat.routerTenantLatencyStat[destType]
 }
 
 func (multitenantStat *MultitenantStatsT) CalculateSuccessFailureCounts(workspace string, destType string, isSuccess bool, isDrained bool) {
@@ -97,6 +471,19 @@ func (multitenantStat *MultitenantStatsT) CalculateSuccessFailureCounts(workspac
 	if !ok {
 		multitenantStat.failureRate[workspace][destType] = misc.NewMovingAverage(misc.AVG_METRIC_AGE)
 	}
+	_, ok = multitenantStat.drainedRate[workspace]
+	if !ok {
+		multitenantStat.drainedRate[workspace] = make(map[string]misc.MovingAverage)
+	}
+	_, ok = multitenantStat.drainedRate[workspace][destType]
+	if !ok {
+		multitenantStat.drainedRate[workspace][destType] = misc.NewMovingAverage(misc.AVG_METRIC_AGE)
+	}
+	if isDrained {
+		multitenantStat.drainedRate[workspace][destType].Add(1)
+	} else {
+		multitenantStat.drainedRate[workspace][destType].Add(0)
+	}
 
 	if isSuccess {
 		multitenantStat.failureRate[workspace][destType].Add(0)
@@ -113,95 +500,267 @@ func (multitenantStat *MultitenantStatsT) CalculateSuccessFailureCounts(workspac
 	}
 }
 
-func (multitenantStat *MultitenantStatsT) AddToInMemoryCount(workspaceID string, destinationType string, count int, tableType string) {
+//StatLoop periodically emits the success and drained rates of every workspace and destination type as gauges.
+//It also refreshes the earliest jobs of the workspaces if Router.multitenant.earliestJobPriority is true.
+func (multitenantStat *MultitenantStatsT) StatLoop(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(statLoopInterval):
+		}
+		multitenantStat.emitRateStats()
+		multitenantStat.emitPileUpStats()
+		multitenantStat.emitInputRateStats()
+		if earliestJobPriority {
+			multitenantStat.refreshEarliestJobMaps()
+		}
+	}
+}
+
+//refreshEarliestJobMaps reads the oldest pending job of the workspaces from the router db for every destination type with pending router jobs
+func (multitenantStat *MultitenantStatsT) refreshEarliestJobMaps() {
+	destTypes := make(map[string]struct{})
 	multitenantStat.routerJobCountMutex.RLock()
-	_, ok := multitenantStat.routerNonTerminalCounts[tableType][workspaceID]
-	if !ok {
-		multitenantStat.routerJobCountMutex.RUnlock()
-		multitenantStat.routerJobCountMutex.Lock()
-		multitenantStat.routerNonTerminalCounts[tableType][workspaceID] = make(map[string]int)
-		multitenantStat.routerJobCountMutex.Unlock()
-		multitenantStat.routerJobCountMutex.RLock()
+	for _, destWiseMap := range multitenantStat.routerNonTerminalCounts["router"] {
+		for destType, count := range destWiseMap {
+			if count > 0 {
+				destTypes[destType] = struct{}{}
+			}
+		}
 	}
 	multitenantStat.routerJobCountMutex.RUnlock()
+
+	for destType := range destTypes {
+		earliestJobMap, err := multitenantStat.routerDB.GetOldestPendingPerCustomer(destType)
+		if err != nil {
+			pkgLogger.Errorf("Failed to read the earliest jobs of the workspaces for %s: %v", destType, err)
+			continue
+		}
+		multitenantStat.UpdateEarliestJobMap(destType, earliestJobMap)
+	}
+}
+
+type rateSnapshotT struct {
+	successRate float64
+	drainedRate float64
+}
+
+//getRateSnapshot returns the current success and drained rates per workspace and destination type.
+//Reading the moving averages doesn't reset them, so the pickup computation is unaffected.
+func (multitenantStat *MultitenantStatsT) getRateSnapshot() map[string]map[string]rateSnapshotT {
+	multitenantStat.routerSuccessRateMutex.RLock()
+	defer multitenantStat.routerSuccessRateMutex.RUnlock()
+
+	snapshot := make(map[string]map[string]rateSnapshotT, len(multitenantStat.failureRate))
+	for workspace := range multitenantStat.failureRate {
+		snapshot[workspace] = make(map[string]rateSnapshotT, len(multitenantStat.failureRate[workspace]))
+		for destType, failureRate := range multitenantStat.failureRate[workspace] {
+			snapshot[workspace][destType] = rateSnapshotT{
+				successRate: 1 - failureRate.Value(),
+				drainedRate: multitenantStat.drainedRate[workspace][destType].Value(),
+			}
+		}
+	}
+	return snapshot
+}
+
+//IsCustomerHealthy returns whether the destination type of the workspace is healthy, along with its success rate.
+//It is unhealthy if its success rate is below Router.multitenant.unhealthyThreshold, so that routers can skip it.
+//Workspaces without enough results for a success rate are healthy.
+//Like getRateSnapshot, reading the success rate doesn't reset it.
+func (multitenantStat *MultitenantStatsT) IsCustomerHealthy(customer string, destType string) (bool, float64) {
+	multitenantStat.routerSuccessRateMutex.RLock()
+	successRate := 1 - multitenantStat.getFailureRate(customer, destType)
+	multitenantStat.routerSuccessRateMutex.RUnlock()
+	return successRate >= unhealthyThreshold, successRate
+}
+
+//PeekSuccessRateMap returns the success rates of the workspaces with results for the destination type.
+//Like getRateSnapshot, it leaves the rates intact, so that any number of consumers can read them at their own cadence,
+//and they are only ever reset explicitly by ResetSuccessCounters.
+func (multitenantStat *MultitenantStatsT) PeekSuccessRateMap(destType string) map[string]float64 {
+	multitenantStat.routerSuccessRateMutex.RLock()
+	defer multitenantStat.routerSuccessRateMutex.RUnlock()
+
+	successRates := make(map[string]float64)
+	for workspace, destTypeRates := range multitenantStat.failureRate {
+		if failureRate, ok := destTypeRates[destType]; ok {
+			successRates[workspace] = 1 - failureRate.Value()
+		}
+	}
+	return successRates
+}
+
+//ResetSuccessCounters clears the success and drained rates of every workspace, so that they start over from the next results.
+//The last drained timestamps are kept, so that the drained workspaces stay deprioritized until their decay window passes.
+func (multitenantStat *MultitenantStatsT) ResetSuccessCounters() {
+	multitenantStat.routerSuccessRateMutex.Lock()
+	defer multitenantStat.routerSuccessRateMutex.Unlock()
+
+	multitenantStat.failureRate = make(map[string]map[string]misc.MovingAverage)
+	multitenantStat.drainedRate = make(map[string]map[string]misc.MovingAverage)
+}
+
+func (multitenantStat *MultitenantStatsT) emitRateStats() {
+	for workspace, destTypeRates := range multitenantStat.getRateSnapshot() {
+		for destType, rates := range destTypeRates {
+			tags := stats.Tags{
+				"workspaceId": workspace,
+				"destType":    destType,
+			}
+			stats.NewTaggedStat("router_success_rate", stats.GaugeType, tags).Gauge(rates.successRate)
+			stats.NewTaggedStat("router_drained_rate", stats.GaugeType, tags).Gauge(rates.drainedRate)
+		}
+	}
+}
+
+//pileUpStatNames are the names of the pile up gauges of the router and batch router jobs
+var pileUpStatNames = map[string]string{
+	"router":       "pile_up_count",
+	"batch_router": "batch_router_pile_up_count",
+}
+
+//getPileUpSnapshot returns the number of non terminal jobs of the table type per destination type, along with their total
+func (multitenantStat *MultitenantStatsT) getPileUpSnapshot(tableType string) (total int, byDestType map[string]int) {
+	multitenantStat.routerJobCountMutex.RLock()
+	defer multitenantStat.routerJobCountMutex.RUnlock()
+
+	byDestType = make(map[string]int)
+	for _, destTypeCounts := range multitenantStat.routerNonTerminalCounts[tableType] {
+		for destType, count := range destTypeCounts {
+			byDestType[destType] += count
+			total += count
+		}
+	}
+	return total, byDestType
+}
+
+//emitPileUpStats emits the pile_up_count gauge of the router jobs and the batch_router_pile_up_count gauge of the batch router jobs,
+//both in total and tagged by destination type, so that a pile up can be attributed to the destination type backed up
+func (multitenantStat *MultitenantStatsT) emitPileUpStats() {
+	for tableType, statName := range pileUpStatNames {
+		total, byDestType := multitenantStat.getPileUpSnapshot(tableType)
+		stats.NewTaggedStat(statName, stats.GaugeType, stats.Tags{}).Gauge(total)
+		for destType, count := range byDestType {
+			stats.NewTaggedStat(statName, stats.GaugeType, stats.Tags{"destType": destType}).Gauge(count)
+		}
+	}
+}
+
+//emitInputRateStats emits the input rates of every workspace and destination type, of both the router and batch router jobs
+func (multitenantStat *MultitenantStatsT) emitInputRateStats() {
+	multitenantStat.routerJobCountMutex.RLock()
+	defer multitenantStat.routerJobCountMutex.RUnlock()
+
+	for tableType, workspaceRates := range multitenantStat.routerInputRates {
+		for workspace, destTypeRates := range workspaceRates {
+			for destType, inputRate := range destTypeRates {
+				stats.NewTaggedStat("router_input_rate", stats.GaugeType, stats.Tags{
+					"workspaceId": workspace,
+					"destType":    destType,
+					"tableType":   tableType,
+				}).Gauge(inputRate.Value())
+			}
+		}
+	}
+}
+
+func (multitenantStat *MultitenantStatsT) AddToInMemoryCount(workspaceID string, destinationType string, count int, tableType string) {
 	multitenantStat.routerJobCountMutex.Lock()
-	multitenantStat.routerNonTerminalCounts[tableType][workspaceID][destinationType] += count
-	multitenantStat.routerJobCountMutex.Unlock()
+	defer multitenantStat.routerJobCountMutex.Unlock()
+	multitenantStat.addToInMemoryCount(workspaceID, destinationType, count, tableType)
 }
 
 func (multitenantStat *MultitenantStatsT) RemoveFromInMemoryCount(workspaceID string, destinationType string, count int, tableType string) {
+	multitenantStat.routerJobCountMutex.Lock()
+	defer multitenantStat.routerJobCountMutex.Unlock()
+	multitenantStat.addToInMemoryCount(workspaceID, destinationType, -1*count, tableType)
+}
+
+//GetInMemoryJobCount returns the number of non terminal jobs of the workspace and destination type
+func (multitenantStat *MultitenantStatsT) GetInMemoryJobCount(tableType string, workspaceID string, destinationType string) int {
 	multitenantStat.routerJobCountMutex.RLock()
-	_, ok := multitenantStat.routerNonTerminalCounts[tableType][workspaceID]
-	if !ok {
-		multitenantStat.routerJobCountMutex.RUnlock()
-		multitenantStat.routerJobCountMutex.Lock()
+	defer multitenantStat.routerJobCountMutex.RUnlock()
+	return multitenantStat.routerNonTerminalCounts[tableType][workspaceID][destinationType]
+}
+
+//addToInMemoryCount expects routerJobCountMutex to be write-locked
+func (multitenantStat *MultitenantStatsT) addToInMemoryCount(workspaceID string, destinationType string, count int, tableType string) {
+	if _, ok := multitenantStat.routerNonTerminalCounts[tableType][workspaceID]; !ok {
 		multitenantStat.routerNonTerminalCounts[tableType][workspaceID] = make(map[string]int)
-		multitenantStat.routerJobCountMutex.Unlock()
-		multitenantStat.routerJobCountMutex.RLock()
 	}
-	multitenantStat.routerJobCountMutex.RUnlock()
-	multitenantStat.routerJobCountMutex.Lock()
-	multitenantStat.routerNonTerminalCounts[tableType][workspaceID][destinationType] += -1 * count
-	multitenantStat.routerJobCountMutex.Unlock()
+	multitenantStat.routerNonTerminalCounts[tableType][workspaceID][destinationType] += count
 }
 
 func (multitenantStat *MultitenantStatsT) ReportProcLoopAddStats(stats map[string]map[string]int, tableType string) {
 	timeTaken := time.Since(multitenantStat.processorStageTime)
 	for key := range stats {
-		multitenantStat.routerJobCountMutex.RLock()
-		_, ok := multitenantStat.routerInputRates[tableType][key]
-		if !ok {
-			multitenantStat.routerJobCountMutex.RUnlock()
-			multitenantStat.routerJobCountMutex.Lock()
-			multitenantStat.routerInputRates[tableType][key] = make(map[string]misc.MovingAverage)
-			multitenantStat.routerJobCountMutex.Unlock()
-			multitenantStat.routerJobCountMutex.RLock()
-		}
-		multitenantStat.routerJobCountMutex.RUnlock()
 		for destType := range stats[key] {
-			multitenantStat.routerJobCountMutex.RLock()
-			_, ok := multitenantStat.routerInputRates[tableType][key][destType]
-			if !ok {
-				multitenantStat.routerJobCountMutex.RUnlock()
-				multitenantStat.routerJobCountMutex.Lock()
-				multitenantStat.routerInputRates[tableType][key][destType] = misc.NewMovingAverage()
-				multitenantStat.routerJobCountMutex.Unlock()
-				multitenantStat.routerJobCountMutex.RLock()
-			}
-			multitenantStat.routerJobCountMutex.RUnlock()
-			multitenantStat.routerInputRates[tableType][key][destType].Add((float64(stats[key][destType]) * float64(time.Second)) / float64(timeTaken))
+			multitenantStat.addInputRate(tableType, key, destType, (float64(stats[key][destType])*float64(time.Second))/float64(timeTaken))
 			multitenantStat.AddToInMemoryCount(key, destType, stats[key][destType], tableType)
 		}
 	}
-	for workspaceKey := range multitenantStat.routerInputRates[tableType] {
-		_, ok := stats[workspaceKey]
-		if !ok {
-			for destType := range stats[workspaceKey] {
-				multitenantStat.routerJobCountMutex.Lock()
-				multitenantStat.routerInputRates[tableType][workspaceKey][destType].Add(0)
-				multitenantStat.routerJobCountMutex.Unlock()
-			}
-		}
 
+	//The input rates of the workspaces without any new jobs are brought down with a zero sample
+	type workspaceDestT struct{ workspaceID, destType string }
+	var idle []workspaceDestT
+	multitenantStat.routerJobCountMutex.RLock()
+	for workspaceKey := range multitenantStat.routerInputRates[tableType] {
 		for destType := range multitenantStat.routerInputRates[tableType][workspaceKey] {
-			_, ok := stats[workspaceKey][destType]
-			if !ok {
-				multitenantStat.routerJobCountMutex.Lock()
-				multitenantStat.routerInputRates[tableType][workspaceKey][destType].Add(0)
-				multitenantStat.routerJobCountMutex.Unlock()
+			if _, ok := stats[workspaceKey][destType]; !ok {
+				idle = append(idle, workspaceDestT{workspaceID: workspaceKey, destType: destType})
 			}
 		}
 	}
+	multitenantStat.routerJobCountMutex.RUnlock()
+	for _, wd := range idle {
+		multitenantStat.addInputRate(tableType, wd.workspaceID, wd.destType, 0)
+	}
 	multitenantStat.processorStageTime = time.Now()
 }
 
+//addInputRate adds a sample to the input rate moving average and keeps count of the samples added to it
+func (multitenantStat *MultitenantStatsT) addInputRate(tableType string, workspaceID string, destType string, value float64) {
+	multitenantStat.routerJobCountMutex.Lock()
+	defer multitenantStat.routerJobCountMutex.Unlock()
+	if _, ok := multitenantStat.routerInputRates[tableType][workspaceID]; !ok {
+		multitenantStat.routerInputRates[tableType][workspaceID] = make(map[string]misc.MovingAverage)
+	}
+	if _, ok := multitenantStat.routerInputRates[tableType][workspaceID][destType]; !ok {
+		multitenantStat.routerInputRates[tableType][workspaceID][destType] = misc.NewMovingAverage()
+	}
+	multitenantStat.routerInputRates[tableType][workspaceID][destType].Add(value)
+	if _, ok := multitenantStat.routerInputRateSamples[tableType][workspaceID]; !ok {
+		multitenantStat.routerInputRateSamples[tableType][workspaceID] = make(map[string]int)
+	}
+	multitenantStat.routerInputRateSamples[tableType][workspaceID][destType]++
+}
+
+//isInputRateCold returns true if the input rate of the workspace's jobs of the table type has fewer than coldStartSamples samples,
+//as is the case right after a restart. Caller must hold routerJobCountMutex.
+func (multitenantStat *MultitenantStatsT) isInputRateCold(tableType string, workspaceID string, destType string) bool {
+	return multitenantStat.routerInputRateSamples[tableType][workspaceID][destType] < coldStartSamples
+}
+
+//GetRouterPickupJobs returns the number of router jobs to pick up per workspace, see GetPickupJobs
 func (multitenantStat *MultitenantStatsT) GetRouterPickupJobs(destType string, noOfWorkers int, routerTimeOut time.Duration, jobQueryBatchSize int, timeGained float64) (map[string]int, map[string]float64) {
+	return multitenantStat.GetPickupJobs("router", destType, noOfWorkers, routerTimeOut, jobQueryBatchSize, timeGained)
+}
+
+//GetPickupJobs returns the number of jobs of the table type, router or batch_router, to pick up per workspace for the destination type,
+//along with the latencies used for sizing the pickups. The jobs are shared fairly between the workspaces based on their
+//pending job counts and input rates of the table type, and their latencies and success rates for the destination type.
+//routerTimeOut is overridden by the timeout of the destination type, if set in Router.multitenant.routerTimeOutPerDestType.
+func (multitenantStat *MultitenantStatsT) GetPickupJobs(tableType string, destType string, noOfWorkers int, routerTimeOut time.Duration, jobQueryBatchSize int, timeGained float64) (map[string]int, map[string]float64) {
 	multitenantStat.routerJobCountMutex.RLock()
 	defer multitenantStat.routerJobCountMutex.RUnlock()
 	multitenantStat.routerLatencyMutex.RLock()
 	defer multitenantStat.routerLatencyMutex.RUnlock()
 
-	workspacesWithJobs := multitenantStat.getWorkspacesWithPendingJobs(destType, multitenantStat.routerTenantLatencyStat[destType])
+	routerTimeOut = multitenantStat.getRouterTimeOut(destType, routerTimeOut)
+	latencyMap := multitenantStat.reconcileLatencyMap(tableType, destType, multitenantStat.getLatencyMap(destType))
+	workspacesWithJobs := multitenantStat.getWorkspacesWithPendingJobs(tableType, destType, latencyMap)
 	boostedRouterTimeOut := getBoostedRouterTimeOut(routerTimeOut, timeGained, noOfWorkers)
 	//TODO: Also while allocating jobs to router workers, we need to assign so that sum of assigned jobs latency equals the timeout
 
@@ -210,87 +769,215 @@ func (multitenantStat *MultitenantStatsT) GetRouterPickupJobs(destType string, n
 	workspacePickUpCount := make(map[string]int)
 	usedLatencies := make(map[string]float64)
 
-	minLatency, maxLatency := getMinMaxWorkspaceLatency(workspacesWithJobs, multitenantStat.routerTenantLatencyStat[destType])
+	minLatency, maxLatency := getMinMaxWorkspaceLatency(workspacesWithJobs, latencyMap)
 
-	scores := multitenantStat.getSortedWorkspaceScoreList(workspacesWithJobs, maxLatency, minLatency, multitenantStat.routerTenantLatencyStat[destType], destType)
+	scores := multitenantStat.getSortedWorkspaceScoreList(workspacesWithJobs, maxLatency, minLatency, latencyMap, destType)
+	//The passes add to the pickups of the passes before them, so that the accounting holds in either order.
+	//BETA is only added for the workspaces without any pickup yet.
 	//TODO : Optimise the loop only for workspaces having jobs
 	//Latency sorted input rate pass
-	for _, scoredWorkspace := range scores {
-		workspaceKey := scoredWorkspace.workspaceId
-		workspaceCountKey, ok := multitenantStat.routerInputRates["router"][workspaceKey]
-		if ok {
-			destTypeCount, ok := workspaceCountKey[destType]
+	inRatePass := func() {
+		for _, scoredWorkspace := range scores {
+			workspaceKey := scoredWorkspace.workspaceId
+			pickedCount := workspacePickUpCount[workspaceKey]
+			remainingCount := misc.MaxInt(multitenantStat.routerNonTerminalCounts[tableType][workspaceKey][destType]-pickedCount, 0)
+			//Until the input rate has warmed up, it under estimates the jobs to be picked up.
+			//So the workspace gets an equal share of the jobs instead.
+			if multitenantStat.isInputRateCold(tableType, workspaceKey, destType) {
+				latency := latencyMap[workspaceKey].Value()
+				equalShare := misc.MaxInt(jobQueryBatchSize/len(workspacesWithJobs), 1)
+				pickUpCount := misc.MinInt(misc.MinInt(equalShare, remainingCount), misc.MaxInt(runningJobCount, 0))
+				if pickUpCount == 0 && remainingCount > 0 && pickedCount == 0 {
+					pickUpCount = 1 //Adding BETA
+				}
+				workspacePickUpCount[workspaceKey] += pickUpCount
+				usedLatencies[workspaceKey] = latency
+				runningJobCount = runningJobCount - pickUpCount
+				runningTimeCounter = runningTimeCounter - float64(pickUpCount)*latency
+				pkgLogger.Debugf("Workspace : %v , pickUpCount : %v , runningJobCount : %v , ColdStartLoop ", workspaceKey, pickUpCount, runningJobCount)
+				continue
+			}
+			workspaceCountKey, ok := multitenantStat.routerInputRates[tableType][workspaceKey]
 			if ok {
+				destTypeCount, ok := workspaceCountKey[destType]
+				if ok {
 
-				if runningJobCount <= 0 || runningTimeCounter <= 0 {
-					//Adding BETA
-					if multitenantStat.routerNonTerminalCounts["router"][workspaceKey][destType] > 0 {
-						usedLatencies[workspaceKey] = multitenantStat.routerTenantLatencyStat[destType][workspaceKey].Value()
-						workspacePickUpCount[workspaceKey] = 1
+					if runningJobCount <= 0 || runningTimeCounter <= 0 {
+						//Adding BETA
+						if remainingCount > 0 && pickedCount == 0 {
+							usedLatencies[workspaceKey] = latencyMap[workspaceKey].Value()
+							workspacePickUpCount[workspaceKey] = 1
+						}
+						continue
 					}
-					continue
-				}
-				//TODO : Get rid of unReliableLatencyORInRate hack
-				unReliableLatencyORInRate := false
-				if multitenantStat.routerTenantLatencyStat[destType][workspaceKey].Value() != 0 {
-					tmpPickCount := int(math.Min(destTypeCount.Value()*float64(routerTimeOut)/float64(time.Second), runningTimeCounter/(multitenantStat.routerTenantLatencyStat[destType][workspaceKey].Value())))
-					if tmpPickCount < 1 {
-						tmpPickCount = 1 //Adding BETA
-						pkgLogger.Debugf("[DRAIN DEBUG] %v  checking for high latency/low in rate workspace %v latency value %v in rate %v", destType, workspaceKey, multitenantStat.routerTenantLatencyStat[destType][workspaceKey].Value(), destTypeCount.Value())
-						unReliableLatencyORInRate = true
+					//Recently drained workspaces are given a fraction of their in rate, which grows back as the drain gets older
+					drainedWeight := getDrainedWeight(time.Since(multitenantStat.getLastDrainedTimestamp(workspaceKey, destType)))
+					timeGiven := drainedWeight * destTypeCount.Value() * float64(routerTimeOut) / float64(time.Second)
+					//TODO : Get rid of unReliableLatencyORInRate hack
+					unReliableLatencyORInRate := false
+					var pickUpCount int
+					if latencyMap[workspaceKey].Value() != 0 {
+						pickUpCount = int(math.Min(timeGiven, runningTimeCounter/(latencyMap[workspaceKey].Value())))
+						if pickUpCount < 1 && pickedCount == 0 {
+							pickUpCount = 1 //Adding BETA
+							pkgLogger.Debugf("[DRAIN DEBUG] %v  checking for high latency/low in rate workspace %v latency value %v in rate %v", destType, workspaceKey, latencyMap[workspaceKey].Value(), destTypeCount.Value())
+							unReliableLatencyORInRate = true
+						}
+					} else {
+						pickUpCount = int(timeGiven)
 					}
-					workspacePickUpCount[workspaceKey] = tmpPickCount
-					if workspacePickUpCount[workspaceKey] > multitenantStat.routerNonTerminalCounts["router"][workspaceKey][destType] {
-						workspacePickUpCount[workspaceKey] = misc.MaxInt(multitenantStat.routerNonTerminalCounts["router"][workspaceKey][destType], 0)
+					pickUpCount = misc.MaxInt(misc.MinInt(misc.MinInt(pickUpCount, remainingCount), runningJobCount), 0)
+					workspacePickUpCount[workspaceKey] += pickUpCount
+
+					timeRequired := float64(pickUpCount) * latencyMap[workspaceKey].Value()
+					if unReliableLatencyORInRate {
+						timeRequired = 0
 					}
-				} else {
-					workspacePickUpCount[workspaceKey] = misc.MinInt(int(destTypeCount.Value()*float64(routerTimeOut)/float64(time.Second)), multitenantStat.routerNonTerminalCounts["router"][workspaceKey][destType])
+					runningTimeCounter = runningTimeCounter - timeRequired
+					runningJobCount = runningJobCount - pickUpCount
+					usedLatencies[workspaceKey] = latencyMap[workspaceKey].Value()
+					pkgLogger.Debugf("Time Calculated : %v , Remaining Time : %v , Workspace : %v ,runningJobCount : %v , moving_average_latency : %v, routerInRare : %v ,InRateLoop ", timeRequired, runningTimeCounter, workspaceKey, runningJobCount, latencyMap[workspaceKey].Value(), destTypeCount.Value())
+					continue
 				}
+			}
+			//Without an input rate, e.g. for the pile up loaded after a restart, the workspace is only picked up in the pile up pass
+			pkgLogger.Debugf("Workspace : %v , destType : %v has pending jobs but no input rate", workspaceKey, destType)
+			statStatsMismatch(workspaceKey, destType, "inputRate")
+		}
+	}
 
-				timeRequired := float64(workspacePickUpCount[workspaceKey]) * multitenantStat.routerTenantLatencyStat[destType][workspaceKey].Value()
-				if unReliableLatencyORInRate {
-					timeRequired = 0
-				}
-				runningTimeCounter = runningTimeCounter - timeRequired
-				runningJobCount = runningJobCount - workspacePickUpCount[workspaceKey]
-				usedLatencies[workspaceKey] = multitenantStat.routerTenantLatencyStat[destType][workspaceKey].Value()
-				pkgLogger.Debugf("Time Calculated : %v , Remaining Time : %v , Workspace : %v ,runningJobCount : %v , moving_average_latency : %v, routerInRare : %v ,InRateLoop ", timeRequired, runningTimeCounter, workspaceKey, runningJobCount, multitenantStat.routerTenantLatencyStat[destType][workspaceKey].Value(), destTypeCount.Value())
+	var secondaryScores []workspaceScore
+	pileUpPass := func() {
+		//Sort by workspaces who can get to realtime quickly
+		secondaryScores = multitenantStat.getSortedWorkspaceSecondaryScoreList(tableType, workspacesWithJobs, workspacePickUpCount, destType, latencyMap)
+		for _, scoredWorkspace := range secondaryScores {
+			workspaceKey := scoredWorkspace.workspaceId
+			workspaceCountKey, ok := multitenantStat.routerNonTerminalCounts[tableType][workspaceKey]
+			if !ok || workspaceCountKey[destType]-workspacePickUpCount[workspaceKey] <= 0 {
+				continue
 			}
+			//BETA is added in the input rate pass
+			if runningJobCount <= 0 || runningTimeCounter <= 0 {
+				break
+			}
+
+			pickUpCount := getPileUpPickUpCount(latencyMap[workspaceKey].Value(), runningTimeCounter, runningJobCount, workspaceCountKey[destType]-workspacePickUpCount[workspaceKey])
+			usedLatencies[workspaceKey] = latencyMap[workspaceKey].Value()
+			workspacePickUpCount[workspaceKey] += pickUpCount
+			runningJobCount = runningJobCount - pickUpCount
+			runningTimeCounter = runningTimeCounter - float64(pickUpCount)*latencyMap[workspaceKey].Value()
+
+			pkgLogger.Debugf("Time Calculated : %v , Remaining Time : %v , Workspace : %v ,runningJobCount : %v , moving_average_latency : %v, pileUpCount : %v ,PileUpLoop ", float64(pickUpCount)*latencyMap[workspaceKey].Value(), runningTimeCounter, workspaceKey, runningJobCount, latencyMap[workspaceKey].Value(), workspaceCountKey[destType])
 		}
 	}
 
-	//Sort by workspaces who can get to realtime quickly
-	secondaryScores := multitenantStat.getSortedWorkspaceSecondaryScoreList(workspacesWithJobs, workspacePickUpCount, destType, multitenantStat.routerTenantLatencyStat[destType])
-	for _, scoredWorkspace := range secondaryScores {
-		workspaceKey := scoredWorkspace.workspaceId
-		workspaceCountKey, ok := multitenantStat.routerNonTerminalCounts["router"][workspaceKey]
-		if !ok || workspaceCountKey[destType] <= 0 {
-			continue
+	if pickupPassOrder == pileUpFirst {
+		pileUpPass()
+		inRatePass()
+	} else {
+		inRatePass()
+		pileUpPass()
+	}
+
+	//Workspaces without any input rate yet, e.g. new ones, are guaranteed coldStartPickup jobs even if the budget has run out,
+	//like BETA, so that they get picked up until their input rates build up
+	if coldStartPickup > 0 {
+		for _, scoredWorkspace := range scores {
+			workspaceKey := scoredWorkspace.workspaceId
+			if _, ok := multitenantStat.routerInputRates[tableType][workspaceKey][destType]; ok {
+				continue
+			}
+			pickedCount := workspacePickUpCount[workspaceKey]
+			remainingCount := multitenantStat.routerNonTerminalCounts[tableType][workspaceKey][destType] - pickedCount
+			pickUpCount := misc.MinInt(coldStartPickup-pickedCount, remainingCount)
+			if pickUpCount <= 0 {
+				continue
+			}
+			usedLatencies[workspaceKey] = latencyMap[workspaceKey].Value()
+			workspacePickUpCount[workspaceKey] += pickUpCount
+			runningJobCount = runningJobCount - pickUpCount
+			runningTimeCounter = runningTimeCounter - float64(pickUpCount)*latencyMap[workspaceKey].Value()
+			pkgLogger.Debugf("Workspace : %v , pickUpCount : %v raised by : %v , runningJobCount : %v , ColdStartPickupLoop ", workspaceKey, pickedCount, pickUpCount, runningJobCount)
 		}
-		//BETA already added in the above loop
-		if runningJobCount <= 0 || runningTimeCounter <= 0 {
-			break
+	}
+
+	//Clamp the workspaces to their pickup caps and redistribute the freed budget among the others
+	if len(multitenantStat.maxPickupPerWorkspace) > 0 {
+		for workspaceKey, pickUpCount := range workspacePickUpCount {
+			maxPickup, ok := multitenantStat.maxPickupPerWorkspace[workspaceKey]
+			if !ok || pickUpCount <= maxPickup {
+				continue
+			}
+			freedCount := pickUpCount - maxPickup
+			workspacePickUpCount[workspaceKey] = maxPickup
+			runningJobCount = runningJobCount + freedCount
+			runningTimeCounter = runningTimeCounter + float64(freedCount)*latencyMap[workspaceKey].Value()
+			pkgLogger.Debugf("Workspace : %v , pickUpCount : %v clamped to : %v , runningJobCount : %v , CapLoop ", workspaceKey, pickUpCount, maxPickup, runningJobCount)
 		}
 
-		pickUpCount := 0
-		if multitenantStat.routerTenantLatencyStat[destType][workspaceKey].Value() == 0 {
-			pickUpCount = misc.MinInt(workspaceCountKey[destType]-workspacePickUpCount[workspaceKey], runningJobCount)
-		} else {
-			tmpCount := int(runningTimeCounter / multitenantStat.routerTenantLatencyStat[destType][workspaceKey].Value())
-			pickUpCount = misc.MinInt(misc.MinInt(tmpCount, runningJobCount), workspaceCountKey[destType]-workspacePickUpCount[workspaceKey])
+		for _, scoredWorkspace := range secondaryScores {
+			if runningJobCount <= 0 || runningTimeCounter <= 0 {
+				break
+			}
+			workspaceKey := scoredWorkspace.workspaceId
+			remainingCount := multitenantStat.routerNonTerminalCounts[tableType][workspaceKey][destType] - workspacePickUpCount[workspaceKey]
+			if maxPickup, ok := multitenantStat.maxPickupPerWorkspace[workspaceKey]; ok {
+				remainingCount = misc.MinInt(remainingCount, maxPickup-workspacePickUpCount[workspaceKey])
+			}
+			if remainingCount <= 0 {
+				continue
+			}
+
+			pickUpCount := getPileUpPickUpCount(latencyMap[workspaceKey].Value(), runningTimeCounter, runningJobCount, remainingCount)
+			usedLatencies[workspaceKey] = latencyMap[workspaceKey].Value()
+			workspacePickUpCount[workspaceKey] += pickUpCount
+			runningJobCount = runningJobCount - pickUpCount
+			runningTimeCounter = runningTimeCounter - float64(pickUpCount)*latencyMap[workspaceKey].Value()
 		}
-		usedLatencies[workspaceKey] = multitenantStat.routerTenantLatencyStat[destType][workspaceKey].Value()
-		workspacePickUpCount[workspaceKey] += pickUpCount
-		runningJobCount = runningJobCount - pickUpCount
-		runningTimeCounter = runningTimeCounter - float64(pickUpCount)*multitenantStat.routerTenantLatencyStat[destType][workspaceKey].Value()
+	}
 
-		pkgLogger.Debugf("Time Calculated : %v , Remaining Time : %v , Workspace : %v ,runningJobCount : %v , moving_average_latency : %v, pileUpCount : %v ,PileUpLoop ", float64(pickUpCount)*multitenantStat.routerTenantLatencyStat[destType][workspaceKey].Value(), runningTimeCounter, workspaceKey, runningJobCount, multitenantStat.routerTenantLatencyStat[destType][workspaceKey].Value(), workspaceCountKey[destType])
+	//Give every workspace with pending jobs at least minPickupFloor jobs out of what is left of jobQueryBatchSize,
+	//so that none is starved once the time budget has run out. BETA can overshoot runningJobCount, so the budget is recounted.
+	if minPickupFloor > 0 {
+		floorBudget := jobQueryBatchSize
+		for _, pickUpCount := range workspacePickUpCount {
+			floorBudget -= pickUpCount
+		}
+		for _, scoredWorkspace := range scores {
+			if floorBudget <= 0 {
+				break
+			}
+			workspaceKey := scoredWorkspace.workspaceId
+			pickedCount := workspacePickUpCount[workspaceKey]
+			floorCount := minPickupFloor
+			if maxPickup, ok := multitenantStat.maxPickupPerWorkspace[workspaceKey]; ok {
+				floorCount = misc.MinInt(floorCount, maxPickup)
+			}
+			remainingCount := multitenantStat.routerNonTerminalCounts[tableType][workspaceKey][destType] - pickedCount
+			pickUpCount := misc.MinInt(misc.MinInt(floorCount-pickedCount, remainingCount), floorBudget)
+			if pickUpCount <= 0 {
+				continue
+			}
+			usedLatencies[workspaceKey] = latencyMap[workspaceKey].Value()
+			workspacePickUpCount[workspaceKey] += pickUpCount
+			floorBudget -= pickUpCount
+			pkgLogger.Debugf("Workspace : %v , pickUpCount : %v raised by : %v , floorBudget : %v , FloorLoop ", workspaceKey, pickedCount, pickUpCount, floorBudget)
+		}
 	}
 
 	return workspacePickUpCount, usedLatencies
 
 }
 
+//getPileUpPickUpCount returns the number of jobs out of remainingCount that fit in the remaining time and job count
+func getPileUpPickUpCount(latency float64, runningTimeCounter float64, runningJobCount int, remainingCount int) int {
+	if latency == 0 {
+		return misc.MinInt(remainingCount, runningJobCount)
+	}
+	tmpCount := int(runningTimeCounter / latency)
+	return misc.MinInt(misc.MinInt(tmpCount, runningJobCount), remainingCount)
+}
+
 func (multitenantStat *MultitenantStatsT) getFailureRate(workspaceKey string, destType string) float64 {
 	_, ok := multitenantStat.failureRate[workspaceKey]
 	if ok {
@@ -316,10 +1003,10 @@ func (multitenantStat *MultitenantStatsT) getLastDrainedTimestamp(workspaceKey s
 	return lastDrainedTS
 }
 
-func (multitenantStat *MultitenantStatsT) getWorkspacesWithPendingJobs(destType string, latencyMap map[string]misc.MovingAverage) []string {
+func (multitenantStat *MultitenantStatsT) getWorkspacesWithPendingJobs(tableType string, destType string, latencyMap map[string]misc.MovingAverage) []string {
 	workspacesWithJobs := make([]string, 0)
 	for workspaceKey := range latencyMap {
-		destWiseMap, ok := multitenantStat.routerNonTerminalCounts["router"][workspaceKey]
+		destWiseMap, ok := multitenantStat.routerNonTerminalCounts[tableType][workspaceKey]
 		if ok {
 			val, ok := destWiseMap[destType]
 			if ok && val > 0 {
@@ -330,6 +1017,24 @@ func (multitenantStat *MultitenantStatsT) getWorkspacesWithPendingJobs(destType
 	return workspacesWithJobs
 }
 
+//getDrainedWeight returns a weight in [0, 1] for a workspace whose jobs were last drained sinceLastDrained ago.
+//The weight ramps up from 0 right after a drain to 1 once drainedDecayWindow has passed,
+//either linearly, exponentially or as a step at the end of the window depending on drainedDecayShape.
+func getDrainedWeight(sinceLastDrained time.Duration) float64 {
+	if drainedDecayWindow <= 0 || sinceLastDrained >= drainedDecayWindow {
+		return 1
+	}
+	if sinceLastDrained <= 0 || drainedDecayShape == stepDecay {
+		return 0
+	}
+
+	elapsedFraction := float64(sinceLastDrained) / float64(drainedDecayWindow)
+	if drainedDecayShape == exponentialDecay {
+		return (1 - math.Exp(-exponentialDecayRate*elapsedFraction)) / (1 - math.Exp(-exponentialDecayRate))
+	}
+	return elapsedFraction
+}
+
 func getBoostedRouterTimeOut(routerTimeOut time.Duration, timeGained float64, noOfWorkers int) time.Duration {
 	//Add 30% to the time interval as exact difference leads to a catchup scenario, but this may cause to give some priority to pileup in the inrate pass
 	//boostedRouterTimeOut := 3 * time.Second //time.Duration(1.3 * float64(routerTimeOut))
@@ -362,29 +1067,38 @@ func (multitenantStat *MultitenantStatsT) getSortedWorkspaceScoreList(workspaces
 			latencyScore = (latencyMap[workspaceKey].Value() - minLatency) / (maxLatency - minLatency)
 		}
 
-		isDraining := 0.0
-		if time.Since(multitenantStat.getLastDrainedTimestamp(workspaceKey, destType)) < 100*time.Second {
-			isDraining = 1.0
-		}
+		isDraining := 1 - getDrainedWeight(time.Since(multitenantStat.getLastDrainedTimestamp(workspaceKey, destType)))
 
 		scores[i].score = latencyScore + 100*isDraining
 		scores[i].workspaceId = workspaceKey
+		scores[i].latency = latencyMap[workspaceKey].Value()
+		scores[i].drainingScore = 100 * isDraining
 	}
 
 	sort.Slice(scores, func(i, j int) bool {
+		//the latency scores are normalized over the workspaces, so the similarity is of their latencies
+		similar := hasSimilarScores(scores[i].latency, scores[j].latency) && hasSimilarScores(scores[i].drainingScore, scores[j].drainingScore)
+		if earliestJobPriority && similar {
+			if multitenantStat.hasOlderEarliestJob(destType, scores[i].workspaceId, scores[j].workspaceId) {
+				return true
+			}
+			if multitenantStat.hasOlderEarliestJob(destType, scores[j].workspaceId, scores[i].workspaceId) {
+				return false
+			}
+		}
 		return scores[i].score < scores[j].score
 	})
 	return scores
 }
 
-func (multitenantStat *MultitenantStatsT) getSortedWorkspaceSecondaryScoreList(workspacesWithJobs []string, workspacePickUpCount map[string]int, destType string, latencyMap map[string]misc.MovingAverage) []workspaceScore {
+func (multitenantStat *MultitenantStatsT) getSortedWorkspaceSecondaryScoreList(tableType string, workspacesWithJobs []string, workspacePickUpCount map[string]int, destType string, latencyMap map[string]misc.MovingAverage) []workspaceScore {
 	//Sort by workspaces who can get to realtime quickly
 	scores := make([]workspaceScore, len(workspacesWithJobs))
 	for i, workspaceKey := range workspacesWithJobs {
 		scores[i] = workspaceScore{}
 		scores[i].workspaceId = workspaceKey
 
-		workspaceCountKey, ok := multitenantStat.routerNonTerminalCounts["router"][workspaceKey]
+		workspaceCountKey, ok := multitenantStat.routerNonTerminalCounts[tableType][workspaceKey]
 		if !ok || workspaceCountKey[destType]-workspacePickUpCount[workspaceKey] <= 0 {
 			scores[i].score = math.MaxFloat64
 			scores[i].secondary_score = 0
@@ -402,6 +1116,14 @@ func (multitenantStat *MultitenantStatsT) getSortedWorkspaceSecondaryScoreList(w
 		if scores[i].score == math.MaxFloat64 && scores[j].score == math.MaxFloat64 {
 			return scores[i].secondary_score < scores[j].secondary_score
 		}
+		if earliestJobPriority && hasSimilarScores(scores[i].score, scores[j].score) {
+			if multitenantStat.hasOlderEarliestJob(destType, scores[i].workspaceId, scores[j].workspaceId) {
+				return true
+			}
+			if multitenantStat.hasOlderEarliestJob(destType, scores[j].workspaceId, scores[i].workspaceId) {
+				return false
+			}
+		}
 		return scores[i].score < scores[j].score
 	})
 	return scores
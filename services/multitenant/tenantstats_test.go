@@ -1,7 +1,9 @@
 package multitenant
 
 import (
+	"errors"
 	"math/rand"
+	"sort"
 	"sync"
 	"sync/atomic"
 	"testing"
@@ -13,6 +15,7 @@ import (
 	. "github.com/onsi/gomega"
 	"github.com/rudderlabs/rudder-server/config"
 	mocksJobsDB "github.com/rudderlabs/rudder-server/mocks/jobsdb"
+	"github.com/rudderlabs/rudder-server/services/stats"
 	"github.com/rudderlabs/rudder-server/utils/logger"
 	"github.com/rudderlabs/rudder-server/utils/misc"
 	"github.com/stretchr/testify/require"
@@ -39,6 +42,7 @@ var _ = Describe("tenantStats", func() {
 	BeforeEach(func() {
 		config.Load()
 		logger.Init()
+		stats.Setup()
 		Init()
 	})
 
@@ -82,6 +86,90 @@ var _ = Describe("tenantStats", func() {
 			Expect(tenantStats.routerTenantLatencyStat[destType1][workspaceID2].Value()).To(Equal(2.0))
 		})
 
+		It("Should snapshot the success and drained rates without resetting them", func() {
+			for i := 0; i < int(misc.AVG_METRIC_AGE); i++ {
+				tenantStats.CalculateSuccessFailureCounts(workspaceID1, destType1, true, false)
+				tenantStats.CalculateSuccessFailureCounts(workspaceID2, destType1, false, true)
+			}
+
+			snapshot := tenantStats.getRateSnapshot()
+			Expect(snapshot[workspaceID1][destType1]).To(Equal(rateSnapshotT{successRate: 1, drainedRate: 0}))
+			Expect(snapshot[workspaceID2][destType1]).To(Equal(rateSnapshotT{successRate: 1, drainedRate: 1}))
+			Expect(tenantStats.getRateSnapshot()).To(Equal(snapshot))
+			Expect(tenantStats.getFailureRate(workspaceID1, destType1)).To(Equal(0.0))
+		})
+
+		It("Should keep the success counters when peeking at the success rates", func() {
+			for i := 0; i < int(misc.AVG_METRIC_AGE); i++ {
+				tenantStats.CalculateSuccessFailureCounts(workspaceID1, destType1, true, false)
+				tenantStats.CalculateSuccessFailureCounts(workspaceID2, destType1, false, false)
+				tenantStats.CalculateSuccessFailureCounts(workspaceID1, "S3", false, false)
+			}
+
+			successRates := tenantStats.PeekSuccessRateMap(destType1)
+			Expect(successRates).To(Equal(map[string]float64{workspaceID1: 1, workspaceID2: 0}))
+			Expect(tenantStats.PeekSuccessRateMap(destType1)).To(Equal(successRates))
+			Expect(tenantStats.PeekSuccessRateMap("S3")).To(Equal(map[string]float64{workspaceID1: 0}))
+			Expect(tenantStats.getFailureRate(workspaceID2, destType1)).To(Equal(1.0))
+		})
+
+		It("Should only reset the success counters explicitly", func() {
+			for i := 0; i < int(misc.AVG_METRIC_AGE); i++ {
+				tenantStats.CalculateSuccessFailureCounts(workspaceID1, destType1, false, false)
+				tenantStats.CalculateSuccessFailureCounts(workspaceID2, destType1, false, true)
+			}
+			Expect(tenantStats.PeekSuccessRateMap(destType1)).To(Equal(map[string]float64{workspaceID1: 0, workspaceID2: 1}))
+
+			tenantStats.ResetSuccessCounters()
+			Expect(tenantStats.PeekSuccessRateMap(destType1)).To(BeEmpty())
+			Expect(tenantStats.getRateSnapshot()).To(BeEmpty())
+			Expect(tenantStats.getLastDrainedTimestamp(workspaceID2, destType1)).NotTo(BeZero())
+
+			tenantStats.CalculateSuccessFailureCounts(workspaceID1, destType1, true, false)
+			Expect(tenantStats.PeekSuccessRateMap(destType1)).To(HaveKey(workspaceID1))
+		})
+
+		It("Should emit the pile up counts in total and per destination type", func() {
+			recorder := &gaugeRecordingStats{Stats: stats.DefaultStats}
+			stats.DefaultStats = recorder
+			defer func() { stats.DefaultStats = recorder.Stats }()
+			tenantStats.AddToInMemoryCount(workspaceID1, destType1, 3, "router")
+			tenantStats.AddToInMemoryCount(workspaceID2, destType1, 4, "router")
+			tenantStats.AddToInMemoryCount(workspaceID2, "AM", 5, "router")
+			tenantStats.RemoveFromInMemoryCount(workspaceID1, "AM", 1, "router")
+			tenantStats.AddToInMemoryCount(workspaceID3, destType1, 100, "batch_router")
+
+			tenantStats.emitPileUpStats()
+			Expect(recorder.gauges).To(Equal(map[string]interface{}{
+				"pile_up_count":                                    11,
+				"pile_up_count,destType=" + destType1:              7,
+				"pile_up_count,destType=AM":                        4,
+				"batch_router_pile_up_count":                       100,
+				"batch_router_pile_up_count,destType=" + destType1: 100,
+			}))
+		})
+
+		It("Should emit the batch router pile up counts and input rates separately from the router ones", func() {
+			recorder := &gaugeRecordingStats{Stats: stats.DefaultStats}
+			stats.DefaultStats = recorder
+			defer func() { stats.DefaultStats = recorder.Stats }()
+			tenantStats.ReportProcLoopAddStats(map[string]map[string]int{
+				workspaceID1: {"S3": 6},
+				workspaceID2: {"S3": 2, "GCS": 3},
+			}, "batch_router")
+			tenantStats.RemoveFromInMemoryCount(workspaceID2, "S3", 1, "batch_router")
+
+			tenantStats.emitPileUpStats()
+			tenantStats.emitInputRateStats()
+			Expect(recorder.gauges).To(HaveKeyWithValue("pile_up_count", 0))
+			Expect(recorder.gauges).To(HaveKeyWithValue("batch_router_pile_up_count", 10))
+			Expect(recorder.gauges).To(HaveKeyWithValue("batch_router_pile_up_count,destType=S3", 7))
+			Expect(recorder.gauges).To(HaveKeyWithValue("batch_router_pile_up_count,destType=GCS", 3))
+			Expect(recorder.gauges).To(HaveKey("router_input_rate,destType=S3,tableType=batch_router,workspaceId=" + workspaceID1))
+			Expect(recorder.gauges).To(HaveKey("router_input_rate,destType=GCS,tableType=batch_router,workspaceId=" + workspaceID2))
+			Expect(recorder.gauges).NotTo(HaveKey("router_input_rate,destType=S3,tableType=router,workspaceId=" + workspaceID1))
+		})
+
 		It("Calculate Success Failure Counts , Drain Map Check", func() {
 			tenantStats.CalculateSuccessFailureCounts(workspaceID1, destType1, false, true)
 
@@ -129,6 +217,32 @@ var _ = Describe("tenantStats", func() {
 			Expect(tenantStats.routerNonTerminalCounts["router"][workspaceID2][destType1]).To(Equal(addJobWID2))
 		})
 
+		It("Should not lose concurrent updates to the InMemory Counts", func() {
+			const writers, updates = 10, 100
+			g := errgroup.Group{}
+			for i := 0; i < writers; i++ {
+				g.Go(func() error {
+					for j := 0; j < updates; j++ {
+						tenantStats.AddToInMemoryCount(workspaceID1, destType1, 2, "router")
+						tenantStats.RemoveFromInMemoryCount(workspaceID1, destType1, 1, "router")
+						tenantStats.GetInMemoryJobCount("router", workspaceID1, destType1)
+					}
+					return nil
+				})
+			}
+			g.Go(func() error {
+				for j := 0; j < updates; j++ {
+					tenantStats.ReportProcLoopAddStats(map[string]map[string]int{workspaceID2: {destType1: 1}}, "router")
+				}
+				return nil
+			})
+			Expect(g.Wait()).To(Succeed())
+
+			Expect(tenantStats.GetInMemoryJobCount("router", workspaceID1, destType1)).To(Equal(writers * updates))
+			Expect(tenantStats.GetInMemoryJobCount("router", workspaceID2, destType1)).To(Equal(updates))
+			Expect(tenantStats.GetInMemoryJobCount("router", workspaceID3, destType1)).To(Equal(0))
+		})
+
 		It("Should Correctly Calculate the Router PickUp Jobs", func() {
 			addJobWID1 := rand.Intn(2000)
 			addJobWID2 := rand.Intn(2000)
@@ -176,6 +290,452 @@ var _ = Describe("tenantStats", func() {
 			Expect(routerPickUpJobs[workspaceID3]).To(Equal(1))
 			Expect(usedLatencies[workspaceID1]).To(Equal(1.0))
 		})
+
+		It("Should increase the drained weight monotonically over the decay window", func() {
+			initialShape := drainedDecayShape
+			defer func() { drainedDecayShape = initialShape }()
+
+			for _, shape := range []string{linearDecay, exponentialDecay} {
+				drainedDecayShape = shape
+				Expect(getDrainedWeight(0)).To(Equal(0.0))
+				previousWeight := 0.0
+				for elapsed := drainedDecayWindow / 10; elapsed <= drainedDecayWindow; elapsed += drainedDecayWindow / 10 {
+					weight := getDrainedWeight(elapsed)
+					Expect(weight).To(BeNumerically(">", previousWeight), "shape: %s, elapsed: %v", shape, elapsed)
+					Expect(weight).To(BeNumerically("<=", 1.0))
+					previousWeight = weight
+				}
+				Expect(getDrainedWeight(drainedDecayWindow)).To(Equal(1.0))
+				Expect(getDrainedWeight(time.Since(time.Time{}))).To(Equal(1.0))
+			}
+		})
+
+		It("Should pick more jobs for a drained workspace as the time since the drain grows", func() {
+			initialShape := drainedDecayShape
+			defer func() { drainedDecayShape = initialShape }()
+
+			//in rate of 100 jobs/sec, so that the in rate pass picks up to 1000 jobs in a router timeout
+			tenantStats.processorStageTime = time.Now().Add(-routerTimeOut)
+			tenantStats.ReportProcLoopAddStats(map[string]map[string]int{workspaceID1: {destType1: 1000}}, "router")
+			tenantStats.AddToInMemoryCount(workspaceID1, destType1, 10000, "router")
+			tenantStats.UpdateWorkspaceLatencyMap(destType1, workspaceID1, 0)
+			//workspaceID2 only has a pile up, which comes before the failing workspaceID1 in the pile up pass and takes the rest of the batch
+			for i := 0; i < int(misc.AVG_METRIC_AGE); i++ {
+				tenantStats.CalculateSuccessFailureCounts(workspaceID1, destType1, false, false)
+			}
+			tenantStats.AddToInMemoryCount(workspaceID2, destType1, 10000, "router")
+			tenantStats.UpdateWorkspaceLatencyMap(destType1, workspaceID2, 0)
+
+			pickUpAt := func(sinceLastDrained time.Duration) int {
+				tenantStats.lastDrainedTimestamps[workspaceID1] = map[string]time.Time{destType1: time.Now().Add(-sinceLastDrained)}
+				routerPickUpJobs, _ := tenantStats.GetRouterPickupJobs(destType1, noOfWorkers, routerTimeOut, 1000, timeGained)
+				return routerPickUpJobs[workspaceID1]
+			}
+			sinceLastDrained := []time.Duration{drainedDecayWindow / 10, drainedDecayWindow / 2, drainedDecayWindow * 9 / 10}
+
+			for _, shape := range []string{linearDecay, exponentialDecay} {
+				drainedDecayShape = shape
+				previousPickUp := 0
+				for _, since := range sinceLastDrained {
+					pickUp := pickUpAt(since)
+					Expect(pickUp).To(BeNumerically(">", previousPickUp), "shape: %s, since last drained: %v", shape, since)
+					Expect(pickUp).To(BeNumerically("<", 1000), "shape: %s, since last drained: %v", shape, since)
+					previousPickUp = pickUp
+				}
+				Expect(pickUpAt(drainedDecayWindow)).To(BeNumerically("~", 1000, 1))
+			}
+
+			drainedDecayShape = stepDecay
+			for _, since := range sinceLastDrained {
+				Expect(pickUpAt(since)).To(Equal(0), "since last drained: %v", since)
+			}
+			Expect(pickUpAt(drainedDecayWindow)).To(BeNumerically("~", 1000, 1))
+		})
+
+		It("Should pick fewer jobs for a recently drained workspace", func() {
+			input := map[string]map[string]int{
+				workspaceID1: {destType1: 1000},
+				workspaceID2: {destType1: 1000},
+			}
+			tenantStats.ReportProcLoopAddStats(input, "router")
+			tenantStats.UpdateWorkspaceLatencyMap(destType1, workspaceID1, 0)
+			tenantStats.UpdateWorkspaceLatencyMap(destType1, workspaceID2, 0)
+			tenantStats.CalculateSuccessFailureCounts(workspaceID1, destType1, false, true)
+
+			routerPickUpJobs, _ := tenantStats.GetRouterPickupJobs(destType1, noOfWorkers, routerTimeOut, 1000, timeGained)
+			Expect(routerPickUpJobs[workspaceID1]).To(BeNumerically("<", routerPickUpJobs[workspaceID2]))
+		})
+
+		It("Should clamp the pickup of capped workspaces and give the rest to others", func() {
+			//workspaceID1 takes the whole in rate pass, while the others only have pile ups
+			tenantStats.ReportProcLoopAddStats(map[string]map[string]int{workspaceID1: {destType1: 1000}}, "router")
+			tenantStats.AddToInMemoryCount(workspaceID2, destType1, 1000, "router")
+			tenantStats.AddToInMemoryCount(workspaceID3, destType1, 1000, "router")
+			tenantStats.UpdateWorkspaceLatencyMap(destType1, workspaceID1, 0)
+			tenantStats.UpdateWorkspaceLatencyMap(destType1, workspaceID2, 0)
+			tenantStats.UpdateWorkspaceLatencyMap(destType1, workspaceID3, 0)
+			tenantStats.maxPickupPerWorkspace = parseMaxPickupPerWorkspace([]string{workspaceID1 + ":100"})
+
+			routerPickUpJobs, _ := tenantStats.GetRouterPickupJobs(destType1, noOfWorkers, routerTimeOut, 1500, timeGained)
+			Expect(routerPickUpJobs[workspaceID1]).To(Equal(100))
+			Expect(routerPickUpJobs[workspaceID2] + routerPickUpJobs[workspaceID3]).To(Equal(1400))
+		})
+
+		It("Should distribute jobs equally among workspaces until the input rates warm up", func() {
+			initialColdStartSamples := coldStartSamples
+			defer func() { coldStartSamples = initialColdStartSamples }()
+			coldStartSamples = 5
+
+			input := map[string]map[string]int{
+				workspaceID1: {destType1: 1000},
+				workspaceID2: {destType1: 1000},
+			}
+			for i := 0; i < coldStartSamples-1; i++ {
+				tenantStats.ReportProcLoopAddStats(input, "router")
+			}
+			tenantStats.UpdateWorkspaceLatencyMap(destType1, workspaceID1, 1)
+			tenantStats.UpdateWorkspaceLatencyMap(destType1, workspaceID2, 1)
+			Expect(tenantStats.routerInputRateSamples["router"][workspaceID1][destType1]).To(Equal(coldStartSamples - 1))
+
+			routerPickUpJobs, _ := tenantStats.GetRouterPickupJobs(destType1, noOfWorkers, routerTimeOut, 100, timeGained)
+			Expect(routerPickUpJobs[workspaceID1]).To(Equal(50))
+			Expect(routerPickUpJobs[workspaceID2]).To(Equal(50))
+
+			tenantStats.ReportProcLoopAddStats(input, "router")
+			Expect(tenantStats.isInputRateCold("router", workspaceID1, destType1)).To(BeFalse())
+			Expect(tenantStats.isInputRateCold("router", workspaceID2, destType1)).To(BeFalse())
+		})
+
+		It("Should size the pickups with the router timeout of the destination type", func() {
+			tenantStats.routerTimeOutPerDestType = parseRouterTimeOutPerDestType([]string{"RS:60s", "invalid", "BQ:-1s"})
+			Expect(tenantStats.routerTimeOutPerDestType).To(Equal(map[string]time.Duration{"RS": 60 * time.Second}))
+
+			input := map[string]map[string]int{
+				workspaceID1: {destType1: 10000, "RS": 10000},
+			}
+			tenantStats.ReportProcLoopAddStats(input, "router")
+			for i := 0; i < int(misc.AVG_METRIC_AGE); i++ {
+				tenantStats.UpdateWorkspaceLatencyMap(destType1, workspaceID1, 1)
+				tenantStats.UpdateWorkspaceLatencyMap("RS", workspaceID1, 1)
+			}
+
+			//With a single worker and a latency of a second, the pickups are bound by the boosted timeout of 1.3 times the router timeout
+			routerPickUpJobs, _ := tenantStats.GetRouterPickupJobs(destType1, 1, routerTimeOut, jobQueryBatchSize, timeGained)
+			Expect(routerPickUpJobs[workspaceID1]).To(BeNumerically("~", 13, 1))
+			routerPickUpJobs, _ = tenantStats.GetRouterPickupJobs("RS", 1, routerTimeOut, jobQueryBatchSize, timeGained)
+			Expect(routerPickUpJobs[workspaceID1]).To(BeNumerically("~", 78, 1))
+		})
+
+		It("Should allocate the batch router jobs from the batch router counts and input rates", func() {
+			input := map[string]map[string]int{
+				workspaceID1: {"S3": 30},
+				workspaceID2: {"S3": 20},
+			}
+			tenantStats.ReportProcLoopAddStats(input, "batch_router")
+			tenantStats.UpdateWorkspaceLatencyMap("S3", workspaceID1, 0)
+			tenantStats.UpdateWorkspaceLatencyMap("S3", workspaceID2, 0)
+
+			batchRouterPickUpJobs, _ := tenantStats.GetPickupJobs("batch_router", "S3", noOfWorkers, routerTimeOut, jobQueryBatchSize, timeGained)
+			Expect(batchRouterPickUpJobs).To(Equal(map[string]int{workspaceID1: 30, workspaceID2: 20}))
+
+			routerPickUpJobs, _ := tenantStats.GetRouterPickupJobs("S3", noOfWorkers, routerTimeOut, jobQueryBatchSize, timeGained)
+			Expect(routerPickUpJobs).To(BeEmpty())
+		})
+
+		It("Should never pick up more than the batch size in either pass order", func() {
+			initialPickupPassOrder := pickupPassOrder
+			defer func() { pickupPassOrder = initialPickupPassOrder }()
+
+			input := map[string]map[string]int{
+				workspaceID1: {destType1: 1000},
+				workspaceID2: {destType1: 1000},
+			}
+			tenantStats.ReportProcLoopAddStats(input, "router")
+			for i := 0; i < int(misc.AVG_METRIC_AGE); i++ {
+				tenantStats.UpdateWorkspaceLatencyMap(destType1, workspaceID1, 0.01)
+				tenantStats.UpdateWorkspaceLatencyMap(destType1, workspaceID2, 0.02)
+			}
+
+			for _, order := range []string{inRateFirst, pileUpFirst} {
+				pickupPassOrder = order
+				for _, batchSize := range []int{1001, 1500, 2000, 5000} {
+					routerPickUpJobs, _ := tenantStats.GetRouterPickupJobs(destType1, noOfWorkers, routerTimeOut, batchSize, timeGained)
+					total := 0
+					for workspaceKey, pickUpCount := range routerPickUpJobs {
+						Expect(pickUpCount).To(BeNumerically("<=", input[workspaceKey][destType1]), "order %s, batch size %d", order, batchSize)
+						total += pickUpCount
+					}
+					Expect(total).To(BeNumerically("<=", batchSize), "order %s, batch size %d", order, batchSize)
+					Expect(total).To(Equal(misc.MinInt(batchSize, 2000)), "order %s, batch size %d", order, batchSize)
+				}
+			}
+		})
+
+		It("Should give every workspace with pending jobs the pickup floor out of the remaining batch size", func() {
+			initialMinPickupFloor := minPickupFloor
+			defer func() { minPickupFloor = initialMinPickupFloor }()
+
+			//Pile ups without any input rate, e.g. after a restart, are only picked up in the pile up pass,
+			//where the first workspace exhausts the time budget of the single worker
+			tenantStats.AddToInMemoryCount(workspaceID1, destType1, 1000, "router")
+			tenantStats.AddToInMemoryCount(workspaceID2, destType1, 1000, "router")
+			for i := 0; i < int(misc.AVG_METRIC_AGE); i++ {
+				tenantStats.UpdateWorkspaceLatencyMap(destType1, workspaceID1, 1)
+				tenantStats.UpdateWorkspaceLatencyMap(destType1, workspaceID2, 1)
+			}
+
+			minPickupFloor = 0
+			routerPickUpJobs, _ := tenantStats.GetRouterPickupJobs(destType1, 1, routerTimeOut, 100, timeGained)
+			Expect(misc.MinInt(routerPickUpJobs[workspaceID1], routerPickUpJobs[workspaceID2])).To(Equal(0))
+
+			minPickupFloor = 5
+			routerPickUpJobs, usedLatencies := tenantStats.GetRouterPickupJobs(destType1, 1, routerTimeOut, 100, timeGained)
+			Expect(misc.MinInt(routerPickUpJobs[workspaceID1], routerPickUpJobs[workspaceID2])).To(Equal(5))
+			Expect(usedLatencies).To(HaveLen(2))
+
+			//The floor never goes beyond the batch size
+			routerPickUpJobs, _ = tenantStats.GetRouterPickupJobs(destType1, 1, routerTimeOut, 15, timeGained)
+			Expect(routerPickUpJobs[workspaceID1] + routerPickUpJobs[workspaceID2]).To(Equal(15))
+			Expect(misc.MinInt(routerPickUpJobs[workspaceID1], routerPickUpJobs[workspaceID2])).To(BeNumerically(">", 0))
+		})
+
+		It("Should prioritize the workspace with the oldest waiting job among similarly scored ones", func() {
+			initialEarliestJobPriority := earliestJobPriority
+			defer func() { earliestJobPriority = initialEarliestJobPriority }()
+			earliestJobPriority = true
+
+			//Equal pile ups and similar latencies, where the first workspace of the pile up pass exhausts the time budget of the single worker
+			tenantStats.AddToInMemoryCount(workspaceID1, destType1, 1000, "router")
+			tenantStats.AddToInMemoryCount(workspaceID2, destType1, 1000, "router")
+			for i := 0; i < int(misc.AVG_METRIC_AGE); i++ {
+				tenantStats.UpdateWorkspaceLatencyMap(destType1, workspaceID1, 1)
+				tenantStats.UpdateWorkspaceLatencyMap(destType1, workspaceID2, 1.02)
+			}
+
+			now := time.Now()
+			tenantStats.UpdateEarliestJobMap(destType1, map[string]time.Time{workspaceID1: now.Add(-time.Minute), workspaceID2: now.Add(-time.Hour)})
+			routerPickUpJobs, _ := tenantStats.GetRouterPickupJobs(destType1, 1, routerTimeOut, 100, timeGained)
+			Expect(routerPickUpJobs[workspaceID2]).To(BeNumerically(">", 0))
+			Expect(routerPickUpJobs[workspaceID1]).To(Equal(0))
+
+			tenantStats.UpdateEarliestJobMap(destType1, map[string]time.Time{workspaceID1: now.Add(-time.Hour), workspaceID2: now.Add(-time.Minute)})
+			routerPickUpJobs, _ = tenantStats.GetRouterPickupJobs(destType1, 1, routerTimeOut, 100, timeGained)
+			Expect(routerPickUpJobs[workspaceID1]).To(BeNumerically(">", 0))
+			Expect(routerPickUpJobs[workspaceID2]).To(Equal(0))
+
+			//A workspace without a pending job timestamp comes after the ones having one
+			tenantStats.UpdateEarliestJobMap(destType1, map[string]time.Time{workspaceID2: now})
+			routerPickUpJobs, _ = tenantStats.GetRouterPickupJobs(destType1, 1, routerTimeOut, 100, timeGained)
+			Expect(routerPickUpJobs[workspaceID2]).To(BeNumerically(">", 0))
+			Expect(routerPickUpJobs[workspaceID1]).To(Equal(0))
+
+			//Workspaces whose latencies aren't similar keep their latency order
+			for i := 0; i < int(misc.AVG_METRIC_AGE); i++ {
+				tenantStats.UpdateWorkspaceLatencyMap(destType1, workspaceID2, 2)
+			}
+			routerPickUpJobs, _ = tenantStats.GetRouterPickupJobs(destType1, 1, routerTimeOut, 100, timeGained)
+			Expect(routerPickUpJobs[workspaceID1]).To(BeNumerically(">", 0))
+			Expect(routerPickUpJobs[workspaceID2]).To(Equal(0))
+		})
+
+		It("Should refresh the earliest jobs of the destination types with pending jobs from the router db", func() {
+			tenantStats.AddToInMemoryCount(workspaceID1, destType1, 10, "router")
+			tenantStats.AddToInMemoryCount(workspaceID2, "AM", 10, "batch_router")
+
+			now := time.Now()
+			mockRouterJobsDB.EXPECT().GetOldestPendingPerCustomer(destType1).Return(map[string]time.Time{workspaceID1: now}, nil).Times(1)
+			tenantStats.refreshEarliestJobMaps()
+			Expect(tenantStats.earliestJobTimestamps).To(Equal(map[string]map[string]time.Time{destType1: {workspaceID1: now}}))
+
+			//The earliest jobs are kept if reading them fails
+			mockRouterJobsDB.EXPECT().GetOldestPendingPerCustomer(destType1).Return(nil, errors.New("query timed out")).Times(1)
+			tenantStats.refreshEarliestJobMaps()
+			Expect(tenantStats.earliestJobTimestamps).To(Equal(map[string]map[string]time.Time{destType1: {workspaceID1: now}}))
+		})
+
+		It("Should give the workspaces without any input rate the cold start pickup", func() {
+			initialColdStartPickup := coldStartPickup
+			defer func() { coldStartPickup = initialColdStartPickup }()
+
+			//workspaceID1 uses up the time budget of the single worker, while the new workspaceID2 has no history at all
+			tenantStats.ReportProcLoopAddStats(map[string]map[string]int{workspaceID1: {destType1: 1000}}, "router")
+			tenantStats.UpdateWorkspaceLatencyMap(destType1, workspaceID1, 1)
+			tenantStats.AddToInMemoryCount(workspaceID2, destType1, 100, "router")
+			Expect(tenantStats.routerInputRates["router"]).NotTo(HaveKey(workspaceID2))
+
+			coldStartPickup = 0
+			routerPickUpJobs, _ := tenantStats.GetRouterPickupJobs(destType1, 1, routerTimeOut, 100, timeGained)
+			Expect(routerPickUpJobs[workspaceID2]).To(Equal(0))
+
+			coldStartPickup = 7
+			routerPickUpJobs, usedLatencies := tenantStats.GetRouterPickupJobs(destType1, 1, routerTimeOut, 100, timeGained)
+			Expect(routerPickUpJobs[workspaceID2]).To(Equal(7))
+			Expect(usedLatencies[workspaceID2]).To(Equal(defaultLatency))
+			Expect(routerPickUpJobs[workspaceID1]).To(BeNumerically(">", 0))
+
+			//The cold start pickup doesn't go beyond the pending jobs
+			tenantStats.RemoveFromInMemoryCount(workspaceID2, destType1, 97, "router")
+			routerPickUpJobs, _ = tenantStats.GetRouterPickupJobs(destType1, 1, routerTimeOut, 100, timeGained)
+			Expect(routerPickUpJobs[workspaceID2]).To(Equal(3))
+		})
+
+		It("Should pick up the jobs of workspaces without a latency yet at the default latency", func() {
+			tenantStats.ReportProcLoopAddStats(map[string]map[string]int{workspaceID1: {destType1: 1000}}, "router")
+			tenantStats.UpdateWorkspaceLatencyMap(destType1, workspaceID1, 1)
+			//as with the pile up loaded after a restart
+			tenantStats.AddToInMemoryCount(workspaceID3, destType1, 100, "router")
+
+			routerPickUpJobs, usedLatencies := tenantStats.GetRouterPickupJobs(destType1, noOfWorkers, routerTimeOut, 2000, timeGained)
+			Expect(routerPickUpJobs[workspaceID3]).To(Equal(100))
+			Expect(usedLatencies[workspaceID3]).To(Equal(defaultLatency))
+			Expect(tenantStats.routerTenantLatencyStat[destType1]).NotTo(HaveKey(workspaceID3))
+		})
+
+		It("Should pick fewer jobs when sizing against the p95 of skewed latencies", func() {
+			initialUseP95Latency := useP95Latency
+			defer func() { useP95Latency = initialUseP95Latency }()
+			useP95Latency = true
+
+			input := map[string]map[string]int{workspaceID1: {destType1: 100000}}
+			tenantStats.ReportProcLoopAddStats(input, "router")
+			for i := 0; i < 100; i++ {
+				latency := 0.1
+				if i%10 == 0 {
+					latency = 10
+				}
+				tenantStats.UpdateWorkspaceLatencyMap(destType1, workspaceID1, latency)
+			}
+
+			useP95Latency = false
+			meanPickUpJobs, meanLatencies := tenantStats.GetRouterPickupJobs(destType1, noOfWorkers, routerTimeOut, 100000, timeGained)
+			useP95Latency = true
+			p95PickUpJobs, p95Latencies := tenantStats.GetRouterPickupJobs(destType1, noOfWorkers, routerTimeOut, 100000, timeGained)
+
+			Expect(p95Latencies[workspaceID1]).To(BeNumerically(">", meanLatencies[workspaceID1]))
+			Expect(p95PickUpJobs[workspaceID1]).To(BeNumerically(">", 0))
+			Expect(p95PickUpJobs[workspaceID1]).To(BeNumerically("<", meanPickUpJobs[workspaceID1]))
+		})
+
+		It("Should not track the p95 latencies unless they are used", func() {
+			initialUseP95Latency := useP95Latency
+			defer func() { useP95Latency = initialUseP95Latency }()
+			useP95Latency = false
+
+			tenantStats.UpdateWorkspaceLatencyMap(destType1, workspaceID1, 1)
+			Expect(tenantStats.routerTenantLatencyStat[destType1]).To(HaveKey(workspaceID1))
+			Expect(tenantStats.routerTenantLatencyP95).To(BeEmpty())
+		})
+
+		It("Should restore the counts and moving averages of a snapshot after a restart", func() {
+			initialUseP95Latency := useP95Latency
+			defer func() { useP95Latency = initialUseP95Latency }()
+			useP95Latency = true
+
+			for i := 0; i < int(misc.AVG_METRIC_AGE); i++ {
+				tenantStats.CalculateSuccessFailureCounts(workspaceID1, destType1, i%3 != 0, false)
+				tenantStats.CalculateSuccessFailureCounts(workspaceID2, destType1, false, i%2 == 0)
+				tenantStats.UpdateWorkspaceLatencyMap(destType1, workspaceID1, float64(i%7))
+				tenantStats.ReportProcLoopAddStats(map[string]map[string]int{workspaceID1: {destType1: i}}, "router")
+			}
+			tenantStats.AddToInMemoryCount(workspaceID2, destType1, 5, "batch_router")
+			snapshot, err := tenantStats.SnapshotStats()
+			Expect(err).To(BeNil())
+
+			mockRouterJobsDB.EXPECT().GetPileUpCounts(gomock.Any()).Times(1)
+			restoredStats := NewStats(mockRouterJobsDB)
+			Expect(restoredStats.RestoreStats(snapshot)).To(Succeed())
+
+			Expect(restoredStats.routerNonTerminalCounts).To(Equal(tenantStats.routerNonTerminalCounts))
+			Expect(restoredStats.routerInputRateSamples).To(Equal(tenantStats.routerInputRateSamples))
+			Expect(restoredStats.routerInputRates).To(Equal(tenantStats.routerInputRates))
+			Expect(restoredStats.failureRate).To(Equal(tenantStats.failureRate))
+			Expect(restoredStats.drainedRate).To(Equal(tenantStats.drainedRate))
+			Expect(restoredStats.routerTenantLatencyStat).To(Equal(tenantStats.routerTenantLatencyStat))
+			Expect(restoredStats.routerTenantLatencyP95).To(Equal(tenantStats.routerTenantLatencyP95))
+			Expect(restoredStats.getLastDrainedTimestamp(workspaceID2, destType1)).To(BeTemporally("==", tenantStats.getLastDrainedTimestamp(workspaceID2, destType1)))
+
+			//the moving averages carry on from their internal state, rather than from their value alone
+			tenantStats.UpdateWorkspaceLatencyMap(destType1, workspaceID1, 100)
+			restoredStats.UpdateWorkspaceLatencyMap(destType1, workspaceID1, 100)
+			Expect(restoredStats.routerTenantLatencyP95[destType1][workspaceID1].Value()).To(Equal(tenantStats.routerTenantLatencyP95[destType1][workspaceID1].Value()))
+			Expect(restoredStats.routerTenantLatencyStat[destType1][workspaceID1].Value()).To(Equal(tenantStats.routerTenantLatencyStat[destType1][workspaceID1].Value()))
+			restoredStats.AddToInMemoryCount(workspaceID3, destType1, 1, "router")
+			Expect(restoredStats.GetInMemoryJobCount("router", workspaceID3, destType1)).To(Equal(1))
+		})
+
+		It("Should keep the stats if the snapshot is invalid", func() {
+			tenantStats.AddToInMemoryCount(workspaceID1, destType1, 5, "router")
+			Expect(tenantStats.RestoreStats([]byte(`{"failureRate": {"w": {"d": "x"}}}`))).NotTo(Succeed())
+			Expect(tenantStats.GetInMemoryJobCount("router", workspaceID1, destType1)).To(Equal(5))
+		})
+
+		Context("IsCustomerHealthy", func() {
+			var initialThreshold float64
+			BeforeEach(func() {
+				initialThreshold = unhealthyThreshold
+				unhealthyThreshold = 0.5
+			})
+			AfterEach(func() {
+				unhealthyThreshold = initialThreshold
+			})
+
+			setFailureRate := func(failureRate float64) {
+				tenantStats.CalculateSuccessFailureCounts(workspaceID1, destType1, true, false)
+				tenantStats.failureRate[workspaceID1][destType1].Set(failureRate)
+			}
+
+			It("Should treat workspaces without any results as healthy", func() {
+				healthy, successRate := tenantStats.IsCustomerHealthy(workspaceID1, destType1)
+				Expect(healthy).To(BeTrue())
+				Expect(successRate).To(Equal(1.0))
+			})
+
+			It("Should treat workspaces whose rates haven't warmed up as healthy", func() {
+				tenantStats.CalculateSuccessFailureCounts(workspaceID1, destType1, false, false)
+				healthy, successRate := tenantStats.IsCustomerHealthy(workspaceID1, destType1)
+				Expect(healthy).To(BeTrue())
+				Expect(successRate).To(Equal(1.0))
+			})
+
+			It("Should be healthy above the threshold", func() {
+				setFailureRate(0.25)
+				healthy, successRate := tenantStats.IsCustomerHealthy(workspaceID1, destType1)
+				Expect(healthy).To(BeTrue())
+				Expect(successRate).To(Equal(0.75))
+			})
+
+			It("Should be healthy at the threshold", func() {
+				setFailureRate(0.5)
+				healthy, successRate := tenantStats.IsCustomerHealthy(workspaceID1, destType1)
+				Expect(healthy).To(BeTrue())
+				Expect(successRate).To(Equal(0.5))
+			})
+
+			It("Should be unhealthy below the threshold", func() {
+				setFailureRate(0.75)
+				healthy, successRate := tenantStats.IsCustomerHealthy(workspaceID1, destType1)
+				Expect(healthy).To(BeFalse())
+				Expect(successRate).To(Equal(0.25))
+			})
+
+			It("Should be unhealthy if every job fails, without resetting the rate", func() {
+				for i := 0; i < int(misc.AVG_METRIC_AGE); i++ {
+					tenantStats.CalculateSuccessFailureCounts(workspaceID1, destType1, false, false)
+				}
+				healthy, successRate := tenantStats.IsCustomerHealthy(workspaceID1, destType1)
+				Expect(healthy).To(BeFalse())
+				Expect(successRate).To(Equal(0.0))
+				Expect(tenantStats.getFailureRate(workspaceID1, destType1)).To(Equal(1.0))
+			})
+
+			It("Should not count drained jobs as failures", func() {
+				for i := 0; i < int(misc.AVG_METRIC_AGE); i++ {
+					tenantStats.CalculateSuccessFailureCounts(workspaceID1, destType1, false, true)
+				}
+				healthy, _ := tenantStats.IsCustomerHealthy(workspaceID1, destType1)
+				Expect(healthy).To(BeTrue())
+			})
+		})
 	})
 })
 
@@ -241,3 +801,36 @@ func Benchmark_Counts_Atomic(b *testing.B) {
 
 	require.Equal(b, int64(b.N), atomic.LoadInt64(a.(*int64)))
 }
+
+//gaugeRecordingStats records the last value of the gauges created through it, keyed by name and sorted tags
+type gaugeRecordingStats struct {
+	stats.Stats
+	gauges map[string]interface{}
+}
+
+func (s *gaugeRecordingStats) NewTaggedStat(name, statType string, tags stats.Tags) stats.RudderStats {
+	if s.gauges == nil {
+		s.gauges = map[string]interface{}{}
+	}
+	key := name
+	tagNames := make([]string, 0, len(tags))
+	for tagName := range tags {
+		tagNames = append(tagNames, tagName)
+	}
+	sort.Strings(tagNames)
+	for _, tagName := range tagNames {
+		key += "," + tagName + "=" + tags[tagName]
+	}
+	return &gaugeRecordingStat{RudderStats: s.Stats.NewTaggedStat(name, statType, tags), gauges: s.gauges, key: key}
+}
+
+type gaugeRecordingStat struct {
+	stats.RudderStats
+	gauges map[string]interface{}
+	key    string
+}
+
+func (s *gaugeRecordingStat) Gauge(value interface{}) {
+	s.gauges[s.key] = value
+	s.RudderStats.Gauge(value)
+}
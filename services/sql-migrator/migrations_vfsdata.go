@@ -25,7 +25,7 @@ var MigrationAssets = func() http.FileSystem {
 		},
 		"/jobsdb": &vfsgen۰DirInfo{
 			name:    "jobsdb",
-			modTime: time.Date(2022, 1, 21, 8, 44, 37, 650807635, time.UTC),
+			modTime: time.Date(2022, 3, 2, 11, 12, 40, 118202937, time.UTC),
 		},
 		"/jobsdb/000001_create_tables.down.tmpl": &vfsgen۰CompressedFileInfo{
 			name:             "000001_create_tables.down.tmpl",
@@ -104,6 +104,30 @@ var MigrationAssets = func() http.FileSystem {
 
 			compressedContent: []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x02\xff\x34\x8e\xc1\x0a\x82\x40\x18\x84\xef\x3e\xc5\x20\x1d\x0a\xc2\x17\xe8\x14\xb9\x81\x17\x8d\xf4\xe0\x6d\xd9\xf4\x37\x2c\xd3\xda\xdd\x2c\xf8\xf9\xdf\x3d\x4a\x9b\xcb\x0c\x7c\xc3\x30\xcc\xd6\xf4\x67\x42\x14\x1b\x6f\x1c\x79\x27\x12\x00\x00\x33\xda\x06\xf4\xc0\x22\x3a\x58\x6a\xda\x37\x42\xeb\x43\x88\xe0\x87\xbf\xda\x1d\xd5\xb6\x50\x48\xd2\x58\x95\x48\xf6\x48\xb3\x02\xaa\x4c\xf2\x22\x47\xf5\x74\x7e\xb8\x8d\xa6\xd3\x53\x22\xab\x99\x23\x11\x64\x29\x98\xff\x93\x22\xfa\x32\x9c\xdc\x8c\x96\x53\x55\x8f\xa6\x5b\xbf\x06\x7b\x75\x77\x53\x51\x5b\xaf\x36\xf3\x1f\xea\x6b\x91\x60\xf6\x4f\x00\x00\x00\xff\xff\x25\xf9\xe2\x9b\xb7\x00\x00\x00"),
 		},
+		"/jobsdb/000008_alter_status_table.down.tmpl": &vfsgen۰FileInfo{
+			name:    "000008_alter_status_table.down.tmpl",
+			modTime: time.Date(2022, 3, 2, 11, 12, 40, 118202937, time.UTC),
+			content: []byte("\x7b\x7b\x72\x61\x6e\x67\x65\x20\x2e\x44\x61\x74\x61\x73\x65\x74\x73\x7d\x7d\x0a\x20\x20\x20\x20\x41\x4c\x54\x45\x52\x20\x54\x41\x42\x4c\x45\x20\x7b\x7b\x24\x2e\x50\x72\x65\x66\x69\x78\x7d\x7d\x5f\x6a\x6f\x62\x5f\x73\x74\x61\x74\x75\x73\x5f\x7b\x7b\x2e\x7d\x7d\x20\x44\x52\x4f\x50\x20\x43\x4f\x4c\x55\x4d\x4e\x20\x49\x46\x20\x45\x58\x49\x53\x54\x53\x20\x61\x62\x6f\x72\x74\x5f\x72\x65\x61\x73\x6f\x6e\x3b\x0a\x7b\x7b\x65\x6e\x64\x7d\x7d\x0a"),
+		},
+		"/jobsdb/000008_alter_status_table.up.tmpl": &vfsgen۰CompressedFileInfo{
+			name:             "000008_alter_status_table.up.tmpl",
+			modTime:          time.Date(2022, 3, 2, 11, 12, 31, 904177120, time.UTC),
+			uncompressedSize: 209,
+
+			compressedContent: []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x02\xff\x55\x8e\xc1\x0a\x83\x30\x00\x43\xef\xfb\x8a\x1c\x06\x5e\xa6\x3f\xb0\x53\x37\x2b\x08\x9d\x8e\x59\xc1\x9b\xd4\x59\xc7\x86\x6b\xa5\xad\x30\x28\xfd\xf7\x95\x79\x5a\x4e\x81\xf0\x92\xa4\x29\xc8\x38\x42\x0c\xda\xb8\xde\x48\x61\xb5\xc2\x5d\xcf\xeb\x5b\xc1\x69\x58\x27\xdc\x6a\xe1\xc4\x30\xcb\x03\x16\xbd\xac\xb3\x70\x72\xc4\xa4\xcd\x86\x44\xff\xd2\x83\xdd\x79\x6f\x84\x7a\x48\x64\xb9\x70\xc2\x4a\x67\x43\xd8\x21\x8a\x30\x4e\x6f\xe0\xe4\xc4\x28\xbc\xdf\x67\x57\x23\xa7\xe7\x27\x84\x3e\x52\xfd\xd6\xde\x7b\x9f\x85\x00\x92\xe7\x38\xd7\xac\xbd\x54\x28\x0b\x54\x35\x07\xed\xca\x86\x37\xff\xd7\x38\xed\xf8\x2f\xac\x5a\xc6\x90\xd3\x82\xb4\x8c\x23\x49\x8e\xf1\x81\x54\x63\x5c\xfd\x02\xac\x9a\xf8\xcd\xd1\x00\x00\x00"),
+		},
+		"/jobsdb/000009_create_latest_status_table.down.tmpl": &vfsgen۰FileInfo{
+			name:    "000009_create_latest_status_table.down.tmpl",
+			modTime: time.Date(2026, 10, 16, 8, 41, 1, 809906000, time.UTC),
+			content: []byte("\x2d\x2d\x20\x44\x72\x6f\x70\x20\x6c\x61\x74\x65\x73\x74\x20\x73\x74\x61\x74\x75\x73\x20\x74\x61\x62\x6c\x65\x0a\x44\x52\x4f\x50\x20\x54\x41\x42\x4c\x45\x20\x49\x46\x20\x45\x58\x49\x53\x54\x53\x20\x7b\x7b\x2e\x50\x72\x65\x66\x69\x78\x7d\x7d\x5f\x6a\x6f\x62\x5f\x6c\x61\x74\x65\x73\x74\x5f\x73\x74\x61\x74\x75\x73\x3b\x0a"),
+		},
+		"/jobsdb/000009_create_latest_status_table.up.tmpl": &vfsgen۰CompressedFileInfo{
+			name:             "000009_create_latest_status_table.up.tmpl",
+			modTime:          time.Date(2026, 10, 16, 8, 41, 1, 810867000, time.UTC),
+			uncompressedSize: 508,
+
+			compressedContent: []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x02\xff\x85\x90\x41\x4f\x02\x31\x10\x85\xef\xfc\x8a\xb9\xc1\x26\xe0\x41\x8d\x07\x3c\x15\x2c\xba\xd8\x5d\xc8\x6e\x31\x70\xda\x74\xd9\x41\x57\x60\xdb\xb4\x43\x84\x10\xfe\xbb\x2c\x25\xa2\x89\xc6\xb9\x75\xbe\xf7\x66\xde\xb4\xd3\x01\xa1\x08\x1d\x81\x23\x45\x1b\x07\xa4\xf2\x15\xb6\x61\x89\x3b\x2c\x20\xdf\xc1\xbb\xce\xb3\xb2\x68\xc3\xc6\xf9\xf7\xc4\x38\xb4\x34\xd4\x79\xea\xf5\x65\xe5\x08\x55\x01\x7a\x01\xca\x18\xac\x8a\xb2\x7a\x05\xd2\x40\x6f\x08\x85\x22\xe5\xf0\xe7\x68\xd7\xe8\x27\x9c\x49\x0e\x92\xf5\x04\x87\x70\x00\xf1\x48\x02\x9f\x86\xa9\x4c\x61\xbf\xbf\x1a\x5b\x5c\x94\xdb\xc3\x21\xab\x17\xaf\x4e\xd1\xb2\xb3\xbf\xd5\x80\x63\xf9\x40\xd0\x0b\x1f\xc3\x58\xc2\x38\x09\x23\x96\xcc\xe0\x99\xcf\xda\x5f\xb8\xd6\x23\xbc\xb0\xa4\xff\xc4\x92\xd6\xdd\x6d\xe0\x91\x22\xc2\xb5\x21\x48\x23\x26\xc4\xd1\xec\xbb\xb8\xc5\x79\x46\xe5\x1a\x41\x86\x11\x4f\x25\x8b\xc6\x1e\x58\x24\xbb\xfb\x95\xa0\xb5\xda\x66\x73\x5d\x5c\x96\xdc\x5c\x07\xdf\x99\x45\x67\x74\xe5\x10\x86\xe9\x28\xee\xc1\x03\x1f\xb0\x89\x90\xd0\xdc\x1f\x9a\xdd\xee\xa9\xe7\xd5\x46\x59\xb5\x46\x42\xeb\xfe\x53\x7e\x68\xbb\x74\x46\xcd\xb1\x3e\x5e\xf2\xa9\x3c\xfd\x5b\x3c\x11\xe2\xe2\x69\x9e\xef\xcc\xb5\xa5\x63\x04\xe5\x74\xf5\xa7\x34\xb8\x6f\x7c\x02\xe8\x01\x1c\xaf\xfc\x01\x00\x00"),
+		},
 		"/node": &vfsgen۰DirInfo{
 			name:    "node",
 			modTime: time.Date(2021, 10, 29, 10, 50, 52, 396404742, time.UTC),
@@ -183,6 +207,20 @@ var MigrationAssets = func() http.FileSystem {
 
 			compressedContent: []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x02\xff\x84\xcd\x4d\xaa\xc2\x30\x14\x47\xf1\x79\x57\xf1\xdf\xc0\x5d\xc1\x1b\xf5\x69\x85\x42\xb4\x92\x46\x70\xd6\xc6\xe4\x6a\x03\xf9\x80\xa4\x66\xfd\xe2\xc0\x41\x9d\xb8\x80\xdf\x39\x44\xd4\x10\x11\x24\x87\x54\x5d\x7c\xc0\x24\xff\x0c\x98\x75\x36\x8b\xab\x6c\x67\xdc\x73\x0a\xe0\xca\x71\x9d\x42\xb2\xec\x0b\x74\xb4\x28\x66\xe1\xa0\xa7\xca\xb9\xb8\x14\x0b\x56\x7d\xf3\x5c\xde\xa9\xa6\x69\x85\xea\x24\x54\xfb\x2f\xba\x2d\xdc\xcb\xe1\x8c\xdd\x20\x2e\xc7\x13\xfa\x03\xba\x6b\x3f\xaa\x11\x9f\xd5\xdf\x06\x7e\x0f\x7e\xd9\x57\x00\x00\x00\xff\xff\x94\xfc\xf5\xe6\xc9\x00\x00\x00"),
 		},
+		"/node/000007_add_unique_uuid_to_event_models.up.sql": &vfsgen۰CompressedFileInfo{
+			name:             "000007_add_unique_uuid_to_event_models.up.sql",
+			modTime:          time.Date(2026, 10, 16, 9, 47, 36, 391291000, time.UTC),
+			uncompressedSize: 304,
+
+			compressedContent: []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x02\xff\x55\x8f\xcb\x6e\xc2\x30\x10\x45\xf7\xfe\x8a\xbb\x04\xa9\xe6\x07\x28\x0b\x94\x4c\x5a\x4b\xe0\xa8\x79\x08\x76\x51\xa8\xa7\xc2\x2a\xd8\x3c\x9c\xd2\xcf\x6f\x52\x67\x41\x96\x57\x67\x74\xcf\x1d\x29\xa5\x90\x52\x62\xdb\x7e\x33\xc2\x91\xd1\x75\xd6\xc0\x7f\x81\x7f\xd8\x85\xe6\xec\x0d\x9f\xee\xe8\x9c\xbd\x76\xfc\x82\xbb\xef\x6f\xda\x10\x21\x46\xf8\xd9\x3a\x1c\x18\xf6\x7c\xf1\xb7\xc0\x06\x0f\x1b\x8e\xc8\x35\x92\x5c\x67\x1b\x95\x54\x98\x0d\x9d\xf3\x41\x23\x44\x4a\x1b\xaa\x08\x59\x91\x6f\xa7\x0a\x7f\x32\x7c\x43\x5d\x2a\xfd\x36\x05\x8e\x1f\x3d\xd8\xbd\x53\x41\xf1\x68\xf1\x3f\x71\x15\x41\x0c\x6b\x9d\x8e\xac\x0f\xaf\x23\xb1\x66\x29\x92\x82\xd6\xbd\xae\xd6\xea\xa3\x26\x28\x9d\xd2\x1e\x2a\x83\xce\x2b\xd0\x5e\x95\x55\xf9\xec\x6a\x86\xae\xc6\x3a\xc3\xbf\xc3\xfe\xc9\x8a\xf8\xc3\x52\xfc\x01\xb5\xcb\xcd\x3f\x30\x01\x00\x00"),
+		},
+		"/node/000007_remove_unique_uuid_from_event_models.down.sql": &vfsgen۰CompressedFileInfo{
+			name:             "000007_remove_unique_uuid_from_event_models.down.sql",
+			modTime:          time.Date(2026, 10, 16, 9, 47, 36, 392187000, time.UTC),
+			uncompressedSize: 112,
+
+			compressedContent: []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x02\xff\xd3\xd5\xd5\xe5\xd2\xd5\xd5\x55\x08\x4a\xcd\xcd\x2f\xcb\xcc\x4b\x57\x28\xc9\x48\x55\x28\xcd\xcb\x2c\x2c\x4d\x55\xc8\xcc\x4b\x49\xad\x50\xc8\xcf\x83\x88\x95\x66\xa6\x28\xe4\xa7\x29\xa4\x96\xa5\xe6\x95\xc4\xe7\xe6\xa7\xa4\xe6\x14\x83\x74\x72\x71\xb9\x04\xf9\x07\x28\x78\xfa\xb9\xb8\x46\x28\x78\xba\x29\xb8\x46\x78\x06\x87\x04\x23\x2b\x8b\x07\x69\x8d\x07\x1b\x66\xcd\x05\x00\x18\x5d\xce\xb4\x70\x00\x00\x00"),
+		},
 		"/pg_notifier_queue": &vfsgen۰DirInfo{
 			name:    "pg_notifier_queue",
 			modTime: time.Date(2022, 1, 21, 7, 5, 2, 42177779, time.UTC),
@@ -359,6 +397,10 @@ var MigrationAssets = func() http.FileSystem {
 		fs["/jobsdb/000006_alter_dataset_table.down.tmpl"].(os.FileInfo),
 		fs["/jobsdb/000006_alter_dataset_table.up.tmpl"].(os.FileInfo),
 		fs["/jobsdb/000007_add_index_rt_table.up.tmpl"].(os.FileInfo),
+		fs["/jobsdb/000008_alter_status_table.down.tmpl"].(os.FileInfo),
+		fs["/jobsdb/000008_alter_status_table.up.tmpl"].(os.FileInfo),
+		fs["/jobsdb/000009_create_latest_status_table.down.tmpl"].(os.FileInfo),
+		fs["/jobsdb/000009_create_latest_status_table.up.tmpl"].(os.FileInfo),
 	}
 	fs["/node"].(*vfsgen۰DirInfo).entries = []os.FileInfo{
 		fs["/node/000001_create_event_schema.down.sql"].(os.FileInfo),
@@ -372,6 +414,8 @@ var MigrationAssets = func() http.FileSystem {
 		fs["/node/000005_alter_event_schemas_autovacuum.up.sql"].(os.FileInfo),
 		fs["/node/000006_add_archived_to_event_schemas_tables.up.sql"].(os.FileInfo),
 		fs["/node/000006_remove_archived_from_event_schemas_tables.down.sql"].(os.FileInfo),
+		fs["/node/000007_add_unique_uuid_to_event_models.up.sql"].(os.FileInfo),
+		fs["/node/000007_remove_unique_uuid_from_event_models.down.sql"].(os.FileInfo),
 	}
 	fs["/pg_notifier_queue"].(*vfsgen۰DirInfo).entries = []os.FileInfo{
 		fs["/pg_notifier_queue/0000001_pg_notifier_queue_init.down.sql"].(os.FileInfo),
@@ -0,0 +1,6 @@
+---
+--- Make the uuid of event_models unique, so that event models can be imported with ON CONFLICT (uuid)
+---
+
+DELETE FROM event_models older USING event_models newer WHERE older.uuid = newer.uuid AND older.id < newer.id;
+CREATE UNIQUE INDEX IF NOT EXISTS event_model_uuid_index ON event_models (uuid);
@@ -0,0 +1,5 @@
+---
+--- Removing the unique index on the uuid of event_models
+---
+
+DROP INDEX IF EXISTS event_model_uuid_index;
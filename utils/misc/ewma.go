@@ -1,6 +1,9 @@
 package misc
 
-import "sync"
+import (
+	"encoding/json"
+	"sync"
+)
 
 const (
 	// By default, we average over a one-minute period, which means the average
@@ -87,6 +90,30 @@ func (e *SimpleEWMA) Set(value float64) {
 	e.value = value
 }
 
+// simpleEWMAState is the JSON representation of a SimpleEWMA.
+type simpleEWMAState struct {
+	Value float64 `json:"value"`
+}
+
+// MarshalJSON serializes the state of the average, so that it survives a restart.
+func (e *SimpleEWMA) MarshalJSON() ([]byte, error) {
+	threadSafeMutex.RLock()
+	defer threadSafeMutex.RUnlock()
+	return json.Marshal(simpleEWMAState{Value: e.value})
+}
+
+// UnmarshalJSON restores the state of the average serialized with MarshalJSON.
+func (e *SimpleEWMA) UnmarshalJSON(data []byte) error {
+	var state simpleEWMAState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return err
+	}
+	threadSafeMutex.Lock()
+	defer threadSafeMutex.Unlock()
+	e.value = state.Value
+	return nil
+}
+
 // VariableEWMA represents the exponentially weighted moving average of a series of
 // numbers. Unlike SimpleEWMA, it supports a custom age, and thus uses more memory.
 type VariableEWMA struct {
@@ -136,3 +163,30 @@ func (e *VariableEWMA) Set(value float64) {
 		e.count = WARMUP_SAMPLES + 1
 	}
 }
+
+// variableEWMAState is the JSON representation of a VariableEWMA, including
+// its warm-up progress.
+type variableEWMAState struct {
+	Decay float64 `json:"decay"`
+	Value float64 `json:"value"`
+	Count uint8   `json:"count"`
+}
+
+// MarshalJSON serializes the state of the average, so that it survives a restart.
+func (e *VariableEWMA) MarshalJSON() ([]byte, error) {
+	threadSafeMutex.RLock()
+	defer threadSafeMutex.RUnlock()
+	return json.Marshal(variableEWMAState{Decay: e.decay, Value: e.value, Count: e.count})
+}
+
+// UnmarshalJSON restores the state of the average serialized with MarshalJSON.
+func (e *VariableEWMA) UnmarshalJSON(data []byte) error {
+	var state variableEWMAState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return err
+	}
+	threadSafeMutex.Lock()
+	defer threadSafeMutex.Unlock()
+	e.decay, e.value, e.count = state.Decay, state.Value, state.Count
+	return nil
+}
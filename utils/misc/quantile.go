@@ -0,0 +1,201 @@
+package misc
+
+import (
+	"encoding/json"
+	"sort"
+)
+
+// pSquareMarkers is the number of markers the P-square algorithm keeps track of.
+const pSquareMarkers = 5
+
+// PSquareQuantile estimates a quantile of a time-series stream of numbers
+// using the P-square algorithm by Jain and Chlamtac, "The P² algorithm for
+// dynamic calculation of quantiles and histograms without storing
+// observations". Unlike sorting a window of samples, it uses constant memory:
+// five markers with their heights, actual and desired positions, i.e. about
+// 180 bytes per estimator, regardless of the number of samples. The estimate
+// covers all the samples added since the estimator was created or last Set,
+// unless it is windowed, see NewPSquareQuantile.
+//
+// It implements MovingAverage, so that it can be used in place of an average
+// where the tail of the series matters more than its mean.
+type PSquareQuantile struct {
+	// The quantile to estimate, in (0, 1).
+	p float64
+	// The number of samples added to this instance.
+	count int
+	// The heights of the markers, i.e. the estimates of the min, p/2, p, (1+p)/2 quantiles and the max.
+	heights [pSquareMarkers]float64
+	// The actual positions of the markers.
+	positions [pSquareMarkers]float64
+	// The desired positions of the markers and their increments per sample.
+	desired    [pSquareMarkers]float64
+	increments [pSquareMarkers]float64
+	// The number of samples after which the estimator restarts from its markers, if positive.
+	window int
+}
+
+// NewPSquareQuantile constructs a PSquareQuantile estimating the p quantile, e.g. 0.95 for the p95.
+// If a window is given, the estimator restarts from its markers every window samples, as if they
+// were its first five samples, so that the estimate follows the recent samples rather than all of them.
+func NewPSquareQuantile(p float64, window ...int) *PSquareQuantile {
+	e := &PSquareQuantile{p: p}
+	if len(window) > 0 {
+		e.window = window[0]
+	}
+	e.restart()
+	return e
+}
+
+// restart resets the positions of the markers as if their heights were the first five samples
+func (e *PSquareQuantile) restart() {
+	p := e.p
+	e.desired = [pSquareMarkers]float64{0, 2 * p, 4 * p, 2 + 2*p, 4}
+	e.increments = [pSquareMarkers]float64{0, p / 2, p, (1 + p) / 2, 1}
+	for i := range e.positions {
+		e.positions[i] = float64(i)
+	}
+}
+
+// Add adds a value to the series and updates the quantile estimate.
+func (e *PSquareQuantile) Add(value float64) {
+	threadSafeMutex.Lock()
+	defer threadSafeMutex.Unlock()
+	if e.count < pSquareMarkers {
+		e.heights[e.count] = value
+		e.count++
+		if e.count == pSquareMarkers {
+			sort.Float64s(e.heights[:])
+			for i := range e.positions {
+				e.positions[i] = float64(i)
+			}
+		}
+		return
+	}
+	if e.window > 0 && e.count >= e.window {
+		e.restart()
+		e.count = pSquareMarkers
+	}
+	e.count++
+
+	// find the cell the value falls in, extending the min and max if needed
+	var k int
+	switch {
+	case value < e.heights[0]:
+		e.heights[0] = value
+		k = 0
+	case value >= e.heights[pSquareMarkers-1]:
+		e.heights[pSquareMarkers-1] = value
+		k = pSquareMarkers - 2
+	default:
+		for k = 0; k < pSquareMarkers-2; k++ {
+			if value < e.heights[k+1] {
+				break
+			}
+		}
+	}
+	for i := k + 1; i < pSquareMarkers; i++ {
+		e.positions[i]++
+	}
+	for i := range e.desired {
+		e.desired[i] += e.increments[i]
+	}
+
+	// move the middle markers towards their desired positions
+	for i := 1; i < pSquareMarkers-1; i++ {
+		d := e.desired[i] - e.positions[i]
+		if (d >= 1 && e.positions[i+1]-e.positions[i] > 1) || (d <= -1 && e.positions[i-1]-e.positions[i] < -1) {
+			sign := 1.0
+			if d < 0 {
+				sign = -1.0
+			}
+			height := e.parabolic(i, sign)
+			if e.heights[i-1] < height && height < e.heights[i+1] {
+				e.heights[i] = height
+			} else {
+				e.heights[i] = e.linear(i, sign)
+			}
+			e.positions[i] += sign
+		}
+	}
+}
+
+// parabolic returns the piecewise-parabolic prediction of the height of marker i moved by sign
+func (e *PSquareQuantile) parabolic(i int, sign float64) float64 {
+	n, q := e.positions, e.heights
+	return q[i] + sign/(n[i+1]-n[i-1])*
+		((n[i]-n[i-1]+sign)*(q[i+1]-q[i])/(n[i+1]-n[i])+
+			(n[i+1]-n[i]-sign)*(q[i]-q[i-1])/(n[i]-n[i-1]))
+}
+
+// linear returns the linear prediction of the height of marker i moved by sign
+func (e *PSquareQuantile) linear(i int, sign float64) float64 {
+	j := i + int(sign)
+	return e.heights[i] + sign*(e.heights[j]-e.heights[i])/(e.positions[j]-e.positions[i])
+}
+
+// Value returns the current quantile estimate, or 0.0 if no samples have been added.
+// Until five samples have been added, it is the nearest rank quantile of the samples.
+func (e *PSquareQuantile) Value() float64 {
+	threadSafeMutex.RLock()
+	defer threadSafeMutex.RUnlock()
+	if e.count == 0 {
+		return 0.0
+	}
+	if e.count < pSquareMarkers {
+		samples := make([]float64, e.count)
+		copy(samples, e.heights[:e.count])
+		sort.Float64s(samples)
+		return samples[int(e.p*float64(e.count-1)+0.5)]
+	}
+	return e.heights[pSquareMarkers/2]
+}
+
+// Set resets the estimate to the given value, as if five samples of it had been added.
+func (e *PSquareQuantile) Set(value float64) {
+	threadSafeMutex.Lock()
+	defer threadSafeMutex.Unlock()
+	for i := range e.heights {
+		e.heights[i] = value
+	}
+	e.restart()
+	e.count = pSquareMarkers
+}
+
+// pSquareQuantileState is the JSON representation of a PSquareQuantile.
+type pSquareQuantileState struct {
+	P          float64                 `json:"p"`
+	Count      int                     `json:"count"`
+	Heights    [pSquareMarkers]float64 `json:"heights"`
+	Positions  [pSquareMarkers]float64 `json:"positions"`
+	Desired    [pSquareMarkers]float64 `json:"desired"`
+	Increments [pSquareMarkers]float64 `json:"increments"`
+}
+
+// MarshalJSON serializes the markers of the estimator, so that it survives a restart.
+// The window isn't, it is kept from the constructor of the estimator being unmarshalled into.
+func (e *PSquareQuantile) MarshalJSON() ([]byte, error) {
+	threadSafeMutex.RLock()
+	defer threadSafeMutex.RUnlock()
+	return json.Marshal(pSquareQuantileState{
+		P:          e.p,
+		Count:      e.count,
+		Heights:    e.heights,
+		Positions:  e.positions,
+		Desired:    e.desired,
+		Increments: e.increments,
+	})
+}
+
+// UnmarshalJSON restores the markers of the estimator serialized with MarshalJSON.
+func (e *PSquareQuantile) UnmarshalJSON(data []byte) error {
+	var state pSquareQuantileState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return err
+	}
+	threadSafeMutex.Lock()
+	defer threadSafeMutex.Unlock()
+	e.p, e.count = state.P, state.Count
+	e.heights, e.positions, e.desired, e.increments = state.Heights, state.Positions, state.Desired, state.Increments
+	return nil
+}
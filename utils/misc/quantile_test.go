@@ -0,0 +1,80 @@
+package misc
+
+import (
+	"encoding/json"
+	"math"
+	"math/rand"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("PSquareQuantile", func() {
+	It("returns 0 without samples", func() {
+		Expect(NewPSquareQuantile(0.95).Value()).To(Equal(0.0))
+	})
+
+	It("returns the nearest rank quantile of the first samples", func() {
+		e := NewPSquareQuantile(0.5)
+		for _, v := range []float64{3, 1, 2} {
+			e.Add(v)
+		}
+		Expect(e.Value()).To(Equal(2.0))
+	})
+
+	It("estimates the quantile of a skewed distribution", func() {
+		e := NewPSquareQuantile(0.95)
+		r := rand.New(rand.NewSource(1))
+		for i := 0; i < 100000; i++ {
+			e.Add(r.ExpFloat64())
+		}
+		//the p95 of an exponential distribution with mean 1 is ln(20)
+		Expect(e.Value()).To(BeNumerically("~", math.Log(20), 0.1))
+	})
+
+	It("follows the recent samples if windowed", func() {
+		windowed, lifetime := NewPSquareQuantile(0.95, 1000), NewPSquareQuantile(0.95)
+		r := rand.New(rand.NewSource(1))
+		for i := 0; i < 100000; i++ {
+			v := r.ExpFloat64() * 10
+			windowed.Add(v)
+			lifetime.Add(v)
+		}
+		//the latencies drop tenfold
+		for i := 0; i < 5000; i++ {
+			v := r.ExpFloat64()
+			windowed.Add(v)
+			lifetime.Add(v)
+		}
+		Expect(windowed.Value()).To(BeNumerically("~", math.Log(20), 0.5))
+		Expect(lifetime.Value()).To(BeNumerically(">", 10*math.Log(20)*0.8))
+	})
+
+	It("resets the estimate to the set value", func() {
+		e := NewPSquareQuantile(0.95)
+		for i := 0; i < 100; i++ {
+			e.Add(float64(i))
+		}
+		e.Set(7)
+		Expect(e.Value()).To(Equal(7.0))
+		e.Add(7)
+		Expect(e.Value()).To(Equal(7.0))
+	})
+
+	It("carries on from its markers after a JSON round trip", func() {
+		e := NewPSquareQuantile(0.95)
+		r := rand.New(rand.NewSource(1))
+		for i := 0; i < 1000; i++ {
+			e.Add(r.ExpFloat64())
+		}
+		data, err := json.Marshal(e)
+		Expect(err).To(BeNil())
+		restored := &PSquareQuantile{}
+		Expect(json.Unmarshal(data, restored)).To(Succeed())
+		Expect(restored).To(Equal(e))
+
+		e.Add(10)
+		restored.Add(10)
+		Expect(restored.Value()).To(Equal(e.Value()))
+	})
+})
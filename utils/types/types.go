@@ -31,12 +31,17 @@ type SuppressUserI interface {
 type EventSchemasI interface {
 	RecordEventSchema(writeKey string, eventBatch string) bool
 	GetEventModels(w http.ResponseWriter, r *http.Request)
+	GetEventModel(w http.ResponseWriter, r *http.Request)
+	GetEventModelsByIDs(w http.ResponseWriter, r *http.Request)
 	GetEventVersions(w http.ResponseWriter, r *http.Request)
+	ExportSchemaVersions(w http.ResponseWriter, r *http.Request)
 	GetSchemaVersionMetadata(w http.ResponseWriter, r *http.Request)
+	GetSchemaVersionHash(w http.ResponseWriter, r *http.Request)
 	GetSchemaVersionMissingKeys(w http.ResponseWriter, r *http.Request)
 	GetKeyCounts(w http.ResponseWriter, r *http.Request)
 	GetEventModelMetadata(w http.ResponseWriter, r *http.Request)
 	GetJsonSchemas(w http.ResponseWriter, r *http.Request)
+	RecomputeCounts(w http.ResponseWriter, r *http.Request)
 }
 
 // ConfigEnvI is interface to inject env variables into config
@@ -6,6 +6,7 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"math"
 	"net/url"
 	"os"
 	"regexp"
@@ -524,6 +525,44 @@ ome_ ga   to ome_ga
 Cízǔ to C_z
 */
 func ToSafeNamespace(provider string, name string) string {
+	return misc.TruncateStr(toSafeIdentifier(provider, name), 127)
+}
+
+//MaxColumnNameLength is the max length of a column identifier in the warehouses,
+//the ones not listed are limited to defaultMaxColumnNameLength
+var MaxColumnNameLength = map[string]int{
+	RS:            127,
+	BQ:            300,
+	SNOWFLAKE:     255,
+	POSTGRES:      63,
+	MSSQL:         128,
+	AZURE_SYNAPSE: 128,
+}
+
+const defaultMaxColumnNameLength = 127
+
+/*
+ToWarehouseColumnName converts the raw event key to a column identifier safe to use in the DDL of the warehouse,
+the same way as ToSafeNamespace, but truncated to the max column identifier length of the warehouse instead.
+The conversion is deterministic, so the same raw key always maps to the same column.
+The column is lowercased, use ToProviderCase for the warehouses expecting another case.
+examples:
+userId     to user_id
+first name to first_name
+9lives     to _9_lives
+select     to _select
+*/
+func ToWarehouseColumnName(provider string, raw string) string {
+	maxLength, ok := MaxColumnNameLength[provider]
+	if !ok {
+		maxLength = defaultMaxColumnNameLength
+	}
+	return misc.TruncateStr(toSafeIdentifier(provider, raw), maxLength)
+}
+
+//toSafeIdentifier converts name to a snake cased identifier of letters, numbers and underscores,
+//prefixed with an underscore if it starts with a number or is a reserved keyword in the warehouse
+func toSafeIdentifier(provider string, name string) string {
 	var extractedValues []string
 	var extractedValue string
 	for _, c := range name {
@@ -541,18 +580,18 @@ func ToSafeNamespace(provider string, name string) string {
 	if extractedValue != "" {
 		extractedValues = append(extractedValues, extractedValue)
 	}
-	namespace := strings.Join(extractedValues, "_")
-	namespace = strcase.ToSnake(namespace)
-	if namespace != "" && int(namespace[0]) >= 48 && int(namespace[0]) <= 57 {
-		namespace = "_" + namespace
+	identifier := strings.Join(extractedValues, "_")
+	identifier = strcase.ToSnake(identifier)
+	if identifier != "" && int(identifier[0]) >= 48 && int(identifier[0]) <= 57 {
+		identifier = "_" + identifier
 	}
-	if namespace == "" {
-		namespace = "stringempty"
+	if identifier == "" {
+		identifier = "stringempty"
 	}
-	if _, ok := ReservedKeywords[provider][strings.ToUpper(namespace)]; ok {
-		namespace = fmt.Sprintf(`_%s`, namespace)
+	if _, ok := ReservedKeywords[provider][strings.ToUpper(identifier)]; ok {
+		identifier = fmt.Sprintf(`_%s`, identifier)
 	}
-	return misc.TruncateStr(namespace, 127)
+	return identifier
 }
 
 /*
@@ -715,6 +754,80 @@ func JoinWithFormatting(keys []string, format func(idx int, str string) string,
 	return strings.Join(output, separator)
 }
 
+// PartitionStagingRows buckets the NDJSON rows of a staging file by the value at tableKey, e.g. metadata.table.
+// tableKey is a gjson path. An error is returned for the first row which isn't valid JSON or misses the key.
+func PartitionStagingRows(rows [][]byte, tableKey string) (map[string][][]byte, error) {
+	partitions := make(map[string][][]byte)
+	for idx, row := range rows {
+		if !gjson.ValidBytes(row) {
+			return nil, fmt.Errorf("staging row %d is not valid JSON", idx)
+		}
+		tableName := gjson.GetBytes(row, tableKey).String()
+		if tableName == "" {
+			return nil, fmt.Errorf("staging row %d is missing %s", idx, tableKey)
+		}
+		partitions[tableName] = append(partitions[tableName], row)
+	}
+	return partitions, nil
+}
+
+// epochMillisThreshold is the magnitude from which a numeric timestamp is taken as epoch millis instead of seconds.
+// 1e11 seconds is in the year 5138, while 1e11 millis is in 1973.
+const epochMillisThreshold = 1e11
+
+// InvalidTimestampError is returned by NormalizeTimestamp for the values which can't be parsed as a timestamp
+type InvalidTimestampError struct {
+	Value interface{}
+}
+
+func (err *InvalidTimestampError) Error() string {
+	return fmt.Sprintf("invalid timestamp: %v (%T)", err.Value, err.Value)
+}
+
+// NormalizeTimestamp parses an RFC3339 string, a numeric epoch in seconds or millis, or a time.Time into a UTC time.
+// Numeric epochs with a magnitude of at least 1e11 are taken as millis, and the rest as seconds.
+// An *InvalidTimestampError is returned for any other value.
+func NormalizeTimestamp(value interface{}) (time.Time, error) {
+	var epoch float64
+	switch v := value.(type) {
+	case time.Time:
+		return v.UTC(), nil
+	case string:
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return time.Time{}, &InvalidTimestampError{Value: value}
+		}
+		return t.UTC(), nil
+	case json.Number:
+		f, err := v.Float64()
+		if err != nil {
+			return time.Time{}, &InvalidTimestampError{Value: value}
+		}
+		epoch = f
+	case float64:
+		epoch = v
+	case float32:
+		epoch = float64(v)
+	case int:
+		epoch = float64(v)
+	case int64:
+		epoch = float64(v)
+	case int32:
+		epoch = float64(v)
+	default:
+		return time.Time{}, &InvalidTimestampError{Value: value}
+	}
+	if math.IsNaN(epoch) || math.IsInf(epoch, 0) {
+		return time.Time{}, &InvalidTimestampError{Value: value}
+	}
+	if math.Abs(epoch) >= epochMillisThreshold {
+		millis := int64(epoch)
+		return time.Unix(millis/1000, (millis%1000)*int64(time.Millisecond)).UTC(), nil
+	}
+	sec, frac := math.Modf(epoch)
+	return time.Unix(int64(sec), int64(frac*1e9)).UTC(), nil
+}
+
 func GetTemporaryS3Cred(accessKeyID, accessKey string) (string, string, string, error) {
 	mySession := session.Must(session.NewSession())
 	svc := sts.New(mySession, aws.NewConfig().WithCredentials(credentials.NewStaticCredentials(accessKeyID, accessKey, "")))
@@ -1,13 +1,66 @@
 package warehouseutils_test
 
 import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
 	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
 	. "github.com/onsi/gomega"
 
 	. "github.com/rudderlabs/rudder-server/warehouse/utils"
 )
 
 var _ = Describe("Utils", func() {
+	DescribeTable("ToWarehouseColumnName", func(provider string, raw string, expected string) {
+		Expect(ToWarehouseColumnName(provider, raw)).To(Equal(expected))
+		//deterministic
+		Expect(ToWarehouseColumnName(provider, raw)).To(Equal(expected))
+	},
+		Entry("snake cases", RS, "userId", "user_id"),
+		Entry("strips illegal characters", BQ, "first name$", "first_name"),
+		Entry("prefixes a leading digit", SNOWFLAKE, "9lives", "_9_lives"),
+		Entry("defaults an empty key", RS, "$$", "stringempty"),
+		Entry("prefixes a snowflake reserved word", SNOWFLAKE, "select", "_select"),
+		Entry("prefixes a bigquery reserved word", BQ, "Select", "_select"),
+		Entry("prefixes a redshift reserved word", RS, "select", "_select"),
+		Entry("doesn't prefix a word not reserved in the warehouse", SNOWFLAKE, "user", "user"),
+		Entry("truncates to the redshift limit", RS, strings.Repeat("a", 400), strings.Repeat("a", 127)),
+		Entry("truncates to the bigquery limit", BQ, strings.Repeat("a", 400), strings.Repeat("a", 300)),
+		Entry("truncates to the snowflake limit", SNOWFLAKE, strings.Repeat("a", 400), strings.Repeat("a", 255)),
+		Entry("truncates after prefixing", RS, "9"+strings.Repeat("a", 400), "_9_"+strings.Repeat("a", 124)),
+		Entry("truncates to the default limit for the other warehouses", CLICKHOUSE, strings.Repeat("a", 400), strings.Repeat("a", 127)),
+	)
+
+	DescribeTable("NormalizeTimestamp", func(value interface{}, expected time.Time) {
+		t, err := NormalizeTimestamp(value)
+		Expect(err).To(BeNil())
+		Expect(t).To(Equal(expected))
+		Expect(t.Location()).To(Equal(time.UTC))
+	},
+		Entry("RFC3339 string", "2022-01-20T11:12:13Z", time.Date(2022, 1, 20, 11, 12, 13, 0, time.UTC)),
+		Entry("RFC3339 string with fractional seconds", "2022-01-20T11:12:13.456Z", time.Date(2022, 1, 20, 11, 12, 13, 456000000, time.UTC)),
+		Entry("RFC3339 string with an offset", "2022-01-20T16:42:13+05:30", time.Date(2022, 1, 20, 11, 12, 13, 0, time.UTC)),
+		Entry("epoch seconds", int64(1642677133), time.Date(2022, 1, 20, 11, 12, 13, 0, time.UTC)),
+		Entry("epoch seconds as a JSON number", float64(1642677133.5), time.Date(2022, 1, 20, 11, 12, 13, 500000000, time.UTC)),
+		Entry("epoch millis", int64(1642677133456), time.Date(2022, 1, 20, 11, 12, 13, 456000000, time.UTC)),
+		Entry("epoch millis as a JSON number", json.Number("1642677133456"), time.Date(2022, 1, 20, 11, 12, 13, 456000000, time.UTC)),
+		Entry("time.Time", time.Date(2022, 1, 20, 16, 42, 13, 0, time.FixedZone("IST", 19800)), time.Date(2022, 1, 20, 11, 12, 13, 0, time.UTC)),
+	)
+
+	DescribeTable("NormalizeTimestamp failures", func(value interface{}) {
+		_, err := NormalizeTimestamp(value)
+		var invalidTimestampErr *InvalidTimestampError
+		Expect(errors.As(err, &invalidTimestampErr)).To(BeTrue())
+		Expect(invalidTimestampErr.Value).To(Equal(value))
+	},
+		Entry("unparseable string", "20th Jan 2022"),
+		Entry("bool", true),
+		Entry("nil", nil),
+	)
+
 	Describe("Locations", func() {
 		Describe("S3", func() {
 			Context("GetS3Location", func() {
@@ -131,6 +184,36 @@ var _ = Describe("Utils", func() {
 		})
 	})
 
+	Describe("PartitionStagingRows", func() {
+		It("should bucket the rows by the table key, keeping their order", func() {
+			rows := [][]byte{
+				[]byte(`{"metadata":{"table":"tracks"},"data":{"id":"1"}}`),
+				[]byte(`{"metadata":{"table":"pages"},"data":{"id":"2"}}`),
+				[]byte(`{"metadata":{"table":"tracks"},"data":{"id":"3"}}`),
+			}
+			partitions, err := PartitionStagingRows(rows, "metadata.table")
+			Expect(err).To(BeNil())
+			Expect(partitions).To(Equal(map[string][][]byte{
+				"tracks": {rows[0], rows[2]},
+				"pages":  {rows[1]},
+			}))
+		})
+
+		It("should return an error for a row missing the table key", func() {
+			rows := [][]byte{
+				[]byte(`{"metadata":{"table":"tracks"},"data":{"id":"1"}}`),
+				[]byte(`{"metadata":{},"data":{"id":"2"}}`),
+			}
+			_, err := PartitionStagingRows(rows, "metadata.table")
+			Expect(err).To(MatchError("staging row 1 is missing metadata.table"))
+		})
+
+		It("should return an error for a row which isn't valid JSON", func() {
+			_, err := PartitionStagingRows([][]byte{[]byte(`{"metadata":`)}, "metadata.table")
+			Expect(err).To(MatchError("staging row 0 is not valid JSON"))
+		})
+	})
+
 	// Describe("Compare Schemas", func() {
 	// 	Context("GetSchemaDiff", func() {
 	// 		var currentSchema map[string]map[string]string